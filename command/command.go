@@ -25,7 +25,11 @@ func Command() {
 	app := kingpin.New("drone", "drone kubernetes runner")
 	registerCompile(app)
 	registerExec(app)
+	registerSchema(app)
+	registerValidate(app)
 	daemon.Register(app)
+	daemon.RegisterDoctor(app)
+	daemon.RegisterReaper(app)
 
 	kingpin.Version(version)
 	kingpin.MustParse(app.Parse(os.Args[1:]))
@@ -35,6 +35,10 @@ type compileCommand struct {
 	Secrets       map[string]string
 	Clone         bool
 	Spec          bool
+	DryRun        bool
+	ServerDryRun  bool
+	Kubeconfig    string
+	Namespace     string
 	Config        string
 	LimitCPU      int64
 	LimitMemory   int64
@@ -91,7 +95,7 @@ func (c *compileCommand) run(*kingpin.ParseContext) error {
 
 	// lint the pipeline and return an error if any
 	// linting rules are broken
-	lint := linter.New(nil)
+	lint := linter.New(nil, linter.Policy{})
 	opts := linter.Opts{Trusted: c.Repo.Trusted}
 	err = lint.Lint(resource, opts)
 	if err != nil {
@@ -102,6 +106,7 @@ func (c *compileCommand) run(*kingpin.ParseContext) error {
 	comp := &compiler.Compiler{
 		Environ:    c.Environ,
 		Labels:     c.Labels,
+		Namespace:  c.Namespace,
 		Privileged: append(c.Privileged, compiler.Privileged...),
 		Secret:     secret.Combine(),
 		Registry:   registry.Combine(),
@@ -134,6 +139,26 @@ func (c *compileCommand) run(*kingpin.ParseContext) error {
 		return nil
 	}
 
+	// the kubernetes manifests are written with the secret
+	// values redacted, and are optionally submitted to the
+	// cluster admission chain as a server-side dry run, so
+	// that admission rejections can be debugged.
+	if c.DryRun || c.ServerDryRun {
+		engine.DumpRedacted(os.Stdout, spec)
+		if !c.ServerDryRun {
+			return nil
+		}
+		kube, err := engine.NewFromConfig(c.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		if err := kube.DryRun(nocontext, spec); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "pod accepted by the cluster admission chain")
+		return nil
+	}
+
 	// encode the pipeline in json format and print to the
 	// console for inspection.
 	enc := json.NewEncoder(os.Stdout)
@@ -173,6 +198,20 @@ func registerCompile(app *kingpin.Application) {
 	cmd.Flag("spec", "output the kubernetes spec").
 		BoolVar(&c.Spec)
 
+	cmd.Flag("dry-run", "output the kubernetes manifests with secrets redacted").
+		BoolVar(&c.DryRun)
+
+	cmd.Flag("server-dry-run", "validate the pod with a server-side dry run").
+		BoolVar(&c.ServerDryRun)
+
+	cmd.Flag("kubeconfig", "kubeconfig file used for the server-side dry run").
+		Envar("KUBECONFIG").
+		StringVar(&c.Kubeconfig)
+
+	cmd.Flag("namespace", "kubernetes namespace").
+		Default("default").
+		StringVar(&c.Namespace)
+
 	cmd.Flag("limit-cpu", "limit container cpu").
 		Int64Var(&c.LimitCPU)
 
@@ -5,12 +5,26 @@
 package daemon
 
 import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine/compiler"
+	"github.com/drone-runners/drone-runner-kube/internal/credentials"
+	"github.com/drone-runners/drone-runner-kube/internal/freeze"
+	"github.com/drone-runners/drone-runner-kube/internal/offline"
+	"github.com/drone-runners/drone-runner-kube/internal/planner"
+	"github.com/drone-runners/drone-runner-kube/internal/provenance"
+	"github.com/drone-runners/drone-runner-kube/internal/timeouts"
 
 	"github.com/buildkite/yaml"
 	"github.com/docker/go-units"
+	ghodss "github.com/ghodss/yaml"
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 )
@@ -28,6 +42,7 @@ type Config struct {
 		SkipVerify bool   `envconfig:"DRONE_RPC_SKIP_VERIFY"`
 		Dump       bool   `envconfig:"DRONE_RPC_DUMP_HTTP"`
 		DumpBody   bool   `envconfig:"DRONE_RPC_DUMP_HTTP_BODY"`
+		Compress   bool   `envconfig:"DRONE_RPC_COMPRESS_LOGS" default:"true"`
 	}
 
 	Dashboard struct {
@@ -37,6 +52,17 @@ type Config struct {
 		Realm    string `envconfig:"DRONE_UI_REALM" default:"MyRealm"`
 	}
 
+	Control struct {
+		Token       string   `envconfig:"DRONE_CONTROL_TOKEN"`
+		MaxCapacity int      `envconfig:"DRONE_CONTROL_MAX_CAPACITY"`
+		Port        string   `envconfig:"DRONE_CONTROL_PORT" default:":3443"`
+		TLSCert     string   `envconfig:"DRONE_CONTROL_TLS_CERT"`
+		TLSKey      string   `envconfig:"DRONE_CONTROL_TLS_KEY"`
+		TLSClientCA string   `envconfig:"DRONE_CONTROL_TLS_CLIENT_CA"`
+		Peers       []string `envconfig:"DRONE_CONTROL_PEERS"`
+		Checkpoint  bool     `envconfig:"DRONE_CONTROL_EXPERIMENTAL_CHECKPOINT"`
+	}
+
 	Server struct {
 		Proto string `envconfig:"DRONE_SERVER_PROTO"`
 		Host  string `envconfig:"DRONE_SERVER_HOST"`
@@ -44,60 +70,300 @@ type Config struct {
 		Acme  bool   `envconfig:"DRONE_SERVER_ACME"`
 	}
 
+	Metrics struct {
+		Port string `envconfig:"DRONE_METRICS_PORT"`
+	}
+
 	Runner struct {
-		Name       string            `envconfig:"DRONE_RUNNER_NAME"`
-		Capacity   int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"100"`
-		Procs      int64             `envconfig:"DRONE_RUNNER_MAX_PROCS"`
-		Environ    map[string]string `envconfig:"DRONE_RUNNER_ENVIRON"`
-		EnvFile    string            `envconfig:"DRONE_RUNNER_ENV_FILE"`
-		Secrets    map[string]string `envconfig:"DRONE_RUNNER_SECRETS"`
-		Labels     map[string]string `envconfig:"DRONE_RUNNER_LABELS"`
-		Privileged []string          `envconfig:"DRONE_RUNNER_PRIVILEGED_IMAGES"`
+		Name        string            `envconfig:"DRONE_RUNNER_NAME"`
+		Capacity    int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"100"`
+		Procs       int64             `envconfig:"DRONE_RUNNER_MAX_PROCS"`
+		Environ     map[string]string `envconfig:"DRONE_RUNNER_ENVIRON"`
+		EnvFile     string            `envconfig:"DRONE_RUNNER_ENV_FILE"`
+		Secrets     map[string]string `envconfig:"DRONE_RUNNER_SECRETS"`
+		Labels      map[string]string `envconfig:"DRONE_RUNNER_LABELS"`
+		Privileged  []string          `envconfig:"DRONE_RUNNER_PRIVILEGED_IMAGES"`
+		Metadata    bool              `envconfig:"DRONE_RUNNER_METADATA" default:"true"`
+		Outputs     bool              `envconfig:"DRONE_RUNNER_OUTPUTS"`
+		ShortSHA    int               `envconfig:"DRONE_RUNNER_SHORT_SHA_LENGTH" default:"8"`
+		ConfigCache int               `envconfig:"DRONE_RUNNER_CONFIG_CACHE_SIZE" default:"100"`
+		Shellless   []string          `envconfig:"DRONE_RUNNER_SHELLLESS_IMAGES"`
+		Platforms   []string          `envconfig:"DRONE_RUNNER_PLATFORMS"`
+		Traces      string            `envconfig:"DRONE_RUNNER_TRACES_PATH"`
+		SBOM        string            `envconfig:"DRONE_RUNNER_SBOM_PATH"`
+		KeepAlive   time.Duration     `envconfig:"DRONE_RUNNER_EXEC_KEEPALIVE"`
+		Watchdog    time.Duration     `envconfig:"DRONE_RUNNER_EXEC_WATCHDOG"`
+		Pending     time.Duration     `envconfig:"DRONE_RUNNER_PENDING_INTERVAL" default:"15s"`
+		NonEvict    bool              `envconfig:"DRONE_RUNNER_NON_EVICTABLE"`
+		MaxExecs    int               `envconfig:"DRONE_RUNNER_MAX_EXECS"`
+		Features    map[string]int    `envconfig:"DRONE_RUNNER_FEATURES"`
+		LogStream   bool              `envconfig:"DRONE_RUNNER_LOG_STREAM"`
+		Exec        map[string]string `envconfig:"DRONE_RUNNER_EXEC_TEMPLATES"`
+		ExecFile    string            `envconfig:"DRONE_RUNNER_EXEC_TEMPLATES_FILE"`
 	}
 
 	Limit struct {
 		Repos   []string `envconfig:"DRONE_LIMIT_REPOS"`
 		Events  []string `envconfig:"DRONE_LIMIT_EVENTS"`
 		Trusted bool     `envconfig:"DRONE_LIMIT_TRUSTED"`
+
+		EventRates map[string]int `envconfig:"DRONE_LIMIT_EVENT_RATES"`
+		EventBurst map[string]int `envconfig:"DRONE_LIMIT_EVENT_BURST"`
 	}
 
 	Resources struct {
-		LimitCPU      int64     `envconfig:"DRONE_RESOURCE_LIMIT_CPU"`
-		LimitMemory   BytesSize `envconfig:"DRONE_RESOURCE_LIMIT_MEMORY"`
-		RequestCPU    int64     `envconfig:"DRONE_RESOURCE_REQUEST_CPU"`
-		RequestMemory BytesSize `envconfig:"DRONE_RESOURCE_REQUEST_MEMORY"`
+		LimitCPU      int64         `envconfig:"DRONE_RESOURCE_LIMIT_CPU"`
+		LimitMemory   BytesSize     `envconfig:"DRONE_RESOURCE_LIMIT_MEMORY"`
+		RequestCPU    int64         `envconfig:"DRONE_RESOURCE_REQUEST_CPU"`
+		RequestMemory BytesSize     `envconfig:"DRONE_RESOURCE_REQUEST_MEMORY"`
+		MaxCPU        int64         `envconfig:"DRONE_RESOURCE_MAX_CPU"`
+		MaxMemory     BytesSize     `envconfig:"DRONE_RESOURCE_MAX_MEMORY"`
+		UsageInterval time.Duration `envconfig:"DRONE_RESOURCE_USAGE_INTERVAL"`
+	}
+
+	Log struct {
+		Buffer BytesSize `envconfig:"DRONE_LOG_BUFFER_SIZE" default:"1MiB"`
+		Limit  BytesSize `envconfig:"DRONE_LOG_LIMIT" default:"5MiB"`
+		Path   string    `envconfig:"DRONE_LOG_BUFFER_PATH"`
+	}
+
+	Spool struct {
+		Path     string        `envconfig:"DRONE_LOG_SPOOL_PATH"`
+		Limit    BytesSize     `envconfig:"DRONE_LOG_SPOOL_LIMIT" default:"100MiB"`
+		Interval time.Duration `envconfig:"DRONE_LOG_SPOOL_INTERVAL" default:"10s"`
+		Wait     time.Duration `envconfig:"DRONE_LOG_SPOOL_WAIT" default:"5m"`
+	}
+
+	TLS struct {
+		MinVersion   string   `envconfig:"DRONE_TLS_MIN_VERSION"`
+		CipherSuites []string `envconfig:"DRONE_TLS_CIPHER_SUITES"`
+		CAFile       string   `envconfig:"DRONE_TLS_CA_FILE"`
+		Pins         []string `envconfig:"DRONE_TLS_PINS"`
+	}
+
+	Flaky struct {
+		Enabled bool   `envconfig:"DRONE_FLAKY_ENABLED"`
+		Path    string `envconfig:"DRONE_FLAKY_PATH"`
+		Max     int    `envconfig:"DRONE_FLAKY_MAX" default:"10000"`
+	}
+
+	Results struct {
+		Enabled bool          `envconfig:"DRONE_RESULT_CACHE_ENABLED"`
+		Path    string        `envconfig:"DRONE_RESULT_CACHE_PATH"`
+		Scope   string        `envconfig:"DRONE_RESULT_CACHE_SCOPE" default:"branch"`
+		Events  []string      `envconfig:"DRONE_RESULT_CACHE_EVENTS" default:"push,pull_request,tag"`
+		TTL     time.Duration `envconfig:"DRONE_RESULT_CACHE_TTL" default:"168h"`
+		Max     int           `envconfig:"DRONE_RESULT_CACHE_MAX" default:"10000"`
+	}
+
+	Cluster struct {
+		Config     string   `envconfig:"DRONE_KUBECONFIG"`
+		Tunnel     string   `envconfig:"DRONE_KUBE_TUNNEL"`
+		TunnelCA   string   `envconfig:"DRONE_KUBE_TUNNEL_CA_FILE"`
+		TunnelCert string   `envconfig:"DRONE_KUBE_TUNNEL_CERT_FILE"`
+		TunnelKey  string   `envconfig:"DRONE_KUBE_TUNNEL_KEY_FILE"`
+		Allowed    []string `envconfig:"DRONE_KUBE_ALLOWED_CLUSTERS"`
 	}
 
 	Secret struct {
 		Endpoint   string `envconfig:"DRONE_SECRET_PLUGIN_ENDPOINT"`
 		Token      string `envconfig:"DRONE_SECRET_PLUGIN_TOKEN"`
 		SkipVerify bool   `envconfig:"DRONE_SECRET_PLUGIN_SKIP_VERIFY"`
+		Scan       string `envconfig:"DRONE_SECRET_SCAN"`
+	}
+
+	Credentials struct {
+		Endpoint   string                `envconfig:"DRONE_CREDENTIALS_ENDPOINT"`
+		Token      string                `envconfig:"DRONE_CREDENTIALS_TOKEN"`
+		SkipVerify bool                  `envconfig:"DRONE_CREDENTIALS_SKIP_VERIFY"`
+		Policies   []*credentials.Policy `ignored:"true"`
+		PolicyFile string                `envconfig:"DRONE_CREDENTIALS_POLICY_FILE"`
+	}
+
+	Timeouts struct {
+		Schedule   time.Duration      `envconfig:"DRONE_TIMEOUT_SCHEDULE"`
+		Pull       time.Duration      `envconfig:"DRONE_TIMEOUT_PULL"`
+		Step       time.Duration      `envconfig:"DRONE_TIMEOUT_STEP"`
+		Stage      time.Duration      `envconfig:"DRONE_TIMEOUT_STAGE_MAX"`
+		Teardown   time.Duration      `envconfig:"DRONE_TIMEOUT_TEARDOWN"`
+		Policies   []*timeouts.Policy `ignored:"true"`
+		PolicyFile string             `envconfig:"DRONE_TIMEOUT_POLICY_FILE"`
+	}
+
+	Planner struct {
+		Configs []*planner.Config `ignored:"true"`
+		File    string            `envconfig:"DRONE_PLANNER_FILE"`
+	}
+
+	Paths struct {
+		Compare bool   `envconfig:"DRONE_PATHS_COMPARE"`
+		Server  string `envconfig:"DRONE_PATHS_COMPARE_SERVER"`
+	}
+
+	Settings struct {
+		Endpoint   string `envconfig:"DRONE_SETTINGS_PLUGIN_ENDPOINT"`
+		Token      string `envconfig:"DRONE_SETTINGS_PLUGIN_TOKEN"`
+		SkipVerify bool   `envconfig:"DRONE_SETTINGS_PLUGIN_SKIP_VERIFY"`
+	}
+
+	Maintenance struct {
+		Paused bool      `envconfig:"DRONE_MAINTENANCE_PAUSED"`
+		Repos  []string  `envconfig:"DRONE_MAINTENANCE_REPOS"`
+		Start  time.Time `envconfig:"DRONE_MAINTENANCE_START"`
+		End    time.Time `envconfig:"DRONE_MAINTENANCE_END"`
+	}
+
+	Falco struct {
+		Token    string `envconfig:"DRONE_FALCO_TOKEN"`
+		Priority string `envconfig:"DRONE_FALCO_PRIORITY" default:"warning"`
+		Fail     bool   `envconfig:"DRONE_FALCO_FAIL"`
+	}
+
+	Freeze struct {
+		Windows []*freeze.Window `ignored:"true"`
+		File    string           `envconfig:"DRONE_FREEZE_FILE"`
+	}
+
+	Library struct {
+		Enabled    bool     `envconfig:"DRONE_STEP_LIBRARY_ENABLED"`
+		Registries []string `envconfig:"DRONE_STEP_LIBRARY_REGISTRIES"`
+		Username   string   `envconfig:"DRONE_STEP_LIBRARY_USERNAME"`
+		Password   string   `envconfig:"DRONE_STEP_LIBRARY_PASSWORD"`
+	}
+
+	Offline struct {
+		Domains []string `envconfig:"DRONE_OFFLINE_DOMAINS"`
+	}
+
+	KVM struct {
+		Repos    []string `envconfig:"DRONE_KVM_REPOS"`
+		Resource string   `envconfig:"DRONE_KVM_RESOURCE" default:"devices.kubevirt.io/kvm"`
+	}
+
+	Node struct {
+		Repos     []string `envconfig:"DRONE_NODE_REPOS"`
+		Namespace string   `envconfig:"DRONE_NODE_HELPER_NAMESPACE" default:"kube-system"`
+		Selector  string   `envconfig:"DRONE_NODE_HELPER_SELECTOR"`
+		Container string   `envconfig:"DRONE_NODE_HELPER_CONTAINER"`
+	}
+
+	Provenance struct {
+		Builder    string            `envconfig:"DRONE_PROVENANCE_BUILDER_ID"`
+		Key        *ecdsa.PrivateKey `ignored:"true"`
+		KeyFile    string            `envconfig:"DRONE_PROVENANCE_KEY_FILE"`
+		Store      string            `envconfig:"DRONE_PROVENANCE_STORE"`
+		Token      string            `envconfig:"DRONE_PROVENANCE_STORE_TOKEN"`
+		SkipVerify bool              `envconfig:"DRONE_PROVENANCE_STORE_SKIP_VERIFY"`
+		Records    bool              `envconfig:"DRONE_PROVENANCE_RECORDS"`
+	}
+
+	Encryption struct {
+		Key     []byte `ignored:"true"`
+		KeyFile string `envconfig:"DRONE_SECRET_ENCRYPTION_KEY_FILE"`
+	}
+
+	Vault struct {
+		Address   string   `envconfig:"DRONE_VAULT_ADDRESS"`
+		Token     string   `envconfig:"DRONE_VAULT_TOKEN"`
+		Namespace string   `envconfig:"DRONE_VAULT_NAMESPACE"`
+		Role      string   `envconfig:"DRONE_VAULT_AUTH_ROLE"`
+		Mount     string   `envconfig:"DRONE_VAULT_AUTH_MOUNT" default:"kubernetes"`
+		Paths     []string `envconfig:"DRONE_VAULT_PATHS"`
 	}
 
 	Registry struct {
-		Endpoint   string `envconfig:"DRONE_REGISTRY_PLUGIN_ENDPOINT"`
-		Token      string `envconfig:"DRONE_REGISTRY_PLUGIN_SECRET"`
-		SkipVerify bool   `envconfig:"DRONE_REGISTRY_PLUGIN_SKIP_VERIFY"`
+		Endpoint       string   `envconfig:"DRONE_REGISTRY_PLUGIN_ENDPOINT"`
+		Token          string   `envconfig:"DRONE_REGISTRY_PLUGIN_SECRET"`
+		SkipVerify     bool     `envconfig:"DRONE_REGISTRY_PLUGIN_SKIP_VERIFY"`
+		ECRRegion      string   `envconfig:"DRONE_REGISTRY_ECR_REGION"`
+		ECRRegistryIDs []string `envconfig:"DRONE_REGISTRY_ECR_REGISTRY_IDS"`
+		GCRHosts       []string `envconfig:"DRONE_REGISTRY_GCR_HOSTS"`
+		ACRHosts       []string `envconfig:"DRONE_REGISTRY_ACR_HOSTS"`
+		ACRClientID    string   `envconfig:"DRONE_REGISTRY_ACR_CLIENT_ID"`
+	}
+
+	Robot struct {
+		Images         []string `envconfig:"DRONE_ROBOT_IMAGES"`
+		HarborAddress  string   `envconfig:"DRONE_ROBOT_HARBOR_ADDRESS"`
+		HarborUsername string   `envconfig:"DRONE_ROBOT_HARBOR_USERNAME"`
+		HarborPassword string   `envconfig:"DRONE_ROBOT_HARBOR_PASSWORD"`
+		HarborDuration int      `envconfig:"DRONE_ROBOT_HARBOR_DURATION" default:"1"`
+		ECRRegion      string   `envconfig:"DRONE_ROBOT_ECR_REGION"`
+		ECRRoleARN     string   `envconfig:"DRONE_ROBOT_ECR_ROLE_ARN"`
 	}
 
 	Docker struct {
-		Config string `envconfig:"DRONE_DOCKER_CONFIG"`
+		Config   string `envconfig:"DRONE_DOCKER_CONFIG"`
+		Compat   bool   `envconfig:"DRONE_DOCKER_COMPAT"`
+		Capacity int    `envconfig:"DRONE_DOCKER_COMPAT_CAPACITY" default:"10"`
 	}
 
 	Images struct {
-		Clone string `envconfig:"DRONE_IMAGE_CLONE"`
+		Clone       string   `envconfig:"DRONE_IMAGE_CLONE"`
+		CloneOpts   bool     `envconfig:"DRONE_IMAGE_CLONE_OPTIONS"`
+		Shell       string   `envconfig:"DRONE_IMAGE_SHELL"`
+		Kaniko      string   `envconfig:"DRONE_IMAGE_KANIKO"`
+		Buildah     string   `envconfig:"DRONE_IMAGE_BUILDAH"`
+		Helm        string   `envconfig:"DRONE_IMAGE_HELM"`
+		Kubectl     string   `envconfig:"DRONE_IMAGE_KUBECTL"`
+		PullSecrets []string `envconfig:"DRONE_IMAGE_PULL_SECRETS"`
+	}
+
+	Inspect struct {
+		Enabled   bool          `envconfig:"DRONE_IMAGE_INSPECT"`
+		Username  string        `envconfig:"DRONE_IMAGE_INSPECT_USERNAME"`
+		Password  string        `envconfig:"DRONE_IMAGE_INSPECT_PASSWORD"`
+		CacheTTL  time.Duration `envconfig:"DRONE_IMAGE_INSPECT_CACHE_TTL" default:"1h"`
+		CacheFile string        `envconfig:"DRONE_IMAGE_INSPECT_CACHE_FILE"`
 	}
 
 	ServiceAccount struct {
-		Default string `envconfig:"DRONE_SERVICE_ACCOUNT_DEFAULT"`
+		Default   string `envconfig:"DRONE_SERVICE_ACCOUNT_DEFAULT"`
+		Automount bool   `envconfig:"DRONE_SERVICE_ACCOUNT_AUTOMOUNT"`
 	}
 
 	Annotations struct {
 		Default map[string]string `envconfig:"DRONE_ANNOTATIONS_DEFAULT"`
 	}
 
+	Template struct {
+		Pod     []byte `ignored:"true"`
+		PodFile string `envconfig:"DRONE_POD_TEMPLATE_FILE"`
+	}
+
+	Sidecars struct {
+		List []*Sidecar `ignored:"true"`
+		File string     `envconfig:"DRONE_SIDECARS_FILE"`
+	}
+
+	Profiles struct {
+		List []*RunnerProfile `ignored:"true"`
+		File string           `envconfig:"DRONE_RUNNER_PROFILES_FILE"`
+	}
+
+	ResourceProfiles struct {
+		List map[string]*ResourceProfile `ignored:"true"`
+		File string                      `envconfig:"DRONE_RESOURCE_PROFILES_FILE"`
+	}
+
+	Forks struct {
+		Secure        bool   `envconfig:"DRONE_FORKS_SECURE"`
+		ApprovalLabel string `envconfig:"DRONE_FORKS_APPROVAL_LABEL"`
+	}
+
+	EnvFilters struct {
+		List []*EnvFilter `ignored:"true"`
+		File string       `envconfig:"DRONE_ENV_FILTERS_FILE"`
+	}
+
 	Labels struct {
 		Default map[string]string `envconfig:"DRONE_LABELS_DEFAULT"`
+		Prefix  string            `envconfig:"DRONE_LABELS_PREFIX"`
+	}
+
+	Network struct {
+		IPFamily      string `envconfig:"DRONE_IP_FAMILY" default:"ipv4"`
+		BlockMetadata string `envconfig:"DRONE_NETWORK_BLOCK_METADATA"`
 	}
 
 	DNS struct {
@@ -105,11 +371,244 @@ type Config struct {
 		DNSConfig map[string][]string `envconfig:"DRONE_DNS_CONFIG"`
 	}
 
+	Workspace struct {
+		Backend       string    `envconfig:"DRONE_WORKSPACE_BACKEND"`
+		Claim         bool      `envconfig:"DRONE_WORKSPACE_PVC"`
+		StorageClass  string    `envconfig:"DRONE_WORKSPACE_PVC_STORAGE_CLASS"`
+		AccessMode    string    `envconfig:"DRONE_WORKSPACE_PVC_ACCESS_MODE" default:"ReadWriteOnce"`
+		Size          BytesSize `envconfig:"DRONE_WORKSPACE_PVC_SIZE" default:"10GiB"`
+		Snapshot      bool      `envconfig:"DRONE_WORKSPACE_PVC_SNAPSHOT"`
+		SnapshotClass string    `envconfig:"DRONE_WORKSPACE_PVC_SNAPSHOT_CLASS"`
+	}
+
+	BuildCache struct {
+		Backend      string    `envconfig:"DRONE_CACHE_BACKEND"`
+		StorageClass string    `envconfig:"DRONE_CACHE_PVC_STORAGE_CLASS"`
+		AccessMode   string    `envconfig:"DRONE_CACHE_PVC_ACCESS_MODE" default:"ReadWriteOnce"`
+		Size         BytesSize `envconfig:"DRONE_CACHE_PVC_SIZE" default:"10GiB"`
+		Image        string    `envconfig:"DRONE_CACHE_S3_IMAGE"`
+		Bucket       string    `envconfig:"DRONE_CACHE_S3_BUCKET"`
+		Endpoint     string    `envconfig:"DRONE_CACHE_S3_ENDPOINT"`
+		Region       string    `envconfig:"DRONE_CACHE_S3_REGION"`
+		PathStyle    bool      `envconfig:"DRONE_CACHE_S3_PATH_STYLE"`
+		AccessKey    string    `envconfig:"DRONE_CACHE_S3_ACCESS_KEY"`
+		SecretKey    string    `envconfig:"DRONE_CACHE_S3_SECRET_KEY"`
+	}
+
+	RepoCache struct {
+		Enabled      bool              `envconfig:"DRONE_REPO_CACHE_ENABLED"`
+		StorageClass string            `envconfig:"DRONE_REPO_CACHE_STORAGE_CLASS"`
+		AccessMode   string            `envconfig:"DRONE_REPO_CACHE_ACCESS_MODE" default:"ReadWriteOnce"`
+		Size         BytesSize         `envconfig:"DRONE_REPO_CACHE_SIZE" default:"20GiB"`
+		Limit        BytesSize         `envconfig:"DRONE_REPO_CACHE_LIMIT" default:"200GiB"`
+		Interval     time.Duration     `envconfig:"DRONE_REPO_CACHE_INTERVAL" default:"10m"`
+		Tools        map[string]string `envconfig:"DRONE_REPO_CACHE_TOOLS" default:"GRADLE_USER_HOME:gradle,SCCACHE_DIR:sccache,DRONE_BUILDKIT_CACHE:buildkit"`
+	}
+
+	Artifacts struct {
+		Image string    `envconfig:"DRONE_ARTIFACTS_IMAGE"`
+		Limit BytesSize `envconfig:"DRONE_ARTIFACTS_LIMIT" default:"1GiB"`
+	}
+
+	Mirrors struct {
+		NPM     string `envconfig:"DRONE_MIRROR_NPM"`
+		PyPI    string `envconfig:"DRONE_MIRROR_PYPI"`
+		GoProxy string `envconfig:"DRONE_MIRROR_GOPROXY"`
+		Apt     string `envconfig:"DRONE_MIRROR_APT"`
+		Resolve bool   `envconfig:"DRONE_MIRROR_RESOLVE" default:"true"`
+	}
+
+	Antivirus struct {
+		Image    string `envconfig:"DRONE_ANTIVIRUS_IMAGE" default:"clamav/clamav:stable"`
+		Endpoint string `envconfig:"DRONE_ANTIVIRUS_ENDPOINT"`
+		Clone    bool   `envconfig:"DRONE_ANTIVIRUS_SCAN_CLONE" default:"true"`
+		Build    bool   `envconfig:"DRONE_ANTIVIRUS_SCAN_BUILD"`
+		Flag     bool   `envconfig:"DRONE_ANTIVIRUS_FLAG"`
+	}
+
+	Locale struct {
+		Timezone  string `envconfig:"DRONE_TIMEZONE"`
+		Lang      string `envconfig:"DRONE_LOCALE"`
+		Localtime bool   `envconfig:"DRONE_TIMEZONE_MOUNT"`
+	}
+
+	Topology struct {
+		ZoneLabel   string `envconfig:"DRONE_TOPOLOGY_ZONE_LABEL" default:"topology.kubernetes.io/zone"`
+		RegionLabel string `envconfig:"DRONE_TOPOLOGY_REGION_LABEL" default:"topology.kubernetes.io/region"`
+	}
+
+	Recovery struct {
+		Enabled bool `envconfig:"DRONE_RECOVERY_ENABLED"`
+	}
+
+	ProxyCache struct {
+		Node      bool              `envconfig:"DRONE_PROXY_CACHE_NODE"`
+		GoImage   string            `envconfig:"DRONE_PROXY_CACHE_GO_IMAGE"`
+		GoPort    int               `envconfig:"DRONE_PROXY_CACHE_GO_PORT" default:"3000"`
+		GoEnv     map[string]string `envconfig:"DRONE_PROXY_CACHE_GO_ENV"`
+		PyPIImage string            `envconfig:"DRONE_PROXY_CACHE_PYPI_IMAGE"`
+		PyPIPort  int               `envconfig:"DRONE_PROXY_CACHE_PYPI_PORT" default:"3141"`
+		PyPIPath  string            `envconfig:"DRONE_PROXY_CACHE_PYPI_PATH" default:"/root/pypi/+simple/"`
+		PyPIEnv   map[string]string `envconfig:"DRONE_PROXY_CACHE_PYPI_ENV"`
+		NPMImage  string            `envconfig:"DRONE_PROXY_CACHE_NPM_IMAGE"`
+		NPMPort   int               `envconfig:"DRONE_PROXY_CACHE_NPM_PORT" default:"4873"`
+		NPMEnv    map[string]string `envconfig:"DRONE_PROXY_CACHE_NPM_ENV"`
+	}
+
+	Preflight struct {
+		Enabled bool          `envconfig:"DRONE_PREFLIGHT_CAPACITY"`
+		Backoff time.Duration `envconfig:"DRONE_PREFLIGHT_BACKOFF" default:"30s"`
+	}
+
+	Security struct {
+		User                  string   `envconfig:"DRONE_SECURITY_CONTEXT_USER"`
+		NonRoot               bool     `envconfig:"DRONE_SECURITY_CONTEXT_NON_ROOT"`
+		FSGroup               int64    `envconfig:"DRONE_SECURITY_CONTEXT_FS_GROUP"`
+		Seccomp               string   `envconfig:"DRONE_SECURITY_CONTEXT_SECCOMP_PROFILE"`
+		DropCapabilities      []string `envconfig:"DRONE_SECURITY_CONTEXT_DROP_CAPABILITIES"`
+		NoPrivilegeEscalation bool     `envconfig:"DRONE_SECURITY_CONTEXT_NO_PRIVILEGE_ESCALATION"`
+		MinUser               int64    `envconfig:"DRONE_SECURITY_POLICY_MIN_USER"`
+		MaxUser               int64    `envconfig:"DRONE_SECURITY_POLICY_MAX_USER"`
+		SeccompProfiles       []string `envconfig:"DRONE_SECURITY_POLICY_SECCOMP_PROFILES"`
+	}
+
+	Volumes struct {
+		NFS []string `envconfig:"DRONE_VOLUME_NFS_SERVERS"`
+		CSI []string `envconfig:"DRONE_VOLUME_CSI_DRIVERS"`
+	}
+
+	Placement struct {
+		NodeSelectors  []string `envconfig:"DRONE_PLACEMENT_NODE_SELECTORS"`
+		Tolerations    []string `envconfig:"DRONE_PLACEMENT_TOLERATIONS"`
+		RuntimeClasses []string `envconfig:"DRONE_PLACEMENT_RUNTIME_CLASSES"`
+	}
+
+	Update struct {
+		Enabled      bool          `envconfig:"DRONE_UPDATE_ENABLED"`
+		Namespace    string        `envconfig:"DRONE_UPDATE_NAMESPACE"`
+		ConfigMap    string        `envconfig:"DRONE_UPDATE_CONFIGMAP" default:"drone-runner-version"`
+		Deployment   string        `envconfig:"DRONE_UPDATE_DEPLOYMENT"`
+		Container    string        `envconfig:"DRONE_UPDATE_CONTAINER"`
+		Interval     time.Duration `envconfig:"DRONE_UPDATE_INTERVAL" default:"1m"`
+		DrainTimeout time.Duration `envconfig:"DRONE_UPDATE_DRAIN_TIMEOUT" default:"1h"`
+	}
+
+	Cleanup struct {
+		Finalizer   bool    `envconfig:"DRONE_CLEANUP_FINALIZER"`
+		Namespace   string  `envconfig:"DRONE_CLEANUP_NAMESPACE"`
+		DeleteQPS   float32 `envconfig:"DRONE_CLEANUP_DELETE_QPS" default:"10"`
+		DeleteBurst int     `envconfig:"DRONE_CLEANUP_DELETE_BURST" default:"20"`
+	}
+
+	GC struct {
+		Enabled    bool          `envconfig:"DRONE_GC_ENABLED"`
+		Namespaces []string      `envconfig:"DRONE_GC_NAMESPACES"`
+		TTL        time.Duration `envconfig:"DRONE_GC_TTL" default:"24h"`
+		Interval   time.Duration `envconfig:"DRONE_GC_INTERVAL" default:"5m"`
+	}
+
+	Heartbeat struct {
+		Enabled        bool          `envconfig:"DRONE_HEARTBEAT_ENABLED"`
+		Interval       time.Duration `envconfig:"DRONE_HEARTBEAT_INTERVAL" default:"30s"`
+		Timeout        time.Duration `envconfig:"DRONE_HEARTBEAT_TIMEOUT" default:"10m"`
+		Reaper         bool          `envconfig:"DRONE_HEARTBEAT_REAPER"`
+		ReaperInterval time.Duration `envconfig:"DRONE_HEARTBEAT_REAPER_INTERVAL" default:"1m"`
+	}
+
+	Pulls struct {
+		Enabled   bool          `envconfig:"DRONE_PULL_THROTTLE_ENABLED"`
+		NodeLimit int           `envconfig:"DRONE_PULL_THROTTLE_NODE_LIMIT" default:"1"`
+		Cooldown  time.Duration `envconfig:"DRONE_PULL_THROTTLE_COOLDOWN" default:"1m"`
+	}
+
+	PullCredentials struct {
+		List []*PullCredential `ignored:"true"`
+		File string            `envconfig:"DRONE_PULL_CREDENTIALS_FILE"`
+	}
+
+	OffPeak struct {
+		Window    string        `envconfig:"DRONE_OFF_PEAK_WINDOW"`
+		Timezone  string        `envconfig:"DRONE_OFF_PEAK_TIMEZONE"`
+		Threshold float64       `envconfig:"DRONE_OFF_PEAK_LOAD_THRESHOLD"`
+		MaxDelay  time.Duration `envconfig:"DRONE_OFF_PEAK_MAX_DELAY" default:"6h"`
+		Interval  time.Duration `envconfig:"DRONE_OFF_PEAK_INTERVAL" default:"1m"`
+	}
+
+	Chaos struct {
+		Enabled          bool          `envconfig:"DRONE_CHAOS_ENABLED"`
+		APIErrors        float64       `envconfig:"DRONE_CHAOS_API_ERROR_RATE"`
+		WatchDisconnects float64       `envconfig:"DRONE_CHAOS_WATCH_DISCONNECT_RATE"`
+		SlowPulls        float64       `envconfig:"DRONE_CHAOS_SLOW_PULL_RATE"`
+		Evictions        float64       `envconfig:"DRONE_CHAOS_EVICTION_RATE"`
+		MaxDelay         time.Duration `envconfig:"DRONE_CHAOS_MAX_DELAY" default:"30s"`
+		Seed             int64         `envconfig:"DRONE_CHAOS_SEED"`
+	}
+
+	WarmPool struct {
+		Images    []string      `envconfig:"DRONE_WARM_POOL_IMAGES"`
+		Replicas  int           `envconfig:"DRONE_WARM_POOL_REPLICAS" default:"1"`
+		Namespace string        `envconfig:"DRONE_WARM_POOL_NAMESPACE"`
+		Interval  time.Duration `envconfig:"DRONE_WARM_POOL_INTERVAL" default:"30s"`
+	}
+
+	Janitor struct {
+		Enabled    bool     `envconfig:"DRONE_JANITOR_ENABLED"`
+		Namespaces []string `envconfig:"DRONE_JANITOR_NAMESPACES"`
+		Resources  []string `envconfig:"DRONE_JANITOR_RESOURCES"`
+	}
+
+	Admission struct {
+		DryRun bool `envconfig:"DRONE_ADMISSION_DRY_RUN"`
+	}
+
+	Lifecycle struct {
+		Events        bool   `envconfig:"DRONE_LIFECYCLE_EVENTS"`
+		Endpoint      string `envconfig:"DRONE_LIFECYCLE_WEBHOOK_ENDPOINT"`
+		Token         string `envconfig:"DRONE_LIFECYCLE_WEBHOOK_TOKEN"`
+		SkipVerify    bool   `envconfig:"DRONE_LIFECYCLE_WEBHOOK_SKIP_VERIFY"`
+		StageEndpoint string `envconfig:"DRONE_LIFECYCLE_STAGE_WEBHOOK_ENDPOINT"`
+		StageToken    string `envconfig:"DRONE_LIFECYCLE_STAGE_WEBHOOK_TOKEN"`
+		StageFormat   string `envconfig:"DRONE_LIFECYCLE_STAGE_WEBHOOK_FORMAT" default:"json"`
+	}
+
+	Retention struct {
+		OnFailure time.Duration `envconfig:"DRONE_POD_RETENTION_ON_FAILURE"`
+		Max       int           `envconfig:"DRONE_POD_RETENTION_MAX" default:"10"`
+		Interval  time.Duration `envconfig:"DRONE_POD_RETENTION_INTERVAL" default:"1m"`
+	}
+
+	Isolation struct {
+		Enabled        bool          `envconfig:"DRONE_ISOLATION_ENABLED"`
+		Quota          []byte        `ignored:"true"`
+		QuotaFile      string        `envconfig:"DRONE_ISOLATION_QUOTA_FILE"`
+		LimitRange     []byte        `ignored:"true"`
+		LimitRangeFile string        `envconfig:"DRONE_ISOLATION_LIMIT_RANGE_FILE"`
+		Role           []byte        `ignored:"true"`
+		RoleFile       string        `envconfig:"DRONE_ISOLATION_ROLE_FILE"`
+		TTL            time.Duration `envconfig:"DRONE_ISOLATION_TTL" default:"24h"`
+		Interval       time.Duration `envconfig:"DRONE_ISOLATION_REAP_INTERVAL" default:"10m"`
+	}
+
+	Retry struct {
+		Attempts int           `envconfig:"DRONE_RETRY_ATTEMPTS" default:"5"`
+		Delay    time.Duration `envconfig:"DRONE_RETRY_DELAY" default:"500ms"`
+		MaxDelay time.Duration `envconfig:"DRONE_RETRY_MAX_DELAY" default:"10s"`
+	}
+
+	Deadlines struct {
+		Request time.Duration `envconfig:"DRONE_API_REQUEST_TIMEOUT" default:"1m"`
+		Watch   time.Duration `envconfig:"DRONE_API_WATCH_TIMEOUT" default:"5m"`
+	}
+
 	Namespace struct {
-		Rules     map[string][]string `envconfig:"-"`
-		RulesMap  map[string]string   `envconfig:"DRONE_NAMESPACE_RULES"`
-		RulesFile string              `envconfig:"DRONE_NAMESPACE_RULES_FILE"`
-		Default   string              `envconfig:"DRONE_NAMESPACE_DEFAULT" default:"default"`
+		Rules      map[string][]string `envconfig:"-"`
+		RulesMap   map[string]string   `envconfig:"DRONE_NAMESPACE_RULES"`
+		RulesFile  string              `envconfig:"DRONE_NAMESPACE_RULES_FILE"`
+		Default    string              `envconfig:"DRONE_NAMESPACE_DEFAULT" default:"default"`
+		Pool       []string            `envconfig:"DRONE_NAMESPACE_POOL"`
+		MaxPods    int                 `envconfig:"DRONE_NAMESPACE_MAX_PODS"`
+		MaxSecrets int                 `envconfig:"DRONE_NAMESPACE_MAX_SECRETS"`
+		Locks      string              `envconfig:"DRONE_NAMESPACE_LOCKS"`
 	}
 }
 
@@ -150,6 +649,307 @@ func fromEnviron() (Config, error) {
 		config.Namespace.Rules[k] = []string{v}
 	}
 
+	// exec templates can be sourced from a separate file,
+	// since templates often include commas, which cannot be
+	// used in the environment variable. Templates defined in
+	// the environment take precedence.
+	if file := config.Runner.ExecFile; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		templates := map[string]string{}
+		err = yaml.Unmarshal(out, &templates)
+		if err != nil {
+			return config, err
+		}
+		for k, v := range config.Runner.Exec {
+			templates[k] = v
+		}
+		config.Runner.Exec = templates
+	}
+
+	// the pod template is sourced from a yaml file and is
+	// converted to json, the format expected by the compiler.
+	if file := config.Template.PodFile; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		config.Template.Pod, err = ghodss.YAMLToJSON(out)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	// the templates of the objects created in the namespace
+	// of isolated pipelines are sourced from yaml files and
+	// are converted to json, the format expected by the engine.
+	templates := []struct {
+		file string
+		dst  *[]byte
+	}{
+		{config.Isolation.QuotaFile, &config.Isolation.Quota},
+		{config.Isolation.LimitRangeFile, &config.Isolation.LimitRange},
+		{config.Isolation.RoleFile, &config.Isolation.Role},
+	}
+	for _, t := range templates {
+		if t.file == "" {
+			continue
+		}
+		out, err := ioutil.ReadFile(t.file)
+		if err != nil {
+			return config, err
+		}
+		*t.dst, err = ghodss.YAMLToJSON(out)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	// the policies that map repositories and deployment
+	// environments to credentials broker policies are sourced
+	// from a separate yaml file.
+	if file := config.Credentials.PolicyFile; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.Credentials.Policies)
+		if err != nil {
+			return config, err
+		}
+		for _, policy := range config.Credentials.Policies {
+			if policy.Name == "" {
+				return config, errors.New("credentials policy name is required")
+			}
+		}
+	}
+
+	// the key used to encrypt the pipeline secrets is sourced
+	// from a separate file containing the base64-encoded key.
+	if file := config.Encryption.KeyFile; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		config.Encryption.Key, err = base64.StdEncoding.DecodeString(
+			strings.TrimSpace(string(out)),
+		)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	// the key used to sign the pipeline provenance is sourced
+	// from a separate file containing the pem-encoded key. The
+	// builder identity defaults to the runner name.
+	if file := config.Provenance.KeyFile; file != "" {
+		config.Provenance.Key, err = provenance.LoadKey(file)
+		if err != nil {
+			return config, err
+		}
+		if config.Provenance.Builder == "" {
+			config.Provenance.Builder = config.Runner.Name
+		}
+	}
+
+	// sidecars injected into every pipeline pod are sourced
+	// from a separate yaml file.
+	if file := config.Sidecars.File; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.Sidecars.List)
+		if err != nil {
+			return config, err
+		}
+		for _, sidecar := range config.Sidecars.List {
+			if sidecar.Name == "" || sidecar.Image == "" {
+				return config, errors.New("sidecar name and image are required")
+			}
+		}
+	}
+
+	// the registry credential pool is sourced from a separate
+	// yaml file.
+	if file := config.PullCredentials.File; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.PullCredentials.List)
+		if err != nil {
+			return config, err
+		}
+		for _, cred := range config.PullCredentials.List {
+			if cred.Name == "" || cred.Registry == "" {
+				return config, errors.New("pull credential name and registry are required")
+			}
+		}
+	}
+
+	// the deployment freeze windows are sourced from a
+	// separate yaml file.
+	if file := config.Freeze.File; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.Freeze.Windows)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	// the policies that override the default timeouts of the
+	// matching repositories are sourced from a separate yaml
+	// file.
+	if file := config.Timeouts.PolicyFile; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.Timeouts.Policies)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	// the path ownership rules of monorepo builds are sourced
+	// from a separate yaml file.
+	if file := config.Planner.File; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.Planner.Configs)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	// the runner profiles are sourced from a separate yaml
+	// file. Each profile polls for pipelines with the profile
+	// labels, which must be unique.
+	if file := config.Profiles.File; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.Profiles.List)
+		if err != nil {
+			return config, err
+		}
+		for _, profile := range config.Profiles.List {
+			if profile.Name == "" || len(profile.Labels) == 0 {
+				return config, errors.New("runner profile name and labels are required")
+			}
+			if profile.Capacity == 0 {
+				profile.Capacity = config.Runner.Capacity
+			}
+		}
+	}
+
+	// the named resource profiles referenced by pipelines
+	// and steps are sourced from a separate yaml file.
+	if file := config.ResourceProfiles.File; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.ResourceProfiles.List)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	// the environment filters applied to the steps of
+	// untrusted builds are sourced from a separate yaml file.
+	if file := config.EnvFilters.File; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.EnvFilters.List)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	switch config.Network.IPFamily {
+	case "ipv4", "ipv6", "dual":
+	default:
+		return config, fmt.Errorf("unsupported ip family: %s", config.Network.IPFamily)
+	}
+
+	for name, percent := range config.Runner.Features {
+		if percent < 0 || percent > 100 {
+			return config, fmt.Errorf("feature %s percentage must be between 0 and 100", name)
+		}
+	}
+
+	if config.Control.MaxCapacity < config.Runner.Capacity {
+		config.Control.MaxCapacity = config.Runner.Capacity
+	}
+
+	if len(config.Node.Repos) != 0 && config.Node.Selector == "" {
+		return config, errors.New("node helper selector is required to run node steps")
+	}
+
+	if endpoint := config.Antivirus.Endpoint; endpoint != "" {
+		if !strings.HasPrefix(endpoint, "clamd://") && !strings.HasPrefix(endpoint, "icap://") {
+			return config, fmt.Errorf("unsupported antivirus endpoint: %s", endpoint)
+		}
+	}
+
+	switch config.Network.BlockMetadata {
+	case "", "all", "untrusted":
+	default:
+		return config, fmt.Errorf("unsupported metadata blocking mode: %s", config.Network.BlockMetadata)
+	}
+
+	if config.Heartbeat.Enabled && config.Heartbeat.Timeout <= config.Heartbeat.Interval {
+		return config, errors.New("heartbeat timeout must be greater than the heartbeat interval")
+	}
+
+	for name, rate := range map[string]float64{
+		"api error":        config.Chaos.APIErrors,
+		"watch disconnect": config.Chaos.WatchDisconnects,
+		"slow pull":        config.Chaos.SlowPulls,
+		"eviction":         config.Chaos.Evictions,
+	} {
+		if rate < 0 || rate > 1 {
+			return config, fmt.Errorf("chaos %s rate must be between 0 and 1", name)
+		}
+	}
+
+	if backend := config.Workspace.Backend; backend != "" {
+		var registered bool
+		for _, name := range compiler.WorkspaceBackends() {
+			registered = registered || name == backend
+		}
+		if !registered {
+			return config, fmt.Errorf("unsupported workspace backend: %s", backend)
+		}
+		if backend == compiler.BackendObjectStore && config.BuildCache.Bucket == "" {
+			return config, errors.New("the object store is required by the objectstore workspace backend")
+		}
+	}
+
+	switch config.Results.Scope {
+	case "repo", "branch", "ref":
+	default:
+		return config, fmt.Errorf("unsupported result cache scope: %s", config.Results.Scope)
+	}
+
+	if config.Update.Enabled {
+		if config.Update.Namespace == "" || config.Update.Deployment == "" {
+			return config, errors.New("update namespace and deployment are required")
+		}
+	}
+
 	// environment variables can be sourced from a separate
 	// file. These variables are loaded and appended to the
 	// environment list.
@@ -163,9 +963,150 @@ func fromEnviron() (Config, error) {
 		}
 	}
 
+	// in offline mode, the images and plugin endpoints
+	// configured by the operator must be hosted by internal
+	// domains.
+	if err := checkOffline(config); err != nil {
+		return config, err
+	}
+
 	return config, nil
 }
 
+// helper function returns an error if a configured image or
+// plugin endpoint is not hosted by an internal domain. The
+// default clone and shell images are hosted by docker.io, and
+// must be configured in offline mode.
+func checkOffline(config Config) error {
+	domains := config.Offline.Domains
+	if len(domains) == 0 {
+		return nil
+	}
+	if config.Images.Clone == "" {
+		return errors.New("offline: DRONE_IMAGE_CLONE is required")
+	}
+	if config.Images.Shell == "" && len(config.Runner.Shellless) != 0 {
+		return errors.New("offline: DRONE_IMAGE_SHELL is required")
+	}
+	images := []string{config.Images.Clone}
+	for _, image := range []string{config.Images.Shell, config.Images.Kaniko, config.Images.Buildah, config.Images.Helm, config.Images.Kubectl} {
+		if image != "" {
+			images = append(images, image)
+		}
+	}
+	for _, sidecar := range config.Sidecars.List {
+		images = append(images, sidecar.Image)
+	}
+	if !config.ProxyCache.Node {
+		for _, image := range []string{config.ProxyCache.GoImage, config.ProxyCache.PyPIImage, config.ProxyCache.NPMImage} {
+			if image != "" {
+				images = append(images, image)
+			}
+		}
+	}
+	if config.Antivirus.Endpoint != "" {
+		images = append(images, config.Antivirus.Image)
+	}
+	if config.BuildCache.Backend == "s3" {
+		if config.BuildCache.Image == "" {
+			return errors.New("offline: DRONE_CACHE_S3_IMAGE is required")
+		}
+		images = append(images, config.BuildCache.Image)
+	}
+	for _, image := range images {
+		if !offline.MatchImage(domains, image) {
+			return fmt.Errorf("offline: image not hosted by an internal domain: %s", image)
+		}
+	}
+	endpoints := []string{
+		config.Secret.Endpoint,
+		config.Registry.Endpoint,
+		config.Settings.Endpoint,
+		config.Credentials.Endpoint,
+	}
+	if strings.Contains(config.Provenance.Store, "://") {
+		endpoints = append(endpoints, config.Provenance.Store)
+	}
+	for _, endpoint := range endpoints {
+		if endpoint != "" && !offline.MatchURL(domains, endpoint) {
+			return fmt.Errorf("offline: endpoint not hosted by an internal domain: %s", endpoint)
+		}
+	}
+	if config.Library.Enabled && len(config.Library.Registries) == 0 {
+		return errors.New("offline: DRONE_STEP_LIBRARY_REGISTRIES is required")
+	}
+	for _, registry := range config.Library.Registries {
+		if !offline.MatchHost(domains, registry) {
+			return fmt.Errorf("offline: registry not hosted by an internal domain: %s", registry)
+		}
+	}
+	return nil
+}
+
+// PullCredential defines a registry credential of the pool
+// that pipeline pods rotate among when the registry rate
+// limits image pulls.
+type PullCredential struct {
+	Name     string `yaml:"name"`
+	Registry string `yaml:"registry"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Sidecar defines a container that is injected into every
+// pipeline pod.
+type Sidecar struct {
+	Name        string            `yaml:"name"`
+	Image       string            `yaml:"image"`
+	Entrypoint  []string          `yaml:"entrypoint"`
+	Command     []string          `yaml:"command"`
+	Environment map[string]string `yaml:"environment"`
+	Privileged  bool              `yaml:"privileged"`
+	Stop        []string          `yaml:"stop"`
+	StopTimeout time.Duration     `yaml:"stop_timeout"`
+	Resources   struct {
+		LimitCPU      int64     `yaml:"limit_cpu"`
+		LimitMemory   BytesSize `yaml:"limit_memory"`
+		RequestCPU    int64     `yaml:"request_cpu"`
+		RequestMemory BytesSize `yaml:"request_memory"`
+	} `yaml:"resources"`
+}
+
+// ResourceProfile defines a named set of resources that
+// pipelines and steps can reference by name.
+type ResourceProfile struct {
+	LimitCPU      int64            `yaml:"limit_cpu"`
+	LimitMemory   BytesSize        `yaml:"limit_memory"`
+	RequestCPU    int64            `yaml:"request_cpu"`
+	RequestMemory BytesSize        `yaml:"request_memory"`
+	Devices       map[string]int64 `yaml:"devices"`
+}
+
+// RunnerProfile defines a runner profile. The profile polls
+// for the pipelines with node labels that match the profile
+// labels, and compiles the pipelines with the profile
+// namespace, node selector and default resources.
+type RunnerProfile struct {
+	Name          string            `yaml:"name"`
+	Labels        map[string]string `yaml:"labels"`
+	Capacity      int               `yaml:"capacity"`
+	Namespace     string            `yaml:"namespace"`
+	NodeSelector  map[string]string `yaml:"node_selector"`
+	LimitCPU      int64             `yaml:"limit_cpu"`
+	LimitMemory   BytesSize         `yaml:"limit_memory"`
+	RequestCPU    int64             `yaml:"request_cpu"`
+	RequestMemory BytesSize         `yaml:"request_memory"`
+}
+
+// EnvFilter defines the environment variables that are
+// removed or overridden in the steps of untrusted builds.
+type EnvFilter struct {
+	Images   []string          `yaml:"images"`
+	Allow    []string          `yaml:"allow"`
+	Deny     []string          `yaml:"deny"`
+	Override map[string]string `yaml:"override"`
+}
+
 type BytesSize int64
 
 func (b *BytesSize) Decode(value string) error {
@@ -176,3 +1117,11 @@ func (b *BytesSize) Decode(value string) error {
 	*b = BytesSize(intType)
 	return nil
 }
+
+func (b *BytesSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return b.Decode(value)
+}
@@ -6,15 +6,49 @@ package daemon
 
 import (
 	"context"
+	"crypto/tls"
+	"expvar"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
 	"github.com/drone-runners/drone-runner-kube/engine/compiler"
 	"github.com/drone-runners/drone-runner-kube/engine/linter"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/internal/changeset"
+	"github.com/drone-runners/drone-runner-kube/internal/cloudauth"
+	"github.com/drone-runners/drone-runner-kube/internal/control"
+	"github.com/drone-runners/drone-runner-kube/internal/credentials"
+	"github.com/drone-runners/drone-runner-kube/internal/docker/inspect"
+	"github.com/drone-runners/drone-runner-kube/internal/falco"
+	"github.com/drone-runners/drone-runner-kube/internal/flaky"
+	"github.com/drone-runners/drone-runner-kube/internal/freeze"
+	"github.com/drone-runners/drone-runner-kube/internal/harbor"
+	"github.com/drone-runners/drone-runner-kube/internal/library"
+	"github.com/drone-runners/drone-runner-kube/internal/livelog"
 	"github.com/drone-runners/drone-runner-kube/internal/match"
+	"github.com/drone-runners/drone-runner-kube/internal/metrics"
+	"github.com/drone-runners/drone-runner-kube/internal/pause"
+	"github.com/drone-runners/drone-runner-kube/internal/planner"
+	"github.com/drone-runners/drone-runner-kube/internal/provenance"
+	"github.com/drone-runners/drone-runner-kube/internal/ratelimit"
+	"github.com/drone-runners/drone-runner-kube/internal/results"
+	"github.com/drone-runners/drone-runner-kube/internal/schema"
+	"github.com/drone-runners/drone-runner-kube/internal/settings"
+	"github.com/drone-runners/drone-runner-kube/internal/spool"
+	"github.com/drone-runners/drone-runner-kube/internal/timeouts"
+	"github.com/drone-runners/drone-runner-kube/internal/tlsconfig"
+	"github.com/drone-runners/drone-runner-kube/internal/tunnel"
+	"github.com/drone-runners/drone-runner-kube/internal/vault"
+	"github.com/drone-runners/drone-runner-kube/internal/webhook"
+	"github.com/drone-runners/drone-runner-kube/internal/zstd"
 	"github.com/drone-runners/drone-runner-kube/runtime"
 
+	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/client"
 	"github.com/drone/runner-go/handler/router"
 	"github.com/drone/runner-go/logger"
@@ -56,17 +90,58 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 	defer cancel()
 
 	// listen for termination signals to gracefully shutdown
-	// the runner daemon.
-	ctx = signal.WithContextFunc(ctx, func() {
-		println("received signal, terminating process")
-		cancel()
+	// the runner daemon. If self-update is enabled the runner
+	// drains before it exits, so that in-flight stages are
+	// not interrupted when the runner pods are replaced.
+	var terminate <-chan struct{}
+	if config.Update.Enabled {
+		terminate = notifyTerminate()
+	} else {
+		ctx = signal.WithContextFunc(ctx, func() {
+			println("received signal, terminating process")
+			cancel()
+		})
+	}
+
+	// the tls policy applies to all outbound connections,
+	// including the remote server and the secret, registry
+	// and credentials plugins.
+	tlsConfig, err := tlsconfig.New(tlsconfig.Config{
+		MinVersion:   config.TLS.MinVersion,
+		CipherSuites: config.TLS.CipherSuites,
+		CAFile:       config.TLS.CAFile,
+		Pins:         config.TLS.Pins,
 	})
+	if err != nil {
+		return err
+	}
 
 	cli := client.New(
 		config.Client.Address,
 		config.Client.Secret,
 		config.Client.SkipVerify,
 	)
+	if tlsConfig != nil {
+		http.DefaultTransport.(*http.Transport).TLSClientConfig = tlsConfig
+
+		clientConfig := tlsConfig.Clone()
+		clientConfig.InsecureSkipVerify = config.Client.SkipVerify
+		cli.Client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: clientConfig,
+			},
+		}
+	}
+	// log uploads are compressed if the server advertises
+	// support, which reduces the bandwidth used by verbose
+	// builds.
+	if config.Client.Compress {
+		if cli.Client == nil {
+			cli.Client = &http.Client{}
+		}
+		cli.Client.Transport = zstd.NewTransport(cli.Client.Transport)
+	}
 	if config.Client.Dump {
 		cli.Dumper = logger.StandardDumper(
 			config.Client.DumpBody,
@@ -78,39 +153,389 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
-	engine, err := engine.NewInCluster()
+	engine, err := toEngine(config)
 	if err != nil {
 		logrus.WithError(err).
 			Fatalln("cannot load the docker engine")
 	}
 
-	remote := remote.New(cli)
+	if key := config.Encryption.Key; len(key) != 0 {
+		if err := engine.EncryptSecrets(key); err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure secret encryption")
+		}
+	}
+
+	// kubernetes api calls that fail with a transient error,
+	// for example when the api server is rate limiting
+	// requests, are retried with exponential backoff.
+	engine.RetryRequests(toRetryPolicy(config))
+
+	// the kubernetes api calls of the pipeline are bound to the
+	// pipeline context, and each request is abandoned if it
+	// does not complete before the deadline.
+	engine.SetDeadlines(toDeadlines(config))
+
+	// in chaos mode, synthetic failures are injected so that
+	// the resilience of the runner can be validated in a test
+	// cluster.
+	if config.Chaos.Enabled {
+		if err := engine.InjectFaults(toChaos(config)); err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure chaos mode")
+		}
+	}
+
+	// delete calls issued when pipelines are destroyed are
+	// throttled, so that mass cancellations do not overload
+	// the api server.
+	if config.Cleanup.DeleteQPS > 0 {
+		engine.ThrottleDeletes(config.Cleanup.DeleteQPS, config.Cleanup.DeleteBurst)
+	}
+
+	// image pulls are coordinated across concurrent pipeline
+	// pods, so that build storms do not saturate the node
+	// network or exceed the registry pull rate limits.
+	if config.Pulls.Enabled {
+		engine.ThrottlePulls(config.Pulls.NodeLimit, config.Pulls.Cooldown)
+	}
+
+	// steps scheduled off-peak are delayed until the off-peak
+	// window opens or the cluster load drops below the
+	// threshold.
+	if config.OffPeak.Window != "" || config.OffPeak.Threshold > 0 {
+		policy, err := toOffPeak(config)
+		if err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure off-peak scheduling")
+		}
+		engine.ScheduleOffPeak(policy)
+	}
+
+	// pipeline pods rotate among a pool of registry credentials
+	// when a registry rate limits image pulls.
+	if len(config.PullCredentials.List) != 0 {
+		engine.RotatePullCredentials(toPullCredentials(config))
+	}
+
+	// the concurrency leases of all pipelines are kept in one
+	// namespace, so that pipelines in the same concurrency
+	// group are mutually exclusive regardless of the pipeline
+	// namespace.
+	if config.Namespace.Locks != "" {
+		engine.SetLockNamespace(config.Namespace.Locks)
+	} else {
+		engine.SetLockNamespace(config.Namespace.Default)
+	}
+
+	// pipelines queue if the objects they create exceed the
+	// namespace limits, so that builds do not fail when the
+	// namespace object count quota is exceeded.
+	if config.Namespace.MaxPods > 0 || config.Namespace.MaxSecrets > 0 {
+		engine.LimitObjects(config.Namespace.MaxPods, config.Namespace.MaxSecrets)
+	}
+
+	// the pods of failed pipelines are retained for debugging,
+	// and the pods of successful pipelines are deleted.
+	if config.Retention.OnFailure > 0 {
+		engine.RetainFailed(config.Retention.OnFailure, config.Retention.Max)
+	}
+
+	// pipelines are optionally created in an ephemeral
+	// namespace, with a resource quota, limit range and role
+	// created from the isolation templates.
+	if config.Isolation.Enabled {
+		if err := engine.IsolateNamespaces(toIsolation(config)); err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure namespace isolation")
+		}
+	}
+
+	// the pipeline pod is submitted to the cluster admission
+	// chain as a dry run before the pipeline is created, so
+	// that pipelines rejected by admission policies fail with
+	// a readable error.
+	if config.Admission.DryRun {
+		engine.ValidateAdmission()
+	}
+
+	// pipeline resources leaked by runner processes that exited
+	// before the pipeline was destroyed are garbage collected.
+	if config.GC.Enabled {
+		engine.CollectGarbage(config.Runner.Name, config.GC.TTL)
+	}
+
+	// the pipeline pods are annotated with a heartbeat, so that
+	// the pods of a crashed runner are deleted by the reaper.
+	if config.Heartbeat.Enabled {
+		engine.EnableHeartbeats(config.Heartbeat.Interval, config.Heartbeat.Timeout)
+	}
+
+	// standby pods keep the pooled plugin images pulled on the
+	// cluster nodes, and pipelines that use a pooled image are
+	// scheduled on the node of a claimed standby pod.
+	if len(config.WarmPool.Images) != 0 {
+		engine.EnableWarmPools(
+			toWarmPoolNamespace(config),
+			config.Labels.Prefix,
+			config.Images.Shell,
+			toWarmPools(config),
+		)
+	}
+
+	// pipelines can run in the cluster of a kubeconfig
+	// sourced from a secret, if the cluster api server is
+	// allowed.
+	if len(config.Cluster.Allowed) != 0 {
+		engine.AllowClusters(config.Cluster.Allowed)
+	}
+
+	// the resources created by the pipeline steps, labeled with
+	// the janitor label, are deleted when the pipeline is
+	// destroyed.
+	if config.Janitor.Enabled {
+		if err := engine.EnableJanitor(config.Janitor.Namespaces, config.Janitor.Resources); err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure the janitor")
+		}
+	}
+
+	// the pipeline lifecycle events are optionally recorded as
+	// kubernetes events of the pipeline pod, and posted to a
+	// webhook endpoint.
+	if config.Lifecycle.Events {
+		engine.EmitEvents()
+	}
+	if config.Lifecycle.Endpoint != "" {
+		engine.Observe(webhook.New(
+			ctx,
+			config.Lifecycle.Endpoint,
+			config.Lifecycle.Token,
+			config.Lifecycle.SkipVerify,
+		))
+	}
+
+	// parallel steps that exec into the same pipeline pod are
+	// queued if they exceed the limit, so that the kubelet
+	// exec stream limits are not exceeded.
+	if config.Runner.MaxExecs > 0 {
+		engine.LimitExecs(config.Runner.MaxExecs)
+	}
+
+	// vault environment variables are resolved by the runner
+	// when the pipeline is created, so that the credentials
+	// never pass through the server.
+	if config.Vault.Address != "" {
+		engine.ResolveSecrets(vault.New(vault.Config{
+			Address:   config.Vault.Address,
+			Token:     config.Vault.Token,
+			Namespace: config.Vault.Namespace,
+			Role:      config.Vault.Role,
+			Mount:     config.Vault.Mount,
+		}))
+	}
+
+	// publish steps receive short-lived registry credentials
+	// that are minted when the pipeline is created, and revoked
+	// when the pipeline is destroyed.
+	if robots := toRobotProvider(config); robots != nil {
+		engine.MintRobots(robots)
+	}
+
+	// plugin steps execute the image entrypoint if the image
+	// configuration can be inspected, instead of a command
+	// derived from the image name.
+	inspector, err := toInspector(config)
+	if err != nil {
+		logrus.WithError(err).
+			Fatalln("cannot load the image manifest cache")
+	}
+
+	// the runner capabilities. Pipelines that request
+	// unsupported capabilities are rejected by the linter, and
+	// nfs and csi volumes can only be mounted if the server or
+	// driver matches the allow-list. Pipelines can only steer
+	// the pod to nodes that match the placement allow-list.
+	policy := linter.Policy{
+		NFS:            config.Volumes.NFS,
+		CSI:            config.Volumes.CSI,
+		Platforms:      config.Runner.Platforms,
+		MaxCPU:         config.Resources.MaxCPU,
+		MaxMemory:      int64(config.Resources.MaxMemory),
+		Domains:        config.Offline.Domains,
+		NodeRepos:      config.Node.Repos,
+		KVMRepos:       config.KVM.Repos,
+		NodeSelectors:  config.Placement.NodeSelectors,
+		Tolerations:    config.Placement.Tolerations,
+		RuntimeClasses: config.Placement.RuntimeClasses,
+		CloneOptions:   config.Images.CloneOpts && config.Images.Clone != "",
+		ZoneLabel:      config.Topology.ZoneLabel,
+		RegionLabel:    config.Topology.RegionLabel,
+	}
+	for name := range config.ResourceProfiles.List {
+		policy.ResourceProfiles = append(policy.ResourceProfiles, name)
+	}
+	if config.Vault.Address != "" {
+		policy.Vault = config.Vault.Paths
+	}
+
+	// log lines and status updates that cannot be sent to the
+	// server are persisted to the spool directory and retried,
+	// so that a short server outage does not fail stages.
+	var spoolClient client.Client = cli
+	var spooler *spool.Client
+	if config.Spool.Path != "" {
+		spooler, err = spool.New(cli, config.Spool.Path, int64(config.Spool.Limit))
+		if err != nil {
+			return err
+		}
+		spooler.QueueUpdates(config.Spool.Wait)
+		spoolClient = spooler
+	}
+
+	remote := remote.New(spoolClient)
 	tracer := history.New(remote)
+
+	// the step logs buffered in memory are bounded, and the
+	// overflow is spilled to disk, so that many concurrent
+	// verbose steps do not exhaust the runner memory.
+	streamer := livelog.NewStreamer(
+		spoolClient,
+		int(config.Log.Buffer),
+		int(config.Log.Limit),
+		config.Log.Path,
+	)
 	hook := loghistory.New()
 	logrus.AddHook(hook)
 
+	// stage execution is paused during the maintenance window.
+	// Stages are not requested while all repositories are
+	// paused, and running stages are not affected.
+	pauser := pause.New(toWindow(config))
+
+	// stages for scheduled builds, for example cron and tag
+	// builds, are optionally rate limited so that they do not
+	// crowd out interactive builds.
+	limiter := ratelimit.New(
+		config.Limit.EventRates,
+		config.Limit.EventBurst,
+	)
+
+	// deployments are rejected or held by the runner during
+	// the deployment freeze windows.
+	freezer, err := freeze.New(config.Freeze.Windows)
+	if err != nil {
+		logrus.WithError(err).
+			Fatalln("cannot configure the deployment freeze windows")
+	}
+
+	// signed provenance is optionally emitted for each
+	// pipeline that succeeds.
+	var emitter *provenance.Emitter
+	if key := config.Provenance.Key; key != nil && config.Provenance.Store != "" {
+		emitter = provenance.New(
+			config.Provenance.Builder,
+			key,
+			provenance.NewStore(
+				config.Provenance.Store,
+				config.Provenance.Token,
+				config.Provenance.SkipVerify,
+			),
+		)
+		// signed execution records of every pipeline are
+		// optionally emitted for compliance audits.
+		if config.Provenance.Records {
+			emitter.RecordExecutions()
+		}
+	}
+
+	// the alerts of the falco node agent are received by the
+	// runner, and written to the logs of the steps that raised
+	// the alerts.
+	var alerts *falco.Receiver
+	if config.Falco.Token != "" {
+		alerts, err = falco.New(
+			config.Falco.Token,
+			config.Falco.Priority,
+			config.Falco.Fail,
+		)
+		if err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure the falco receiver")
+		}
+	}
+
+	// the timeouts of the pipelines, which can be overridden
+	// for matching repositories.
+	timeoutTable := toTimeouts(config)
+
 	poller := &runtime.Poller{
 		// NOTE the single flight wrapper limits the number
 		// of open requests when polling the queue. This is
 		// an experimental feature and requires further testing.
-		Client: &client.SingleFlight{Client: cli},
+		Client: &client.SingleFlight{Client: pauser.Client(cli)},
 		Runner: &runtime.Runner{
-			Client:   cli,
+			Client:   spoolClient,
+			Pause:    pauser.Wait,
+			Throttle: limiter.Wait,
+			Freeze:   freezer.Wait,
 			Machine:  config.Runner.Name,
 			Reporter: tracer,
-			Linter:   linter.New(config.Namespace.Rules),
+			Cache:    runtime.NewCache(config.Runner.ConfigCache),
+			Linter:   linter.New(config.Namespace.Rules, policy),
 			Match: match.Func(
 				config.Limit.Repos,
 				config.Limit.Events,
 				config.Limit.Trusted,
 			),
+			Approve: match.Approved(
+				config.Forks.ApprovalLabel,
+			),
+			MaxTimeout: func(repo *drone.Repo) time.Duration {
+				return timeoutTable.Lookup(repo.Slug).Stage
+			},
 			Compiler: &compiler.Compiler{
 				Cloner:         config.Images.Clone,
+				ShellImage:     config.Images.Shell,
+				ExecTemplates:  config.Runner.Exec,
+				PullSecrets:    config.Images.PullSecrets,
+				Shellless:      config.Runner.Shellless,
 				Environ:        config.Runner.Environ,
 				Namespace:      config.Namespace.Default,
+				NamespacePool:  compiler.NewNamespacePool(config.Namespace.Pool),
 				Labels:         config.Labels.Default,
 				Annotations:    config.Annotations.Default,
 				ServiceAccount: config.ServiceAccount.Default,
+				AutomountToken: config.ServiceAccount.Automount,
+				Metadata:       config.Runner.Metadata,
+				Outputs:        config.Runner.Outputs,
+				Janitor:        config.Janitor.Enabled,
+				ShortSHA:       config.Runner.ShortSHA,
+				Finalizer:      config.Cleanup.Finalizer,
+				Isolate:        config.Isolation.Enabled,
+				LabelPrefix:    config.Labels.Prefix,
+				IPFamily:       config.Network.IPFamily,
+				UsageInterval:  config.Resources.UsageInterval,
+				KeepAlive:      config.Runner.KeepAlive,
+				Watchdog:       config.Runner.Watchdog,
+				Pending:        config.Runner.Pending,
+				Timeouts:       timeoutTable,
+				NonEvictable:   config.Runner.NonEvict,
+				BlockMetadata:  config.Network.BlockMetadata,
+				SecretScan:     toScanPolicy(config.Secret.Scan),
+				NodeHelper:     toNodeHelper(config),
+				KVMResource:    config.KVM.Resource,
+				Features:       config.Runner.Features,
+				LogStream:      config.Runner.LogStream,
+				PodTemplate:    config.Template.Pod,
+				Sidecars:       toSidecars(config.Sidecars.List),
+				Mirrors:        toMirrors(config),
+				ProxyCache:     toProxyCache(config),
+				Antivirus:      toAntivirus(config),
+				Locale:         toLocale(config),
+				Topology:       toTopology(config),
+				EnvFilters:     toEnvFilters(config.EnvFilters.List),
+				SecureForks:    config.Forks.Secure,
 				Privileged:     append(config.Runner.Privileged, compiler.Privileged...),
 				Registry: registry.Combine(
 					registry.File(
@@ -121,6 +546,22 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 						config.Registry.Token,
 						config.Registry.SkipVerify,
 					),
+					toCloudRegistry(config),
+				),
+				Inspector: inspector,
+				Changeset: toChangeset(config),
+				Planner:   planner.New(config.Planner.Configs),
+				Settings: settings.External(
+					config.Settings.Endpoint,
+					config.Settings.Token,
+					config.Settings.SkipVerify,
+				),
+				RobotImages: toRobotImages(config),
+				Credentials: credentials.Broker(
+					config.Credentials.Endpoint,
+					config.Credentials.Token,
+					config.Credentials.SkipVerify,
+					config.Credentials.Policies,
 				),
 				Secret: secret.Combine(
 					secret.StaticVars(
@@ -142,16 +583,74 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 						Memory: int64(config.Resources.RequestMemory),
 					},
 				},
+				MaxResources: compiler.ResourceObject{
+					CPU:    config.Resources.MaxCPU,
+					Memory: int64(config.Resources.MaxMemory),
+				},
 				DNS: compiler.DNS{
 					DNSPolicy: config.DNS.DNSPolicy,
 					DNSConfig: config.DNS.DNSConfig,
 				},
+				SecurityContext:  toSecurityContext(config),
+				ResourceProfiles: toResourceProfiles(config.ResourceProfiles.List),
+				SecurityPolicy: compiler.SecurityPolicy{
+					MinUser:         config.Security.MinUser,
+					MaxUser:         config.Security.MaxUser,
+					SeccompProfiles: config.Security.SeccompProfiles,
+				},
+				Workspace: compiler.Workspace{
+					Backend:       config.Workspace.Backend,
+					Claim:         config.Workspace.Claim,
+					StorageClass:  config.Workspace.StorageClass,
+					AccessMode:    config.Workspace.AccessMode,
+					Size:          int64(config.Workspace.Size),
+					Snapshot:      config.Workspace.Snapshot,
+					SnapshotClass: config.Workspace.SnapshotClass,
+				},
+				Cache: compiler.Cache{
+					Backend:      config.BuildCache.Backend,
+					StorageClass: config.BuildCache.StorageClass,
+					AccessMode:   config.BuildCache.AccessMode,
+					Size:         int64(config.BuildCache.Size),
+					Image:        config.BuildCache.Image,
+					Bucket:       config.BuildCache.Bucket,
+					Endpoint:     config.BuildCache.Endpoint,
+					Region:       config.BuildCache.Region,
+					PathStyle:    config.BuildCache.PathStyle,
+					AccessKey:    config.BuildCache.AccessKey,
+					SecretKey:    config.BuildCache.SecretKey,
+				},
+				RepoCache: compiler.RepoCache{
+					Enabled:      config.RepoCache.Enabled,
+					StorageClass: config.RepoCache.StorageClass,
+					AccessMode:   config.RepoCache.AccessMode,
+					Size:         int64(config.RepoCache.Size),
+					Tools:        config.RepoCache.Tools,
+				},
+				Artifacts: compiler.Artifacts{
+					Image: config.Artifacts.Image,
+					Limit: int64(config.Artifacts.Limit),
+				},
+				Publish: compiler.Publish{
+					Kaniko:  config.Images.Kaniko,
+					Buildah: config.Images.Buildah,
+				},
+				Deploy: compiler.Deploy{
+					Helm:    config.Images.Helm,
+					Kubectl: config.Images.Kubectl,
+				},
 			},
 			Execer: runtime.NewExecer(
 				tracer,
-				remote,
+				streamer,
 				engine,
 				config.Runner.Procs,
+				config.Runner.Traces,
+				config.Runner.SBOM,
+				emitter,
+				alerts,
+				toStageObserver(ctx, config),
+				toFlaky(config),
 			),
 		},
 		Filter: &client.Filter{
@@ -161,14 +660,73 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		},
 	}
 
+	// steps can reference reusable step bundles published to
+	// an oci registry, which are expanded before the pipeline
+	// is linted and compiled.
+	if config.Library.Enabled {
+		poller.Runner.Expand = library.New(
+			config.Library.Username,
+			config.Library.Password,
+			config.Library.Registries,
+		).Expand
+	}
+
+	// the pipeline specification is stored with recoverable
+	// pipelines, so that the runner can resume the pipeline if
+	// the runner process restarts.
+	poller.Runner.Recoverable = config.Recovery.Enabled
+
+	// identical stages that already succeeded, for example
+	// from re-delivered webhooks, report the cached result
+	// instead of executing again.
+	poller.Runner.Results = toResults(config)
+
+	// the cluster capacity is verified before the stage is
+	// started, and stages that cannot be scheduled are released
+	// to the queue for runners with capacity.
+	if config.Preflight.Enabled {
+		poller.Runner.Preflight = engine.CheckCapacity
+		poller.Runner.PreflightBackoff = config.Preflight.Backoff
+	}
+
+	// the runner metrics are exposed in the expvar format
+	// alongside the dashboard, and the engine metrics are
+	// exposed in the prometheus format.
+	mux := http.NewServeMux()
+	mux.Handle("/varz", expvar.Handler())
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/", router.New(tracer, hook, router.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Realm:    config.Dashboard.Realm,
+	}))
+
+	// the maintenance api is only enabled if the dashboard
+	// requires authentication.
+	if config.Dashboard.Password != "" {
+		mux.Handle("/api/pause", pause.Handler(
+			pauser,
+			config.Dashboard.Username,
+			config.Dashboard.Password,
+		))
+	}
+
+	// the pipeline schema and validation api are used by
+	// editors and pre-commit hooks, and do not expose any
+	// runner state.
+	mux.Handle("/api/schema", schema.Handler())
+	mux.Handle("/api/validate", schema.ValidateHandler())
+
+	// the falco http output posts the node agent alerts to
+	// the runner.
+	if alerts != nil {
+		mux.Handle("/api/falco", alerts.Handler())
+	}
+
 	var g errgroup.Group
 	server := server.Server{
-		Addr: config.Server.Port,
-		Handler: router.New(tracer, hook, router.Config{
-			Username: config.Dashboard.Username,
-			Password: config.Dashboard.Password,
-			Realm:    config.Dashboard.Realm,
-		}),
+		Addr:    config.Server.Port,
+		Handler: mux,
 	}
 
 	logrus.WithField("addr", config.Server.Port).
@@ -178,6 +736,21 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		return server.ListenAndServe(ctx)
 	})
 
+	// the prometheus metrics are optionally served on a
+	// separate port, so that the metrics can be scraped
+	// without exposing the dashboard.
+	if config.Metrics.Port != "" {
+		metricsServer := server.Server{
+			Addr:    config.Metrics.Port,
+			Handler: metrics.Handler(),
+		}
+		logrus.WithField("addr", config.Metrics.Port).
+			Infoln("starting the metrics server")
+		g.Go(func() error {
+			return metricsServer.ListenAndServe(ctx)
+		})
+	}
+
 	// Ping the server and block until a successful connection
 	// to the server has been established.
 	for {
@@ -200,6 +773,239 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		}
 	}
 
+	// the server protocol does not support capability
+	// negotiation, so capabilities are reported in the
+	// runner logs and enforced by the linter.
+	logrus.WithField("platforms", policy.Platforms).
+		WithField("volumes.nfs", policy.NFS).
+		WithField("volumes.csi", policy.CSI).
+		WithField("resources.cpu", policy.MaxCPU).
+		WithField("resources.memory", policy.MaxMemory).
+		WithField("placement.node_selectors", policy.NodeSelectors).
+		WithField("placement.tolerations", policy.Tolerations).
+		WithField("placement.runtime_classes", policy.RuntimeClasses).
+		Infoln("runner capabilities")
+
+	// the cleanup controller guarantees teardown of pipeline
+	// resources for pods with the cleanup finalizer, including
+	// pods orphaned by a previous runner process.
+	// The controller watches every namespace where the runner
+	// creates pipeline pods.
+	if config.Cleanup.Finalizer {
+		namespaces := toNamespaces(config)
+		if namespace := config.Cleanup.Namespace; namespace != "" && !contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+		for _, namespace := range namespaces {
+			namespace := namespace
+			g.Go(func() error {
+				logrus.WithField("namespace", namespace).
+					Infoln("starting the cleanup controller")
+				return engine.Cleanup(ctx, namespace, config.Labels.Prefix)
+			})
+		}
+	}
+
+	// the reaper deletes retained pods whose retention expired,
+	// including pods retained by a previous runner process.
+	if config.Retention.OnFailure > 0 {
+		for _, namespace := range toNamespaces(config) {
+			namespace := namespace
+			g.Go(func() error {
+				engine.Reap(ctx, namespace, config.Labels.Prefix, config.Retention.Interval)
+				return nil
+			})
+		}
+	}
+
+	// the cache janitor deletes the least recently used
+	// repository caches once the caches exceed the limit.
+	if config.RepoCache.Enabled {
+		for _, namespace := range toNamespaces(config) {
+			namespace := namespace
+			g.Go(func() error {
+				engine.ReapCaches(ctx, namespace, config.Labels.Prefix, int64(config.RepoCache.Limit), config.RepoCache.Interval)
+				return nil
+			})
+		}
+	}
+
+	// the standby pods of the warm pools are replaced once
+	// they are claimed by a pipeline.
+	if len(config.WarmPool.Images) != 0 {
+		g.Go(func() error {
+			logrus.WithField("images", config.WarmPool.Images).
+				Infoln("starting the warm pools")
+			engine.Replenish(ctx, config.WarmPool.Interval)
+			return nil
+		})
+	}
+
+	// the garbage collector deletes leaked pipeline resources,
+	// including resources leaked by a previous runner process.
+	if config.GC.Enabled {
+		namespaces := config.GC.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = toNamespaces(config)
+		}
+		g.Go(func() error {
+			logrus.WithField("namespaces", namespaces).
+				Infoln("starting the garbage collector")
+			engine.Collect(ctx, namespaces, config.Labels.Prefix, config.GC.Interval)
+			return nil
+		})
+	}
+
+	// the heartbeat of the active pipeline pods is updated,
+	// and the reaper deletes the pipeline pods whose heartbeat
+	// is stale, including pods of other runner processes.
+	if config.Heartbeat.Enabled {
+		g.Go(func() error {
+			engine.Heartbeat(ctx)
+			return nil
+		})
+	}
+	if config.Heartbeat.Reaper {
+		g.Go(func() error {
+			logrus.WithField("namespaces", toNamespaces(config)).
+				Infoln("starting the heartbeat reaper")
+			engine.ReapStale(ctx, toNamespaces(config), config.Labels.Prefix, config.Heartbeat.ReaperInterval)
+			return nil
+		})
+	}
+
+	// the reaper deletes the namespaces of isolated pipelines
+	// that were not destroyed, for example because the runner
+	// exited while the pipeline was running.
+	if config.Isolation.Enabled {
+		g.Go(func() error {
+			engine.ReapNamespaces(ctx, config.Labels.Prefix, config.Isolation.TTL, config.Isolation.Interval)
+			return nil
+		})
+	}
+
+	if spooler != nil {
+		g.Go(func() error {
+			spooler.Run(ctx, config.Spool.Interval)
+			return nil
+		})
+	}
+
+	// polling stops when the runner is drained for an update.
+	// The poller returns once in-flight stages are complete.
+	pollctx, drain := context.WithCancel(ctx)
+	defer drain()
+	drained := make(chan struct{})
+
+	// the control api allows a fleet controller to pause and
+	// drain the runner, to adjust the capacity at runtime up
+	// to the maximum capacity, and to cancel running stages.
+	threads := config.Runner.Capacity
+	if config.Control.Token != "" {
+		threads = config.Control.MaxCapacity
+		poller.Capacity = runtime.NewCapacity(config.Runner.Capacity)
+		poller.Runner.Canceller = runtime.NewCanceller()
+		controller := &control.Controller{
+			Name:        config.Runner.Name,
+			Token:       config.Control.Token,
+			MaxCapacity: config.Control.MaxCapacity,
+			Capacity:    poller.Capacity,
+			Pause:       pauser,
+			History:     tracer,
+			Canceller:   poller.Runner.Canceller,
+			Drain:       drain,
+			Drained:     drained,
+			Revoke: func(repo string) int {
+				return engine.Revoke(nocontext, repo)
+			},
+			Node:     toNodePods(engine, config),
+			Relocate: engine.Relocate,
+			Release:  engine.Release,
+		}
+
+		// container checkpointing is experimental, and requires
+		// the kubelet checkpoint api.
+		if config.Control.Checkpoint {
+			controller.Checkpoint = toCheckpoint(engine, config)
+		}
+
+		// the control api is optionally served on a separate
+		// port with mutual tls, so that the fleet controller
+		// and the peer runner replicas are authenticated by
+		// their client certificates.
+		if config.Control.TLSCert != "" {
+			controller.Peers = config.Control.Peers
+			tlsServer, err := tlsconfig.Server(
+				config.Control.TLSCert,
+				config.Control.TLSKey,
+				config.Control.TLSClientCA,
+			)
+			if err != nil {
+				return err
+			}
+			controlServer := &http.Server{
+				Addr:      config.Control.Port,
+				Handler:   controller.Handler(),
+				TLSConfig: tlsServer,
+			}
+			logrus.WithField("addr", config.Control.Port).
+				Infoln("starting the control server")
+			g.Go(func() error {
+				go func() {
+					<-ctx.Done()
+					controlServer.Close()
+				}()
+				err := controlServer.ListenAndServeTLS("", "")
+				if err == http.ErrServerClosed {
+					return nil
+				}
+				return err
+			})
+		} else {
+			mux.Handle("/api/control/", controller.Handler())
+		}
+	}
+
+	// pipelines that were running when the runner process
+	// exited are resumed before the poller starts. The garbage
+	// collector skips resumed pipelines. Steps that do not write
+	// the output to the container logs cannot be re-attached,
+	// and fail.
+	if config.Recovery.Enabled {
+		poller.Runner.Recover(ctx, engine,
+			toNamespaces(config),
+			config.Labels.Prefix,
+		)
+	}
+
+	// the runner is drained once the docker pipeline poller
+	// returns, if enabled.
+	var compat chan struct{}
+	if config.Docker.Compat {
+		compat = make(chan struct{})
+	}
+
+	// runner profiles poll for the pipelines that match the
+	// profile labels with a separate capacity, so that a single
+	// runner serves multiple resource classes. The runner is
+	// drained once the profile pollers return.
+	var profiles sync.WaitGroup
+	for _, profile := range config.Profiles.List {
+		profile := profile
+		profilePoller := toProfilePoller(poller, profile)
+		profiles.Add(1)
+		g.Go(func() error {
+			logrus.WithField("profile", profile.Name).
+				WithField("capacity", profile.Capacity).
+				WithField("labels", profile.Labels).
+				Infoln("polling the remote server for the runner profile")
+
+			profilePoller.Poll(pollctx, profile.Capacity)
+			profiles.Done()
+			return nil
+		})
+	}
+
 	g.Go(func() error {
 		logrus.WithField("capacity", config.Runner.Capacity).
 			WithField("endpoint", config.Client.Address).
@@ -207,10 +1013,54 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 			WithField("type", resource.Type).
 			Infoln("polling the remote server")
 
-		poller.Poll(ctx, config.Runner.Capacity)
+		poller.Poll(pollctx, threads)
+		if compat != nil {
+			<-compat
+		}
+		profiles.Wait()
+		close(drained)
 		return nil
 	})
 
+	// docker pipelines are optionally polled with a separate
+	// capacity and translated to kubernetes pipelines, so that
+	// repositories can migrate from the docker runner.
+	if config.Docker.Compat {
+		dockerPoller := &runtime.Poller{
+			Client: poller.Client,
+			Runner: poller.Runner,
+			Filter: &client.Filter{
+				Kind:   resource.Kind,
+				Type:   resource.TypeDocker,
+				Labels: config.Runner.Labels,
+			},
+		}
+		g.Go(func() error {
+			logrus.WithField("capacity", config.Docker.Capacity).
+				WithField("kind", resource.Kind).
+				WithField("type", resource.TypeDocker).
+				Infoln("polling the remote server for docker pipelines")
+
+			dockerPoller.Poll(pollctx, config.Docker.Capacity)
+			close(compat)
+			return nil
+		})
+	}
+
+	if config.Update.Enabled {
+		updater, err := newUpdater(config)
+		if err != nil {
+			return err
+		}
+		g.Go(func() error {
+			return selfUpdate(ctx, updater)
+		})
+		g.Go(func() error {
+			drainOnSignal(ctx, config, terminate, drain, drained, cancel)
+			return nil
+		})
+	}
+
 	err = g.Wait()
 	if err != nil {
 		logrus.WithError(err).
@@ -235,6 +1085,342 @@ func setupLogger(config Config) {
 	}
 }
 
+// helper function returns the maintenance window from the
+// loaded configuration, or nil if execution is not paused.
+func toWindow(config Config) *pause.Window {
+	if !config.Maintenance.Paused && config.Maintenance.Start.IsZero() {
+		return nil
+	}
+	return &pause.Window{
+		Repos: config.Maintenance.Repos,
+		Start: config.Maintenance.Start,
+		End:   config.Maintenance.End,
+	}
+}
+
+// helper function returns the policy for secrets detected
+// in the step output. Valid values are warn and fail.
+func toScanPolicy(s string) engine.ScanPolicy {
+	switch strings.ToLower(s) {
+	case "warn":
+		return engine.ScanWarn
+	case "fail":
+		return engine.ScanFail
+	default:
+		return engine.ScanNone
+	}
+}
+
+// helper function returns the node helper used to execute
+// node steps, or nil if node steps are disabled.
+func toNodeHelper(config Config) *engine.NodeHelper {
+	if len(config.Node.Repos) == 0 {
+		return nil
+	}
+	return &engine.NodeHelper{
+		Namespace: config.Node.Namespace,
+		Selector:  config.Node.Selector,
+		Container: config.Node.Container,
+	}
+}
+
+// helper function returns the policy used to retry kubernetes
+// api calls that fail with a transient error.
+func toRetryPolicy(config Config) engine.RetryPolicy {
+	return engine.RetryPolicy{
+		Attempts: config.Retry.Attempts,
+		Delay:    config.Retry.Delay,
+		MaxDelay: config.Retry.MaxDelay,
+		Factor:   2,
+		Jitter:   0.2,
+	}
+}
+
+// helper function returns the deadlines of the kubernetes
+// api calls.
+func toDeadlines(config Config) engine.Deadlines {
+	return engine.Deadlines{
+		Request: config.Deadlines.Request,
+		Watch:   config.Deadlines.Watch,
+	}
+}
+
+// helper function returns the image inspector, or nil if image
+// inspection is disabled.
+func toInspector(config Config) (*inspect.Inspector, error) {
+	if !config.Inspect.Enabled {
+		return nil, nil
+	}
+	inspector := inspect.New(
+		config.Inspect.Username,
+		config.Inspect.Password,
+		config.Inspect.CacheTTL,
+	)
+	if path := config.Inspect.CacheFile; path != "" {
+		if err := inspector.Load(path); err != nil {
+			return nil, err
+		}
+	}
+	return inspector, nil
+}
+
+// helper function converts the configured sidecars to the
+// sidecar structure used by the engine.
+func toSidecars(src []*Sidecar) []*engine.Sidecar {
+	var dst []*engine.Sidecar
+	for _, s := range src {
+		dst = append(dst, &engine.Sidecar{
+			Name:        s.Name,
+			Image:       s.Image,
+			Entrypoint:  s.Entrypoint,
+			Command:     s.Command,
+			Envs:        s.Environment,
+			Privileged:  s.Privileged,
+			Stop:        s.Stop,
+			StopTimeout: int64(s.StopTimeout / time.Second),
+			Resources: engine.Resources{
+				Limits: engine.ResourceObject{
+					CPU:    s.Resources.LimitCPU,
+					Memory: int64(s.Resources.LimitMemory),
+				},
+				Requests: engine.ResourceObject{
+					CPU:    s.Resources.RequestCPU,
+					Memory: int64(s.Resources.RequestMemory),
+				},
+			},
+		})
+	}
+	return dst
+}
+
+// helper function returns the antivirus scanner of the
+// pipeline workspace.
+func toAntivirus(config Config) compiler.Antivirus {
+	return compiler.Antivirus{
+		Image:    config.Antivirus.Image,
+		Endpoint: config.Antivirus.Endpoint,
+		Clone:    config.Antivirus.Clone,
+		Build:    config.Antivirus.Build,
+		Flag:     config.Antivirus.Flag,
+	}
+}
+
+// helper function converts the locale configuration to
+// the compiler locale.
+func toLocale(config Config) compiler.Locale {
+	return compiler.Locale{
+		Timezone:  config.Locale.Timezone,
+		Lang:      config.Locale.Lang,
+		Localtime: config.Locale.Localtime,
+	}
+}
+
+// helper function converts the topology configuration to
+// the compiler topology.
+func toTopology(config Config) compiler.Topology {
+	return compiler.Topology{
+		ZoneLabel:   config.Topology.ZoneLabel,
+		RegionLabel: config.Topology.RegionLabel,
+	}
+}
+
+// helper function converts the resource profile
+// configuration to compiler resource profiles.
+func toResourceProfiles(src map[string]*ResourceProfile) map[string]*compiler.ResourceProfile {
+	dst := map[string]*compiler.ResourceProfile{}
+	for name, p := range src {
+		dst[name] = &compiler.ResourceProfile{
+			Limits: compiler.ResourceObject{
+				CPU:    p.LimitCPU,
+				Memory: int64(p.LimitMemory),
+			},
+			Requests: compiler.ResourceObject{
+				CPU:    p.RequestCPU,
+				Memory: int64(p.RequestMemory),
+			},
+			Devices: p.Devices,
+		}
+	}
+	return dst
+}
+
+// helper function returns the changeset provider used to
+// evaluate the path conditions of the steps when the pipeline
+// is compiled. If disabled, the path conditions are evaluated
+// after the repository is cloned.
+func toChangeset(config Config) changeset.Provider {
+	if !config.Paths.Compare {
+		return nil
+	}
+	return changeset.GitHub(config.Paths.Server)
+}
+
+// helper function returns the namespaces of the pipelines,
+// including the namespaces of the runner profiles and of the
+// namespace rules.
+func toNamespaces(config Config) []string {
+	namespaces := append([]string{config.Namespace.Default}, config.Namespace.Pool...)
+	seen := map[string]bool{}
+	for _, namespace := range namespaces {
+		seen[namespace] = true
+	}
+	for _, profile := range config.Profiles.List {
+		if profile.Namespace != "" && !seen[profile.Namespace] {
+			seen[profile.Namespace] = true
+			namespaces = append(namespaces, profile.Namespace)
+		}
+	}
+	// the namespaces of the namespace rules can be requested
+	// by the pipeline.
+	var rules []string
+	for namespace := range config.Namespace.Rules {
+		if !seen[namespace] {
+			seen[namespace] = true
+			rules = append(rules, namespace)
+		}
+	}
+	sort.Strings(rules)
+	return append(namespaces, rules...)
+}
+
+// helper function returns true if the list contains the name.
+func contains(list []string, name string) bool {
+	for _, item := range list {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the registry credential pool.
+func toPullCredentials(config Config) []*engine.PullCredential {
+	var pool []*engine.PullCredential
+	for _, cred := range config.PullCredentials.List {
+		pool = append(pool, &engine.PullCredential{
+			Name:     cred.Name,
+			Registry: cred.Registry,
+			Username: cred.Username,
+			Password: cred.Password,
+		})
+	}
+	return pool
+}
+
+// helper function returns the warm pools of the configured
+// plugin images.
+func toWarmPools(config Config) []*engine.WarmPool {
+	var pools []*engine.WarmPool
+	for _, image := range config.WarmPool.Images {
+		pools = append(pools, &engine.WarmPool{
+			Image:    image,
+			Replicas: config.WarmPool.Replicas,
+		})
+	}
+	return pools
+}
+
+// helper function returns the namespace of the standby pods,
+// which defaults to the default pipeline namespace.
+func toWarmPoolNamespace(config Config) string {
+	if config.WarmPool.Namespace != "" {
+		return config.WarmPool.Namespace
+	}
+	return config.Namespace.Default
+}
+
+// helper function returns the off-peak scheduling policy.
+func toOffPeak(config Config) (engine.OffPeak, error) {
+	policy := engine.OffPeak{
+		Threshold: config.OffPeak.Threshold,
+		MaxDelay:  config.OffPeak.MaxDelay,
+		Interval:  config.OffPeak.Interval,
+	}
+	if config.OffPeak.Window != "" {
+		window, err := freeze.ParseSchedule(config.OffPeak.Window, config.OffPeak.Timezone)
+		if err != nil {
+			return policy, err
+		}
+		policy.Window = window.Match
+	}
+	return policy, nil
+}
+
+// helper function returns a function that lists the pipeline
+// pods on a node. Isolated pipelines run in a namespace per
+// pipeline, so all namespaces are searched.
+func toNodePods(k *engine.Kubernetes, config Config) func(string) ([]*engine.NodePod, error) {
+	namespaces := toNamespaces(config)
+	if config.Isolation.Enabled {
+		namespaces = []string{""}
+	}
+	return func(node string) ([]*engine.NodePod, error) {
+		return k.NodePods(namespaces, config.Labels.Prefix, node)
+	}
+}
+
+// helper function returns a function that checkpoints the
+// running containers of a pipeline pod.
+func toCheckpoint(k *engine.Kubernetes, config Config) func(*engine.NodePod) (map[string]string, error) {
+	return func(pod *engine.NodePod) (map[string]string, error) {
+		return k.CheckpointPod(nocontext, pod.Namespace, pod.Name, config.Labels.Prefix)
+	}
+}
+
+// helper function returns a poller for the runner profile,
+// which polls for the pipelines with the profile labels. The
+// profile runner shares the runner configuration, and compiles
+// the pipelines with the profile namespace, node selector and
+// default resources.
+func toProfilePoller(poller *runtime.Poller, profile *RunnerProfile) *runtime.Poller {
+	c := *poller.Runner.Compiler
+	if profile.Namespace != "" {
+		c.Namespace = profile.Namespace
+		c.NamespacePool = nil
+	}
+	if len(profile.NodeSelector) != 0 {
+		c.NodeSelector = profile.NodeSelector
+	}
+	if profile.LimitCPU != 0 {
+		c.Resources.Limits.CPU = profile.LimitCPU
+	}
+	if profile.LimitMemory != 0 {
+		c.Resources.Limits.Memory = int64(profile.LimitMemory)
+	}
+	if profile.RequestCPU != 0 {
+		c.Resources.Requests.CPU = profile.RequestCPU
+	}
+	if profile.RequestMemory != 0 {
+		c.Resources.Requests.Memory = int64(profile.RequestMemory)
+	}
+	r := *poller.Runner
+	r.Compiler = &c
+	return &runtime.Poller{
+		Client: poller.Client,
+		Runner: &r,
+		Filter: &client.Filter{
+			Kind:   resource.Kind,
+			Type:   resource.Type,
+			Labels: profile.Labels,
+		},
+	}
+}
+
+// helper function converts the environment filter
+// configuration to compiler environment filters.
+func toEnvFilters(src []*EnvFilter) []*compiler.EnvFilter {
+	var dst []*compiler.EnvFilter
+	for _, f := range src {
+		dst = append(dst, &compiler.EnvFilter{
+			Images:   f.Images,
+			Allow:    f.Allow,
+			Deny:     f.Deny,
+			Override: f.Override,
+		})
+	}
+	return dst
+}
+
 // Register the daemon command.
 func Register(app *kingpin.Application) {
 	c := new(daemonCommand)
@@ -247,3 +1433,240 @@ func Register(app *kingpin.Application) {
 		Default("").
 		StringVar(&c.envfile)
 }
+
+// helper function returns the templates of the objects created
+// in the namespace of isolated pipelines.
+func toIsolation(config Config) engine.Isolation {
+	return engine.Isolation{
+		Quota:      config.Isolation.Quota,
+		LimitRange: config.Isolation.LimitRange,
+		Role:       config.Isolation.Role,
+	}
+}
+
+// helper function returns the package mirrors. The mirror
+// hostnames are resolved once, when the runner starts, and are
+// added to the hosts file of the pipeline pods.
+func toMirrors(config Config) compiler.Mirrors {
+	mirrors := compiler.Mirrors{
+		NPM:     config.Mirrors.NPM,
+		PyPI:    config.Mirrors.PyPI,
+		GoProxy: config.Mirrors.GoProxy,
+		Apt:     config.Mirrors.Apt,
+	}
+	if !config.Mirrors.Resolve {
+		return mirrors
+	}
+	for _, hostname := range mirrors.Hostnames() {
+		if net.ParseIP(hostname) != nil {
+			continue
+		}
+		addrs, err := net.LookupHost(hostname)
+		if err != nil || len(addrs) == 0 {
+			logrus.WithError(err).
+				WithField("hostname", hostname).
+				Warnln("cannot resolve package mirror")
+			continue
+		}
+		if mirrors.Hosts == nil {
+			mirrors.Hosts = map[string]string{}
+		}
+		mirrors.Hosts[hostname] = addrs[0]
+	}
+	return mirrors
+}
+
+func toProxyCache(config Config) compiler.ProxyCache {
+	return compiler.ProxyCache{
+		Node: config.ProxyCache.Node,
+		Go: compiler.Proxy{
+			Image: config.ProxyCache.GoImage,
+			Port:  config.ProxyCache.GoPort,
+			Envs:  config.ProxyCache.GoEnv,
+		},
+		PyPI: compiler.Proxy{
+			Image: config.ProxyCache.PyPIImage,
+			Port:  config.ProxyCache.PyPIPort,
+			Path:  config.ProxyCache.PyPIPath,
+			Envs:  config.ProxyCache.PyPIEnv,
+		},
+		NPM: compiler.Proxy{
+			Image: config.ProxyCache.NPMImage,
+			Port:  config.ProxyCache.NPMPort,
+			Envs:  config.ProxyCache.NPMEnv,
+		},
+	}
+}
+
+func toSecurityContext(config Config) engine.SecurityContext {
+	uid, gid := engine.ParseUser(config.Security.User)
+	sc := engine.SecurityContext{
+		RunAsUser:        uid,
+		RunAsGroup:       gid,
+		RunAsNonRoot:     config.Security.NonRoot,
+		SeccompProfile:   config.Security.Seccomp,
+		DropCapabilities: config.Security.DropCapabilities,
+	}
+	if config.Security.FSGroup != 0 {
+		sc.FSGroup = &config.Security.FSGroup
+	}
+	if config.Security.NoPrivilegeEscalation {
+		sc.AllowPrivilegeEscalation = new(bool)
+	}
+	return sc
+}
+
+// helper function returns the registry provider that mints
+// short-lived credentials for the cloud provider registries,
+// using the cloud provider identity of the runner.
+func toCloudRegistry(config Config) registry.Provider {
+	var providers []registry.Provider
+	if config.Registry.ECRRegion != "" {
+		providers = append(providers, cloudauth.ECR(
+			config.Registry.ECRRegion,
+			config.Registry.ECRRegistryIDs,
+		))
+	}
+	if len(config.Registry.GCRHosts) != 0 {
+		providers = append(providers, cloudauth.GCR(
+			config.Registry.GCRHosts,
+		))
+	}
+	if len(config.Registry.ACRHosts) != 0 {
+		providers = append(providers, cloudauth.ACR(
+			config.Registry.ACRHosts,
+			config.Registry.ACRClientID,
+		))
+	}
+	return registry.Combine(providers...)
+}
+
+// helper function returns the provider that mints short-lived
+// registry credentials for publish steps, or nil if disabled.
+func toRobotProvider(config Config) engine.RobotProvider {
+	switch {
+	case config.Robot.HarborAddress != "":
+		return harbor.New(harbor.Config{
+			Address:  config.Robot.HarborAddress,
+			Username: config.Robot.HarborUsername,
+			Password: config.Robot.HarborPassword,
+			Duration: config.Robot.HarborDuration,
+		})
+	case config.Robot.ECRRoleARN != "":
+		return cloudauth.ECRRobots(
+			config.Robot.ECRRegion,
+			config.Robot.ECRRoleARN,
+		)
+	default:
+		return nil
+	}
+}
+
+// helper function returns the images of the publish steps
+// that receive short-lived registry credentials. Credentials
+// are not requested if no provider is configured.
+func toRobotImages(config Config) []string {
+	if toRobotProvider(config) == nil {
+		return nil
+	}
+	return config.Robot.Images
+}
+
+// helper function returns the observer that posts the stage
+// lifecycle events to the webhook endpoint, or nil if disabled.
+func toStageObserver(ctx context.Context, config Config) runtime.StageObserver {
+	if config.Lifecycle.StageEndpoint == "" {
+		return nil
+	}
+	return webhook.NewStages(
+		ctx,
+		config.Lifecycle.StageEndpoint,
+		config.Lifecycle.StageToken,
+		config.Lifecycle.StageFormat,
+		config.Lifecycle.SkipVerify,
+	)
+}
+
+// helper function returns the engine. The runner connects to
+// the cluster in which it runs, unless a kubeconfig is
+// provided, in which case the runner can connect to a remote
+// cluster through a tunnel.
+func toEngine(config Config) (*engine.Kubernetes, error) {
+	switch {
+	case config.Cluster.Config == "":
+		return engine.NewInCluster()
+	case config.Cluster.Tunnel == "":
+		return engine.NewFromConfig(config.Cluster.Config)
+	}
+	tlsConfig, err := tlsconfig.New(tlsconfig.Config{
+		CAFile: config.Cluster.TunnelCA,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if config.Cluster.TunnelCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.Cluster.TunnelCert, config.Cluster.TunnelKey)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			tlsConfig = new(tls.Config)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	dial, err := tunnel.New(config.Cluster.Tunnel, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return engine.NewFromTunnel(config.Cluster.Config, dial)
+}
+
+// helper function returns the flaky step tracker, or nil if
+// flaky step tracking is disabled.
+func toFlaky(config Config) *flaky.Tracker {
+	if !config.Flaky.Enabled {
+		return nil
+	}
+	return flaky.New(config.Flaky.Path, config.Flaky.Max)
+}
+
+// helper function returns the synthetic failures injected in
+// chaos mode.
+func toChaos(config Config) engine.Chaos {
+	return engine.Chaos{
+		APIErrors:        config.Chaos.APIErrors,
+		WatchDisconnects: config.Chaos.WatchDisconnects,
+		SlowPulls:        config.Chaos.SlowPulls,
+		Evictions:        config.Chaos.Evictions,
+		MaxDelay:         config.Chaos.MaxDelay,
+		Seed:             config.Chaos.Seed,
+	}
+}
+
+// helper function returns the stage result cache, or nil if
+// result caching is disabled.
+func toResults(config Config) *results.Store {
+	if !config.Results.Enabled {
+		return nil
+	}
+	return results.New(
+		config.Results.Path,
+		results.Scope(config.Results.Scope),
+		config.Results.Events,
+		config.Results.TTL,
+		config.Results.Max,
+	)
+}
+
+// helper function returns the pipeline timeouts, with the
+// policies that override the timeouts of matching
+// repositories.
+func toTimeouts(config Config) *timeouts.Table {
+	return timeouts.New(timeouts.Timeouts{
+		Schedule: config.Timeouts.Schedule,
+		Pull:     config.Timeouts.Pull,
+		Step:     config.Timeouts.Step,
+		Stage:    config.Timeouts.Stage,
+		Teardown: config.Timeouts.Teardown,
+	}, config.Timeouts.Policies)
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine/compiler"
+	"github.com/drone-runners/drone-runner-kube/runtime"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestToProfilePoller(t *testing.T) {
+	c := &compiler.Compiler{
+		Namespace:     "default",
+		NamespacePool: compiler.NewNamespacePool([]string{"pool-a", "pool-b"}),
+		NodeSelector:  map[string]string{"pool": "default"},
+	}
+	c.Resources.Limits.CPU = 1000
+	poller := &runtime.Poller{
+		Runner: &runtime.Runner{Compiler: c},
+	}
+
+	tests := []struct {
+		profile   *RunnerProfile
+		namespace string
+		pool      bool
+		selector  map[string]string
+		limitCPU  int64
+	}{
+		// the runner configuration is used if the profile
+		// does not override it.
+		{
+			profile:   &RunnerProfile{Name: "default", Labels: map[string]string{"size": "small"}},
+			namespace: "default",
+			pool:      true,
+			selector:  map[string]string{"pool": "default"},
+			limitCPU:  1000,
+		},
+		{
+			profile: &RunnerProfile{
+				Name:         "gpu",
+				Labels:       map[string]string{"size": "gpu"},
+				Namespace:    "gpu",
+				NodeSelector: map[string]string{"pool": "gpu"},
+				LimitCPU:     4000,
+			},
+			namespace: "gpu",
+			pool:      false,
+			selector:  map[string]string{"pool": "gpu"},
+			limitCPU:  4000,
+		},
+	}
+	for _, test := range tests {
+		got := toProfilePoller(poller, test.profile)
+		c := got.Runner.Compiler
+		if c.Namespace != test.namespace {
+			t.Errorf("%s: Want namespace %s, got %s", test.profile.Name, test.namespace, c.Namespace)
+		}
+		if got := c.NamespacePool != nil; got != test.pool {
+			t.Errorf("%s: Want namespace pool %v, got %v", test.profile.Name, test.pool, got)
+		}
+		if diff := cmp.Diff(test.selector, c.NodeSelector); diff != "" {
+			t.Errorf("%s: Unexpected node selector", test.profile.Name)
+			t.Log(diff)
+		}
+		if c.Resources.Limits.CPU != test.limitCPU {
+			t.Errorf("%s: Want cpu limit %d, got %d", test.profile.Name, test.limitCPU, c.Resources.Limits.CPU)
+		}
+		if diff := cmp.Diff(test.profile.Labels, got.Filter.Labels); diff != "" {
+			t.Errorf("%s: Want stages filtered by the profile labels", test.profile.Name)
+			t.Log(diff)
+		}
+	}
+
+	// the profiles do not modify the shared runner.
+	if poller.Runner.Compiler.Namespace != "default" || poller.Runner.Compiler.Resources.Limits.CPU != 1000 {
+		t.Errorf("Want runner compiler not modified by the profiles")
+	}
+}
+
+func TestToNamespaces(t *testing.T) {
+	config := Config{}
+	config.Namespace.Default = "default"
+	config.Namespace.Pool = []string{"pool-a", "pool-b"}
+	config.Namespace.Rules = map[string][]string{
+		"team-b": nil,
+		"team-a": nil,
+		"gpu":    nil,
+	}
+	config.Profiles.List = []*RunnerProfile{
+		{Name: "gpu", Namespace: "gpu"},
+		{Name: "small"},
+		{Name: "pool", Namespace: "pool-a"},
+	}
+
+	want := []string{"default", "pool-a", "pool-b", "gpu", "team-a", "team-b"}
+	if diff := cmp.Diff(want, toNamespaces(config)); diff != "" {
+		t.Errorf("Unexpected namespaces")
+		t.Log(diff)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/runner-go/client"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type doctorCommand struct {
+	envfile    string
+	kubeconfig string
+	timeout    time.Duration
+}
+
+func (c *doctorCommand) run(*kingpin.ParseContext) error {
+	// load environment variables from file.
+	godotenv.Load(c.envfile)
+
+	// load the configuration from the environment
+	config, err := fromEnviron()
+	if err != nil {
+		return err
+	}
+
+	var kube *engine.Kubernetes
+	if c.kubeconfig != "" {
+		kube, err = engine.NewFromConfig(c.kubeconfig)
+	} else {
+		kube, err = engine.NewInCluster()
+	}
+	if err != nil {
+		return err
+	}
+
+	namespaces := []string{config.Namespace.Default}
+	for name := range config.Namespace.Rules {
+		if name != config.Namespace.Default {
+			namespaces = append(namespaces, name)
+		}
+	}
+	for _, name := range config.Namespace.Pool {
+		if name != config.Namespace.Default {
+			namespaces = append(namespaces, name)
+		}
+	}
+
+	var results []*engine.Diagnostic
+	for _, namespace := range namespaces {
+		results = append(results, kube.Diagnose(namespace)...)
+	}
+	results = append(results, c.ping(config))
+
+	var failed bool
+	for _, result := range results {
+		switch {
+		case !result.Passed:
+			failed = true
+			fmt.Printf("[FAIL] %s: %s\n", result.Name, result.Message)
+		case result.Warning:
+			fmt.Printf("[WARN] %s: %s\n", result.Name, result.Message)
+		default:
+			fmt.Printf("[ OK ] %s\n", result.Name)
+			continue
+		}
+		if result.Fix != "" {
+			fmt.Printf("       fix: %s\n", result.Fix)
+		}
+	}
+	if failed {
+		return errors.New("doctor: one or more checks failed")
+	}
+	return nil
+}
+
+// helper function checks the runner can reach the remote
+// server.
+func (c *doctorCommand) ping(config Config) *engine.Diagnostic {
+	check := &engine.Diagnostic{
+		Name:   fmt.Sprintf("can reach the server at %s", config.Client.Address),
+		Passed: true,
+	}
+	cli := client.New(
+		config.Client.Address,
+		config.Client.Secret,
+		config.Client.SkipVerify,
+	)
+	ctx, cancel := context.WithTimeout(nocontext, c.timeout)
+	defer cancel()
+	if err := cli.Ping(ctx, config.Runner.Name); err != nil {
+		check.Passed = false
+		check.Message = err.Error()
+		check.Fix = "verify DRONE_RPC_PROTO, DRONE_RPC_HOST and DRONE_RPC_SECRET, and that network policies permit egress to the server"
+	}
+	return check
+}
+
+// RegisterDoctor registers the doctor command.
+func RegisterDoctor(app *kingpin.Application) {
+	c := new(doctorCommand)
+
+	cmd := app.Command("doctor", "validates the runner environment").
+		Action(c.run)
+
+	cmd.Arg("envfile", "load the environment variable file").
+		Default("").
+		StringVar(&c.envfile)
+
+	cmd.Flag("kubeconfig", "path to the kubeconfig file, used when running outside the cluster").
+		StringVar(&c.kubeconfig)
+
+	cmd.Flag("timeout", "timeout for network checks").
+		Default("10s").
+		DurationVar(&c.timeout)
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/signal"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type reaperCommand struct {
+	envfile    string
+	kubeconfig string
+	namespaces []string
+	interval   time.Duration
+}
+
+func (c *reaperCommand) run(*kingpin.ParseContext) error {
+	// load environment variables from file.
+	godotenv.Load(c.envfile)
+
+	// load the configuration from the environment
+	config, err := fromEnviron()
+	if err != nil {
+		return err
+	}
+
+	// setup the global logrus logger.
+	setupLogger(config)
+
+	var kube *engine.Kubernetes
+	if c.kubeconfig != "" {
+		kube, err = engine.NewFromConfig(c.kubeconfig)
+	} else {
+		kube, err = engine.NewInCluster()
+	}
+	if err != nil {
+		return err
+	}
+
+	namespaces := c.namespaces
+	if len(namespaces) == 0 {
+		namespaces = toNamespaces(config)
+	}
+
+	ctx := signal.WithContext(nocontext)
+	logrus.WithField("namespaces", namespaces).
+		Infoln("starting the heartbeat reaper")
+	kube.ReapStale(ctx, namespaces, config.Labels.Prefix, c.interval)
+	return nil
+}
+
+// RegisterReaper registers the reaper command, which deletes
+// the pipeline pods whose heartbeat is stale. The reaper runs
+// separately from the runners, so that pods are deleted even
+// if every runner crashed.
+func RegisterReaper(app *kingpin.Application) {
+	c := new(reaperCommand)
+
+	cmd := app.Command("reaper", "deletes pipeline pods with a stale heartbeat").
+		Action(c.run)
+
+	cmd.Arg("envfile", "load the environment variable file").
+		Default("").
+		StringVar(&c.envfile)
+
+	cmd.Flag("kubeconfig", "path to the kubeconfig file, used when running outside the cluster").
+		StringVar(&c.kubeconfig)
+
+	cmd.Flag("namespace", "namespace of the pipeline pods, defaults to the runner namespaces").
+		StringsVar(&c.namespaces)
+
+	cmd.Flag("interval", "interval at which the pipeline pods are checked").
+		Default("1m").
+		DurationVar(&c.interval)
+}
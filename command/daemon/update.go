@@ -0,0 +1,99 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/internal/update"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// helper function returns the updater used to update the
+// runner deployment.
+func newUpdater(config Config) (*update.Updater, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &update.Updater{
+		Client:     client,
+		Namespace:  config.Update.Namespace,
+		ConfigMap:  config.Update.ConfigMap,
+		Deployment: config.Update.Deployment,
+		Container:  config.Update.Container,
+		Interval:   config.Update.Interval,
+	}, nil
+}
+
+// helper function waits for runner updates, and updates the
+// runner deployment, retrying failed updates on the update
+// interval. The runner does not drain when the update is
+// available, because every replica observes the update at
+// the same time. Instead the deployment controller replaces
+// the runner pods, and each runner drains when it receives
+// the termination signal. The deployment should configure a
+// termination grace period that exceeds the drain timeout.
+//
+// In-flight stages are not handed off to peer runners. The
+// runner waits for the in-flight stages to complete, and the
+// stages that do not complete within the drain timeout are
+// cancelled.
+func selfUpdate(ctx context.Context, updater *update.Updater) error {
+	updater.Run(ctx)
+	return nil
+}
+
+// helper function drains the runner when the runner receives
+// the termination signal, for example when the runner pod is
+// replaced by the deployment controller. The runner stops
+// polling for new stages, which are picked up by peer runners,
+// and waits for in-flight stages to complete before the
+// runner exits.
+func drainOnSignal(ctx context.Context, config Config, terminate <-chan struct{}, drain func(), drained <-chan struct{}, cancel func()) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-terminate:
+	}
+
+	logrus.Infoln("runner terminating, draining")
+	drain()
+
+	select {
+	case <-ctx.Done():
+	case <-drained:
+	case <-time.After(config.Update.DrainTimeout):
+		logrus.WithField("timeout", config.Update.DrainTimeout).
+			Warnln("runner drain timeout exceeded")
+	}
+	cancel()
+}
+
+// helper function returns a channel that is closed when the
+// runner receives a termination signal.
+func notifyTerminate() <-chan struct{} {
+	terminate := make(chan struct{})
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(c)
+		<-c
+		println("received signal, draining the runner")
+		close(terminate)
+	}()
+	return terminate
+}
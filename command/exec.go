@@ -11,24 +11,13 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/drone-runners/drone-runner-kube/command/internal"
-	"github.com/drone-runners/drone-runner-kube/engine"
-	"github.com/drone-runners/drone-runner-kube/engine/compiler"
-	"github.com/drone-runners/drone-runner-kube/engine/linter"
-	"github.com/drone-runners/drone-runner-kube/engine/resource"
-	"github.com/drone-runners/drone-runner-kube/runtime"
+	"github.com/drone-runners/drone-runner-kube/kube"
 	"github.com/drone/drone-go/drone"
-	"github.com/drone/envsubst"
-	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/logger"
-	"github.com/drone/runner-go/manifest"
-	"github.com/drone/runner-go/pipeline"
 	"github.com/drone/runner-go/pipeline/console"
-	"github.com/drone/runner-go/registry"
-	"github.com/drone/runner-go/secret"
 	"github.com/drone/signal"
 
 	"github.com/mattn/go-isatty"
@@ -68,124 +57,17 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		kubeconfig = filepath.Join(dir, ".kube", "config")
 	}
 
-	envs := environ.Combine(
-		c.Environ,
-		environ.System(c.System),
-		environ.Repo(c.Repo),
-		environ.Build(c.Build),
-		environ.Stage(c.Stage),
-		environ.Link(c.Repo, c.Build, c.System),
-		c.Build.Params,
-	)
-
-	// string substitution function ensures that string
-	// replacement variables are escaped and quoted if they
-	// contain newlines.
-	subf := func(k string) string {
-		v := envs[k]
-		if strings.Contains(v, "\n") {
-			v = fmt.Sprintf("%q", v)
-		}
-		return v
-	}
-
-	// evaluates string replacement expressions and returns an
-	// update configuration.
-	config, err := envsubst.Eval(string(rawsource), subf)
-	if err != nil {
-		return err
-	}
-
-	// parse and lint the configuration.
-	manifest, err := manifest.ParseString(config)
-	if err != nil {
-		return err
-	}
-
-	// a configuration can contain multiple pipelines.
-	// get a specific pipeline resource for execution.
-	resource, err := resource.Lookup(c.Stage.Name, manifest)
-	if err != nil {
-		return err
-	}
-
-	// lint the pipeline and return an error if any
-	// linting rules are broken
-	lint := linter.New(nil)
-	opts := linter.Opts{Trusted: c.Repo.Trusted}
-	err = lint.Lint(resource, opts)
-	if err != nil {
-		return err
-	}
-
-	// compile the pipeline to an intermediate representation.
-	comp := &compiler.Compiler{
+	runner, err := kube.New(kube.Options{
+		Kubeconfig: kubeconfig,
+		Namespace:  c.Namespace,
 		Environ:    c.Environ,
 		Labels:     c.Labels,
-		Privileged: append(c.Privileged, compiler.Privileged...),
-		Secret:     secret.StaticVars(c.Secrets),
-		Registry:   registry.Combine(),
-		Namespace:  c.Namespace,
-	}
-
-	args := compiler.Args{
-		Pipeline: resource,
-		Manifest: manifest,
-		Build:    c.Build,
-		Netrc:    c.Netrc,
-		Repo:     c.Repo,
-		Stage:    c.Stage,
-		System:   c.System,
-		Secret:   secret.StaticVars(c.Secrets),
-	}
-	spec := comp.Compile(nocontext, args)
-
-	// include only steps that are in the include list,
-	// if the list in non-empty.
-	if len(c.Include) > 0 {
-	I:
-		for _, step := range spec.Steps {
-			if step.Name == "clone" {
-				continue
-			}
-			for _, name := range c.Include {
-				if step.Name == name {
-					continue I
-				}
-			}
-			step.RunPolicy = engine.RunNever
-		}
-	}
-
-	// exclude steps that are in the exclude list,
-	// if the list in non-empty.
-	if len(c.Exclude) > 0 {
-	E:
-		for _, step := range spec.Steps {
-			if step.Name == "clone" {
-				continue
-			}
-			for _, name := range c.Exclude {
-				if step.Name == name {
-					step.RunPolicy = engine.RunNever
-					continue E
-				}
-			}
-		}
-	}
-
-	// create a step object for each pipeline step.
-	for _, step := range spec.Steps {
-		if step.RunPolicy == engine.RunNever {
-			continue
-		}
-		c.Stage.Steps = append(c.Stage.Steps, &drone.Step{
-			StageID:   c.Stage.ID,
-			Number:    len(c.Stage.Steps) + 1,
-			Name:      step.Name,
-			Status:    drone.StatusPending,
-			ErrIgnore: step.IgnoreErr,
-		})
+		Privileged: c.Privileged,
+		Secrets:    c.Secrets,
+		Procs:      c.Procs,
+	})
+	if err != nil {
+		return err
 	}
 
 	// configures the pipeline timeout.
@@ -200,15 +82,6 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		cancel()
 	})
 
-	state := &pipeline.State{
-		Build:  c.Build,
-		Stage:  c.Stage,
-		Repo:   c.Repo,
-		System: c.System,
-	}
-	state.Build.Status = drone.StatusRunning
-	state.Stage.Status = drone.StatusRunning
-
 	// enable debug logging
 	logrus.SetLevel(logrus.WarnLevel)
 	if c.Debug {
@@ -223,19 +96,21 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
-	// change to out-of-cluster for local testing
-	engine, err := engine.NewFromConfig(kubeconfig)
-	if err != nil {
+	state, err := runner.Run(ctx, &kube.Request{
+		Source:   rawsource,
+		Repo:     c.Repo,
+		Build:    c.Build,
+		Stage:    c.Stage,
+		System:   c.System,
+		Netrc:    c.Netrc,
+		Include:  c.Include,
+		Exclude:  c.Exclude,
+		Streamer: console.New(c.Pretty),
+	})
+	if state == nil {
 		return err
 	}
 
-	err = runtime.NewExecer(
-		pipeline.NopReporter(),
-		console.New(c.Pretty),
-		engine,
-		c.Procs,
-	).Exec(ctx, spec, state)
-
 	if c.Dump {
 		dump(state)
 	}
@@ -0,0 +1,65 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type schemaCommand struct{}
+
+func (c *schemaCommand) run(*kingpin.ParseContext) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(resource.NewSchema())
+}
+
+type validateCommand struct {
+	Sources []string
+}
+
+func (c *validateCommand) run(*kingpin.ParseContext) error {
+	var invalid bool
+	for _, source := range c.Sources {
+		raw, err := ioutil.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		for _, err := range resource.Validate(raw) {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", source, err)
+			invalid = true
+		}
+	}
+	if invalid {
+		return errors.New("validation failed")
+	}
+	return nil
+}
+
+func registerSchema(app *kingpin.Application) {
+	c := new(schemaCommand)
+
+	app.Command("schema", "output the json schema of the pipeline").
+		Action(c.run)
+}
+
+func registerValidate(app *kingpin.Application) {
+	c := new(validateCommand)
+
+	cmd := app.Command("validate", "validate the yaml files against the pipeline schema").
+		Action(c.run)
+
+	cmd.Arg("source", "source file location").
+		Default(".drone.yml").
+		StringsVar(&c.Sources)
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// CodeAdmissionRejected is the error code of pipelines that are
+// rejected by the cluster admission policies.
+const CodeAdmissionRejected ErrorCode = "ADMISSION_REJECTED"
+
+// pattern matches the container fields in the admission causes,
+// for example spec.containers[0].securityContext.
+var containerField = regexp.MustCompile(`^spec\.(initContainers|containers)\[(\d+)\]`)
+
+// ValidateAdmission submits the pipeline pod to the admission
+// chain of the cluster as a server-side dry run before the
+// pipeline is created, so that pipelines rejected by admission
+// policies, for example pod security standards or gatekeeper
+// constraints, fail with a readable error.
+func (k *Kubernetes) ValidateAdmission() {
+	k.admission = true
+}
+
+// Validate submits the pipeline pod to the admission chain
+// without persisting the pod, and returns an error describing
+// why the pod was rejected. The pipeline is not rejected if
+// the dry run cannot be completed for other reasons.
+func (k *Kubernetes) Validate(ctx context.Context, spec *Spec) error {
+	// the namespace of isolated pipelines does not exist
+	// until the pipeline is setup.
+	if !k.admission || spec.PodSpec.Isolated {
+		return nil
+	}
+	k, err := k.forCluster(spec)
+	if err != nil {
+		return err
+	}
+	pod, err := toTemplatePod(spec)
+	if err != nil {
+		return err
+	}
+	err = k.dryRun(ctx, spec, pod)
+	if err == nil || CodeOf(err) == CodeAdmissionRejected {
+		return err
+	}
+	logrus.WithError(err).
+		WithField("pod", spec.PodSpec.Name).
+		Warnln("cannot validate pod admission")
+	return nil
+}
+
+// DryRun submits the pipeline pod to the admission chain as a
+// server-side dry run, without persisting the pod. Returns an
+// error with the admission rejected code if the pod is rejected
+// by the admission policies, or the api server error if the
+// dry run cannot be completed.
+func (k *Kubernetes) DryRun(ctx context.Context, spec *Spec) error {
+	pod, err := toTemplatePod(spec)
+	if err != nil {
+		return err
+	}
+	return k.dryRun(ctx, spec, pod)
+}
+
+func (k *Kubernetes) dryRun(ctx context.Context, spec *Spec, pod *v1.Pod) error {
+	err := k.call(ctx, func(ctx context.Context) error {
+		return k.coreV1(ctx).RESTClient().Post().
+			Namespace(spec.PodSpec.Namespace).
+			Resource("pods").
+			VersionedParams(&metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}, scheme.ParameterCodec).
+			Body(pod).
+			Do().
+			Error()
+	})
+	if err == nil {
+		return nil
+	}
+	if !kerrors.IsForbidden(err) && !kerrors.IsInvalid(err) && !kerrors.IsBadRequest(err) {
+		return err
+	}
+	return &Error{
+		Code: CodeAdmissionRejected,
+		Err:  toAdmissionError(spec, pod, err),
+	}
+}
+
+// helper function converts the admission rejection to a
+// readable error. Containers are referenced by step name
+// instead of the generated container name.
+func toAdmissionError(spec *Spec, pod *v1.Pod, err error) error {
+	steps := map[string]string{}
+	for _, step := range append(spec.Init, spec.Steps...) {
+		steps[step.ID] = step.Name
+	}
+	replacer := toStepReplacer(steps)
+
+	var lines []string
+	if status, ok := err.(kerrors.APIStatus); ok {
+		if details := status.Status().Details; details != nil {
+			for _, cause := range details.Causes {
+				line := cause.Message
+				if cause.Field != "" {
+					line = cause.Field + ": " + line
+				}
+				if name := toFieldStep(pod, steps, cause.Field); name != "" {
+					line = "step " + name + ": " + line
+				}
+				lines = append(lines, replacer.Replace(line))
+			}
+		}
+	}
+	msg := "linter: pipeline rejected by the cluster admission policy: " + replacer.Replace(err.Error())
+	if len(lines) == 0 {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%s\n  %s", msg, strings.Join(lines, "\n  "))
+}
+
+// helper function returns the name of the step that the field
+// of the admission cause refers to, or an empty string if the
+// field does not refer to a step container.
+func toFieldStep(pod *v1.Pod, steps map[string]string, field string) string {
+	match := containerField.FindStringSubmatch(field)
+	if match == nil {
+		return ""
+	}
+	i, _ := strconv.Atoi(match[2])
+	containers := pod.Spec.Containers
+	if match[1] == "initContainers" {
+		containers = pod.Spec.InitContainers
+	}
+	if i >= len(containers) {
+		return ""
+	}
+	return steps[containers[i].Name]
+}
+
+// helper function returns a replacer that replaces quoted
+// container names with the step name, for example in pod
+// security violations that reference the container name.
+func toStepReplacer(steps map[string]string) *strings.Replacer {
+	var pairs []string
+	for id, name := range steps {
+		pairs = append(pairs,
+			`container "`+id+`"`, `step "`+name+`"`,
+			`"`+id+`"`, `"`+name+`"`,
+			id, name,
+		)
+	}
+	return strings.NewReplacer(pairs...)
+}
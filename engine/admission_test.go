@@ -0,0 +1,45 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestToAdmissionError(t *testing.T) {
+	spec := &Spec{
+		Init:  []*Step{{ID: "drone-init", Name: "clone"}},
+		Steps: []*Step{{ID: "drone-abc", Name: "build"}},
+	}
+	pod := &v1.Pod{}
+	pod.Spec.InitContainers = []v1.Container{{Name: "drone-init"}}
+	pod.Spec.Containers = []v1.Container{{Name: "drone-abc"}}
+
+	err := kerrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, "drone-pod", field.ErrorList{
+		field.Forbidden(field.NewPath("spec", "containers").Index(0).Child("securityContext", "privileged"), "privileged containers are not allowed"),
+	})
+	got := toAdmissionError(spec, pod, err).Error()
+	if !strings.HasPrefix(got, "linter: pipeline rejected by the cluster admission policy: ") {
+		t.Errorf("Want lint error, got %s", got)
+	}
+	if want := "step build: spec.containers[0].securityContext.privileged: Forbidden: privileged containers are not allowed"; !strings.Contains(got, want) {
+		t.Errorf("Want cause %q referencing the step, got %s", want, got)
+	}
+
+	err = kerrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "drone-pod", errors.New(
+		`violates PodSecurity "restricted:latest": allowPrivilegeEscalation != false (container "drone-abc" must set securityContext.allowPrivilegeEscalation=false)`,
+	))
+	got = toAdmissionError(spec, pod, err).Error()
+	if want := `(step "build" must set securityContext.allowPrivilegeEscalation=false)`; !strings.Contains(got, want) {
+		t.Errorf("Want container name replaced with step name, got %s", got)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Chaos defines the synthetic failures injected by the engine
+// in chaos mode. The rates are probabilities between 0 and 1.
+// Chaos mode is intended to validate the resilience of the
+// runner in test clusters, and must not be enabled in
+// production.
+type Chaos struct {
+	// APIErrors provides the rate of kubernetes api requests
+	// that fail with an internal server error.
+	APIErrors float64
+
+	// WatchDisconnects provides the rate of watches that are
+	// disconnected after a random delay.
+	WatchDisconnects float64
+
+	// SlowPulls provides the rate of steps that are delayed
+	// by a random delay before the step starts, as if the
+	// step image was pulled slowly.
+	SlowPulls float64
+
+	// Evictions provides the rate of steps during which the
+	// pipeline pod is evicted after a random delay.
+	Evictions float64
+
+	// MaxDelay provides the maximum random delay of the
+	// watch disconnects, slow pulls and evictions.
+	MaxDelay time.Duration
+
+	// Seed provides the seed of the faults, so that a failing
+	// run can be reproduced. The seed is random if zero.
+	Seed int64
+}
+
+// chaos injects the synthetic failures.
+type chaos struct {
+	policy Chaos
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// InjectFaults enables chaos mode, in which the engine injects
+// synthetic failures at the configured rates: kubernetes api
+// errors, watch disconnects, slow image pulls and pod
+// evictions. The engine clients are replaced with clients that
+// inject the api faults.
+func (k *Kubernetes) InjectFaults(policy Chaos) error {
+	if policy.Seed == 0 {
+		policy.Seed = time.Now().UnixNano()
+	}
+	c := &chaos{
+		policy: policy,
+		rand:   rand.New(rand.NewSource(policy.Seed)),
+	}
+	clientset, dynamicset, err := c.clients(k.config)
+	if err != nil {
+		return err
+	}
+	k.client = clientset
+	k.dynamic = dynamicset
+	k.chaos = c
+	logrus.WithField("seed", policy.Seed).
+		Warnln("chaos mode enabled, synthetic failures are injected")
+	return nil
+}
+
+// helper function returns the clients of the api server that
+// inject the api faults. The exec and log streams, which are
+// upgraded connections, are not affected.
+func (c *chaos) clients(config *rest.Config) (*kubernetes.Clientset, dynamic.Interface, error) {
+	config = rest.CopyConfig(config)
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &chaosTransport{chaos: c, next: rt}
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	dynamicset, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientset, dynamicset, nil
+}
+
+// helper function returns true if the fault is injected, at
+// the rate of the fault. A nil chaos injects no faults.
+func (c *chaos) inject(fault string, rate float64) bool {
+	if c == nil || rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	injected := c.rand.Float64() < rate
+	c.mu.Unlock()
+	if injected {
+		chaosFaults.Inc(fault)
+	}
+	return injected
+}
+
+// helper function returns a random delay up to the maximum
+// delay.
+func (c *chaos) delay() time.Duration {
+	if c.policy.MaxDelay <= 0 {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Duration(c.rand.Int63n(int64(c.policy.MaxDelay)))
+}
+
+// helper function delays the step before the step starts, as
+// if the step image was pulled slowly.
+func (c *chaos) slowPull(ctx context.Context, step *Step, output io.Writer) error {
+	if !c.inject("slow_pull", c.policy.SlowPulls) {
+		return nil
+	}
+	delay := c.delay()
+	fmt.Fprintf(output, "[chaos] simulating a slow image pull, delaying the step by %s\n", delay)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// helper function evicts the pipeline pod after a random delay
+// while the step is running, and returns a function that
+// cancels the eviction once the step exits.
+func (k *Kubernetes) evictRandomly(spec *Spec, step *Step, output io.Writer) func() {
+	c := k.chaos
+	if !c.inject("eviction", c.policy.Evictions) {
+		return func() {}
+	}
+	delay := c.delay()
+	timer := time.AfterFunc(delay, func() {
+		fmt.Fprintf(output, "[chaos] evicting the pipeline pod\n")
+		ctx, cancel := k.deadline(context.Background())
+		defer cancel()
+		err := k.policyV1beta1(ctx).Evictions(spec.PodSpec.Namespace).Evict(&policyv1beta1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: spec.PodSpec.Namespace,
+				Name:      spec.PodSpec.Name,
+			},
+		})
+		if err != nil {
+			logrus.WithError(err).
+				WithField("pod", spec.PodSpec.Name).
+				WithField("step", step.Name).
+				Warnln("chaos: cannot evict the pipeline pod")
+		}
+	})
+	return func() { timer.Stop() }
+}
+
+// chaosTransport injects api errors and watch disconnects
+// into the api requests.
+type chaosTransport struct {
+	chaos *chaos
+	next  http.RoundTripper
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Upgrade") != "" {
+		return t.next.RoundTrip(req)
+	}
+	if isWatch(req) {
+		res, err := t.next.RoundTrip(req)
+		if err != nil || res.StatusCode != http.StatusOK {
+			return res, err
+		}
+		if t.chaos.inject("watch_disconnect", t.chaos.policy.WatchDisconnects) {
+			res.Body = newDisconnectBody(res.Body, t.chaos.delay())
+		}
+		return res, nil
+	}
+	if t.chaos.inject("api_error", t.chaos.policy.APIErrors) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return toInternalError(req), nil
+	}
+	return t.next.RoundTrip(req)
+}
+
+// helper function returns true if the request is a watch.
+func isWatch(req *http.Request) bool {
+	switch req.URL.Query().Get("watch") {
+	case "true", "1":
+		return true
+	}
+	return false
+}
+
+// helper function returns an internal server error response
+// to the request, with the status body returned by the api
+// server, so that the error is decoded as an api error.
+func toInternalError(req *http.Request) *http.Response {
+	body := `{"kind":"Status","apiVersion":"v1","metadata":{},"status":"Failure","message":"chaos: injected internal error","reason":"InternalError","code":500}`
+	return &http.Response{
+		Status:        "500 Internal Server Error",
+		StatusCode:    http.StatusInternalServerError,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// disconnectBody closes the watch stream after the delay, as
+// if the connection to the api server was lost.
+type disconnectBody struct {
+	io.ReadCloser
+	timer *time.Timer
+}
+
+func newDisconnectBody(body io.ReadCloser, delay time.Duration) io.ReadCloser {
+	return &disconnectBody{
+		ReadCloser: body,
+		timer:      time.AfterFunc(delay, func() { body.Close() }),
+	}
+}
+
+func (b *disconnectBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// roundTripFunc implements a stub http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// blockingBody blocks reads until the body is closed.
+type blockingBody struct {
+	closed chan struct{}
+}
+
+func (b *blockingBody) Read(p []byte) (int, error) {
+	<-b.closed
+	return 0, context.Canceled
+}
+
+func (b *blockingBody) Close() error {
+	select {
+	case <-b.closed:
+	default:
+		close(b.closed)
+	}
+	return nil
+}
+
+func testChaos(policy Chaos) *chaos {
+	return &chaos{policy: policy, rand: rand.New(rand.NewSource(1))}
+}
+
+func TestChaosTransport_APIErrors(t *testing.T) {
+	var called bool
+	transport := &chaosTransport{
+		chaos: testChaos(Chaos{APIErrors: 1}),
+		next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			called = true
+			return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(new(bytes.Buffer))}, nil
+		}),
+	}
+
+	req, _ := http.NewRequest("GET", "https://kubernetes/api/v1/namespaces/default/pods/drone-a", nil)
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if called {
+		t.Errorf("Want request not sent to the api server")
+	}
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Want internal server error, got %d", res.StatusCode)
+	}
+
+	// upgraded connections, for example exec streams, are
+	// not affected.
+	req.Header.Set("Upgrade", "SPDY/3.1")
+	if res, _ := transport.RoundTrip(req); !called || res.StatusCode != 200 {
+		t.Errorf("Want upgraded request sent to the api server")
+	}
+}
+
+func TestChaosTransport_WatchDisconnects(t *testing.T) {
+	body := &blockingBody{closed: make(chan struct{})}
+	transport := &chaosTransport{
+		chaos: testChaos(Chaos{WatchDisconnects: 1, MaxDelay: time.Millisecond}),
+		next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Body: body}, nil
+		}),
+	}
+
+	req, _ := http.NewRequest("GET", "https://kubernetes/api/v1/namespaces/default/pods?watch=true", nil)
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer res.Body.Close()
+	done := make(chan struct{})
+	go func() {
+		res.Body.Read(make([]byte, 1))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Want watch stream disconnected")
+	}
+}
+
+func TestChaos_Disabled(t *testing.T) {
+	var c *chaos
+	if c.inject("api_error", 1) {
+		t.Errorf("Want no faults injected if chaos mode is disabled")
+	}
+	if err := c.slowPull(context.Background(), &Step{}, ioutil.Discard); err != nil {
+		t.Error(err)
+	}
+	k := new(Kubernetes)
+	k.evictRandomly(&Spec{}, &Step{}, ioutil.Discard)()
+}
+
+func TestChaos_Rate(t *testing.T) {
+	c := testChaos(Chaos{})
+	if c.inject("api_error", 0) {
+		t.Errorf("Want no faults injected at rate 0")
+	}
+	var injected int
+	for i := 0; i < 1000; i++ {
+		if c.inject("api_error", 0.5) {
+			injected++
+		}
+	}
+	if injected < 400 || injected > 600 {
+		t.Errorf("Want about half of the faults injected, got %d", injected)
+	}
+}
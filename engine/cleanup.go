@@ -0,0 +1,137 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/client-go/util/retry"
+)
+
+// Finalizer is added to the build pod to guarantee the
+// pipeline resources are removed before the pod is deleted.
+const Finalizer = "drone.io/cleanup"
+
+// Cleanup watches build pods in the namespace that are marked
+// for deletion, removes the resources that belong to the
+// pipeline, and then releases the pod finalizer. Pods that
+// were marked for deletion while the runner was offline are
+// cleaned up when the controller starts. Cleanup blocks until
+// the context is cancelled. The prefix must match the label
+// prefix used to compile the pipeline.
+func (k *Kubernetes) Cleanup(ctx context.Context, namespace, prefix string) error {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	label := prefix + "=true"
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = label
+			return k.client.CoreV1().Pods(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = label
+			return k.client.CoreV1().Pods(namespace).Watch(options)
+		},
+	}
+
+	_, err := watchtools.UntilWithSync(ctx, lw, &v1.Pod{}, nil, func(e watch.Event) (bool, error) {
+		switch e.Type {
+		case watch.Added, watch.Modified:
+		default:
+			return false, nil
+		}
+		pod, ok := e.Object.(*v1.Pod)
+		if !ok || pod.DeletionTimestamp == nil || !hasFinalizer(pod) {
+			return false, nil
+		}
+		if err := k.teardown(pod, prefix); err != nil {
+			logrus.WithError(err).
+				WithField("pod", pod.Name).
+				Errorln("cannot cleanup pipeline resources")
+		}
+		return false, nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// helper function removes the resources that belong to the
+// pipeline and releases the pod finalizer.
+func (k *Kubernetes) teardown(pod *v1.Pod, prefix string) error {
+	var result error
+
+	opts := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", labelName(prefix), pod.Name),
+	}
+	del := &metav1.DeleteOptions{}
+
+	err := k.client.CoreV1().Secrets(pod.Namespace).DeleteCollection(del, opts)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	err = k.client.CoreV1().ConfigMaps(pod.Namespace).DeleteCollection(del, opts)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	err = k.client.CoreV1().PersistentVolumeClaims(pod.Namespace).DeleteCollection(del, opts)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	err = k.client.NetworkingV1().NetworkPolicies(pod.Namespace).DeleteCollection(del, opts)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	// the finalizer is only released once all resources are
+	// removed, so that teardown is retried on the next update.
+	if result != nil {
+		return result
+	}
+
+	client := k.client.CoreV1().Pods(pod.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := client.Get(pod.Name, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var finalizers []string
+		for _, s := range latest.Finalizers {
+			if s != Finalizer {
+				finalizers = append(finalizers, s)
+			}
+		}
+		latest.Finalizers = finalizers
+		_, err = client.Update(latest)
+		return err
+	})
+}
+
+// helper function returns true if the pod has the cleanup
+// finalizer.
+func hasFinalizer(pod *v1.Pod) bool {
+	for _, s := range pod.Finalizers {
+		if s == Finalizer {
+			return true
+		}
+	}
+	return false
+}
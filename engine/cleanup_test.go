@@ -0,0 +1,113 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testCleanupPod(name string, deleted bool) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  "pool-b",
+			Labels:     map[string]string{DefaultLabelPrefix: "true"},
+			Finalizers: []string{"example.com/other", Finalizer},
+		},
+	}
+	if deleted {
+		now := metav1.Now()
+		pod.DeletionTimestamp = &now
+	}
+	return pod
+}
+
+func TestCleanup(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		testCleanupPod("drone-deleted", true),
+		testCleanupPod("drone-running", false),
+	)
+
+	// the fake clientset does not implement collection
+	// deletes, so the deleted resources are recorded.
+	var (
+		mu      sync.Mutex
+		deleted []string
+	)
+	client.PrependReactor("delete-collection", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		del := action.(k8stesting.DeleteCollectionAction)
+		mu.Lock()
+		deleted = append(deleted, del.GetResource().Resource+" "+del.GetListRestrictions().Labels.String())
+		mu.Unlock()
+		return true, nil, nil
+	})
+
+	k := &Kubernetes{client: client}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- k.Cleanup(ctx, "pool-b", "")
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pod, err := client.CoreV1().Pods("pool-b").Get("drone-deleted", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasFinalizer(pod) {
+			if got, want := len(pod.Finalizers), 1; got != want {
+				t.Errorf("Want other finalizers preserved, got %v", pod.Finalizers)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Want cleanup finalizer released from the deleted pod")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Want no error when the context is cancelled, got %s", err)
+	}
+
+	running, err := client.CoreV1().Pods("pool-b").Get("drone-running", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasFinalizer(running) {
+		t.Errorf("Want cleanup finalizer retained by the running pod")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]bool{
+		"secrets io.drone.name=drone-deleted":                true,
+		"configmaps io.drone.name=drone-deleted":             true,
+		"persistentvolumeclaims io.drone.name=drone-deleted": true,
+		"networkpolicies io.drone.name=drone-deleted":        true,
+	}
+	got := map[string]bool{}
+	for _, name := range deleted {
+		if !want[name] {
+			t.Errorf("Unexpected collection delete %q", name)
+		}
+		got[name] = true
+	}
+	for name := range want {
+		if !got[name] {
+			t.Errorf("Want collection delete %q", name)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// clusters provides the clusters where pipelines are allowed
+// to run with a kubeconfig sourced from a pipeline secret, and
+// the engines of the clusters, keyed by kubeconfig checksum.
+type clusters struct {
+	allowed []string
+
+	mu      sync.Mutex
+	engines map[string]*Kubernetes
+}
+
+// AllowClusters enables pipelines to run in the cluster of a
+// kubeconfig sourced from a pipeline secret, for example so
+// that tenants of a shared server bring their own cluster. The
+// api server of the kubeconfig must match an allowed server
+// url. A server ending with a wildcard matches the servers
+// with the prefix, for example https://k8s-* matches
+// https://k8s-42.example.com:6443.
+func (k *Kubernetes) AllowClusters(servers []string) {
+	k.clusters = &clusters{
+		allowed: servers,
+		engines: map[string]*Kubernetes{},
+	}
+}
+
+// helper function returns the engine of the cluster where
+// the pipeline runs, which is the runner cluster unless the
+// pipeline provides a kubeconfig. The kubeconfig is rejected
+// if the cluster is not allowed.
+func (k *Kubernetes) forCluster(spec *Spec) (*Kubernetes, error) {
+	if spec.Cluster == nil || k.remote {
+		return k, nil
+	}
+	if k.clusters == nil {
+		return nil, fmt.Errorf("engine: pipeline clusters are not enabled")
+	}
+	if len(spec.Cluster.Kubeconfig) == 0 {
+		return nil, fmt.Errorf("engine: cannot find the kubeconfig secret %s", spec.Cluster.Secret)
+	}
+	key := fmt.Sprintf("%x", sha256.Sum256(spec.Cluster.Kubeconfig))
+
+	k.clusters.mu.Lock()
+	defer k.clusters.mu.Unlock()
+	if remote, ok := k.clusters.engines[key]; ok {
+		return remote, nil
+	}
+	remote, err := k.newRemote(spec.Cluster.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	k.clusters.engines[key] = remote
+	return remote, nil
+}
+
+// helper function returns the engine of the cluster of the
+// kubeconfig. The engine inherits the engine options that
+// do not depend on the runner cluster.
+func (k *Kubernetes) newRemote(kubeconfig []byte) (*Kubernetes, error) {
+	raw, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("engine: invalid pipeline kubeconfig: %s", err)
+	}
+	if err := checkKubeconfig(raw); err != nil {
+		return nil, err
+	}
+	config, err := clientcmd.NewDefaultClientConfig(*raw, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("engine: invalid pipeline kubeconfig: %s", err)
+	}
+	if !k.clusters.allow(config.Host) {
+		return nil, fmt.Errorf("engine: cluster not allowed: %s", config.Host)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicset, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	// in chaos mode, the api faults are also injected into the
+	// requests to the pipeline cluster.
+	if k.chaos != nil {
+		clientset, dynamicset, err = k.chaos.clients(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Kubernetes{
+		client:    clientset,
+		dynamic:   dynamicset,
+		config:    config,
+		kek:       k.kek,
+		deletes:   k.deletes,
+		quota:     k.quota,
+		execs:     k.execs,
+		retries:   k.retries,
+		deadlines: k.deadlines,
+		isolation: k.isolation,
+		admission: k.admission,
+		janitor:   k.janitor,
+		pulls:     k.pulls,
+		rotation:  k.rotation,
+		offpeak:   k.offpeak,
+		chaos:     k.chaos,
+		observers: k.observers,
+		store:     k.store,
+		robots:    k.robots,
+		remote:    true,
+	}, nil
+}
+
+// helper function returns true if the api server matches an
+// allowed server url.
+func (c *clusters) allow(server string) bool {
+	server = strings.TrimSuffix(server, "/")
+	for _, s := range c.allowed {
+		s = strings.TrimSuffix(s, "/")
+		if s == server {
+			return true
+		}
+		if strings.HasSuffix(s, "*") && strings.HasPrefix(server, strings.TrimSuffix(s, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns an error if the kubeconfig executes
+// commands or reads files on the runner host, since the
+// kubeconfig is provided by the pipeline.
+func checkKubeconfig(config *clientcmdapi.Config) error {
+	for _, user := range config.AuthInfos {
+		if user.Exec != nil || user.AuthProvider != nil {
+			return fmt.Errorf("engine: pipeline kubeconfig cannot use authentication plugins")
+		}
+		if user.TokenFile != "" || user.ClientCertificate != "" || user.ClientKey != "" {
+			return fmt.Errorf("engine: pipeline kubeconfig cannot reference files")
+		}
+	}
+	for _, cluster := range config.Clusters {
+		if cluster.CertificateAuthority != "" {
+			return fmt.Errorf("engine: pipeline kubeconfig cannot reference files")
+		}
+	}
+	return nil
+}
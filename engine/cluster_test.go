@@ -0,0 +1,102 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `
+apiVersion: v1
+kind: Config
+clusters:
+- name: tenant
+  cluster:
+    server: https://k8s-42.example.com:6443
+users:
+- name: drone
+  user:
+    token: %s
+contexts:
+- name: tenant
+  context:
+    cluster: tenant
+    user: drone
+current-context: tenant
+`
+
+func TestClustersAllow(t *testing.T) {
+	c := &clusters{allowed: []string{"https://k8s.example.com/", "https://k8s-*"}}
+	tests := []struct {
+		server string
+		want   bool
+	}{
+		{"https://k8s.example.com", true},
+		{"https://k8s-42.example.com:6443", true},
+		{"https://k8s.example.com.evil.com", false},
+		{"http://k8s-42.example.com", false},
+	}
+	for _, test := range tests {
+		if got := c.allow(test.server); got != test.want {
+			t.Errorf("Want allowed %v for server %s, got %v", test.want, test.server, got)
+		}
+	}
+}
+
+func TestForCluster(t *testing.T) {
+	k := new(Kubernetes)
+	spec := new(Spec)
+	if got, _ := k.forCluster(spec); got != k {
+		t.Errorf("Want runner cluster if the pipeline does not provide a kubeconfig")
+	}
+
+	spec.Cluster = &Cluster{
+		Secret:     "kubeconfig",
+		Kubeconfig: []byte(strings.Replace(testKubeconfig, "%s", "token-a", 1)),
+	}
+	if _, err := k.forCluster(spec); err == nil {
+		t.Errorf("Want error if pipeline clusters are not enabled")
+	}
+
+	k.AllowClusters([]string{"https://k8s.example.com"})
+	if _, err := k.forCluster(spec); err == nil {
+		t.Errorf("Want error if the cluster is not allowed")
+	}
+
+	k.AllowClusters([]string{"https://k8s-*"})
+	remote, err := k.forCluster(spec)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if remote == k || !remote.remote {
+		t.Errorf("Want remote engine")
+	}
+	if got, _ := k.forCluster(spec); got != remote {
+		t.Errorf("Want remote engine reused for the same kubeconfig")
+	}
+	if got, _ := remote.forCluster(spec); got != remote {
+		t.Errorf("Want remote engine does not route the pipeline")
+	}
+
+	spec.Cluster.Kubeconfig = nil
+	if _, err := k.forCluster(spec); err == nil {
+		t.Errorf("Want error if the kubeconfig secret is not found")
+	}
+}
+
+func TestForCluster_Files(t *testing.T) {
+	k := new(Kubernetes)
+	k.AllowClusters([]string{"https://k8s-*"})
+
+	// the kubeconfig cannot read the token from a file on
+	// the runner host.
+	kubeconfig := strings.Replace(testKubeconfig, "token: %s", "tokenFile: /var/run/secrets/token", 1)
+	spec := &Spec{Cluster: &Cluster{Kubeconfig: []byte(kubeconfig)}}
+	if _, err := k.forCluster(spec); err == nil {
+		t.Errorf("Want error if the kubeconfig references files")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/internal/timeouts"
+)
+
+// MetadataVolume is the name of the pod volume that projects
+// the pod annotations into the env file with the downward api.
+// Sidecars injected into the pipeline pod, for example by
+// security scanners or cost agents, can mount the volume to
+// read the build metadata from the env file, in the format
+// key="value", one annotation per line.
+const MetadataVolume = "drone-build-metadata"
+
+// metadataVersion is the version of the build metadata
+// annotations schema. The version is incremented if existing
+// annotations are renamed or removed.
+const metadataVersion = "1"
+
+// helper function sets the build metadata annotations of the
+// pipeline pod. The annotations use the label prefix, which
+// defaults to io.drone, and follow a versioned schema:
+//
+//	<prefix>.metadata.version  schema version, currently 1
+//	<prefix>.repo.slug         repository slug
+//	<prefix>.repo.link         repository link
+//	<prefix>.build.number      build number
+//	<prefix>.build.event       build event, for example push
+//	<prefix>.build.link        build link
+//	<prefix>.build.ref         git reference
+//	<prefix>.build.sha         commit sha
+//	<prefix>.build.author      commit author login
+//	<prefix>.build.deploy_to   deployment target, if any
+//	<prefix>.stage.name        pipeline name
+//	<prefix>.stage.number      stage number
+//	<prefix>.stage.id          stage id
+//	<prefix>.stage.timeout     stage timeout, in minutes
+//	<prefix>.metadata          json-encoded build metadata, in
+//	                           the .drone/metadata.json format
+//
+// Unlike labels, annotations are not restricted in length or
+// character set, and include the unmodified values.
+func configureAnnotations(spec *engine.Spec, args Args, prefix string, table *timeouts.Table) {
+	annotations := spec.PodSpec.Annotations
+	annotations[prefix+".metadata.version"] = metadataVersion
+	annotations[prefix+".repo.slug"] = args.Repo.Slug
+	annotations[prefix+".repo.link"] = args.Repo.Link
+	annotations[prefix+".build.number"] = fmt.Sprint(args.Build.Number)
+	annotations[prefix+".build.event"] = args.Build.Event
+	annotations[prefix+".build.link"] = args.Build.Link
+	annotations[prefix+".build.ref"] = args.Build.Ref
+	annotations[prefix+".build.sha"] = args.Build.After
+	annotations[prefix+".build.author"] = args.Build.Author
+	if args.Build.Deploy != "" {
+		annotations[prefix+".build.deploy_to"] = args.Build.Deploy
+	}
+	annotations[prefix+".stage.name"] = args.Stage.Name
+	annotations[prefix+".stage.number"] = fmt.Sprint(args.Stage.Number)
+	annotations[prefix+".stage.id"] = fmt.Sprint(args.Stage.ID)
+	annotations[prefix+".stage.timeout"] = fmt.Sprint(stageTimeout(args.Repo, table))
+
+	// the commit message is excluded from the json metadata,
+	// because the total size of the annotations is limited.
+	meta := &metadata{
+		Repo:   toMetadataRepo(args.Repo),
+		Build:  toMetadataBuild(args.Build),
+		Commit: toMetadataCommit(args.Build),
+		Stage:  toMetadataStage(args.Stage),
+		Labels: args.Pipeline.Metadata.Labels,
+	}
+	meta.Commit.Message = ""
+	out, _ := json.Marshal(meta)
+	annotations[prefix+".metadata"] = string(out)
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func Test_configureAnnotations(t *testing.T) {
+	spec := &engine.Spec{
+		PodSpec: engine.PodSpec{Annotations: map[string]string{}},
+	}
+	args := Args{
+		Repo:     &drone.Repo{Slug: "octocat/hello-world", Timeout: 60},
+		Build:    &drone.Build{Number: 42, Event: "push", After: "7fd1a60", Message: "fix the build"},
+		Stage:    &drone.Stage{ID: 7, Number: 1, Name: "default"},
+		Pipeline: &resource.Pipeline{},
+	}
+	configureAnnotations(spec, args, "io.drone", nil)
+
+	want := map[string]string{
+		"io.drone.metadata.version": "1",
+		"io.drone.repo.slug":        "octocat/hello-world",
+		"io.drone.build.number":     "42",
+		"io.drone.build.event":      "push",
+		"io.drone.build.sha":        "7fd1a60",
+		"io.drone.stage.id":         "7",
+		"io.drone.stage.timeout":    "60",
+	}
+	for k, v := range want {
+		if got := spec.PodSpec.Annotations[k]; got != v {
+			t.Errorf("Want annotation %s=%q, got %q", k, v, got)
+		}
+	}
+	if _, ok := spec.PodSpec.Annotations["io.drone.build.deploy_to"]; ok {
+		t.Errorf("Want empty deployment target omitted")
+	}
+
+	meta := new(metadata)
+	if err := json.Unmarshal([]byte(spec.PodSpec.Annotations["io.drone.metadata"]), meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Build.Number != 42 || meta.Commit.Sha != "7fd1a60" {
+		t.Errorf("Want json build metadata annotation")
+	}
+	if meta.Commit.Message != "" {
+		t.Errorf("Want commit message excluded from the annotation")
+	}
+}
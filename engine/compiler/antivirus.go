@@ -0,0 +1,131 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+const (
+	// names of the steps that scan the workspace after the
+	// repository is cloned, and after the pipeline steps
+	// complete.
+	cloneScanStepName = "antivirus-clone"
+	buildScanStepName = "antivirus"
+
+	// default ports of the clamd and icap endpoints.
+	clamdPort = "3310"
+	icapPort  = "1344"
+
+	// default icap service.
+	icapService = "avscan"
+)
+
+// helper function adds the steps that scan the workspace with
+// the antivirus scanner. The clone scan runs before all steps
+// that depend on the clone step, and the build scan runs once
+// all other steps succeed. Detections fail the build, unless
+// the scanner is configured to flag detections only.
+func (c *Compiler) configureAntivirus(spec *engine.Spec, workspace string, mounts ...*engine.VolumeMount) {
+	commands := scanCommands(c.Antivirus.Endpoint, workspace)
+	if c.Antivirus.Image == "" || len(commands) == 0 {
+		return
+	}
+	if c.Antivirus.Clone {
+		// the scan runs after the workspace ownership step,
+		// if the pipeline includes the step. The workspace is
+		// not scanned if the clone step is disabled.
+		index := -1
+		for i, step := range spec.Steps {
+			if step.Name == cloneStepName || step.Name == ownerStepName {
+				index = i
+			}
+		}
+		if index != -1 {
+			after := spec.Steps[index].Name
+			scan := c.createScanStep(cloneScanStepName, workspace, commands)
+			scan.Volumes = append(scan.Volumes, mounts...)
+			scan.DependsOn = []string{after}
+			for _, step := range spec.Steps {
+				for i, dep := range step.DependsOn {
+					if dep == after {
+						step.DependsOn[i] = cloneScanStepName
+					}
+				}
+			}
+			spec.Steps = append(spec.Steps[:index+1], append([]*engine.Step{scan}, spec.Steps[index+1:]...)...)
+		}
+	}
+	if c.Antivirus.Build {
+		scan := c.createScanStep(buildScanStepName, workspace, commands)
+		scan.Volumes = append(scan.Volumes, mounts...)
+		scan.RunPolicy = engine.RunOnSuccess
+		for _, step := range spec.Steps {
+			if !step.Detach {
+				scan.DependsOn = append(scan.DependsOn, step.Name)
+			}
+		}
+		spec.Steps = append(spec.Steps, scan)
+	}
+}
+
+// helper function creates the step that scans the workspace.
+func (c *Compiler) createScanStep(name, workspace string, commands []string) *engine.Step {
+	dst := &engine.Step{
+		ID:         random(),
+		Name:       name,
+		Image:      c.Antivirus.Image,
+		Envs:       map[string]string{},
+		IgnoreErr:  c.Antivirus.Flag,
+		WorkingDir: workspace,
+	}
+	setupScriptPosix(func() string { return "" }, commands, dst)
+	return dst
+}
+
+// helper function returns the commands that scan the path with
+// the scanner endpoint, in clamd://host:port format for clamd,
+// or icap://host:port/service format for icap. The commands
+// exit with a non-zero exit code if malware is detected. No
+// commands are returned if the endpoint is not supported.
+func scanCommands(endpoint, path string) []string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	host, port := u.Hostname(), u.Port()
+	switch u.Scheme {
+	case "clamd":
+		if port == "" {
+			port = clamdPort
+		}
+		return []string{
+			fmt.Sprintf("printf 'TCPSocket %s\\nTCPAddr %s\\n' > /tmp/clamd.conf", port, host),
+			fmt.Sprintf("clamdscan --config-file=/tmp/clamd.conf --stream --infected %s", path),
+		}
+	case "icap":
+		if port == "" {
+			port = icapPort
+		}
+		service := strings.Trim(u.Path, "/")
+		if service == "" {
+			service = icapService
+		}
+		// the icap server responds with 204 no content if the
+		// file is not modified, and with the blocked content
+		// otherwise.
+		scan := fmt.Sprintf(`c-icap-client -i %s -p %s -s %s -f "$f" -o /dev/null -v 2>&1 | grep -q "ICAP/1.0 204" || echo "$f"`,
+			host, port, service)
+		return []string{
+			fmt.Sprintf(`found=$(find %s -type f -exec sh -c 'for f; do %s; done' sh {} +)`, path, scan),
+			`if [ -n "$found" ]; then echo "malware detected in:"; echo "$found"; exit 1; fi`,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConfigureAntivirus(t *testing.T) {
+	c := &Compiler{
+		Antivirus: Antivirus{
+			Image:    "clamav/clamav",
+			Endpoint: "clamd://clamd.antivirus",
+			Clone:    true,
+			Build:    true,
+			Flag:     true,
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			{Name: "clone"},
+			{Name: "redis", Detach: true, DependsOn: []string{"clone"}},
+			{Name: "build", DependsOn: []string{"clone"}},
+			{Name: "test", DependsOn: []string{"build"}},
+		},
+	}
+	c.configureAntivirus(spec, "/drone/src")
+
+	var names []string
+	deps := map[string][]string{}
+	for _, step := range spec.Steps {
+		names = append(names, step.Name)
+		deps[step.Name] = step.DependsOn
+	}
+	if diff := cmp.Diff(names, []string{"clone", "antivirus-clone", "redis", "build", "test", "antivirus"}); diff != "" {
+		t.Errorf("Unexpected steps")
+		t.Log(diff)
+	}
+	want := map[string][]string{
+		"clone":           nil,
+		"antivirus-clone": {"clone"},
+		"redis":           {"antivirus-clone"},
+		"build":           {"antivirus-clone"},
+		"test":            {"build"},
+		"antivirus":       {"clone", "antivirus-clone", "build", "test"},
+	}
+	if diff := cmp.Diff(deps, want); diff != "" {
+		t.Errorf("Unexpected step dependencies")
+		t.Log(diff)
+	}
+
+	scan := spec.Steps[len(spec.Steps)-1]
+	if !scan.IgnoreErr {
+		t.Errorf("Want detections flagged without failing the build")
+	}
+	if got, want := scan.RunPolicy, engine.RunOnSuccess; got != want {
+		t.Errorf("Want run policy %v, got %v", want, got)
+	}
+	if script := scan.Envs["DRONE_SCRIPT"]; !strings.Contains(script, "TCPSocket 3310\\nTCPAddr clamd.antivirus") {
+		t.Errorf("Want clamd configured in the scan script")
+	}
+}
+
+func TestConfigureAntivirus_Disabled(t *testing.T) {
+	c := &Compiler{
+		Antivirus: Antivirus{
+			Image:    "clamav/clamav",
+			Endpoint: "http://clamd.antivirus",
+			Clone:    true,
+			Build:    true,
+		},
+	}
+	spec := &engine.Spec{
+		Steps: []*engine.Step{{Name: "clone"}},
+	}
+	c.configureAntivirus(spec, "/drone/src")
+	if got, want := len(spec.Steps), 1; got != want {
+		t.Errorf("Want no scan steps for unsupported endpoints")
+	}
+}
+
+func TestScanCommands(t *testing.T) {
+	got := scanCommands("icap://icap.antivirus:1345/squidclamav", "/drone/src")
+	if len(got) != 2 {
+		t.Fatalf("Want icap scan commands")
+	}
+	if !strings.Contains(got[0], "c-icap-client -i icap.antivirus -p 1345 -s squidclamav") {
+		t.Errorf("Unexpected icap scan command %s", got[0])
+	}
+	if !strings.Contains(got[0], "find /drone/src -type f") {
+		t.Errorf("Want the workspace scanned, got %s", got[0])
+	}
+	if got := scanCommands("", "/drone/src"); got != nil {
+		t.Errorf("Want no scan commands if the endpoint is empty")
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+const (
+	// names of the steps that import the artifacts of the
+	// pipeline dependencies, and export the artifacts of the
+	// pipeline.
+	importStepName = "artifacts-import"
+	exportStepName = "artifacts-export"
+
+	// default image used to upload and download the artifacts.
+	artifactsImage = "minio/mc:RELEASE.2020-10-03T02-54-56Z"
+
+	// default endpoint of the object store.
+	artifactsEndpoint = "https://s3.amazonaws.com"
+
+	// path of the temporary artifacts archive.
+	artifactsArchive = "/tmp/artifacts.tar.gz"
+)
+
+// helper function adds the step that imports the artifacts of
+// the pipeline dependencies after the repository is cloned,
+// and the step that exports the artifacts of the pipeline once
+// all other steps succeed. The artifacts are stored in the
+// object store as a compressed archive with a sha256 checksum,
+// which is verified before the archive is extracted.
+func (c *Compiler) configureArtifacts(spec *engine.Spec, args Args, workspace string, mounts ...*engine.VolumeMount) {
+	var exports []string
+	if args.Pipeline.Export != nil {
+		exports = args.Pipeline.Export.Paths
+	}
+	// the manifests applied by the deploy steps are exported
+	// if the object store is configured.
+	deploy := hasDeploy(args.Pipeline) && c.Cache.Bucket != ""
+	if deploy {
+		exports = append(exports[:len(exports):len(exports)], deployManifests)
+	}
+	if len(args.Pipeline.Import) == 0 && len(exports) == 0 {
+		return
+	}
+
+	spec.Secrets[cacheAccessKey] = &engine.Secret{Name: cacheAccessKey, Data: c.Cache.AccessKey, Mask: true}
+	spec.Secrets[cacheSecretKey] = &engine.Secret{Name: cacheSecretKey, Data: c.Cache.SecretKey, Mask: true}
+
+	if len(args.Pipeline.Import) != 0 {
+		var commands []string
+		for _, name := range args.Pipeline.Import {
+			commands = append(commands, c.importCommands(artifactsKey(args, name), workspace)...)
+		}
+		step := c.createArtifactsStep(importStepName, workspace, commands)
+		step.Volumes = append(step.Volumes, mounts...)
+
+		// the import runs after the workspace is prepared,
+		// before all steps that depend on the clone step.
+		index := -1
+		for i, s := range spec.Steps {
+			if s.Name == cloneStepName || s.Name == ownerStepName || s.Name == cloneScanStepName {
+				index = i
+			}
+		}
+		if index != -1 {
+			after := spec.Steps[index].Name
+			step.DependsOn = []string{after}
+			for _, s := range spec.Steps {
+				for i, dep := range s.DependsOn {
+					if dep == after {
+						s.DependsOn[i] = importStepName
+					}
+				}
+			}
+		} else {
+			for _, s := range spec.Steps {
+				if len(s.DependsOn) == 0 {
+					s.DependsOn = []string{importStepName}
+				}
+			}
+		}
+		spec.Steps = append(spec.Steps[:index+1], append([]*engine.Step{step}, spec.Steps[index+1:]...)...)
+	}
+
+	if len(exports) != 0 {
+		commands := c.exportCommands(artifactsKey(args, args.Stage.Name), exports)
+		if deploy {
+			// the manifests directory does not exist if the
+			// deploy steps are skipped.
+			commands = append([]string{"mkdir -p " + deployManifests}, commands...)
+		}
+		step := c.createArtifactsStep(exportStepName, workspace, commands)
+		step.Volumes = append(step.Volumes, mounts...)
+		step.RunPolicy = engine.RunOnSuccess
+		for _, s := range spec.Steps {
+			if !s.Detach {
+				step.DependsOn = append(step.DependsOn, s.Name)
+			}
+		}
+		spec.Steps = append(spec.Steps, step)
+	}
+}
+
+// helper function creates the step that uploads or downloads
+// the artifacts.
+func (c *Compiler) createArtifactsStep(name, workspace string, commands []string) *engine.Step {
+	image := c.Artifacts.Image
+	if image == "" {
+		image = artifactsImage
+	}
+	dst := &engine.Step{
+		ID:    random(),
+		Name:  name,
+		Image: image,
+		Envs:  map[string]string{},
+		Secrets: []*engine.SecretVar{
+			{Name: cacheAccessKey, Env: "AWS_ACCESS_KEY_ID"},
+			{Name: cacheSecretKey, Env: "AWS_SECRET_ACCESS_KEY"},
+		},
+		WorkingDir: workspace,
+	}
+	setupScriptPosix(func() string { return "" }, append(c.storeCommands(), commands...), dst)
+	return dst
+}
+
+// helper function returns the commands that configure the
+// object store alias of the minio client. The commands fail
+// if the object store is not configured.
+func (c *Compiler) storeCommands() []string {
+	if c.Cache.Bucket == "" {
+		return []string{`echo "artifacts: the object store is not configured" && exit 1`}
+	}
+	endpoint := c.Cache.Endpoint
+	if endpoint == "" {
+		endpoint = artifactsEndpoint
+	}
+	lookup := "auto"
+	if c.Cache.PathStyle {
+		lookup = "path"
+	}
+	return []string{
+		fmt.Sprintf(`mc config host add store %s "$AWS_ACCESS_KEY_ID" "$AWS_SECRET_ACCESS_KEY" --lookup %s > /dev/null`, quote(endpoint), lookup),
+	}
+}
+
+// helper function returns the commands that archive the
+// export paths and upload the archive with its checksum. The
+// commands fail if the archive exceeds the size limit.
+func (c *Compiler) exportCommands(key string, paths []string) []string {
+	var quoted []string
+	for _, p := range paths {
+		quoted = append(quoted, quote(path.Clean(p)))
+	}
+	object := quote("store/" + path.Join(c.Cache.Bucket, key))
+	commands := []string{
+		fmt.Sprintf("tar -czf %s -- %s", artifactsArchive, strings.Join(quoted, " ")),
+	}
+	commands = append(commands, c.limitCommands()...)
+	return append(commands,
+		fmt.Sprintf("sha256sum %s | cut -d ' ' -f 1 > %s.sha256", artifactsArchive, artifactsArchive),
+		fmt.Sprintf("mc cp --quiet %s %s.tar.gz", artifactsArchive, object),
+		fmt.Sprintf("mc cp --quiet %s.sha256 %s.sha256", artifactsArchive, object),
+	)
+}
+
+// helper function returns the commands that download the
+// archive and its checksum, verify the archive and extract
+// the archive into the workspace.
+func (c *Compiler) importCommands(key, workspace string) []string {
+	object := quote("store/" + path.Join(c.Cache.Bucket, key))
+	commands := []string{
+		fmt.Sprintf("mc cp --quiet %s.tar.gz %s", object, artifactsArchive),
+		fmt.Sprintf("mc cp --quiet %s.sha256 %s.sha256", object, artifactsArchive),
+	}
+	commands = append(commands, c.limitCommands()...)
+	return append(commands,
+		fmt.Sprintf(`echo "$(cat %s.sha256)  %s" | sha256sum -c -`, artifactsArchive, artifactsArchive),
+		fmt.Sprintf("tar -xzf %s -C %s", artifactsArchive, quote(workspace)),
+		fmt.Sprintf("rm -f %s %s.sha256", artifactsArchive, artifactsArchive),
+	)
+}
+
+// helper function returns the commands that fail if the
+// archive exceeds the size limit.
+func (c *Compiler) limitCommands() []string {
+	if c.Artifacts.Limit <= 0 {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf(`if [ "$(wc -c < %s)" -gt %d ]; then echo "artifacts: the archive exceeds the size limit of %d bytes"; exit 1; fi`,
+			artifactsArchive, c.Artifacts.Limit, c.Artifacts.Limit),
+	}
+}
+
+// helper function returns the object key of the artifacts of
+// the named pipeline of the build.
+func artifactsKey(args Args, pipeline string) string {
+	return fmt.Sprintf("artifacts/%s/%d/%s", args.Repo.Slug, args.Build.Number, pipeline)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func testArtifactsArgs() Args {
+	return Args{
+		Pipeline: &resource.Pipeline{
+			Export: &resource.Export{Paths: []string{"dist"}},
+			Import: []string{"build"},
+		},
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{Number: 42},
+		Stage: &drone.Stage{Name: "test"},
+	}
+}
+
+func Test_configureArtifacts(t *testing.T) {
+	c := &Compiler{
+		Cache:     Cache{Bucket: "drone"},
+		Artifacts: Artifacts{Limit: 1024},
+	}
+	spec := testCacheSpec()
+	spec.Steps[1].DependsOn = []string{"clone"}
+	spec.Steps[2].DependsOn = []string{"clone"}
+	c.configureArtifacts(spec, testArtifactsArgs(), "/drone/src")
+
+	if len(spec.Steps) != 5 {
+		t.Fatalf("Want import and export steps added, got %d steps", len(spec.Steps))
+	}
+	imp := spec.Steps[1]
+	if imp.Name != importStepName {
+		t.Fatalf("Want import step after the clone step, got %s", imp.Name)
+	}
+	if got := imp.DependsOn; len(got) != 1 || got[0] != "clone" {
+		t.Errorf("Want import step to depend on the clone step, got %v", got)
+	}
+	if got := spec.Steps[3].DependsOn; len(got) != 1 || got[0] != importStepName {
+		t.Errorf("Want steps to depend on the import step, got %v", got)
+	}
+	script := imp.Envs["DRONE_SCRIPT"]
+	if !strings.Contains(script, "artifacts/octocat/hello-world/42/build.tar.gz") {
+		t.Errorf("Want the artifacts of the dependency downloaded")
+	}
+	if !strings.Contains(script, "sha256sum -c") {
+		t.Errorf("Want the checksum of the artifacts verified")
+	}
+
+	exp := spec.Steps[4]
+	if exp.Name != exportStepName {
+		t.Fatalf("Want export step appended, got %s", exp.Name)
+	}
+	if exp.RunPolicy != engine.RunOnSuccess {
+		t.Errorf("Want export step to run on success")
+	}
+	for _, dep := range exp.DependsOn {
+		if dep == "redis" {
+			t.Errorf("Want export step not to depend on detached steps")
+		}
+	}
+	script = exp.Envs["DRONE_SCRIPT"]
+	if !strings.Contains(script, "artifacts/octocat/hello-world/42/test.tar.gz") {
+		t.Errorf("Want the artifacts of the pipeline uploaded")
+	}
+	if !strings.Contains(script, "-gt 1024") {
+		t.Errorf("Want the size of the artifacts limited")
+	}
+	if spec.Secrets[cacheAccessKey] == nil || spec.Secrets[cacheSecretKey] == nil {
+		t.Errorf("Want the object store credentials added to the secrets")
+	}
+}
+
+func Test_configureArtifacts_None(t *testing.T) {
+	c := &Compiler{Cache: Cache{Bucket: "drone"}}
+	spec := testCacheSpec()
+	args := testArtifactsArgs()
+	args.Pipeline.Export = nil
+	args.Pipeline.Import = nil
+	c.configureArtifacts(spec, args, "/drone/src")
+	if len(spec.Steps) != 3 {
+		t.Errorf("Want no artifacts steps, got %d steps", len(spec.Steps))
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// names of the built-in workspace backends.
+const (
+	BackendEmptyDir    = "emptydir"
+	BackendClaim       = "pvc"
+	BackendSnapshot    = "snapshot"
+	BackendObjectStore = "objectstore"
+)
+
+const (
+	// names of the steps that restore and save the workspace
+	// with the object store backend.
+	workspaceRestoreStepName = "workspace-restore"
+	workspaceSaveStepName    = "workspace-save"
+
+	// path of the temporary workspace archive.
+	workspaceArchive = "/tmp/workspace.tar.gz"
+)
+
+// WorkspaceBackend provides the storage of the pipeline
+// workspace. A backend returns the workspace volume, and may
+// add the steps that prepare or persist the workspace, so that
+// storage strategies can be added without changes to the
+// compiler or the engine.
+type WorkspaceBackend interface {
+	// Volume returns the workspace volume of the pipeline.
+	Volume(c *Compiler, args WorkspaceArgs) *engine.Volume
+
+	// Configure adds the steps that prepare or persist the
+	// workspace, once the pipeline steps are compiled.
+	Configure(c *Compiler, spec *engine.Spec, args WorkspaceArgs)
+}
+
+// WorkspaceArgs provides the arguments of the workspace
+// backend.
+type WorkspaceArgs struct {
+	Args
+
+	// Config provides the workspace configuration, with the
+	// repository settings applied.
+	Config Workspace
+
+	// Mount provides the workspace volume mount of the steps.
+	Mount *engine.VolumeMount
+
+	// Restricted is true if the steps of the build are
+	// restricted, in which case the backend must not expose
+	// runner credentials to the steps.
+	Restricted bool
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]WorkspaceBackend{
+		BackendEmptyDir:    emptyDirBackend{},
+		BackendClaim:       claimBackend{},
+		BackendSnapshot:    claimBackend{snapshot: true},
+		BackendObjectStore: objectStoreBackend{},
+	}
+)
+
+// RegisterWorkspaceBackend registers the workspace backend
+// with the name, which is selected with the Backend field of
+// the workspace configuration. A registered backend replaces
+// the backend of the same name, including built-in backends.
+func RegisterWorkspaceBackend(name string, backend WorkspaceBackend) {
+	backendsMu.Lock()
+	backends[name] = backend
+	backendsMu.Unlock()
+}
+
+// WorkspaceBackends returns the names of the registered
+// workspace backends.
+func WorkspaceBackends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// helper function returns the workspace backend of the
+// workspace configuration. The emptyDir backend is returned
+// if the backend is not registered.
+func lookupWorkspaceBackend(config Workspace) WorkspaceBackend {
+	name := config.Backend
+	if name == "" {
+		// the backend is selected by the claim and snapshot
+		// settings if not set, for compatibility with the
+		// existing runner and repository settings.
+		switch {
+		case config.Claim && config.Snapshot:
+			name = BackendSnapshot
+		case config.Claim:
+			name = BackendClaim
+		default:
+			name = BackendEmptyDir
+		}
+	}
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	if backend, ok := backends[name]; ok {
+		return backend
+	}
+	return backends[BackendEmptyDir]
+}
+
+// emptyDirBackend stores the workspace in an emptyDir volume,
+// which is deleted with the pipeline pod.
+type emptyDirBackend struct{}
+
+func (emptyDirBackend) Volume(c *Compiler, args WorkspaceArgs) *engine.Volume {
+	return &engine.Volume{
+		EmptyDir: &engine.VolumeEmptyDir{
+			ID:   random(),
+			Name: args.Mount.Name,
+		},
+	}
+}
+
+func (emptyDirBackend) Configure(c *Compiler, spec *engine.Spec, args WorkspaceArgs) {}
+
+// claimBackend stores the workspace in a dynamically
+// provisioned volume claim. The claim name is generated, and
+// is provisioned when the pipeline environment is created.
+// With snapshots, the claim is restored from a snapshot of
+// the most recent successful build of the same branch.
+type claimBackend struct {
+	snapshot bool
+}
+
+func (b claimBackend) Volume(c *Compiler, args WorkspaceArgs) *engine.Volume {
+	id := random()
+	volume := &engine.Volume{
+		Claim: &engine.VolumeClaim{
+			ID:           id,
+			Name:         args.Mount.Name,
+			ClaimName:    id,
+			Provision:    true,
+			StorageClass: args.Config.StorageClass,
+			AccessMode:   args.Config.AccessMode,
+			Size:         args.Config.Size,
+		},
+	}
+	if b.snapshot {
+		volume.Claim.Snapshot = createSnapshot(args.Args, args.Config.SnapshotClass)
+	}
+	return volume
+}
+
+func (claimBackend) Configure(c *Compiler, spec *engine.Spec, args WorkspaceArgs) {}
+
+// objectStoreBackend stores the workspace in an emptyDir
+// volume that is restored from the object store when the
+// pipeline starts, and saved to the object store when the
+// pipeline succeeds. The workspace is keyed like snapshots,
+// and is only saved by builds of the branch itself, so that
+// pull requests cannot alter the workspace of the target
+// branch.
+type objectStoreBackend struct {
+	emptyDirBackend
+}
+
+func (objectStoreBackend) Configure(c *Compiler, spec *engine.Spec, args WorkspaceArgs) {
+	// the workspace is not synced for restricted builds, so
+	// that the steps of the untrusted build never run with
+	// the object store credentials.
+	if args.Restricted {
+		return
+	}
+	spec.Secrets[cacheAccessKey] = &engine.Secret{Name: cacheAccessKey, Data: c.Cache.AccessKey, Mask: true}
+	spec.Secrets[cacheSecretKey] = &engine.Secret{Name: cacheSecretKey, Data: c.Cache.SecretKey, Mask: true}
+
+	workspace := args.Mount.Path
+	object := quote("store/" + path.Join(c.Cache.Bucket, "workspace", createSnapshot(args.Args, "").Key))
+
+	// the workspace is restored by an init container, which
+	// runs the command instead of the step script. The
+	// pipeline does not fail if the workspace cannot be
+	// restored, for example because the pipeline has not
+	// succeeded on the branch yet.
+	commands := append(c.storeCommands(),
+		fmt.Sprintf("mc cp --quiet %s.tar.gz %s || { echo cannot restore the workspace; exit 0; }", object, workspaceArchive),
+		fmt.Sprintf("tar -xzf %s -C %s", workspaceArchive, quote(workspace)),
+		fmt.Sprintf("rm -f %s", workspaceArchive),
+	)
+	restore := c.createArtifactsStep(workspaceRestoreStepName, workspace, nil)
+	restore.Entrypoint = []string{"/bin/sh", "-c"}
+	restore.Command = []string{"set -e\n" + strings.Join(commands, "\n")}
+	restore.Volumes = []*engine.VolumeMount{args.Mount}
+	delete(restore.Envs, "DRONE_SCRIPT")
+	spec.Init = append(spec.Init, restore)
+
+	switch args.Build.Event {
+	case drone.EventPush, drone.EventCron, drone.EventCustom:
+	default:
+		return
+	}
+	save := c.createArtifactsStep(workspaceSaveStepName, workspace, []string{
+		fmt.Sprintf("tar -czf %s -C %s .", workspaceArchive, quote(workspace)),
+		fmt.Sprintf("mc cp --quiet %s %s.tar.gz", workspaceArchive, object),
+	})
+	save.Volumes = append(save.Volumes, args.Mount)
+	save.IgnoreErr = true
+	save.RunPolicy = engine.RunOnSuccess
+	for _, step := range spec.Steps {
+		if !step.Detach {
+			save.DependsOn = append(save.DependsOn, step.Name)
+		}
+	}
+	spec.Steps = append(spec.Steps, save)
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/registry"
+	"github.com/drone/runner-go/secret"
+)
+
+// hostPathBackend is a custom workspace backend that stores
+// the workspace on the node.
+type hostPathBackend struct{}
+
+func (hostPathBackend) Volume(c *Compiler, args WorkspaceArgs) *engine.Volume {
+	return &engine.Volume{
+		HostPath: &engine.VolumeHostPath{
+			ID:   "workspace",
+			Name: args.Mount.Name,
+			Path: "/var/lib/drone/" + args.Repo.Slug,
+		},
+	}
+}
+
+func (hostPathBackend) Configure(c *Compiler, spec *engine.Spec, args WorkspaceArgs) {
+	spec.PodSpec.Labels["workspace"] = "host"
+}
+
+func testBackendArgs() Args {
+	return Args{
+		Repo:     &drone.Repo{Slug: "octocat/hello-world"},
+		Build:    &drone.Build{Event: drone.EventPush, Target: "master"},
+		Stage:    &drone.Stage{Name: "default"},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: &manifest.Manifest{},
+		Pipeline: &resource.Pipeline{},
+	}
+}
+
+func TestLookupWorkspaceBackend(t *testing.T) {
+	tests := []struct {
+		config Workspace
+		want   WorkspaceBackend
+	}{
+		{Workspace{}, emptyDirBackend{}},
+		{Workspace{Claim: true}, claimBackend{}},
+		{Workspace{Claim: true, Snapshot: true}, claimBackend{snapshot: true}},
+		{Workspace{Backend: BackendObjectStore}, objectStoreBackend{}},
+		{Workspace{Backend: BackendEmptyDir, Claim: true}, emptyDirBackend{}},
+		{Workspace{Backend: "unknown"}, emptyDirBackend{}},
+	}
+	for _, test := range tests {
+		if got := lookupWorkspaceBackend(test.config); got != test.want {
+			t.Errorf("Want backend %T for %+v, got %T", test.want, test.config, got)
+		}
+	}
+}
+
+func TestWorkspaceBackend_Register(t *testing.T) {
+	RegisterWorkspaceBackend("hostpath", hostPathBackend{})
+	defer func() {
+		backendsMu.Lock()
+		delete(backends, "hostpath")
+		backendsMu.Unlock()
+	}()
+
+	c := &Compiler{
+		Registry:  registry.Static(nil),
+		Secret:    secret.Static(nil),
+		Workspace: Workspace{Backend: "hostpath"},
+	}
+	got := c.Compile(nocontext, testBackendArgs())
+	volume := got.Volumes[0]
+	if volume.HostPath == nil || volume.HostPath.Path != "/var/lib/drone/octocat/hello-world" {
+		t.Errorf("Want workspace volume of the registered backend")
+	}
+	if got.PodSpec.Labels["workspace"] != "host" {
+		t.Errorf("Want pipeline configured by the registered backend")
+	}
+}
+
+func TestWorkspaceBackend_ObjectStore(t *testing.T) {
+	c := &Compiler{
+		Registry:  registry.Static(nil),
+		Secret:    secret.Static(nil),
+		Workspace: Workspace{Backend: BackendObjectStore},
+	}
+	c.Cache.Bucket = "drone"
+
+	args := testBackendArgs()
+	got := c.Compile(nocontext, args)
+	if got.Volumes[0].EmptyDir == nil {
+		t.Errorf("Want workspace stored in an emptyDir volume")
+	}
+	if len(got.Init) == 0 || got.Init[len(got.Init)-1].Name != workspaceRestoreStepName {
+		t.Errorf("Want workspace restored by an init container")
+	}
+	if last := got.Steps[len(got.Steps)-1]; last.Name != workspaceSaveStepName || last.RunPolicy != engine.RunOnSuccess {
+		t.Errorf("Want workspace saved when the pipeline succeeds")
+	}
+
+	// pull requests restore the workspace of the branch, but
+	// do not save the workspace.
+	args.Build.Event = drone.EventPullRequest
+	got = c.Compile(nocontext, args)
+	for _, step := range got.Steps {
+		if step.Name == workspaceSaveStepName {
+			t.Errorf("Want workspace not saved by pull requests")
+		}
+	}
+}
+
+func TestWorkspaceBackend_Claim(t *testing.T) {
+	c := &Compiler{
+		Registry: registry.Static(nil),
+		Secret:   secret.Static(nil),
+		Workspace: Workspace{
+			Claim:        true,
+			StorageClass: "fast",
+			AccessMode:   "ReadWriteMany",
+			Size:         1 << 30,
+		},
+	}
+	got := c.Compile(nocontext, testBackendArgs())
+	claim := got.Volumes[0].Claim
+	if claim == nil {
+		t.Fatalf("Want workspace stored in a volume claim")
+	}
+	if !claim.Provision || claim.ClaimName == "" {
+		t.Errorf("Want workspace volume claim provisioned")
+	}
+	if claim.StorageClass != "fast" || claim.AccessMode != "ReadWriteMany" || claim.Size != 1<<30 {
+		t.Errorf("Want workspace volume claim configured, got %+v", claim)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/environ"
+)
+
+const (
+	// name of the build cache volume.
+	cacheVolumeName = "_cache"
+
+	// path where the build cache volume is mounted by the
+	// steps that restore and rebuild the build cache.
+	cachePath = "/drone/cache"
+
+	// default image used to restore and rebuild the build
+	// cache with the s3 backend.
+	cacheImage = "meltwater/drone-cache:1.4.0"
+
+	// names of the secrets that store the credentials of the
+	// s3 backend.
+	cacheAccessKey = "cache.access_key"
+	cacheSecretKey = "cache.secret_key"
+)
+
+// helper function configures the build cache. The cache
+// directories are mounted into each step from the build cache
+// volume. With the pvc backend, the build cache volume is a
+// volume claim that is reused by subsequent pipelines. With
+// the s3 backend, the build cache volume is a temporary
+// directory that is restored from the object store when the
+// pipeline starts, and saved when the pipeline succeeds.
+func (c *Compiler) configureCache(spec *engine.Spec, args Args, workspace string) {
+	if args.Pipeline.Cache == nil || len(args.Pipeline.Cache.Paths) == 0 {
+		return
+	}
+	key := cacheKey(args)
+	dirs := cacheDirs(args.Pipeline.Cache.Paths, workspace)
+
+	volume := &engine.Volume{}
+	switch c.Cache.Backend {
+	case "pvc":
+		volume.Claim = &engine.VolumeClaim{
+			ID:           random(),
+			Name:         cacheVolumeName,
+			ClaimName:    "drone-cache-" + key[:20],
+			Provision:    true,
+			Keep:         true,
+			StorageClass: c.Cache.StorageClass,
+			AccessMode:   c.Cache.AccessMode,
+			Size:         c.Cache.Size,
+		}
+	case "s3":
+		volume.EmptyDir = &engine.VolumeEmptyDir{
+			ID:   random(),
+			Name: cacheVolumeName,
+		}
+	default:
+		return
+	}
+	spec.Volumes = append(spec.Volumes, volume)
+
+	var paths []string
+	for dir := range dirs {
+		paths = append(paths, dir)
+	}
+	sort.Strings(paths)
+	for _, step := range spec.Steps {
+		if step.Name == cloneStepName {
+			continue
+		}
+		for _, dir := range paths {
+			step.Volumes = append(step.Volumes, &engine.VolumeMount{
+				Name:    cacheVolumeName,
+				Path:    dir,
+				SubPath: dirs[dir],
+			})
+		}
+	}
+
+	// the build cache is not restored or rebuilt for untrusted
+	// builds in the security mode, so that the steps of the
+	// untrusted build never run with the object store
+	// credentials.
+	if c.Cache.Backend == "s3" && !(c.SecureForks && isUntrusted(args)) {
+		c.configureObjectCache(spec, args, key, dirs)
+	}
+}
+
+// helper function adds the init step that restores the build
+// cache from the object store, and the step that rebuilds the
+// build cache once all other steps succeed.
+func (c *Compiler) configureObjectCache(spec *engine.Spec, args Args, key string, dirs map[string]string) {
+	var mounts []string
+	for _, sub := range dirs {
+		mounts = append(mounts, sub)
+	}
+	sort.Strings(mounts)
+	envs := map[string]string{
+		"PLUGIN_BACKEND":    "s3",
+		"PLUGIN_BUCKET":     c.Cache.Bucket,
+		"PLUGIN_ENDPOINT":   c.Cache.Endpoint,
+		"PLUGIN_REGION":     c.Cache.Region,
+		"PLUGIN_PATH_STYLE": strconv.FormatBool(c.Cache.PathStyle),
+		"PLUGIN_CACHE_KEY":  key,
+		"PLUGIN_MOUNT":      strings.Join(mounts, ","),
+	}
+	secrets := []*engine.SecretVar{
+		{Name: cacheAccessKey, Env: "AWS_ACCESS_KEY_ID"},
+		{Name: cacheSecretKey, Env: "AWS_SECRET_ACCESS_KEY"},
+	}
+	spec.Secrets[cacheAccessKey] = &engine.Secret{Name: cacheAccessKey, Data: c.Cache.AccessKey, Mask: true}
+	spec.Secrets[cacheSecretKey] = &engine.Secret{Name: cacheSecretKey, Data: c.Cache.SecretKey, Mask: true}
+
+	image := c.Cache.Image
+	if image == "" {
+		image = cacheImage
+	}
+	mount := &engine.VolumeMount{
+		Name: cacheVolumeName,
+		Path: cachePath,
+	}
+
+	// the pipeline does not fail if the build cache cannot be
+	// restored, for example because the pipeline has not
+	// succeeded on the branch yet.
+	restore := &engine.Step{
+		ID:         random(),
+		Name:       "cache-restore",
+		Image:      image,
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Command:    []string{"/bin/drone-cache || echo cannot restore the build cache"},
+		Envs:       environ.Combine(envs, map[string]string{"PLUGIN_RESTORE": "true"}),
+		Secrets:    secrets,
+		WorkingDir: cachePath,
+		Volumes:    []*engine.VolumeMount{mount},
+	}
+	spec.Init = append(spec.Init, restore)
+
+	// the build cache is only rebuilt by builds of the branch
+	// itself, so that pull requests cannot alter the build
+	// cache of the target branch.
+	switch args.Build.Event {
+	case drone.EventPush, drone.EventCron, drone.EventCustom:
+	default:
+		return
+	}
+	rebuild := &engine.Step{
+		ID:         random(),
+		Name:       "cache-rebuild",
+		Image:      image,
+		Envs:       environ.Combine(envs, map[string]string{"PLUGIN_REBUILD": "true"}),
+		Secrets:    secrets,
+		IgnoreErr:  true,
+		RunPolicy:  engine.RunOnSuccess,
+		WorkingDir: cachePath,
+		Volumes:    []*engine.VolumeMount{mount},
+	}
+	for _, step := range spec.Steps {
+		if !step.Detach {
+			rebuild.DependsOn = append(rebuild.DependsOn, step.Name)
+		}
+	}
+	setupScriptPosix(func() string { return "" }, []string{"/bin/drone-cache"}, rebuild)
+	spec.Steps = append(spec.Steps, rebuild)
+}
+
+// helper function returns the cache key. The build cache is
+// keyed by repository, branch and pipeline. Pull requests use
+// the pull request reference instead of the branch, so that
+// pull requests do not share the build cache of the branch.
+func cacheKey(args Args) string {
+	branch := args.Build.Target
+	if args.Build.Event == drone.EventPullRequest {
+		branch = args.Build.Ref
+	}
+	return snapshotKey(args.Repo.Slug, branch, args.Stage.Name)
+}
+
+// helper function returns the absolute cache directories,
+// mapped to the sub-directory of the build cache volume in
+// which the directory is stored.
+func cacheDirs(paths []string, workspace string) map[string]string {
+	dirs := map[string]string{}
+	for _, p := range paths {
+		if !path.IsAbs(p) {
+			p = path.Join(workspace, p)
+		}
+		p = path.Clean(p)
+		dirs[p] = fmt.Sprintf("%x", sha1.Sum([]byte(p)))[:12]
+	}
+	return dirs
+}
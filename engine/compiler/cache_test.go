@@ -0,0 +1,143 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func testCacheArgs(event string) Args {
+	return Args{
+		Pipeline: &resource.Pipeline{
+			Cache: &resource.Cache{Paths: []string{"node_modules", "/go/pkg/mod"}},
+		},
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{Event: event, Target: "master", Ref: "refs/pull/1/head"},
+		Stage: &drone.Stage{Name: "default"},
+	}
+}
+
+func testCacheSpec() *engine.Spec {
+	return &engine.Spec{
+		Secrets: map[string]*engine.Secret{},
+		Steps: []*engine.Step{
+			{Name: "clone"},
+			{Name: "redis", Detach: true},
+			{Name: "test"},
+		},
+	}
+}
+
+func Test_configureCache_PVC(t *testing.T) {
+	c := &Compiler{Cache: Cache{Backend: "pvc", Size: 1024}}
+	spec := testCacheSpec()
+	c.configureCache(spec, testCacheArgs(drone.EventPush), "/drone/src")
+
+	if len(spec.Volumes) != 1 || spec.Volumes[0].Claim == nil {
+		t.Fatalf("Want build cache volume claim")
+	}
+	claim := spec.Volumes[0].Claim
+	if !claim.Provision || !claim.Keep || claim.Size != 1024 {
+		t.Errorf("Want kept volume claim provisioned, got %+v", claim)
+	}
+	// the claim is reused by builds of the same branch.
+	other := testCacheSpec()
+	c.configureCache(other, testCacheArgs(drone.EventCron), "/drone/src")
+	if got, want := other.Volumes[0].Claim.ClaimName, claim.ClaimName; got != want {
+		t.Errorf("Want claim %q reused, got %q", want, got)
+	}
+	// the claim is not shared with pull requests.
+	other = testCacheSpec()
+	c.configureCache(other, testCacheArgs(drone.EventPullRequest), "/drone/src")
+	if other.Volumes[0].Claim.ClaimName == claim.ClaimName {
+		t.Errorf("Want pull requests to use a separate claim")
+	}
+
+	if got := len(spec.Steps[0].Volumes); got != 0 {
+		t.Errorf("Want cache not mounted in the clone step, got %d mounts", got)
+	}
+	mounts := spec.Steps[2].Volumes
+	if len(mounts) != 2 {
+		t.Fatalf("Want 2 cache mounts, got %d", len(mounts))
+	}
+	if got, want := mounts[0].Path, "/drone/src/node_modules"; got != want {
+		t.Errorf("Want mount path %q, got %q", want, got)
+	}
+	if got, want := mounts[1].Path, "/go/pkg/mod"; got != want {
+		t.Errorf("Want mount path %q, got %q", want, got)
+	}
+	if mounts[0].SubPath == "" || mounts[0].SubPath == mounts[1].SubPath {
+		t.Errorf("Want a separate sub path for each cache directory")
+	}
+	if len(spec.Init) != 0 || len(spec.Steps) != 3 {
+		t.Errorf("Want no restore or rebuild steps with the pvc backend")
+	}
+}
+
+func Test_configureCache_S3(t *testing.T) {
+	c := &Compiler{Cache: Cache{Backend: "s3", Bucket: "cache", AccessKey: "key", SecretKey: "secret"}}
+	spec := testCacheSpec()
+	c.configureCache(spec, testCacheArgs(drone.EventPush), "/drone/src")
+
+	if len(spec.Volumes) != 1 || spec.Volumes[0].EmptyDir == nil {
+		t.Fatalf("Want build cache temp volume")
+	}
+	if len(spec.Init) != 1 {
+		t.Fatalf("Want cache restore init step")
+	}
+	if got := spec.Init[0].Envs["PLUGIN_RESTORE"]; got != "true" {
+		t.Errorf("Want restore enabled, got %q", got)
+	}
+	if s := spec.Secrets[cacheSecretKey]; s == nil || s.Data != "secret" || !s.Mask || s.Local {
+		t.Errorf("Want masked object store credentials in the pipeline secret")
+	}
+	rebuild := spec.Steps[len(spec.Steps)-1]
+	if rebuild.Name != "cache-rebuild" {
+		t.Fatalf("Want cache rebuild step")
+	}
+	if rebuild.RunPolicy != engine.RunOnSuccess || !rebuild.IgnoreErr {
+		t.Errorf("Want cache rebuild on success, ignoring errors")
+	}
+	if got, want := len(rebuild.DependsOn), 2; got != want {
+		t.Errorf("Want rebuild to depend on %d steps, got %d", want, got)
+	}
+
+	// the build cache is not rebuilt by pull requests.
+	spec = testCacheSpec()
+	c.configureCache(spec, testCacheArgs(drone.EventPullRequest), "/drone/src")
+	if len(spec.Init) != 1 || len(spec.Steps) != 3 {
+		t.Errorf("Want cache restored but not rebuilt for pull requests")
+	}
+}
+
+func Test_configureCache_S3_Untrusted(t *testing.T) {
+	c := &Compiler{
+		Cache:       Cache{Backend: "s3", Bucket: "cache", AccessKey: "key", SecretKey: "secret"},
+		SecureForks: true,
+	}
+	args := testCacheArgs(drone.EventPullRequest)
+	args.Build.Fork = "spaceghost/hello-world"
+	spec := testCacheSpec()
+	c.configureCache(spec, args, "/drone/src")
+	if len(spec.Init) != 0 || len(spec.Steps) != 3 {
+		t.Errorf("Want cache not restored or rebuilt for untrusted builds")
+	}
+	if len(spec.Secrets) != 0 {
+		t.Errorf("Want no object store credentials for untrusted builds")
+	}
+}
+
+func Test_configureCache_Disabled(t *testing.T) {
+	spec := testCacheSpec()
+	new(Compiler).configureCache(spec, testCacheArgs(drone.EventPush), "/drone/src")
+	if len(spec.Volumes) != 0 || len(spec.Steps[2].Volumes) != 0 {
+		t.Errorf("Want build cache disabled without a backend")
+	}
+}
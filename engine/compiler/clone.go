@@ -6,6 +6,7 @@ package compiler
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
@@ -26,9 +27,13 @@ func cloneImage(platform manifest.Platform) string {
 	}
 }
 
-// helper function configures the clone depth parameter,
-// specific to the clone plugin.
-func cloneParams(src manifest.Clone) map[string]string {
+// helper function configures the clone parameters, specific to
+// the clone plugin. The sparse checkout, partial clone, mirror,
+// submodule credentials, shallow since and commit pinning
+// parameters are not supported by the default clone image, and
+// the linter rejects the options unless the runner is
+// configured with a clone image that supports them.
+func cloneParams(src resource.Clone) map[string]string {
 	dst := map[string]string{}
 	if depth := src.Depth; depth > 0 {
 		dst["PLUGIN_DEPTH"] = strconv.Itoa(depth)
@@ -37,6 +42,37 @@ func cloneParams(src manifest.Clone) map[string]string {
 		dst["GIT_SSL_NO_VERIFY"] = "true"
 		dst["PLUGIN_SKIP_VERIFY"] = "true"
 	}
+	if src.LFS {
+		dst["PLUGIN_LFS"] = "true"
+	}
+	if len(src.Sparse) > 0 {
+		dst["PLUGIN_SPARSE_CHECKOUT"] = strings.Join(src.Sparse, ",")
+	}
+	if filter := src.Filter; filter != "" {
+		dst["PLUGIN_FILTER"] = filter
+	}
+	if src.Recursive {
+		dst["PLUGIN_RECURSIVE"] = "true"
+	}
+	if src.SubmoduleRemote {
+		dst["PLUGIN_SUBMODULE_UPDATE_REMOTE"] = "true"
+	}
+	if creds := src.SubmoduleCredentials; creds != "" {
+		dst["PLUGIN_SUBMODULE_CREDENTIALS"] = creds
+	}
+	if mirror := src.Mirror; mirror != "" {
+		dst["PLUGIN_MIRROR"] = mirror
+	}
+	if since := src.ShallowSince; since != "" {
+		dst["PLUGIN_SHALLOW_SINCE"] = since
+	}
+	if src.Pin {
+		dst["PLUGIN_PIN_COMMIT"] = "true"
+		dst["PLUGIN_PIN_FALLBACK"] = "ref"
+		if fallback := src.PinFallback; fallback != "" {
+			dst["PLUGIN_PIN_FALLBACK"] = fallback
+		}
+	}
 	return dst
 }
 
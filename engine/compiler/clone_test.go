@@ -77,7 +77,7 @@ func TestCloneDisable(t *testing.T) {
 		System:   &drone.System{},
 		Netrc:    &drone.Netrc{},
 		Manifest: &manifest.Manifest{},
-		Pipeline: &resource.Pipeline{Clone: manifest.Clone{Disable: true}},
+		Pipeline: &resource.Pipeline{Clone: resource.Clone{Clone: manifest.Clone{Disable: true}}},
 	}
 	got := c.Compile(nocontext, args)
 	if len(got.Steps) != 0 {
@@ -92,7 +92,7 @@ func TestCloneCreate(t *testing.T) {
 		RunPolicy: engine.RunAlways,
 		Envs:      map[string]string{"PLUGIN_DEPTH": "50"},
 	}
-	src := &resource.Pipeline{Clone: manifest.Clone{Depth: 50}}
+	src := &resource.Pipeline{Clone: resource.Clone{Clone: manifest.Clone{Depth: 50}}}
 	got := createClone(src)
 	if diff := cmp.Diff(got, want); len(diff) != 0 {
 		t.Errorf(diff)
@@ -126,15 +126,15 @@ func TestCloneImage(t *testing.T) {
 }
 
 func TestCloneParams(t *testing.T) {
-	params := cloneParams(manifest.Clone{})
+	params := cloneParams(resource.Clone{})
 	if len(params) != 0 {
 		t.Errorf("Expect empty clone parameters")
 	}
-	params = cloneParams(manifest.Clone{Depth: 0})
+	params = cloneParams(resource.Clone{Clone: manifest.Clone{Depth: 0}})
 	if len(params) != 0 {
 		t.Errorf("Expect zero depth ignored")
 	}
-	params = cloneParams(manifest.Clone{Depth: 50, SkipVerify: true})
+	params = cloneParams(resource.Clone{Clone: manifest.Clone{Depth: 50, SkipVerify: true}})
 	if params["PLUGIN_DEPTH"] != "50" {
 		t.Errorf("Expect clone depth 50")
 	}
@@ -145,3 +145,62 @@ func TestCloneParams(t *testing.T) {
 		t.Errorf("Expect PLUGIN_SKIP_VERIFY is true")
 	}
 }
+
+func TestCloneParams_Partial(t *testing.T) {
+	params := cloneParams(resource.Clone{
+		LFS:    true,
+		Sparse: []string{"docs", "src/api"},
+		Filter: "blob:none",
+	})
+	if params["PLUGIN_LFS"] != "true" {
+		t.Errorf("Expect PLUGIN_LFS is true")
+	}
+	if params["PLUGIN_SPARSE_CHECKOUT"] != "docs,src/api" {
+		t.Errorf("Expect sparse checkout paths")
+	}
+	if params["PLUGIN_FILTER"] != "blob:none" {
+		t.Errorf("Expect partial clone filter")
+	}
+}
+
+func TestCloneParams_Submodules(t *testing.T) {
+	params := cloneParams(resource.Clone{
+		Recursive:            true,
+		SubmoduleRemote:      true,
+		SubmoduleCredentials: "none",
+		Mirror:               "https://mirror.company.com/octocat/hello-world.git",
+	})
+	if params["PLUGIN_RECURSIVE"] != "true" {
+		t.Errorf("Expect PLUGIN_RECURSIVE is true")
+	}
+	if params["PLUGIN_SUBMODULE_UPDATE_REMOTE"] != "true" {
+		t.Errorf("Expect PLUGIN_SUBMODULE_UPDATE_REMOTE is true")
+	}
+	if params["PLUGIN_SUBMODULE_CREDENTIALS"] != "none" {
+		t.Errorf("Expect submodule credentials none")
+	}
+	if params["PLUGIN_MIRROR"] == "" {
+		t.Errorf("Expect mirror remote")
+	}
+}
+
+func TestCloneParams_Pin(t *testing.T) {
+	params := cloneParams(resource.Clone{Pin: true})
+	if params["PLUGIN_PIN_COMMIT"] != "true" {
+		t.Errorf("Expect PLUGIN_PIN_COMMIT is true")
+	}
+	if params["PLUGIN_PIN_FALLBACK"] != "ref" {
+		t.Errorf("Expect default pin fallback ref")
+	}
+	params = cloneParams(resource.Clone{Pin: true, PinFallback: "fail"})
+	if params["PLUGIN_PIN_FALLBACK"] != "fail" {
+		t.Errorf("Expect pin fallback fail")
+	}
+	params = cloneParams(resource.Clone{ShallowSince: "2019-01-01"})
+	if params["PLUGIN_SHALLOW_SINCE"] != "2019-01-01" {
+		t.Errorf("Expect shallow since date")
+	}
+	if _, ok := params["PLUGIN_PIN_FALLBACK"]; ok {
+		t.Errorf("Expect pin fallback ignored when pin disabled")
+	}
+}
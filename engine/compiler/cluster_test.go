@@ -0,0 +1,46 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/registry"
+	"github.com/drone/runner-go/secret"
+)
+
+func TestCluster(t *testing.T) {
+	c := &Compiler{
+		Registry: registry.Static(nil),
+		Secret:   secret.StaticVars(map[string]string{"kubeconfig": "apiVersion: v1"}),
+	}
+	args := Args{
+		Repo:     &drone.Repo{},
+		Build:    &drone.Build{},
+		Stage:    &drone.Stage{},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: &manifest.Manifest{},
+		Pipeline: &resource.Pipeline{},
+	}
+	if got := c.Compile(nocontext, args); got.Cluster != nil {
+		t.Errorf("Want pipeline runs in the runner cluster")
+	}
+
+	args.Pipeline.Cluster = &resource.Cluster{
+		Kubeconfig: &manifest.Variable{Secret: "kubeconfig"},
+	}
+	got := c.Compile(nocontext, args).Cluster
+	if got == nil {
+		t.Errorf("Want pipeline cluster")
+		return
+	}
+	if got.Secret != "kubeconfig" || string(got.Kubeconfig) != "apiVersion: v1" {
+		t.Errorf("Want kubeconfig sourced from the secret, got %q", got.Kubeconfig)
+	}
+}
@@ -7,13 +7,20 @@ package compiler
 import (
 	"context"
 	"fmt"
+	"path"
 	"strings"
-
-	"k8s.io/apimachinery/pkg/util/validation"
+	"time"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/internal/changeset"
+	"github.com/drone-runners/drone-runner-kube/internal/credentials"
 	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+	"github.com/drone-runners/drone-runner-kube/internal/docker/inspect"
+	"github.com/drone-runners/drone-runner-kube/internal/feature"
+	"github.com/drone-runners/drone-runner-kube/internal/planner"
+	"github.com/drone-runners/drone-runner-kube/internal/settings"
+	"github.com/drone-runners/drone-runner-kube/internal/timeouts"
 
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/clone"
@@ -63,6 +70,247 @@ type (
 		DNSConfig map[string][]string
 	}
 
+	// SecurityPolicy defines the bounds within which the
+	// pipeline can override the default security context.
+	SecurityPolicy struct {
+		// MinUser provides the minimum uid, gid and fs group
+		// that a pipeline or step can select.
+		MinUser int64
+
+		// MaxUser provides the maximum uid, gid and fs group
+		// that a pipeline or step can select. Unbounded if zero.
+		MaxUser int64
+
+		// SeccompProfiles provides the seccomp profiles that a
+		// pipeline can select.
+		SeccompProfiles []string
+	}
+
+	// Workspace describes the workspace volume.
+	Workspace struct {
+		// Backend provides the name of the workspace backend,
+		// either a built-in backend (emptydir, pvc, snapshot or
+		// objectstore) or a backend registered with
+		// RegisterWorkspaceBackend. If empty, the backend is
+		// selected by the Claim and Snapshot fields.
+		Backend string
+
+		// Claim enables a dynamically provisioned persistent
+		// volume claim for the workspace, instead of an emptyDir
+		// volume.
+		Claim bool
+
+		// StorageClass provides the storage class used to
+		// provision the workspace volume claim.
+		StorageClass string
+
+		// AccessMode provides the access mode of the workspace
+		// volume claim. Defaults to ReadWriteOnce.
+		AccessMode string
+
+		// Size provides the requested size of the workspace
+		// volume claim, in bytes.
+		Size int64
+
+		// Snapshot enables restoring the workspace volume claim
+		// from a snapshot of the most recent successful build of
+		// the same branch.
+		Snapshot bool
+
+		// SnapshotClass provides the volume snapshot class used
+		// to snapshot the workspace volume claim.
+		SnapshotClass string
+	}
+
+	// Cache describes the build cache backend.
+	Cache struct {
+		// Backend provides the build cache backend, either pvc
+		// or s3. The build cache is disabled if empty.
+		Backend string
+
+		// StorageClass, AccessMode and Size configure the
+		// volume claims of the pvc backend.
+		StorageClass string
+		AccessMode   string
+		Size         int64
+
+		// Image provides the image used to restore and rebuild
+		// the build cache with the s3 backend.
+		Image string
+
+		// Bucket, Endpoint, Region and PathStyle configure the
+		// s3-compatible object store of the s3 backend.
+		Bucket    string
+		Endpoint  string
+		Region    string
+		PathStyle bool
+
+		// AccessKey and SecretKey provide the credentials of
+		// the s3-compatible object store.
+		AccessKey string
+		SecretKey string
+	}
+
+	// RepoCache describes the repository cache, a volume claim
+	// per repository that is mounted into every step and
+	// reused by the pipelines of the repository, so that tool
+	// caches are reused without per-pipeline configuration.
+	RepoCache struct {
+		// Enabled enables the repository cache.
+		Enabled bool
+
+		// StorageClass, AccessMode and Size configure the
+		// volume claims. The size caps the cache of each
+		// repository.
+		StorageClass string
+		AccessMode   string
+		Size         int64
+
+		// Tools maps the environment variables read by the
+		// build tools, for example GRADLE_USER_HOME or
+		// SCCACHE_DIR, to the cache sub-directory.
+		Tools map[string]string
+	}
+
+	// Artifacts describes the cross-stage artifacts, which
+	// are uploaded when a pipeline succeeds and downloaded by
+	// the dependent pipelines of the build.
+	Artifacts struct {
+		// Image provides the image used to upload and download
+		// the artifacts, which must include the minio client,
+		// tar and sha256sum.
+		Image string
+
+		// Limit provides the maximum size of the artifacts of
+		// a pipeline, in bytes. The size is not limited if zero.
+		Limit int64
+	}
+
+	// Mirrors provides the in-cluster package mirrors that
+	// pipeline steps are configured to use.
+	Mirrors struct {
+		// NPM, PyPI and GoProxy provide the urls of the npm
+		// registry, the pip package index and the go module
+		// proxy.
+		NPM     string
+		PyPI    string
+		GoProxy string
+
+		// Apt provides the url of the apt caching proxy, for
+		// example apt-cacher-ng.
+		Apt string
+
+		// Hosts provides the addresses of the mirror hostnames,
+		// resolved by the runner, that are added to the pod
+		// hosts file so that steps do not resolve the mirrors.
+		Hosts map[string]string
+	}
+
+	// ProxyCache provides the dependency caching proxies that
+	// pipeline steps are configured to use.
+	ProxyCache struct {
+		// Node uses the proxies running on the pipeline node,
+		// for example in a DaemonSet with host ports, instead
+		// of running the proxies in the pipeline pod.
+		Node bool
+
+		// Go, PyPI and NPM provide the go module proxy, the
+		// pip package index and the npm registry proxies. A
+		// proxy is disabled if the image is empty.
+		Go   Proxy
+		PyPI Proxy
+		NPM  Proxy
+	}
+
+	// Antivirus provides the scanner that scans the workspace
+	// for malware, which is required in some environments.
+	Antivirus struct {
+		// Image provides the scanner image, which includes
+		// clamdscan for clamd endpoints, or c-icap-client for
+		// icap endpoints.
+		Image string
+
+		// Endpoint provides the address of the scanner, in
+		// clamd://host:port or icap://host:port/service format.
+		Endpoint string
+
+		// Clone and Build enable scanning the workspace after
+		// the repository is cloned, and after the pipeline
+		// steps complete.
+		Clone bool
+		Build bool
+
+		// Flag reports detections without failing the build.
+		Flag bool
+	}
+
+	// Publish provides the builder images of the publish
+	// steps, which build and publish images without
+	// privileges.
+	Publish struct {
+		// Kaniko provides the kaniko image, which must include
+		// a shell, for example the debug image.
+		Kaniko string
+
+		// Buildah provides the buildah image.
+		Buildah string
+	}
+
+	// Deploy provides the tool images of the deploy steps.
+	Deploy struct {
+		// Helm provides the helm image.
+		Helm string
+
+		// Kubectl provides the kubectl image used to deploy
+		// kustomize directories.
+		Kubectl string
+	}
+
+	// Locale provides the default timezone and locale of the
+	// pipeline steps, so that date-sensitive tests behave the
+	// same on every cluster.
+	Locale struct {
+		// Timezone provides the timezone name, for example
+		// Europe/Berlin, exported as the TZ variable.
+		Timezone string
+
+		// Lang provides the locale, for example en_US.UTF-8,
+		// exported as the LANG and LC_ALL variables.
+		Lang string
+
+		// Localtime mounts the timezone database of the node
+		// in the steps, including /etc/localtime.
+		Localtime bool
+	}
+
+	// Topology provides the node labels of the zone and the
+	// region, which the pipeline zone and region select.
+	Topology struct {
+		// ZoneLabel provides the zone label, which defaults
+		// to topology.kubernetes.io/zone.
+		ZoneLabel string
+
+		// RegionLabel provides the region label, which
+		// defaults to topology.kubernetes.io/region.
+		RegionLabel string
+	}
+
+	// Proxy provides a dependency caching proxy.
+	Proxy struct {
+		// Image provides the proxy image, for example athens,
+		// devpi or verdaccio.
+		Image string
+
+		// Port and Path provide the port the proxy listens on
+		// and the path of the proxy endpoint.
+		Port int
+		Path string
+
+		// Envs provides the environment of the proxy container,
+		// for example to configure a shared storage backend.
+		Envs map[string]string
+	}
+
 	// Args provides compiler arguments.
 	Args struct {
 		// Manifest provides the parsed manifest.
@@ -120,6 +368,11 @@ type (
 		// to each container by default.
 		Annotations map[string]string
 
+		// NodeSelector provides the default node selector of
+		// the pipeline pods, which is extended by the node
+		// selector of the repository settings and the pipeline.
+		NodeSelector map[string]string
+
 		// Privileged provides a list of docker images that
 		// are always privileged.
 		Privileged []string
@@ -132,10 +385,48 @@ type (
 		// used to pull private container images.
 		Registry registry.Provider
 
+		// Settings returns repository settings that override
+		// the runner defaults.
+		Settings settings.Provider
+
+		// Inspector returns the image configuration, used to
+		// execute the entrypoint of plugin images.
+		Inspector *inspect.Inspector
+
+		// Changeset returns the files changed by the build,
+		// used to evaluate the path conditions of the steps
+		// when the pipeline is compiled.
+		Changeset changeset.Provider
+
+		// Planner computes the pipelines and steps of monorepo
+		// builds affected by the changed files, based on path
+		// ownership rules. Unaffected steps are skipped.
+		Planner *planner.Planner
+
+		// Credentials returns short-lived credentials that are
+		// injected into the steps of deployment pipelines.
+		Credentials credentials.Provider
+
+		// RobotImages provides the images of the publish steps
+		// that receive short-lived registry credentials, scoped
+		// to the image namespace of the repository. The
+		// credentials are minted by the engine.
+		RobotImages []string
+
 		// Resources defines resource limits that are applied by
 		// default to all pipeline containers if none exist.
 		Resources Resources
 
+		// MaxResources defines the maximum resources of a
+		// step. Step resources that exceed the maximum are
+		// capped at the maximum.
+		MaxResources ResourceObject
+
+		// ResourceProfiles provides the named resource profiles
+		// that pipelines and steps can reference instead of
+		// defining resources.
+		ResourceProfiles map[string]*ResourceProfile
+
 		// Cloner provides an option to override the default clone
 		// image used to clone the repository when the pipeline
 		// initializes.
@@ -145,13 +436,227 @@ type (
 		// when no namespace is provided.
 		Namespace string
 
+		// NamespacePool provides a pool of namespaces that are
+		// assigned to pipelines in round-robin order when no
+		// namespace is provided. The pool takes precedence over
+		// the default namespace.
+		NamespacePool *NamespacePool
+
 		// ServiceAccount provides the default kubernetes Service Account
 		// when no Service Account is provided.
 		ServiceAccount string
 
+		// AutomountToken mounts the Service Account token in
+		// the pipeline pod by default. When false, the token is
+		// only mounted if the pipeline opts in.
+		AutomountToken bool
+
 		// DNS provides the default kubernetes DNS
 		// when no DNS is provided.
 		DNS DNS
+
+		// Metadata enables writing a json file with the build
+		// metadata to the workspace before the repository is
+		// cloned.
+		Metadata bool
+
+		// SecurityContext provides the default pod security
+		// context, for example to run pipelines in namespaces
+		// that enforce the restricted pod security standard.
+		SecurityContext engine.SecurityContext
+
+		// SecurityPolicy provides the bounds within which the
+		// pipeline can override the default security context.
+		SecurityPolicy SecurityPolicy
+
+		// Shellless provides a list of docker images that do
+		// not include a shell, for example distroless images.
+		// Shell-less images are opt-in: the images are not
+		// inspected for a shell, so images that are not listed
+		// must select the none shell. The steps are executed
+		// with a static shell installed by an init step, since
+		// the step commands are executed in the running
+		// container and not as the image entrypoint.
+		Shellless []string
+
+		// ShellImage provides an option to override the default
+		// image used to install a static shell for images that
+		// do not include a shell.
+		ShellImage string
+
+		// ExecTemplates provides the templates of the command
+		// used to execute the step script, keyed by os/shell
+		// or os, for example linux/bash or linux. The template
+		// replaces the default echo "$DRONE_SCRIPT" | sh, so
+		// that images without the default utilities or with a
+		// non-posix shell can execute steps.
+		ExecTemplates map[string]string
+
+		// PullSecrets provides the names of existing image pull
+		// secrets that are added to every pipeline pod, in
+		// addition to the pull secret created by the runner.
+		PullSecrets []string
+
+		// Finalizer adds the cleanup finalizer to the pipeline
+		// pod. This requires the cleanup controller is running,
+		// otherwise pod deletion is blocked.
+		Finalizer bool
+
+		// Isolate creates each pipeline in an ephemeral
+		// namespace that is deleted with the pipeline. The
+		// pipeline runs as the service account created in the
+		// namespace, and concurrency locks are held in the
+		// default namespace.
+		Isolate bool
+
+		// Workspace provides the workspace volume configuration.
+		Workspace Workspace
+
+		// Cache provides the build cache configuration. The
+		// cache directories of the pipeline are restored when
+		// the pipeline starts, and saved when it completes.
+		Cache Cache
+
+		// RepoCache provides the repository cache
+		// configuration.
+		RepoCache RepoCache
+
+		// Artifacts provides the cross-stage artifacts
+		// configuration. The artifacts are stored in the object
+		// store of the s3 build cache backend.
+		Artifacts Artifacts
+
+		// Mirrors provides the in-cluster package mirrors. The
+		// steps are configured to use the mirrors unless the
+		// pipeline overrides the configuration.
+		Mirrors Mirrors
+
+		// ProxyCache provides the dependency caching proxies.
+		// The proxies take precedence over the mirrors.
+		ProxyCache ProxyCache
+
+		// Antivirus provides the scanner that scans the pipeline
+		// workspace. The workspace is not scanned if the image
+		// or the endpoint is empty.
+		Antivirus Antivirus
+
+		// Publish provides the builder images of the publish
+		// steps.
+		Publish Publish
+
+		// Deploy provides the tool images of the deploy steps.
+		Deploy Deploy
+
+		// Locale provides the default timezone and locale of
+		// the pipeline steps, which the pipeline can override.
+		Locale Locale
+
+		// Topology provides the node labels selected by the
+		// pipeline zone and region.
+		Topology Topology
+
+		// PodTemplate provides a json-encoded pod that is merged
+		// with every pipeline pod. This gives operators the option
+		// to add cluster-specific configuration, for example
+		// tolerations, sidecars or a security context.
+		PodTemplate []byte
+
+		// Sidecars provides a list of containers that are injected
+		// into every pipeline pod.
+		Sidecars []*engine.Sidecar
+
+		// LabelPrefix provides an option to override the default
+		// prefix of the labels and annotations added to objects
+		// created by the runner.
+		LabelPrefix string
+
+		// UsageInterval provides the interval at which the step
+		// resource usage is written to the step output. Usage
+		// is not reported if zero.
+		UsageInterval time.Duration
+
+		// KeepAlive provides the interval at which keep-alive
+		// traffic is written to the exec stream of the step,
+		// so that proxies do not close the stream of steps that
+		// do not write output. Disabled if zero.
+		KeepAlive time.Duration
+
+		// Watchdog provides the interval after which the step
+		// container is probed if the exec stream does not write
+		// output, to detect streams that are not closed after
+		// the step exits. Disabled if zero.
+		Watchdog time.Duration
+
+		// NonEvictable prevents the cluster autoscaler and the
+		// descheduler from evicting pipeline pods, so that long
+		// running builds are not restarted mid-build.
+		NonEvictable bool
+
+		// Pending provides the interval at which the reason
+		// the pod is pending is written to the step output.
+		// Pending reasons are not reported if zero.
+		Pending time.Duration
+
+		// Timeouts provides the schedule, pull, default step
+		// and teardown timeouts of the pipelines, which can be
+		// overridden per repository. Not enforced if nil.
+		Timeouts *timeouts.Table
+
+		// Outputs enables passing the variables a step writes
+		// to the DRONE_OUTPUT file to subsequent steps.
+		Outputs bool
+
+		// Janitor enables deleting the resources created by the
+		// pipeline steps when the pipeline is destroyed. The
+		// steps label the resources with the label provided by
+		// the DRONE_JANITOR_LABEL variable.
+		Janitor bool
+
+		// SecretScan provides the policy for secrets detected
+		// in the step output that are not masked. The step
+		// output is not scanned if none.
+		SecretScan engine.ScanPolicy
+
+		// ShortSHA provides the length of the short commit sha
+		// provided to each pipeline step. Defaults to 8.
+		ShortSHA int
+
+		// SecureForks enables the security mode for untrusted
+		// builds. Secrets are omitted, steps are unprivileged,
+		// and step images are always pulled.
+		SecureForks bool
+
+		// EnvFilters provides a list of filters that remove or
+		// override environment variables in the steps of
+		// untrusted builds.
+		EnvFilters []*EnvFilter
+
+		// BlockMetadata blocks access to the cloud provider
+		// metadata endpoints, so that steps cannot read the
+		// node credentials. Valid values are all and untrusted.
+		BlockMetadata string
+
+		// LogStream enables streaming the step output from the
+		// container logs, which can be resumed if the stream is
+		// disconnected.
+		LogStream bool
+
+		// Features provides the feature flags and the percentage
+		// of builds for which each feature is enabled.
+		Features feature.Flags
+
+		// KVMResource provides the device plugin resource name
+		// of the kvm device requested by kvm steps.
+		KVMResource string
+
+		// NodeHelper provides the helper pods used to execute
+		// node steps on the pipeline node.
+		NodeHelper *engine.NodeHelper
+
+		// IPFamily provides the ip family of the pod network,
+		// used to resolve service hostnames to the loopback
+		// address. Valid values are ipv4, ipv6 and dual.
+		IPFamily string
 	}
 )
 
@@ -162,6 +667,24 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 	// create the workspace paths
 	workspace := createWorkspace(args.Pipeline)
 
+	// get the repository settings from the settings extension.
+	// The settings override the runner defaults.
+	workspaceConfig := c.Workspace
+	var nodeSelector map[string]string
+	if c.Settings != nil {
+		s, err := c.Settings.Find(ctx, &settings.Request{
+			Repo:  args.Repo,
+			Build: args.Build,
+		})
+		if err != nil {
+			// TODO return an error to the caller if the
+			// provider returns an error.
+		} else if s != nil {
+			workspaceConfig = applyWorkspaceSettings(c.Workspace, s.Workspace)
+			nodeSelector = s.NodeSelector
+		}
+	}
+
 	// create labels
 	podLabels := labels.Combine(
 		c.Labels,
@@ -176,6 +699,7 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		labels.FromStage(args.Stage),
 		labels.FromSystem(args.System),
 		labels.WithTimeout(args.Repo),
+		evictionAnnotations(c.NonEvictable),
 		args.Pipeline.Metadata.Annotations,
 	)
 
@@ -185,13 +709,15 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		Path: workspace,
 	}
 
-	// create the workspace volume
-	workVolume := &engine.Volume{
-		EmptyDir: &engine.VolumeEmptyDir{
-			ID:   random(),
-			Name: workMount.Name,
-		},
+	// create the workspace volume with the workspace backend,
+	// for example an emptyDir volume or a volume claim.
+	workBackend := lookupWorkspaceBackend(workspaceConfig)
+	workArgs := WorkspaceArgs{
+		Args:   args,
+		Config: workspaceConfig,
+		Mount:  workMount,
 	}
+	workVolume := workBackend.Volume(c, workArgs)
 
 	// create the statuses volume
 	statusMount := &engine.VolumeMount{
@@ -199,10 +725,12 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		Path: "/run/drone",
 	}
 
-	// create the statuses DownwardAPI volume
+	// create the statuses DownwardAPI volume. The volume has a
+	// well-known name, so that injected sidecars can mount the
+	// build metadata annotations.
 	statusVolume := &engine.Volume{
 		DownwardAPI: &engine.VolumeDownwardAPI{
-			ID:   random(),
+			ID:   MetadataVolume,
 			Name: statusMount.Name,
 			Items: []engine.VolumeDownwardAPIItem{
 				{
@@ -221,19 +749,24 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 			Annotations:        podAnnotations,
 			NodeName:           args.Pipeline.NodeName,
 			NodeSelector:       args.Pipeline.NodeSelector,
+			RuntimeClassName:   args.Pipeline.RuntimeClassName,
 			ServiceAccountName: args.Pipeline.ServiceAccountName,
 		},
 		Platform: engine.Platform{
-			OS:      args.Pipeline.Platform.OS,
-			Arch:    args.Pipeline.Platform.Arch,
-			Variant: args.Pipeline.Platform.Variant,
-			Version: args.Pipeline.Platform.Version,
+			OS:       args.Pipeline.Platform.OS,
+			Arch:     args.Pipeline.Platform.Arch,
+			Variant:  args.Pipeline.Platform.Variant,
+			Version:  args.Pipeline.Platform.Version,
+			Features: args.Pipeline.Features,
 		},
 		Secrets: map[string]*engine.Secret{},
 		Volumes: []*engine.Volume{workVolume, statusVolume},
 	}
 
 	// set default namespace and ensure maps are non-nil
+	if spec.PodSpec.Namespace == "" {
+		spec.PodSpec.Namespace = c.NamespacePool.Next()
+	}
 	if spec.PodSpec.Namespace == "" {
 		spec.PodSpec.Namespace = c.Namespace
 	}
@@ -247,6 +780,11 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 	if spec.PodSpec.ServiceAccountName == "" {
 		spec.PodSpec.ServiceAccountName = c.ServiceAccount
 	}
+	// the service account token is not mounted unless the
+	// pipeline or the runner opts in.
+	spec.PodSpec.AutomountToken = args.Pipeline.AutomountServiceAccountToken || c.AutomountToken
+	// set the existing image pull secrets
+	spec.PodSpec.PullSecrets = c.PullSecrets
 
 	if args.Pipeline.DNS == nil {
 		spec.PodSpec.DNS = engine.DNS{
@@ -260,9 +798,20 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		}
 	}
 
+	// the node selector from the runner and the repository
+	// settings can be extended by the pipeline node selector.
+	if len(c.NodeSelector) != 0 || len(nodeSelector) != 0 {
+		spec.PodSpec.NodeSelector = labels.Combine(c.NodeSelector, nodeSelector, args.Pipeline.NodeSelector)
+	}
+
+	// the pipeline zone and region are added to the node
+	// selector, so that builds run where their data lives.
+	configureTopology(spec, c.Topology, args.Pipeline.Zone, args.Pipeline.Region)
+
 	// add tolerations
 	for _, toleration := range args.Pipeline.Tolerations {
 		spec.PodSpec.Tolerations = append(spec.PodSpec.Tolerations, engine.Toleration{
+			Key:               toleration.Key,
 			Operator:          toleration.Operator,
 			Effect:            toleration.Effect,
 			TolerationSeconds: toleration.TolerationSeconds,
@@ -270,6 +819,14 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		})
 	}
 
+	// add node affinity
+	if affinity := args.Pipeline.Affinity; affinity != nil {
+		spec.PodSpec.Affinity = &engine.Affinity{
+			Required:  toNodeRequirements(affinity.Required),
+			Preferred: toNodeRequirements(affinity.Preferred),
+		}
+	}
+
 	// create the default environment variables.
 	envs := environ.Combine(
 		c.Environ,
@@ -281,6 +838,7 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		environ.Build(args.Build),
 		environ.Stage(args.Stage),
 		environ.Link(args.Repo, args.Build, args.System),
+		versionEnviron(args.Build, c.ShortSHA),
 		clone.Environ(clone.Config{
 			SkipVerify: args.Pipeline.Clone.SkipVerify,
 			Trace:      args.Pipeline.Clone.Trace,
@@ -291,13 +849,32 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		}),
 	)
 
+	// services are reached using the loopback address and
+	// are excluded from the proxy, as are the in-cluster
+	// package mirrors.
+	hostnames := serviceHostnames(args.Pipeline)
+	mirrors := c.configureProxyCache(spec)
+	mirrorEnviron(envs, mirrors)
+	configureNoProxy(envs, append(mirrors.Hostnames(), hostnames...))
+
+	// the timezone and locale of the pipeline take precedence
+	// over the runner defaults.
+	timezone, lang := c.Locale.Timezone, c.Locale.Lang
+	if args.Pipeline.Timezone != "" {
+		timezone = args.Pipeline.Timezone
+	}
+	if args.Pipeline.Locale != "" {
+		lang = args.Pipeline.Locale
+	}
+	localeEnviron(envs, timezone, lang)
+
 	// create the workspace variables
 	envs["DRONE_WORKSPACE"] = workspace
 
 	// create volume reference variables
 	if workVolume.EmptyDir != nil {
 		envs["DRONE_DOCKER_VOLUME_ID"] = workVolume.EmptyDir.ID
-	} else {
+	} else if workVolume.HostPath != nil {
 		envs["DRONE_DOCKER_VOLUME_PATH"] = workVolume.HostPath.Path
 	}
 
@@ -324,18 +901,99 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		}
 	}
 
+	// set the pod template
+	if len(c.PodTemplate) != 0 {
+		spec.PodSpec.Template = c.PodTemplate
+	}
+
+	// inject the runner sidecars
+	spec.Sidecars = append(spec.Sidecars, c.Sidecars...)
+
+	// configure the concurrency group. The group is scoped to
+	// the repository, so that pipelines in other repositories
+	// cannot acquire the concurrency locks.
+	if group := args.Pipeline.Concurrency.Group; group != "" {
+		spec.Concurrency = &engine.Concurrency{
+			Group: args.Repo.Slug + "/" + group,
+			Limit: args.Pipeline.Concurrency.Limit,
+		}
+	}
+
+	// report the step resource usage.
+	if c.UsageInterval > 0 {
+		spec.UsageInterval = int64(c.UsageInterval / time.Second)
+	}
+
+	// report the reason the pod is pending.
+	if c.Pending >= time.Second {
+		spec.PendingInterval = int64(c.Pending / time.Second)
+	}
+
+	// keep the exec stream of quiet steps active.
+	if c.KeepAlive >= time.Second {
+		spec.KeepAlive = int64(c.KeepAlive / time.Second)
+	}
+
+	// detect exec streams that are not closed after the step
+	// exits.
+	if c.Watchdog >= time.Second {
+		spec.Watchdog = int64(c.Watchdog / time.Second)
+	}
+
+	// scan the step output for secrets.
+	spec.SecretScan = c.SecretScan
+
+	// stream the step output from the container logs.
+	spec.LogStream = c.LogStream
+
+	// select the features enabled for the build. Every stage
+	// of the build is assigned the same features.
+	spec.Features = c.Features.Select(
+		fmt.Sprintf("%s/%d", args.Repo.Slug, args.Build.Number),
+	)
+
+	// add the cleanup finalizer to guarantee teardown of
+	// the pipeline resources.
+	if c.Finalizer {
+		spec.PodSpec.Finalizers = []string{engine.Finalizer}
+	}
+
+	// isolated pipelines are created in a namespace named
+	// after the pipeline pod. The pipeline resources are
+	// deleted with the namespace, so the cleanup finalizer
+	// is not required.
+	if c.Isolate {
+		if spec.Concurrency != nil {
+			spec.Concurrency.Namespace = spec.PodSpec.Namespace
+		}
+		spec.PodSpec.Namespace = spec.PodSpec.Name
+		spec.PodSpec.Isolated = true
+		spec.PodSpec.ServiceAccountName = engine.IsolationServiceAccount
+		spec.PodSpec.Finalizers = nil
+	}
+
 	// set platform if needed
 	if arch == "arm" || arch == "arm64" {
 		spec.PodSpec.Labels["kubernetes.io/arch"] = arch
 	}
 
 	// set drone labels
-	spec.PodSpec.Labels["io.drone"] = "true"
-	spec.PodSpec.Labels["io.drone.name"] = spec.PodSpec.Name
-	spec.PodSpec.Labels["io.drone.repo.namespace"] = slug.Make(args.Repo.Namespace)
-	spec.PodSpec.Labels["io.drone.repo.name"] = slug.Make(args.Repo.Name)
-	spec.PodSpec.Labels["io.drone.build.number"] = fmt.Sprint(args.Build.Number)
-	spec.PodSpec.Labels["io.drone.build.event"] = slug.Make(args.Build.Event)
+	prefix := engine.DefaultLabelPrefix
+	if c.LabelPrefix != "" {
+		prefix = c.LabelPrefix
+		spec.PodSpec.LabelPrefix = c.LabelPrefix
+	}
+	spec.PodSpec.Labels[prefix] = "true"
+	spec.PodSpec.Labels[prefix+".name"] = spec.PodSpec.Name
+	spec.PodSpec.Labels[prefix+".repo.namespace"] = slug.Make(args.Repo.Namespace)
+	spec.PodSpec.Labels[prefix+".repo.name"] = slug.Make(args.Repo.Name)
+	spec.PodSpec.Labels[prefix+".build.number"] = fmt.Sprint(args.Build.Number)
+	spec.PodSpec.Labels[prefix+".build.event"] = slug.Make(args.Build.Event)
+
+	// set drone annotations, which describe the build in a
+	// versioned schema, so that sidecars injected into the
+	// pipeline pod can attribute activity to the build.
+	configureAnnotations(spec, args, prefix, c.Timeouts)
 
 	match := manifest.Match{
 		Action:   args.Build.Action,
@@ -362,16 +1020,40 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		}
 	}
 
-	var hostnames []string
+	// steps of untrusted builds are filtered to prevent
+	// access to sensitive environment variables.
+	untrusted := isUntrusted(args)
+
+	// steps of untrusted builds are restricted in secure fork
+	// mode.
+	restricted := untrusted && c.SecureForks
 
 	// create steps
-	for _, src := range args.Pipeline.Services {
+	for _, v := range args.Pipeline.Services {
+		src := copyStep(v)
 		dst := createStep(args.Pipeline, src)
 		dst.Detach = true
+		if src.Readiness != nil {
+			dst.Readiness = &engine.Readiness{
+				Port:    src.Readiness.Port,
+				Path:    src.Readiness.Path,
+				Timeout: src.Readiness.Timeout,
+			}
+		}
 		dst.Envs = environ.Combine(spec.CommonEnvs, dst.Envs)
 		dst.Volumes = append(dst.Volumes, workMount, statusMount)
-		c.setupScript(src, dst, true)
+		configureTmpfs(spec, src, dst)
+		var filter string
+		if untrusted {
+			filter = filterEnv(c.findEnvFilter(src), envs, dst)
+		}
+		c.setupScript(src, dst, true, filter)
+		dst.ExecTemplate = c.execTemplate(args.Pipeline.Platform.OS, src)
 		setupWorkdir(src, dst, workspace)
+		if src.KVM {
+			configureKVM(dst, c.KVMResource)
+		}
+		configureProfile(&dst.Resources, c.ResourceProfiles[src.Resources.Profile], true)
 		spec.Steps = append(spec.Steps, dst)
 
 		// if the pipeline step has unmet conditions the step is
@@ -379,36 +1061,81 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		if !src.When.Match(match) {
 			dst.RunPolicy = engine.RunNever
 		}
-
-		if len(validation.IsDNS1123Subdomain(src.Name)) == 0 {
-			hostnames = append(hostnames, src.Name)
-		}
 	}
 
+	spec.PodSpec.HostAliases = append(spec.PodSpec.HostAliases, mirrorHostAliases(c.Mirrors)...)
 	if len(hostnames) > 0 {
-		spec.PodSpec.HostAliases = []engine.HostAlias{
-			{
-				IP:        "127.0.0.1",
+		for _, ip := range loopbackAddresses(c.IPFamily) {
+			spec.PodSpec.HostAliases = append(spec.PodSpec.HostAliases, engine.HostAlias{
+				IP:        ip,
 				Hostnames: hostnames,
-			},
+			})
 		}
 	}
 
+	// the path conditions of the steps are evaluated when the
+	// pipeline is compiled if the changed files are known, and
+	// otherwise after the repository is cloned.
+	changes := c.findChanges(ctx, args)
+
+	// the pipeline is skipped if the pipeline is owned by a
+	// path ownership rule, and no owned files changed.
+	plan := c.Planner.Plan(args.Repo.Slug, changes)
+	spec.Skipped = plan.SkipPipeline(args.Pipeline.Name)
+
 	// create steps
-	for _, src := range args.Pipeline.Steps {
+	for _, v := range args.Pipeline.Steps {
+		src := copyStep(v)
+		c.configurePublish(src)
+		c.configureDeploy(spec, src, restricted)
 		dst := createStep(args.Pipeline, src)
+
+		// deploy steps are never executed by restricted
+		// builds.
+		if src.Deploy != nil && restricted {
+			dst.RunPolicy = engine.RunNever
+		}
 		// dst.Envs = environ.Combine(envs, dst.Envs)
 		dst.Volumes = append(dst.Volumes, workMount, statusMount)
-		c.setupScript(src, dst, false)
+		configureTmpfs(spec, src, dst)
+		var filter string
+		if untrusted {
+			filter = filterEnv(c.findEnvFilter(src), envs, dst)
+		}
+		filter += setupPaths(args, src, dst, changes)
+		c.setupEntrypoint(ctx, args.Pipeline, src)
+		c.setupScript(src, dst, false, filter)
+		dst.ExecTemplate = c.execTemplate(args.Pipeline.Platform.OS, src)
 		setupWorkdir(src, dst, workspace)
+		if src.KVM {
+			configureKVM(dst, c.KVMResource)
+		}
+		configureProfile(&dst.Resources, c.ResourceProfiles[src.Resources.Profile], true)
 		spec.Steps = append(spec.Steps, dst)
 
+		// identical steps in the pipelines of the build, for
+		// example in the cells of a matrix build, are executed
+		// once if deduplication is enabled. The result is
+		// shared through the pipeline namespace, so steps are
+		// not deduplicated if pipelines are isolated in their
+		// own namespace.
+		if src.Dedupe && !c.Isolate {
+			dst.Dedupe = dedupeKey(args, dst)
+		}
+
 		// if the pipeline step has unmet conditions the step is
 		// automatically skipped.
 		if !src.When.Match(match) {
 			dst.RunPolicy = engine.RunNever
 		}
 
+		// if the pipeline step is owned by a path ownership
+		// rule, and no owned files changed, the step is
+		// skipped.
+		if plan.SkipStep(args.Pipeline.Name, src.Name) {
+			dst.RunPolicy = engine.RunNever
+		}
+
 		// if the pipeline step has an approved image, it is
 		// automatically defaulted to run with escalalated
 		// privileges.
@@ -417,6 +1144,94 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		}
 	}
 
+	// configure the static shell for steps with images that
+	// do not include a shell.
+	c.configureShell(spec)
+
+	// pass the step output variables to subsequent steps.
+	if c.Outputs {
+		spec.Outputs = true
+		for _, step := range spec.Steps {
+			step.Envs["DRONE_OUTPUT"] = engine.OutputPath
+		}
+	}
+
+	// provide the label of the resources created by the steps.
+	// The label value is the stage id, since the resources of
+	// a stage are deleted when the stage completes, and the
+	// stages of a build can run in parallel.
+	if c.Janitor {
+		spec.Janitor = fmt.Sprint(args.Stage.ID)
+		for _, step := range spec.Steps {
+			step.Envs["DRONE_JANITOR_LABEL"] = prefix + ".janitor=" + spec.Janitor
+		}
+	}
+
+	// create the build metadata file. The file is stored in
+	// the pipeline secret and mounted into the workspace of
+	// each pipeline step.
+	if c.Metadata {
+		spec.Secrets[metadataKey] = &engine.Secret{
+			Name: metadataKey,
+			Data: createMetadata(args),
+		}
+		metaVolume := &engine.Volume{
+			Secret: &engine.VolumeSecret{
+				ID:         random(),
+				Name:       "_metadata",
+				SecretName: spec.PodSpec.Name,
+				Items: []engine.VolumeSecretItem{
+					{Key: metadataKey, Path: metadataKey},
+				},
+			},
+		}
+		metaMount := &engine.VolumeMount{
+			Name:    metaVolume.Secret.Name,
+			Path:    path.Join(workspace, metadataPath),
+			SubPath: metadataKey,
+		}
+		spec.Volumes = append(spec.Volumes, metaVolume)
+		for _, step := range spec.Steps {
+			step.Volumes = append(step.Volumes, metaMount)
+		}
+		spec.PodSpec.Annotations["DRONE_BUILD_METADATA"] = metaMount.Path
+	}
+
+	// configure apt to download packages through the apt
+	// caching proxy.
+	if c.Mirrors.Apt != "" && args.Pipeline.Platform.OS != "windows" {
+		configureAptMirror(spec, c.Mirrors.Apt)
+	}
+
+	// mount the timezone database of the node, so that the
+	// timezone is applied to images without the database.
+	if c.Locale.Localtime && timezone != "" && args.Pipeline.Platform.OS != "windows" {
+		configureLocaltime(spec, timezone)
+	}
+
+	// apply the pod security context, and remove the step
+	// users that are outside the bounds of the policy.
+	spec.PodSpec.SecurityContext = c.createSecurityContext(args.Pipeline.SecurityContext)
+	capStepUsers(spec, c.SecurityPolicy)
+
+	// steps that run as a non-root user cannot write to the
+	// workspace cloned by the root user. A step is inserted
+	// after the clone step to fix the workspace ownership.
+	// If the pod does not run as root, the workspace is owned
+	// by the pod user and the fs group.
+	var owner *engine.Step
+	if args.Pipeline.Clone.Disable == false && runsAsRoot(spec) {
+		image := cloneImage(args.Pipeline.Platform)
+		if c.Cloner != "" {
+			image = c.Cloner
+		}
+		owner = createOwnerStep(spec, image, workspace)
+	}
+	if owner != nil {
+		owner.Volumes = append(owner.Volumes, workMount, statusMount)
+		spec.Steps = append(spec.Steps[:1], append([]*engine.Step{owner}, spec.Steps[1:]...)...)
+	}
+
 	if isGraph(spec) == false {
 		configureSerial(spec)
 	} else if args.Pipeline.Clone.Disable == false {
@@ -424,25 +1239,143 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 	} else if args.Pipeline.Clone.Disable == true {
 		removeCloneDeps(spec)
 	}
+	if owner != nil {
+		configureOwnerDeps(spec)
+	}
+
+	// scan the workspace for malware after the repository is
+	// cloned, and after the pipeline steps complete.
+	if args.Pipeline.Platform.OS != "windows" {
+		c.configureAntivirus(spec, workspace, workMount, statusMount)
+	}
+
+	// restrict the steps of untrusted builds, before the
+	// step secrets are requested from the secret providers.
+	if restricted {
+		configureUntrusted(spec, c.KVMResource)
+	}
+
+	// mount the build cache directories, and restore and
+	// rebuild the build cache with the s3 backend.
+	c.configureCache(spec, args, workspace)
+
+	// mount the repository cache into every step.
+	c.configureRepoCache(spec, args)
+
+	// import the artifacts of the pipeline dependencies, and
+	// export the artifacts of the pipeline.
+	c.configureArtifacts(spec, args, workspace, workMount)
+
+	// add the steps that prepare or persist the workspace, if
+	// required by the workspace backend.
+	workArgs.Restricted = restricted
+	workBackend.Configure(c, spec, workArgs)
+
+	// block access to the cloud provider metadata endpoints,
+	// for all builds or for untrusted builds.
+	switch c.BlockMetadata {
+	case "all":
+		spec.PodSpec.BlockMetadata = true
+	case "untrusted":
+		spec.PodSpec.BlockMetadata = untrusted
+	}
+
+	// enforce the pipeline timeouts, and the default timeout
+	// of the steps that do not define a timeout.
+	configureTimeouts(spec, c.Timeouts.Lookup(args.Repo.Slug))
+
+	// node steps are executed by the node helper pod on the
+	// node where the pipeline pod is scheduled.
+	for _, step := range spec.Steps {
+		if step.Node {
+			spec.NodeHelper = c.NodeHelper
+			break
+		}
+	}
 
 	for _, step := range spec.Steps {
+		var names []string
 		for _, s := range step.Secrets {
+			names = append(names, s.Name)
+		}
+		// the secret written to the standard input of the step
+		// is fetched like the secret environment variables.
+		if step.Stdin != nil && step.Stdin.Secret != "" {
+			names = append(names, step.Stdin.Secret)
+		}
+		// the secrets mounted as files are fetched like the
+		// secret environment variables. Binary secrets are
+		// stored separately, so that the decoded value does
+		// not replace the encoded value.
+		for _, file := range step.SecretFiles {
+			names = append(names, file.Name)
+		}
+		for _, name := range names {
 			// if the secret was already fetched and stored in the
 			// secret map it can be skipped.
-			if _, ok := spec.Secrets[s.Name]; ok {
+			if _, ok := spec.Secrets[name]; ok {
 				continue
 			}
-			secret, ok := c.findSecret(ctx, args, s.Name)
+			source, binary := toSecretName(name)
+			secret, ok := c.findSecret(ctx, args, source)
 			if ok {
-				spec.Secrets[s.Name] = &engine.Secret{
-					Name: s.Name,
-					Data: secret,
-					Mask: true,
+				spec.Secrets[name] = &engine.Secret{
+					Name:   name,
+					Data:   secret,
+					Mask:   true,
+					Binary: binary,
 				}
 			}
 		}
 	}
 
+	// get short-lived deployment credentials from the
+	// credentials broker.
+	if c.Credentials != nil && !restricted {
+		creds, err := c.Credentials.Find(ctx, &credentials.Request{
+			Repo:  args.Repo,
+			Build: args.Build,
+		})
+		if err != nil {
+			// TODO return an error to the caller if the
+			// provider returns an error.
+		} else if creds != nil {
+			configureCredentials(spec, creds)
+		}
+	}
+
+	// request short-lived registry credentials for the
+	// publish steps. Credentials are not minted for untrusted
+	// builds.
+	if !untrusted {
+		c.configureRobot(spec, args)
+	}
+
+	// run the pipeline in the cluster of the kubeconfig
+	// sourced from a secret. The kubeconfig is not available
+	// to restricted builds, and the engine rejects pipelines
+	// without a kubeconfig or with a cluster that is not
+	// allowed.
+	if cluster := args.Pipeline.Cluster; cluster != nil && cluster.Kubeconfig != nil {
+		name := cluster.Kubeconfig.Secret
+		spec.Cluster = &engine.Cluster{Secret: name}
+		if !restricted {
+			if data, ok := c.findSecret(ctx, args, name); ok {
+				spec.Cluster.Kubeconfig = []byte(data)
+			}
+		}
+	}
+
+	// scripts and secrets that exceed the maximum environment
+	// variable size are delivered to the step as files. Other
+	// variables are not renamed, since the plugins would not
+	// find the setting, and the pipeline fails at setup if a
+	// variable is too large.
+	for _, step := range spec.Steps {
+		configureScriptFile(spec, step)
+		configureSecretFiles(spec, step)
+	}
+
 	// get registry credentials from registry plugins
 	creds, err := c.Registry.List(ctx, &registry.Request{
 		Repo:  args.Repo,
@@ -453,13 +1386,32 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 		// if the provider returns an error.
 	}
 
-	// get registry credentials from secrets
-	for _, name := range args.Pipeline.PullSecrets {
-		secret, ok := c.findSecret(ctx, args, name)
-		if ok {
-			parsed, err := auths.ParseString(secret)
-			if err == nil {
-				creds = append(creds, parsed...)
+	// get registry credentials from secrets. Pull secrets are
+	// not available to untrusted builds.
+	if !restricted {
+		for _, name := range args.Pipeline.PullSecrets {
+			secret, ok := c.findSecret(ctx, args, name)
+			if ok {
+				parsed, err := auths.ParseString(secret)
+				if err == nil {
+					creds = append(creds, parsed...)
+				}
+				// the registry credentials are only required to
+				// create the pull secret. The password is stored
+				// as a local secret so that it is masked in the
+				// logs, but is not written to the pipeline secret.
+				for _, cred := range parsed {
+					if cred.Password == "" {
+						continue
+					}
+					key := name + "." + cred.Address
+					spec.Secrets[key] = &engine.Secret{
+						Name:  key,
+						Data:  cred.Password,
+						Mask:  true,
+						Local: true,
+					}
+				}
 			}
 		}
 	}
@@ -490,26 +1442,76 @@ func (c *Compiler) Compile(ctx context.Context, args Args) *engine.Spec {
 				Name: v.Name,
 				Path: v.HostPath.Path,
 			}
+		} else if v.NFS != nil {
+			src.NFS = &engine.VolumeNFS{
+				ID:       id,
+				Name:     v.Name,
+				Server:   v.NFS.Server,
+				Path:     v.NFS.Path,
+				ReadOnly: v.NFS.ReadOnly,
+			}
+		} else if v.Projected != nil {
+			src.Projected = &engine.VolumeProjected{
+				ID:      id,
+				Name:    v.Name,
+				Sources: convertProjections(v.Projected.Sources),
+			}
+		} else if v.CSI != nil {
+			src.CSI = &engine.VolumeCSI{
+				ID:         id,
+				Name:       v.Name,
+				Driver:     v.CSI.Driver,
+				FSType:     v.CSI.FSType,
+				ReadOnly:   v.CSI.ReadOnly,
+				Attributes: v.CSI.Attributes,
+			}
+		} else if v.Claim != nil {
+			// if the claim name is empty, the claim is
+			// provisioned when the pipeline environment is
+			// created, and removed when it is destroyed.
+			src.Claim = &engine.VolumeClaim{
+				ID:           id,
+				Name:         v.Name,
+				ClaimName:    v.Claim.Name,
+				StorageClass: v.Claim.StorageClass,
+				AccessMode:   v.Claim.AccessMode,
+				Size:         int64(v.Claim.Size),
+				ReadOnly:     v.Claim.ReadOnly,
+			}
+			if v.Claim.Name == "" {
+				src.Claim.ClaimName = id
+				src.Claim.Provision = true
+			}
+		} else if v.Secret != nil {
+			src.Secret = &engine.VolumeSecret{
+				ID:         id,
+				Name:       v.Name,
+				SecretName: v.Secret.Name,
+				Items:      convertItems(v.Secret.Items),
+			}
+		} else if v.ConfigMap != nil {
+			src.ConfigMap = &engine.VolumeConfigMap{
+				ID:            id,
+				Name:          v.Name,
+				ConfigMapName: v.ConfigMap.Name,
+				Items:         convertItems(v.ConfigMap.Items),
+			}
 		} else {
 			continue
 		}
 		spec.Volumes = append(spec.Volumes, src)
 	}
 
-	// apply default resources limits
+	// apply the pipeline resources, and then the default
+	// resources, to steps that do not define resources.
+	defaults := convertResources(args.Pipeline.Resources)
+	configureProfile(&defaults, c.ResourceProfiles[args.Pipeline.Resources.Profile], false)
 	for _, v := range spec.Steps {
-		if v.Resources.Requests.CPU == 0 {
-			v.Resources.Requests.CPU = c.Resources.Requests.CPU
-		}
-		if v.Resources.Requests.Memory == 0 {
-			v.Resources.Requests.Memory = c.Resources.Requests.Memory
-		}
-		if v.Resources.Limits.CPU == 0 {
-			v.Resources.Limits.CPU = c.Resources.Limits.CPU
-		}
-		if v.Resources.Limits.Memory == 0 {
-			v.Resources.Limits.Memory = c.Resources.Limits.Memory
-		}
+		defaultResources(&v.Resources.Requests, defaults.Requests.CPU, defaults.Requests.Memory)
+		defaultResources(&v.Resources.Limits, defaults.Limits.CPU, defaults.Limits.Memory)
+		defaultResources(&v.Resources.Requests, c.Resources.Requests.CPU, c.Resources.Requests.Memory)
+		defaultResources(&v.Resources.Limits, c.Resources.Limits.CPU, c.Resources.Limits.Memory)
+		capResources(&v.Resources, c.MaxResources)
 	}
 
 	return spec
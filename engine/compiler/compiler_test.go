@@ -240,3 +240,73 @@ func dump(v interface{}) {
 	enc.SetIndent("", "  ")
 	enc.Encode(v)
 }
+
+func TestCompile_NodeSelector(t *testing.T) {
+	tests := []struct {
+		runner   map[string]string
+		pipeline map[string]string
+		want     map[string]string
+	}{
+		{
+			runner:   nil,
+			pipeline: nil,
+			want:     nil,
+		},
+		{
+			runner:   map[string]string{"pool": "gpu"},
+			pipeline: nil,
+			want:     map[string]string{"pool": "gpu"},
+		},
+		{
+			runner:   map[string]string{"pool": "gpu", "disk": "hdd"},
+			pipeline: map[string]string{"disk": "ssd"},
+			want:     map[string]string{"pool": "gpu", "disk": "ssd"},
+		},
+	}
+	for _, test := range tests {
+		c := &Compiler{
+			Registry:     registry.Static(nil),
+			Secret:       secret.Static(nil),
+			NodeSelector: test.runner,
+		}
+		args := testBackendArgs()
+		args.Pipeline.NodeSelector = test.pipeline
+		got := c.Compile(nocontext, args).PodSpec.NodeSelector
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Unexpected node selector for runner %v and pipeline %v", test.runner, test.pipeline)
+			t.Log(diff)
+		}
+	}
+}
+
+func TestCompile_LabelPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+		other  string
+	}{
+		{prefix: "", want: "io.drone", other: "acme.ci"},
+		{prefix: "acme.ci", want: "acme.ci", other: "io.drone"},
+	}
+	for _, test := range tests {
+		c := &Compiler{
+			Registry:    registry.Static(nil),
+			Secret:      secret.Static(nil),
+			LabelPrefix: test.prefix,
+		}
+		got := c.Compile(nocontext, testBackendArgs())
+		if got.PodSpec.LabelPrefix != test.prefix {
+			t.Errorf("Want spec label prefix %q, got %q", test.prefix, got.PodSpec.LabelPrefix)
+		}
+		labels := got.PodSpec.Labels
+		if labels[test.want] != "true" || labels[test.want+".name"] != got.PodSpec.Name {
+			t.Errorf("Want pod labeled with prefix %s, got %v", test.want, labels)
+		}
+		if _, ok := labels[test.other]; ok {
+			t.Errorf("Want pod not labeled with prefix %s", test.other)
+		}
+		if got.PodSpec.Annotations[test.want+".repo.slug"] != "octocat/hello-world" {
+			t.Errorf("Want pod annotated with prefix %s", test.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"sort"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/internal/credentials"
+)
+
+// prefix of the secrets used to store short-lived
+// deployment credentials.
+const credentialsPrefix = "credentials."
+
+// helper function adds the short-lived credentials to the
+// pipeline secrets, and exposes the credentials to each
+// pipeline step, excluding the clone step and services.
+func configureCredentials(spec *engine.Spec, creds *credentials.Credentials) {
+	var keys []string
+	for key := range creds.Environ {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := credentialsPrefix + key
+		spec.Secrets[name] = &engine.Secret{
+			Name: name,
+			Data: creds.Environ[key],
+			Mask: true,
+		}
+	}
+	for _, step := range spec.Steps {
+		if step.Name == "clone" || step.Detach {
+			continue
+		}
+		for _, key := range keys {
+			step.Secrets = append(step.Secrets, &engine.SecretVar{
+				Name: credentialsPrefix + key,
+				Env:  key,
+			})
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/internal/credentials"
+)
+
+func Test_configureCredentials(t *testing.T) {
+	spec := &engine.Spec{
+		Secrets: map[string]*engine.Secret{},
+		Steps: []*engine.Step{
+			{Name: "clone"},
+			{Name: "database", Detach: true},
+			{Name: "deploy"},
+		},
+	}
+	configureCredentials(spec, &credentials.Credentials{
+		Environ: map[string]string{"AWS_SESSION_TOKEN": "token"},
+	})
+	secret := spec.Secrets["credentials.AWS_SESSION_TOKEN"]
+	if secret == nil || secret.Data != "token" || !secret.Mask {
+		t.Errorf("Expect masked credentials stored in the pipeline secrets")
+	}
+	if len(spec.Steps[0].Secrets) != 0 || len(spec.Steps[1].Secrets) != 0 {
+		t.Errorf("Expect credentials not exposed to clone step or services")
+	}
+	if got, want := len(spec.Steps[2].Secrets), 1; got != want {
+		t.Errorf("Want %d secret variables, got %d", want, got)
+		return
+	}
+	if got, want := spec.Steps[2].Secrets[0].Env, "AWS_SESSION_TOKEN"; got != want {
+		t.Errorf("Want secret environment variable %q, got %q", want, got)
+	}
+}
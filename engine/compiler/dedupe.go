@@ -0,0 +1,38 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+// helper function returns the key used to execute identical
+// steps in the pipelines of a build once, for example in the
+// cells of a matrix build. The key is scoped to the build, and
+// is derived from the step image, commands and environment.
+func dedupeKey(args Args, step *engine.Step) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00", args.Repo.Slug, args.Build.Number)
+	fmt.Fprintf(h, "%s\x00%s\x00", step.Name, step.Image)
+	fmt.Fprintf(h, "%q\x00%q\x00", step.Entrypoint, step.Command)
+	fmt.Fprintf(h, "%s\x00%s\x00%v\x00", step.User, step.WorkingDir, step.Privileged)
+
+	var keys []string
+	for k := range step.Envs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\x00", k, step.Envs[k])
+	}
+	for _, s := range step.Secrets {
+		fmt.Fprintf(h, "%s=%s\x00", s.Env, s.Name)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,43 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone/drone-go/drone"
+)
+
+func Test_dedupeKey(t *testing.T) {
+	args := Args{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{Number: 42},
+	}
+	a := &engine.Step{
+		Name:  "setup",
+		Image: "node",
+		Envs:  map[string]string{"DRONE_SCRIPT": "npm ci", "CI": "true"},
+	}
+	b := &engine.Step{
+		Name:  "setup",
+		Image: "node",
+		Envs:  map[string]string{"CI": "true", "DRONE_SCRIPT": "npm ci"},
+	}
+	if dedupeKey(args, a) != dedupeKey(args, b) {
+		t.Errorf("Want identical steps to have the same key")
+	}
+
+	b.Envs["NODE_VERSION"] = "12"
+	if dedupeKey(args, a) == dedupeKey(args, b) {
+		t.Errorf("Want steps with different environments to have different keys")
+	}
+
+	key := dedupeKey(args, a)
+	args.Build = &drone.Build{Number: 43}
+	if dedupeKey(args, a) == key {
+		t.Errorf("Want keys scoped to the build")
+	}
+}
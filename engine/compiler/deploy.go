@@ -0,0 +1,228 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+const (
+	// default images of the deploy tools. The kustomize
+	// deployments use the kustomize support of kubectl.
+	helmImage    = "alpine/helm:3.3.4"
+	kubectlImage = "bitnami/kubectl:1.19"
+
+	// name and path of the volume that projects the service
+	// account token and the cluster certificate authority.
+	deployVolumeName = "_deploy"
+	deployTokenPath  = "/drone/deploy"
+
+	// path of the kubeconfig sourced from a secret, and of
+	// the kubeconfig generated from the service account.
+	deployKubeconfig = "/drone/kubeconfig"
+	deployGenerated  = "/tmp/drone-kubeconfig"
+
+	// path of the applied manifests, relative to the
+	// workspace.
+	deployManifests = ".drone/deploy"
+)
+
+// helper function configures the deploy step. The step image
+// and commands are replaced with the deploy tool image and
+// commands, which write the applied manifests to the
+// workspace. The kubeconfig secret is mounted as a file, or a
+// kubeconfig scoped to the namespace is generated from a
+// short-lived service account token that is only mounted in
+// the deploy step. The deploy step of restricted builds
+// receives no credentials, and is skipped by the compiler.
+func (c *Compiler) configureDeploy(spec *engine.Spec, src *resource.Step, restricted bool) {
+	deploy := src.Deploy
+	if deploy == nil {
+		return
+	}
+	namespace := deploy.Namespace
+	if namespace == "" {
+		namespace = spec.PodSpec.Namespace
+	}
+
+	environ := map[string]*manifest.Variable{}
+	for k, v := range src.Environment {
+		environ[k] = v
+	}
+	var commands []string
+	switch {
+	case restricted:
+		// restricted builds receive no credentials.
+	case deploy.Kubeconfig != nil && deploy.Kubeconfig.Secret != "":
+		environ["KUBECONFIG"] = &manifest.Variable{Value: deployKubeconfig}
+		src.SecretFiles = append(append([]*resource.SecretFile(nil), src.SecretFiles...), &resource.SecretFile{
+			Secret: deploy.Kubeconfig.Secret,
+			Path:   deployKubeconfig,
+		})
+	default:
+		environ["KUBECONFIG"] = &manifest.Variable{Value: deployGenerated}
+		commands = append(commands, kubeconfigCommand(namespace))
+		configureDeployToken(spec)
+		src.Volumes = append(append([]*resource.VolumeMount(nil), src.Volumes...), &resource.VolumeMount{
+			Name:      deployVolumeName,
+			MountPath: deployTokenPath,
+			ReadOnly:  true,
+		})
+	}
+	src.Environment = environ
+	src.Entrypoint = nil
+	src.Command = nil
+
+	output := path.Join(deployManifests, toManifestName(src.Name))
+	commands = append(commands, "mkdir -p "+deployManifests)
+	if strings.EqualFold(deploy.Tool, "kustomize") {
+		src.Image = c.Deploy.Kubectl
+		if src.Image == "" {
+			src.Image = kubectlImage
+		}
+		src.Commands = append(commands, kustomizeCommands(deploy, namespace, output)...)
+	} else {
+		src.Image = c.Deploy.Helm
+		if src.Image == "" {
+			src.Image = helmImage
+		}
+		src.Commands = append(commands, helmCommands(deploy, namespace, output)...)
+	}
+}
+
+// helper function returns the helm commands, which install or
+// upgrade the release and write the release manifest.
+func helmCommands(deploy *resource.Deploy, namespace, output string) []string {
+	args := []string{
+		"helm", "upgrade", "--install",
+		quote(deploy.Release),
+		quote(deploy.Chart),
+		"--namespace", quote(namespace),
+	}
+	for _, file := range deploy.Values {
+		args = append(args, "--values", quote(file))
+	}
+	var sets []string
+	for k, v := range deploy.Set {
+		sets = append(sets, k+"="+v)
+	}
+	sort.Strings(sets)
+	for _, set := range sets {
+		args = append(args, "--set", quote(set))
+	}
+	if deploy.Wait {
+		args = append(args, "--wait")
+	}
+	return []string{
+		strings.Join(args, " "),
+		fmt.Sprintf("helm get manifest %s --namespace %s > %s", quote(deploy.Release), quote(namespace), quote(output)),
+	}
+}
+
+// helper function returns the kustomize commands, which build
+// the manifests, write the manifests and apply the manifests.
+func kustomizeCommands(deploy *resource.Deploy, namespace, output string) []string {
+	dir := deploy.Path
+	if dir == "" {
+		dir = "."
+	}
+	return []string{
+		fmt.Sprintf("kubectl kustomize %s > %s", quote(dir), quote(output)),
+		fmt.Sprintf("kubectl apply --namespace %s --filename %s", quote(namespace), quote(output)),
+	}
+}
+
+// helper function returns the command that generates the
+// kubeconfig from the projected service account token. The
+// api server address is enclosed in brackets if it is an ipv6
+// address.
+func kubeconfigCommand(namespace string) string {
+	config := strings.Join([]string{
+		"apiVersion: v1",
+		"kind: Config",
+		"clusters:",
+		"- name: cluster",
+		"  cluster:",
+		"    server: https://%s:%s",
+		"    certificate-authority: " + deployTokenPath + "/ca.crt",
+		"users:",
+		"- name: drone",
+		"  user:",
+		"    tokenFile: " + deployTokenPath + "/token",
+		"contexts:",
+		"- name: drone",
+		"  context:",
+		"    cluster: cluster",
+		"    user: drone",
+		"    namespace: %s",
+		"current-context: drone",
+	}, `\n`)
+	host := `case "$KUBERNETES_SERVICE_HOST" in *:*) host="[$KUBERNETES_SERVICE_HOST]" ;; *) host="$KUBERNETES_SERVICE_HOST" ;; esac`
+	return fmt.Sprintf(`%s; printf '%s\n' "$host" "$KUBERNETES_SERVICE_PORT" %s > "$KUBECONFIG"`, host, config, quote(namespace))
+}
+
+// helper function adds the volume that projects a short-lived
+// service account token and the cluster certificate authority,
+// unless the volume was already added by another deploy step.
+func configureDeployToken(spec *engine.Spec) {
+	for _, v := range spec.Volumes {
+		if v.Projected != nil && v.Projected.Name == deployVolumeName {
+			return
+		}
+	}
+	spec.Volumes = append(spec.Volumes, &engine.Volume{
+		Projected: &engine.VolumeProjected{
+			ID:   random(),
+			Name: deployVolumeName,
+			Sources: []engine.VolumeProjection{
+				{
+					ServiceAccountToken: &engine.VolumeProjectedToken{
+						ExpirationSeconds: 3600,
+						Path:              "token",
+					},
+				},
+				{
+					ConfigMap: &engine.VolumeProjectedObject{
+						Name: "kube-root-ca.crt",
+						Items: []engine.VolumeSecretItem{
+							{Key: "ca.crt", Path: "ca.crt"},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// helper function returns true if the pipeline includes a
+// deploy step.
+func hasDeploy(pipeline *resource.Pipeline) bool {
+	for _, step := range pipeline.Steps {
+		if step.Deploy != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the file name of the manifests
+// applied by the named step.
+func toManifestName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, name) + ".yaml"
+}
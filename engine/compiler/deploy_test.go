@@ -0,0 +1,110 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+func Test_configureDeploy_Helm(t *testing.T) {
+	spec := &engine.Spec{PodSpec: engine.PodSpec{Namespace: "drone"}}
+	src := &resource.Step{
+		Name: "deploy production",
+		Deploy: &resource.Deploy{
+			Chart:      "./charts/hello-world",
+			Release:    "hello-world",
+			Namespace:  "production",
+			Set:        map[string]string{"image.tag": "1.0.0"},
+			Wait:       true,
+			Kubeconfig: &manifest.Variable{Secret: "kubeconfig"},
+		},
+	}
+	c := &Compiler{}
+	c.configureDeploy(spec, src, false)
+
+	if got, want := src.Image, helmImage; got != want {
+		t.Errorf("Want image %q, got %q", want, got)
+	}
+	if len(src.SecretFiles) != 1 || src.SecretFiles[0].Path != deployKubeconfig {
+		t.Errorf("Want kubeconfig secret mounted as file")
+	}
+	if got := src.Environment["KUBECONFIG"]; got == nil || got.Value != deployKubeconfig {
+		t.Errorf("Want KUBECONFIG variable")
+	}
+	if len(spec.Volumes) != 0 {
+		t.Errorf("Want service account token not mounted")
+	}
+	want := []string{
+		"mkdir -p .drone/deploy",
+		"helm upgrade --install 'hello-world' './charts/hello-world' --namespace 'production' --set 'image.tag=1.0.0' --wait",
+		"helm get manifest 'hello-world' --namespace 'production' > '.drone/deploy/deploy-production.yaml'",
+	}
+	if got := strings.Join(src.Commands, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("Want helm commands\n%s\ngot\n%s", strings.Join(want, "\n"), got)
+	}
+}
+
+func Test_configureDeploy_ServiceAccount(t *testing.T) {
+	spec := &engine.Spec{PodSpec: engine.PodSpec{Namespace: "drone"}}
+	for _, name := range []string{"deploy", "deploy-canary"} {
+		src := &resource.Step{
+			Name:   name,
+			Deploy: &resource.Deploy{Tool: "kustomize"},
+		}
+		c := &Compiler{}
+		c.configureDeploy(spec, src, false)
+
+		if got, want := src.Image, kubectlImage; got != want {
+			t.Errorf("Want image %q, got %q", want, got)
+		}
+		if len(src.Volumes) != 1 || src.Volumes[0].Name != deployVolumeName {
+			t.Errorf("Want service account token mounted in the deploy step")
+		}
+		if !strings.Contains(src.Commands[0], "'drone' > \"$KUBECONFIG\"") {
+			t.Errorf("Want kubeconfig scoped to the pipeline namespace, got %s", src.Commands[0])
+		}
+	}
+	if len(spec.Volumes) != 1 {
+		t.Errorf("Want service account token volume added once, got %d volumes", len(spec.Volumes))
+	}
+}
+
+func Test_configureDeploy_Restricted(t *testing.T) {
+	spec := &engine.Spec{PodSpec: engine.PodSpec{Namespace: "drone"}}
+	for _, kubeconfig := range []*manifest.Variable{nil, {Secret: "kubeconfig"}} {
+		src := &resource.Step{
+			Name:   "deploy",
+			Deploy: &resource.Deploy{Tool: "kustomize", Kubeconfig: kubeconfig},
+		}
+		c := &Compiler{}
+		c.configureDeploy(spec, src, true)
+
+		if len(src.SecretFiles) != 0 {
+			t.Errorf("Want kubeconfig secret not mounted for restricted builds")
+		}
+		if len(src.Volumes) != 0 || len(spec.Volumes) != 0 {
+			t.Errorf("Want service account token not mounted for restricted builds")
+		}
+		if _, ok := src.Environment["KUBECONFIG"]; ok {
+			t.Errorf("Want no KUBECONFIG variable for restricted builds")
+		}
+	}
+}
+
+func Test_kubeconfigCommand_IPv6(t *testing.T) {
+	got := kubeconfigCommand("drone")
+	if !strings.Contains(got, `*:*) host="[$KUBERNETES_SERVICE_HOST]"`) {
+		t.Errorf("Want ipv6 api server address enclosed in brackets, got %s", got)
+	}
+	if !strings.Contains(got, `server: https://%s:%s`) {
+		t.Errorf("Want server address formatted from host and port, got %s", got)
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+// annotations that prevent the cluster autoscaler and the
+// descheduler from evicting the pipeline pod.
+var nonEvictable = map[string]string{
+	"cluster-autoscaler.kubernetes.io/safe-to-evict":   "false",
+	"descheduler.alpha.kubernetes.io/prevent-eviction": "true",
+}
+
+// helper function returns the pod annotations that prevent
+// eviction, or nil if the pod can be evicted.
+func evictionAnnotations(nonevictable bool) map[string]string {
+	if !nonevictable {
+		return nil
+	}
+	return nonEvictable
+}
@@ -0,0 +1,20 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import "testing"
+
+func TestEvictionAnnotations(t *testing.T) {
+	if got := evictionAnnotations(false); got != nil {
+		t.Errorf("Want no annotations, got %v", got)
+	}
+	got := evictionAnnotations(true)
+	if got["cluster-autoscaler.kubernetes.io/safe-to-evict"] != "false" {
+		t.Errorf("Want cluster autoscaler safe-to-evict annotation")
+	}
+	if got["descheduler.alpha.kubernetes.io/prevent-eviction"] != "true" {
+		t.Errorf("Want descheduler prevent-eviction annotation")
+	}
+}
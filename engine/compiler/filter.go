@@ -0,0 +1,174 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// EnvFilter removes or overrides environment variables in the
+// steps of untrusted builds. A build is untrusted if it is a
+// pull request from a forked repository.
+type EnvFilter struct {
+	// Images provides the list of step images the filter is
+	// applied to. The filter is applied to all step images if
+	// the list is empty.
+	Images []string
+
+	// Allow provides a list of variable name patterns that are
+	// passed to the step. If the list is not empty, variables
+	// that do not match a pattern are removed.
+	Allow []string
+
+	// Deny provides a list of variable name patterns that are
+	// removed from the step, for example DRONE_NETRC_*.
+	Deny []string
+
+	// Override provides variables that replace the value of
+	// the named variables.
+	Override map[string]string
+}
+
+// helper function returns true if the build is a pull request
+// from a forked repository.
+func isUntrusted(args Args) bool {
+	return args.Build.Event == drone.EventPullRequest &&
+		args.Build.Fork != "" &&
+		args.Build.Fork != args.Repo.Slug
+}
+
+// helper function restricts the steps of an untrusted build.
+// Secrets, including secrets written to the standard input,
+// secrets mounted as files and variables sourced from
+// kubernetes secrets, config maps and vault, are removed, steps run unprivileged, without the kvm
+// device and inside the pipeline pod instead of the node, and
+// images are always pulled, so that images cached on the node
+// by trusted builds cannot be used without registry
+// authorization.
+func configureUntrusted(spec *engine.Spec, kvmResource string) {
+	if kvmResource == "" {
+		kvmResource = defaultKVMResource
+	}
+	for _, step := range spec.Steps {
+		step.Secrets = nil
+		step.SecretFiles = nil
+		step.EnvRefs = nil
+		if step.Stdin != nil && step.Stdin.Secret != "" {
+			step.Stdin = nil
+		}
+		step.Privileged = false
+		step.Node = false
+		step.Pull = engine.PullAlways
+		if _, ok := step.Resources.Devices[kvmResource]; ok {
+			devices := map[string]int64{}
+			for k, v := range step.Resources.Devices {
+				if k != kvmResource {
+					devices[k] = v
+				}
+			}
+			step.Resources.Devices = devices
+		}
+	}
+}
+
+// helper function returns the first environment filter that
+// matches the step image, or nil if no filter matches.
+func (c *Compiler) findEnvFilter(src *resource.Step) *EnvFilter {
+	for _, filter := range c.EnvFilters {
+		if len(filter.Images) == 0 {
+			return filter
+		}
+		for _, img := range filter.Images {
+			if image.Match(img, src.Image) {
+				return filter
+			}
+		}
+	}
+	return nil
+}
+
+// helper function applies the environment filter to the step
+// variables and secrets, and returns the shell commands that
+// remove or override the build variables that are sourced by
+// the step script.
+func filterEnv(filter *EnvFilter, envs map[string]string, dst *engine.Step) string {
+	if filter == nil {
+		return ""
+	}
+
+	var names []string
+	for name := range envs {
+		if filter.isDenied(name) {
+			names = append(names, name)
+		}
+	}
+	for name := range dst.Envs {
+		if filter.isDenied(name) {
+			delete(dst.Envs, name)
+		}
+	}
+	var secrets []*engine.SecretVar
+	for _, secret := range dst.Secrets {
+		if !filter.isDenied(secret.Env) {
+			secrets = append(secrets, secret)
+		}
+	}
+	dst.Secrets = secrets
+
+	var overrides []string
+	for name, value := range filter.Override {
+		dst.Envs[name] = value
+		overrides = append(overrides, name)
+	}
+
+	sort.Strings(names)
+	sort.Strings(overrides)
+
+	buf := new(strings.Builder)
+	for _, name := range names {
+		fmt.Fprintf(buf, "unset %s\n", name)
+	}
+	for _, name := range overrides {
+		fmt.Fprintf(buf, "export %s=%s\n", name, quote(filter.Override[name]))
+	}
+	return buf.String()
+}
+
+// helper function returns true if the variable is removed
+// by the filter.
+func (f *EnvFilter) isDenied(name string) bool {
+	if name == "DRONE_SCRIPT" {
+		return false
+	}
+	if len(f.Allow) != 0 && !matchAny(f.Allow, name) {
+		return true
+	}
+	return matchAny(f.Deny, name)
+}
+
+// helper function returns true if the name matches any of
+// the patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the single-quoted shell value.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/registry"
+	"github.com/drone/runner-go/secret"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_isUntrusted(t *testing.T) {
+	tests := []struct {
+		event, fork string
+		want        bool
+	}{
+		{event: "pull_request", fork: "octocat/hello-world", want: false},
+		{event: "pull_request", fork: "spaceghost/hello-world", want: true},
+		{event: "pull_request", fork: "", want: false},
+		{event: "push", fork: "spaceghost/hello-world", want: false},
+	}
+	for _, test := range tests {
+		args := Args{
+			Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+			Build: &drone.Build{Event: test.event, Fork: test.fork},
+		}
+		if got := isUntrusted(args); got != test.want {
+			t.Errorf("Want untrusted %v for event %s from %q", test.want, test.event, test.fork)
+		}
+	}
+}
+
+func Test_configureUntrusted(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			{
+				Name:        "publish",
+				Privileged:  true,
+				Pull:        engine.PullIfNotExists,
+				Secrets:     []*engine.SecretVar{{Name: "password", Env: "PASSWORD"}},
+				SecretFiles: []*engine.SecretFile{{Name: "keystore", Path: "/etc/keystore"}},
+				Stdin:       &engine.Stdin{Secret: "kubeconfig"},
+				Node:        true,
+				Resources: engine.Resources{
+					Devices: map[string]int64{defaultKVMResource: 1, "nvidia.com/gpu": 1},
+				},
+				EnvRefs: []*engine.EnvRef{
+					{Env: "TOKEN", Secret: &engine.EnvKeyRef{Name: "deploy", Key: "token"}},
+					{Env: "CONFIG", ConfigMap: &engine.EnvKeyRef{Name: "deploy", Key: "config"}},
+					{Env: "PASSWORD", Vault: &engine.EnvKeyRef{Name: "secret/data/ci", Key: "password"}},
+				},
+			},
+			{
+				Name:  "test",
+				Stdin: &engine.Stdin{Data: "fixture"},
+			},
+		},
+	}
+	configureUntrusted(spec, "")
+	step := spec.Steps[0]
+	if step.Privileged {
+		t.Errorf("Expect step unprivileged")
+	}
+	if step.Pull != engine.PullAlways {
+		t.Errorf("Want pull policy always, got %s", step.Pull)
+	}
+	if len(step.Secrets) != 0 {
+		t.Errorf("Expect step secrets removed")
+	}
+	if len(step.SecretFiles) != 0 {
+		t.Errorf("Expect step secret files removed")
+	}
+	if step.Stdin != nil {
+		t.Errorf("Expect step stdin secret removed")
+	}
+	if len(step.EnvRefs) != 0 {
+		t.Errorf("Expect step secret and config map references removed")
+	}
+	if step.Node {
+		t.Errorf("Expect step not executed on the node")
+	}
+	if _, ok := step.Resources.Devices[defaultKVMResource]; ok {
+		t.Errorf("Expect kvm device removed")
+	}
+	if _, ok := step.Resources.Devices["nvidia.com/gpu"]; !ok {
+		t.Errorf("Expect other devices preserved")
+	}
+	if stdin := spec.Steps[1].Stdin; stdin == nil || stdin.Data != "fixture" {
+		t.Errorf("Expect step stdin data preserved")
+	}
+}
+
+// secrets mounted as files must not be fetched for steps of
+// untrusted builds in secure fork mode.
+func TestCompile_Untrusted_SecretFiles(t *testing.T) {
+	manifest, err := manifest.ParseFile("testdata/secret_files.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiler := &Compiler{
+		Registry:    registry.Static(nil),
+		SecureForks: true,
+		Secret: secret.StaticVars(map[string]string{
+			"keystore": "c2VjcmV0",
+		}),
+	}
+	args := Args{
+		Repo:     &drone.Repo{Slug: "octocat/hello-world"},
+		Build:    &drone.Build{Event: drone.EventPullRequest, Fork: "spaceghost/hello-world"},
+		Stage:    &drone.Stage{},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: manifest,
+		Pipeline: manifest.Resources[0].(*resource.Pipeline),
+		Secret:   secret.Static(nil),
+	}
+	spec := compiler.Compile(nocontext, args)
+	if len(spec.Secrets) != 0 {
+		t.Errorf("Want no secrets for untrusted builds, got %d", len(spec.Secrets))
+	}
+	for _, step := range spec.Steps {
+		if len(step.SecretFiles) != 0 {
+			t.Errorf("Want no secret files for untrusted step %s", step.Name)
+		}
+	}
+
+	// the secret files are mounted for trusted builds.
+	args.Build.Fork = "octocat/hello-world"
+	spec = compiler.Compile(nocontext, args)
+	if _, ok := spec.Secrets["keystore"]; !ok {
+		t.Errorf("Want secret file fetched for trusted builds")
+	}
+}
+
+func Test_findEnvFilter(t *testing.T) {
+	c := &Compiler{
+		EnvFilters: []*EnvFilter{
+			{Images: []string{"golang"}, Deny: []string{"GOPROXY"}},
+			{Deny: []string{"DRONE_NETRC_*"}},
+		},
+	}
+	if got := c.findEnvFilter(&resource.Step{Image: "golang:1.12"}); got != c.EnvFilters[0] {
+		t.Errorf("Want image filter matched")
+	}
+	if got := c.findEnvFilter(&resource.Step{Image: "node"}); got != c.EnvFilters[1] {
+		t.Errorf("Want default filter matched")
+	}
+}
+
+func Test_filterEnv(t *testing.T) {
+	filter := &EnvFilter{
+		Deny:     []string{"DRONE_NETRC_*", "NPM_TOKEN"},
+		Override: map[string]string{"GOPROXY": "https://proxy.golang.org"},
+	}
+	envs := map[string]string{
+		"DRONE_BRANCH":         "master",
+		"DRONE_NETRC_PASSWORD": "correct-horse-battery-staple",
+		"DRONE_NETRC_FILE":     "machine github.com",
+	}
+	dst := &engine.Step{
+		Envs: map[string]string{
+			"NPM_TOKEN":    "token",
+			"NODE_ENV":     "test",
+			"DRONE_SCRIPT": "echo hello",
+		},
+		Secrets: []*engine.SecretVar{
+			{Name: "npm_token", Env: "NPM_TOKEN"},
+			{Name: "username", Env: "USERNAME"},
+		},
+	}
+
+	got := filterEnv(filter, envs, dst)
+	want := "unset DRONE_NETRC_FILE\nunset DRONE_NETRC_PASSWORD\nexport GOPROXY='https://proxy.golang.org'\n"
+	if got != want {
+		t.Errorf("Want filter commands %q, got %q", want, got)
+	}
+
+	wantEnvs := map[string]string{
+		"NODE_ENV":     "test",
+		"DRONE_SCRIPT": "echo hello",
+		"GOPROXY":      "https://proxy.golang.org",
+	}
+	if diff := cmp.Diff(wantEnvs, dst.Envs); diff != "" {
+		t.Errorf("Unexpected step environment")
+		t.Log(diff)
+	}
+	if len(dst.Secrets) != 1 || dst.Secrets[0].Env != "USERNAME" {
+		t.Errorf("Want denied secret removed from the step")
+	}
+}
+
+func Test_filterEnv_Allow(t *testing.T) {
+	filter := &EnvFilter{Allow: []string{"DRONE_*", "CI"}}
+	envs := map[string]string{
+		"CI":           "true",
+		"DRONE_BRANCH": "master",
+		"HTTP_PROXY":   "http://proxy:3128",
+	}
+	dst := &engine.Step{Envs: map[string]string{}}
+	if got, want := filterEnv(filter, envs, dst), "unset HTTP_PROXY\n"; got != want {
+		t.Errorf("Want filter commands %q, got %q", want, got)
+	}
+	if got := filterEnv(nil, envs, dst); got != "" {
+		t.Errorf("Want no filter commands without a filter, got %q", got)
+	}
+}
+
+func Test_quote(t *testing.T) {
+	if got, want := quote("it's"), `'it'\''s'`; got != want {
+		t.Errorf("Want %s, got %s", want, got)
+	}
+}
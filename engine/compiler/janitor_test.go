@@ -0,0 +1,49 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/registry"
+	"github.com/drone/runner-go/secret"
+)
+
+func TestJanitor(t *testing.T) {
+	args := Args{
+		Repo:     &drone.Repo{},
+		Build:    &drone.Build{},
+		Stage:    &drone.Stage{ID: 42},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: &manifest.Manifest{},
+		Pipeline: &resource.Pipeline{},
+	}
+
+	c := &Compiler{
+		Registry: registry.Static(nil),
+		Secret:   secret.Static(nil),
+	}
+	got := c.Compile(nocontext, args)
+	if got.Janitor != "" {
+		t.Errorf("Want janitor disabled by default")
+	}
+	if _, ok := got.Steps[0].Envs["DRONE_JANITOR_LABEL"]; ok {
+		t.Errorf("Want no janitor label when disabled")
+	}
+
+	c.Janitor = true
+	c.LabelPrefix = "com.example"
+	got = c.Compile(nocontext, args)
+	if got.Janitor != "42" {
+		t.Errorf("Want janitor label value is the stage id, got %q", got.Janitor)
+	}
+	if got, want := got.Steps[0].Envs["DRONE_JANITOR_LABEL"], "com.example.janitor=42"; got != want {
+		t.Errorf("Want janitor label %q, got %q", want, got)
+	}
+}
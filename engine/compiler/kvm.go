@@ -0,0 +1,26 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import "github.com/drone-runners/drone-runner-kube/engine"
+
+// defaultKVMResource is the resource name of the kvm device
+// advertised by the KubeVirt device plugin.
+const defaultKVMResource = "devices.kubevirt.io/kvm"
+
+// helper function requests the kvm device from the device
+// plugin, so that the step is scheduled on a node with kvm
+// support and the device is mounted at /dev/kvm.
+func configureKVM(dst *engine.Step, resource string) {
+	if resource == "" {
+		resource = defaultKVMResource
+	}
+	devices := map[string]int64{}
+	for k, v := range dst.Resources.Devices {
+		devices[k] = v
+	}
+	devices[resource] = 1
+	dst.Resources.Devices = devices
+}
@@ -0,0 +1,28 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+func TestConfigureKVM(t *testing.T) {
+	step := new(engine.Step)
+	configureKVM(step, "")
+	if got, want := step.Resources.Devices["devices.kubevirt.io/kvm"], int64(1); got != want {
+		t.Errorf("Want default kvm resource count %d, got %d", want, got)
+	}
+
+	step = new(engine.Step)
+	configureKVM(step, "smarter-devices/kvm")
+	if got, want := len(step.Resources.Devices), 1; got != want {
+		t.Errorf("Want %d device resources, got %d", want, got)
+	}
+	if got, want := step.Resources.Devices["smarter-devices/kvm"], int64(1); got != want {
+		t.Errorf("Want kvm resource count %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+const (
+	// path of the timezone database on the node and in the
+	// step containers.
+	zoneinfoPath = "/usr/share/zoneinfo"
+
+	// path of the local timezone file in the step containers.
+	localtimePath = "/etc/localtime"
+)
+
+// helper function adds the environment variables that configure
+// the timezone and the locale of the pipeline steps. The
+// pipeline settings take precedence over the runner settings,
+// and variables defined by the runner or the pipeline
+// environment are not overridden.
+func localeEnviron(envs map[string]string, timezone, lang string) {
+	set := func(key, value string) {
+		if _, ok := envs[key]; !ok && value != "" {
+			envs[key] = value
+		}
+	}
+	set("TZ", timezone)
+	set("LANG", lang)
+	set("LC_ALL", lang)
+}
+
+// helper function mounts the timezone database of the node in
+// the pipeline steps, and the zoneinfo file of the timezone at
+// /etc/localtime, for images that do not include the timezone
+// database or programs that ignore the TZ variable.
+func configureLocaltime(spec *engine.Spec, timezone string) {
+	volume := &engine.Volume{
+		HostPath: &engine.VolumeHostPath{
+			ID:   random(),
+			Name: "_localtime",
+			Path: zoneinfoPath,
+		},
+	}
+	mounts := []*engine.VolumeMount{
+		{
+			Name:     volume.HostPath.Name,
+			Path:     zoneinfoPath,
+			ReadOnly: true,
+		},
+		{
+			Name:     volume.HostPath.Name,
+			Path:     localtimePath,
+			SubPath:  timezone,
+			ReadOnly: true,
+		},
+	}
+	spec.Volumes = append(spec.Volumes, volume)
+	for _, step := range spec.Steps {
+		step.Volumes = append(step.Volumes, mounts...)
+	}
+}
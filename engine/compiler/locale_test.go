@@ -0,0 +1,53 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_localeEnviron(t *testing.T) {
+	envs := map[string]string{"LC_ALL": "C"}
+	localeEnviron(envs, "Europe/Berlin", "de_DE.UTF-8")
+	want := map[string]string{
+		"TZ":     "Europe/Berlin",
+		"LANG":   "de_DE.UTF-8",
+		"LC_ALL": "C",
+	}
+	if diff := cmp.Diff(envs, want); diff != "" {
+		t.Errorf("Unexpected locale environment")
+		t.Log(diff)
+	}
+
+	envs = map[string]string{}
+	localeEnviron(envs, "", "")
+	if len(envs) != 0 {
+		t.Errorf("Want no locale environment if not configured")
+	}
+}
+
+func Test_configureLocaltime(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{{Name: "build"}},
+	}
+	configureLocaltime(spec, "Europe/Berlin")
+	if len(spec.Volumes) != 1 || spec.Volumes[0].HostPath.Path != zoneinfoPath {
+		t.Errorf("Want the timezone database mounted from the node")
+	}
+	mounts := spec.Steps[0].Volumes
+	if len(mounts) != 2 {
+		t.Errorf("Want the timezone database and localtime mounted in the step")
+		return
+	}
+	if got, want := mounts[1].Path, localtimePath; got != want {
+		t.Errorf("Want localtime mounted at %s, got %s", want, got)
+	}
+	if got, want := mounts[1].SubPath, "Europe/Berlin"; got != want {
+		t.Errorf("Want localtime sub path %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"encoding/json"
+
+	"github.com/drone/drone-go/drone"
+)
+
+const (
+	// name of the pipeline secret key that stores the build
+	// metadata file.
+	metadataKey = "drone-metadata.json"
+
+	// path of the build metadata file, relative to the
+	// workspace.
+	metadataPath = ".drone/metadata.json"
+)
+
+type (
+	// metadata provides structured build metadata that is
+	// written to the workspace.
+	metadata struct {
+		Repo   metadataRepo      `json:"repo"`
+		Build  metadataBuild     `json:"build"`
+		Commit metadataCommit    `json:"commit"`
+		Stage  metadataStage     `json:"stage"`
+		Labels map[string]string `json:"labels,omitempty"`
+	}
+
+	metadataRepo struct {
+		Namespace string `json:"namespace,omitempty"`
+		Name      string `json:"name,omitempty"`
+		Slug      string `json:"slug,omitempty"`
+		Link      string `json:"link,omitempty"`
+		Branch    string `json:"default_branch,omitempty"`
+		Private   bool   `json:"private"`
+	}
+
+	metadataBuild struct {
+		Number  int64  `json:"number"`
+		Parent  int64  `json:"parent,omitempty"`
+		Event   string `json:"event,omitempty"`
+		Action  string `json:"action,omitempty"`
+		Cron    string `json:"cron,omitempty"`
+		Deploy  string `json:"deploy_to,omitempty"`
+		Link    string `json:"link,omitempty"`
+		Created int64  `json:"created,omitempty"`
+	}
+
+	metadataCommit struct {
+		Sha     string         `json:"sha,omitempty"`
+		Before  string         `json:"before,omitempty"`
+		Ref     string         `json:"ref,omitempty"`
+		Source  string         `json:"source_branch,omitempty"`
+		Target  string         `json:"target_branch,omitempty"`
+		Title   string         `json:"title,omitempty"`
+		Message string         `json:"message,omitempty"`
+		Author  metadataAuthor `json:"author"`
+	}
+
+	metadataAuthor struct {
+		Login  string `json:"login,omitempty"`
+		Name   string `json:"name,omitempty"`
+		Email  string `json:"email,omitempty"`
+		Avatar string `json:"avatar,omitempty"`
+	}
+
+	metadataStage struct {
+		Number int    `json:"number"`
+		Name   string `json:"name,omitempty"`
+		OS     string `json:"os,omitempty"`
+		Arch   string `json:"arch,omitempty"`
+	}
+)
+
+// helper function returns the json-encoded build metadata
+// file for the given compiler arguments.
+func createMetadata(args Args) string {
+	out, _ := json.MarshalIndent(&metadata{
+		Repo:   toMetadataRepo(args.Repo),
+		Build:  toMetadataBuild(args.Build),
+		Commit: toMetadataCommit(args.Build),
+		Stage:  toMetadataStage(args.Stage),
+		Labels: args.Pipeline.Metadata.Labels,
+	}, "", "  ")
+	return string(out)
+}
+
+func toMetadataRepo(repo *drone.Repo) metadataRepo {
+	return metadataRepo{
+		Namespace: repo.Namespace,
+		Name:      repo.Name,
+		Slug:      repo.Slug,
+		Link:      repo.Link,
+		Branch:    repo.Branch,
+		Private:   repo.Private,
+	}
+}
+
+func toMetadataBuild(build *drone.Build) metadataBuild {
+	return metadataBuild{
+		Number:  build.Number,
+		Parent:  build.Parent,
+		Event:   build.Event,
+		Action:  build.Action,
+		Cron:    build.Cron,
+		Deploy:  build.Deploy,
+		Link:    build.Link,
+		Created: build.Created,
+	}
+}
+
+func toMetadataCommit(build *drone.Build) metadataCommit {
+	return metadataCommit{
+		Sha:     build.After,
+		Before:  build.Before,
+		Ref:     build.Ref,
+		Source:  build.Source,
+		Target:  build.Target,
+		Title:   build.Title,
+		Message: build.Message,
+		Author: metadataAuthor{
+			Login:  build.Author,
+			Name:   build.AuthorName,
+			Email:  build.AuthorEmail,
+			Avatar: build.AuthorAvatar,
+		},
+	}
+}
+
+func toMetadataStage(stage *drone.Stage) metadataStage {
+	return metadataStage{
+		Number: stage.Number,
+		Name:   stage.Name,
+		OS:     stage.OS,
+		Arch:   stage.Arch,
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone/drone-go/drone"
+)
+
+func Test_createMetadata(t *testing.T) {
+	args := Args{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{Number: 2, Parent: 1, After: "7fd1a60", AuthorName: "octocat"},
+		Stage: &drone.Stage{Name: "default"},
+		Pipeline: &resource.Pipeline{
+			Metadata: resource.Metadata{
+				Labels: map[string]string{"team": "platform"},
+			},
+		},
+	}
+
+	got := new(metadata)
+	if err := json.Unmarshal([]byte(createMetadata(args)), got); err != nil {
+		t.Error(err)
+		return
+	}
+	if got.Repo.Slug != "octocat/hello-world" {
+		t.Errorf("Want repository slug, got %q", got.Repo.Slug)
+	}
+	if got.Build.Parent != 1 {
+		t.Errorf("Want parent build number, got %d", got.Build.Parent)
+	}
+	if got.Commit.Sha != "7fd1a60" || got.Commit.Author.Name != "octocat" {
+		t.Errorf("Want commit sha and author")
+	}
+	if got.Labels["team"] != "platform" {
+		t.Errorf("Want pipeline labels")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+const (
+	// name of the pipeline secret key that stores the apt
+	// configuration file.
+	aptMirrorKey = "drone-apt-mirror.conf"
+
+	// path of the apt configuration file.
+	aptMirrorPath = "/etc/apt/apt.conf.d/99drone-mirror"
+)
+
+// helper function adds the environment variables that
+// configure the package managers to use the mirrors. Variables
+// defined by the runner or the pipeline are not overridden.
+func mirrorEnviron(envs map[string]string, mirrors Mirrors) {
+	set := func(key, value string) {
+		if _, ok := envs[key]; !ok && value != "" {
+			envs[key] = value
+		}
+	}
+	set("NPM_CONFIG_REGISTRY", mirrors.NPM)
+	set("PIP_INDEX_URL", mirrors.PyPI)
+	set("GOPROXY", mirrors.GoProxy)
+
+	// pip refuses to download from an index served over
+	// plain http unless the host is trusted.
+	if u, err := url.Parse(mirrors.PyPI); err == nil && u.Scheme == "http" {
+		set("PIP_TRUSTED_HOST", u.Hostname())
+	}
+}
+
+// Hostnames returns the hostnames of the mirrors.
+func (m Mirrors) Hostnames() []string {
+	var hostnames []string
+	seen := map[string]bool{}
+	for _, mirror := range []string{m.NPM, m.PyPI, m.GoProxy, m.Apt} {
+		u, err := url.Parse(mirror)
+		if err != nil || u.Hostname() == "" || seen[u.Hostname()] {
+			continue
+		}
+		seen[u.Hostname()] = true
+		hostnames = append(hostnames, u.Hostname())
+	}
+	return hostnames
+}
+
+// helper function returns the host aliases of the resolved
+// mirror hostnames, grouped by address.
+func mirrorHostAliases(mirrors Mirrors) []engine.HostAlias {
+	grouped := map[string][]string{}
+	for hostname, ip := range mirrors.Hosts {
+		if ip != "" {
+			grouped[ip] = append(grouped[ip], hostname)
+		}
+	}
+	var aliases []engine.HostAlias
+	for ip, hostnames := range grouped {
+		sort.Strings(hostnames)
+		aliases = append(aliases, engine.HostAlias{
+			IP:        ip,
+			Hostnames: hostnames,
+		})
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].IP < aliases[j].IP
+	})
+	return aliases
+}
+
+// helper function mounts the apt configuration file that
+// configures the apt caching proxy into each pipeline step.
+// The file is stored in the pipeline secret.
+func configureAptMirror(spec *engine.Spec, proxy string) {
+	spec.Secrets[aptMirrorKey] = &engine.Secret{
+		Name: aptMirrorKey,
+		Data: fmt.Sprintf("Acquire::http::Proxy %q;\n", proxy),
+	}
+	volume := &engine.Volume{
+		Secret: &engine.VolumeSecret{
+			ID:         random(),
+			Name:       "_apt_mirror",
+			SecretName: spec.PodSpec.Name,
+			Items: []engine.VolumeSecretItem{
+				{Key: aptMirrorKey, Path: aptMirrorKey},
+			},
+		},
+	}
+	mount := &engine.VolumeMount{
+		Name:     volume.Secret.Name,
+		Path:     aptMirrorPath,
+		SubPath:  aptMirrorKey,
+		ReadOnly: true,
+	}
+	spec.Volumes = append(spec.Volumes, volume)
+	for _, step := range spec.Steps {
+		step.Volumes = append(step.Volumes, mount)
+	}
+}
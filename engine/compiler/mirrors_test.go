@@ -0,0 +1,77 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_mirrorEnviron(t *testing.T) {
+	mirrors := Mirrors{
+		NPM:     "http://verdaccio.mirrors:4873",
+		PyPI:    "http://devpi.mirrors:3141/root/pypi/+simple/",
+		GoProxy: "http://athens.mirrors:3000",
+	}
+	envs := map[string]string{
+		"GOPROXY": "https://proxy.golang.org",
+	}
+	mirrorEnviron(envs, mirrors)
+	want := map[string]string{
+		"NPM_CONFIG_REGISTRY": "http://verdaccio.mirrors:4873",
+		"PIP_INDEX_URL":       "http://devpi.mirrors:3141/root/pypi/+simple/",
+		"PIP_TRUSTED_HOST":    "devpi.mirrors",
+		"GOPROXY":             "https://proxy.golang.org",
+	}
+	if diff := cmp.Diff(want, envs); diff != "" {
+		t.Errorf("Unexpected mirror environment")
+		t.Log(diff)
+	}
+}
+
+func Test_mirrorHostAliases(t *testing.T) {
+	mirrors := Mirrors{
+		NPM:  "http://verdaccio.mirrors:4873",
+		Apt:  "http://apt.mirrors:3142",
+		PyPI: "http://verdaccio.mirrors:4873/pypi",
+		Hosts: map[string]string{
+			"verdaccio.mirrors": "10.0.0.2",
+			"apt.mirrors":       "10.0.0.1",
+			"apt":               "10.0.0.1",
+		},
+	}
+	if diff := cmp.Diff([]string{"verdaccio.mirrors", "apt.mirrors"}, mirrors.Hostnames()); diff != "" {
+		t.Errorf("Unexpected mirror hostnames")
+		t.Log(diff)
+	}
+	want := []engine.HostAlias{
+		{IP: "10.0.0.1", Hostnames: []string{"apt", "apt.mirrors"}},
+		{IP: "10.0.0.2", Hostnames: []string{"verdaccio.mirrors"}},
+	}
+	if diff := cmp.Diff(want, mirrorHostAliases(mirrors)); diff != "" {
+		t.Errorf("Unexpected mirror host aliases")
+		t.Log(diff)
+	}
+}
+
+func Test_configureAptMirror(t *testing.T) {
+	spec := &engine.Spec{
+		PodSpec: engine.PodSpec{Name: "drone-abc"},
+		Secrets: map[string]*engine.Secret{},
+		Steps:   []*engine.Step{{Name: "build"}},
+	}
+	configureAptMirror(spec, "http://apt.mirrors:3142")
+	if got, want := spec.Secrets[aptMirrorKey].Data, "Acquire::http::Proxy \"http://apt.mirrors:3142\";\n"; got != want {
+		t.Errorf("Want apt configuration %q, got %q", want, got)
+	}
+	if len(spec.Volumes) != 1 || spec.Volumes[0].Secret.SecretName != "drone-abc" {
+		t.Errorf("Want apt configuration mounted from the pipeline secret")
+	}
+	if mounts := spec.Steps[0].Volumes; len(mounts) != 1 || mounts[0].Path != aptMirrorPath {
+		t.Errorf("Want apt configuration mounted in the step")
+	}
+}
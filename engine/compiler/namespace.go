@@ -0,0 +1,42 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import "sync/atomic"
+
+// NamespacePool provides a fixed pool of pre-created namespaces
+// that pipelines are assigned to in round-robin order, so that
+// object counts and quota usage are spread across namespaces.
+type NamespacePool struct {
+	names []string
+	next  uint32
+}
+
+// NewNamespacePool returns a new namespace pool. A nil pool is
+// returned if no namespaces are provided.
+func NewNamespacePool(names []string) *NamespacePool {
+	if len(names) == 0 {
+		return nil
+	}
+	return &NamespacePool{names: names}
+}
+
+// Next returns the next namespace in the pool. A nil pool
+// returns an empty string.
+func (p *NamespacePool) Next() string {
+	if p == nil {
+		return ""
+	}
+	n := atomic.AddUint32(&p.next, 1) - 1
+	return p.names[n%uint32(len(p.names))]
+}
+
+// Names returns the namespaces in the pool.
+func (p *NamespacePool) Names() []string {
+	if p == nil {
+		return nil
+	}
+	return p.names
+}
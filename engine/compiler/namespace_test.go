@@ -0,0 +1,26 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import "testing"
+
+func TestNamespacePool(t *testing.T) {
+	pool := NewNamespacePool([]string{"build-1", "build-2", "build-3"})
+	for _, want := range []string{"build-1", "build-2", "build-3", "build-1"} {
+		if got := pool.Next(); got != want {
+			t.Errorf("Want namespace %s, got %s", want, got)
+		}
+	}
+}
+
+func TestNamespacePool_Empty(t *testing.T) {
+	pool := NewNamespacePool(nil)
+	if pool != nil {
+		t.Errorf("Want nil pool")
+	}
+	if got := pool.Next(); got != "" {
+		t.Errorf("Want empty namespace, got %s", got)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// helper function returns the hostnames of the pipeline
+// services. The pipeline pod shares a network namespace, and
+// service hostnames resolve to the loopback address.
+func serviceHostnames(pipeline *resource.Pipeline) []string {
+	var hostnames []string
+	for _, src := range pipeline.Services {
+		if len(validation.IsDNS1123Subdomain(src.Name)) == 0 {
+			hostnames = append(hostnames, src.Name)
+		}
+	}
+	return hostnames
+}
+
+// helper function returns the loopback addresses for the ip
+// family, in order of preference. Dual-stack and ipv6 pod
+// networks resolve service hostnames to both addresses, so
+// that services listening on either address are reachable.
+func loopbackAddresses(family string) []string {
+	switch family {
+	case "ipv6":
+		return []string{"::1", "127.0.0.1"}
+	case "dual":
+		return []string{"127.0.0.1", "::1"}
+	default:
+		return []string{"127.0.0.1"}
+	}
+}
+
+// helper function adds the loopback addresses and service
+// hostnames to the no_proxy environment variables if a proxy
+// is configured.
+func configureNoProxy(envs map[string]string, hostnames []string) {
+	var proxy bool
+	for _, key := range []string{"http_proxy", "https_proxy", "HTTP_PROXY", "HTTPS_PROXY"} {
+		if envs[key] != "" {
+			proxy = true
+		}
+	}
+	if !proxy {
+		return
+	}
+
+	var list []string
+	seen := map[string]bool{}
+	add := func(s string) {
+		s = strings.TrimSpace(s)
+		if s != "" && !seen[s] {
+			seen[s] = true
+			list = append(list, s)
+		}
+	}
+	for _, key := range []string{"no_proxy", "NO_PROXY"} {
+		for _, s := range strings.Split(envs[key], ",") {
+			add(s)
+		}
+	}
+	for _, s := range []string{"localhost", "127.0.0.1", "::1"} {
+		add(s)
+	}
+	for _, s := range hostnames {
+		add(s)
+	}
+	envs["no_proxy"] = strings.Join(list, ",")
+	envs["NO_PROXY"] = envs["no_proxy"]
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_loopbackAddresses(t *testing.T) {
+	tests := []struct {
+		family string
+		want   []string
+	}{
+		{family: "", want: []string{"127.0.0.1"}},
+		{family: "ipv4", want: []string{"127.0.0.1"}},
+		{family: "dual", want: []string{"127.0.0.1", "::1"}},
+		{family: "ipv6", want: []string{"::1", "127.0.0.1"}},
+	}
+	for _, test := range tests {
+		if diff := cmp.Diff(test.want, loopbackAddresses(test.family)); diff != "" {
+			t.Errorf("Unexpected loopback addresses for family %q", test.family)
+			t.Log(diff)
+		}
+	}
+}
+
+func Test_configureNoProxy(t *testing.T) {
+	envs := map[string]string{
+		"HTTP_PROXY": "http://[fd00::1]:3128",
+		"no_proxy":   "example.com, localhost",
+	}
+	configureNoProxy(envs, []string{"redis"})
+	want := "example.com,localhost,127.0.0.1,::1,redis"
+	if got := envs["no_proxy"]; got != want {
+		t.Errorf("Want no_proxy %q, got %q", want, got)
+	}
+	if got := envs["NO_PROXY"]; got != want {
+		t.Errorf("Want NO_PROXY %q, got %q", want, got)
+	}
+
+	// no_proxy is unchanged if no proxy is configured.
+	envs = map[string]string{}
+	configureNoProxy(envs, []string{"redis"})
+	if _, ok := envs["no_proxy"]; ok {
+		t.Errorf("Expect no_proxy unset without a proxy")
+	}
+}
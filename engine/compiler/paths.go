@@ -0,0 +1,144 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/internal/changeset"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// helper function returns the files changed by the build, or
+// nil if the changed files are unknown. The changed files are
+// only requested if a pipeline step has path conditions, or
+// the repository has path ownership rules.
+func (c *Compiler) findChanges(ctx context.Context, args Args) []string {
+	if c.Changeset == nil || !hasPathConditions(args.Build) {
+		return nil
+	}
+	if !hasPaths(args.Pipeline) && !c.Planner.Owned(args.Repo.Slug) {
+		return nil
+	}
+	files, err := c.Changeset.List(ctx, &changeset.Request{
+		Repo:  args.Repo,
+		Build: args.Build,
+		Netrc: args.Netrc,
+	})
+	if err != nil {
+		// the path conditions are evaluated after the
+		// repository is cloned if the changed files cannot
+		// be requested.
+		return nil
+	}
+	return files
+}
+
+// helper function configures the path conditions of the step.
+// If the changed files are known, steps with no relevant
+// changes are skipped when the pipeline is compiled. Otherwise
+// the path conditions are evaluated after the repository is
+// cloned, and the returned commands are executed before the
+// step commands.
+func setupPaths(args Args, src *resource.Step, dst *engine.Step, changes []string) string {
+	paths := src.When.Paths
+	if len(paths.Include) == 0 && len(paths.Exclude) == 0 {
+		return ""
+	}
+	if !hasPathConditions(args.Build) {
+		return ""
+	}
+	if changes != nil {
+		if !changeset.Match(changes, paths.Include, paths.Exclude) {
+			dst.RunPolicy = engine.RunNever
+		}
+		return ""
+	}
+	if args.Pipeline.Clone.Disable {
+		return ""
+	}
+	dst.Paths = &engine.PathFilter{
+		Include: paths.Include,
+		Exclude: paths.Exclude,
+	}
+	return pathsScript(dst.Paths)
+}
+
+// helper function returns true if the path conditions apply
+// to the build. Path conditions are ignored for builds that
+// are not triggered by a commit, for example tags, promotions
+// and cron jobs.
+func hasPathConditions(build *drone.Build) bool {
+	return build.Event == drone.EventPush || build.Event == drone.EventPullRequest
+}
+
+// helper function returns true if a pipeline step has path
+// conditions.
+func hasPaths(pipeline *resource.Pipeline) bool {
+	for _, step := range pipeline.Steps {
+		if len(step.When.Paths.Include) != 0 || len(step.When.Paths.Exclude) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the shell commands that compare the
+// files changed by the build with the path conditions, and
+// exit with the skipped exit code if no relevant files changed.
+// If the changed files cannot be listed, for example because
+// git is not installed in the step image or the commit was not
+// cloned, the step is executed.
+func pathsScript(paths *engine.PathFilter) string {
+	var buf strings.Builder
+	// the script is passed to the shell template as a format
+	// string, and must not include format verbs.
+	buf.WriteString(`if [ -n "${DRONE_COMMIT_BEFORE}" ] && drone_changes=$(git diff --name-only "${DRONE_COMMIT_BEFORE}...${DRONE_COMMIT_SHA}" 2>/dev/null) && echo "$drone_changes" | while IFS= read -r drone_path; do` + "\n")
+	buf.WriteString("  [ -z \"$drone_path\" ] && continue\n")
+	if len(paths.Exclude) != 0 {
+		fmt.Fprintf(&buf, "  case \"$drone_path\" in %s) continue ;; esac\n", casePatterns(paths.Exclude))
+	}
+	include := "*"
+	if len(paths.Include) != 0 {
+		include = casePatterns(paths.Include)
+	}
+	fmt.Fprintf(&buf, "  case \"$drone_path\" in %s) exit 1 ;; esac\n", include)
+	buf.WriteString("done; then\n")
+	buf.WriteString("  echo \"+ skipping the step, no changed files match the path conditions\"\n")
+	fmt.Fprintf(&buf, "  exit %d\n", engine.ExitCodeSkipped)
+	buf.WriteString("fi\n")
+	return buf.String()
+}
+
+// helper function converts the glob patterns to shell case
+// patterns. The ** pattern is converted to *, which matches
+// any number of directories in a case pattern. Other special
+// characters are escaped.
+func casePatterns(patterns []string) string {
+	var out []string
+	for _, pattern := range patterns {
+		pattern = strings.Replace(pattern, "**", "*", -1)
+		var buf strings.Builder
+		for _, r := range pattern {
+			switch {
+			case r == '*' || r == '?' || r == '[' || r == ']' || r == '/' || r == '.' || r == '-' || r == '_':
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			case r == '%':
+				// the script is a format string.
+				buf.WriteString(`\%`)
+			default:
+				buf.WriteRune('\\')
+			}
+			buf.WriteRune(r)
+		}
+		out = append(out, buf.String())
+	}
+	return strings.Join(out, "|")
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+)
+
+func Test_setupPaths(t *testing.T) {
+	args := Args{
+		Pipeline: &resource.Pipeline{},
+		Build:    &drone.Build{Event: drone.EventPush},
+	}
+	src := &resource.Step{
+		When: manifest.Conditions{
+			Paths: manifest.Condition{Include: []string{"docs/**"}},
+		},
+	}
+
+	dst := &engine.Step{}
+	setupPaths(args, src, dst, []string{"main.go"})
+	if got, want := dst.RunPolicy, engine.RunNever; got != want {
+		t.Errorf("Want step skipped if no relevant files changed")
+	}
+
+	dst = &engine.Step{}
+	setupPaths(args, src, dst, []string{"docs/index.md"})
+	if got, want := dst.RunPolicy, engine.RunOnSuccess; got != want {
+		t.Errorf("Want step executed if relevant files changed")
+	}
+
+	// the path conditions are evaluated after the repository
+	// is cloned if the changed files are unknown.
+	dst = &engine.Step{}
+	if script := setupPaths(args, src, dst, nil); script == "" || dst.Paths == nil {
+		t.Errorf("Want path conditions evaluated at runtime")
+	}
+
+	// the path conditions are ignored for tags.
+	args.Build.Event = drone.EventTag
+	dst = &engine.Step{}
+	if script := setupPaths(args, src, dst, []string{"main.go"}); script != "" || dst.RunPolicy == engine.RunNever {
+		t.Errorf("Want path conditions ignored for tags")
+	}
+}
+
+func Test_casePatterns(t *testing.T) {
+	got := casePatterns([]string{"docs/**", "*.md", "a b"})
+	if want := `docs/*|*.md|a\ b`; got != want {
+		t.Errorf("Want case patterns %s, got %s", want, got)
+	}
+}
+
+func Test_pathsScript(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=drone", "-c", "user.email=drone@localhost"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s", args, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0600)
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+	before := git("rev-parse", "HEAD")
+	os.Mkdir(filepath.Join(dir, "docs"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "docs", "index.md"), []byte("# docs"), 0600)
+	git("add", "-A")
+	git("commit", "-q", "-m", "docs")
+	after := git("rev-parse", "HEAD")
+
+	tests := []struct {
+		paths  *engine.PathFilter
+		before string
+		code   int
+	}{
+		{&engine.PathFilter{Include: []string{"docs/**"}}, before, 0},
+		{&engine.PathFilter{Include: []string{"src/**"}}, before, engine.ExitCodeSkipped},
+		{&engine.PathFilter{Exclude: []string{"docs/**"}}, before, engine.ExitCodeSkipped},
+		{&engine.PathFilter{Exclude: []string{"*.go"}}, before, 0},
+		// the step is executed if the changed files cannot
+		// be listed.
+		{&engine.PathFilter{Include: []string{"src/**"}}, "", 0},
+		{&engine.PathFilter{Include: []string{"src/**"}}, "0000000", 0},
+	}
+	for i, test := range tests {
+		// the script is passed to the shell template as a
+		// format string.
+		script := fmt.Sprintf(pathsScript(test.paths))
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"DRONE_COMMIT_BEFORE="+test.before,
+			"DRONE_COMMIT_SHA="+after,
+		)
+		code := 0
+		if err := cmd.Run(); err != nil {
+			exit, ok := err.(*exec.ExitError)
+			if !ok {
+				t.Fatal(err)
+			}
+			code = exit.ExitCode()
+		}
+		if code != test.code {
+			t.Errorf("Want exit code %d at index %d, got %d", test.code, i, code)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import "github.com/drone-runners/drone-runner-kube/engine"
+
+// ResourceProfile defines a named set of resources, so that
+// resource defaults can be tuned centrally by the operator.
+type ResourceProfile struct {
+	Limits   ResourceObject
+	Requests ResourceObject
+
+	// Devices provides the device plugin resources of the
+	// profile, for example nvidia.com/gpu.
+	Devices map[string]int64
+}
+
+// helper function applies the resource profile to the cpu and
+// memory that are not defined. The profile devices are only
+// applied if enabled, because pipeline profiles apply to every
+// step and devices cannot be shared between containers.
+func configureProfile(dst *engine.Resources, profile *ResourceProfile, devices bool) {
+	if profile == nil {
+		return
+	}
+	defaultResources(&dst.Requests, profile.Requests.CPU, profile.Requests.Memory)
+	defaultResources(&dst.Limits, profile.Limits.CPU, profile.Limits.Memory)
+	if !devices || len(profile.Devices) == 0 {
+		return
+	}
+	merged := map[string]int64{}
+	for k, v := range profile.Devices {
+		merged[k] = v
+	}
+	for k, v := range dst.Devices {
+		merged[k] = v
+	}
+	dst.Devices = merged
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_configureProfile(t *testing.T) {
+	profile := &ResourceProfile{
+		Limits:   ResourceObject{CPU: 4000, Memory: 8589934592},
+		Requests: ResourceObject{CPU: 2000, Memory: 4294967296},
+		Devices:  map[string]int64{"nvidia.com/gpu": 1},
+	}
+	res := engine.Resources{
+		Limits:  engine.ResourceObject{Memory: 1073741824},
+		Devices: map[string]int64{"devices.kubevirt.io/kvm": 1},
+	}
+	configureProfile(&res, profile, true)
+	want := engine.Resources{
+		Limits:   engine.ResourceObject{CPU: 4000, Memory: 1073741824},
+		Requests: engine.ResourceObject{CPU: 2000, Memory: 4294967296},
+		Devices: map[string]int64{
+			"nvidia.com/gpu":          1,
+			"devices.kubevirt.io/kvm": 1,
+		},
+	}
+	if diff := cmp.Diff(want, res); diff != "" {
+		t.Errorf("Unexpected profile resources")
+		t.Log(diff)
+	}
+}
+
+func Test_configureProfile_NoDevices(t *testing.T) {
+	profile := &ResourceProfile{
+		Requests: ResourceObject{CPU: 500},
+		Devices:  map[string]int64{"nvidia.com/gpu": 1},
+	}
+	var res engine.Resources
+	configureProfile(&res, profile, false)
+	if res.Requests.CPU != 500 {
+		t.Errorf("Want profile cpu applied")
+	}
+	if len(res.Devices) != 0 {
+		t.Errorf("Want profile devices ignored")
+	}
+
+	// unknown profiles are ignored, and rejected by the
+	// linter.
+	configureProfile(&res, nil, true)
+}
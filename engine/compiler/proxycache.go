@@ -0,0 +1,58 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+// helper function configures the dependency caching proxies
+// and returns the mirrors, with the mirror urls replaced by
+// the proxy urls of the enabled proxies. The proxies are run
+// as sidecars of the pipeline pod, unless the proxies run on
+// the node.
+func (c *Compiler) configureProxyCache(spec *engine.Spec) Mirrors {
+	mirrors := c.Mirrors
+	proxies := []struct {
+		name  string
+		proxy Proxy
+		url   *string
+	}{
+		{"proxy-go", c.ProxyCache.Go, &mirrors.GoProxy},
+		{"proxy-pypi", c.ProxyCache.PyPI, &mirrors.PyPI},
+		{"proxy-npm", c.ProxyCache.NPM, &mirrors.NPM},
+	}
+	for _, p := range proxies {
+		if p.proxy.Image == "" {
+			continue
+		}
+		// the node address is resolved by the kubelet when
+		// the step container is created.
+		host := "127.0.0.1"
+		if c.ProxyCache.Node {
+			host = "$(DRONE_NODE_IP)"
+			spec.PodSpec.NodeAddress = true
+		}
+		*p.url = fmt.Sprintf("http://%s:%d/%s", host, p.proxy.Port, strings.TrimPrefix(p.proxy.Path, "/"))
+		if c.ProxyCache.Node {
+			continue
+		}
+
+		// steps do not start until the proxy accepts
+		// connections.
+		spec.Sidecars = append(spec.Sidecars, &engine.Sidecar{
+			Name:  p.name,
+			Image: p.proxy.Image,
+			Envs:  p.proxy.Envs,
+			Readiness: &engine.Readiness{
+				Port: p.proxy.Port,
+			},
+		})
+	}
+	return mirrors
+}
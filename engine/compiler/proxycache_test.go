@@ -0,0 +1,64 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+func Test_configureProxyCache(t *testing.T) {
+	c := &Compiler{
+		Mirrors: Mirrors{
+			GoProxy: "http://athens.mirrors:3000",
+			NPM:     "http://verdaccio.mirrors:4873",
+		},
+		ProxyCache: ProxyCache{
+			Go:   Proxy{Image: "gomods/athens", Port: 3000},
+			PyPI: Proxy{Image: "devpi", Port: 3141, Path: "/root/pypi/+simple/"},
+		},
+	}
+	spec := new(engine.Spec)
+	mirrors := c.configureProxyCache(spec)
+	if got, want := mirrors.GoProxy, "http://127.0.0.1:3000/"; got != want {
+		t.Errorf("Want go proxy %q, got %q", want, got)
+	}
+	if got, want := mirrors.PyPI, "http://127.0.0.1:3141/root/pypi/+simple/"; got != want {
+		t.Errorf("Want pypi index %q, got %q", want, got)
+	}
+	if got, want := mirrors.NPM, "http://verdaccio.mirrors:4873"; got != want {
+		t.Errorf("Want npm mirror %q, got %q", want, got)
+	}
+	if len(spec.Sidecars) != 2 {
+		t.Fatalf("Want proxy sidecars, got %d", len(spec.Sidecars))
+	}
+	if s := spec.Sidecars[0]; s.Name != "proxy-go" || s.Readiness == nil || s.Readiness.Port != 3000 {
+		t.Errorf("Want go proxy sidecar with readiness probe")
+	}
+	if spec.PodSpec.NodeAddress {
+		t.Errorf("Want node address not exposed")
+	}
+}
+
+func Test_configureProxyCache_Node(t *testing.T) {
+	c := &Compiler{
+		ProxyCache: ProxyCache{
+			Node: true,
+			Go:   Proxy{Image: "gomods/athens", Port: 3000},
+		},
+	}
+	spec := new(engine.Spec)
+	mirrors := c.configureProxyCache(spec)
+	if got, want := mirrors.GoProxy, "http://$(DRONE_NODE_IP):3000/"; got != want {
+		t.Errorf("Want go proxy %q, got %q", want, got)
+	}
+	if len(spec.Sidecars) != 0 {
+		t.Errorf("Want no proxy sidecars")
+	}
+	if !spec.PodSpec.NodeAddress {
+		t.Errorf("Want node address exposed")
+	}
+}
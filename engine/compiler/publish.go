@@ -0,0 +1,186 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+const (
+	// default images of the publish builders. The kaniko
+	// image must include a shell, which is only included in
+	// the debug image.
+	kanikoImage  = "gcr.io/kaniko-project/executor:debug"
+	buildahImage = "quay.io/buildah/stable:v1.16"
+
+	// names of the variables that provide the registry
+	// credentials of the publish step.
+	publishUsername = "PUBLISH_USERNAME"
+	publishPassword = "PUBLISH_PASSWORD"
+)
+
+// helper function configures the publish step, which builds
+// and publishes an image without privileges. The step image
+// and commands are replaced with the builder image and the
+// builder commands, and the registry credentials are passed
+// to the step as environment variables, so that credentials
+// sourced from secrets are masked.
+func (c *Compiler) configurePublish(src *resource.Step) {
+	publish := src.Publish
+	if publish == nil {
+		return
+	}
+	environ := map[string]*manifest.Variable{}
+	for k, v := range src.Environment {
+		environ[k] = v
+	}
+	var login bool
+	if publish.Username != nil && publish.Password != nil {
+		environ[publishUsername] = publish.Username
+		environ[publishPassword] = publish.Password
+		login = true
+	}
+	src.Environment = environ
+	src.Entrypoint = nil
+	src.Command = nil
+
+	registry := publish.Registry
+	if registry == "" {
+		registry = image.Domain(publish.Repo)
+	}
+	if strings.EqualFold(publish.Builder, "buildah") {
+		src.Image = c.Publish.Buildah
+		if src.Image == "" {
+			src.Image = buildahImage
+		}
+		src.Commands = buildahCommands(publish, registry, login)
+	} else {
+		src.Image = c.Publish.Kaniko
+		if src.Image == "" {
+			src.Image = kanikoImage
+		}
+		src.Commands = kanikoCommands(publish, registry, login)
+	}
+}
+
+// helper function returns the kaniko commands. The registry
+// credentials are written to the kaniko docker configuration.
+func kanikoCommands(publish *resource.Publish, registry string, login bool) []string {
+	// the docker hub credentials are keyed by the legacy
+	// index address.
+	if registry == "docker.io" {
+		registry = "https://index.docker.io/v1/"
+	}
+	var commands []string
+	if login {
+		commands = append(commands,
+			`mkdir -p /kaniko/.docker`,
+			`printf '{"auths":{"%s":{"auth":"%s"}}}' `+quote(registry)+
+				` "$(printf '%s:%s' "$`+publishUsername+`" "$`+publishPassword+`" | base64 | tr -d '\n')"`+
+				` > /kaniko/.docker/config.json`,
+		)
+	}
+	args := []string{
+		"/kaniko/executor",
+		"--context=" + quote(publishContext(publish)),
+		"--dockerfile=" + quote(publishDockerfile(publish)),
+	}
+	for _, tag := range publishTags(publish) {
+		args = append(args, "--destination="+quote(publish.Repo+":"+tag))
+	}
+	if publish.Target != "" {
+		args = append(args, "--target="+quote(publish.Target))
+	}
+	for _, arg := range publishBuildArgs(publish) {
+		args = append(args, "--build-arg="+quote(arg))
+	}
+	if publish.CacheRepo != "" {
+		args = append(args, "--cache=true", "--cache-repo="+quote(publish.CacheRepo))
+	}
+	return append(commands, strings.Join(args, " "))
+}
+
+// helper function returns the buildah commands. The image is
+// built with the vfs storage driver and chroot isolation, so
+// that the build does not require privileges.
+func buildahCommands(publish *resource.Publish, registry string, login bool) []string {
+	var commands []string
+	if login {
+		commands = append(commands,
+			`printf '%s' "$`+publishPassword+`" | buildah login --username "$`+publishUsername+`" --password-stdin `+quote(registry),
+		)
+	}
+	tags := publishTags(publish)
+	args := []string{
+		"buildah", "bud",
+		"--storage-driver=vfs",
+		"--isolation=chroot",
+		"--file=" + quote(publishDockerfile(publish)),
+	}
+	for _, tag := range tags {
+		args = append(args, "--tag="+quote(publish.Repo+":"+tag))
+	}
+	if publish.Target != "" {
+		args = append(args, "--target="+quote(publish.Target))
+	}
+	for _, arg := range publishBuildArgs(publish) {
+		args = append(args, "--build-arg="+quote(arg))
+	}
+	if publish.CacheRepo != "" {
+		args = append(args, "--layers", "--cache-from="+quote(publish.CacheRepo), "--cache-to="+quote(publish.CacheRepo))
+	}
+	args = append(args, quote(publishContext(publish)))
+	commands = append(commands, strings.Join(args, " "))
+	for _, tag := range tags {
+		commands = append(commands, "buildah push --storage-driver=vfs "+quote(publish.Repo+":"+tag))
+	}
+	return commands
+}
+
+// helper function returns the build context, which defaults
+// to the workspace.
+func publishContext(publish *resource.Publish) string {
+	if publish.Context == "" {
+		return "."
+	}
+	return publish.Context
+}
+
+// helper function returns the path of the dockerfile, which
+// is relative to the build context.
+func publishDockerfile(publish *resource.Publish) string {
+	dockerfile := publish.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	return path.Join(publishContext(publish), dockerfile)
+}
+
+// helper function returns the image tags, which default to
+// latest.
+func publishTags(publish *resource.Publish) []string {
+	if len(publish.Tags) == 0 {
+		return []string{"latest"}
+	}
+	return publish.Tags
+}
+
+// helper function returns the build arguments, in key=value
+// format, ordered by key.
+func publishBuildArgs(publish *resource.Publish) []string {
+	var args []string
+	for k, v := range publish.BuildArgs {
+		args = append(args, k+"="+v)
+	}
+	sort.Strings(args)
+	return args
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+func Test_configurePublish_Kaniko(t *testing.T) {
+	environ := map[string]*manifest.Variable{"FOO": {Value: "bar"}}
+	src := &resource.Step{
+		Name:        "publish",
+		Environment: environ,
+		Publish: &resource.Publish{
+			Repo:      "octocat/hello-world",
+			Tags:      []string{"latest", "1.0.0"},
+			CacheRepo: "octocat/hello-world-cache",
+			BuildArgs: map[string]string{"GOOS": "linux"},
+			Username:  &manifest.Variable{Value: "octocat"},
+			Password:  &manifest.Variable{Secret: "docker_password"},
+		},
+	}
+	c := &Compiler{}
+	c.configurePublish(src)
+
+	if got, want := src.Image, kanikoImage; got != want {
+		t.Errorf("Want image %q, got %q", want, got)
+	}
+	if len(environ) != 1 {
+		t.Errorf("Want the pipeline environment unchanged")
+	}
+	if got := src.Environment[publishPassword]; got == nil || got.Secret != "docker_password" {
+		t.Errorf("Want password sourced from secret")
+	}
+	if len(src.Commands) != 3 {
+		t.Fatalf("Want login and build commands, got %v", src.Commands)
+	}
+	if !strings.Contains(src.Commands[1], `'https://index.docker.io/v1/'`) {
+		t.Errorf("Want docker hub credentials keyed by index address, got %s", src.Commands[1])
+	}
+	want := "/kaniko/executor --context='.' --dockerfile='Dockerfile'" +
+		" --destination='octocat/hello-world:latest' --destination='octocat/hello-world:1.0.0'" +
+		" --build-arg='GOOS=linux' --cache=true --cache-repo='octocat/hello-world-cache'"
+	if got := src.Commands[2]; got != want {
+		t.Errorf("Want kaniko command\n%s\ngot\n%s", want, got)
+	}
+}
+
+func Test_configurePublish_Buildah(t *testing.T) {
+	src := &resource.Step{
+		Name: "publish",
+		Publish: &resource.Publish{
+			Builder:    "buildah",
+			Repo:       "quay.io/octocat/hello-world",
+			Context:    "app",
+			Dockerfile: "docker/Dockerfile",
+		},
+	}
+	c := &Compiler{}
+	c.Publish.Buildah = "quay.io/buildah/stable:latest"
+	c.configurePublish(src)
+
+	if got, want := src.Image, "quay.io/buildah/stable:latest"; got != want {
+		t.Errorf("Want image %q, got %q", want, got)
+	}
+	want := []string{
+		"buildah bud --storage-driver=vfs --isolation=chroot --file='app/docker/Dockerfile' --tag='quay.io/octocat/hello-world:latest' 'app'",
+		"buildah push --storage-driver=vfs 'quay.io/octocat/hello-world:latest'",
+	}
+	if len(src.Commands) != len(want) {
+		t.Fatalf("Want build and push commands without login, got %v", src.Commands)
+	}
+	for i := range want {
+		if src.Commands[i] != want[i] {
+			t.Errorf("Want command\n%s\ngot\n%s", want[i], src.Commands[i])
+		}
+	}
+}
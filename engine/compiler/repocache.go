@@ -0,0 +1,81 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+const (
+	// name of the repository cache volume.
+	repoCacheVolumeName = "_repo_cache"
+
+	// path where the repository cache volume is mounted.
+	repoCachePath = "/drone/repo-cache"
+)
+
+// helper function mounts the repository cache into every
+// step, and points the build tools at the cache directories
+// unless the step configures the tool itself. The cache is a
+// volume claim named after the repository that is kept after
+// the pipeline, and is evicted by the cache janitor once the
+// caches exceed the storage limit.
+//
+// The cache is not mounted for untrusted builds in the
+// security mode, so that forks cannot alter the cache of the
+// repository, or for isolated pipelines, which do not share
+// the namespace of the volume claim.
+func (c *Compiler) configureRepoCache(spec *engine.Spec, args Args) {
+	switch {
+	case !c.RepoCache.Enabled:
+		return
+	case c.Isolate:
+		return
+	case c.SecureForks && isUntrusted(args):
+		return
+	}
+	spec.Volumes = append(spec.Volumes, &engine.Volume{
+		Claim: &engine.VolumeClaim{
+			ID:           random(),
+			Name:         repoCacheVolumeName,
+			ClaimName:    fmt.Sprintf("drone-repo-cache-%x", sha1.Sum([]byte(args.Repo.Slug)))[:37],
+			Provision:    true,
+			Keep:         true,
+			Cache:        true,
+			StorageClass: c.RepoCache.StorageClass,
+			AccessMode:   c.RepoCache.AccessMode,
+			Size:         c.RepoCache.Size,
+		},
+	})
+
+	var names []string
+	for name := range c.RepoCache.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, step := range spec.Steps {
+		if step.Name == cloneStepName {
+			continue
+		}
+		step.Volumes = append(step.Volumes, &engine.VolumeMount{
+			Name: repoCacheVolumeName,
+			Path: repoCachePath,
+		})
+		if step.Envs == nil {
+			step.Envs = map[string]string{}
+		}
+		step.Envs["DRONE_REPO_CACHE"] = repoCachePath
+		for _, name := range names {
+			if _, ok := step.Envs[name]; !ok {
+				step.Envs[name] = path.Join(repoCachePath, c.RepoCache.Tools[name])
+			}
+		}
+	}
+}
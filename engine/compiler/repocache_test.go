@@ -0,0 +1,75 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func Test_configureRepoCache(t *testing.T) {
+	c := &Compiler{
+		RepoCache: RepoCache{
+			Enabled: true,
+			Size:    1024,
+			Tools: map[string]string{
+				"GRADLE_USER_HOME": "gradle",
+				"SCCACHE_DIR":      "sccache",
+			},
+		},
+	}
+	spec := testCacheSpec()
+	spec.Steps[2].Envs = map[string]string{"SCCACHE_DIR": "/tmp/sccache"}
+	c.configureRepoCache(spec, testCacheArgs(drone.EventPullRequest))
+
+	if len(spec.Volumes) != 1 || spec.Volumes[0].Claim == nil {
+		t.Fatalf("Want repository cache volume claim")
+	}
+	claim := spec.Volumes[0].Claim
+	if !claim.Provision || !claim.Keep || !claim.Cache || claim.Size != 1024 {
+		t.Errorf("Want kept cache volume claim provisioned, got %+v", claim)
+	}
+	// the claim is shared by the pipelines of the repository.
+	other := testCacheSpec()
+	c.configureRepoCache(other, testCacheArgs(drone.EventPush))
+	if got, want := other.Volumes[0].Claim.ClaimName, claim.ClaimName; got != want {
+		t.Errorf("Want claim %q reused, got %q", want, got)
+	}
+
+	if len(spec.Steps[0].Volumes) != 0 {
+		t.Errorf("Want repository cache not mounted in the clone step")
+	}
+	step := spec.Steps[2]
+	if len(step.Volumes) != 1 || step.Volumes[0].Path != "/drone/repo-cache" {
+		t.Errorf("Want repository cache mounted")
+	}
+	if got, want := step.Envs["GRADLE_USER_HOME"], "/drone/repo-cache/gradle"; got != want {
+		t.Errorf("Want GRADLE_USER_HOME %q, got %q", want, got)
+	}
+	if got, want := step.Envs["SCCACHE_DIR"], "/tmp/sccache"; got != want {
+		t.Errorf("Want step configuration %q preserved, got %q", want, got)
+	}
+}
+
+func Test_configureRepoCache_Untrusted(t *testing.T) {
+	c := &Compiler{RepoCache: RepoCache{Enabled: true}, SecureForks: true}
+	args := testCacheArgs(drone.EventPullRequest)
+	args.Build.Fork = "spaceghost/hello-world"
+	spec := testCacheSpec()
+	c.configureRepoCache(spec, args)
+	if len(spec.Volumes) != 0 {
+		t.Errorf("Want repository cache disabled for untrusted builds")
+	}
+
+	c = &Compiler{RepoCache: RepoCache{Enabled: true}, Isolate: true}
+	spec = &engine.Spec{}
+	c.configureRepoCache(spec, testCacheArgs(drone.EventPush))
+	if len(spec.Volumes) != 0 {
+		t.Errorf("Want repository cache disabled for isolated pipelines")
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+)
+
+// helper function requests short-lived registry credentials,
+// scoped to the image namespace of the repository, for the
+// pipeline steps that use a publish image. The credentials are
+// minted by the engine when the pipeline is created.
+func (c *Compiler) configureRobot(spec *engine.Spec, args Args) {
+	if len(c.RobotImages) == 0 {
+		return
+	}
+	var steps []string
+	for _, step := range spec.Steps {
+		if image.Match(step.Image, c.RobotImages...) {
+			steps = append(steps, step.ID)
+		}
+	}
+	if len(steps) == 0 {
+		return
+	}
+	spec.Robot = &engine.Robot{
+		Namespace: args.Repo.Namespace,
+		Name:      spec.PodSpec.Name,
+		Steps:     steps,
+	}
+}
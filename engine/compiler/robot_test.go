@@ -0,0 +1,44 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func Test_configureRobot(t *testing.T) {
+	c := &Compiler{RobotImages: []string{"plugins/docker"}}
+	spec := &engine.Spec{
+		PodSpec: engine.PodSpec{Name: "drone-abc"},
+		Steps: []*engine.Step{
+			{ID: "step1", Image: "golang"},
+			{ID: "step2", Image: "plugins/docker:19"},
+		},
+	}
+	args := Args{Repo: &drone.Repo{Namespace: "octocat"}}
+	c.configureRobot(spec, args)
+	if spec.Robot == nil {
+		t.Fatalf("Want registry credentials requested")
+	}
+	if got, want := spec.Robot.Namespace, "octocat"; got != want {
+		t.Errorf("Want namespace %q, got %q", want, got)
+	}
+	if got := spec.Robot.Steps; len(got) != 1 || got[0] != "step2" {
+		t.Errorf("Want credentials for the publish step, got %v", got)
+	}
+}
+
+func Test_configureRobot_NoPublish(t *testing.T) {
+	c := &Compiler{RobotImages: []string{"plugins/docker"}}
+	spec := &engine.Spec{Steps: []*engine.Step{{ID: "step1", Image: "golang"}}}
+	c.configureRobot(spec, Args{Repo: &drone.Repo{Namespace: "octocat"}})
+	if spec.Robot != nil {
+		t.Errorf("Want no registry credentials without publish steps")
+	}
+}
@@ -5,30 +5,174 @@
 package compiler
 
 import (
+	"context"
 	"os"
 	"strings"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
 	"github.com/drone-runners/drone-runner-kube/engine/compiler/shell"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+)
+
+const (
+	// path of the directory where the static shell is
+	// installed for images that do not include a shell.
+	shellPath = "/drone/bin"
+
+	// default image used to install the static shell.
+	shellImage = "busybox:1.31"
 )
 
 // helper function configures the pipeline script for the
-// target operating system.
-func (c *Compiler) setupScript(src *resource.Step, dst *engine.Step, isService bool) {
+// target operating system. The filter commands are executed
+// after the build environment is sourced.
+func (c *Compiler) setupScript(src *resource.Step, dst *engine.Step, isService bool, filter string) {
+	before := func() string {
+		return c.envCommands() + filter
+	}
+	if c.isShellless(src) {
+		before = func() string {
+			return "export PATH=$PATH:" + shellPath + "\n" + c.envCommands() + filter
+		}
+	}
+
 	if len(src.Commands) == 0 && len(src.Entrypoint) == 0 && !isService {
 		src.Commands = []string{getCommand(src.Image)}
 	}
 	if len(src.Commands) > 0 {
-		setupScriptPosix(c.envCommands, src.Commands, dst)
+		setupScriptPosix(before, src.Commands, dst)
 	}
 
 	if len(src.Entrypoint) > 0 {
+		var args []string
+		args = append(args, src.Entrypoint...)
+		args = append(args, src.Command...)
 		cmds := []string{
-			strings.Join(append(src.Entrypoint, src.Command...), " "),
+			strings.Join(args, " "),
+		}
+		setupScriptPosix(before, cmds, dst)
+	}
+
+	// images that do not include a shell execute the script
+	// using the static shell installed by the init step.
+	if c.isShellless(src) && len(dst.Entrypoint) > 0 {
+		dst.Shell = shellPath + "/sh"
+		dst.Entrypoint = []string{dst.Shell, "-c"}
+	}
+}
+
+// helper function sets the plugin step commands to the image
+// entrypoint, if the image configuration can be inspected.
+// The step command overrides the image command. Otherwise the
+// command is derived from the image name.
+func (c *Compiler) setupEntrypoint(ctx context.Context, pipeline *resource.Pipeline, src *resource.Step) {
+	if c.Inspector == nil || len(src.Commands) != 0 || len(src.Entrypoint) != 0 {
+		return
+	}
+	goos, goarch := pipeline.Platform.OS, pipeline.Platform.Arch
+	if goos == "" {
+		goos = "linux"
+	}
+	if goarch == "" {
+		goarch = "amd64"
+	}
+	config, err := c.Inspector.Inspect(ctx, src.Image, goos+"/"+goarch)
+	if err != nil {
+		return
+	}
+	var args []string
+	args = append(args, config.Entrypoint...)
+	if len(src.Command) != 0 {
+		args = append(args, src.Command...)
+	} else {
+		args = append(args, config.Cmd...)
+	}
+	if len(args) == 0 {
+		return
+	}
+	for i, arg := range args {
+		args[i] = quote(arg)
+	}
+	src.Commands = []string{strings.Join(args, " ")}
+}
+
+// helper function returns true if the step image does not
+// include a shell, because the image is configured as
+// shell-less or the step selects the none shell. The image
+// itself is not inspected.
+func (c *Compiler) isShellless(step *resource.Step) bool {
+	if strings.EqualFold(step.Shell, "none") {
+		return true
+	}
+	for _, img := range c.Shellless {
+		if image.Match(img, step.Image) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the exec template of the step,
+// matching the os and shell before the os. Returns an empty
+// string if no template matches, in which case the default
+// command is used.
+func (c *Compiler) execTemplate(os string, step *resource.Step) string {
+	if len(c.ExecTemplates) == 0 {
+		return ""
+	}
+	if os == "" {
+		os = "linux"
+	}
+	shell := step.Shell
+	if shell == "" || strings.EqualFold(shell, "none") {
+		shell = "sh"
+	}
+	if tmpl, ok := c.ExecTemplates[os+"/"+shell]; ok {
+		return tmpl
+	}
+	return c.ExecTemplates[os]
+}
+
+// helper function configures the init step that installs a
+// static shell for steps with images that do not include a
+// shell.
+func (c *Compiler) configureShell(spec *engine.Spec) {
+	mount := &engine.VolumeMount{
+		Name: "_shell",
+		Path: shellPath,
+	}
+	var found bool
+	for _, step := range spec.Steps {
+		if step.Shell != "" {
+			step.Volumes = append(step.Volumes, mount)
+			found = true
 		}
-		setupScriptPosix(c.envCommands, cmds, dst)
 	}
+	if !found {
+		return
+	}
+	img := c.ShellImage
+	if img == "" {
+		img = shellImage
+	}
+	spec.Volumes = append(spec.Volumes, &engine.Volume{
+		EmptyDir: &engine.VolumeEmptyDir{
+			ID:   random(),
+			Name: mount.Name,
+		},
+	})
+	spec.Init = append(spec.Init, &engine.Step{
+		ID:         random(),
+		Name:       "shell",
+		Image:      img,
+		Entrypoint: []string{"/bin/busybox", "sh", "-c"},
+		Command: []string{
+			"cp /bin/busybox " + shellPath + "/busybox && " +
+				shellPath + "/busybox --install -s " + shellPath,
+		},
+		Volumes: []*engine.VolumeMount{mount},
+	})
 }
 
 // helper function configures the pipeline script for the
@@ -40,6 +184,24 @@ func setupScriptPosix(before func() string, commands []string, dst *engine.Step)
 	dst.Envs["DRONE_SCRIPT"] = shell.Script(before, commands)
 }
 
+// helper function moves the step script to the pipeline
+// secret when it is too large to be passed to the container
+// as an environment variable. The engine mounts the script
+// as a file instead.
+func configureScriptFile(spec *engine.Spec, dst *engine.Step) {
+	script, ok := dst.Envs["DRONE_SCRIPT"]
+	if !ok || engine.EnvSize("DRONE_SCRIPT", script) <= engine.MaxEnvSize {
+		return
+	}
+	name := dst.ID + ".sh"
+	spec.Secrets[name] = &engine.Secret{
+		Name: name,
+		Data: script,
+	}
+	dst.ScriptFile = name
+	delete(dst.Envs, "DRONE_SCRIPT")
+}
+
 func getCommand(image string) string {
 	temp := getImageName(image)
 	temp = strings.ReplaceAll(temp, "-", "_")
@@ -51,8 +213,7 @@ func getCommand(image string) string {
 	return getImageName(image)
 }
 
-func getImageName(image string) string {
-	noTagImage := strings.Split(image, ":")[0]
-	split := strings.Split(noTagImage, "/")
+func getImageName(name string) string {
+	split := strings.Split(image.Trim(name), "/")
 	return split[len(split)-1]
 }
@@ -0,0 +1,145 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/internal/docker/inspect"
+)
+
+func Test_configureScriptFile(t *testing.T) {
+	spec := &engine.Spec{Secrets: map[string]*engine.Secret{}}
+	small := &engine.Step{
+		ID:   "small",
+		Envs: map[string]string{"DRONE_SCRIPT": "echo hello"},
+	}
+	large := &engine.Step{
+		ID:   "large",
+		Envs: map[string]string{"DRONE_SCRIPT": strings.Repeat("a", engine.MaxEnvSize)},
+	}
+	configureScriptFile(spec, small)
+	configureScriptFile(spec, large)
+
+	if small.ScriptFile != "" {
+		t.Errorf("Expect small script passed as environment variable")
+	}
+	if large.ScriptFile != "large.sh" {
+		t.Errorf("Expect large script passed as file")
+	}
+	if _, ok := large.Envs["DRONE_SCRIPT"]; ok {
+		t.Errorf("Expect large script removed from environment")
+	}
+	if got := spec.Secrets["large.sh"]; got == nil || got.Mask {
+		t.Errorf("Expect unmasked script stored in pipeline secret")
+	}
+}
+
+func Test_configureShell(t *testing.T) {
+	c := &Compiler{Shellless: []string{"gcr.io/distroless/base"}}
+
+	src := &resource.Step{Image: "gcr.io/distroless/base", Commands: []string{"/app"}}
+	dst := &engine.Step{Envs: map[string]string{}}
+	c.setupScript(src, dst, false, "")
+	if got, want := dst.Shell, "/drone/bin/sh"; got != want {
+		t.Errorf("Want shell %q, got %q", want, got)
+	}
+
+	spec := &engine.Spec{Steps: []*engine.Step{dst, {Name: "build"}}}
+	c.configureShell(spec)
+	if len(spec.Init) != 1 {
+		t.Errorf("Expect init step installs static shell")
+	}
+	if len(dst.Volumes) != 1 || dst.Volumes[0].Path != shellPath {
+		t.Errorf("Expect shell volume mounted")
+	}
+	if len(spec.Steps[1].Volumes) != 0 {
+		t.Errorf("Expect shell volume not mounted in steps with a shell")
+	}
+}
+
+func Test_setupEntrypoint(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("plugins/slack:\n  entrypoint: [ /bin/drone-slack ]\n  cmd: [ --help ]\n")
+	f.Close()
+
+	c := &Compiler{Inspector: inspect.New("", "", time.Hour)}
+	if err := c.Inspector.Load(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	src := &resource.Step{Image: "plugins/slack"}
+	c.setupEntrypoint(context.Background(), &resource.Pipeline{}, src)
+	if got, want := strings.Join(src.Commands, "; "), "'/bin/drone-slack' '--help'"; got != want {
+		t.Errorf("Want commands %q, got %q", want, got)
+	}
+
+	// the step command overrides the image command.
+	src = &resource.Step{Image: "plugins/slack", Command: []string{"--channel", "dev team"}}
+	c.setupEntrypoint(context.Background(), &resource.Pipeline{}, src)
+	if got, want := strings.Join(src.Commands, "; "), "'/bin/drone-slack' '--channel' 'dev team'"; got != want {
+		t.Errorf("Want commands %q, got %q", want, got)
+	}
+
+	// steps with commands are not changed.
+	src = &resource.Step{Image: "plugins/slack", Commands: []string{"echo hello"}}
+	c.setupEntrypoint(context.Background(), &resource.Pipeline{}, src)
+	if got, want := strings.Join(src.Commands, "; "), "echo hello"; got != want {
+		t.Errorf("Want commands %q, got %q", want, got)
+	}
+}
+
+func Test_execTemplate(t *testing.T) {
+	c := &Compiler{
+		ExecTemplates: map[string]string{
+			"linux":      `printf '%s' {script} | {shell}`,
+			"linux/bash": `printf '%s' {script} | bash`,
+		},
+	}
+	tests := []struct {
+		os    string
+		shell string
+		want  string
+	}{
+		{"", "", `printf '%s' {script} | {shell}`},
+		{"linux", "none", `printf '%s' {script} | {shell}`},
+		{"linux", "bash", `printf '%s' {script} | bash`},
+		{"windows", "", ""},
+	}
+	for i, test := range tests {
+		got := c.execTemplate(test.os, &resource.Step{Shell: test.shell})
+		if got != test.want {
+			t.Errorf("Want template %q, got %q at index %d", test.want, got, i)
+		}
+	}
+	if got := new(Compiler).execTemplate("linux", &resource.Step{}); got != "" {
+		t.Errorf("Want empty template, got %q", got)
+	}
+}
+
+func Test_getImageName(t *testing.T) {
+	tests := map[string]string{
+		"golang:1.12":                      "golang",
+		"plugins/docker":                   "docker",
+		"registry:5000/plugins/slack:1.0":  "slack",
+		"[fd00::1]:5000/plugins/slack:1.0": "slack",
+	}
+	for from, want := range tests {
+		if got := getImageName(from); got != want {
+			t.Errorf("Want image name %q, got %q", want, got)
+		}
+	}
+}
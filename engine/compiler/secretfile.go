@@ -0,0 +1,128 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+)
+
+// binarySuffix is appended to the name of the pipeline secret
+// that holds the decoded value of a base64 encoded secret.
+const binarySuffix = ".binary"
+
+// secretFilePath is the default directory where secret files
+// are mounted.
+const secretFilePath = "/drone/secrets"
+
+// helper function converts the secret files from the yaml
+// package to the secret files used by the engine. Base64
+// encoded secrets are decoded into a separate pipeline secret.
+func convertSecretFiles(src []*resource.SecretFile) []*engine.SecretFile {
+	var dst []*engine.SecretFile
+	for _, file := range src {
+		if file == nil || strings.TrimSpace(file.Secret) == "" {
+			continue
+		}
+		name := file.Secret
+		if strings.EqualFold(file.Encoding, "base64") {
+			name += binarySuffix
+		}
+		target := file.Path
+		if target == "" {
+			target = path.Join(secretFilePath, file.Secret)
+		}
+		dst = append(dst, &engine.SecretFile{
+			Name: name,
+			Path: target,
+		})
+	}
+	return dst
+}
+
+// helper function returns the name of the secret requested
+// from the secret providers, and true if the secret value is
+// base64 encoded binary data.
+func toSecretName(name string) (string, bool) {
+	if strings.HasSuffix(name, binarySuffix) {
+		return strings.TrimSuffix(name, binarySuffix), true
+	}
+	return name, false
+}
+
+// helper function removes the secret files of secrets that
+// were not found, because the step container cannot start if a
+// mounted key does not exist, and delivers the secret variables
+// that exceed the maximum environment variable size, or the
+// maximum environment size, as files. The largest secrets are
+// moved first, and the variable is replaced with the file
+// path, suffixed with _FILE.
+func configureSecretFiles(spec *engine.Spec, dst *engine.Step) {
+	var files []*engine.SecretFile
+	for _, file := range dst.SecretFiles {
+		if _, ok := spec.Secrets[file.Name]; ok {
+			files = append(files, file)
+		}
+	}
+	dst.SecretFiles = files
+
+	total := 0
+	for k, v := range dst.Envs {
+		total += engine.EnvSize(k, v)
+	}
+	var found []*engine.SecretVar
+	for _, v := range dst.Secrets {
+		if secret, ok := spec.Secrets[v.Name]; ok {
+			total += engine.EnvSize(v.Env, secret.Data)
+			found = append(found, v)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return len(spec.Secrets[found[i].Name].Data) > len(spec.Secrets[found[j].Name].Data)
+	})
+
+	moved := map[*engine.SecretVar]bool{}
+	for _, v := range found {
+		size := engine.EnvSize(v.Env, spec.Secrets[v.Name].Data)
+		if size <= engine.MaxEnvSize && total <= engine.MaxEnvTotal {
+			continue
+		}
+		file := engine.ScriptPath + "/" + v.Name
+		if dst.Envs == nil {
+			dst.Envs = map[string]string{}
+		}
+		dst.Envs[v.Env+"_FILE"] = file
+		if !hasValueFile(dst, v.Name) {
+			dst.ValueFiles = append(dst.ValueFiles, v.Name)
+		}
+		moved[v] = true
+		total += engine.EnvSize(v.Env+"_FILE", file) - size
+	}
+	if len(moved) == 0 {
+		return
+	}
+	var vars []*engine.SecretVar
+	for _, v := range dst.Secrets {
+		if !moved[v] {
+			vars = append(vars, v)
+		}
+	}
+	dst.Secrets = vars
+}
+
+// helper function returns true if the step mounts the named
+// value file.
+func hasValueFile(step *engine.Step, name string) bool {
+	for _, file := range step.ValueFiles {
+		if file == name {
+			return true
+		}
+	}
+	return false
+}
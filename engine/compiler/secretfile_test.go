@@ -0,0 +1,69 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+)
+
+func Test_convertSecretFiles(t *testing.T) {
+	files := convertSecretFiles([]*resource.SecretFile{
+		{Secret: "kubeconfig", Path: "/root/.kube/config"},
+		{Secret: "keystore", Encoding: "base64"},
+		{Secret: ""},
+	})
+	if len(files) != 2 {
+		t.Fatalf("Want secret files without a secret ignored, got %d files", len(files))
+	}
+	if got, want := files[0].Path, "/root/.kube/config"; got != want {
+		t.Errorf("Want path %q, got %q", want, got)
+	}
+	if got, want := files[1].Name, "keystore"+binarySuffix; got != want {
+		t.Errorf("Want binary secret name %q, got %q", want, got)
+	}
+	if got, want := files[1].Path, "/drone/secrets/keystore"; got != want {
+		t.Errorf("Want default path %q, got %q", want, got)
+	}
+	if name, binary := toSecretName(files[1].Name); name != "keystore" || !binary {
+		t.Errorf("Want binary secret fetched by its name, got %q", name)
+	}
+}
+
+func Test_configureSecretFiles(t *testing.T) {
+	spec := &engine.Spec{
+		Secrets: map[string]*engine.Secret{
+			"token":      {Name: "token", Data: "correct-horse-battery-staple", Mask: true},
+			"kubeconfig": {Name: "kubeconfig", Data: strings.Repeat("a", engine.MaxEnvSize), Mask: true},
+		},
+	}
+	step := &engine.Step{
+		Secrets: []*engine.SecretVar{
+			{Name: "token", Env: "TOKEN"},
+			{Name: "kubeconfig", Env: "KUBECONFIG"},
+		},
+		SecretFiles: []*engine.SecretFile{
+			{Name: "kubeconfig", Path: "/root/.kube/config"},
+			{Name: "missing", Path: "/root/missing"},
+		},
+	}
+	configureSecretFiles(spec, step)
+
+	if len(step.SecretFiles) != 1 {
+		t.Errorf("Want secret files of missing secrets removed")
+	}
+	if len(step.Secrets) != 1 || step.Secrets[0].Name != "token" {
+		t.Errorf("Want large secret removed from environment")
+	}
+	if got, want := step.Envs["KUBECONFIG_FILE"], "/drone/scripts/kubeconfig"; got != want {
+		t.Errorf("Want secret file variable %q, got %q", want, got)
+	}
+	if len(step.ValueFiles) != 1 || step.ValueFiles[0] != "kubeconfig" {
+		t.Errorf("Want large secret mounted as file")
+	}
+}
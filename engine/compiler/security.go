@@ -0,0 +1,110 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+)
+
+// helper function returns the pod security context, with the
+// pipeline overrides applied to the runner defaults. Overrides
+// outside the bounds of the security policy are ignored, and
+// the pipeline can only tighten the non-root, capabilities and
+// privilege escalation defaults.
+func (c *Compiler) createSecurityContext(src *resource.SecurityContext) *engine.SecurityContext {
+	dst := c.SecurityContext
+	dst.DropCapabilities = append([]string(nil), c.SecurityContext.DropCapabilities...)
+	if src != nil {
+		dst.RunAsNonRoot = dst.RunAsNonRoot || src.RunAsNonRoot
+		if src.RunAsUser != nil && c.SecurityPolicy.allowsUser(*src.RunAsUser) &&
+			(*src.RunAsUser != 0 || dst.RunAsNonRoot == false) {
+			dst.RunAsUser = src.RunAsUser
+		}
+		if src.RunAsGroup != nil && c.SecurityPolicy.allowsUser(*src.RunAsGroup) {
+			dst.RunAsGroup = src.RunAsGroup
+		}
+		if src.FSGroup != nil && c.SecurityPolicy.allowsUser(*src.FSGroup) {
+			dst.FSGroup = src.FSGroup
+		}
+		if src.SeccompProfile != "" && c.SecurityPolicy.allowsSeccomp(src.SeccompProfile) {
+			dst.SeccompProfile = src.SeccompProfile
+		}
+		if src.Capabilities != nil {
+			for _, capability := range src.Capabilities.Drop {
+				if !contains(dst.DropCapabilities, capability) {
+					dst.DropCapabilities = append(dst.DropCapabilities, capability)
+				}
+			}
+		}
+		if src.AllowPrivilegeEscalation != nil && *src.AllowPrivilegeEscalation == false {
+			dst.AllowPrivilegeEscalation = src.AllowPrivilegeEscalation
+		}
+	}
+	if isZeroSecurityContext(&dst) {
+		return nil
+	}
+	return &dst
+}
+
+// helper function removes the step users that are outside the
+// bounds of the security policy, or that run as root when the
+// pod must run as non-root. The step then runs as the pod user.
+func capStepUsers(spec *engine.Spec, policy SecurityPolicy) {
+	nonroot := spec.PodSpec.SecurityContext != nil &&
+		spec.PodSpec.SecurityContext.RunAsNonRoot
+	for _, step := range append(spec.Init, spec.Steps...) {
+		uid, gid := engine.ParseUser(step.User)
+		switch {
+		case uid != nil && policy.allowsUser(*uid) == false,
+			gid != nil && policy.allowsUser(*gid) == false,
+			uid != nil && *uid == 0 && nonroot:
+			step.User = ""
+		}
+	}
+}
+
+// helper function returns true if the pipeline containers
+// run as the root user by default.
+func runsAsRoot(spec *engine.Spec) bool {
+	sc := spec.PodSpec.SecurityContext
+	if sc == nil {
+		return true
+	}
+	if sc.RunAsNonRoot {
+		return false
+	}
+	return sc.RunAsUser == nil || *sc.RunAsUser == 0
+}
+
+func (p SecurityPolicy) allowsUser(id int64) bool {
+	if id < p.MinUser {
+		return false
+	}
+	return p.MaxUser == 0 || id <= p.MaxUser
+}
+
+func (p SecurityPolicy) allowsSeccomp(profile string) bool {
+	return contains(p.SeccompProfiles, profile)
+}
+
+func isZeroSecurityContext(sc *engine.SecurityContext) bool {
+	return sc.RunAsUser == nil &&
+		sc.RunAsGroup == nil &&
+		sc.RunAsNonRoot == false &&
+		sc.FSGroup == nil &&
+		sc.SeccompProfile == "" &&
+		len(sc.DropCapabilities) == 0 &&
+		sc.AllowPrivilegeEscalation == nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func int64ptr(v int64) *int64 { return &v }
+
+func Test_createSecurityContext(t *testing.T) {
+	c := &Compiler{
+		SecurityContext: engine.SecurityContext{
+			RunAsUser:        int64ptr(1000),
+			RunAsNonRoot:     true,
+			SeccompProfile:   "runtime/default",
+			DropCapabilities: []string{"NET_RAW"},
+		},
+		SecurityPolicy: SecurityPolicy{
+			MinUser:         1000,
+			SeccompProfiles: []string{"runtime/default", "localhost/build.json"},
+		},
+	}
+	got := c.createSecurityContext(&resource.SecurityContext{
+		RunAsUser:      int64ptr(2000),
+		RunAsGroup:     int64ptr(10),
+		FSGroup:        int64ptr(2000),
+		SeccompProfile: "unconfined",
+		Capabilities:   &resource.Capabilities{Drop: []string{"ALL", "NET_RAW"}},
+	})
+	want := &engine.SecurityContext{
+		RunAsUser:        int64ptr(2000),
+		RunAsNonRoot:     true,
+		FSGroup:          int64ptr(2000),
+		SeccompProfile:   "runtime/default",
+		DropCapabilities: []string{"NET_RAW", "ALL"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected security context")
+		t.Log(diff)
+	}
+	if len(c.SecurityContext.DropCapabilities) != 1 {
+		t.Errorf("Want runner defaults unchanged")
+	}
+}
+
+func Test_createSecurityContext_NonRoot(t *testing.T) {
+	c := &Compiler{
+		SecurityContext: engine.SecurityContext{
+			RunAsNonRoot: true,
+		},
+	}
+	got := c.createSecurityContext(&resource.SecurityContext{
+		RunAsUser: int64ptr(0),
+	})
+	if got.RunAsUser != nil {
+		t.Errorf("Want root user ignored when the pod must run as non-root")
+	}
+}
+
+func Test_createSecurityContext_Empty(t *testing.T) {
+	c := &Compiler{}
+	if c.createSecurityContext(nil) != nil {
+		t.Errorf("Want nil security context")
+	}
+	sc := c.createSecurityContext(&resource.SecurityContext{RunAsNonRoot: true})
+	if sc == nil || sc.RunAsNonRoot == false {
+		t.Errorf("Want pipeline to require non-root")
+	}
+}
+
+func Test_capStepUsers(t *testing.T) {
+	spec := &engine.Spec{
+		PodSpec: engine.PodSpec{
+			SecurityContext: &engine.SecurityContext{RunAsNonRoot: true},
+		},
+		Steps: []*engine.Step{
+			{Name: "root", User: "0"},
+			{Name: "low", User: "100"},
+			{Name: "group", User: "1000:0"},
+			{Name: "ok", User: "1000:1000"},
+		},
+	}
+	capStepUsers(spec, SecurityPolicy{MinUser: 1000})
+	var users []string
+	for _, step := range spec.Steps {
+		users = append(users, step.User)
+	}
+	if diff := cmp.Diff([]string{"", "", "", "1000:1000"}, users); diff != "" {
+		t.Errorf("Unexpected step users")
+		t.Log(diff)
+	}
+}
+
+func Test_runsAsRoot(t *testing.T) {
+	tests := []struct {
+		sc   *engine.SecurityContext
+		want bool
+	}{
+		{nil, true},
+		{&engine.SecurityContext{RunAsUser: int64ptr(0)}, true},
+		{&engine.SecurityContext{RunAsUser: int64ptr(1000)}, false},
+		{&engine.SecurityContext{RunAsNonRoot: true}, false},
+	}
+	for _, test := range tests {
+		spec := &engine.Spec{PodSpec: engine.PodSpec{SecurityContext: test.sc}}
+		if got := runsAsRoot(spec); got != test.want {
+			t.Errorf("Want runs as root %v, got %v", test.want, got)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import "github.com/drone-runners/drone-runner-kube/internal/settings"
+
+// helper function returns the workspace configuration with
+// the repository settings applied.
+func applyWorkspaceSettings(dst Workspace, src settings.Workspace) Workspace {
+	if src.Backend != "" {
+		dst.Backend = src.Backend
+	}
+	if src.Claim != nil {
+		dst.Claim = *src.Claim
+	}
+	if src.StorageClass != "" {
+		dst.StorageClass = src.StorageClass
+	}
+	if src.Size != 0 {
+		dst.Size = src.Size
+	}
+	if src.Snapshot != nil {
+		dst.Snapshot = *src.Snapshot
+	}
+	return dst
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/internal/settings"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_applyWorkspaceSettings(t *testing.T) {
+	enabled, disabled := true, false
+	defaults := Workspace{
+		Claim:        false,
+		StorageClass: "standard",
+		Size:         1 << 30,
+		Snapshot:     true,
+	}
+	got := applyWorkspaceSettings(defaults, settings.Workspace{
+		Claim:    &enabled,
+		Size:     10 << 30,
+		Snapshot: &disabled,
+	})
+	want := Workspace{
+		Claim:        true,
+		StorageClass: "standard",
+		Size:         10 << 30,
+		Snapshot:     false,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected workspace settings")
+		t.Log(diff)
+	}
+}
@@ -23,22 +23,51 @@ func createStep(spec *resource.Pipeline, src *resource.Step) *engine.Step {
 		Detach:       src.Detach,
 		DependsOn:    src.DependsOn,
 		Envs:         convertStaticEnv(src.Environment),
+		EnvFiles:     src.EnvFile,
+		EnvRefs:      convertEnvFrom(src.EnvFrom),
 		IgnoreErr:    strings.EqualFold(src.Failure, "ignore"),
 		IgnoreStderr: false,
 		IgnoreStdout: false,
+		Node:         src.Node,
+		OffPeak:      strings.EqualFold(src.Schedule, "off_peak"),
 		Privileged:   src.Privileged,
 		Pull:         convertPullPolicy(src.Pull),
 		User:         src.User,
 		Resources:    convertResources(src.Resources),
 		Secrets:      convertSecretEnv(src.Environment),
+		SecretFiles:  convertSecretFiles(src.SecretFiles),
 		WorkingDir:   src.WorkingDir,
+		JUnit:        src.JUnit,
+		Timeout:      src.Timeout,
+	}
+
+	// appends the code coverage settings.
+	if src.Coverage != nil && len(src.Coverage.Paths) != 0 {
+		dst.Coverage = &engine.Coverage{
+			Paths:     src.Coverage.Paths,
+			Threshold: src.Coverage.Threshold,
+		}
+	}
+
+	// appends the conditions the step waits for.
+	if src.WaitFor != nil {
+		dst.WaitFor = &engine.WaitFor{
+			HTTP:    src.WaitFor.HTTP,
+			TCP:     src.WaitFor.TCP,
+			File:    src.WaitFor.File,
+			Command: src.WaitFor.Command,
+			Timeout: src.WaitFor.Timeout,
+		}
 	}
 
 	// appends the volumes to the container def.
 	for _, vol := range src.Volumes {
 		dst.Volumes = append(dst.Volumes, &engine.VolumeMount{
-			Name: vol.Name,
-			Path: vol.MountPath,
+			Name:        vol.Name,
+			Path:        vol.MountPath,
+			SubPath:     vol.SubPath,
+			ReadOnly:    vol.ReadOnly,
+			Propagation: vol.Propagation,
 		})
 	}
 
@@ -68,6 +97,17 @@ func createStep(spec *resource.Pipeline, src *resource.Step) *engine.Step {
 		}
 	}
 
+	// appends the payload written to the standard input of
+	// the step process, which is optionally sourced from a
+	// secret.
+	if src.Stdin != nil {
+		if src.Stdin.Secret != "" {
+			dst.Stdin = &engine.Stdin{Secret: src.Stdin.Secret}
+		} else {
+			dst.Stdin = &engine.Stdin{Data: encoder.Encode(src.Stdin.Value)}
+		}
+	}
+
 	// set the pipeline step run policy. steps run on
 	// success by default, but may be optionally configured
 	// to run on failure.
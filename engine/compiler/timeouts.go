@@ -0,0 +1,43 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/internal/timeouts"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// helper function configures the pipeline timeouts. The
+// default step timeout applies to the steps that do not define
+// a timeout, and does not apply to detached steps and services
+// which run until the pipeline completes.
+func configureTimeouts(spec *engine.Spec, t timeouts.Timeouts) {
+	spec.ScheduleTimeout = int64(t.Schedule / time.Second)
+	spec.PullTimeout = int64(t.Pull / time.Second)
+	spec.TeardownTimeout = int64(t.Teardown / time.Second)
+	if t.Step < time.Second {
+		return
+	}
+	for _, step := range spec.Steps {
+		if step.Timeout == 0 && !step.Detach {
+			step.Timeout = int64(t.Step / time.Second)
+		}
+	}
+}
+
+// helper function returns the stage timeout in minutes, which
+// is the repository timeout capped to the maximum stage
+// timeout.
+func stageTimeout(repo *drone.Repo, table *timeouts.Table) int64 {
+	max := int64(table.Lookup(repo.Slug).Stage / time.Minute)
+	if max > 0 && repo.Timeout > max {
+		return max
+	}
+	return repo.Timeout
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/internal/timeouts"
+)
+
+func TestConfigureTimeouts(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			{Name: "build"},
+			{Name: "test", Timeout: 60},
+			{Name: "redis", Detach: true},
+		},
+	}
+	configureTimeouts(spec, timeouts.Timeouts{
+		Schedule: 10 * time.Minute,
+		Pull:     5 * time.Minute,
+		Step:     30 * time.Minute,
+		Teardown: 2 * time.Minute,
+	})
+	if got, want := spec.ScheduleTimeout, int64(600); got != want {
+		t.Errorf("Want schedule timeout %d, got %d", want, got)
+	}
+	if got, want := spec.PullTimeout, int64(300); got != want {
+		t.Errorf("Want pull timeout %d, got %d", want, got)
+	}
+	if got, want := spec.TeardownTimeout, int64(120); got != want {
+		t.Errorf("Want teardown timeout %d, got %d", want, got)
+	}
+	if got, want := spec.Steps[0].Timeout, int64(1800); got != want {
+		t.Errorf("Want default step timeout %d, got %d", want, got)
+	}
+	if got, want := spec.Steps[1].Timeout, int64(60); got != want {
+		t.Errorf("Want step timeout %d preserved, got %d", want, got)
+	}
+	if got := spec.Steps[2].Timeout; got != 0 {
+		t.Errorf("Want no default timeout for detached steps, got %d", got)
+	}
+}
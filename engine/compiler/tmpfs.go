@@ -0,0 +1,34 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+)
+
+// helper function mounts the tmpfs filesystems of the step,
+// which are memory-backed empty directory volumes that are
+// only mounted in the step container.
+func configureTmpfs(spec *engine.Spec, src *resource.Step, dst *engine.Step) {
+	for _, tmpfs := range src.Tmpfs {
+		if tmpfs == nil || tmpfs.Path == "" {
+			continue
+		}
+		name := "_tmpfs_" + random()
+		spec.Volumes = append(spec.Volumes, &engine.Volume{
+			EmptyDir: &engine.VolumeEmptyDir{
+				ID:        random(),
+				Name:      name,
+				Medium:    "memory",
+				SizeLimit: int64(tmpfs.Size),
+			},
+		})
+		dst.Volumes = append(dst.Volumes, &engine.VolumeMount{
+			Name: name,
+			Path: tmpfs.Path,
+		})
+	}
+}
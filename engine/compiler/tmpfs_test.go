@@ -0,0 +1,38 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+)
+
+func Test_configureTmpfs(t *testing.T) {
+	spec := &engine.Spec{}
+	src := &resource.Step{
+		Tmpfs: []*resource.Tmpfs{
+			{Path: "/tmp", Size: 64 * 1024 * 1024},
+			{Path: "/var/cache"},
+		},
+	}
+	dst := &engine.Step{}
+	configureTmpfs(spec, src, dst)
+
+	if len(spec.Volumes) != 2 || len(dst.Volumes) != 2 {
+		t.Fatalf("Want a volume and mount per tmpfs")
+	}
+	volume := spec.Volumes[0].EmptyDir
+	if volume.Medium != "memory" || volume.SizeLimit != 64*1024*1024 {
+		t.Errorf("Want memory volume with size limit, got %+v", volume)
+	}
+	if got, want := dst.Volumes[0].Name, volume.Name; got != want {
+		t.Errorf("Want mount of volume %q, got %q", want, got)
+	}
+	if got, want := dst.Volumes[1].Path, "/var/cache"; got != want {
+		t.Errorf("Want mount path %q, got %q", want, got)
+	}
+}
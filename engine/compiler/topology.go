@@ -0,0 +1,45 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/runner-go/labels"
+)
+
+const (
+	// default node label of the zone.
+	defaultZoneLabel = "topology.kubernetes.io/zone"
+
+	// default node label of the region.
+	defaultRegionLabel = "topology.kubernetes.io/region"
+)
+
+// helper function adds the zone and region of the pipeline to
+// the node selector of the pipeline pod, and records them as
+// the pod topology, which the engine verifies against the
+// zones and regions of the cluster nodes. The zone and region
+// take precedence over the node selector.
+func configureTopology(spec *engine.Spec, topology Topology, zone, region string) {
+	if zone == "" && region == "" {
+		return
+	}
+	zoneLabel, regionLabel := topology.ZoneLabel, topology.RegionLabel
+	if zoneLabel == "" {
+		zoneLabel = defaultZoneLabel
+	}
+	if regionLabel == "" {
+		regionLabel = defaultRegionLabel
+	}
+	spec.PodSpec.Topology = map[string]string{}
+	if region != "" {
+		spec.PodSpec.Topology[regionLabel] = region
+	}
+	if zone != "" {
+		spec.PodSpec.Topology[zoneLabel] = zone
+	}
+	spec.PodSpec.NodeSelector = labels.Combine(spec.PodSpec.NodeSelector, spec.PodSpec.Topology)
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_configureTopology(t *testing.T) {
+	spec := &engine.Spec{}
+	configureTopology(spec, Topology{}, "", "")
+	if spec.PodSpec.NodeSelector != nil || spec.PodSpec.Topology != nil {
+		t.Errorf("Want no topology if no zone or region is configured")
+	}
+
+	spec = &engine.Spec{
+		PodSpec: engine.PodSpec{
+			NodeSelector: map[string]string{
+				"pool":                        "builds",
+				"topology.kubernetes.io/zone": "us-east1-b",
+			},
+		},
+	}
+	configureTopology(spec, Topology{}, "us-east1-c", "us-east1")
+	want := map[string]string{
+		"pool":                          "builds",
+		"topology.kubernetes.io/zone":   "us-east1-c",
+		"topology.kubernetes.io/region": "us-east1",
+	}
+	if diff := cmp.Diff(spec.PodSpec.NodeSelector, want); diff != "" {
+		t.Errorf("Unexpected node selector")
+		t.Log(diff)
+	}
+	want = map[string]string{
+		"topology.kubernetes.io/zone":   "us-east1-c",
+		"topology.kubernetes.io/region": "us-east1",
+	}
+	if diff := cmp.Diff(spec.PodSpec.Topology, want); diff != "" {
+		t.Errorf("Unexpected topology")
+		t.Log(diff)
+	}
+
+	spec = &engine.Spec{}
+	configureTopology(spec, Topology{ZoneLabel: "failure-domain.beta.kubernetes.io/zone"}, "us-east1-c", "")
+	want = map[string]string{
+		"failure-domain.beta.kubernetes.io/zone": "us-east1-c",
+	}
+	if diff := cmp.Diff(spec.PodSpec.NodeSelector, want); diff != "" {
+		t.Errorf("Unexpected node selector with custom zone label")
+		t.Log(diff)
+	}
+}
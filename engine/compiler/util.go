@@ -5,6 +5,7 @@
 package compiler
 
 import (
+	"sort"
 	"strings"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
@@ -92,6 +93,46 @@ func convertSecretEnv(src map[string]*manifest.Variable) []*engine.SecretVar {
 	return dst
 }
 
+// helper function converts the environment variable sources
+// from the yaml package to the environment variable references
+// used by the engine, sorted by variable name.
+func convertEnvFrom(src map[string]*resource.EnvFrom) []*engine.EnvRef {
+	var dst []*engine.EnvRef
+	for k, v := range src {
+		if v == nil {
+			continue
+		}
+		ref := &engine.EnvRef{Env: k}
+		switch {
+		case v.Secret != nil:
+			ref.Secret = convertEnvKeyRef(v.Secret)
+		case v.ConfigMap != nil:
+			ref.ConfigMap = convertEnvKeyRef(v.ConfigMap)
+		case v.Vault != nil:
+			ref.Vault = &engine.EnvKeyRef{
+				Name:     v.Vault.Path,
+				Key:      v.Vault.Key,
+				Optional: v.Vault.Optional,
+			}
+		default:
+			continue
+		}
+		dst = append(dst, ref)
+	}
+	sort.Slice(dst, func(i, j int) bool {
+		return dst[i].Env < dst[j].Env
+	})
+	return dst
+}
+
+func convertEnvKeyRef(src *resource.EnvKeyRef) *engine.EnvKeyRef {
+	return &engine.EnvKeyRef{
+		Name:     src.Name,
+		Key:      src.Key,
+		Optional: src.Optional,
+	}
+}
+
 // helper function converts the resource limits structure from the
 // yaml package to the resource limit structure used by the engine.
 func convertResources(src resource.Resources) engine.Resources {
@@ -107,6 +148,83 @@ func convertResources(src resource.Resources) engine.Resources {
 	}
 }
 
+// helper function applies the default cpu and memory to the
+// resource object if the cpu or memory is not defined.
+func defaultResources(dst *engine.ResourceObject, cpu, memory int64) {
+	if dst.CPU == 0 {
+		dst.CPU = cpu
+	}
+	if dst.Memory == 0 {
+		dst.Memory = memory
+	}
+}
+
+// helper function caps the resources at the maximum, and caps
+// the resource requests at the resource limits, because the
+// pod is rejected if a request exceeds the limit. A zero
+// maximum is not enforced.
+func capResources(dst *engine.Resources, max ResourceObject) {
+	for _, res := range []*engine.ResourceObject{&dst.Limits, &dst.Requests} {
+		if max.CPU > 0 && res.CPU > max.CPU {
+			res.CPU = max.CPU
+		}
+		if max.Memory > 0 && res.Memory > max.Memory {
+			res.Memory = max.Memory
+		}
+	}
+	if dst.Limits.CPU > 0 && dst.Requests.CPU > dst.Limits.CPU {
+		dst.Requests.CPU = dst.Limits.CPU
+	}
+	if dst.Limits.Memory > 0 && dst.Requests.Memory > dst.Limits.Memory {
+		dst.Requests.Memory = dst.Limits.Memory
+	}
+}
+
+// helper function converts the projected volume sources from
+// the yaml package to the projected volume sources used by
+// the engine.
+func convertProjections(src []*resource.VolumeProjection) []engine.VolumeProjection {
+	var dst []engine.VolumeProjection
+	for _, s := range src {
+		var p engine.VolumeProjection
+		if s.Secret != nil {
+			p.Secret = convertProjectedObject(s.Secret)
+		}
+		if s.ConfigMap != nil {
+			p.ConfigMap = convertProjectedObject(s.ConfigMap)
+		}
+		if s.ServiceAccountToken != nil {
+			p.ServiceAccountToken = &engine.VolumeProjectedToken{
+				Audience:          s.ServiceAccountToken.Audience,
+				ExpirationSeconds: s.ServiceAccountToken.ExpirationSeconds,
+				Path:              s.ServiceAccountToken.Path,
+			}
+		}
+		dst = append(dst, p)
+	}
+	return dst
+}
+
+func convertProjectedObject(src *resource.VolumeProjectedObject) *engine.VolumeProjectedObject {
+	return &engine.VolumeProjectedObject{
+		Name:  src.Name,
+		Items: convertItems(src.Items),
+	}
+}
+
+// helper function converts the keys of a secret or config map
+// to the file paths they are mounted at.
+func convertItems(src []*resource.VolumeProjectedItem) []engine.VolumeSecretItem {
+	var dst []engine.VolumeSecretItem
+	for _, item := range src {
+		dst = append(dst, engine.VolumeSecretItem{
+			Key:  item.Key,
+			Path: item.Path,
+		})
+	}
+	return dst
+}
+
 // helper function modifies the pipeline dependency graph to
 // account for the clone step.
 func configureCloneDeps(spec *engine.Spec) {
@@ -150,3 +268,26 @@ func convertPullPolicy(s string) engine.PullPolicy {
 		return engine.PullDefault
 	}
 }
+
+// helper function returns a shallow copy of the step. The
+// compiler modifies the copy, so that the parsed pipeline can
+// be shared between stages.
+func copyStep(src *resource.Step) *resource.Step {
+	dst := *src
+	return &dst
+}
+
+// helper function converts the node requirements of the
+// pipeline affinity.
+func toNodeRequirements(src []resource.NodeRequirement) []engine.NodeRequirement {
+	var dst []engine.NodeRequirement
+	for _, req := range src {
+		dst = append(dst, engine.NodeRequirement{
+			Key:      req.Key,
+			Operator: req.Operator,
+			Values:   req.Values,
+			Weight:   req.Weight,
+		})
+	}
+	return dst
+}
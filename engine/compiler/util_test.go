@@ -120,6 +120,24 @@ func Test_convertSecretEnv(t *testing.T) {
 	}
 }
 
+func Test_convertEnvFrom(t *testing.T) {
+	src := map[string]*resource.EnvFrom{
+		"REGION":   {ConfigMap: &resource.EnvKeyRef{Name: "aws", Key: "region"}},
+		"TOKEN":    {Vault: &resource.EnvVaultRef{Path: "secret/data/ci", Key: "token", Optional: true}},
+		"PASSWORD": {Secret: &resource.EnvKeyRef{Name: "db", Key: "password"}},
+		"INVALID":  {},
+	}
+	want := []*engine.EnvRef{
+		{Env: "PASSWORD", Secret: &engine.EnvKeyRef{Name: "db", Key: "password"}},
+		{Env: "REGION", ConfigMap: &engine.EnvKeyRef{Name: "aws", Key: "region"}},
+		{Env: "TOKEN", Vault: &engine.EnvKeyRef{Name: "secret/data/ci", Key: "token", Optional: true}},
+	}
+	if diff := cmp.Diff(convertEnvFrom(src), want); diff != "" {
+		t.Errorf("Unexpected environment variable references")
+		t.Log(diff)
+	}
+}
+
 func Test_configureCloneDeps(t *testing.T) {
 	before := new(engine.Spec)
 	before.Steps = []*engine.Step{
@@ -196,3 +214,26 @@ func Test_removeCloneDeps_CloneEnabled(t *testing.T) {
 		t.Log(diff)
 	}
 }
+
+func Test_capResources(t *testing.T) {
+	res := engine.Resources{
+		Limits:   engine.ResourceObject{CPU: 4000, Memory: 1024},
+		Requests: engine.ResourceObject{CPU: 3000, Memory: 512},
+	}
+	capResources(&res, ResourceObject{CPU: 2000})
+	want := engine.Resources{
+		Limits:   engine.ResourceObject{CPU: 2000, Memory: 1024},
+		Requests: engine.ResourceObject{CPU: 2000, Memory: 512},
+	}
+	if diff := cmp.Diff(res, want); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+func Test_defaultResources(t *testing.T) {
+	res := engine.ResourceObject{CPU: 500}
+	defaultResources(&res, 1000, 1024)
+	if got, want := res, (engine.ResourceObject{CPU: 500, Memory: 1024}); got != want {
+		t.Errorf("Want resources %v, got %v", want, got)
+	}
+}
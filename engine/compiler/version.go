@@ -0,0 +1,94 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// default length of the short commit sha.
+const defaultShortSHA = 8
+
+// maximum length of a docker image tag.
+const maxTagLength = 128
+
+// regular expression matches characters that are not
+// permitted in a docker image tag.
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// helper function returns the computed version variables for
+// the build. This includes the short commit sha, the branch name
+// sanitized for use as an image tag, and the next semantic
+// versions if the build is a semantic version tag.
+func versionEnviron(build *drone.Build, length int) map[string]string {
+	envs := map[string]string{
+		"DRONE_COMMIT_SHA_SHORT": shortSHA(build.After, length),
+		"DRONE_BRANCH_TAG":       branchTag(build.Target),
+	}
+	if !strings.HasPrefix(build.Ref, "refs/tags/") {
+		return envs
+	}
+	tag := strings.TrimPrefix(build.Ref, "refs/tags/")
+	major, minor, patch, ok := parseVersion(tag)
+	if !ok {
+		return envs
+	}
+	envs["DRONE_SEMVER_NEXT_MAJOR"] = fmt.Sprintf("%d.0.0", major+1)
+	envs["DRONE_SEMVER_NEXT_MINOR"] = fmt.Sprintf("%d.%d.0", major, minor+1)
+	envs["DRONE_SEMVER_NEXT_PATCH"] = fmt.Sprintf("%d.%d.%d", major, minor, patch+1)
+	return envs
+}
+
+// helper function returns the commit sha truncated to the
+// given length.
+func shortSHA(sha string, length int) string {
+	if length <= 0 {
+		length = defaultShortSHA
+	}
+	if len(sha) > length {
+		return sha[:length]
+	}
+	return sha
+}
+
+// helper function returns the branch name sanitized for use
+// as a docker image tag. Invalid characters are replaced with
+// a dash, and the tag cannot start with a period or dash.
+func branchTag(branch string) string {
+	tag := invalidTagChars.ReplaceAllString(branch, "-")
+	tag = strings.TrimLeft(tag, ".-")
+	if len(tag) > maxTagLength {
+		tag = tag[:maxTagLength]
+	}
+	return tag
+}
+
+// helper function parses the major, minor and patch version
+// of a semantic version, with an optional v prefix. The
+// pre-release and build metadata are ignored.
+func parseVersion(s string) (major, minor, patch int, ok bool) {
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i != -1 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return
+	}
+	var nums [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return
+		}
+		nums[i] = n
+	}
+	return nums[0], nums[1], nums[2], true
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_versionEnviron(t *testing.T) {
+	build := &drone.Build{
+		After:  "2897b31ec3a1b59279a08a8ad54dc360686327f7",
+		Target: "feature/Login_v2",
+		Ref:    "refs/tags/v1.2.3-rc.1",
+	}
+	want := map[string]string{
+		"DRONE_COMMIT_SHA_SHORT":  "2897b31e",
+		"DRONE_BRANCH_TAG":        "feature-Login_v2",
+		"DRONE_SEMVER_NEXT_MAJOR": "2.0.0",
+		"DRONE_SEMVER_NEXT_MINOR": "1.3.0",
+		"DRONE_SEMVER_NEXT_PATCH": "1.2.4",
+	}
+	if diff := cmp.Diff(want, versionEnviron(build, 0)); diff != "" {
+		t.Errorf("Unexpected version variables")
+		t.Log(diff)
+	}
+
+	build.Ref = "refs/heads/master"
+	got := versionEnviron(build, 12)
+	if _, ok := got["DRONE_SEMVER_NEXT_PATCH"]; ok {
+		t.Errorf("Expect no next version for a branch build")
+	}
+	if got, want := got["DRONE_COMMIT_SHA_SHORT"], "2897b31ec3a1"; got != want {
+		t.Errorf("Want short sha %s, got %s", want, got)
+	}
+}
+
+func Test_branchTag(t *testing.T) {
+	tests := []struct {
+		branch, tag string
+	}{
+		{"master", "master"},
+		{"feature/foo", "feature-foo"},
+		{"-fix/#12", "fix-12"},
+		{"release-1.0", "release-1.0"},
+	}
+	for _, test := range tests {
+		if got := branchTag(test.branch); got != test.tag {
+			t.Errorf("Want tag %s for branch %s, got %s", test.tag, test.branch, got)
+		}
+	}
+}
+
+func Test_parseVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		ok      bool
+	}{
+		{"1.2.3", true},
+		{"v1.2.3", true},
+		{"v1.2.3+build.1", true},
+		{"1.2", false},
+		{"latest", false},
+		{"v1.x.3", false},
+	}
+	for _, test := range tests {
+		if _, _, _, ok := parseVersion(test.version); ok != test.ok {
+			t.Errorf("Want parsed %v for version %s", test.ok, test.version)
+		}
+	}
+}
@@ -5,16 +5,24 @@
 package compiler
 
 import (
+	"crypto/sha1"
+	"fmt"
 	"strings"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/drone/drone-go/drone"
 )
 
 const (
 	workspacePath     = "/drone/src"
 	workspaceName     = "workspace"
 	workspaceHostName = "host"
+
+	// name of the step that fixes workspace ownership for
+	// steps that run as a non-root user.
+	ownerStepName = "workspace-owner"
 )
 
 func createWorkspace(from *resource.Pipeline) string {
@@ -28,6 +36,32 @@ func createWorkspace(from *resource.Pipeline) string {
 	return path
 }
 
+// helper function returns the workspace snapshot settings.
+// Snapshots are keyed by repository, branch and pipeline, and
+// are only created for builds of the branch itself, so that
+// pull requests cannot alter the workspace of the target
+// branch.
+func createSnapshot(args Args, class string) *engine.VolumeClaimSnapshot {
+	var create bool
+	switch args.Build.Event {
+	case drone.EventPush, drone.EventCron, drone.EventCustom:
+		create = true
+	}
+	return &engine.VolumeClaimSnapshot{
+		Key:    snapshotKey(args.Repo.Slug, args.Build.Target, args.Stage.Name),
+		Class:  class,
+		Create: create,
+	}
+}
+
+// helper function returns the snapshot key. The key is hashed
+// to produce a valid label value.
+func snapshotKey(repo, branch, stage string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", repo, branch, stage)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 func setupWorkdir(src *resource.Step, dst *engine.Step, path string) {
 	// if the working directory is already set
 	// do not alter.
@@ -44,6 +78,61 @@ func setupWorkdir(src *resource.Step, dst *engine.Step, path string) {
 	dst.WorkingDir = path
 }
 
+// helper function returns a step that changes the ownership
+// of the cloned workspace, or nil if all pipeline steps run
+// as the root user. If steps run as different users the
+// workspace is made writable by all users.
+func createOwnerStep(spec *engine.Spec, image, path string) *engine.Step {
+	users := map[int64]struct{}{}
+	var uid int64
+	for _, step := range spec.Steps {
+		id, _ := engine.ParseUser(step.User)
+		if id == nil || *id == 0 {
+			continue
+		}
+		uid = *id
+		users[uid] = struct{}{}
+	}
+	var command string
+	switch len(users) {
+	case 0:
+		return nil
+	case 1:
+		command = fmt.Sprintf("chown -R %d %s", uid, path)
+	default:
+		command = fmt.Sprintf("chmod -R a+rwX %s", path)
+	}
+	dst := &engine.Step{
+		ID:         random(),
+		Name:       ownerStepName,
+		Image:      image,
+		Envs:       map[string]string{},
+		WorkingDir: path,
+	}
+	setupScriptPosix(func() string { return "" }, []string{command}, dst)
+	return dst
+}
+
+// helper function modifies the pipeline dependency graph so
+// that steps depending on the clone step depend on the
+// workspace ownership step instead.
+func configureOwnerDeps(spec *engine.Spec) {
+	for _, step := range spec.Steps {
+		switch step.Name {
+		case cloneStepName:
+			continue
+		case ownerStepName:
+			step.DependsOn = []string{cloneStepName}
+			continue
+		}
+		for i, dep := range step.DependsOn {
+			if dep == cloneStepName {
+				step.DependsOn[i] = ownerStepName
+			}
+		}
+	}
+}
+
 // helper function converts the path to a valid windows
 // path, including the default C drive.
 func toWindowsDrive(s string) string {
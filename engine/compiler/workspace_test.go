@@ -5,10 +5,12 @@
 package compiler
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/manifest"
 )
 
@@ -105,3 +107,84 @@ func TestCreateWorkspace(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateOwnerStep(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			{Name: "clone"},
+			{Name: "build", User: "root"},
+		},
+	}
+	if step := createOwnerStep(spec, "drone/git", "/drone/src"); step != nil {
+		t.Errorf("Expect no owner step when steps run as root")
+	}
+
+	spec.Steps = append(spec.Steps, &engine.Step{Name: "test", User: "1000:1000"})
+	step := createOwnerStep(spec, "drone/git", "/drone/src")
+	if step == nil {
+		t.Errorf("Expect owner step when steps run as non-root")
+		return
+	}
+	if got, want := step.Envs["DRONE_SCRIPT"], "chown -R 1000 /drone/src"; !strings.Contains(got, want) {
+		t.Errorf("Expect script contains %q", want)
+	}
+
+	spec.Steps = append(spec.Steps, &engine.Step{Name: "deploy", User: "1001"})
+	step = createOwnerStep(spec, "drone/git", "/drone/src")
+	if got, want := step.Envs["DRONE_SCRIPT"], "chmod -R a+rwX /drone/src"; !strings.Contains(got, want) {
+		t.Errorf("Expect script contains %q", want)
+	}
+}
+
+func TestConfigureOwnerDeps(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			{Name: "clone"},
+			{Name: ownerStepName},
+			{Name: "build", DependsOn: []string{"clone"}},
+			{Name: "test", DependsOn: []string{"build"}},
+		},
+	}
+	configureOwnerDeps(spec)
+	if got := spec.Steps[1].DependsOn; len(got) != 1 || got[0] != "clone" {
+		t.Errorf("Expect owner step depends on clone, got %v", got)
+	}
+	if got := spec.Steps[2].DependsOn; len(got) != 1 || got[0] != ownerStepName {
+		t.Errorf("Expect build step depends on owner step, got %v", got)
+	}
+	if got := spec.Steps[3].DependsOn; len(got) != 1 || got[0] != "build" {
+		t.Errorf("Expect test step dependencies unchanged, got %v", got)
+	}
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	args := Args{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{Event: drone.EventPush, Target: "master"},
+		Stage: &drone.Stage{Name: "default"},
+	}
+	a := createSnapshot(args, "csi-snapclass")
+	if !a.Create {
+		t.Errorf("Expect snapshot created for push events")
+	}
+	if got, want := a.Class, "csi-snapclass"; got != want {
+		t.Errorf("Want snapshot class %q, got %q", want, got)
+	}
+	if got, want := len(a.Key), 40; got != want {
+		t.Errorf("Want snapshot key length %d, got %d", want, got)
+	}
+
+	args.Build = &drone.Build{Event: drone.EventPullRequest, Target: "master"}
+	b := createSnapshot(args, "")
+	if b.Create {
+		t.Errorf("Expect snapshot not created for pull request events")
+	}
+	if a.Key != b.Key {
+		t.Errorf("Expect pull request restored from target branch snapshot")
+	}
+
+	args.Build = &drone.Build{Event: drone.EventPush, Target: "develop"}
+	if c := createSnapshot(args, ""); c.Key == a.Key {
+		t.Errorf("Expect unique snapshot key per branch")
+	}
+}
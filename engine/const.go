@@ -16,6 +16,11 @@ var statusesWhiteList = []string{
 	"DRONE_STAGE_FINISHED",
 }
 
+// ExitCodeSkipped is the exit code of a step that is skipped
+// at runtime, because none of the files changed by the build
+// match the step path conditions.
+const ExitCodeSkipped = 80
+
 // PullPolicy defines the container image pull policy.
 type PullPolicy int
 
@@ -123,3 +128,54 @@ func (r *RunPolicy) UnmarshalJSON(b []byte) error {
 	*r = runPolicyName[s]
 	return nil
 }
+
+// ScanPolicy defines the policy for secrets detected in
+// the step output that are not masked.
+type ScanPolicy int
+
+// ScanPolicy enumeration.
+const (
+	ScanNone ScanPolicy = iota
+	ScanWarn
+	ScanFail
+)
+
+func (s ScanPolicy) String() string {
+	return scanPolicyID[s]
+}
+
+var scanPolicyID = map[ScanPolicy]string{
+	ScanNone: "none",
+	ScanWarn: "warn",
+	ScanFail: "fail",
+}
+
+var scanPolicyName = map[string]ScanPolicy{
+	"":     ScanNone,
+	"none": ScanNone,
+	"warn": ScanWarn,
+	"fail": ScanFail,
+}
+
+// MarshalJSON marshals the string representation of the
+// scan type to JSON.
+func (s *ScanPolicy) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString(`"`)
+	buffer.WriteString(scanPolicyID[*s])
+	buffer.WriteString(`"`)
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalJSON unmarshals the json representation of the
+// scan type from a string value.
+func (s *ScanPolicy) UnmarshalJSON(b []byte) error {
+	// unmarshal as string
+	var v string
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+	// lookup value
+	*s = scanPolicyName[v]
+	return nil
+}
@@ -235,3 +235,51 @@ func TestPullPolicy_String(t *testing.T) {
 		}
 	}
 }
+
+//
+// scan policy unit tests.
+//
+
+func TestScanPolicy_Unmarshal(t *testing.T) {
+	tests := []struct {
+		policy ScanPolicy
+		data   string
+	}{
+		{
+			policy: ScanWarn,
+			data:   `"warn"`,
+		},
+		{
+			policy: ScanFail,
+			data:   `"fail"`,
+		},
+		{
+			// no policy should default to none
+			policy: ScanNone,
+			data:   `""`,
+		},
+	}
+	for _, test := range tests {
+		var policy ScanPolicy
+		err := json.Unmarshal([]byte(test.data), &policy)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got, want := policy, test.policy; got != want {
+			t.Errorf("Want policy %q, got %q", want, got)
+		}
+	}
+}
+
+func TestScanPolicy_Marshal(t *testing.T) {
+	policy := ScanFail
+	data, err := json.Marshal(&policy)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := string(data), `"fail"`; got != want {
+		t.Errorf("Want policy %s, got %s", want, got)
+	}
+}
@@ -5,7 +5,9 @@
 package engine
 
 import (
+	"encoding/base64"
 	"strings"
+	"time"
 	"unicode"
 
 	v1 "k8s.io/api/core/v1"
@@ -13,27 +15,70 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// name of the volume used to mount step scripts.
+const scriptVolumeName = "drone-scripts"
+
+// command used to terminate the step processes. The signal
+// is sent to every process in the container except the init
+// process and the calling shell.
+const interruptCommand = "kill -TERM -1"
+
+// command executed to kill the step processes that do not exit
+// when interrupted.
+const killCommand = "kill -KILL -1"
+
+// command executed by the step container pre-stop hook. The
+// hook waits for the exec stream to deliver the remaining
+// step output before the container is stopped.
+const preStopCommand = interruptCommand + "; sleep 5"
+
+// DefaultLabelPrefix is the default prefix of the labels used
+// to identify objects created by the runner.
+const DefaultLabelPrefix = "io.drone"
+
+// helper function returns the label prefix of the pipeline.
+func labelPrefix(spec *Spec) string {
+	if spec.PodSpec.LabelPrefix != "" {
+		return spec.PodSpec.LabelPrefix
+	}
+	return DefaultLabelPrefix
+}
+
+// helper function returns the name of the label used to
+// identify objects that belong to the pipeline.
+func labelName(prefix string) string {
+	return prefix + ".name"
+}
+
 func toPod(spec *Spec) *v1.Pod {
 	return &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        spec.PodSpec.Name,
 			Namespace:   spec.PodSpec.Namespace,
-			Annotations: spec.PodSpec.Annotations,
-			Labels:      spec.PodSpec.Labels,
+			Annotations: toPodAnnotations(spec),
+			Labels:      toPodLabels(spec),
+			Finalizers:  spec.PodSpec.Finalizers,
 		},
 		Spec: v1.PodSpec{
-			Affinity:           toAffinity(),
+			Affinity:           toAffinity(spec),
 			ServiceAccountName: spec.PodSpec.ServiceAccountName,
 			RestartPolicy:      v1.RestartPolicyNever,
 			Volumes:            toVolumes(spec),
+			InitContainers:     toInitContainers(spec),
 			Containers:         toContainers(spec),
 			NodeName:           spec.PodSpec.NodeName,
 			NodeSelector:       spec.PodSpec.NodeSelector,
 			Tolerations:        toTolerations(spec),
+			RuntimeClassName:   toRuntimeClassName(spec),
 			ImagePullSecrets:   toImagePullSecrets(spec),
 			HostAliases:        toHostAliases(spec),
 			DNSPolicy:          v1.DNSPolicy(spec.PodSpec.DNS.DNSPolicy),
 			DNSConfig:          toDNSConfig(spec),
+			SecurityContext:    toPodSecurityContext(spec),
+
+			// the service account token is only mounted if the
+			// pipeline requires access to the kubernetes api.
+			AutomountServiceAccountToken: boolptr(spec.PodSpec.AutomountToken),
 		},
 	}
 }
@@ -79,6 +124,7 @@ func toTolerations(spec *Spec) []v1.Toleration {
 	var tolerations []v1.Toleration
 	for _, toleration := range spec.PodSpec.Tolerations {
 		t := v1.Toleration{
+			Key:      toleration.Key,
 			Operator: v1.TolerationOperator(toleration.Operator),
 			Effect:   v1.TaintEffect(toleration.Effect),
 			Value:    toleration.Value,
@@ -91,8 +137,18 @@ func toTolerations(spec *Spec) []v1.Toleration {
 	return tolerations
 }
 
-func toAffinity() *v1.Affinity {
+// helper function returns the runtime class of the pod, or
+// nil if the pod uses the default runtime class.
+func toRuntimeClassName(spec *Spec) *string {
+	if spec.PodSpec.RuntimeClassName == "" {
+		return nil
+	}
+	return stringptr(spec.PodSpec.RuntimeClassName)
+}
+
+func toAffinity(spec *Spec) *v1.Affinity {
 	return &v1.Affinity{
+		NodeAffinity: toNodeAffinity(spec),
 		PodAntiAffinity: &v1.PodAntiAffinity{
 			PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
 				{
@@ -100,7 +156,7 @@ func toAffinity() *v1.Affinity {
 					PodAffinityTerm: v1.PodAffinityTerm{
 						LabelSelector: &metav1.LabelSelector{
 							MatchLabels: map[string]string{
-								"io.drone": "true",
+								labelPrefix(spec): "true",
 							},
 						},
 						TopologyKey: "kubernetes.io/hostname",
@@ -112,6 +168,59 @@ func toAffinity() *v1.Affinity {
 
 }
 
+// helper function returns the node affinity of the pod, or
+// nil if the pipeline does not define a node affinity. The
+// required node requirements are combined in a single node
+// selector term, so that all requirements must be met.
+func toNodeAffinity(spec *Spec) *v1.NodeAffinity {
+	affinity := spec.PodSpec.Affinity
+	if affinity == nil || (len(affinity.Required) == 0 && len(affinity.Preferred) == 0) {
+		return nil
+	}
+	dst := new(v1.NodeAffinity)
+	if len(affinity.Required) != 0 {
+		term := v1.NodeSelectorTerm{}
+		for _, req := range affinity.Required {
+			term.MatchExpressions = append(term.MatchExpressions, toNodeRequirement(req))
+		}
+		dst.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{term},
+		}
+	}
+	for _, req := range affinity.Preferred {
+		weight := req.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		dst.PreferredDuringSchedulingIgnoredDuringExecution = append(
+			dst.PreferredDuringSchedulingIgnoredDuringExecution,
+			v1.PreferredSchedulingTerm{
+				Weight: int32(weight),
+				Preference: v1.NodeSelectorTerm{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						toNodeRequirement(req),
+					},
+				},
+			},
+		)
+	}
+	return dst
+}
+
+// helper function returns the node selector requirement,
+// defaulting to the In operator.
+func toNodeRequirement(req NodeRequirement) v1.NodeSelectorRequirement {
+	operator := v1.NodeSelectorOperator(req.Operator)
+	if operator == "" {
+		operator = v1.NodeSelectorOpIn
+	}
+	return v1.NodeSelectorRequirement{
+		Key:      req.Key,
+		Operator: operator,
+		Values:   req.Values,
+	}
+}
+
 func toVolumes(spec *Spec) []v1.Volume {
 	var volumes []v1.Volume
 	for _, v := range spec.Volumes {
@@ -169,39 +278,237 @@ func toVolumes(spec *Spec) []v1.Volume {
 
 			volumes = append(volumes, volume)
 		}
+
+		if v.Secret != nil {
+			volume := v1.Volume{
+				Name: v.Secret.ID,
+				VolumeSource: v1.VolumeSource{
+					Secret: &v1.SecretVolumeSource{
+						SecretName: v.Secret.SecretName,
+						Items:      toKeyToPath(v.Secret.Items),
+					},
+				},
+			}
+			volumes = append(volumes, volume)
+		}
+
+		if v.NFS != nil {
+			volume := v1.Volume{
+				Name: v.NFS.ID,
+				VolumeSource: v1.VolumeSource{
+					NFS: &v1.NFSVolumeSource{
+						Server:   v.NFS.Server,
+						Path:     v.NFS.Path,
+						ReadOnly: v.NFS.ReadOnly,
+					},
+				},
+			}
+			volumes = append(volumes, volume)
+		}
+
+		if v.CSI != nil {
+			source := &v1.CSIVolumeSource{
+				Driver:           v.CSI.Driver,
+				ReadOnly:         boolptr(v.CSI.ReadOnly),
+				VolumeAttributes: v.CSI.Attributes,
+			}
+			if v.CSI.FSType != "" {
+				source.FSType = stringptr(v.CSI.FSType)
+			}
+			volume := v1.Volume{
+				Name: v.CSI.ID,
+				VolumeSource: v1.VolumeSource{
+					CSI: source,
+				},
+			}
+			volumes = append(volumes, volume)
+		}
+
+		if v.Projected != nil {
+			volume := v1.Volume{
+				Name: v.Projected.ID,
+				VolumeSource: v1.VolumeSource{
+					Projected: &v1.ProjectedVolumeSource{
+						Sources: toProjections(v.Projected),
+					},
+				},
+			}
+			volumes = append(volumes, volume)
+		}
+
+		if v.Claim != nil {
+			volume := v1.Volume{
+				Name: v.Claim.ID,
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+						ClaimName: v.Claim.ClaimName,
+						ReadOnly:  v.Claim.ReadOnly,
+					},
+				},
+			}
+			volumes = append(volumes, volume)
+		}
+
+		if v.ConfigMap != nil {
+			volume := v1.Volume{
+				Name: v.ConfigMap.ID,
+				VolumeSource: v1.VolumeSource{
+					ConfigMap: &v1.ConfigMapVolumeSource{
+						LocalObjectReference: v1.LocalObjectReference{
+							Name: v.ConfigMap.ConfigMapName,
+						},
+						Items: toKeyToPath(v.ConfigMap.Items),
+					},
+				},
+			}
+			volumes = append(volumes, volume)
+		}
+	}
+
+	// step scripts that are too large to pass as environment
+	// variables are sourced from the pipeline secret and
+	// mounted as files.
+	if items := toScriptItems(spec); len(items) != 0 {
+		volumes = append(volumes, v1.Volume{
+			Name: scriptVolumeName,
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: spec.PodSpec.Name,
+					Items:      items,
+				},
+			},
+		})
 	}
 
 	return volumes
 }
 
+func toScriptItems(spec *Spec) []v1.KeyToPath {
+	var items []v1.KeyToPath
+	// the secrets mounted as files may be used by multiple
+	// steps, and the item paths must be unique.
+	seen := map[string]bool{}
+	add := func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		items = append(items, v1.KeyToPath{
+			Key:  name,
+			Path: name,
+		})
+	}
+	for _, s := range spec.Steps {
+		if s.ScriptFile != "" {
+			add(s.ScriptFile)
+		}
+		for _, name := range s.ValueFiles {
+			add(name)
+		}
+		for _, file := range s.SecretFiles {
+			add(file.Name)
+		}
+	}
+	return items
+}
+
 func toContainers(spec *Spec) []v1.Container {
 	var containers []v1.Container
-
 	for _, s := range spec.Steps {
-		container := v1.Container{
-			Name:            s.ID,
-			Image:           s.Image,
-			Command:         s.Entrypoint,
-			Args:            s.Command,
-			ImagePullPolicy: toPullPolicy(s.Pull),
-			WorkingDir:      s.WorkingDir,
-			Resources:       toResources(s.Resources),
-			SecurityContext: &v1.SecurityContext{
-				Privileged: boolptr(s.Privileged),
-			},
-			VolumeMounts: toVolumeMounts(spec, s),
-			Env:          toEnv(spec, s),
+		// node steps are executed by the node helper pod
+		// and do not have a container in the pipeline pod.
+		if s.Node {
+			continue
 		}
-
+		container := toContainer(spec, s)
+		container.Lifecycle = toLifecycle(s)
 		containers = append(containers, container)
 	}
+	for _, s := range spec.Sidecars {
+		containers = append(containers, toSidecarContainer(spec, s))
+	}
+	return containers
+}
 
+func toSidecarContainer(spec *Spec, s *Sidecar) v1.Container {
+	var envs []v1.EnvVar
+	for k, v := range s.Envs {
+		envs = append(envs, v1.EnvVar{
+			Name:  k,
+			Value: v,
+		})
+	}
+	return v1.Container{
+		Name:            s.Name,
+		Image:           s.Image,
+		Command:         s.Entrypoint,
+		Args:            s.Command,
+		Resources:       toResources(s.Resources),
+		SecurityContext: toContainerSecurityContext(spec, s.Privileged, nil, nil),
+		Env:             envs,
+		ReadinessProbe:  toProbe(s.Readiness),
+	}
+}
+
+// helper function returns the step container lifecycle. The
+// pre-stop hook runs when the pod is deleted with a grace
+// period, for example by the cleanup controller or when the
+// node is drained.
+func toLifecycle(step *Step) *v1.Lifecycle {
+	return &v1.Lifecycle{
+		PreStop: &v1.Handler{
+			Exec: &v1.ExecAction{
+				Command: toShellCommand(step, preStopCommand),
+			},
+		},
+	}
+}
+
+func toInitContainers(spec *Spec) []v1.Container {
+	var containers []v1.Container
+	for _, s := range spec.Init {
+		containers = append(containers, toContainer(spec, s))
+	}
 	return containers
 }
 
+func toContainer(spec *Spec, s *Step) v1.Container {
+	return v1.Container{
+		Name:            s.ID,
+		Image:           s.Image,
+		Command:         s.Entrypoint,
+		Args:            s.Command,
+		ImagePullPolicy: toPullPolicy(s.Pull),
+		WorkingDir:      s.WorkingDir,
+		Resources:       toResources(s.Resources),
+		SecurityContext: toSecurityContext(spec, s),
+		VolumeMounts:    toVolumeMounts(spec, s),
+		Env:             toEnv(spec, s),
+		ReadinessProbe:  toReadinessProbe(s),
+	}
+}
+
+func toSecurityContext(spec *Spec, step *Step) *v1.SecurityContext {
+	uid, gid := ParseUser(step.User)
+	return toContainerSecurityContext(spec, step.Privileged, uid, gid)
+}
+
 func toEnv(spec *Spec, step *Step) []v1.EnvVar {
 	var envVars []v1.EnvVar
 
+	// the node address is defined first, so that the step
+	// environment can reference it as $(DRONE_NODE_IP).
+	if spec.PodSpec.NodeAddress {
+		envVars = append(envVars, v1.EnvVar{
+			Name: "DRONE_NODE_IP",
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: "status.hostIP",
+				},
+			},
+		})
+	}
+
 	for k, v := range step.Envs {
 		for _, r := range v {
 			if unicode.Is(unicode.Scripts["Han"], r) {
@@ -217,6 +524,11 @@ func toEnv(spec *Spec, step *Step) []v1.EnvVar {
 	}
 
 	for _, secret := range step.Secrets {
+		// encrypted secrets are injected by the runner when
+		// the step is executed.
+		if s, ok := spec.Secrets[secret.Name]; ok && s.Encrypted {
+			continue
+		}
 		envVars = append(envVars, v1.EnvVar{
 			Name: secret.Env,
 			ValueFrom: &v1.EnvVarSource{
@@ -231,6 +543,8 @@ func toEnv(spec *Spec, step *Step) []v1.EnvVar {
 		})
 	}
 
+	envVars = append(envVars, toEnvRefs(step)...)
+
 	envVars = append(envVars, v1.EnvVar{
 		Name: "KUBERNETES_NODE",
 		ValueFrom: &v1.EnvVarSource{
@@ -258,23 +572,120 @@ func toEnvFrom(step *Step) []v1.EnvFromSource {
 
 func toSecret(spec *Spec) *v1.Secret {
 	stringData := make(map[string]string)
+	data := make(map[string][]byte)
 	for _, secret := range spec.Secrets {
+		// local secrets are only used by the runner and
+		// are never exposed to the pipeline.
+		if secret.Local {
+			continue
+		}
+		// binary secrets are decoded, so that the data is
+		// not corrupted by the conversion to a string.
+		if secret.Binary {
+			if decoded, err := base64.StdEncoding.DecodeString(secret.Data); err == nil {
+				data[secret.Name] = decoded
+				continue
+			}
+		}
 		stringData[secret.Name] = secret.Data
 	}
 
-	return &v1.Secret{
+	out := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: spec.PodSpec.Name,
+			Name:   spec.PodSpec.Name,
+			Labels: toOwnerLabels(spec),
 		},
 		Type:       "Opaque",
 		StringData: stringData,
 	}
+	if len(data) != 0 {
+		out.Data = data
+	}
+	return out
+}
+
+// helper function returns the labels that identify a
+// resource as belonging to the pipeline.
+func toOwnerLabels(spec *Spec) map[string]string {
+	return map[string]string{
+		labelName(labelPrefix(spec)): spec.PodSpec.Name,
+	}
+}
+
+// helper function returns the name of the label used to
+// identify volume claims that are reused by subsequent
+// pipelines, and are not removed with the pipeline.
+func labelKeep(spec *Spec) string {
+	return labelPrefix(spec) + ".keep"
+}
+
+// helper function returns true if the volume claim is reused
+// by subsequent pipelines.
+func isKept(spec *Spec, claim *v1.PersistentVolumeClaim) bool {
+	return claim.Labels[labelKeep(spec)] == "true"
+}
+
+// helper function returns the persistent volume claims that
+// are provisioned for the pipeline.
+func toPersistentVolumeClaims(spec *Spec) []*v1.PersistentVolumeClaim {
+	var claims []*v1.PersistentVolumeClaim
+	for _, v := range spec.Volumes {
+		if v.Claim == nil || v.Claim.Provision == false {
+			continue
+		}
+		mode := v1.ReadWriteOnce
+		if v.Claim.AccessMode != "" {
+			mode = v1.PersistentVolumeAccessMode(v.Claim.AccessMode)
+		}
+		labels := toOwnerLabels(spec)
+		var annotations map[string]string
+		if v.Claim.Keep {
+			labels = map[string]string{labelKeep(spec): "true"}
+		}
+		if v.Claim.Keep && v.Claim.Cache {
+			labels[labelCache(labelPrefix(spec))] = "true"
+			annotations = map[string]string{
+				annotationLastUsed(labelPrefix(spec)): time.Now().UTC().Format(time.RFC3339),
+			}
+		}
+		claim := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        v.Claim.ClaimName,
+				Labels:      labels,
+				Annotations: annotations,
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{mode},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceStorage: *resource.NewQuantity(v.Claim.Size, resource.BinarySI),
+					},
+				},
+			},
+		}
+		if v.Claim.StorageClass != "" {
+			claim.Spec.StorageClassName = stringptr(v.Claim.StorageClass)
+		}
+		claims = append(claims, claim)
+	}
+	return claims
+}
+
+// helper function returns an owner reference to the pod.
+func toOwnerReference(pod *v1.Pod) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
 }
 
 func toDockerConfigSecret(spec *Spec) *v1.Secret {
 	return &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: spec.PullSecret.Name,
+			Name:   spec.PullSecret.Name,
+			Labels: toOwnerLabels(spec),
 		},
 		Type: "kubernetes.io/dockerconfigjson",
 		StringData: map[string]string{
@@ -283,6 +694,9 @@ func toDockerConfigSecret(spec *Spec) *v1.Secret {
 	}
 }
 
+// helper function returns the image pull secrets of the pod.
+// The pull secret created from the registry credentials is
+// merged with the existing pull secrets.
 func toImagePullSecrets(spec *Spec) []v1.LocalObjectReference {
 	var pullSecrets []v1.LocalObjectReference
 	if spec.PullSecret != nil {
@@ -290,9 +704,61 @@ func toImagePullSecrets(spec *Spec) []v1.LocalObjectReference {
 			Name: spec.PullSecret.Name,
 		}}
 	}
+	seen := map[string]bool{}
+	for _, name := range spec.PodSpec.PullSecrets {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		pullSecrets = append(pullSecrets, v1.LocalObjectReference{
+			Name: name,
+		})
+	}
 	return pullSecrets
 }
 
+// helper function returns the projected volume sources.
+func toProjections(src *VolumeProjected) []v1.VolumeProjection {
+	var dst []v1.VolumeProjection
+	for _, s := range src.Sources {
+		var p v1.VolumeProjection
+		if s.Secret != nil {
+			p.Secret = &v1.SecretProjection{
+				LocalObjectReference: v1.LocalObjectReference{Name: s.Secret.Name},
+				Items:                toKeyToPath(s.Secret.Items),
+			}
+		}
+		if s.ConfigMap != nil {
+			p.ConfigMap = &v1.ConfigMapProjection{
+				LocalObjectReference: v1.LocalObjectReference{Name: s.ConfigMap.Name},
+				Items:                toKeyToPath(s.ConfigMap.Items),
+			}
+		}
+		if s.ServiceAccountToken != nil {
+			p.ServiceAccountToken = &v1.ServiceAccountTokenProjection{
+				Audience: s.ServiceAccountToken.Audience,
+				Path:     s.ServiceAccountToken.Path,
+			}
+			if s.ServiceAccountToken.ExpirationSeconds > 0 {
+				p.ServiceAccountToken.ExpirationSeconds = int64ptr(s.ServiceAccountToken.ExpirationSeconds)
+			}
+		}
+		dst = append(dst, p)
+	}
+	return dst
+}
+
+func toKeyToPath(src []VolumeSecretItem) []v1.KeyToPath {
+	var dst []v1.KeyToPath
+	for _, item := range src {
+		dst = append(dst, v1.KeyToPath{
+			Key:  item.Key,
+			Path: item.Path,
+		})
+	}
+	return dst
+}
+
 func toVolumeMounts(spec *Spec, step *Step) []v1.VolumeMount {
 	var volumeMounts []v1.VolumeMount
 	for _, v := range step.Volumes {
@@ -300,9 +766,35 @@ func toVolumeMounts(spec *Spec, step *Step) []v1.VolumeMount {
 		if !ok {
 			continue
 		}
-		volumeMounts = append(volumeMounts, v1.VolumeMount{
+		mount := v1.VolumeMount{
 			Name:      id,
 			MountPath: v.Path,
+			SubPath:   v.SubPath,
+			ReadOnly:  v.ReadOnly,
+		}
+		if v.Propagation != "" {
+			propagation := v1.MountPropagationMode(v.Propagation)
+			mount.MountPropagation = &propagation
+		}
+		volumeMounts = append(volumeMounts, mount)
+	}
+
+	if step.ScriptFile != "" || len(step.ValueFiles) != 0 {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      scriptVolumeName,
+			MountPath: ScriptPath,
+			ReadOnly:  true,
+		})
+	}
+
+	// the secret files are mounted individually at the file
+	// path from the script volume.
+	for _, file := range step.SecretFiles {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      scriptVolumeName,
+			MountPath: file.Path,
+			SubPath:   file.Name,
+			ReadOnly:  true,
 		})
 	}
 
@@ -333,6 +825,15 @@ func toResources(src Resources) v1.ResourceRequirements {
 				src.Requests.CPU, resource.DecimalSI)
 		}
 	}
+	// device plugin resources cannot be overcommitted, and
+	// are only defined as limits.
+	for name, count := range src.Devices {
+		if dst.Limits == nil {
+			dst.Limits = v1.ResourceList{}
+		}
+		dst.Limits[v1.ResourceName(name)] = *resource.NewQuantity(
+			count, resource.DecimalSI)
+	}
 	return dst
 }
 
@@ -351,6 +852,30 @@ func lookupVolumeID(spec *Spec, name string) (string, bool) {
 		if v.DownwardAPI != nil && v.DownwardAPI.Name == name {
 			return v.DownwardAPI.ID, true
 		}
+
+		if v.Secret != nil && v.Secret.Name == name {
+			return v.Secret.ID, true
+		}
+
+		if v.Claim != nil && v.Claim.Name == name {
+			return v.Claim.ID, true
+		}
+
+		if v.ConfigMap != nil && v.ConfigMap.Name == name {
+			return v.ConfigMap.ID, true
+		}
+
+		if v.Projected != nil && v.Projected.Name == name {
+			return v.Projected.ID, true
+		}
+
+		if v.NFS != nil && v.NFS.Name == name {
+			return v.NFS.ID, true
+		}
+
+		if v.CSI != nil && v.CSI.Name == name {
+			return v.CSI.ID, true
+		}
 	}
 
 	return "", false
@@ -369,6 +894,10 @@ func toPullPolicy(policy PullPolicy) v1.PullPolicy {
 	}
 }
 
+func int32ptr(v int32) *int32 {
+	return &v
+}
+
 func int64ptr(v int64) *int64 {
 	return &v
 }
@@ -3,3 +3,292 @@
 // that can be found in the LICENSE file.
 
 package engine
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestToSecret(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{Name: "drone-pod"},
+		Secrets: map[string]*Secret{
+			"password": {Name: "password", Data: "correct-horse", Mask: true},
+			"registry": {Name: "registry", Data: "battery-staple", Mask: true, Local: true},
+		},
+	}
+	secret := toSecret(spec)
+	if got, want := secret.StringData["password"], "correct-horse"; got != want {
+		t.Errorf("Want secret data %q, got %q", want, got)
+	}
+	if _, ok := secret.StringData["registry"]; ok {
+		t.Errorf("Expect local secret excluded from the pipeline secret")
+	}
+}
+
+func TestToSecret_Binary(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{Name: "drone-pod"},
+		Secrets: map[string]*Secret{
+			"keystore.binary": {Name: "keystore.binary", Data: "AAECAw==", Mask: true, Binary: true},
+			"certificate":     {Name: "certificate", Data: "line1\nline2\n", Mask: true},
+		},
+	}
+	secret := toSecret(spec)
+	if got := secret.Data["keystore.binary"]; string(got) != "\x00\x01\x02\x03" {
+		t.Errorf("Want binary secret decoded, got %v", got)
+	}
+	if got, want := secret.StringData["certificate"], "line1\nline2\n"; got != want {
+		t.Errorf("Want multi-line secret preserved, got %q", got)
+	}
+}
+
+func TestToScriptItems_Dedupe(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{ValueFiles: []string{"kubeconfig"}},
+			{SecretFiles: []*SecretFile{{Name: "kubeconfig", Path: "/root/.kube/config"}}},
+		},
+	}
+	if got := toScriptItems(spec); len(got) != 1 {
+		t.Errorf("Want secret shared by steps mounted once, got %d items", len(got))
+	}
+}
+
+func TestToContainers_Lifecycle(t *testing.T) {
+	spec := &Spec{
+		Init:  []*Step{{ID: "drone-init", Image: "alpine"}},
+		Steps: []*Step{{ID: "drone-step", Image: "golang", Shell: "/drone/bin/sh"}},
+	}
+	containers := toContainers(spec)
+	if len(containers) != 1 || containers[0].Lifecycle == nil || containers[0].Lifecycle.PreStop == nil {
+		t.Errorf("Expect pre-stop hook configured for step containers")
+		return
+	}
+	got := containers[0].Lifecycle.PreStop.Exec.Command
+	want := []string{"/drone/bin/sh", "-c", "export PATH=$PATH:/drone/bin; " + preStopCommand}
+	if len(got) != len(want) || got[0] != want[0] || got[2] != want[2] {
+		t.Errorf("Want pre-stop command %q, got %q", want, got)
+	}
+	for _, c := range toInitContainers(spec) {
+		if c.Lifecycle != nil {
+			t.Errorf("Expect no lifecycle hooks for init containers")
+		}
+	}
+}
+
+func TestToImagePullSecrets(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			PullSecrets: []string{"gcr", "ecr", "gcr"},
+		},
+		PullSecret: &Secret{Name: "drone-pull"},
+	}
+	var got []string
+	for _, ref := range toImagePullSecrets(spec) {
+		got = append(got, ref.Name)
+	}
+	want := []string{"drone-pull", "gcr", "ecr"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("Want pull secrets %v, got %v", want, got)
+	}
+}
+
+func TestToResources_Devices(t *testing.T) {
+	res := toResources(Resources{
+		Devices: map[string]int64{"devices.kubevirt.io/kvm": 1},
+	})
+	limit, ok := res.Limits["devices.kubevirt.io/kvm"]
+	if !ok {
+		t.Fatalf("Want kvm device limit")
+	}
+	if got, want := limit.Value(), int64(1); got != want {
+		t.Errorf("Want kvm device limit %d, got %d", want, got)
+	}
+	if res.Requests != nil {
+		t.Errorf("Want no device requests, got %v", res.Requests)
+	}
+}
+
+func TestToPod_AutomountToken(t *testing.T) {
+	spec := &Spec{}
+	pod := toPod(spec)
+	if v := pod.Spec.AutomountServiceAccountToken; v == nil || *v {
+		t.Errorf("Want service account token not mounted by default")
+	}
+	spec.PodSpec.AutomountToken = true
+	pod = toPod(spec)
+	if v := pod.Spec.AutomountServiceAccountToken; v == nil || !*v {
+		t.Errorf("Want service account token mounted")
+	}
+}
+
+func TestToPod_Placement(t *testing.T) {
+	spec := &Spec{}
+	pod := toPod(spec)
+	if pod.Spec.RuntimeClassName != nil {
+		t.Errorf("Want default runtime class")
+	}
+	if pod.Spec.Affinity.NodeAffinity != nil {
+		t.Errorf("Want no node affinity")
+	}
+
+	spec.PodSpec = PodSpec{
+		RuntimeClassName: "gvisor",
+		Tolerations:      []Toleration{{Key: "nvidia.com/gpu", Operator: "Exists"}},
+		Affinity: &Affinity{
+			Required: []NodeRequirement{
+				{Key: "kubernetes.io/arch", Values: []string{"arm64"}},
+				{Key: "pool", Operator: "Exists"},
+			},
+			Preferred: []NodeRequirement{
+				{Key: "zone", Values: []string{"a"}, Weight: 50},
+				{Key: "spot", Operator: "DoesNotExist"},
+			},
+		},
+	}
+	pod = toPod(spec)
+	if v := pod.Spec.RuntimeClassName; v == nil || *v != "gvisor" {
+		t.Errorf("Want runtime class gvisor")
+	}
+	if got, want := pod.Spec.Tolerations[0].Key, "nvidia.com/gpu"; got != want {
+		t.Errorf("Want toleration key %q, got %q", want, got)
+	}
+	affinity := pod.Spec.Affinity.NodeAffinity
+	if affinity == nil {
+		t.Fatalf("Want node affinity")
+	}
+	terms := affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 || len(terms[0].MatchExpressions) != 2 {
+		t.Fatalf("Want required requirements in a single term, got %v", terms)
+	}
+	if got, want := string(terms[0].MatchExpressions[0].Operator), "In"; got != want {
+		t.Errorf("Want default operator %q, got %q", want, got)
+	}
+	preferred := affinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(preferred) != 2 {
+		t.Fatalf("Want 2 preferred terms, got %d", len(preferred))
+	}
+	if got, want := preferred[0].Weight, int32(50); got != want {
+		t.Errorf("Want weight %d, got %d", want, got)
+	}
+	if got, want := preferred[1].Weight, int32(1); got != want {
+		t.Errorf("Want default weight %d, got %d", want, got)
+	}
+	if pod.Spec.Affinity.PodAntiAffinity == nil {
+		t.Errorf("Want pod anti-affinity preserved")
+	}
+}
+
+func TestToVolumes_ConfigMapAndClaim(t *testing.T) {
+	spec := &Spec{
+		Volumes: []*Volume{
+			{
+				ConfigMap: &VolumeConfigMap{
+					ID:            "abc",
+					Name:          "certs",
+					ConfigMapName: "cluster-ca",
+					Items:         []VolumeSecretItem{{Key: "ca.crt", Path: "ca.crt"}},
+				},
+			},
+			{
+				Claim: &VolumeClaim{
+					ID:        "def",
+					Name:      "cache",
+					ClaimName: "go-cache",
+					ReadOnly:  true,
+				},
+			},
+		},
+	}
+	volumes := toVolumes(spec)
+	if len(volumes) != 2 {
+		t.Fatalf("Want 2 volumes, got %d", len(volumes))
+	}
+	if got, want := volumes[0].ConfigMap.Name, "cluster-ca"; got != want {
+		t.Errorf("Want config map %q, got %q", want, got)
+	}
+	if got, want := volumes[0].ConfigMap.Items[0].Key, "ca.crt"; got != want {
+		t.Errorf("Want config map key %q, got %q", want, got)
+	}
+	if claim := volumes[1].PersistentVolumeClaim; claim == nil || claim.ClaimName != "go-cache" || !claim.ReadOnly {
+		t.Errorf("Want read-only claim go-cache, got %v", claim)
+	}
+	if id, _ := lookupVolumeID(spec, "certs"); id != "abc" {
+		t.Errorf("Want volume id abc, got %q", id)
+	}
+	// existing claims are not provisioned.
+	if claims := toPersistentVolumeClaims(spec); len(claims) != 0 {
+		t.Errorf("Want no provisioned claims, got %d", len(claims))
+	}
+}
+
+func TestToEnv_NodeAddress(t *testing.T) {
+	spec := &Spec{PodSpec: PodSpec{NodeAddress: true}}
+	step := &Step{Envs: map[string]string{"GOPROXY": "http://$(DRONE_NODE_IP):3000"}}
+	envs := toEnv(spec, step)
+	if envs[0].Name != "DRONE_NODE_IP" || envs[0].ValueFrom.FieldRef.FieldPath != "status.hostIP" {
+		t.Errorf("Want node address defined before the step environment")
+	}
+}
+
+func TestToSidecarContainer_Readiness(t *testing.T) {
+	container := toSidecarContainer(&Spec{}, &Sidecar{Name: "proxy-go", Readiness: &Readiness{Port: 3000}})
+	if container.ReadinessProbe == nil || container.ReadinessProbe.TCPSocket.Port.IntValue() != 3000 {
+		t.Errorf("Want sidecar readiness probe")
+	}
+	if toSidecarContainer(&Spec{}, &Sidecar{Name: "logger"}).ReadinessProbe != nil {
+		t.Errorf("Want no readiness probe")
+	}
+}
+
+func TestToPod_Sidecars(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{{ID: "step-a", Image: "golang"}},
+		Sidecars: []*Sidecar{
+			{Name: "logger", Image: "fluent/fluent-bit", Envs: map[string]string{"FLUSH": "1"}},
+		},
+	}
+	containers := toPod(spec).Spec.Containers
+	if got, want := len(containers), 2; got != want {
+		t.Fatalf("Want %d containers, got %d", want, got)
+	}
+	sidecar := containers[1]
+	if sidecar.Name != "logger" || sidecar.Image != "fluent/fluent-bit" {
+		t.Errorf("Want sidecar container after the step containers, got %s", sidecar.Name)
+	}
+	if len(sidecar.Env) != 1 || sidecar.Env[0].Name != "FLUSH" {
+		t.Errorf("Want sidecar environment, got %v", sidecar.Env)
+	}
+}
+
+func TestLabelPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "", want: "io.drone"},
+		{prefix: "acme.ci", want: "acme.ci"},
+	}
+	for _, test := range tests {
+		spec := &Spec{PodSpec: PodSpec{Name: "drone-abc", LabelPrefix: test.prefix}}
+		if got := toSecret(spec).Labels[test.want+".name"]; got != "drone-abc" {
+			t.Errorf("Want secret labeled %s.name, got labels %v", test.want, toSecret(spec).Labels)
+		}
+		terms := toPod(spec).Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+		if got := terms[0].PodAffinityTerm.LabelSelector.MatchLabels[test.want]; got != "true" {
+			t.Errorf("Want pod anti-affinity to select label %s", test.want)
+		}
+		if !isOwned(spec, metav1.ObjectMeta{Labels: map[string]string{test.want + ".name": "drone-abc"}}) {
+			t.Errorf("Want object labeled %s.name owned by the pipeline", test.want)
+		}
+	}
+
+	// objects labeled with the default prefix are not owned
+	// by pipelines with a custom prefix.
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-abc", LabelPrefix: "acme.ci"}}
+	if isOwned(spec, metav1.ObjectMeta{Labels: map[string]string{"io.drone.name": "drone-abc"}}) {
+		t.Errorf("Want object labeled with another prefix not owned by the pipeline")
+	}
+}
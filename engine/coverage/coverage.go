@@ -0,0 +1,122 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package coverage parses and merges code coverage reports in
+// lcov and cobertura format.
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// Report provides line coverage merged from one or more
+// coverage files. A line is covered if any file reports a
+// hit for the line.
+type Report struct {
+	files map[string]map[int]bool
+}
+
+// New returns a new, empty coverage report.
+func New() *Report {
+	return &Report{files: map[string]map[int]bool{}}
+}
+
+// Parse parses the coverage file and merges the results into
+// the report. The format is detected from the file contents.
+func (r *Report) Parse(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return r.parseCobertura(data)
+	}
+	return r.parseLcov(data)
+}
+
+// Lines returns the number of covered and total lines.
+func (r *Report) Lines() (covered, total int) {
+	for _, lines := range r.files {
+		for _, hit := range lines {
+			total++
+			if hit {
+				covered++
+			}
+		}
+	}
+	return
+}
+
+// Percent returns the percentage of covered lines.
+func (r *Report) Percent() float64 {
+	covered, total := r.Lines()
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total) * 100
+}
+
+func (r *Report) add(file string, line, hits int) {
+	lines, ok := r.files[file]
+	if !ok {
+		lines = map[int]bool{}
+		r.files[file] = lines
+	}
+	lines[line] = lines[line] || hits > 0
+}
+
+// helper function parses the lcov tracefile format. Only the
+// source file (SF) and line data (DA) records are used.
+func (r *Report) parseLcov(data []byte) error {
+	var file string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			file = strings.TrimPrefix(line, "SF:")
+		case strings.HasPrefix(line, "DA:"):
+			parts := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(parts) < 2 {
+				continue
+			}
+			num, err := strconv.Atoi(parts[0])
+			if err != nil {
+				return err
+			}
+			hits, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return err
+			}
+			r.add(file, num, hits)
+		case line == "end_of_record":
+			file = ""
+		}
+	}
+	return scanner.Err()
+}
+
+type cobertura struct {
+	Classes []struct {
+		Filename string `xml:"filename,attr"`
+		Lines    []struct {
+			Number int `xml:"number,attr"`
+			Hits   int `xml:"hits,attr"`
+		} `xml:"lines>line"`
+	} `xml:"packages>package>classes>class"`
+}
+
+// helper function parses the cobertura xml format.
+func (r *Report) parseCobertura(data []byte) error {
+	report := new(cobertura)
+	if err := xml.Unmarshal(data, report); err != nil {
+		return err
+	}
+	for _, class := range report.Classes {
+		for _, line := range class.Lines {
+			r.add(class.Filename, line.Number, line.Hits)
+		}
+	}
+	return nil
+}
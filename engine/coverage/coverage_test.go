@@ -0,0 +1,66 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package coverage
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		files   []string
+		covered int
+		total   int
+	}{
+		{
+			files:   []string{"testdata/lcov.info"},
+			covered: 2,
+			total:   5,
+		},
+		{
+			files:   []string{"testdata/cobertura.xml"},
+			covered: 2,
+			total:   4,
+		},
+		// lines covered in either report are covered in
+		// the merged report.
+		{
+			files:   []string{"testdata/lcov.info", "testdata/cobertura.xml"},
+			covered: 4,
+			total:   7,
+		},
+	}
+	for _, test := range tests {
+		report := New()
+		for _, file := range test.files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := report.Parse(data); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		covered, total := report.Lines()
+		if covered != test.covered || total != test.total {
+			t.Errorf("Want %d/%d lines covered, got %d/%d", test.covered, test.total, covered, total)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	report := New()
+	if got := report.Percent(); got != 0 {
+		t.Errorf("Want 0%% coverage for empty report, got %v", got)
+	}
+	report.add("main.go", 1, 1)
+	report.add("main.go", 2, 0)
+	if got, want := report.Percent(), 50.0; got != want {
+		t.Errorf("Want %v%% coverage, got %v", want, got)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// checkpointResult is the kubelet checkpoint api response.
+type checkpointResult struct {
+	Items []string `json:"items"`
+}
+
+// CheckpointPod checkpoints the running containers of the
+// pipeline pod with the kubelet checkpoint api, for example
+// before the node is drained. The kubelet writes the archives
+// to the node, and the archive paths are recorded in the pod
+// annotations and returned, keyed by container name, so that
+// the containers can be restored on another node by a runtime
+// that supports restoring checkpoints.
+//
+// Checkpointing is experimental. It requires the kubelet
+// ContainerCheckpoint feature gate and a container runtime
+// with CRIU support, and the exec streams of the running
+// steps are not part of the checkpoint.
+func (k *Kubernetes) CheckpointPod(ctx context.Context, namespace, name, prefix string) (map[string]string, error) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	client := k.client.CoreV1().Pods(namespace)
+	pod, err := client.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if pod.Spec.NodeName == "" {
+		return nil, errors.New("engine: pod is not scheduled")
+	}
+	archives := map[string]string{}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil {
+			continue
+		}
+		raw, err := k.client.CoreV1().RESTClient().Post().
+			Resource("nodes").
+			Name(pod.Spec.NodeName).
+			SubResource("proxy").
+			Suffix("checkpoint", namespace, name, status.Name).
+			DoRaw()
+		if err != nil {
+			return archives, err
+		}
+		result := new(checkpointResult)
+		if err := json.Unmarshal(raw, result); err != nil {
+			return archives, err
+		}
+		if len(result.Items) != 0 {
+			archives[status.Name] = result.Items[0]
+		}
+	}
+
+	value, _ := json.Marshal(archives)
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				annotationContainerCheckpoints(prefix): string(value),
+			},
+		},
+	})
+	err = k.retry(ctx, func() error {
+		_, err := client.Patch(name, types.MergePatchType, patch)
+		return err
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("pod", name).
+			Warnln("cannot record the container checkpoints")
+	}
+	logrus.WithField("pod", name).
+		WithField("node", pod.Spec.NodeName).
+		WithField("containers", len(archives)).
+		Infoln("checkpointed the pipeline containers")
+	return archives, nil
+}
+
+// helper function returns the name of the annotation used to
+// record the container checkpoint archives.
+func annotationContainerCheckpoints(prefix string) string {
+	return prefix + ".container-checkpoints"
+}
+
+// helper function returns the container checkpoint archives
+// recorded in the pod annotations, keyed by container name.
+func parseContainerCheckpoints(pod *v1.Pod, prefix string) map[string]string {
+	archives := map[string]string{}
+	json.Unmarshal([]byte(pod.Annotations[annotationContainerCheckpoints(prefix)]), &archives)
+	return archives
+}
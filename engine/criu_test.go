@@ -0,0 +1,31 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseContainerCheckpoints(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				"io.drone.container-checkpoints": `{"build":"/var/lib/kubelet/checkpoints/checkpoint-drone-abc_default-build.tar"}`,
+			},
+		},
+	}
+	got := toNodePod(pod, DefaultLabelPrefix).Checkpoints
+	if got, want := got["build"], "/var/lib/kubelet/checkpoints/checkpoint-drone-abc_default-build.tar"; got != want {
+		t.Errorf("Want checkpoint archive %q, got %q", want, got)
+	}
+
+	pod.Annotations = nil
+	if got := toNodePod(pod, DefaultLabelPrefix).Checkpoints; got != nil {
+		t.Errorf("Want no checkpoints if the pod was not checkpointed, got %v", got)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	networkingv1client "k8s.io/client-go/kubernetes/typed/networking/v1"
+	policyv1beta1client "k8s.io/client-go/kubernetes/typed/policy/v1beta1"
+	rbacv1client "k8s.io/client-go/kubernetes/typed/rbac/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// Deadlines defines the deadlines of the kubernetes api
+// operations of the pipeline. The operations are also bound
+// to the context of the pipeline, and are cancelled when the
+// pipeline is cancelled.
+type Deadlines struct {
+	// Request provides the deadline of each api request, for
+	// example to create or delete a pipeline resource, and of
+	// the short commands executed in the step containers, for
+	// example to read the step outputs. The steps themselves
+	// are not subject to the deadline.
+	Request time.Duration
+
+	// Watch provides the duration after which the api server
+	// closes a watch, which is then re-established, so that
+	// watches stuck on a broken connection are not waited on
+	// forever.
+	Watch time.Duration
+}
+
+// defaultDeadlines is used if no deadlines are configured.
+var defaultDeadlines = Deadlines{
+	Request: time.Minute,
+	Watch:   5 * time.Minute,
+}
+
+// SetDeadlines sets the deadlines of the kubernetes api
+// operations of the pipeline.
+func (k *Kubernetes) SetDeadlines(deadlines Deadlines) {
+	k.deadlines = &deadlines
+}
+
+// helper function returns the configured deadlines.
+func (k *Kubernetes) getDeadlines() Deadlines {
+	if k.deadlines == nil {
+		return defaultDeadlines
+	}
+	return *k.deadlines
+}
+
+// helper function returns the context of a single api
+// operation, which is cancelled when the parent context is
+// cancelled or the request deadline expires.
+func (k *Kubernetes) deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := k.getDeadlines().Request; d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// helper function calls the api operation with the context of
+// the operation, and retries the operation if it fails with a
+// transient error. Each attempt is subject to the request
+// deadline.
+func (k *Kubernetes) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	return k.retry(ctx, func() error {
+		ctx, cancel := k.deadline(ctx)
+		defer cancel()
+		return fn(ctx)
+	})
+}
+
+// helper function returns the watch timeout, in seconds, for
+// the watch options.
+func (k *Kubernetes) watchTimeout() *int64 {
+	if d := k.getDeadlines().Watch; d > 0 {
+		seconds := int64(d.Seconds())
+		return &seconds
+	}
+	return nil
+}
+
+// helper function returns the core client with the requests
+// bound to the context.
+func (k *Kubernetes) coreV1(ctx context.Context) corev1client.CoreV1Interface {
+	client := k.client.CoreV1()
+	if !hasRESTClient(client.RESTClient()) {
+		return client
+	}
+	return corev1client.New(&contextClient{Interface: client.RESTClient(), ctx: ctx})
+}
+
+// helper function returns the coordination client with the
+// requests bound to the context.
+func (k *Kubernetes) coordinationV1(ctx context.Context) coordinationv1client.CoordinationV1Interface {
+	client := k.client.CoordinationV1()
+	if !hasRESTClient(client.RESTClient()) {
+		return client
+	}
+	return coordinationv1client.New(&contextClient{Interface: client.RESTClient(), ctx: ctx})
+}
+
+// helper function returns the networking client with the
+// requests bound to the context.
+func (k *Kubernetes) networkingV1(ctx context.Context) networkingv1client.NetworkingV1Interface {
+	client := k.client.NetworkingV1()
+	if !hasRESTClient(client.RESTClient()) {
+		return client
+	}
+	return networkingv1client.New(&contextClient{Interface: client.RESTClient(), ctx: ctx})
+}
+
+// helper function returns the policy client with the requests
+// bound to the context.
+func (k *Kubernetes) policyV1beta1(ctx context.Context) policyv1beta1client.PolicyV1beta1Interface {
+	client := k.client.PolicyV1beta1()
+	if !hasRESTClient(client.RESTClient()) {
+		return client
+	}
+	return policyv1beta1client.New(&contextClient{Interface: client.RESTClient(), ctx: ctx})
+}
+
+// helper function returns the rbac client with the requests
+// bound to the context.
+func (k *Kubernetes) rbacV1(ctx context.Context) rbacv1client.RbacV1Interface {
+	client := k.client.RbacV1()
+	if !hasRESTClient(client.RESTClient()) {
+		return client
+	}
+	return rbacv1client.New(&contextClient{Interface: client.RESTClient(), ctx: ctx})
+}
+
+// helper function returns true if the typed client is backed
+// by a rest client. The clients of the fake clientset are not,
+// and are returned without binding the requests to the
+// context.
+func hasRESTClient(client rest.Interface) bool {
+	c, ok := client.(*rest.RESTClient)
+	return client != nil && (!ok || c != nil)
+}
+
+// contextClient binds the requests of the rest client to the
+// context, since the typed clients do not accept a context.
+// The request is aborted, and the watch or stream is closed,
+// once the context is cancelled.
+type contextClient struct {
+	rest.Interface
+	ctx context.Context
+}
+
+func (c *contextClient) Verb(verb string) *rest.Request {
+	return c.Interface.Verb(verb).Context(c.ctx)
+}
+
+func (c *contextClient) Post() *rest.Request {
+	return c.Interface.Post().Context(c.ctx)
+}
+
+func (c *contextClient) Put() *rest.Request {
+	return c.Interface.Put().Context(c.ctx)
+}
+
+func (c *contextClient) Patch(pt types.PatchType) *rest.Request {
+	return c.Interface.Patch(pt).Context(c.ctx)
+}
+
+func (c *contextClient) Get() *rest.Request {
+	return c.Interface.Get().Context(c.ctx)
+}
+
+func (c *contextClient) Delete() *rest.Request {
+	return c.Interface.Delete().Context(c.ctx)
+}
+
+// cancelUpgrader closes the upgraded exec connection once the
+// context is cancelled, since the exec stream does not accept
+// a context, so that the stream returns instead of blocking
+// on a broken connection.
+type cancelUpgrader struct {
+	spdy.Upgrader
+	ctx context.Context
+}
+
+func (u *cancelUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := u.Upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-u.ctx.Done():
+			conn.Close()
+		case <-conn.CloseChan():
+		}
+	}()
+	return conn, nil
+}
+
+// helper function returns a context that is cancelled once
+// the grace period elapsed after the parent context is
+// cancelled, so that streams are given the grace period to
+// complete after the pipeline is cancelled.
+func withGrace(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-graceCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		select {
+		case <-graceCtx.Done():
+		case <-time.After(grace):
+			cancel()
+		}
+	}()
+	return graceCtx, cancel
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+// stubConnection is a stub upgraded connection.
+type stubConnection struct {
+	httpstream.Connection
+	closed chan bool
+}
+
+func (c *stubConnection) Close() error {
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	return nil
+}
+
+func (c *stubConnection) CloseChan() <-chan bool {
+	return c.closed
+}
+
+// stubUpgrader is a stub upgrader that returns the connection.
+type stubUpgrader struct {
+	conn *stubConnection
+}
+
+func (u *stubUpgrader) NewConnection(*http.Response) (httpstream.Connection, error) {
+	return u.conn, nil
+}
+
+func TestCall_Deadline(t *testing.T) {
+	k := new(Kubernetes)
+	k.RetryRequests(RetryPolicy{Attempts: 1})
+	k.SetDeadlines(Deadlines{Request: 10 * time.Millisecond})
+
+	err := k.call(context.Background(), func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("Want operation deadline")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded, got %v", err)
+	}
+}
+
+func TestCall_Cancelled(t *testing.T) {
+	k := new(Kubernetes)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var attempts int
+	err := k.call(ctx, func(ctx context.Context) error {
+		attempts++
+		return ctx.Err()
+	})
+	if err != context.Canceled {
+		t.Errorf("Want context cancelled, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Want cancelled operation not retried, got %d attempts", attempts)
+	}
+}
+
+func TestWatchTimeout(t *testing.T) {
+	k := new(Kubernetes)
+	if got, want := *k.watchTimeout(), int64(300); got != want {
+		t.Errorf("Want default watch timeout %d, got %d", want, got)
+	}
+	k.SetDeadlines(Deadlines{})
+	if k.watchTimeout() != nil {
+		t.Errorf("Want no watch timeout")
+	}
+}
+
+func TestCancelUpgrader(t *testing.T) {
+	conn := &stubConnection{closed: make(chan bool)}
+	ctx, cancel := context.WithCancel(context.Background())
+	upgrader := &cancelUpgrader{Upgrader: &stubUpgrader{conn: conn}, ctx: ctx}
+	if _, err := upgrader.NewConnection(nil); err != nil {
+		t.Error(err)
+		return
+	}
+	cancel()
+	select {
+	case <-conn.closed:
+	case <-time.After(time.Second):
+		t.Errorf("Want exec connection closed when the context is cancelled")
+	}
+}
+
+func TestWithGrace(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := withGrace(parent, 100*time.Millisecond)
+	defer cancel()
+
+	cancelParent()
+	select {
+	case <-ctx.Done():
+		t.Errorf("Want context not cancelled before the grace period")
+	default:
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("Want context cancelled after the grace period")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// the interval at which a step waiting for an identical step
+// checks for the result.
+var dedupeInterval = 5 * time.Second
+
+// helper function executes the step once for all pipelines
+// with an identical step, for example the cells of a matrix
+// build. The first pipeline to claim the step executes it and
+// records the result in a config map owned by its pod. Other
+// pipelines wait for the result and reuse the exit code. If
+// the pod that executes the step is deleted before the result
+// is recorded, the config map is garbage collected and a
+// waiting pipeline executes the step instead.
+//
+// Only the exit code is shared, so deduplication is limited to
+// steps without side effects: the files the step writes to the
+// workspace are not copied to the waiting pipelines. The config
+// map is created in the pipeline namespace, so pipelines in
+// isolated namespaces are not deduplicated.
+func (k *Kubernetes) runOnce(ctx context.Context, spec *Spec, step *Step, output io.Writer, run func() (*State, error)) (*State, error) {
+	namespace := spec.PodSpec.Namespace
+	name := dedupeName(step)
+
+	var waiting bool
+	for {
+		var pod *v1.Pod
+		err := k.call(ctx, func(ctx context.Context) (err error) {
+			pod, err = k.coreV1(ctx).Pods(namespace).Get(spec.PodSpec.Name, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		// the claim is not retried, since a retry of a claim
+		// that was created, but whose response was lost, would
+		// wait on its own result.
+		createCtx, cancel := k.deadline(ctx)
+		cm, err := k.coreV1(createCtx).ConfigMaps(namespace).Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Labels:          toOwnerLabels(spec),
+				OwnerReferences: []metav1.OwnerReference{toOwnerReference(pod)},
+			},
+			Data: map[string]string{
+				"pod": spec.PodSpec.Name,
+			},
+		})
+		cancel()
+		if err == nil {
+			state, err := run()
+			if err != nil {
+				// the claim is released so that a waiting
+				// pipeline can execute the step. The claim
+				// is released even if the pipeline is
+				// cancelled.
+				ctx, cancel := k.deadline(context.Background())
+				k.coreV1(ctx).ConfigMaps(namespace).Delete(name, &metav1.DeleteOptions{})
+				cancel()
+				return nil, err
+			}
+			cm.Data["exit_code"] = strconv.Itoa(state.ExitCode)
+			cm.Data["oom_killed"] = strconv.FormatBool(state.OOMKilled)
+			err = k.call(ctx, func(ctx context.Context) error {
+				_, err := k.coreV1(ctx).ConfigMaps(namespace).Update(cm)
+				return err
+			})
+			if err != nil {
+				logrus.WithError(err).
+					WithField("pod", spec.PodSpec.Name).
+					WithField("step", step.Name).
+					Warnln("cannot record the step result")
+			}
+			return state, nil
+		}
+		if !kerrors.IsAlreadyExists(err) {
+			return nil, err
+		}
+
+		for {
+			var cm *v1.ConfigMap
+			err := k.call(ctx, func(ctx context.Context) (err error) {
+				cm, err = k.coreV1(ctx).ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+				return err
+			})
+			if kerrors.IsNotFound(err) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if state, ok := toDedupeState(cm); ok {
+				fmt.Fprintf(output, "reusing the result of the identical step in pod %s\n", cm.Data["pod"])
+				return state, nil
+			}
+			if !waiting {
+				waiting = true
+				fmt.Fprintf(output, "waiting on the identical step in pod %s\n", cm.Data["pod"])
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(dedupeInterval):
+			}
+		}
+	}
+}
+
+// helper function returns the name of the config map that
+// records the result of the step.
+func dedupeName(step *Step) string {
+	key := step.Dedupe
+	if len(key) > 40 {
+		key = key[:40]
+	}
+	return "drone-dedupe-" + key
+}
+
+// helper function returns the step state recorded in the config
+// map, and false if the step has not completed.
+func toDedupeState(cm *v1.ConfigMap) (*State, bool) {
+	code, ok := cm.Data["exit_code"]
+	if !ok {
+		return nil, false
+	}
+	exitCode, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, false
+	}
+	return &State{
+		ExitCode:  exitCode,
+		Exited:    true,
+		OOMKilled: cm.Data["oom_killed"] == "true",
+	}, true
+}
@@ -0,0 +1,36 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestDedupeName(t *testing.T) {
+	step := &Step{Dedupe: strings.Repeat("a", 64)}
+	if got, want := dedupeName(step), "drone-dedupe-"+strings.Repeat("a", 40); got != want {
+		t.Errorf("Want config map name %s, got %s", want, got)
+	}
+}
+
+func TestToDedupeState(t *testing.T) {
+	cm := &v1.ConfigMap{Data: map[string]string{"pod": "drone-abc"}}
+	if _, ok := toDedupeState(cm); ok {
+		t.Errorf("Expect no state before the step completes")
+	}
+	cm.Data["exit_code"] = "2"
+	cm.Data["oom_killed"] = "true"
+	state, ok := toDedupeState(cm)
+	if !ok {
+		t.Errorf("Expect state after the step completes")
+		return
+	}
+	if state.ExitCode != 2 || !state.OOMKilled || !state.Exited {
+		t.Errorf("Unexpected step state %+v", state)
+	}
+}
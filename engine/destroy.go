@@ -0,0 +1,55 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// maximum number of pipeline resources that are deleted
+// concurrently when the pipeline is destroyed.
+var destroyParallelism = 4
+
+// helper function calls the delete functions concurrently,
+// with bounded parallelism, and returns the combined errors
+// once all functions return.
+func deleteParallel(deletes []func() error) error {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		result error
+	)
+	slots := make(chan struct{}, destroyParallelism)
+	for _, fn := range deletes {
+		fn := fn
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := fn(); err != nil {
+				mu.Lock()
+				result = multierror.Append(result, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+// helper function returns nil if the resource was not found.
+func ignoreNotFound(err error) error {
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeleteParallel(t *testing.T) {
+	var running, peak, calls int32
+	var deletes []func() error
+	for i := 0; i < 10; i++ {
+		i := i
+		deletes = append(deletes, func() error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&calls, 1)
+			if i%5 == 0 {
+				return errors.New("delete failed")
+			}
+			return nil
+		})
+	}
+
+	err := deleteParallel(deletes)
+	if calls != 10 {
+		t.Errorf("Want all resources deleted, got %d", calls)
+	}
+	if peak > int32(destroyParallelism) {
+		t.Errorf("Want at most %d concurrent deletes, got %d", destroyParallelism, peak)
+	}
+	if peak < 2 {
+		t.Errorf("Want concurrent deletes")
+	}
+	if merr, ok := err.(*multierror.Error); !ok || len(merr.Errors) != 2 {
+		t.Errorf("Want both errors returned, got %v", err)
+	}
+}
+
+func TestDestroy_Claims(t *testing.T) {
+	tests := []struct {
+		name string
+		keep bool
+		want int
+	}{
+		{name: "provisioned", keep: false, want: 0},
+		{name: "kept", keep: true, want: 1},
+	}
+	for _, test := range tests {
+		spec := testSetupSpec()
+		spec.Volumes[0].Claim.Keep = test.keep
+		claim := toPersistentVolumeClaims(spec)[0]
+		claim.Namespace = "default"
+		client := fake.NewSimpleClientset(toPod(spec), claim)
+		k := &Kubernetes{client: client}
+
+		if err := k.Destroy(context.Background(), spec); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		claims, _ := client.CoreV1().PersistentVolumeClaims("default").List(metav1.ListOptions{})
+		if got := len(claims.Items); got != test.want {
+			t.Errorf("%s: Want %d volume claims after destroy, got %d", test.name, test.want, got)
+		}
+	}
+}
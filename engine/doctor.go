@@ -0,0 +1,126 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+
+	authv1 "k8s.io/api/authorization/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Diagnostic describes the result of an environment check.
+type Diagnostic struct {
+	Name    string
+	Passed  bool
+	Warning bool
+	Message string
+	Fix     string
+}
+
+// list of permissions required to execute pipelines.
+var permissions = []authv1.ResourceAttributes{
+	{Verb: "create", Resource: "pods"},
+	{Verb: "get", Resource: "pods"},
+	{Verb: "watch", Resource: "pods"},
+	{Verb: "list", Resource: "pods"},
+	{Verb: "update", Resource: "pods"},
+	{Verb: "patch", Resource: "pods"},
+	{Verb: "delete", Resource: "pods"},
+	{Verb: "create", Resource: "pods", Subresource: "exec"},
+	{Verb: "get", Resource: "pods", Subresource: "log"},
+	{Verb: "create", Resource: "secrets"},
+	{Verb: "get", Resource: "secrets"},
+	{Verb: "update", Resource: "secrets"},
+	{Verb: "delete", Resource: "secrets"},
+	{Verb: "create", Resource: "configmaps"},
+	{Verb: "get", Resource: "configmaps"},
+	{Verb: "update", Resource: "configmaps"},
+	{Verb: "delete", Resource: "configmaps"},
+	{Verb: "create", Resource: "leases", Group: "coordination.k8s.io"},
+	{Verb: "get", Resource: "leases", Group: "coordination.k8s.io"},
+	{Verb: "update", Resource: "leases", Group: "coordination.k8s.io"},
+	{Verb: "delete", Resource: "leases", Group: "coordination.k8s.io"},
+	{Verb: "create", Resource: "networkpolicies", Group: "networking.k8s.io"},
+	{Verb: "delete", Resource: "networkpolicies", Group: "networking.k8s.io"},
+}
+
+// label used by the pod security admission controller to
+// enforce the pod security level of a namespace.
+const labelPodSecurity = "pod-security.kubernetes.io/enforce"
+
+// Diagnose checks the runner is able to execute pipelines in
+// the namespace. It verifies the namespace exists, the runner
+// has the required permissions, and the pod security level
+// permits privileged pipeline steps.
+func (k *Kubernetes) Diagnose(namespace string) []*Diagnostic {
+	var results []*Diagnostic
+
+	check := &Diagnostic{Name: fmt.Sprintf("namespace %s exists", namespace), Passed: true}
+	ns, err := k.client.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	switch {
+	case kerrors.IsForbidden(err):
+		// the runner is not required to read namespaces, so
+		// the namespace check is skipped.
+		check.Warning = true
+		check.Message = "cannot read namespace"
+		check.Fix = "grant the runner get access to namespaces to enable this check"
+	case kerrors.IsNotFound(err):
+		check.Passed = false
+		check.Message = "namespace not found"
+		check.Fix = fmt.Sprintf("kubectl create namespace %s", namespace)
+	case err != nil:
+		check.Passed = false
+		check.Message = err.Error()
+		check.Fix = "verify the runner can reach the kubernetes api server"
+	}
+	results = append(results, check)
+
+	for _, attrs := range permissions {
+		attrs.Namespace = namespace
+		results = append(results, k.checkAccess(attrs))
+	}
+
+	if ns != nil && err == nil {
+		check := &Diagnostic{Name: "pod security level permits privileged steps", Passed: true}
+		switch level := ns.Labels[labelPodSecurity]; level {
+		case "baseline", "restricted":
+			check.Warning = true
+			check.Message = fmt.Sprintf("namespace enforces the %s pod security level", level)
+			check.Fix = fmt.Sprintf("kubectl label --overwrite namespace %s %s=privileged", namespace, labelPodSecurity)
+		}
+		results = append(results, check)
+	}
+	return results
+}
+
+// helper function checks the runner is authorized to perform
+// the action.
+func (k *Kubernetes) checkAccess(attrs authv1.ResourceAttributes) *Diagnostic {
+	resource := attrs.Resource
+	if attrs.Subresource != "" {
+		resource = resource + "/" + attrs.Subresource
+	}
+	check := &Diagnostic{Name: fmt.Sprintf("can %s %s", attrs.Verb, resource), Passed: true}
+
+	review, err := k.client.AuthorizationV1().SelfSubjectAccessReviews().Create(&authv1.SelfSubjectAccessReview{
+		Spec: authv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &attrs,
+		},
+	})
+	if err != nil {
+		check.Passed = false
+		check.Message = err.Error()
+		check.Fix = "verify the runner can reach the kubernetes api server"
+		return check
+	}
+	if !review.Status.Allowed {
+		check.Passed = false
+		check.Message = "permission denied"
+		check.Fix = fmt.Sprintf("add a Role rule granting %q on %q in namespace %s and bind it to the runner service account", attrs.Verb, resource, attrs.Namespace)
+	}
+	return check
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	authv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// helper function returns a fake client that denies the
+// listed resources in access reviews.
+func testDoctorClient(denied map[string]bool, objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.SelfSubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		resource := attrs.Resource
+		if attrs.Subresource != "" {
+			resource = resource + "/" + attrs.Subresource
+		}
+		review.Status.Allowed = !denied[attrs.Verb+" "+resource]
+		return true, review, nil
+	})
+	return client
+}
+
+// helper function returns the named diagnostic.
+func findDiagnostic(results []*Diagnostic, name string) *Diagnostic {
+	for _, result := range results {
+		if result.Name == name {
+			return result
+		}
+	}
+	return nil
+}
+
+func TestDiagnose(t *testing.T) {
+	namespace := func(labels map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "drone", Labels: labels}}
+	}
+	forbidden := func(client *fake.Clientset) {
+		client.PrependReactor("get", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, kerrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "drone", nil)
+		})
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		denied  map[string]bool
+		setup   func(*fake.Clientset)
+		check   string
+		passed  bool
+		warning bool
+	}{
+		{
+			name:    "namespace exists",
+			objects: []runtime.Object{namespace(nil)},
+			check:   "namespace drone exists",
+			passed:  true,
+		},
+		{
+			name:   "namespace not found",
+			check:  "namespace drone exists",
+			passed: false,
+		},
+		{
+			name:    "namespace not readable",
+			setup:   forbidden,
+			check:   "namespace drone exists",
+			passed:  true,
+			warning: true,
+		},
+		{
+			name:    "exec denied",
+			objects: []runtime.Object{namespace(nil)},
+			denied:  map[string]bool{"create pods/exec": true},
+			check:   "can create pods/exec",
+			passed:  false,
+		},
+		{
+			name:    "leases allowed",
+			objects: []runtime.Object{namespace(nil)},
+			denied:  map[string]bool{"create pods/exec": true},
+			check:   "can create leases",
+			passed:  true,
+		},
+		{
+			name:    "privileged namespace",
+			objects: []runtime.Object{namespace(map[string]string{labelPodSecurity: "privileged"})},
+			check:   "pod security level permits privileged steps",
+			passed:  true,
+		},
+		{
+			name:    "restricted namespace",
+			objects: []runtime.Object{namespace(map[string]string{labelPodSecurity: "restricted"})},
+			check:   "pod security level permits privileged steps",
+			passed:  true,
+			warning: true,
+		},
+	}
+	for _, test := range tests {
+		client := testDoctorClient(test.denied, test.objects...)
+		if test.setup != nil {
+			test.setup(client)
+		}
+		k := &Kubernetes{client: client}
+
+		result := findDiagnostic(k.Diagnose("drone"), test.check)
+		if result == nil {
+			t.Errorf("%s: Want diagnostic %q", test.name, test.check)
+			continue
+		}
+		if result.Passed != test.passed || result.Warning != test.warning {
+			t.Errorf("%s: Want passed %v warning %v, got passed %v warning %v",
+				test.name, test.passed, test.warning, result.Passed, result.Warning)
+		}
+		if (!result.Passed || result.Warning) && result.Fix == "" {
+			t.Errorf("%s: Want a fix suggested", test.name)
+		}
+	}
+}
+
+func TestDiagnose_NamespaceNotFound(t *testing.T) {
+	// the pod security level is not checked if the namespace
+	// does not exist.
+	k := &Kubernetes{client: testDoctorClient(nil)}
+	results := k.Diagnose("drone")
+	if findDiagnostic(results, "pod security level permits privileged steps") != nil {
+		t.Errorf("Want pod security level not checked")
+	}
+	if got, want := len(results), len(permissions)+1; got != want {
+		t.Errorf("Want %d diagnostics, got %d", want, got)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// helper function returns the name of the annotation used to
+// store the spec hash.
+func annotationHash(spec *Spec) string {
+	return labelPrefix(spec) + ".spec-hash"
+}
+
+// helper function returns a stable hash of the compiled spec.
+// Map keys are sorted when the spec is encoded, so the same
+// spec always produces the same hash. The secret values are
+// excluded, because the hash is visible in the pod annotations.
+func toSpecHash(spec *Spec) string {
+	clone := *spec
+	clone.Secrets = nil
+	clone.PullSecret = nil
+	data, _ := json.Marshal(&clone)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// helper function returns a copy of the pod annotations with
+// the spec hash.
+func withSpecHash(spec *Spec, annotations map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range annotations {
+		out[k] = v
+	}
+	out[annotationHash(spec)] = toSpecHash(spec)
+	return out
+}
+
+// helper function compares the pod returned by the api server
+// to the expected pod, and returns an error describing the
+// differences if the pod was mutated in ways the engine does
+// not support, for example by an admission webhook. Containers,
+// volumes and environment variables that are added to the pod
+// are permitted.
+func checkDrift(spec *Spec, want, got *v1.Pod) error {
+	var diff []string
+	if a, b := want.Annotations[annotationHash(spec)], got.Annotations[annotationHash(spec)]; a != b {
+		diff = append(diff, fmt.Sprintf("annotation %s: want %q, got %q", annotationHash(spec), a, b))
+	}
+	diff = append(diff, diffContainers(want.Spec.InitContainers, got.Spec.InitContainers)...)
+	diff = append(diff, diffContainers(want.Spec.Containers, got.Spec.Containers)...)
+	for _, volume := range want.Spec.Volumes {
+		if !hasVolume(got.Spec.Volumes, volume.Name) {
+			diff = append(diff, fmt.Sprintf("volume %s: missing", volume.Name))
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return &Error{
+		Code: CodePodModified,
+		Err:  fmt.Errorf("engine: pod %s was modified after it was submitted:\n  %s", want.Name, strings.Join(diff, "\n  ")),
+	}
+}
+
+// helper function returns the differences between the expected
+// containers and the containers of the pod.
+func diffContainers(want, got []v1.Container) []string {
+	var diff []string
+	for _, a := range want {
+		b, ok := findContainer(got, a.Name)
+		if !ok {
+			diff = append(diff, fmt.Sprintf("container %s: missing", a.Name))
+			continue
+		}
+		if !equalStrings(a.Command, b.Command) {
+			diff = append(diff, fmt.Sprintf("container %s command: want %q, got %q", a.Name, a.Command, b.Command))
+		}
+		if !equalStrings(a.Args, b.Args) {
+			diff = append(diff, fmt.Sprintf("container %s args: want %q, got %q", a.Name, a.Args, b.Args))
+		}
+		for _, mount := range a.VolumeMounts {
+			if !hasVolumeMount(b.VolumeMounts, mount) {
+				diff = append(diff, fmt.Sprintf("container %s volume mount %s at %s: missing", a.Name, mount.Name, mount.MountPath))
+			}
+		}
+	}
+	return diff
+}
+
+func findContainer(containers []v1.Container, name string) (v1.Container, bool) {
+	for _, c := range containers {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return v1.Container{}, false
+}
+
+func hasVolume(volumes []v1.Volume, name string) bool {
+	for _, v := range volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVolumeMount(mounts []v1.VolumeMount, want v1.VolumeMount) bool {
+	for _, m := range mounts {
+		if m.Name == want.Name && m.MountPath == want.MountPath && m.SubPath == want.SubPath {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the string slices are equal,
+// treating nil and empty slices as equal.
+func equalStrings(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
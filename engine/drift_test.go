@@ -0,0 +1,75 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestSpecHash(t *testing.T) {
+	a := &Spec{
+		PodSpec: PodSpec{Name: "drone-pod"},
+		Steps: []*Step{
+			{ID: "a", Name: "build", Envs: map[string]string{"A": "1", "B": "2", "C": "3"}},
+		},
+		Secrets: map[string]*Secret{"password": {Name: "password", Data: "correct-horse"}},
+	}
+	b := &Spec{
+		PodSpec: PodSpec{Name: "drone-pod"},
+		Steps: []*Step{
+			{ID: "a", Name: "build", Envs: map[string]string{"C": "3", "B": "2", "A": "1"}},
+		},
+		Secrets: map[string]*Secret{"password": {Name: "password", Data: "battery-staple"}},
+	}
+	if toSpecHash(a) != toSpecHash(b) {
+		t.Errorf("Expect the same hash for equal specs with different secret values")
+	}
+	b.Steps[0].Name = "test"
+	if toSpecHash(a) == toSpecHash(b) {
+		t.Errorf("Expect a different hash for different specs")
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{Name: "drone-pod"},
+		Steps: []*Step{
+			{ID: "a", Name: "build", Command: []string{"sleep"}},
+		},
+	}
+	want := toPod(spec)
+	want.Annotations = withSpecHash(spec, want.Annotations)
+	want.Spec.Volumes = []v1.Volume{{Name: "workspace"}}
+	want.Spec.Containers[0].VolumeMounts = []v1.VolumeMount{{Name: "workspace", MountPath: "/drone/src"}}
+
+	// containers added to the pod, for example a service
+	// mesh proxy, are permitted.
+	got := want.DeepCopy()
+	got.Spec.Containers = append(got.Spec.Containers, v1.Container{Name: "istio-proxy"})
+	if err := checkDrift(spec, want, got); err != nil {
+		t.Errorf("Expect added containers are permitted, got %s", err)
+	}
+
+	got = want.DeepCopy()
+	got.Spec.Containers[0].Args = []string{"true"}
+	got.Spec.Containers[0].VolumeMounts = nil
+	got.Spec.Volumes = nil
+	err := checkDrift(spec, want, got)
+	if err == nil {
+		t.Fatalf("Expect drift error")
+	}
+	for _, s := range []string{
+		`container a args: want ["sleep"], got ["true"]`,
+		"container a volume mount workspace at /drone/src: missing",
+		"volume workspace: missing",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("Expect drift error contains %q, got %s", s, err)
+		}
+	}
+}
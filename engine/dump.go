@@ -8,6 +8,8 @@ import (
 	"io"
 
 	"github.com/ghodss/yaml"
+
+	v1 "k8s.io/api/core/v1"
 )
 
 const (
@@ -15,10 +17,32 @@ const (
 	documentEnd   = "...\n"
 )
 
+// redacted replaces secret values in redacted manifests.
+const redacted = "[redacted]"
+
+// sensitiveEnv provides the names of the pipeline environment
+// variables that contain credentials, but are not sourced from
+// pipeline secrets.
+var sensitiveEnv = map[string]bool{
+	"DRONE_NETRC_PASSWORD": true,
+	"DRONE_NETRC_FILE":     true,
+}
+
 // Dump encodes returns specification as a Kubernetes
 // multi-document yaml configuration file, and writes
 // to io.Writer w.
 func Dump(w io.Writer, spec *Spec) {
+	dump(w, spec, false)
+}
+
+// DumpRedacted encodes the specification like Dump, with the
+// secret values redacted, so that the manifests can be shared
+// to debug pipelines that are rejected by the cluster.
+func DumpRedacted(w io.Writer, spec *Spec) {
+	dump(w, spec, true)
+}
+
+func dump(w io.Writer, spec *Spec, redact bool) {
 
 	//
 	// Secret Encoding.
@@ -28,6 +52,9 @@ func Dump(w io.Writer, spec *Spec) {
 		io.WriteString(w, documentBegin)
 		res := toSecret(spec)
 		res.Kind = "Secret"
+		if redact {
+			redactSecret(res)
+		}
 		raw, _ := yaml.Marshal(res)
 		w.Write(raw)
 	}
@@ -40,6 +67,22 @@ func Dump(w io.Writer, spec *Spec) {
 		io.WriteString(w, documentBegin)
 		res := toDockerConfigSecret(spec)
 		res.Kind = "Secret"
+		if redact {
+			redactSecret(res)
+		}
+		raw, _ := yaml.Marshal(res)
+		w.Write(raw)
+	}
+
+	//
+	// Network Policy Encoding.
+	//
+
+	if spec.PodSpec.BlockMetadata {
+		io.WriteString(w, documentBegin)
+		res := toNetworkPolicy(spec)
+		res.Kind = "NetworkPolicy"
+		res.APIVersion = "networking.k8s.io/v1"
 		raw, _ := yaml.Marshal(res)
 		w.Write(raw)
 	}
@@ -50,11 +93,46 @@ func Dump(w io.Writer, spec *Spec) {
 
 	{
 		io.WriteString(w, documentBegin)
-		res := toPod(spec)
+		res, err := toTemplatePod(spec)
+		if err != nil {
+			res = toPod(spec)
+		}
 		res.Kind = "Pod"
+		if redact {
+			redactPod(spec, res)
+		}
 		raw, _ := yaml.Marshal(res)
 		w.Write(raw)
 	}
 
 	io.WriteString(w, documentEnd)
 }
+
+// helper function redacts the secret values.
+func redactSecret(secret *v1.Secret) {
+	for k := range secret.StringData {
+		secret.StringData[k] = redacted
+	}
+	for k := range secret.Data {
+		secret.Data[k] = []byte(redacted)
+	}
+}
+
+// helper function redacts the container environment variables
+// that contain credentials or the value of a masked secret.
+func redactPod(spec *Spec, pod *v1.Pod) {
+	masked := map[string]bool{}
+	for _, secret := range spec.Secrets {
+		if secret.Mask && secret.Data != "" {
+			masked[secret.Data] = true
+		}
+	}
+	containers := append(pod.Spec.InitContainers, pod.Spec.Containers...)
+	for _, container := range containers {
+		for i, env := range container.Env {
+			if env.Value != "" && (sensitiveEnv[env.Name] || masked[env.Value]) {
+				container.Env[i].Value = redacted
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDumpRedacted(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{Name: "drone-pod", Namespace: "default"},
+		Secrets: map[string]*Secret{
+			"password": {Name: "password", Data: "correct-horse", Mask: true},
+		},
+		Steps: []*Step{
+			{
+				ID:    "drone-step",
+				Name:  "build",
+				Image: "golang",
+				Envs: map[string]string{
+					"DRONE_NETRC_PASSWORD": "battery-staple",
+					"PASSWORD_COPY":        "correct-horse",
+					"GOOS":                 "linux",
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	DumpRedacted(&buf, spec)
+	out := buf.String()
+	for _, value := range []string{"correct-horse", "battery-staple"} {
+		if strings.Contains(out, value) {
+			t.Errorf("Want secret value %s redacted", value)
+		}
+	}
+	if !strings.Contains(out, "linux") {
+		t.Errorf("Want environment variables that are not secrets")
+	}
+	if !strings.Contains(out, "kind: Pod") || !strings.Contains(out, "kind: Secret") {
+		t.Errorf("Want the pod and secret manifests")
+	}
+
+	buf.Reset()
+	Dump(&buf, spec)
+	if !strings.Contains(buf.String(), "correct-horse") {
+		t.Errorf("Want secret values if the manifests are not redacted")
+	}
+}
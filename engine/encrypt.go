@@ -0,0 +1,180 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// errInvalidKey is returned when the encryption key is not a
+// 256-bit key.
+var errInvalidKey = errors.New("engine: encryption key must be 32 bytes")
+
+// EncryptSecrets enables envelope encryption of the pipeline
+// secret. Secret values are encrypted with a random data key
+// that is created for each pipeline, and the data key is
+// encrypted with the key held by the runner. Encrypted
+// secrets are not sourced from the pipeline secret by the
+// step containers; the runner injects the plaintext values
+// into the step environment when the step is executed.
+func (k *Kubernetes) EncryptSecrets(key []byte) error {
+	if len(key) != 32 {
+		return errInvalidKey
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	k.kek = aead
+	return nil
+}
+
+// helper function returns the name of the annotation used to
+// store the encrypted data key.
+func annotationKey(spec *Spec) string {
+	return labelPrefix(spec) + ".key"
+}
+
+// helper function marks the pipeline secrets that are
+// encrypted. Only masked secrets are encrypted; metadata,
+// script files and the secrets mounted as files are mounted
+// into the step containers and are stored in plaintext.
+func configureEncryption(spec *Spec) {
+	files := map[string]bool{}
+	for _, step := range spec.Steps {
+		for _, name := range step.ValueFiles {
+			files[name] = true
+		}
+		for _, file := range step.SecretFiles {
+			files[file.Name] = true
+		}
+	}
+	for _, secret := range spec.Secrets {
+		if secret.Mask && !secret.Local && !files[secret.Name] {
+			secret.Encrypted = true
+		}
+	}
+}
+
+// helper function encrypts the values of the encrypted
+// pipeline secrets with a random data key, and stores the
+// data key, encrypted with the runner key, in the secret
+// annotations.
+func encryptSecret(kek cipher.AEAD, spec *Spec, secret *v1.Secret) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	dek, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	for _, s := range spec.Secrets {
+		if !s.Encrypted {
+			continue
+		}
+		data, err := seal(dek, []byte(s.Data))
+		if err != nil {
+			return err
+		}
+		secret.StringData[s.Name] = data
+	}
+	wrapped, err := seal(kek, key)
+	if err != nil {
+		return err
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[annotationKey(spec)] = wrapped
+	return nil
+}
+
+// helper function returns a shell script that exports the
+// encrypted secrets used by the step.
+func toExports(spec *Spec, step *Step) []byte {
+	var names []string
+	values := map[string]string{}
+	for _, s := range step.Secrets {
+		secret, ok := spec.Secrets[s.Name]
+		if !ok || !secret.Encrypted {
+			continue
+		}
+		names = append(names, s.Env)
+		values[s.Env] = secret.Data
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString("export ")
+		buf.WriteString(name)
+		buf.WriteString("=")
+		buf.WriteString(quote(values[name]))
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// helper function returns the value single-quoted for use in
+// a shell script.
+func quote(s string) string {
+	var b bytes.Buffer
+	b.WriteString("'")
+	for _, r := range s {
+		if r == '\'' {
+			b.WriteString(`'\''`)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// helper function returns an AES-GCM cipher for the key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// helper function encrypts the plaintext and returns the
+// base64-encoded nonce and ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	out := aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// helper function decrypts the base64-encoded nonce and
+// ciphertext.
+func unseal(aead cipher.AEAD, data string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	size := aead.NonceSize()
+	if len(raw) < size {
+		return nil, errors.New("engine: malformed ciphertext")
+	}
+	return aead.Open(nil, raw[:size], raw[size:], nil)
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptSecret(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 32)
+	kek, err := newAEAD(key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	spec := &Spec{
+		PodSpec: PodSpec{Name: "drone-pod"},
+		Secrets: map[string]*Secret{
+			"password":  {Name: "password", Data: "correct-horse", Mask: true},
+			"script.sh": {Name: "script.sh", Data: "go build"},
+		},
+	}
+	configureEncryption(spec)
+
+	secret := toSecret(spec)
+	if err := encryptSecret(kek, spec, secret); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := secret.StringData["script.sh"], "go build"; got != want {
+		t.Errorf("Want unmasked secret in plaintext %q, got %q", want, got)
+	}
+
+	wrapped := secret.Annotations["io.drone.key"]
+	dataKey, err := unseal(kek, wrapped)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	plaintext, err := unseal(dek, secret.StringData["password"])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := string(plaintext), "correct-horse"; got != want {
+		t.Errorf("Want decrypted secret %q, got %q", want, got)
+	}
+}
+
+func TestEncryptSecrets_InvalidKey(t *testing.T) {
+	k := new(Kubernetes)
+	if err := k.EncryptSecrets([]byte("short")); err != errInvalidKey {
+		t.Errorf("Want invalid key error, got %v", err)
+	}
+	if err := k.EncryptSecrets(bytes.Repeat([]byte("k"), 32)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestToExports(t *testing.T) {
+	spec := &Spec{
+		Secrets: map[string]*Secret{
+			"password": {Name: "password", Data: "it's", Mask: true, Encrypted: true},
+			"token":    {Name: "token", Data: "plain", Mask: true},
+		},
+	}
+	step := &Step{
+		Secrets: []*SecretVar{
+			{Name: "password", Env: "PASSWORD"},
+			{Name: "token", Env: "TOKEN"},
+		},
+	}
+	got := string(toExports(spec, step))
+	want := "export PASSWORD='it'\\''s'\n"
+	if got != want {
+		t.Errorf("Want exports %q, got %q", want, got)
+	}
+}
@@ -21,3 +21,68 @@ type Engine interface {
 	// Run runs the pipeine step.
 	Run(context.Context, *Spec, *Step, io.Writer) (*State, error)
 }
+
+// Snapshotter is an optional interface that may be implemented
+// by a pipeline execution engine to snapshot the pipeline
+// volumes after the pipeline completes successfully.
+type Snapshotter interface {
+	// Snapshot the pipeline volumes.
+	Snapshot(context.Context, *Spec) error
+}
+
+// Locker is an optional interface that may be implemented by
+// a pipeline execution engine to limit the number of pipelines
+// that execute concurrently, for example in the same
+// concurrency group.
+type Locker interface {
+	// Lock blocks until the pipeline acquires a lock, and
+	// returns a function that releases the lock. The wait
+	// function is invoked with a description of the lock if
+	// the pipeline must wait.
+	Lock(ctx context.Context, spec *Spec, wait func(string)) (func(), error)
+
+	// Relock re-acquires the locks of a pipeline that was
+	// recovered after the runner process restarted, and
+	// returns a function that releases the locks.
+	Relock(ctx context.Context, spec *Spec, wait func(string)) (func(), error)
+}
+
+// Retainer is an optional interface that may be implemented
+// by a pipeline execution engine to retain the pipeline
+// environment of failed pipelines for debugging.
+type Retainer interface {
+	// Retain retains the pipeline environment, and returns
+	// false if the pipeline environment is not retained.
+	Retain(context.Context, *Spec) (bool, error)
+}
+
+// Inventory is an optional interface that may be implemented
+// by a pipeline execution engine to list the container images
+// used by the pipeline, before the pipeline is destroyed.
+type Inventory interface {
+	// Images returns the pipeline container images.
+	Images(context.Context, *Spec) ([]*Image, error)
+}
+
+// Validator is an optional interface that may be implemented
+// by a pipeline execution engine to validate the pipeline
+// before the pipeline environment is created, for example
+// against the cluster admission policies.
+type Validator interface {
+	// Validate returns an error if the pipeline would be
+	// rejected when the pipeline environment is created.
+	Validate(context.Context, *Spec) error
+}
+
+// Recoverer is an optional interface that may be implemented
+// by a pipeline execution engine to re-attach to the pipelines
+// that were running when the runner process exited.
+type Recoverer interface {
+	// Recover returns the recoverable pipelines in the
+	// namespace with the label prefix that match the filter.
+	Recover(ctx context.Context, namespace, prefix string, match func(*Spec) bool) ([]*Recovered, error)
+
+	// Attach re-attaches to the pipeline step, and returns
+	// the step state once the step exits.
+	Attach(context.Context, *Spec, *Step, io.Writer) (*State, error)
+}
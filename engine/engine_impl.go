@@ -5,25 +5,36 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"k8s.io/client-go/util/retry"
 
+	"github.com/drone-runners/drone-runner-kube/engine/coverage"
+	"github.com/drone-runners/drone-runner-kube/engine/junit"
+	"github.com/drone-runners/drone-runner-kube/internal/tunnel"
 	"github.com/drone-runners/drone-runner-kube/nicelog"
 	"k8s.io/client-go/util/exec"
 
 	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -31,8 +42,9 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/remotecommand"
 	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/client-go/transport/spdy"
 
-	"github.com/hashicorp/go-multierror"
+	"golang.org/x/sync/errgroup"
 )
 
 var (
@@ -41,8 +53,78 @@ var (
 
 // Kubernetes implements a Kubernetes pipeline engine.
 type Kubernetes struct {
-	client *kubernetes.Clientset
-	config *rest.Config
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
+	config  *rest.Config
+	kek     cipher.AEAD
+
+	deletes   *throttle
+	quota     *quota
+	execs     *execLimiter
+	retries   *RetryPolicy
+	deadlines *Deadlines
+	retained  *retention
+
+	isolation *isolation
+	admission bool
+	gc        *collector
+	warm      *warmer
+	janitor   *janitor
+	clusters  *clusters
+	pulls     *pulls
+	rotation  *rotation
+	offpeak   *OffPeak
+	chaos     *chaos
+
+	// pipelines whose pod heartbeat is updated by the runner.
+	heartbeats *heartbeats
+
+	// namespace of the concurrency lease objects.
+	lockNamespace string
+
+	// remote is true if the engine runs the pipelines that
+	// provide the kubeconfig of the cluster.
+	remote bool
+
+	mu      sync.Mutex
+	outputs map[string]map[string]string
+
+	// pods observed running, so that the time to running is
+	// recorded once per pod.
+	running sync.Map
+
+	// nodes excluded from scheduling while the nodes are
+	// drained.
+	relocated sync.Map
+
+	// nodes of the cluster, read by the pipeline checks.
+	nodes nodeCache
+
+	// cluster load shared by the off-peak steps.
+	load loadSampler
+
+	observers []LifecycleObserver
+	store     SecretStore
+	robots    RobotProvider
+}
+
+// New returns a new engine for the kubernetes client
+// configuration, for example a configuration built by a tool
+// that embeds the engine.
+func New(config *rest.Config) (*Kubernetes, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicset, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Kubernetes{
+		client:  clientset,
+		dynamic: dynamicset,
+		config:  config,
+	}, nil
 }
 
 // NewFromConfig returns a new out-of-cluster engine.
@@ -52,16 +134,42 @@ func NewFromConfig(path string) (*Kubernetes, error) {
 	if err != nil {
 		return nil, err
 	}
+	return New(config)
+}
 
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
+// NewFromTunnel returns a new out-of-cluster engine that
+// connects to the api server through the tunnel, for example
+// a bastion host or the konnectivity server of a firewalled
+// edge cluster. The api server is exposed on a local address
+// that forwards connections through the tunnel, so that exec
+// and log streams, which do not use a custom dialer, are
+// tunneled as well.
+func NewFromTunnel(path string, dial tunnel.DialFunc) (*Kubernetes, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", path)
 	if err != nil {
 		return nil, err
 	}
-	return &Kubernetes{
-		client: clientset,
-		config: config,
-	}, nil
+	host, err := url.Parse(config.Host)
+	if err != nil {
+		return nil, err
+	}
+	addr := host.Host
+	if host.Port() == "" {
+		addr = net.JoinHostPort(host.Hostname(), "443")
+	}
+	local, err := tunnel.Forward(context.Background(), dial, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the server certificate is verified against the api
+	// server hostname instead of the local address.
+	if config.TLSClientConfig.ServerName == "" {
+		config.TLSClientConfig.ServerName = host.Hostname()
+	}
+	host.Host = local
+	config.Host = host.String()
+	return New(config)
 }
 
 // NewInCluster returns a new in-cluster engine.
@@ -71,87 +179,660 @@ func NewInCluster() (*Kubernetes, error) {
 	if err != nil {
 		return nil, err
 	}
-	// creates the clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-	return &Kubernetes{
-		client: clientset,
-		config: config,
-	}, nil
+	return New(config)
 }
 
 // Setup the pipeline environment.
-func (k *Kubernetes) Setup(ctx context.Context, spec *Spec) error {
-	if spec.PullSecret != nil {
-		_, err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Create(toDockerConfigSecret(spec))
+func (k *Kubernetes) Setup(ctx context.Context, spec *Spec) (err error) {
+	if spec.Cluster != nil && !k.remote {
+		remote, err := k.forCluster(spec)
 		if err != nil {
 			return err
 		}
+		return remote.Setup(ctx, spec)
+	}
+	if err := checkEnv(spec); err != nil {
+		return err
+	}
+
+	// the vault environment variables are resolved before
+	// the pipeline secret is created, so that the values are
+	// encrypted and masked like the pipeline secrets.
+	if err := k.resolveEnvRefs(ctx, spec); err != nil {
+		return toSetupError(err)
+	}
+	if err := checkSecretSize(spec); err != nil {
+		return err
+	}
+
+	// pipelines are not scheduled on nodes that are drained.
+	k.avoidRelocated(spec)
+
+	// pipelines that use a pooled plugin image are preferably
+	// scheduled on the node of a claimed standby pod, where
+	// the image is already pulled.
+	k.claimStandby(spec)
+
+	// pipelines prefer the nodes where fewer pods are pulling
+	// images, and are not created while a registry of the
+	// pipeline images rejects pulls with rate limit errors.
+	k.pulls.avoidBusy(spec)
+	if err := k.pulls.waitRegistries(ctx, spec); err != nil {
+		return err
+	}
+
+	// the pipeline pulls the images of the pooled registries
+	// with the current credential of the registry pool.
+	k.rotation.assign(spec)
+
+	// the pipeline fails with an actionable error if none of
+	// the selected nodes support the pipeline platform, instead
+	// of failing to execute the step binaries.
+	if err := k.checkPlatform(ctx, spec); err != nil {
+		return err
+	}
+
+	// the pipeline fails with an actionable error if none of
+	// the nodes are in the pipeline zone or region, instead of
+	// remaining pending until the pipeline times out.
+	if err := k.checkTopology(ctx, spec); err != nil {
+		return err
+	}
+
+	// the registry credentials are minted before the pipeline
+	// secret is created, and are revoked if the pipeline
+	// resources cannot be created.
+	if err := k.mintRobot(ctx, spec); err != nil {
+		return toSetupError(err)
+	}
+
+	namespace := spec.PodSpec.Namespace
+
+	// the pipeline is tracked until it is destroyed, so that
+	// the garbage collector does not delete the resources of
+	// active pipelines.
+	k.gc.track(spec)
+	k.heartbeats.track(spec)
+
+	// if the pipeline environment cannot be created, the
+	// resources that were successfully created are rolled
+	// back, the namespace of isolated pipelines is deleted,
+	// the registry credentials are revoked, and the pipeline
+	// is no longer tracked. The resources are rolled back
+	// with a new context, since setup may have failed because
+	// the pipeline was cancelled.
+	var (
+		mu       sync.Mutex
+		rollback []func(context.Context) error
+	)
+	created := func(fn func(context.Context) error) {
+		mu.Lock()
+		rollback = append(rollback, fn)
+		mu.Unlock()
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, fn := range rollback {
+			if rerr := fn(context.Background()); rerr != nil {
+				logrus.WithError(rerr).
+					WithField("pod", spec.PodSpec.Name).
+					Warnln("cannot rollback pipeline resource")
+			}
+		}
+		if spec.PodSpec.Isolated {
+			k.rollbackNamespace(spec)
+		}
+		k.revokeRobot(context.Background(), spec)
+		k.gc.untrack(spec)
+		k.heartbeats.untrack(spec)
+	}()
+
+	// the namespace of isolated pipelines is created before
+	// the pipeline resources.
+	if spec.PodSpec.Isolated {
+		if err := k.setupNamespace(ctx, spec); err != nil {
+			return toSetupError(err)
+		}
+	}
+
+	if k.kek != nil {
+		configureEncryption(spec)
+	}
+
+	// the network policy that blocks the metadata endpoints
+	// is created before the pod, so that the pod containers
+	// never have access to the metadata endpoints.
+	if spec.PodSpec.BlockMetadata {
+		err := k.call(ctx, func(ctx context.Context) error {
+			_, err := k.networkingV1(ctx).NetworkPolicies(namespace).Create(toNetworkPolicy(spec))
+			return err
+		})
+		if err != nil && !kerrors.IsAlreadyExists(err) {
+			return toSetupError(err)
+		}
+		if err == nil {
+			created(func(ctx context.Context) error {
+				return k.call(ctx, func(ctx context.Context) error {
+					return k.networkingV1(ctx).NetworkPolicies(namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
+				})
+			})
+		}
 	}
 
-	_, err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Create(toSecret(spec))
+	// the secrets and volume claims are created concurrently,
+	// before the pod.
+	var g errgroup.Group
+	if spec.PullSecret != nil {
+		g.Go(func() error {
+			ok, err := k.createSecret(ctx, spec, toDockerConfigSecret(spec))
+			if ok {
+				created(func(ctx context.Context) error {
+					return k.call(ctx, func(ctx context.Context) error {
+						return k.coreV1(ctx).Secrets(namespace).Delete(spec.PullSecret.Name, &metav1.DeleteOptions{})
+					})
+				})
+			}
+			return err
+		})
+	}
+
+	g.Go(func() error {
+		secret := toSecret(spec)
+		if k.kek != nil {
+			if err := encryptSecret(k.kek, spec, secret); err != nil {
+				return err
+			}
+		}
+		if err := k.storeSpec(spec, secret); err != nil {
+			return err
+		}
+		ok, err := k.createSecret(ctx, spec, secret)
+		if ok {
+			created(func(ctx context.Context) error {
+				return k.call(ctx, func(ctx context.Context) error {
+					return k.coreV1(ctx).Secrets(namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
+				})
+			})
+		}
+		return err
+	})
+
+	for _, claim := range toPersistentVolumeClaims(spec) {
+		claim := claim
+		g.Go(func() error {
+			k.restoreSnapshot(spec, claim)
+			ok, err := k.createClaim(ctx, spec, claim)
+			if ok {
+				created(func(ctx context.Context) error {
+					return k.call(ctx, func(ctx context.Context) error {
+						return k.coreV1(ctx).PersistentVolumeClaims(namespace).Delete(claim.Name, &metav1.DeleteOptions{})
+					})
+				})
+			}
+			return err
+		})
+	}
+
+	err = g.Wait()
+
+	// the pod is created once the secrets and volume claims
+	// exist, so that the containers never start with missing
+	// secret variables, and the image pull secret exists when
+	// the images are pulled.
+	var pod *v1.Pod
+	if err == nil {
+		var ok bool
+		start := time.Now()
+		pod, ok, err = k.createPod(ctx, spec)
+		if err == nil {
+			podCreateSeconds.Observe(time.Since(start).Seconds())
+		}
+		if ok {
+			created(func(ctx context.Context) error {
+				return k.call(ctx, func(ctx context.Context) error {
+					return k.coreV1(ctx).Pods(namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{
+						GracePeriodSeconds: int64ptr(0),
+					})
+				})
+			})
+		}
+	}
+	if err == nil {
+		err = k.setOwner(ctx, spec, pod)
+	}
+	if err == nil {
+		event := toPodEvent(spec, nil)
+		event.UID = string(pod.UID)
+		for _, o := range k.observers {
+			o.OnPodCreated(ctx, event)
+		}
+	}
+	return toSetupError(err)
+}
+
+// helper function deletes the namespace of the isolated
+// pipeline when setup fails.
+func (k *Kubernetes) rollbackNamespace(spec *Spec) {
+	if err := k.deleteNamespace(context.Background(), spec); err != nil {
+		logrus.WithError(err).
+			WithField("namespace", spec.PodSpec.Namespace).
+			Warnln("cannot rollback isolated namespace")
+	}
+}
+
+// helper function creates the secret and returns true if the
+// secret was created. If the secret already exists and is
+// owned by the pipeline, for example when setup is retried,
+// the secret is updated.
+func (k *Kubernetes) createSecret(ctx context.Context, spec *Spec, secret *v1.Secret) (bool, error) {
+	namespace := spec.PodSpec.Namespace
+	err := k.call(ctx, func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).Secrets(namespace).Create(secret)
+		return err
+	})
+	if !kerrors.IsAlreadyExists(err) {
+		return err == nil, err
+	}
+	var existing *v1.Secret
+	err = k.call(ctx, func(ctx context.Context) (err error) {
+		existing, err = k.coreV1(ctx).Secrets(namespace).Get(secret.Name, metav1.GetOptions{})
+		return err
+	})
 	if err != nil {
+		return false, err
+	}
+	if !isOwned(spec, existing.ObjectMeta) {
+		return false, &Error{
+			Code: CodeResourceConflict,
+			Err:  fmt.Errorf("engine: secret %s already exists and is not owned by the pipeline", secret.Name),
+		}
+	}
+	secret.ResourceVersion = existing.ResourceVersion
+	err = k.call(ctx, func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).Secrets(namespace).Update(secret)
 		return err
+	})
+	return false, err
+}
+
+// helper function creates the persistent volume claim and
+// returns true if the claim was created. An existing claim
+// owned by the pipeline is reused.
+func (k *Kubernetes) createClaim(ctx context.Context, spec *Spec, claim *v1.PersistentVolumeClaim) (bool, error) {
+	namespace := spec.PodSpec.Namespace
+	err := k.call(ctx, func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).PersistentVolumeClaims(namespace).Create(claim)
+		return err
+	})
+	if !kerrors.IsAlreadyExists(err) {
+		return err == nil, err
+	}
+	// claims that are kept are reused by subsequent
+	// pipelines, and are not owned by the pipeline.
+	if isKept(spec, claim) {
+		if isCache(spec, claim) {
+			k.touchCache(ctx, spec, claim.Name)
+		}
+		return false, nil
 	}
+	var existing *v1.PersistentVolumeClaim
+	err = k.call(ctx, func(ctx context.Context) (err error) {
+		existing, err = k.coreV1(ctx).PersistentVolumeClaims(namespace).Get(claim.Name, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if !isOwned(spec, existing.ObjectMeta) {
+		return false, &Error{
+			Code: CodeResourceConflict,
+			Err:  fmt.Errorf("engine: volume claim %s already exists and is not owned by the pipeline", claim.Name),
+		}
+	}
+	return false, nil
+}
 
-	_, err = k.client.CoreV1().Pods(spec.PodSpec.Namespace).Create(toPod(spec))
+// helper function creates the pod and returns true if the
+// pod was created. Pods are immutable, so an existing pod
+// owned by the pipeline is reused if it has not terminated.
+func (k *Kubernetes) createPod(ctx context.Context, spec *Spec) (*v1.Pod, bool, error) {
+	namespace := spec.PodSpec.Namespace
+	want, err := toTemplatePod(spec)
 	if err != nil {
+		return nil, false, err
+	}
+	want.Annotations = k.gc.annotate(spec, withSpecHash(spec, want.Annotations))
+	want.Annotations = k.heartbeats.annotate(spec, want.Annotations, time.Now())
+
+	// the pod returned by the api server is compared to the
+	// submitted pod, so that the pipeline fails fast if the
+	// pod was mutated, for example by an admission webhook,
+	// in ways that prevent the steps from running.
+	var pod *v1.Pod
+	err = k.call(ctx, func(ctx context.Context) (err error) {
+		pod, err = k.coreV1(ctx).Pods(namespace).Create(want)
+		return err
+	})
+	if err == nil {
+		return pod, true, checkDrift(spec, want, pod)
+	}
+	if !kerrors.IsAlreadyExists(err) {
+		return nil, false, err
+	}
+	var existing *v1.Pod
+	err = k.call(ctx, func(ctx context.Context) (err error) {
+		existing, err = k.coreV1(ctx).Pods(namespace).Get(spec.PodSpec.Name, metav1.GetOptions{})
 		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if !isOwned(spec, existing.ObjectMeta) {
+		return nil, false, &Error{
+			Code: CodeResourceConflict,
+			Err:  fmt.Errorf("engine: pod %s already exists and is not owned by the pipeline", spec.PodSpec.Name),
+		}
 	}
+	switch existing.Status.Phase {
+	case v1.PodSucceeded, v1.PodFailed:
+		return nil, false, &Error{
+			Code: CodeResourceConflict,
+			Err:  fmt.Errorf("engine: pod %s already exists and has terminated", spec.PodSpec.Name),
+		}
+	}
+	return existing, false, checkDrift(spec, want, existing)
+}
 
+// helper function sets the pod as the owner of the pipeline
+// secrets. This ensures the secrets are garbage collected
+// with the pod if the runner exits before the pipeline
+// environment is destroyed.
+func (k *Kubernetes) setOwner(ctx context.Context, spec *Spec, pod *v1.Pod) error {
+	names := []string{spec.PodSpec.Name}
+	if spec.PullSecret != nil {
+		names = append(names, spec.PullSecret.Name)
+	}
+	owner := toOwnerReference(pod)
+	for _, name := range names {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			ctx, cancel := k.deadline(ctx)
+			defer cancel()
+			client := k.coreV1(ctx).Secrets(spec.PodSpec.Namespace)
+			secret, err := client.Get(name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			for _, ref := range secret.OwnerReferences {
+				if ref.UID == owner.UID {
+					return nil
+				}
+			}
+			secret.OwnerReferences = append(secret.OwnerReferences, owner)
+			_, err = client.Update(secret)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Destroy the pipeline environment.
-func (k *Kubernetes) Destroy(ctx context.Context, spec *Spec) error {
-	var result error
+// helper function returns true if the object is labeled as
+// belonging to the pipeline.
+func isOwned(spec *Spec, meta metav1.ObjectMeta) bool {
+	return meta.Labels[labelName(labelPrefix(spec))] == spec.PodSpec.Name
+}
 
-	if spec.PullSecret != nil {
-		err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Delete(spec.PullSecret.Name, &metav1.DeleteOptions{})
+// Destroy the pipeline environment. Deletes are abandoned if
+// they do not complete within the teardown timeout.
+func (k *Kubernetes) Destroy(ctx context.Context, spec *Spec) (err error) {
+	if spec.Cluster != nil && !k.remote {
+		remote, err := k.forCluster(spec)
 		if err != nil {
-			result = multierror.Append(result, err)
+			return err
 		}
+		return remote.Destroy(ctx, spec)
 	}
+	if spec.TeardownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(spec.TeardownTimeout)*time.Second)
+		defer cancel()
+	}
+	defer k.gc.untrack(spec)
+	defer k.heartbeats.untrack(spec)
+	defer k.pulls.forget(spec)
+	defer k.rotation.forget(spec)
+	defer k.running.Delete(spec.PodSpec.Namespace + "/" + spec.PodSpec.Name)
 
-	err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
-	if err != nil {
-		result = multierror.Append(result, err)
+	start := time.Now()
+	defer func() {
+		destroySeconds.Observe(time.Since(start).Seconds())
+	}()
+	if len(k.observers) != 0 {
+		defer func() {
+			event := toPodEvent(spec, err)
+			for _, o := range k.observers {
+				o.OnDestroy(ctx, event)
+			}
+		}()
 	}
 
-	err = k.client.CoreV1().Pods(spec.PodSpec.Namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{
-		GracePeriodSeconds: int64ptr(0),
-	})
-	if err != nil {
-		result = multierror.Append(result, err)
+	if spec.Outputs {
+		k.removeOutputs(spec)
+	}
+
+	// the registry credentials are revoked once the steps
+	// complete, since the credentials are only used by the
+	// pipeline steps.
+	k.revokeRobot(ctx, spec)
+
+	// injected sidecars are stopped before the pod is deleted
+	// so that they can complete gracefully, for example to
+	// flush buffered logs.
+	for _, sidecar := range spec.Sidecars {
+		if err := k.stopSidecar(ctx, spec, sidecar); err != nil {
+			logrus.WithError(err).
+				WithField("pod", spec.PodSpec.Name).
+				WithField("sidecar", sidecar.Name).
+				Warnln("cannot stop sidecar")
+		}
+	}
+
+	// the resources created by the pipeline steps are deleted
+	// before the pipeline namespace, since the resources may
+	// be created in other namespaces.
+	k.cleanupLeftovers(ctx, spec)
+
+	// the pipeline resources of isolated pipelines are deleted
+	// with the namespace.
+	if spec.PodSpec.Isolated {
+		k.deletes.wait(priorityHigh)
+		return k.deleteNamespace(ctx, spec)
+	}
+
+	// the pipeline resources are deleted concurrently, and
+	// delete calls are throttled if enabled. The pipeline
+	// secrets are owned by the pod and may be garbage collected
+	// before they are deleted, so not found errors are ignored.
+	namespace := spec.PodSpec.Namespace
+	deletes := []func() error{
+		func() error {
+			k.deletes.wait(priorityHigh)
+			return k.call(ctx, func(ctx context.Context) error {
+				return k.coreV1(ctx).Pods(namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{
+					GracePeriodSeconds: int64ptr(0),
+				})
+			})
+		},
+		func() error {
+			k.deletes.wait(priorityLow)
+			return ignoreNotFound(k.call(ctx, func(ctx context.Context) error {
+				return k.coreV1(ctx).Secrets(namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
+			}))
+		},
+	}
+
+	if spec.PullSecret != nil {
+		deletes = append(deletes, func() error {
+			k.deletes.wait(priorityLow)
+			return ignoreNotFound(k.call(ctx, func(ctx context.Context) error {
+				return k.coreV1(ctx).Secrets(namespace).Delete(spec.PullSecret.Name, &metav1.DeleteOptions{})
+			}))
+		})
+	}
+
+	if spec.PodSpec.BlockMetadata {
+		deletes = append(deletes, func() error {
+			k.deletes.wait(priorityLow)
+			return ignoreNotFound(k.call(ctx, func(ctx context.Context) error {
+				return k.networkingV1(ctx).NetworkPolicies(namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
+			}))
+		})
 	}
 
-	return result
+	for _, claim := range toPersistentVolumeClaims(spec) {
+		if isKept(spec, claim) {
+			continue
+		}
+		claim := claim
+		deletes = append(deletes, func() error {
+			k.deletes.wait(priorityLow)
+			return k.call(ctx, func(ctx context.Context) error {
+				return k.coreV1(ctx).PersistentVolumeClaims(namespace).Delete(claim.Name, &metav1.DeleteOptions{})
+			})
+		})
+	}
+
+	return deleteParallel(deletes)
 }
 
 // Run runs the pipeline step.
-func (k *Kubernetes) Run(ctx context.Context, spec *Spec, step *Step, output io.Writer) (*State, error) {
-	err := k.waitForReady(ctx, spec, step)
+func (k *Kubernetes) Run(ctx context.Context, spec *Spec, step *Step, output io.Writer) (state *State, err error) {
+	if spec.Cluster != nil && !k.remote {
+		remote, err := k.forCluster(spec)
+		if err != nil {
+			return nil, err
+		}
+		return remote.Run(ctx, spec, step, output)
+	}
+	err = k.waitForReady(ctx, spec, step, output)
 	if err != nil {
 		return nil, err
 	}
 
-	return k.start(spec, step, output)
+	// steps do not start until the services are ready to
+	// accept connections.
+	if !step.Detach {
+		if err := k.waitForServices(ctx, spec, output); err != nil {
+			return nil, err
+		}
+	}
+
+	// steps scheduled off-peak are delayed until the cluster
+	// is off-peak.
+	if err := k.waitOffPeak(ctx, step, output); err != nil {
+		return nil, err
+	}
+
+	// in chaos mode, the step is randomly delayed as if the
+	// image was pulled slowly, and the pipeline pod is
+	// randomly evicted while the step is running.
+	if err := k.chaos.slowPull(ctx, step, output); err != nil {
+		return nil, err
+	}
+	defer k.evictRandomly(spec, step, output)()
+
+	// the step duration excludes the time waiting for the
+	// pod and services, which is recorded separately.
+	start := time.Now()
+
+	// the step progress is recorded in the pod, so that the
+	// runner can re-attach to the step if it restarts.
+	cp := &Checkpoint{Started: start.Unix()}
+	k.checkpoint(spec, step, cp)
+	defer func() {
+		if state != nil {
+			cp.Exited, cp.ExitCode, cp.Stopped = true, state.ExitCode, time.Now().Unix()
+			k.checkpoint(spec, step, cp)
+		}
+	}()
+	defer func() {
+		observeStep(start, state, err)
+	}()
+	if len(k.observers) != 0 {
+		event := toStepEvent(spec, step, nil, nil)
+		for _, o := range k.observers {
+			o.OnStepStarted(ctx, event)
+		}
+		defer func() {
+			event := toStepEvent(spec, step, state, err)
+			event.Duration = time.Since(start).Seconds()
+			for _, o := range k.observers {
+				o.OnStepFinished(ctx, event)
+			}
+		}()
+	}
+
+	// the step is cancelled if it exceeds the step timeout,
+	// in which case the step fails and the pipeline continues.
+	if step.Timeout > 0 {
+		timeout := time.Duration(step.Timeout) * time.Second
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		state, err = k.dispatch(stepCtx, spec, step, output)
+		if ctx.Err() == nil && stepCtx.Err() == context.DeadlineExceeded {
+			fmt.Fprintf(output, "step %s timed out after %s\n", step.Name, timeout)
+			return &State{Exited: true, ExitCode: 1, TimedOut: true}, nil
+		}
+		return state, err
+	}
+	return k.dispatch(ctx, spec, step, output)
+}
+
+// helper function executes the step on the node, once for all
+// pipelines with the same dedupe key, or in the pipeline pod.
+func (k *Kubernetes) dispatch(ctx context.Context, spec *Spec, step *Step, output io.Writer) (*State, error) {
+	if step.Node {
+		return k.runOnNode(ctx, spec, step, output)
+	}
+	if step.Dedupe != "" {
+		return k.runOnce(ctx, spec, step, output, func() (*State, error) {
+			return k.start(ctx, spec, step, output)
+		})
+	}
+	return k.start(ctx, spec, step, output)
 }
 
 func (k *Kubernetes) waitFor(ctx context.Context, spec *Spec, conditionFunc func(e watch.Event) (bool, error)) error {
-	label := fmt.Sprintf("io.drone.name=%s", spec.PodSpec.Name)
+	label := fmt.Sprintf("%s=%s", labelName(labelPrefix(spec)), spec.PodSpec.Name)
 	lw := &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			return k.client.CoreV1().Pods(spec.PodSpec.Namespace).List(metav1.ListOptions{
-				LabelSelector: label,
+		// the list and watch calls are retried, so that the
+		// watch is re-established after transient errors.
+		ListFunc: func(options metav1.ListOptions) (list runtime.Object, err error) {
+			err = k.call(ctx, func(ctx context.Context) error {
+				list, err = k.coreV1(ctx).Pods(spec.PodSpec.Namespace).List(metav1.ListOptions{
+					LabelSelector: label,
+				})
+				return err
 			})
+			return list, err
 		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			return k.client.CoreV1().Pods(spec.PodSpec.Namespace).Watch(metav1.ListOptions{
-				LabelSelector: label,
+		// the watch is bound to the pipeline context instead
+		// of the request deadline, and is closed by the api
+		// server after the watch timeout, in which case the
+		// watch is re-established.
+		WatchFunc: func(options metav1.ListOptions) (w watch.Interface, err error) {
+			err = k.retry(ctx, func() error {
+				w, err = k.coreV1(ctx).Pods(spec.PodSpec.Namespace).Watch(metav1.ListOptions{
+					LabelSelector:  label,
+					TimeoutSeconds: k.watchTimeout(),
+				})
+				return err
 			})
+			return w, err
 		},
 	}
 
@@ -170,40 +851,316 @@ func (k *Kubernetes) waitFor(ctx context.Context, spec *Spec, conditionFunc func
 	return err
 }
 
-func (k *Kubernetes) waitForReady(ctx context.Context, spec *Spec, step *Step) error {
+// helper function executes the sidecar stop command and waits
+// for the sidecar container to exit.
+func (k *Kubernetes) stopSidecar(ctx context.Context, spec *Spec, sidecar *Sidecar) error {
+	if len(sidecar.Stop) == 0 {
+		return nil
+	}
+	timeout := time.Duration(sidecar.StopTimeout) * time.Second
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// the exec connection is closed if the stop command does
+	// not return before the stop timeout expires.
+	err := k.exec(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, sidecar.Name, sidecar.Stop, ioutil.Discard, ioutil.Discard)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	return k.waitSidecarExit(ctx, spec, sidecar)
+}
+
+// helper function waits for the sidecar container to exit.
+func (k *Kubernetes) waitSidecarExit(ctx context.Context, spec *Spec, sidecar *Sidecar) error {
 	return k.waitFor(ctx, spec, func(e watch.Event) (bool, error) {
+		switch e.Type {
+		case watch.Added, watch.Modified:
+			pod, ok := e.Object.(*v1.Pod)
+			if !ok || pod.ObjectMeta.Name != spec.PodSpec.Name {
+				return false, nil
+			}
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name == sidecar.Name && status.State.Terminated != nil {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	})
+}
+
+func (k *Kubernetes) waitForReady(ctx context.Context, spec *Spec, step *Step, output io.Writer) error {
+	// the reason the pod is pending is periodically written
+	// to the step output while waiting, so that scheduling
+	// and image pull delays are visible in the build logs.
+	p := new(pending)
+	if spec.PendingInterval > 0 {
+		pendingCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go k.reportPending(pendingCtx, spec, p, output)
+	}
+	stepsWaiting.Inc()
+	defer stepsWaiting.Dec()
+
+	// the step fails if the pod is not scheduled, or the step
+	// container does not start, before the timeouts expire.
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	expired := make(chan error, 1)
+	if spec.ScheduleTimeout > 0 || spec.PullTimeout > 0 {
+		go watchTimeouts(waitCtx, spec, step, p, func(err error) {
+			expired <- err
+			cancel()
+		})
+	}
+
+	err := k.waitFor(waitCtx, spec, func(e watch.Event) (bool, error) {
 		switch t := e.Type; t {
 		case watch.Added, watch.Modified:
 			pod, ok := e.Object.(*v1.Pod)
 			if !ok || pod.ObjectMeta.Name != spec.PodSpec.Name {
 				return false, nil
 			}
+			k.pulls.observe(pod)
+			k.rotateCredentials(waitCtx, spec, pod)
 			if pod.Status.Phase == v1.PodRunning {
-				return true, nil
+				// the time to running is recorded once per
+				// pod, by the first step that observes it.
+				if _, loaded := k.running.LoadOrStore(pod.Namespace+"/"+pod.Name, true); !loaded {
+					podRunningSeconds.Observe(time.Since(pod.CreationTimestamp.Time).Seconds())
+				}
+				// the pod is running once a single container
+				// is running, so the step waits until its own
+				// container is running.
+				if ready, err := checkStepContainer(pod, step); ready || err != nil {
+					return ready, err
+				}
+			}
+			if err := checkRejected(pod); err != nil {
+				return false, err
 			}
+			if err := checkContainers(spec, pod); err != nil {
+				return false, err
+			}
+			p.set(pod)
 		}
 		return false, nil
 	})
+	select {
+	case err = <-expired:
+	default:
+	}
+	if CodeOf(err) == CodeImagePullFailed {
+		imagePullFailures.Inc()
+	}
+	return err
 }
 
-func (k *Kubernetes) start(spec *Spec, step *Step, output io.Writer) (*State, error) {
+func (k *Kubernetes) start(ctx context.Context, spec *Spec, step *Step, output io.Writer) (*State, error) {
 	// log := logger.Default
 
 	stdoutOutput := nicelog.New(output)
 	stderrOutput := nicelog.New(output)
 
+	// encrypted secrets are not sourced from the pipeline
+	// secret, and are written to the standard input of the
+	// step shell instead.
+	exports := toExports(spec, step)
+
+	// variables exported by previous steps are written to
+	// the standard input of the step shell.
+	if spec.Outputs {
+		exports = append(exports, k.toOutputExports(spec)...)
+	}
+
+	// variables from the step environment files are read from
+	// the workspace when the step starts, after the repository
+	// is cloned. The step fails if a file cannot be read.
+	if len(step.EnvFiles) != 0 {
+		envs, err := k.readEnvFiles(ctx, spec, step)
+		if err != nil {
+			fmt.Fprintf(output, "env_file: %s\n", err)
+			return &State{Exited: true, ExitCode: 1}, nil
+		}
+		exports = append(exports, envs...)
+	}
+
+	// the stdin payload is written to the standard input of
+	// the step process, after the exports. The step fails if
+	// the payload secret does not exist.
+	stdin, err := toStdin(spec, step)
+	if err != nil {
+		fmt.Fprintf(output, "stdin: %s\n", err)
+		return &State{Exited: true, ExitCode: 1}, nil
+	}
+	marker, err := toExitMarker()
+	if err != nil {
+		return nil, err
+	}
+
+	// the step script is not executed until the wait_for
+	// conditions are met, and the step fails if they are not
+	// met before the timeout.
+	command := toScriptCommand(step)
+	if step.WaitFor != nil {
+		command = toWaitCommand(command, step.WaitFor)
+	}
+
+	// the step script periodically writes a keep-alive byte
+	// to the exec stream, so that steps that do not write
+	// output for a long time are not disconnected by proxies.
+	// The keep-alive bytes are removed from the step output.
+	script := command
+	var stdout io.Writer = stdoutOutput
+	var stderr io.Writer = stderrOutput
+	if spec.KeepAlive > 0 {
+		command = toKeepAliveCommand(command, spec.KeepAlive)
+		stderr = &keepAliveWriter{w: stderrOutput}
+	}
+
+	// the step script records its exit code, so that the step
+	// completes if the exec stream is not closed after the
+	// script exits.
+	var watch *watchdog
+	if spec.Watchdog > 0 {
+		watch = newWatchdog(step, time.Duration(spec.Watchdog)*time.Second)
+		command = watch.command(command)
+		stdout = watch.writer(stdout)
+		stderr = watch.writer(stderr)
+	}
+
+	// the exec stream is closed if it does not complete after
+	// the step is cancelled and the step processes are killed,
+	// so that cancelled steps do not block until the pod is
+	// deleted.
+	execCtx, cancel := withGrace(ctx, 2*killGrace)
+	defer cancel()
+
 	execFunc := func(cmd string) error {
-		return k.exec(spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, cmd, stdoutOutput, stderrOutput)
+		if step.Stdin != nil {
+			if len(exports) != 0 {
+				cmd = toStdinCommand(cmd, marker)
+			}
+			return k.stream(execCtx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, cmd), bytes.NewReader(toStdinStream(exports, stdin, marker)), stdout, stderr)
+		}
+		if len(exports) == 0 {
+			return k.exec(execCtx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, cmd), stdout, stderr)
+		}
+		cmd = ". /dev/stdin; " + cmd
+		return k.stream(execCtx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, cmd), bytes.NewReader(exports), stdout, stderr)
+	}
+	if watch != nil {
+		execFunc = k.watchExec(execCtx, spec, step, watch, stderrOutput, execFunc)
+	}
+	execFunc = cancelExec(ctx, execFunc)
+
+	// steps that exec into the pod are limited, and queued
+	// in order of arrival when the limit is exceeded.
+	release, err := k.execs.acquire(ctx, spec.PodSpec.Namespace+"/"+spec.PodSpec.Name, func(limit int) {
+		fmt.Fprintf(output, "exec: waiting for one of %d exec slots in the pod\n", limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// if the pipeline is cancelled the step processes are
+	// terminated, so that the step exits and the buffered
+	// output is flushed before the pod is deleted.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			k.interrupt(spec, step)
+		}
+		// processes that do not exit when interrupted are
+		// killed after the grace period.
+		select {
+		case <-done:
+		case <-time.After(killGrace):
+			k.kill(spec, step)
+		}
+	}()
+
+	// the step resource usage is periodically written to the
+	// step output while the step is running.
+	if spec.UsageInterval > 0 {
+		usageCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go k.usage(usageCtx, spec, step, output)
 	}
 
 	state := &State{
 		Exited:    true,
 		OOMKilled: false,
 	}
+
+	// the step output is optionally streamed from the container
+	// logs, which can be resumed if the stream disconnects. The
+	// output of the exec session is streamed instead if the
+	// container logs are not available, or if the step reads
+	// the standard input of the exec session.
+	streamed := false
+	if spec.LogStream && step.Stdin == nil {
+		streamed, err = k.runWithLogs(ctx, spec, step, script, exports, state, stdoutOutput)
+		stdoutOutput.Flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !streamed {
+		err = k.runWithExec(ctx, spec, step, execFunc, command, state, stdoutOutput, stderrOutput)
+	}
+
+	// the exec session fails, or the step exits with an error,
+	// if the step container terminated, for example if the
+	// container was killed because it exceeded the memory limit.
+	if (err != nil || state.ExitCode != 0) && ctx.Err() == nil {
+		if k.checkTerminated(ctx, spec, step, state) {
+			err = nil
+		}
+	}
+	if state.OOMKilled {
+		fmt.Fprintf(output, "step %s was killed because it exceeded the memory limit\n", step.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if spec.Outputs {
+		k.collectOutputs(ctx, spec, step)
+	}
+	if len(step.JUnit) != 0 {
+		k.report(ctx, spec, step, output)
+	}
+	if step.Coverage != nil && state.ExitCode == 0 {
+		if !k.coverage(ctx, spec, step, output) {
+			state.ExitCode = 1
+		}
+	}
+	return state, nil
+}
+
+// helper function executes the step script and streams the
+// output of the exec session. The script is executed again if
+// no output was written, because the exec stream may have been
+// dropped before any output was delivered.
+func (k *Kubernetes) runWithExec(ctx context.Context, spec *Spec, step *Step, execFunc func(string) error, command string, state *State, stdoutOutput, stderrOutput *nicelog.Writer) error {
 	err := retry.OnError(retry.DefaultBackoff, func(err error) bool {
-		return err == errNotDataWrittern
+		return err == errNotDataWrittern && ctx.Err() == nil
 	}, func() error {
-		err := execFunc(`echo "$DRONE_SCRIPT" | sh`)
+		err := execFunc(command)
 		stdoutOutput.Flush()
 		stderrOutput.Flush()
 		if err != nil {
@@ -222,38 +1179,217 @@ func (k *Kubernetes) start(spec *Spec, step *Step, output io.Writer) (*State, er
 		}
 		return nil
 	})
+	if err == errNotDataWrittern {
+		return nil
+	}
+	// the exec stream failed before the step exited, and the
+	// step was not cancelled.
+	if err != nil && ctx.Err() == nil {
+		execStreamErrors.Inc()
+		return withCode(CodeExecDisconnected, err)
+	}
+	return err
+}
+
+// helper function terminates the step processes. The step
+// context is cancelled, so the command is bound to a new
+// context with the request deadline.
+func (k *Kubernetes) interrupt(spec *Spec, step *Step) {
+	ctx, cancel := k.deadline(context.Background())
+	defer cancel()
+	err := k.exec(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, interruptCommand), ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			WithField("container", step.ID).
+			Debugln("cannot interrupt step")
+	}
+}
+
+// the duration the step processes are given to exit after the
+// step is interrupted, before the processes are killed.
+var killGrace = 10 * time.Second
 
-	if err != nil && err != errNotDataWrittern {
+// helper function kills the step processes.
+func (k *Kubernetes) kill(spec *Spec, step *Step) {
+	ctx, cancel := k.deadline(context.Background())
+	defer cancel()
+	err := k.exec(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, killCommand), ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			WithField("container", step.ID).
+			Debugln("cannot kill step")
+	}
+}
+
+// helper function returns the exec function wrapped to return
+// when the context is cancelled. The exec stream is given the
+// grace period to deliver the remaining output after the step
+// processes are interrupted and killed, after which the stream
+// is abandoned and the context error is returned.
+func cancelExec(ctx context.Context, execFunc func(string) error) func(string) error {
+	return func(cmd string) error {
+		result := make(chan error, 1)
+		go func() {
+			result <- execFunc(cmd)
+		}()
+		select {
+		case err := <-result:
+			return err
+		case <-ctx.Done():
+		}
+		select {
+		case err := <-result:
+			return err
+		case <-time.After(2 * killGrace):
+			return ctx.Err()
+		}
+	}
+}
+
+// helper function merges the code coverage files produced by
+// the step, writes the coverage total to the step logs, and
+// returns false if the coverage is below the threshold.
+func (k *Kubernetes) coverage(ctx context.Context, spec *Spec, step *Step, output io.Writer) bool {
+	files, err := k.readFiles(ctx, spec, step, step.Coverage.Paths)
+	if err != nil {
+		fmt.Fprintf(output, "coverage: cannot read coverage files: %s\n", err)
+		return step.Coverage.Threshold == 0
+	}
+	report := coverage.New()
+	for _, data := range files {
+		if err := report.Parse(data); err != nil {
+			fmt.Fprintf(output, "coverage: cannot parse coverage file: %s\n", err)
+			return step.Coverage.Threshold == 0
+		}
+	}
+	covered, total := report.Lines()
+	percent := report.Percent()
+	fmt.Fprintf(output, "coverage: %.2f%% of lines covered (%d/%d) in %d files\n", percent, covered, total, len(files))
+	if percent < step.Coverage.Threshold {
+		fmt.Fprintf(output, "coverage: below threshold of %.2f%%\n", step.Coverage.Threshold)
+		return false
+	}
+	return true
+}
+
+// helper function returns the contents of the files in the
+// step container that match the glob patterns.
+func (k *Kubernetes) readFiles(ctx context.Context, spec *Spec, step *Step, patterns []string) ([][]byte, error) {
+	ctx, cancel := k.deadline(ctx)
+	defer cancel()
+
+	var quoted []string
+	for _, pattern := range patterns {
+		quoted = append(quoted, shellquote(pattern))
+	}
+	cmd := "for f in " + strings.Join(quoted, " ") + `; do [ -f "$f" ] && echo "$f"; done; true`
+
+	buf := new(bytes.Buffer)
+	err := k.exec(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, cmd), buf, ioutil.Discard)
+	if err != nil {
 		return nil, err
 	}
-	return state, nil
+	var files [][]byte
+	for _, name := range strings.Split(buf.String(), "\n") {
+		if name == "" {
+			continue
+		}
+		buf.Reset()
+		// the file name is passed as a positional argument to
+		// avoid quoting.
+		command := append(toShellCommand(step, `cat "$0"`), name)
+		err := k.exec(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, command, buf, ioutil.Discard)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, append([]byte(nil), buf.Bytes()...))
+	}
+	return files, nil
+}
+
+// helper function parses the junit test reports produced by
+// the step and writes the test summary to the step logs.
+func (k *Kubernetes) report(ctx context.Context, spec *Spec, step *Step, output io.Writer) {
+	ctx, cancel := k.deadline(ctx)
+	defer cancel()
+
+	var patterns []string
+	for _, pattern := range step.JUnit {
+		patterns = append(patterns, shellquote(pattern))
+	}
+	// the patterns are expanded by the shell in the step
+	// working directory. Patterns that do not match any
+	// files are ignored.
+	cmd := "for f in " + strings.Join(patterns, " ") + `; do [ -f "$f" ] && cat "$f"; done; true`
+
+	buf := new(bytes.Buffer)
+	err := k.exec(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, cmd), buf, ioutil.Discard)
+	if err != nil {
+		fmt.Fprintf(output, "junit: cannot read test reports: %s\n", err)
+		return
+	}
+	if buf.Len() == 0 {
+		fmt.Fprintln(output, "junit: no test reports found")
+		return
+	}
+	summary, err := junit.Parse(buf)
+	if err != nil {
+		fmt.Fprintf(output, "junit: cannot parse test reports: %s\n", err)
+		return
+	}
+	fmt.Fprintf(output, "junit: %s\n", summary)
+	for _, name := range summary.Failed {
+		fmt.Fprintf(output, "junit: FAIL %s\n", name)
+	}
+}
+
+func (k *Kubernetes) exec(ctx context.Context, podNamespace, podName, container string, command []string, stdout, stderr io.Writer) error {
+	return k.stream(ctx, podNamespace, podName, container, command, nil, stdout, stderr)
 }
 
-func (k *Kubernetes) exec(podNamespace, podName, container string, command string, stdout, stderr io.Writer) error {
+// helper function executes the command in the container,
+// streaming stdin to the command if provided. The exec
+// connection is closed once the context is cancelled.
+func (k *Kubernetes) stream(ctx context.Context, podNamespace, podName, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
 	return retry.OnError(retry.DefaultBackoff, func(e error) bool {
+		if ctx.Err() != nil {
+			return false
+		}
 		return strings.Contains(e.Error(), "lookup") || errors.Is(e, errors.New("asd"))
 	}, func() error {
 		// rate limit
-		time.Sleep(time.Millisecond * 500)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 500):
+		}
 		req := k.client.CoreV1().
 			RESTClient().Post().
 			Resource("pods").Name(podName).
 			Namespace(podNamespace).SubResource("exec")
 		req.VersionedParams(&v1.PodExecOptions{
 			Container: container,
-			Command:   []string{"sh", "-c", command},
+			Command:   command,
+			Stdin:     stdin != nil,
 			Stdout:    stdout != nil,
 			Stderr:    stderr != nil,
 		},
 			scheme.ParameterCodec,
 		)
-		executor, err := remotecommand.NewSPDYExecutor(
-			k.config, http.MethodPost, req.URL())
+		transport, upgrader, err := spdy.RoundTripperFor(k.config)
+		if err != nil {
+			return err
+		}
+		executor, err := remotecommand.NewSPDYExecutorForTransports(
+			transport, &cancelUpgrader{Upgrader: upgrader, ctx: ctx}, http.MethodPost, req.URL())
 		if err != nil {
 			logrus.WithError(err).Error("New SPDYExecutor failed")
 			return err
 		}
 		err = executor.Stream(remotecommand.StreamOptions{
+			Stdin:  stdin,
 			Stdout: stdout,
 			Stderr: stderr,
 		})
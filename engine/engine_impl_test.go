@@ -2,6 +2,7 @@ package engine
 
 import (
 	"bytes"
+	"context"
 	"testing"
 
 	"k8s.io/client-go/kubernetes"
@@ -56,7 +57,7 @@ echo 1
 			}
 			stdout := &bytes.Buffer{}
 			stderr := &bytes.Buffer{}
-			err := k.exec(tt.args.podNamespace, tt.args.podName, tt.args.container, tt.args.commands, stdout, stderr)
+			err := k.exec(context.Background(), tt.args.podNamespace, tt.args.podName, tt.args.container, []string{"sh", "-c", tt.args.commands}, stdout, stderr)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("exec() error = %v, wantErr %v", err, tt.wantErr)
 				return
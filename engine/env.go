@@ -0,0 +1,84 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// MaxEnvSize is the maximum size of a single environment
+// variable, including the variable name. Larger variables
+// exceed the kernel MAX_ARG_STRLEN limit and cause the step
+// to fail with "argument list too long".
+const MaxEnvSize = 128*1024 - 1
+
+// MaxEnvTotal is the maximum size of the step environment.
+// The environment and the command arguments share the kernel
+// ARG_MAX limit, which is 2MiB with the default stack size,
+// so half is reserved for the arguments and the variables
+// added by the container runtime.
+const MaxEnvTotal = 1024 * 1024
+
+// MaxSecretSize is the maximum size of the pipeline secret,
+// which holds the pipeline secrets and the step scripts and
+// variables that are mounted as files. Kubernetes rejects
+// secrets larger than 1MiB.
+const MaxSecretSize = 1024 * 1024
+
+// ScriptPath is the path where step scripts that are too
+// large to pass as environment variables are mounted.
+const ScriptPath = "/drone/scripts"
+
+// EnvSize returns the size of the environment variable
+// as it is passed to the kernel.
+func EnvSize(name, value string) int {
+	return len(name) + len(value) + 1
+}
+
+// helper function returns an error if a step environment
+// variable, or the step environment as a whole, exceeds the
+// maximum size. This is used to fail early with a meaningful
+// error instead of an opaque exec error.
+func checkEnv(spec *Spec) error {
+	for _, step := range spec.Steps {
+		total := 0
+		for k, v := range step.Envs {
+			if EnvSize(k, v) > MaxEnvSize {
+				return fmt.Errorf("engine: environment variable %s in step %s exceeds %d bytes. Pass large values as a secret or in a file instead", k, step.Name, MaxEnvSize)
+			}
+			total += EnvSize(k, v)
+		}
+		for _, v := range step.Secrets {
+			if secret, ok := spec.Secrets[v.Name]; ok {
+				total += EnvSize(v.Env, secret.Data)
+			}
+		}
+		if total > MaxEnvTotal {
+			return fmt.Errorf("engine: environment of step %s exceeds %d bytes", step.Name, MaxEnvTotal)
+		}
+	}
+	return nil
+}
+
+// helper function returns an error if the pipeline secret
+// exceeds the kubernetes secret size limit.
+func checkSecretSize(spec *Spec) error {
+	total := 0
+	for _, secret := range spec.Secrets {
+		if secret.Local {
+			continue
+		}
+		size := len(secret.Data)
+		if secret.Binary {
+			size = base64.StdEncoding.DecodedLen(size)
+		}
+		total += len(secret.Name) + size
+	}
+	if total > MaxSecretSize {
+		return fmt.Errorf("engine: the pipeline secrets and scripts total %d bytes, which exceeds the %d byte kubernetes secret limit", total, MaxSecretSize)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckEnv(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{Name: "build", Envs: map[string]string{"GOOS": "linux"}},
+		},
+	}
+	if err := checkEnv(spec); err != nil {
+		t.Error(err)
+	}
+
+	spec.Steps[0].Envs["PLUGIN_SETTINGS"] = strings.Repeat("a", MaxEnvSize)
+	if err := checkEnv(spec); err == nil {
+		t.Errorf("Expect error when a variable exceeds the maximum size")
+	}
+
+	// the variables are within the maximum size, but the
+	// environment as a whole, including secrets, is not.
+	spec.Steps[0].Envs = map[string]string{}
+	spec.Secrets = map[string]*Secret{}
+	for i := 0; i < 9; i++ {
+		name := strings.Repeat("a", i+1)
+		spec.Steps[0].Envs[name] = strings.Repeat("a", MaxEnvSize-100)
+	}
+	spec.Secrets["token"] = &Secret{Name: "token", Data: strings.Repeat("a", 1024)}
+	spec.Steps[0].Secrets = []*SecretVar{{Name: "token", Env: "TOKEN"}}
+	if err := checkEnv(spec); err == nil {
+		t.Errorf("Expect error when the environment exceeds the maximum size")
+	}
+}
+
+func TestCheckSecretSize(t *testing.T) {
+	spec := &Spec{
+		Secrets: map[string]*Secret{
+			"build.sh": {Name: "build.sh", Data: strings.Repeat("a", MaxSecretSize/2)},
+			"cache":    {Name: "cache", Data: strings.Repeat("a", MaxSecretSize), Local: true},
+		},
+	}
+	if err := checkSecretSize(spec); err != nil {
+		t.Errorf("Expect local secrets ignored, got %s", err)
+	}
+	spec.Secrets["test.sh"] = &Secret{Name: "test.sh", Data: strings.Repeat("a", MaxSecretSize/2)}
+	if err := checkSecretSize(spec); err == nil {
+		t.Errorf("Expect error when the pipeline secret exceeds the kubernetes limit")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/joho/godotenv"
+)
+
+// helper function reads the environment files of the step from
+// the step container, and returns the shell commands that export
+// the variables. Variables in later files take precedence, and
+// variables defined in the step environment are not overridden.
+func (k *Kubernetes) readEnvFiles(ctx context.Context, spec *Spec, step *Step) ([]byte, error) {
+	envs := map[string]string{}
+	for _, file := range step.EnvFiles {
+		files, err := k.readFiles(ctx, spec, step, []string{file})
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s: no such file", file)
+		}
+		for _, data := range files {
+			parsed, err := godotenv.Parse(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+			for name, value := range parsed {
+				envs[name] = value
+			}
+		}
+	}
+	return toEnvFileExports(envs, step), nil
+}
+
+// helper function returns the shell commands that export the
+// environment file variables.
+func toEnvFileExports(envs map[string]string, step *Step) []byte {
+	var names []string
+	for name := range envs {
+		if _, ok := step.Envs[name]; ok {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString("export ")
+		buf.WriteString(name)
+		buf.WriteString("=")
+		buf.WriteString(quote(envs[name]))
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,22 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestToEnvFileExports(t *testing.T) {
+	envs := map[string]string{
+		"GOOS":    "linux",
+		"GOARCH":  "amd64",
+		"MESSAGE": "hello world",
+	}
+	step := &Step{
+		Envs: map[string]string{"GOARCH": "arm64"},
+	}
+	want := "export GOOS='linux'\nexport MESSAGE='hello world'\n"
+	if got := string(toEnvFileExports(envs, step)); got != want {
+		t.Errorf("Want exports %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ErrSecretNotFound is returned by the secret store if the
+// secret path or key does not exist.
+var ErrSecretNotFound = errors.New("engine: secret not found")
+
+// SecretStore resolves secrets from an external secret store,
+// for example vault.
+type SecretStore interface {
+	// Find returns the value of the key of the secret at
+	// the path.
+	Find(ctx context.Context, path, key string) (string, error)
+}
+
+// ResolveSecrets configures the engine to resolve the vault
+// environment variables of the pipeline steps with the secret
+// store when the pipeline is created. The values are resolved
+// by the runner, so that the credentials never pass through
+// the server.
+func (k *Kubernetes) ResolveSecrets(store SecretStore) {
+	k.store = store
+}
+
+// helper function resolves the vault environment variables of
+// the pipeline steps, and adds the values to the masked
+// pipeline secrets. Optional variables are skipped if the
+// secret does not exist.
+func (k *Kubernetes) resolveEnvRefs(ctx context.Context, spec *Spec) error {
+	for _, step := range spec.Steps {
+		for _, ref := range step.EnvRefs {
+			if ref.Vault == nil {
+				continue
+			}
+			if k.store == nil {
+				return fmt.Errorf("engine: cannot resolve %s in step %s: vault is not configured", ref.Env, step.Name)
+			}
+			value, err := k.store.Find(ctx, ref.Vault.Name, ref.Vault.Key)
+			if err == ErrSecretNotFound && ref.Vault.Optional {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("engine: cannot resolve %s in step %s: %s", ref.Env, step.Name, err)
+			}
+			name := "vault." + step.ID + "." + strings.ToLower(ref.Env)
+			if spec.Secrets == nil {
+				spec.Secrets = map[string]*Secret{}
+			}
+			spec.Secrets[name] = &Secret{
+				Name: name,
+				Data: value,
+				Mask: true,
+			}
+			step.Secrets = append(step.Secrets, &SecretVar{
+				Name: name,
+				Env:  ref.Env,
+			})
+		}
+	}
+	return nil
+}
+
+// helper function returns the environment variables sourced
+// from existing Kubernetes secrets and config maps.
+func toEnvRefs(step *Step) []v1.EnvVar {
+	var envVars []v1.EnvVar
+	for _, ref := range step.EnvRefs {
+		switch {
+		case ref.Secret != nil:
+			envVars = append(envVars, v1.EnvVar{
+				Name: ref.Env,
+				ValueFrom: &v1.EnvVarSource{
+					SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{
+							Name: ref.Secret.Name,
+						},
+						Key:      ref.Secret.Key,
+						Optional: boolptr(ref.Secret.Optional),
+					},
+				},
+			})
+		case ref.ConfigMap != nil:
+			envVars = append(envVars, v1.EnvVar{
+				Name: ref.Env,
+				ValueFrom: &v1.EnvVarSource{
+					ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{
+							Name: ref.ConfigMap.Name,
+						},
+						Key:      ref.ConfigMap.Key,
+						Optional: boolptr(ref.ConfigMap.Optional),
+					},
+				},
+			})
+		}
+	}
+	return envVars
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockStore map[string]string
+
+func (m mockStore) Find(ctx context.Context, path, key string) (string, error) {
+	value, ok := m[path+"#"+key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func TestResolveEnvRefs(t *testing.T) {
+	k := &Kubernetes{store: mockStore{"secret/data/ci#token": "correct-horse"}}
+	step := &Step{
+		ID:   "drone-step",
+		Name: "deploy",
+		EnvRefs: []*EnvRef{
+			{Env: "DB_PASSWORD", Secret: &EnvKeyRef{Name: "database", Key: "password"}},
+			{Env: "DEPLOY_TOKEN", Vault: &EnvKeyRef{Name: "secret/data/ci", Key: "token"}},
+			{Env: "OPTIONAL", Vault: &EnvKeyRef{Name: "secret/data/ci", Key: "unknown", Optional: true}},
+		},
+	}
+	spec := &Spec{Steps: []*Step{step}}
+	if err := k.resolveEnvRefs(context.Background(), spec); err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []*SecretVar{{Name: "vault.drone-step.deploy_token", Env: "DEPLOY_TOKEN"}}
+	if diff := cmp.Diff(step.Secrets, want); diff != "" {
+		t.Errorf("Unexpected secret variables")
+		t.Log(diff)
+	}
+	secret := spec.Secrets["vault.drone-step.deploy_token"]
+	if secret == nil || secret.Data != "correct-horse" || !secret.Mask {
+		t.Errorf("Want the vault secret added to the masked pipeline secrets")
+	}
+
+	step.EnvRefs[2].Vault.Optional = false
+	if err := k.resolveEnvRefs(context.Background(), spec); err == nil {
+		t.Errorf("Want error if a required vault secret does not exist")
+	}
+	if err := new(Kubernetes).resolveEnvRefs(context.Background(), spec); err == nil {
+		t.Errorf("Want error if vault is not configured")
+	}
+}
+
+func TestToEnvRefs(t *testing.T) {
+	step := &Step{
+		EnvRefs: []*EnvRef{
+			{Env: "DB_PASSWORD", Secret: &EnvKeyRef{Name: "database", Key: "password"}},
+			{Env: "AWS_REGION", ConfigMap: &EnvKeyRef{Name: "aws", Key: "region", Optional: true}},
+			{Env: "DEPLOY_TOKEN", Vault: &EnvKeyRef{Name: "secret/data/ci", Key: "token"}},
+		},
+	}
+	envs := toEnvRefs(step)
+	if got, want := len(envs), 2; got != want {
+		t.Errorf("Want %d environment variables, got %d", want, got)
+		return
+	}
+	if ref := envs[0].ValueFrom.SecretKeyRef; ref == nil || ref.Name != "database" || ref.Key != "password" || *ref.Optional {
+		t.Errorf("Want secret key reference, got %v", envs[0].ValueFrom)
+	}
+	if ref := envs[1].ValueFrom.ConfigMapKeyRef; ref == nil || ref.Name != "aws" || ref.Key != "region" || !*ref.Optional {
+		t.Errorf("Want config map key reference, got %v", envs[1].ValueFrom)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"errors"
+	"strings"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ErrorCode identifies the cause of an infrastructure failure,
+// so that failures can be aggregated by cause instead of by
+// the free-text error message.
+type ErrorCode string
+
+// Error codes.
+const (
+	// the pod cannot be scheduled, or was rejected by the
+	// node, for example because the node is out of resources.
+	CodeSchedulingFailed ErrorCode = "SCHEDULING_FAILED"
+
+	// a step image cannot be pulled, or the image name is
+	// invalid.
+	CodeImagePullFailed ErrorCode = "IMAGE_PULL_FAILED"
+
+	// a step container cannot be created, for example because
+	// a referenced secret or config map does not exist.
+	CodeContainerFailed ErrorCode = "CONTAINER_CREATE_FAILED"
+
+	// the exec stream of a step was disconnected before the
+	// step exited.
+	CodeExecDisconnected ErrorCode = "EXEC_DISCONNECTED"
+
+	// the container log stream of a step was disconnected
+	// and could not be resumed.
+	CodeLogsDisconnected ErrorCode = "LOGS_DISCONNECTED"
+
+	// the pipeline resources exceed the namespace quota.
+	CodeQuotaExceeded ErrorCode = "QUOTA_EXCEEDED"
+
+	// the pipeline services are not ready before the timeout.
+	CodeServicesNotReady ErrorCode = "SERVICES_NOT_READY"
+
+	// a pipeline resource already exists and is not owned by
+	// the pipeline.
+	CodeResourceConflict ErrorCode = "RESOURCE_CONFLICT"
+
+	// the pod was modified after it was submitted, for
+	// example by an admission webhook.
+	CodePodModified ErrorCode = "POD_MODIFIED"
+
+	// the pipeline resources cannot be created.
+	CodeSetupFailed ErrorCode = "SETUP_FAILED"
+
+	// the step exceeded the step timeout.
+	CodeStepTimeout ErrorCode = "STEP_TIMEOUT"
+
+	// none of the nodes that match the pipeline node selector
+	// support the pipeline platform or required features.
+	CodePlatformUnsupported ErrorCode = "PLATFORM_UNSUPPORTED"
+
+	// none of the nodes are in the pipeline zone or region.
+	CodeTopologyUnavailable ErrorCode = "TOPOLOGY_UNAVAILABLE"
+)
+
+// Error is an infrastructure failure with an error code. The
+// code is included in the error message, because the message
+// is the only failure detail stored for stages and steps.
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return "[" + string(e.Code) + "] " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// CodeOf returns the error code of the error, or an empty code
+// if the error does not have a code.
+func CodeOf(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// helper function returns the error with the error code. The
+// error is returned unchanged if it already has a code.
+func withCode(code ErrorCode, err error) error {
+	if err == nil || CodeOf(err) != "" {
+		return err
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// helper function returns the setup error with an error code
+// derived from the api server error.
+func toSetupError(err error) error {
+	if kerrors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota") {
+		return withCode(CodeQuotaExceeded, err)
+	}
+	return withCode(CodeSetupFailed, err)
+}
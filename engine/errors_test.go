@@ -0,0 +1,49 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestError(t *testing.T) {
+	err := withCode(CodeExecDisconnected, errors.New("connection reset by peer"))
+	if got, want := err.Error(), "[EXEC_DISCONNECTED] connection reset by peer"; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+	if got, want := CodeOf(fmt.Errorf("step failed: %w", err)), CodeExecDisconnected; got != want {
+		t.Errorf("Want error code %s of wrapped error, got %s", want, got)
+	}
+	if got := withCode(CodeSetupFailed, err); CodeOf(got) != CodeExecDisconnected {
+		t.Errorf("Want existing error code preserved, got %s", CodeOf(got))
+	}
+	if got := CodeOf(errors.New("exit status 1")); got != "" {
+		t.Errorf("Want no error code, got %s", got)
+	}
+	if withCode(CodeSetupFailed, nil) != nil {
+		t.Errorf("Want nil error")
+	}
+}
+
+func TestToSetupError(t *testing.T) {
+	quota := kerrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "drone-abc",
+		errors.New("exceeded quota: compute, requested: pods=1, used: pods=10, limited: pods=10"))
+	if got, want := CodeOf(toSetupError(quota)), CodeQuotaExceeded; got != want {
+		t.Errorf("Want error code %s, got %s", want, got)
+	}
+	denied := kerrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "drone-abc",
+		errors.New("admission webhook denied the request"))
+	if got, want := CodeOf(toSetupError(denied)), CodeSetupFailed; got != want {
+		t.Errorf("Want error code %s, got %s", want, got)
+	}
+	if toSetupError(nil) != nil {
+		t.Errorf("Want nil error")
+	}
+}
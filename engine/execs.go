@@ -0,0 +1,85 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// LimitExecs limits the number of steps that concurrently
+// exec into the same pipeline pod, so that parallel steps do
+// not exceed the kubelet exec stream limits. Steps that exceed
+// the limit are queued and started in order of arrival.
+func (k *Kubernetes) LimitExecs(n int) {
+	k.execs = newExecLimiter(n)
+}
+
+// execLimiter tracks the exec slots of each pipeline pod.
+type execLimiter struct {
+	limit int
+
+	mu   sync.Mutex
+	pods map[string]*execSlots
+}
+
+// execSlots provides the exec slots of a pipeline pod. The
+// slots are released in order of arrival, because blocked
+// channel sends are served in order.
+type execSlots struct {
+	slots chan struct{}
+	refs  int
+}
+
+func newExecLimiter(limit int) *execLimiter {
+	return &execLimiter{
+		limit: limit,
+		pods:  map[string]*execSlots{},
+	}
+}
+
+// acquire blocks until an exec slot in the pod is available,
+// and returns a function that releases the slot. The wait
+// function is invoked if the step is queued. A nil limiter
+// grants slots immediately.
+func (l *execLimiter) acquire(ctx context.Context, pod string, wait func(limit int)) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	s, ok := l.pods[pod]
+	if !ok {
+		s = &execSlots{slots: make(chan struct{}, l.limit)}
+		l.pods[pod] = s
+	}
+	s.refs++
+	l.mu.Unlock()
+
+	done := func() {
+		l.mu.Lock()
+		s.refs--
+		if s.refs == 0 {
+			delete(l.pods, pod)
+		}
+		l.mu.Unlock()
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		wait(l.limit)
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			done()
+			return nil, ctx.Err()
+		}
+	}
+	return func() {
+		<-s.slots
+		done()
+	}, nil
+}
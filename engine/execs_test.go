@@ -0,0 +1,105 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExecLimiter(t *testing.T) {
+	l := newExecLimiter(1)
+	ctx := context.Background()
+
+	release, err := l.acquire(ctx, "default/a", func(int) { t.Errorf("Expect first exec not queued") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// execs in a different pod are not limited.
+	other, err := l.acquire(ctx, "default/b", func(int) { t.Errorf("Expect exec in other pod not queued") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	queued := make(chan struct{})
+	acquired := make(chan struct{})
+	go func() {
+		next, err := l.acquire(ctx, "default/a", func(int) { close(queued) })
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		close(acquired)
+		next()
+	}()
+
+	<-queued
+	select {
+	case <-acquired:
+		t.Fatalf("Expect exec queued until the slot is released")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("Expect queued exec to acquire the released slot")
+	}
+}
+
+func TestExecLimiter_Cancel(t *testing.T) {
+	l := newExecLimiter(1)
+	release, _ := l.acquire(context.Background(), "default/a", func(int) {})
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx, "default/a", func(int) {}); err != context.Canceled {
+		t.Errorf("Want context canceled error, got %v", err)
+	}
+}
+
+func TestExecLimiter_Nil(t *testing.T) {
+	var l *execLimiter
+	release, err := l.acquire(context.Background(), "default/a", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	release()
+}
+
+func TestCancelExec(t *testing.T) {
+	defer func(d time.Duration) { killGrace = d }(killGrace)
+	killGrace = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the exec stream is abandoned if it does not complete
+	// after the grace period.
+	block := make(chan struct{})
+	defer close(block)
+	err := cancelExec(ctx, func(string) error {
+		<-block
+		return nil
+	})("true")
+	if err != context.Canceled {
+		t.Errorf("Want context error for abandoned stream, got %v", err)
+	}
+
+	// the result of the exec stream is returned if it
+	// completes within the grace period.
+	want := errors.New("command terminated with exit code 143")
+	err = cancelExec(ctx, func(string) error {
+		time.Sleep(time.Millisecond)
+		return want
+	})("true")
+	if err != want {
+		t.Errorf("Want exec error, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// the minimum age of pipeline resources that do not have a
+// pipeline pod before they are collected, so that resources
+// created while the pipeline is setup are not collected.
+var gcGrace = 10 * time.Minute
+
+// CollectGarbage enables the garbage collector, which deletes
+// the pipeline resources leaked when a runner process exits
+// between setup and destroy. Pipelines created by a previous
+// process of the named runner are collected immediately, and
+// pipelines created by other runners are collected once they
+// are older than the ttl. A ttl of zero only collects the
+// pipelines created by the named runner.
+func (k *Kubernetes) CollectGarbage(runner string, ttl time.Duration) {
+	k.gc = &collector{
+		runner: runner,
+		ttl:    ttl,
+		active: map[string]struct{}{},
+	}
+}
+
+// Collect periodically deletes the leaked pipeline resources in
+// the namespaces until the context is cancelled. The prefix
+// must match the label prefix used to compile the pipeline.
+func (k *Kubernetes) Collect(ctx context.Context, namespaces []string, prefix string, interval time.Duration) {
+	if k.gc == nil {
+		return
+	}
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		for _, namespace := range namespaces {
+			k.collect(ctx, namespace, prefix)
+		}
+	}
+}
+
+// collector tracks the pipelines that are active in the runner
+// process.
+type collector struct {
+	runner string
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	active map[string]struct{}
+}
+
+// helper function tracks the pipeline until it is untracked.
+func (c *collector) track(spec *Spec) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.active[spec.PodSpec.Namespace+"/"+spec.PodSpec.Name] = struct{}{}
+	c.mu.Unlock()
+}
+
+// helper function untracks the pipeline.
+func (c *collector) untrack(spec *Spec) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.active, spec.PodSpec.Namespace+"/"+spec.PodSpec.Name)
+	c.mu.Unlock()
+}
+
+// helper function returns true if the pipeline is active in
+// the runner process.
+func (c *collector) isActive(namespace, name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.active[namespace+"/"+name]
+	return ok
+}
+
+// helper function returns the pod annotations with the name of
+// the runner that created the pod.
+func (c *collector) annotate(spec *Spec, annotations map[string]string) map[string]string {
+	if c == nil || c.runner == "" {
+		return annotations
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[annotationRunner(labelPrefix(spec))] = c.runner
+	return annotations
+}
+
+// helper function returns true if the pipeline pod was leaked.
+// Pods that are active in the runner process, or retained for
+// debugging, are never leaked.
+func (c *collector) isLeaked(pod *v1.Pod, prefix string, now time.Time) bool {
+	if c.isActive(pod.Namespace, pod.Name) {
+		return false
+	}
+	if _, ok := pod.Annotations[annotationRetain(prefix)]; ok {
+		return false
+	}
+	if c.runner != "" && pod.Annotations[annotationRunner(prefix)] == c.runner {
+		return true
+	}
+	return c.ttl > 0 && now.Sub(pod.CreationTimestamp.Time) > c.ttl
+}
+
+// helper function deletes the leaked pipelines in the namespace,
+// and the pipeline resources that do not have a pipeline pod.
+func (k *Kubernetes) collect(ctx context.Context, namespace, prefix string) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: prefix + "=true",
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("namespace", namespace).
+			Warnln("cannot list pipeline pods")
+		return
+	}
+	now := time.Now()
+	exists := map[string]bool{}
+	for _, pod := range pods.Items {
+		exists[pod.Name] = true
+		if !k.gc.isLeaked(&pod, prefix, now) {
+			continue
+		}
+		logrus.WithField("namespace", namespace).
+			WithField("pod", pod.Name).
+			Infoln("deleting leaked pipeline")
+		if err := k.deleteLeaked(ctx, namespace, prefix, pod.Name, true); err != nil {
+			logrus.WithError(err).
+				WithField("namespace", namespace).
+				WithField("pod", pod.Name).
+				Warnln("cannot delete leaked pipeline")
+		}
+	}
+
+	// the pipeline secrets are created concurrently with the
+	// pipeline pod, and are leaked if the pod is not created.
+	secrets, err := k.client.CoreV1().Secrets(namespace).List(metav1.ListOptions{
+		LabelSelector: labelName(prefix),
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("namespace", namespace).
+			Warnln("cannot list pipeline secrets")
+		return
+	}
+	for _, secret := range secrets.Items {
+		name := secret.Labels[labelName(prefix)]
+		if exists[name] || k.gc.isActive(namespace, name) || now.Sub(secret.CreationTimestamp.Time) < gcGrace {
+			continue
+		}
+		exists[name] = true
+		logrus.WithField("namespace", namespace).
+			WithField("pod", name).
+			Infoln("deleting leaked pipeline resources")
+		if err := k.deleteLeaked(ctx, namespace, prefix, name, false); err != nil {
+			logrus.WithError(err).
+				WithField("namespace", namespace).
+				WithField("pod", name).
+				Warnln("cannot delete leaked pipeline resources")
+		}
+	}
+}
+
+// helper function deletes the pipeline pod, if the pod exists,
+// and the resources that belong to the pipeline. Volume claims
+// that are kept are not labeled with the pipeline name, and
+// are not deleted.
+func (k *Kubernetes) deleteLeaked(ctx context.Context, namespace, prefix, name string, pod bool) error {
+	var result error
+	if pod {
+		k.deletes.wait(priorityLow)
+		err := k.retry(ctx, func() error {
+			return k.client.CoreV1().Pods(namespace).Delete(name, &metav1.DeleteOptions{
+				GracePeriodSeconds: int64ptr(0),
+			})
+		})
+		if err != nil && !kerrors.IsNotFound(err) {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	opts := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", labelName(prefix), name),
+	}
+	del := &metav1.DeleteOptions{}
+	k.deletes.wait(priorityLow)
+	if err := k.client.CoreV1().Secrets(namespace).DeleteCollection(del, opts); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := k.client.CoreV1().ConfigMaps(namespace).DeleteCollection(del, opts); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := k.client.CoreV1().PersistentVolumeClaims(namespace).DeleteCollection(del, opts); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := k.client.NetworkingV1().NetworkPolicies(namespace).DeleteCollection(del, opts); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result
+}
+
+// helper function returns the name of the annotation used to
+// store the name of the runner that created the pod.
+func annotationRunner(prefix string) string {
+	return prefix + ".runner"
+}
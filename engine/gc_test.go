@@ -0,0 +1,62 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCollectorIsLeaked(t *testing.T) {
+	now := time.Now()
+	k := new(Kubernetes)
+	k.CollectGarbage("runner-1", time.Hour)
+
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-abc", Namespace: "default"}}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "drone-abc",
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)),
+			Annotations:       k.gc.annotate(spec, nil),
+		},
+	}
+
+	k.gc.track(spec)
+	if k.gc.isLeaked(pod, DefaultLabelPrefix, now) {
+		t.Errorf("Want active pipeline not leaked")
+	}
+	k.gc.untrack(spec)
+	if !k.gc.isLeaked(pod, DefaultLabelPrefix, now) {
+		t.Errorf("Want pipeline of a previous runner process leaked")
+	}
+
+	pod.Annotations = map[string]string{"io.drone.runner": "runner-2"}
+	if k.gc.isLeaked(pod, DefaultLabelPrefix, now) {
+		t.Errorf("Want pipeline of another runner not leaked before the ttl")
+	}
+	pod.CreationTimestamp = metav1.NewTime(now.Add(-2 * time.Hour))
+	if !k.gc.isLeaked(pod, DefaultLabelPrefix, now) {
+		t.Errorf("Want pipeline of another runner leaked after the ttl")
+	}
+
+	pod.Annotations[annotationRetain(DefaultLabelPrefix)] = now.Format(time.RFC3339)
+	if k.gc.isLeaked(pod, DefaultLabelPrefix, now) {
+		t.Errorf("Want retained pipeline not leaked")
+	}
+}
+
+func TestCollectorDisabled(t *testing.T) {
+	var c *collector
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-abc", Namespace: "default"}}
+	c.track(spec)
+	c.untrack(spec)
+	if got := c.annotate(spec, nil); got != nil {
+		t.Errorf("Want no runner annotation, got %v", got)
+	}
+}
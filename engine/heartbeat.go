@@ -0,0 +1,230 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// EnableHeartbeats enables the heartbeats of the pipeline pods.
+// The pipeline pods are annotated with the time of the last
+// heartbeat, which is updated at the interval while the
+// pipeline is active in the runner process, and with the
+// timeout after which the pod is stale.
+//
+// The heartbeat annotations are the contract with the reaper,
+// which deletes the pods whose heartbeat is stale, so that
+// pods are not leaked if the runner crashes or fails to
+// destroy the pipeline:
+//
+//	<prefix>=true                       label of the pipeline pods
+//	<prefix>.heartbeat                  time of the last heartbeat (RFC 3339)
+//	<prefix>.heartbeat-timeout          seconds after which the pod is stale
+//
+// Pipelines that run in a cluster provided by the pipeline
+// are not annotated, since the reaper runs in the runner
+// cluster.
+func (k *Kubernetes) EnableHeartbeats(interval, timeout time.Duration) {
+	k.heartbeats = &heartbeats{
+		interval: interval,
+		timeout:  timeout,
+		active:   map[string]*Spec{},
+	}
+}
+
+// Heartbeat periodically updates the heartbeat annotation of
+// the active pipeline pods until the context is cancelled.
+func (k *Kubernetes) Heartbeat(ctx context.Context) {
+	h := k.heartbeats
+	if h == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(h.interval):
+		}
+		for _, spec := range h.list() {
+			if err := k.heartbeat(ctx, spec, time.Now()); err != nil {
+				logrus.WithError(err).
+					WithField("pod", spec.PodSpec.Name).
+					Warnln("cannot update the pipeline heartbeat")
+			}
+		}
+	}
+}
+
+// ReapStale periodically deletes the pipeline pods in the
+// namespaces whose heartbeat is stale until the context is
+// cancelled. The prefix must match the label prefix used to
+// compile the pipeline. The reaper does not require the
+// heartbeats to be enabled in the process, so that the reaper
+// can run separately from the runners.
+func (k *Kubernetes) ReapStale(ctx context.Context, namespaces []string, prefix string, interval time.Duration) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	for {
+		for _, namespace := range namespaces {
+			k.reapStale(ctx, namespace, prefix)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// heartbeats tracks the pipelines whose heartbeat is updated
+// by the runner process.
+type heartbeats struct {
+	interval time.Duration
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	active map[string]*Spec
+}
+
+// helper function tracks the pipeline until it is untracked.
+func (h *heartbeats) track(spec *Spec) {
+	if h == nil || spec.Cluster != nil {
+		return
+	}
+	h.mu.Lock()
+	h.active[spec.PodSpec.Namespace+"/"+spec.PodSpec.Name] = spec
+	h.mu.Unlock()
+}
+
+// helper function untracks the pipeline.
+func (h *heartbeats) untrack(spec *Spec) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	delete(h.active, spec.PodSpec.Namespace+"/"+spec.PodSpec.Name)
+	h.mu.Unlock()
+}
+
+// helper function returns the tracked pipelines.
+func (h *heartbeats) list() []*Spec {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var specs []*Spec
+	for _, spec := range h.active {
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// helper function returns the pod annotations with the initial
+// heartbeat and the heartbeat timeout.
+func (h *heartbeats) annotate(spec *Spec, annotations map[string]string, now time.Time) map[string]string {
+	if h == nil || spec.Cluster != nil {
+		return annotations
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	prefix := labelPrefix(spec)
+	annotations[annotationHeartbeat(prefix)] = now.UTC().Format(time.RFC3339)
+	annotations[annotationHeartbeatTimeout(prefix)] = strconv.Itoa(int(h.timeout.Seconds()))
+	return annotations
+}
+
+// helper function updates the heartbeat annotation of the
+// pipeline pod. The pod may not exist yet, for example while
+// the pipeline secrets are created, in which case the pod is
+// annotated with the initial heartbeat when created.
+func (k *Kubernetes) heartbeat(ctx context.Context, spec *Spec, now time.Time) error {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				annotationHeartbeat(labelPrefix(spec)): now.UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	err := k.call(ctx, func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).Pods(spec.PodSpec.Namespace).Patch(spec.PodSpec.Name, types.MergePatchType, patch)
+		return err
+	})
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// helper function deletes the pipeline pods in the namespace
+// whose heartbeat is stale, and the pipeline resources.
+func (k *Kubernetes) reapStale(ctx context.Context, namespace, prefix string) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: prefix + "=true",
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("namespace", namespace).
+			Warnln("cannot list pipeline pods")
+		return
+	}
+	now := time.Now()
+	for _, pod := range pods.Items {
+		if !isStale(&pod, prefix, now) {
+			continue
+		}
+		logrus.WithField("namespace", namespace).
+			WithField("pod", pod.Name).
+			WithField("heartbeat", pod.Annotations[annotationHeartbeat(prefix)]).
+			Infoln("deleting pipeline with a stale heartbeat")
+		if err := k.deleteLeaked(ctx, namespace, prefix, pod.Name, true); err != nil {
+			logrus.WithError(err).
+				WithField("namespace", namespace).
+				WithField("pod", pod.Name).
+				Warnln("cannot delete pipeline with a stale heartbeat")
+		}
+	}
+}
+
+// helper function returns true if the heartbeat of the pipeline
+// pod is stale. Pods without a heartbeat, for example pods
+// created by runners that do not enable heartbeats, and pods
+// retained for debugging are never stale.
+func isStale(pod *v1.Pod, prefix string, now time.Time) bool {
+	if _, ok := pod.Annotations[annotationRetain(prefix)]; ok {
+		return false
+	}
+	heartbeat, err := time.Parse(time.RFC3339, pod.Annotations[annotationHeartbeat(prefix)])
+	if err != nil {
+		return false
+	}
+	timeout, err := strconv.Atoi(pod.Annotations[annotationHeartbeatTimeout(prefix)])
+	if err != nil || timeout <= 0 {
+		return false
+	}
+	return now.Sub(heartbeat) > time.Duration(timeout)*time.Second
+}
+
+// helper function returns the name of the annotation used to
+// store the time of the last heartbeat.
+func annotationHeartbeat(prefix string) string {
+	return prefix + ".heartbeat"
+}
+
+// helper function returns the name of the annotation used to
+// store the heartbeat timeout, in seconds.
+func annotationHeartbeatTimeout(prefix string) string {
+	return prefix + ".heartbeat-timeout"
+}
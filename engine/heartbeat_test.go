@@ -0,0 +1,83 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHeartbeatIsStale(t *testing.T) {
+	now := time.Now()
+	k := new(Kubernetes)
+	k.EnableHeartbeats(time.Minute, 10*time.Minute)
+
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-abc", Namespace: "default"}}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "drone-abc",
+			Namespace:   "default",
+			Annotations: k.heartbeats.annotate(spec, nil, now.Add(-time.Minute)),
+		},
+	}
+	if got, want := pod.Annotations["io.drone.heartbeat-timeout"], "600"; got != want {
+		t.Errorf("Want heartbeat timeout %s, got %s", want, got)
+	}
+	if isStale(pod, DefaultLabelPrefix, now) {
+		t.Errorf("Want recent heartbeat not stale")
+	}
+	if !isStale(pod, DefaultLabelPrefix, now.Add(time.Hour)) {
+		t.Errorf("Want heartbeat stale after the timeout")
+	}
+
+	pod.Annotations[annotationRetain(DefaultLabelPrefix)] = now.Format(time.RFC3339)
+	if isStale(pod, DefaultLabelPrefix, now.Add(time.Hour)) {
+		t.Errorf("Want retained pipeline not stale")
+	}
+
+	pod.Annotations = map[string]string{}
+	if isStale(pod, DefaultLabelPrefix, now.Add(time.Hour)) {
+		t.Errorf("Want pipeline without heartbeat not stale")
+	}
+}
+
+func TestHeartbeatTrack(t *testing.T) {
+	k := new(Kubernetes)
+	k.EnableHeartbeats(time.Minute, 10*time.Minute)
+
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-abc", Namespace: "default"}}
+	k.heartbeats.track(spec)
+	if got := len(k.heartbeats.list()); got != 1 {
+		t.Errorf("Want 1 tracked pipeline, got %d", got)
+	}
+	k.heartbeats.untrack(spec)
+	if got := len(k.heartbeats.list()); got != 0 {
+		t.Errorf("Want 0 tracked pipelines, got %d", got)
+	}
+
+	// pipelines that run in a cluster provided by the pipeline
+	// are not reaped by the runner cluster reaper.
+	remote := &Spec{PodSpec: PodSpec{Name: "drone-def", Namespace: "default"}, Cluster: &Cluster{}}
+	k.heartbeats.track(remote)
+	if got := len(k.heartbeats.list()); got != 0 {
+		t.Errorf("Want remote pipeline not tracked")
+	}
+	if got := k.heartbeats.annotate(remote, nil, time.Now()); got != nil {
+		t.Errorf("Want no heartbeat annotation, got %v", got)
+	}
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+	var h *heartbeats
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-abc", Namespace: "default"}}
+	h.track(spec)
+	h.untrack(spec)
+	if got := h.annotate(spec, nil, time.Now()); got != nil {
+		t.Errorf("Want no heartbeat annotation, got %v", got)
+	}
+}
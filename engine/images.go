@@ -0,0 +1,62 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Images returns the container images of the pipeline pod,
+// resolved to the image digests reported by the kubelet.
+func (k *Kubernetes) Images(ctx context.Context, spec *Spec) ([]*Image, error) {
+	k, err := k.forCluster(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var pod *v1.Pod
+	err = k.call(ctx, func(ctx context.Context) (err error) {
+		pod, err = k.coreV1(ctx).Pods(spec.PodSpec.Namespace).Get(spec.PodSpec.Name, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toImages(pod), nil
+}
+
+// helper function returns the container images of the pod,
+// including the init containers.
+func toImages(pod *v1.Pod) []*Image {
+	var images []*Image
+	var statuses []v1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		images = append(images, &Image{
+			Container: status.Name,
+			Name:      status.Image,
+			Digest:    toDigest(status.ImageID),
+		})
+	}
+	return images
+}
+
+// helper function returns the digest of the image id, for
+// example docker-pullable://alpine@sha256:1a2b. An empty
+// string is returned if the image id has no digest.
+func toDigest(imageID string) string {
+	if i := strings.LastIndex(imageID, "@"); i != -1 {
+		return imageID[i+1:]
+	}
+	if strings.HasPrefix(imageID, "sha256:") {
+		return imageID
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestToImages(t *testing.T) {
+	pod := &v1.Pod{
+		Status: v1.PodStatus{
+			InitContainerStatuses: []v1.ContainerStatus{
+				{Name: "clone", Image: "drone/git:latest", ImageID: "docker-pullable://drone/git@sha256:1a2b"},
+			},
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "build", Image: "docker.io/library/golang:1.12", ImageID: "sha256:3c4d"},
+				{Name: "test", Image: "golang:1.12", ImageID: ""},
+			},
+		},
+	}
+	images := toImages(pod)
+	if got, want := len(images), 3; got != want {
+		t.Fatalf("Want %d images, got %d", want, got)
+	}
+	if got, want := *images[0], (Image{Container: "clone", Name: "drone/git:latest", Digest: "sha256:1a2b"}); got != want {
+		t.Errorf("Want image %v, got %v", want, got)
+	}
+	if got, want := images[1].Digest, "sha256:3c4d"; got != want {
+		t.Errorf("Want digest %s, got %s", want, got)
+	}
+	if got, want := images[2].Digest, ""; got != want {
+		t.Errorf("Want empty digest, got %s", got)
+	}
+}
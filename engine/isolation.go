@@ -0,0 +1,318 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IsolationServiceAccount is the name of the service account
+// created in the namespace of isolated pipelines. The pipeline
+// pod runs as this service account.
+const IsolationServiceAccount = "drone-pipeline"
+
+// Isolation provides the templates of the objects created in
+// the namespace of isolated pipelines, in json format. The
+// name and namespace of the objects are set by the engine.
+type Isolation struct {
+	// Quota provides the resource quota of the namespace.
+	Quota json.RawMessage
+
+	// LimitRange provides the limit range of the namespace.
+	LimitRange json.RawMessage
+
+	// Role provides the role that is bound to the pipeline
+	// service account. The service account has no access to
+	// the kubernetes api if empty.
+	Role json.RawMessage
+}
+
+// isolation provides the decoded isolation templates.
+type isolation struct {
+	quota      *v1.ResourceQuota
+	limitRange *v1.LimitRange
+	role       *rbacv1.Role
+}
+
+// IsolateNamespaces sets the templates of the objects created
+// in the namespace of isolated pipelines. Isolated pipelines
+// are created in an ephemeral namespace, which is created when
+// the pipeline is setup and deleted when it is destroyed.
+func (k *Kubernetes) IsolateNamespaces(templates Isolation) error {
+	iso := new(isolation)
+	if len(templates.Quota) != 0 {
+		iso.quota = new(v1.ResourceQuota)
+		if err := json.Unmarshal(templates.Quota, iso.quota); err != nil {
+			return fmt.Errorf("engine: cannot parse resource quota template: %s", err)
+		}
+	}
+	if len(templates.LimitRange) != 0 {
+		iso.limitRange = new(v1.LimitRange)
+		if err := json.Unmarshal(templates.LimitRange, iso.limitRange); err != nil {
+			return fmt.Errorf("engine: cannot parse limit range template: %s", err)
+		}
+	}
+	if len(templates.Role) != 0 {
+		iso.role = new(rbacv1.Role)
+		if err := json.Unmarshal(templates.Role, iso.role); err != nil {
+			return fmt.Errorf("engine: cannot parse role template: %s", err)
+		}
+	}
+	k.isolation = iso
+	return nil
+}
+
+// helper function creates the namespace of the isolated
+// pipeline, and the objects created from the isolation
+// templates. Objects that already exist, for example when
+// setup is retried, are reused.
+func (k *Kubernetes) setupNamespace(ctx context.Context, spec *Spec) error {
+	create := func(kind string, fn func(ctx context.Context) error) error {
+		err := k.call(ctx, fn)
+		if err != nil && !kerrors.IsAlreadyExists(err) {
+			return fmt.Errorf("engine: cannot create %s in namespace %s: %s", kind, spec.PodSpec.Namespace, err)
+		}
+		return nil
+	}
+
+	err := create("namespace", func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).Namespaces().Create(toNamespace(spec))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = create("service account", func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).ServiceAccounts(spec.PodSpec.Namespace).Create(toIsolationServiceAccount(spec))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	iso := k.isolation
+	if iso == nil {
+		return nil
+	}
+	if iso.quota != nil {
+		err := create("resource quota", func(ctx context.Context) error {
+			_, err := k.coreV1(ctx).ResourceQuotas(spec.PodSpec.Namespace).Create(toIsolationQuota(spec, iso.quota))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if iso.limitRange != nil {
+		err := create("limit range", func(ctx context.Context) error {
+			_, err := k.coreV1(ctx).LimitRanges(spec.PodSpec.Namespace).Create(toIsolationLimitRange(spec, iso.limitRange))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if iso.role != nil {
+		err := create("role", func(ctx context.Context) error {
+			_, err := k.rbacV1(ctx).Roles(spec.PodSpec.Namespace).Create(toIsolationRole(spec, iso.role))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = create("role binding", func(ctx context.Context) error {
+			_, err := k.rbacV1(ctx).RoleBindings(spec.PodSpec.Namespace).Create(toIsolationRoleBinding(spec))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function deletes the namespace of the isolated
+// pipeline. The pipeline resources are deleted with the
+// namespace.
+func (k *Kubernetes) deleteNamespace(ctx context.Context, spec *Spec) error {
+	policy := metav1.DeletePropagationBackground
+	err := k.call(ctx, func(ctx context.Context) error {
+		return k.coreV1(ctx).Namespaces().Delete(spec.PodSpec.Namespace, &metav1.DeleteOptions{
+			PropagationPolicy: &policy,
+		})
+	})
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ReapNamespaces deletes the namespaces of isolated pipelines
+// that are older than the ttl, for example namespaces orphaned
+// when the runner exits before the pipeline is destroyed. The
+// ttl must exceed the maximum pipeline duration. ReapNamespaces
+// blocks until the context is cancelled. The prefix must match
+// the label prefix used to compile the pipeline.
+func (k *Kubernetes) ReapNamespaces(ctx context.Context, prefix string, ttl, interval time.Duration) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		k.reapNamespaces(ctx, prefix, ttl)
+	}
+}
+
+// helper function deletes the expired namespaces.
+func (k *Kubernetes) reapNamespaces(ctx context.Context, prefix string, ttl time.Duration) {
+	client := k.client.CoreV1().Namespaces()
+	list, err := client.List(metav1.ListOptions{
+		LabelSelector: labelIsolated(prefix) + "=true",
+	})
+	if err != nil {
+		logrus.WithError(err).
+			Warnln("cannot list isolated namespaces")
+		return
+	}
+	now := time.Now()
+	for _, ns := range list.Items {
+		if !isExpiredNamespace(ns, ttl, now) {
+			continue
+		}
+		policy := metav1.DeletePropagationBackground
+		err := k.retry(ctx, func() error {
+			return client.Delete(ns.Name, &metav1.DeleteOptions{
+				PropagationPolicy: &policy,
+			})
+		})
+		if err != nil && !kerrors.IsNotFound(err) {
+			logrus.WithError(err).
+				WithField("namespace", ns.Name).
+				Warnln("cannot delete isolated namespace")
+			continue
+		}
+		logrus.WithField("namespace", ns.Name).
+			Infoln("deleted orphaned isolated namespace")
+	}
+}
+
+// helper function returns true if the namespace is older than
+// the ttl and is not already being deleted.
+func isExpiredNamespace(ns v1.Namespace, ttl time.Duration, now time.Time) bool {
+	if ns.DeletionTimestamp != nil {
+		return false
+	}
+	return now.Sub(ns.CreationTimestamp.Time) > ttl
+}
+
+// helper function returns the name of the label used to
+// identify the namespaces of isolated pipelines.
+func labelIsolated(prefix string) string {
+	return prefix + ".isolated"
+}
+
+// helper function returns the labels of the objects created
+// in the namespace of the isolated pipeline.
+func toIsolationLabels(spec *Spec) map[string]string {
+	prefix := labelPrefix(spec)
+	return map[string]string{
+		prefix:            "true",
+		labelName(prefix): spec.PodSpec.Name,
+	}
+}
+
+func toNamespace(spec *Spec) *v1.Namespace {
+	labels := toIsolationLabels(spec)
+	labels[labelIsolated(labelPrefix(spec))] = "true"
+	return &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   spec.PodSpec.Namespace,
+			Labels: labels,
+		},
+	}
+}
+
+func toIsolationServiceAccount(spec *Spec) *v1.ServiceAccount {
+	return &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      IsolationServiceAccount,
+			Namespace: spec.PodSpec.Namespace,
+			Labels:    toIsolationLabels(spec),
+		},
+	}
+}
+
+func toIsolationQuota(spec *Spec, template *v1.ResourceQuota) *v1.ResourceQuota {
+	quota := template.DeepCopy()
+	quota.ObjectMeta = toIsolationMeta(spec, template.ObjectMeta)
+	return quota
+}
+
+func toIsolationLimitRange(spec *Spec, template *v1.LimitRange) *v1.LimitRange {
+	limits := template.DeepCopy()
+	limits.ObjectMeta = toIsolationMeta(spec, template.ObjectMeta)
+	return limits
+}
+
+func toIsolationRole(spec *Spec, template *rbacv1.Role) *rbacv1.Role {
+	role := template.DeepCopy()
+	role.ObjectMeta = toIsolationMeta(spec, metav1.ObjectMeta{})
+	return role
+}
+
+func toIsolationRoleBinding(spec *Spec) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: toIsolationMeta(spec, metav1.ObjectMeta{}),
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     spec.PodSpec.Name,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      IsolationServiceAccount,
+				Namespace: spec.PodSpec.Namespace,
+			},
+		},
+	}
+}
+
+// helper function returns the metadata of an object created
+// from a template. The template name is used if defined, and
+// the template labels are merged with the pipeline labels.
+func toIsolationMeta(spec *Spec, template metav1.ObjectMeta) metav1.ObjectMeta {
+	name := template.Name
+	if name == "" {
+		name = spec.PodSpec.Name
+	}
+	labels := map[string]string{}
+	for k, v := range template.Labels {
+		labels[k] = v
+	}
+	for k, v := range toIsolationLabels(spec) {
+		labels[k] = v
+	}
+	return metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   spec.PodSpec.Namespace,
+		Labels:      labels,
+		Annotations: template.Annotations,
+	}
+}
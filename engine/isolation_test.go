@@ -0,0 +1,100 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestToNamespace(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-abc123",
+			Namespace: "drone-abc123",
+			Isolated:  true,
+		},
+	}
+	ns := toNamespace(spec)
+	if got, want := ns.Name, "drone-abc123"; got != want {
+		t.Errorf("Want namespace %s, got %s", want, got)
+	}
+	if got := ns.Labels["io.drone.isolated"]; got != "true" {
+		t.Errorf("Want isolated label, got %q", got)
+	}
+	if got := ns.Labels["io.drone.name"]; got != "drone-abc123" {
+		t.Errorf("Want name label, got %q", got)
+	}
+}
+
+func TestIsolateNamespaces(t *testing.T) {
+	k := new(Kubernetes)
+	err := k.IsolateNamespaces(Isolation{
+		Quota: []byte(`{"metadata":{"name":"compute","labels":{"team":"ci"}},"spec":{"hard":{"pods":"10"}}}`),
+		Role:  []byte(`{"rules":[{"apiGroups":[""],"resources":["pods"],"verbs":["get","list"]}]}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.isolation.limitRange != nil {
+		t.Errorf("Want no limit range without a template")
+	}
+
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-abc123",
+			Namespace: "drone-abc123",
+		},
+	}
+	quota := toIsolationQuota(spec, k.isolation.quota)
+	if got, want := quota.Name, "compute"; got != want {
+		t.Errorf("Want template name %s, got %s", want, got)
+	}
+	if got, want := quota.Namespace, "drone-abc123"; got != want {
+		t.Errorf("Want namespace %s, got %s", want, got)
+	}
+	if quota.Labels["team"] != "ci" || quota.Labels["io.drone.name"] != "drone-abc123" {
+		t.Errorf("Want template and pipeline labels, got %v", quota.Labels)
+	}
+	if _, ok := quota.Spec.Hard[v1.ResourcePods]; !ok {
+		t.Errorf("Want quota spec copied from the template")
+	}
+
+	role := toIsolationRole(spec, k.isolation.role)
+	binding := toIsolationRoleBinding(spec)
+	if got, want := binding.RoleRef.Name, role.Name; got != want {
+		t.Errorf("Want binding to role %s, got %s", want, got)
+	}
+	if got, want := binding.Subjects[0].Name, IsolationServiceAccount; got != want {
+		t.Errorf("Want binding to service account %s, got %s", want, got)
+	}
+
+	if err := k.IsolateNamespaces(Isolation{LimitRange: []byte(`{"spec":[]}`)}); err == nil {
+		t.Errorf("Want error parsing invalid template")
+	}
+}
+
+func TestIsExpiredNamespace(t *testing.T) {
+	now := time.Now()
+	ns := v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+		},
+	}
+	if !isExpiredNamespace(ns, time.Hour, now) {
+		t.Errorf("Want namespace older than the ttl expired")
+	}
+	if isExpiredNamespace(ns, 3*time.Hour, now) {
+		t.Errorf("Want namespace younger than the ttl not expired")
+	}
+	deleted := metav1.NewTime(now)
+	ns.DeletionTimestamp = &deleted
+	if isExpiredNamespace(ns, time.Hour, now) {
+		t.Errorf("Want namespace being deleted not expired")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultJanitorResources provides the resources deleted by
+// the janitor if no resources are configured, in the
+// group/version/resource format. Core resources omit the group.
+var DefaultJanitorResources = []string{
+	"v1/namespaces",
+	"v1/pods",
+	"v1/services",
+	"v1/configmaps",
+	"v1/secrets",
+	"v1/persistentvolumeclaims",
+	"apps/v1/deployments",
+	"apps/v1/statefulsets",
+	"apps/v1/daemonsets",
+	"batch/v1/jobs",
+	"networking.k8s.io/v1/ingresses",
+}
+
+// janitor deletes the leftover resources created by the
+// pipeline steps.
+type janitor struct {
+	namespaces []string
+	resources  []schema.GroupVersionResource
+}
+
+// EnableJanitor enables the janitor, which deletes the
+// resources created by the pipeline steps once the pipeline
+// completes. The steps label the resources they create with
+// the label in the DRONE_JANITOR_LABEL variable, and the
+// labeled resources are deleted from the namespaces. Labeled
+// namespaces are deleted with their content.
+func (k *Kubernetes) EnableJanitor(namespaces, resources []string) error {
+	if len(resources) == 0 {
+		resources = DefaultJanitorResources
+	}
+	j := &janitor{namespaces: namespaces}
+	for _, s := range resources {
+		gvr, err := parseResource(s)
+		if err != nil {
+			return err
+		}
+		j.resources = append(j.resources, gvr)
+	}
+	k.janitor = j
+	return nil
+}
+
+// helper function deletes the resources labeled by the
+// pipeline steps. Errors are logged and do not fail the
+// pipeline.
+func (k *Kubernetes) cleanupLeftovers(ctx context.Context, spec *Spec) {
+	if k.janitor == nil || spec.Janitor == "" {
+		return
+	}
+	selector := labelJanitor(labelPrefix(spec)) + "=" + spec.Janitor
+	for _, gvr := range k.janitor.resources {
+		// namespaces are cluster-scoped, and are only deleted
+		// if they are designated.
+		if gvr.Group == "" && gvr.Resource == "namespaces" {
+			k.cleanupNamespaces(ctx, spec, selector)
+			continue
+		}
+		for _, namespace := range k.janitor.namespaces {
+			// wildcard namespaces only match the namespaces
+			// that are deleted with their content.
+			if strings.HasSuffix(namespace, "*") {
+				continue
+			}
+			k.cleanupResource(ctx, spec, gvr, namespace, selector)
+		}
+	}
+}
+
+// helper function deletes the labeled resources in the
+// namespace.
+func (k *Kubernetes) cleanupResource(ctx context.Context, spec *Spec, gvr schema.GroupVersionResource, namespace, selector string) {
+	logger := logrus.
+		WithField("pod", spec.PodSpec.Name).
+		WithField("namespace", namespace).
+		WithField("resource", gvr.Resource)
+	client := k.dynamic.Resource(gvr).Namespace(namespace)
+	list, err := client.List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		if !kerrors.IsNotFound(err) {
+			logger.WithError(err).Warnln("cannot list leftover resources")
+		}
+		return
+	}
+	background := metav1.DeletePropagationBackground
+	for _, item := range list.Items {
+		if ctx.Err() != nil {
+			return
+		}
+		k.deletes.wait(priorityLow)
+		err := client.Delete(item.GetName(), &metav1.DeleteOptions{PropagationPolicy: &background})
+		if err != nil && !kerrors.IsNotFound(err) {
+			logger.WithError(err).
+				WithField("name", item.GetName()).
+				Warnln("cannot delete leftover resource")
+		}
+	}
+}
+
+// helper function deletes the labeled namespaces that match a
+// designated namespace. A namespace that matches the pipeline
+// namespace is never deleted.
+func (k *Kubernetes) cleanupNamespaces(ctx context.Context, spec *Spec, selector string) {
+	list, err := k.client.CoreV1().Namespaces().List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			Warnln("cannot list leftover namespaces")
+		return
+	}
+	for _, item := range list.Items {
+		if ctx.Err() != nil {
+			return
+		}
+		if item.Name == spec.PodSpec.Namespace || !k.janitor.designated(item.Name) {
+			continue
+		}
+		k.deletes.wait(priorityLow)
+		err := k.client.CoreV1().Namespaces().Delete(item.Name, &metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			logrus.WithError(err).
+				WithField("pod", spec.PodSpec.Name).
+				WithField("namespace", item.Name).
+				Warnln("cannot delete leftover namespace")
+		}
+	}
+}
+
+// helper function returns true if the namespace matches a
+// designated namespace. Designated namespaces ending with a
+// wildcard match the namespaces with the prefix, for example
+// test-* matches test-42.
+func (j *janitor) designated(namespace string) bool {
+	for _, s := range j.namespaces {
+		if s == namespace {
+			return true
+		}
+		if strings.HasSuffix(s, "*") && strings.HasPrefix(namespace, strings.TrimSuffix(s, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function parses the resource in the
+// group/version/resource format. Core resources omit the group.
+func parseResource(s string) (schema.GroupVersionResource, error) {
+	parts := strings.Split(s, "/")
+	switch len(parts) {
+	case 2:
+		return schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}, nil
+	case 3:
+		return schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}, nil
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("janitor: invalid resource: %s", s)
+}
+
+// helper function returns the name of the label used to
+// identify the resources created by the pipeline steps.
+func labelJanitor(prefix string) string {
+	return prefix + ".janitor"
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestEnableJanitor(t *testing.T) {
+	k := new(Kubernetes)
+	if err := k.EnableJanitor(nil, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := len(k.janitor.resources), len(DefaultJanitorResources); got != want {
+		t.Errorf("Want %d default resources, got %d", want, got)
+	}
+
+	err := k.EnableJanitor(nil, []string{"pods"})
+	if err == nil {
+		t.Errorf("Want invalid resource error")
+	}
+}
+
+func TestParseResource(t *testing.T) {
+	tests := []struct {
+		in   string
+		want schema.GroupVersionResource
+	}{
+		{
+			in:   "v1/pods",
+			want: schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+		},
+		{
+			in:   "apps/v1/deployments",
+			want: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		},
+	}
+	for _, test := range tests {
+		got, err := parseResource(test.in)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Want resource %v, got %v", test.want, got)
+		}
+	}
+	for _, in := range []string{"", "pods", "a/b/c/d"} {
+		if _, err := parseResource(in); err == nil {
+			t.Errorf("Want error parsing resource %q", in)
+		}
+	}
+}
+
+func TestJanitorDesignated(t *testing.T) {
+	j := &janitor{namespaces: []string{"preview", "test-*"}}
+	tests := []struct {
+		namespace string
+		want      bool
+	}{
+		{"preview", true},
+		{"test-42", true},
+		{"test-", true},
+		{"previews", false},
+		{"default", false},
+		{"test", false},
+	}
+	for _, test := range tests {
+		if got := j.designated(test.namespace); got != test.want {
+			t.Errorf("Want designated %v for namespace %s, got %v", test.want, test.namespace, got)
+		}
+	}
+}
+
+func TestJanitorDisabled(t *testing.T) {
+	// the cleanup is a no-op if the janitor is disabled, or
+	// if the pipeline does not provide the janitor label.
+	k := new(Kubernetes)
+	k.cleanupLeftovers(context.Background(), &Spec{Janitor: "1"})
+	k.EnableJanitor(nil, nil)
+	k.cleanupLeftovers(context.Background(), &Spec{})
+}
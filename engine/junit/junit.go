@@ -0,0 +1,96 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package junit parses JUnit XML test reports.
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type (
+	// Summary provides the test report summary.
+	Summary struct {
+		Tests    int
+		Failures int
+		Errors   int
+		Skipped  int
+		Failed   []string
+	}
+
+	testsuite struct {
+		Name      string      `xml:"name,attr"`
+		Cases     []testcase  `xml:"testcase"`
+		Testsuite []testsuite `xml:"testsuite"`
+	}
+
+	testcase struct {
+		Name      string    `xml:"name,attr"`
+		Classname string    `xml:"classname,attr"`
+		Failure   *struct{} `xml:"failure"`
+		Error     *struct{} `xml:"error"`
+		Skipped   *struct{} `xml:"skipped"`
+	}
+)
+
+// Parse parses one or more concatenated JUnit XML reports and
+// returns the combined summary. Both testsuites and testsuite
+// root elements are supported.
+func Parse(r io.Reader) (*Summary, error) {
+	summary := new(Summary)
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return summary, nil
+		}
+		if err != nil {
+			return summary, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		suite := new(testsuite)
+		if err := decoder.DecodeElement(suite, &start); err != nil {
+			return summary, err
+		}
+		summary.add(suite)
+	}
+}
+
+// String returns a human-readable summary.
+func (s *Summary) String() string {
+	return fmt.Sprintf("%d tests, %d passed, %d failed, %d errors, %d skipped",
+		s.Tests, s.Tests-s.Failures-s.Errors-s.Skipped, s.Failures, s.Errors, s.Skipped)
+}
+
+func (s *Summary) add(suite *testsuite) {
+	for _, c := range suite.Cases {
+		s.Tests++
+		switch {
+		case c.Failure != nil:
+			s.Failures++
+			s.Failed = append(s.Failed, c.fullname())
+		case c.Error != nil:
+			s.Errors++
+			s.Failed = append(s.Failed, c.fullname())
+		case c.Skipped != nil:
+			s.Skipped++
+		}
+	}
+	for i := range suite.Testsuite {
+		s.add(&suite.Testsuite[i])
+	}
+}
+
+func (c *testcase) fullname() string {
+	if c.Classname == "" {
+		return c.Name
+	}
+	return strings.Join([]string{c.Classname, c.Name}, ".")
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package junit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("testdata/report.xml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+
+	got, err := Parse(f)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := &Summary{
+		Tests:    5,
+		Failures: 1,
+		Errors:   1,
+		Skipped:  1,
+		Failed:   []string{"math.TestSub", "io.TestWrite"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf(diff)
+	}
+	if got, want := got.String(), "5 tests, 2 passed, 1 failed, 1 errors, 1 skipped"; got != want {
+		t.Errorf("Want summary %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// keepAliveByte is written to the standard error of the step
+// script to keep the exec stream active. The byte is removed
+// from the step output.
+const keepAliveByte = 0
+
+// helper function returns the step command with a background
+// loop that periodically writes the keep-alive byte to the
+// standard error, so that proxies do not close the exec stream
+// of steps that do not write output for a long time. The loop
+// is stopped when the command exits.
+func toKeepAliveCommand(command string, interval int64) string {
+	return fmt.Sprintf(
+		`(while sleep %d </dev/null >/dev/null 2>&1; do printf '\0' >&2; done) & _drone_keepalive=$!; %s; _drone_exit=$?; kill $_drone_keepalive 2>/dev/null; exit $_drone_exit`,
+		interval, command,
+	)
+}
+
+// keepAliveWriter removes the keep-alive bytes from the step
+// output.
+type keepAliveWriter struct {
+	w io.Writer
+}
+
+func (k *keepAliveWriter) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, keepAliveByte) == -1 {
+		return k.w.Write(p)
+	}
+	if _, err := k.w.Write(bytes.Replace(p, []byte{keepAliveByte}, nil, -1)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestKeepAliveWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := &keepAliveWriter{w: buf}
+
+	n, err := w.Write([]byte("linking\x00\x00 done\n"))
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := n, 15; got != want {
+		t.Errorf("Want %d bytes written, got %d", want, got)
+	}
+	if got, want := buf.String(), "linking done\n"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+func TestKeepAliveCommand(t *testing.T) {
+	got := toKeepAliveCommand(`echo "$DRONE_SCRIPT" | sh`, 30)
+	if !strings.Contains(got, "while sleep 30 ") {
+		t.Errorf("Expect keep-alive interval in command, got %s", got)
+	}
+	if !strings.Contains(got, `; echo "$DRONE_SCRIPT" | sh; _drone_exit=$?;`) {
+		t.Errorf("Expect step command and exit code preserved, got %s", got)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+type (
+	// PodEvent describes a pipeline pod lifecycle event.
+	PodEvent struct {
+		Namespace string            `json:"namespace"`
+		Pod       string            `json:"pod"`
+		UID       string            `json:"uid,omitempty"`
+		Labels    map[string]string `json:"labels,omitempty"`
+		Time      time.Time         `json:"time"`
+		Error     string            `json:"error,omitempty"`
+	}
+
+	// StepEvent describes a pipeline step lifecycle event.
+	// The exit code and duration are only set when the step
+	// is finished.
+	StepEvent struct {
+		PodEvent
+		Step      string  `json:"step"`
+		Container string  `json:"container"`
+		Image     string  `json:"image"`
+		ExitCode  int     `json:"exit_code"`
+		OOMKilled bool    `json:"oom_killed,omitempty"`
+		TimedOut  bool    `json:"timed_out,omitempty"`
+		Duration  float64 `json:"duration_seconds,omitempty"`
+	}
+
+	// LifecycleObserver receives the pipeline lifecycle events,
+	// for example to push the events to external systems. The
+	// observer is called synchronously and must not block.
+	LifecycleObserver interface {
+		OnPodCreated(context.Context, *PodEvent)
+		OnStepStarted(context.Context, *StepEvent)
+		OnStepFinished(context.Context, *StepEvent)
+		OnDestroy(context.Context, *PodEvent)
+	}
+)
+
+// Observe registers the lifecycle observers.
+func (k *Kubernetes) Observe(observers ...LifecycleObserver) {
+	k.observers = append(k.observers, observers...)
+}
+
+// EmitEvents records the pipeline lifecycle events as events
+// of the pipeline pod, so that cluster operators can correlate
+// build activity with cluster behavior.
+func (k *Kubernetes) EmitEvents() {
+	k.Observe(&eventRecorder{
+		client: k.client,
+		uids:   map[string]string{},
+	})
+}
+
+// helper function returns the pod event of the pipeline.
+func toPodEvent(spec *Spec, err error) *PodEvent {
+	event := &PodEvent{
+		Namespace: spec.PodSpec.Namespace,
+		Pod:       spec.PodSpec.Name,
+		Labels:    spec.PodSpec.Labels,
+		Time:      time.Now(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
+
+// helper function returns the step event of the pipeline
+// step, with the step state if the step is finished.
+func toStepEvent(spec *Spec, step *Step, state *State, err error) *StepEvent {
+	event := &StepEvent{
+		PodEvent:  *toPodEvent(spec, err),
+		Step:      step.Name,
+		Container: step.ID,
+		Image:     step.Image,
+	}
+	if state != nil {
+		event.ExitCode = state.ExitCode
+		event.OOMKilled = state.OOMKilled
+		event.TimedOut = state.TimedOut
+	}
+	return event
+}
+
+// eventRecorder records the lifecycle events as events of the
+// pipeline pod.
+type eventRecorder struct {
+	client kubernetes.Interface
+
+	mu   sync.Mutex
+	uids map[string]string
+}
+
+func (r *eventRecorder) OnPodCreated(ctx context.Context, e *PodEvent) {
+	r.mu.Lock()
+	r.uids[e.Namespace+"/"+e.Pod] = e.UID
+	r.mu.Unlock()
+	r.record(e, "", v1.EventTypeNormal, "PipelineCreated", "Pipeline created")
+}
+
+func (r *eventRecorder) OnStepStarted(ctx context.Context, e *StepEvent) {
+	r.record(&e.PodEvent, e.Container, v1.EventTypeNormal, "StepStarted",
+		fmt.Sprintf("Step %s started", e.Step))
+}
+
+func (r *eventRecorder) OnStepFinished(ctx context.Context, e *StepEvent) {
+	switch {
+	case e.Error != "":
+		r.record(&e.PodEvent, e.Container, v1.EventTypeWarning, "StepFailed",
+			fmt.Sprintf("Step %s failed: %s", e.Step, e.Error))
+	case e.ExitCode != 0:
+		r.record(&e.PodEvent, e.Container, v1.EventTypeWarning, "StepFailed",
+			fmt.Sprintf("Step %s exited with code %d after %.0fs", e.Step, e.ExitCode, e.Duration))
+	default:
+		r.record(&e.PodEvent, e.Container, v1.EventTypeNormal, "StepSucceeded",
+			fmt.Sprintf("Step %s succeeded after %.0fs", e.Step, e.Duration))
+	}
+}
+
+func (r *eventRecorder) OnDestroy(ctx context.Context, e *PodEvent) {
+	uid := r.uid(e)
+	r.mu.Lock()
+	delete(r.uids, e.Namespace+"/"+e.Pod)
+	r.mu.Unlock()
+
+	// the pod is deleted, but the event is retained until the
+	// event ttl expires.
+	if e.Error != "" {
+		r.create(toEvent(e, uid, "", v1.EventTypeWarning, "PipelineDestroyed", "Pipeline destroyed with errors: "+e.Error))
+	} else {
+		r.create(toEvent(e, uid, "", v1.EventTypeNormal, "PipelineDestroyed", "Pipeline destroyed"))
+	}
+}
+
+func (r *eventRecorder) record(e *PodEvent, container, kind, reason, message string) {
+	r.create(toEvent(e, r.uid(e), container, kind, reason, message))
+}
+
+// helper function returns the uid of the pipeline pod.
+func (r *eventRecorder) uid(e *PodEvent) string {
+	if e.UID != "" {
+		return e.UID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.uids[e.Namespace+"/"+e.Pod]
+}
+
+// helper function creates the event in the background, so
+// that the pipeline is not blocked by the api server.
+func (r *eventRecorder) create(event *v1.Event) {
+	go func() {
+		_, err := r.client.CoreV1().Events(event.Namespace).Create(event)
+		if err != nil {
+			logrus.WithError(err).
+				WithField("pod", event.InvolvedObject.Name).
+				WithField("reason", event.Reason).
+				Debugln("cannot record pipeline event")
+		}
+	}()
+}
+
+// helper function returns the kubernetes event of the pod
+// lifecycle event. Step events refer to the step container.
+func toEvent(e *PodEvent, uid, container, kind, reason, message string) *v1.Event {
+	ref := v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  e.Namespace,
+		Name:       e.Pod,
+		UID:        types.UID(uid),
+	}
+	if container != "" {
+		ref.FieldPath = "spec.containers{" + container + "}"
+	}
+	now := metav1.NewTime(e.Time)
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: e.Pod + ".",
+			Namespace:    e.Namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           kind,
+		Source: v1.EventSource{
+			Component: "drone-runner-kube",
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestToStepEvent(t *testing.T) {
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-abc", Namespace: "default"}}
+	step := &Step{ID: "drone-step", Name: "build", Image: "golang"}
+	event := toStepEvent(spec, step, &State{ExitCode: 2, OOMKilled: true}, nil)
+	if event.Pod != "drone-abc" || event.Step != "build" || event.Container != "drone-step" {
+		t.Errorf("Want step event to reference the pod and step")
+	}
+	if event.ExitCode != 2 || !event.OOMKilled {
+		t.Errorf("Want step event with the step state")
+	}
+	event = toStepEvent(spec, step, nil, errors.New("exec stream disconnected"))
+	if got, want := event.Error, "exec stream disconnected"; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+}
+
+func TestToEvent(t *testing.T) {
+	e := toPodEvent(&Spec{PodSpec: PodSpec{Name: "drone-abc", Namespace: "default"}}, nil)
+	event := toEvent(e, "uid-1", "drone-step", "Warning", "StepFailed", "Step build exited with code 1")
+	if got, want := event.InvolvedObject.FieldPath, "spec.containers{drone-step}"; got != want {
+		t.Errorf("Want field path %q, got %q", want, got)
+	}
+	if event.InvolvedObject.UID != "uid-1" || event.InvolvedObject.Name != "drone-abc" {
+		t.Errorf("Want event to reference the pipeline pod")
+	}
+	if event.Namespace != "default" || event.GenerateName != "drone-abc." {
+		t.Errorf("Want event created in the pod namespace")
+	}
+}
@@ -7,11 +7,14 @@ package linter
 import (
 	"errors"
 	"fmt"
+	"net"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/bmatcuk/doublestar"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/internal/offline"
 )
 
 // ErrDuplicateStepName is returned when two Pipeline steps
@@ -30,9 +33,90 @@ var ErrCyclicalDependency = errors.New("linter: cyclical step dependency detecte
 // Opts provides linting options.
 type Opts struct {
 	Trusted   bool
+	Fork      bool
 	Namespace string
 	Name      string
 	Slug      string
+	Remote    string
+}
+
+// Policy provides the runner capabilities and volume policy.
+// Pipelines that request unsupported capabilities are
+// rejected before they are compiled.
+type Policy struct {
+	// NFS provides a list of nfs server patterns that
+	// pipelines are allowed to mount.
+	NFS []string
+
+	// CSI provides a list of csi driver patterns that
+	// pipelines are allowed to mount.
+	CSI []string
+
+	// Platforms provides a list of supported platforms in
+	// os/arch format. If empty, all platforms are supported.
+	Platforms []string
+
+	// MaxCPU provides the maximum cpu, in millicores, that a
+	// step can request or be limited to.
+	MaxCPU int64
+
+	// MaxMemory provides the maximum memory, in bytes, that a
+	// step can request or be limited to.
+	MaxMemory int64
+
+	// Domains provides a list of internal domains. If not
+	// empty, pipeline images and the clone url must be hosted
+	// by an internal domain.
+	Domains []string
+
+	// NodeRepos provides a list of repository patterns that
+	// are allowed to run node steps. Node steps are disabled
+	// if empty.
+	NodeRepos []string
+
+	// KVMRepos provides a list of repository patterns that
+	// are allowed to request the kvm device. The kvm device
+	// is disabled if empty.
+	KVMRepos []string
+
+	// NodeSelectors provides a list of node label patterns in
+	// key=value format that pipelines are allowed to use in
+	// node selectors and node affinity. If empty, all node
+	// labels are allowed.
+	NodeSelectors []string
+
+	// ZoneLabel and RegionLabel provide the node labels
+	// selected by the pipeline zone and region, which are
+	// subject to the node selector policy.
+	ZoneLabel   string
+	RegionLabel string
+
+	// CloneOptions is true if the configured clone image
+	// supports the sparse checkout, partial clone, mirror,
+	// submodule credentials, shallow since and commit pinning
+	// options. The default clone image does not, and the
+	// options are rejected.
+	CloneOptions bool
+
+	// Tolerations provides a list of taint patterns in
+	// key=value format that pipelines are allowed to
+	// tolerate. If empty, all taints can be tolerated.
+	Tolerations []string
+
+	// RuntimeClasses provides a list of runtime class
+	// patterns that pipelines are allowed to use. If empty,
+	// all runtime classes are allowed.
+	RuntimeClasses []string
+
+	// Vault provides a list of vault secret path patterns
+	// that pipelines are allowed to read. Vault secrets are
+	// disabled if empty.
+	Vault []string
+
+	// ResourceProfiles provides the names of the resource
+	// profiles defined by the runner. Pipelines and steps
+	// can only reference a defined profile.
+	ResourceProfiles []string
 }
 
 // Linter evaluates the pipeline against a set of
@@ -40,11 +124,12 @@ type Opts struct {
 // rules are broken.
 type Linter struct {
 	patterns map[string][]string
+	policy   Policy
 }
 
 // New returns a new Linter.
-func New(patterns map[string][]string) *Linter {
-	return &Linter{patterns: patterns}
+func New(patterns map[string][]string, policy Policy) *Linter {
+	return &Linter{patterns: patterns, policy: policy}
 }
 
 // Lint executes the linting rules for the pipeline
@@ -56,12 +141,110 @@ func (l *Linter) Lint(pipeline *resource.Pipeline, opts Opts) error {
 	if err := checkVolumes(pipeline, opts.Trusted); err != nil {
 		return err
 	}
+	if err := checkCache(pipeline.Cache); err != nil {
+		return err
+	}
+	if err := checkArtifacts(pipeline); err != nil {
+		return err
+	}
+	if err := checkDedupe(pipeline); err != nil {
+		return err
+	}
+	if err := checkPolicy(pipeline, l.policy); err != nil {
+		return err
+	}
+	if err := checkCapabilities(pipeline, l.policy); err != nil {
+		return err
+	}
+	if err := checkPlacement(pipeline, l.policy); err != nil {
+		return err
+	}
+	if err := checkClone(pipeline.Clone, l.policy.CloneOptions); err != nil {
+		return err
+	}
+	if err := checkLocale(pipeline); err != nil {
+		return err
+	}
+	if err := checkTopology(pipeline, l.policy); err != nil {
+		return err
+	}
+	if err := checkCluster(pipeline); err != nil {
+		return err
+	}
+	if err := checkOffline(pipeline, opts.Remote, l.policy.Domains); err != nil {
+		return err
+	}
+	if err := checkNode(pipeline, opts, l.policy.NodeRepos); err != nil {
+		return err
+	}
+	if err := checkKVM(pipeline, opts, l.policy.KVMRepos); err != nil {
+		return err
+	}
 	if err := checkNamespace(pipeline.Metadata.Namespace, opts.Slug, l.patterns); err != nil {
 		return err
 	}
 	return nil
 }
 
+// patterns match the timezone names of the timezone database,
+// and the locale names.
+var (
+	timezonePattern = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+	localePattern   = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+)
+
+func checkLocale(pipeline *resource.Pipeline) error {
+	if tz := pipeline.Timezone; tz != "" && !timezonePattern.MatchString(tz) {
+		return fmt.Errorf("linter: invalid timezone: %s", tz)
+	}
+	if lang := pipeline.Locale; lang != "" && !localePattern.MatchString(lang) {
+		return fmt.Errorf("linter: invalid locale: %s", lang)
+	}
+	return nil
+}
+
+// topologyPattern matches the zone and region names, which
+// must be valid label values.
+var topologyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.-]{0,61}[A-Za-z0-9])?$`)
+
+func checkTopology(pipeline *resource.Pipeline, policy Policy) error {
+	if zone := pipeline.Zone; zone != "" {
+		if !topologyPattern.MatchString(zone) {
+			return fmt.Errorf("linter: invalid zone: %s", zone)
+		}
+		if label := policy.ZoneLabel; label != "" && !matchPolicy(policy.NodeSelectors, label+"="+zone) {
+			return fmt.Errorf("linter: zone not allowed: %s", zone)
+		}
+	}
+	if region := pipeline.Region; region != "" {
+		if !topologyPattern.MatchString(region) {
+			return fmt.Errorf("linter: invalid region: %s", region)
+		}
+		if label := policy.RegionLabel; label != "" && !matchPolicy(policy.NodeSelectors, label+"="+region) {
+			return fmt.Errorf("linter: region not allowed: %s", region)
+		}
+	}
+	return nil
+}
+
+func checkCluster(pipeline *resource.Pipeline) error {
+	cluster := pipeline.Cluster
+	if cluster == nil {
+		return nil
+	}
+	if cluster.Kubeconfig == nil || cluster.Kubeconfig.Secret == "" {
+		return errors.New("linter: cluster kubeconfig must be sourced from a secret")
+	}
+	// node steps are executed by the node helper pods of the
+	// runner cluster.
+	for _, step := range pipeline.Steps {
+		if step.Node {
+			return fmt.Errorf("linter: node steps cannot run in the pipeline cluster: %s", step.Name)
+		}
+	}
+	return nil
+}
+
 func checkSteps(pipeline *resource.Pipeline, trusted bool) error {
 	steps := append(pipeline.Services, pipeline.Steps...)
 	for _, step := range steps {
@@ -69,24 +252,214 @@ func checkSteps(pipeline *resource.Pipeline, trusted bool) error {
 			return err
 		}
 	}
+	for _, step := range pipeline.Steps {
+		if step.Readiness != nil {
+			return fmt.Errorf("linter: readiness is only supported for services: %s", step.Name)
+		}
+	}
+	for _, service := range pipeline.Services {
+		if service.Schedule != "" {
+			return fmt.Errorf("linter: best_effort_schedule is not supported for services: %s", service.Name)
+		}
+		if service.Readiness != nil {
+			if err := checkReadiness(service.Readiness); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkReadiness(ready *resource.Readiness) error {
+	if ready.Port < 1 || ready.Port > 65535 {
+		return fmt.Errorf("linter: invalid readiness port: %d", ready.Port)
+	}
+	if ready.Path != "" && !strings.HasPrefix(ready.Path, "/") {
+		return fmt.Errorf("linter: invalid readiness path: %s", ready.Path)
+	}
+	if ready.Timeout < 0 {
+		return errors.New("linter: readiness timeout cannot be negative")
+	}
 	return nil
 }
 
 func checkStep(step *resource.Step, trusted bool) error {
-	if step.Image == "" {
+	if step.Publish != nil {
+		if err := checkPublish(step); err != nil {
+			return err
+		}
+	} else if step.Deploy != nil {
+		if err := checkDeploy(step, trusted); err != nil {
+			return err
+		}
+	} else if step.Image == "" {
 		return errors.New("linter: invalid or missing image")
 	}
 	if trusted == false && step.Privileged {
 		return errors.New("linter: untrusted repositories cannot enable privileged mode")
 	}
+	if step.Coverage != nil && (step.Coverage.Threshold < 0 || step.Coverage.Threshold > 100) {
+		return errors.New("linter: coverage threshold must be between 0 and 100")
+	}
+	if step.Timeout < 0 {
+		return errors.New("linter: step timeout cannot be negative")
+	}
+	switch strings.ToLower(step.Schedule) {
+	case "", "off_peak":
+	default:
+		return fmt.Errorf("linter: invalid best_effort_schedule: %s", step.Schedule)
+	}
+	for _, file := range step.EnvFile {
+		if filepath.IsAbs(file) || hasDotDot(file) {
+			return fmt.Errorf("linter: invalid env_file: %s", file)
+		}
+	}
+	for name, src := range step.EnvFrom {
+		if err := checkEnvFrom(name, src, trusted); err != nil {
+			return err
+		}
+	}
+	if step.WaitFor != nil {
+		if err := checkWaitFor(step.WaitFor); err != nil {
+			return err
+		}
+	}
+	for _, tmpfs := range step.Tmpfs {
+		if err := checkTmpfs(tmpfs); err != nil {
+			return err
+		}
+	}
 	for _, mount := range step.Volumes {
 		switch mount.Name {
-		case "workspace", "_workspace", "_docker_socket", "_status":
+		case "workspace", "_workspace", "_docker_socket", "_status", "_metadata", "_shell", "_apt_mirror", "_localtime", "_deploy":
 			return fmt.Errorf("linter: invalid volume name: %s", mount.Name)
 		}
 		if strings.HasPrefix(filepath.Clean(mount.MountPath), "/run/drone") {
 			return fmt.Errorf("linter: cannot mount volume at /run/drone")
 		}
+		if filepath.IsAbs(mount.SubPath) || hasDotDot(mount.SubPath) {
+			return fmt.Errorf("linter: invalid volume sub_path: %s", mount.SubPath)
+		}
+		switch mount.Propagation {
+		case "", "None", "HostToContainer":
+		case "Bidirectional":
+			if step.Privileged == false {
+				return errors.New("linter: bidirectional mount propagation requires privileged mode")
+			}
+		default:
+			return fmt.Errorf("linter: invalid mount propagation: %s", mount.Propagation)
+		}
+	}
+	return nil
+}
+
+func checkPublish(step *resource.Step) error {
+	publish := step.Publish
+	if step.Image != "" || len(step.Commands) != 0 {
+		return fmt.Errorf("linter: publish step cannot define an image or commands: %s", step.Name)
+	}
+	if publish.Repo == "" {
+		return fmt.Errorf("linter: invalid or missing publish repo: %s", step.Name)
+	}
+	switch strings.ToLower(publish.Builder) {
+	case "", "kaniko", "buildah":
+	default:
+		return fmt.Errorf("linter: invalid publish builder: %s", publish.Builder)
+	}
+	if filepath.IsAbs(publish.Context) || hasDotDot(publish.Context) {
+		return fmt.Errorf("linter: invalid publish context: %s", publish.Context)
+	}
+	if (publish.Username == nil) != (publish.Password == nil) {
+		return fmt.Errorf("linter: publish username and password must be defined together: %s", step.Name)
+	}
+	return nil
+}
+
+func checkTmpfs(tmpfs *resource.Tmpfs) error {
+	if tmpfs == nil || !filepath.IsAbs(tmpfs.Path) {
+		return errors.New("linter: tmpfs path must be absolute")
+	}
+	if strings.HasPrefix(filepath.Clean(tmpfs.Path), "/run/drone") {
+		return errors.New("linter: cannot mount tmpfs at /run/drone")
+	}
+	if tmpfs.Size < 0 {
+		return errors.New("linter: tmpfs size cannot be negative")
+	}
+	return nil
+}
+
+func checkDeploy(step *resource.Step, trusted bool) error {
+	deploy := step.Deploy
+	if step.Image != "" || len(step.Commands) != 0 {
+		return fmt.Errorf("linter: deploy step cannot define an image or commands: %s", step.Name)
+	}
+	switch strings.ToLower(deploy.Tool) {
+	case "", "helm":
+		if deploy.Chart == "" || deploy.Release == "" {
+			return fmt.Errorf("linter: invalid or missing deploy chart or release: %s", step.Name)
+		}
+	case "kustomize":
+		if filepath.IsAbs(deploy.Path) || hasDotDot(deploy.Path) {
+			return fmt.Errorf("linter: invalid deploy path: %s", deploy.Path)
+		}
+	default:
+		return fmt.Errorf("linter: invalid deploy tool: %s", deploy.Tool)
+	}
+	if deploy.Kubeconfig != nil && deploy.Kubeconfig.Secret == "" {
+		return fmt.Errorf("linter: deploy kubeconfig must be sourced from a secret: %s", step.Name)
+	}
+	if trusted == false && deploy.Kubeconfig == nil {
+		return errors.New("linter: untrusted repositories cannot deploy with the pipeline service account")
+	}
+	return nil
+}
+
+func checkEnvFrom(name string, src *resource.EnvFrom, trusted bool) error {
+	if src == nil {
+		return fmt.Errorf("linter: invalid env_from: %s", name)
+	}
+	var refs int
+	if src.Secret != nil {
+		refs++
+		if src.Secret.Name == "" || src.Secret.Key == "" {
+			return fmt.Errorf("linter: invalid env_from: %s", name)
+		}
+	}
+	if src.ConfigMap != nil {
+		refs++
+		if src.ConfigMap.Name == "" || src.ConfigMap.Key == "" {
+			return fmt.Errorf("linter: invalid env_from: %s", name)
+		}
+	}
+	if src.Vault != nil {
+		refs++
+		if src.Vault.Path == "" || src.Vault.Key == "" {
+			return fmt.Errorf("linter: invalid env_from: %s", name)
+		}
+	}
+	if refs != 1 {
+		return fmt.Errorf("linter: invalid env_from: %s", name)
+	}
+	if trusted == false && (src.Secret != nil || src.ConfigMap != nil) {
+		return errors.New("linter: untrusted repositories cannot read secrets or config maps")
+	}
+	return nil
+}
+
+func checkWaitFor(wait *resource.WaitFor) error {
+	if wait.HTTP == "" && wait.TCP == "" && wait.File == "" && wait.Command == "" {
+		return errors.New("linter: wait_for requires an http, tcp, file or command condition")
+	}
+	if wait.HTTP != "" && !strings.HasPrefix(wait.HTTP, "http://") && !strings.HasPrefix(wait.HTTP, "https://") {
+		return fmt.Errorf("linter: invalid wait_for http address: %s", wait.HTTP)
+	}
+	if wait.TCP != "" {
+		if _, _, err := net.SplitHostPort(wait.TCP); err != nil {
+			return fmt.Errorf("linter: invalid wait_for tcp address: %s", wait.TCP)
+		}
+	}
+	if wait.Timeout < 0 {
+		return errors.New("linter: wait_for timeout cannot be negative")
 	}
 	return nil
 }
@@ -105,16 +478,179 @@ func checkVolumes(pipeline *resource.Pipeline, trusted bool) error {
 				return err
 			}
 		}
+		if volume.Projected != nil {
+			err := checkProjectedVolume(volume.Projected, trusted)
+			if err != nil {
+				return err
+			}
+		}
+		if volume.Claim != nil {
+			err := checkClaimVolume(volume.Claim, trusted)
+			if err != nil {
+				return err
+			}
+		}
+		if trusted == false && (volume.Secret != nil || volume.ConfigMap != nil) {
+			return errors.New("linter: untrusted repositories cannot mount secrets or config maps")
+		}
 		switch volume.Name {
 		case "":
 			return fmt.Errorf("linter: missing volume name")
-		case "workspace", "_workspace", "_docker_socket", "_status":
+		case "workspace", "_workspace", "_docker_socket", "_status", "_metadata", "_shell", "_apt_mirror", "_localtime":
 			return fmt.Errorf("linter: invalid volume name: %s", volume.Name)
 		}
 	}
 	return nil
 }
 
+func checkPolicy(pipeline *resource.Pipeline, policy Policy) error {
+	for _, volume := range pipeline.Volumes {
+		if volume.NFS != nil && !matchAny(policy.NFS, volume.NFS.Server) {
+			return fmt.Errorf("linter: nfs server not allowed: %s", volume.NFS.Server)
+		}
+		if volume.CSI != nil && !matchAny(policy.CSI, volume.CSI.Driver) {
+			return fmt.Errorf("linter: csi driver not allowed: %s", volume.CSI.Driver)
+		}
+	}
+	for _, step := range append(pipeline.Services, pipeline.Steps...) {
+		for _, src := range step.EnvFrom {
+			if src != nil && src.Vault != nil && !matchAny(policy.Vault, src.Vault.Path) {
+				return fmt.Errorf("linter: vault path not allowed: %s", src.Vault.Path)
+			}
+		}
+	}
+	return nil
+}
+
+func checkCapabilities(pipeline *resource.Pipeline, policy Policy) error {
+	if len(policy.Platforms) != 0 {
+		os, arch := pipeline.Platform.OS, pipeline.Platform.Arch
+		if os == "" {
+			os = "linux"
+		}
+		if arch == "" {
+			arch = "amd64"
+		}
+		platform := os + "/" + arch
+		if !matchAny(policy.Platforms, platform) {
+			return fmt.Errorf("linter: unsupported platform: %s", platform)
+		}
+	}
+	for _, res := range []resource.ResourceObject{pipeline.Resources.Limits, pipeline.Resources.Requests} {
+		if policy.MaxCPU > 0 && res.CPU > policy.MaxCPU {
+			return fmt.Errorf("linter: pipeline exceeds the maximum cpu of %dm", policy.MaxCPU)
+		}
+		if policy.MaxMemory > 0 && int64(res.Memory) > policy.MaxMemory {
+			return fmt.Errorf("linter: pipeline exceeds the maximum memory of %d bytes", policy.MaxMemory)
+		}
+	}
+	if !checkProfile(policy.ResourceProfiles, pipeline.Resources.Profile) {
+		return fmt.Errorf("linter: unknown resource profile: %s", pipeline.Resources.Profile)
+	}
+	steps := append(pipeline.Services, pipeline.Steps...)
+	for _, step := range steps {
+		if !checkProfile(policy.ResourceProfiles, step.Resources.Profile) {
+			return fmt.Errorf("linter: unknown resource profile: %s", step.Resources.Profile)
+		}
+		for _, res := range []resource.ResourceObject{step.Resources.Limits, step.Resources.Requests} {
+			if policy.MaxCPU > 0 && res.CPU > policy.MaxCPU {
+				return fmt.Errorf("linter: step %s exceeds the maximum cpu of %dm", step.Name, policy.MaxCPU)
+			}
+			if policy.MaxMemory > 0 && int64(res.Memory) > policy.MaxMemory {
+				return fmt.Errorf("linter: step %s exceeds the maximum memory of %d bytes", step.Name, policy.MaxMemory)
+			}
+		}
+	}
+	return nil
+}
+
+// helper function returns true if the resource profile is
+// empty, or is defined by the runner.
+func checkProfile(profiles []string, name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, profile := range profiles {
+		if profile == name {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns an error if the pipeline node
+// selector, node affinity, tolerations or runtime class are
+// not allowed by the policy.
+func checkPlacement(pipeline *resource.Pipeline, policy Policy) error {
+	for k, v := range pipeline.NodeSelector {
+		if !matchPolicy(policy.NodeSelectors, k+"="+v) {
+			return fmt.Errorf("linter: node selector not allowed: %s=%s", k, v)
+		}
+	}
+	if affinity := pipeline.Affinity; affinity != nil {
+		reqs := append(affinity.Required, affinity.Preferred...)
+		for _, req := range reqs {
+			if req.Weight < 0 || req.Weight > 100 {
+				return fmt.Errorf("linter: node affinity weight must be between 1 and 100: %s", req.Key)
+			}
+			values := req.Values
+			if len(values) == 0 {
+				values = []string{""}
+			}
+			for _, v := range values {
+				if !matchPolicy(policy.NodeSelectors, req.Key+"="+v) {
+					return fmt.Errorf("linter: node affinity not allowed: %s=%s", req.Key, v)
+				}
+			}
+		}
+	}
+	for _, toleration := range pipeline.Tolerations {
+		if !matchPolicy(policy.Tolerations, toleration.Key+"="+toleration.Value) {
+			return fmt.Errorf("linter: toleration not allowed: %s=%s", toleration.Key, toleration.Value)
+		}
+	}
+	if name := pipeline.RuntimeClassName; name != "" && !matchPolicy(policy.RuntimeClasses, name) {
+		return fmt.Errorf("linter: runtime class not allowed: %s", name)
+	}
+	return nil
+}
+
+// helper function returns true if the name matches the
+// policy patterns, or if the policy is empty.
+func matchPolicy(patterns []string, name string) bool {
+	return len(patterns) == 0 || matchAny(patterns, name)
+}
+
+// helper function returns an error if a pipeline image or
+// the clone url is not hosted by an internal domain. The
+// pipeline is not checked if no domains are configured.
+func checkOffline(pipeline *resource.Pipeline, remote string, domains []string) error {
+	if len(domains) == 0 {
+		return nil
+	}
+	steps := append(pipeline.Services, pipeline.Steps...)
+	for _, step := range steps {
+		if !offline.MatchImage(domains, step.Image) {
+			return fmt.Errorf("linter: image not hosted by an internal domain: %s", step.Image)
+		}
+	}
+	if remote != "" && !pipeline.Clone.Disable && !offline.MatchURL(domains, remote) {
+		return fmt.Errorf("linter: clone url not hosted by an internal domain: %s", remote)
+	}
+	return nil
+}
+
+// helper function returns true if the name matches any of
+// the patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func checkHostPathVolume(volume *resource.VolumeHostPath, trusted bool) error {
 	if trusted == false {
 		return errors.New("linter: untrusted repositories cannot mount host volumes")
@@ -122,6 +658,93 @@ func checkHostPathVolume(volume *resource.VolumeHostPath, trusted bool) error {
 	return nil
 }
 
+func checkProjectedVolume(volume *resource.VolumeProjected, trusted bool) error {
+	for _, source := range volume.Sources {
+		if trusted == false && (source.Secret != nil || source.ConfigMap != nil) {
+			return errors.New("linter: untrusted repositories cannot mount projected secrets or config maps")
+		}
+	}
+	return nil
+}
+
+func checkCache(cache *resource.Cache) error {
+	if cache == nil {
+		return nil
+	}
+	for _, p := range cache.Paths {
+		clean := filepath.Clean(p)
+		if p == "" || clean == "/" || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("linter: invalid cache path: %q", p)
+		}
+	}
+	return nil
+}
+
+func checkArtifacts(pipeline *resource.Pipeline) error {
+	if export := pipeline.Export; export != nil {
+		for _, p := range export.Paths {
+			clean := filepath.Clean(p)
+			if p == "" || clean == "/" || clean == ".." || strings.HasPrefix(clean, "../") || filepath.IsAbs(clean) {
+				return fmt.Errorf("linter: invalid export path: %q", p)
+			}
+		}
+	}
+	// artifacts can only be imported from the pipelines the
+	// pipeline depends on, which complete before the pipeline
+	// starts.
+	for _, name := range pipeline.Import {
+		var found bool
+		for _, dep := range pipeline.Deps {
+			if dep == name {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("linter: cannot import the artifacts of a pipeline that is not a dependency: %s", name)
+		}
+	}
+	return nil
+}
+
+// helper function returns an error if a step that is executed
+// once for identical pipelines has side effects. Only the exit
+// code of the step is shared with the pipelines that reuse the
+// result, so the files written by the step, for example to
+// volumes or test reports, are not.
+func checkDedupe(pipeline *resource.Pipeline) error {
+	for _, step := range pipeline.Steps {
+		if !step.Dedupe {
+			continue
+		}
+		switch {
+		case step.Detach:
+			return fmt.Errorf("linter: detached steps cannot be deduplicated: %s", step.Name)
+		case len(step.Volumes) != 0:
+			return fmt.Errorf("linter: steps that mount volumes cannot be deduplicated: %s", step.Name)
+		case step.Coverage != nil || len(step.JUnit) != 0:
+			return fmt.Errorf("linter: steps that produce reports cannot be deduplicated: %s", step.Name)
+		}
+		for _, other := range pipeline.Steps {
+			for _, dep := range other.DependsOn {
+				if dep == step.Name {
+					return fmt.Errorf("linter: step %s cannot depend on the deduplicated step %s, because the files written by the step are not shared", other.Name, step.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkClaimVolume(volume *resource.VolumeClaim, trusted bool) error {
+	if volume.Name == "" && volume.Size <= 0 {
+		return errors.New("linter: volume claim requires a size or the name of an existing claim")
+	}
+	if trusted == false && volume.Name != "" {
+		return errors.New("linter: untrusted repositories cannot mount existing volume claims")
+	}
+	return nil
+}
+
 func checkEmptyDirVolume(volume *resource.VolumeEmptyDir, trusted bool) error {
 	if trusted == false && volume.Medium == "memory" {
 		return errors.New("linter: untrusted repositories cannot mount in-memory volumes")
@@ -129,6 +752,105 @@ func checkEmptyDirVolume(volume *resource.VolumeEmptyDir, trusted bool) error {
 	return nil
 }
 
+func checkClone(clone resource.Clone, supported bool) error {
+	switch filter := clone.Filter; {
+	case filter == "",
+		filter == "blob:none",
+		filter == "tree:0",
+		strings.HasPrefix(filter, "blob:limit="):
+	default:
+		return fmt.Errorf("linter: invalid clone filter: %s", filter)
+	}
+	for _, path := range clone.Sparse {
+		if path == "" || strings.HasPrefix(filepath.Clean(path), "..") {
+			return fmt.Errorf("linter: invalid sparse checkout path: %s", path)
+		}
+	}
+	switch creds := clone.SubmoduleCredentials; creds {
+	case "", "same-host", "all", "none":
+	default:
+		return fmt.Errorf("linter: invalid submodule credentials: %s", creds)
+	}
+	switch fallback := clone.PinFallback; fallback {
+	case "", "ref", "full", "fail":
+	default:
+		return fmt.Errorf("linter: invalid clone pin fallback: %s", fallback)
+	}
+	if clone.Pin && (clone.Depth > 0 || clone.ShallowSince != "") {
+		return errors.New("linter: clone pin_commit cannot be combined with depth or shallow_since")
+	}
+	if supported {
+		return nil
+	}
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"sparse_checkout", len(clone.Sparse) > 0},
+		{"filter", clone.Filter != ""},
+		{"mirror", clone.Mirror != ""},
+		{"submodule_credentials", clone.SubmoduleCredentials != ""},
+		{"shallow_since", clone.ShallowSince != ""},
+		{"pin_commit", clone.Pin},
+	} {
+		if option.set {
+			return fmt.Errorf("linter: clone %s is not supported by the clone image", option.name)
+		}
+	}
+	return nil
+}
+
+func checkNode(pipeline *resource.Pipeline, opts Opts, repos []string) error {
+	for _, step := range pipeline.Services {
+		if step.Node {
+			return errors.New("linter: services cannot run on the node")
+		}
+	}
+	for _, step := range pipeline.Steps {
+		if !step.Node {
+			continue
+		}
+		if opts.Trusted == false {
+			return errors.New("linter: untrusted repositories cannot run node steps")
+		}
+		// pull requests from forked repositories are untrusted,
+		// even if the target repository is trusted.
+		if opts.Fork {
+			return errors.New("linter: pull requests from forked repositories cannot run node steps")
+		}
+		if !matchAny(repos, opts.Slug) {
+			return errors.New("linter: repository restricted from running node steps")
+		}
+		if step.Detach {
+			return errors.New("linter: node steps cannot be detached")
+		}
+		for _, env := range step.Environment {
+			if env != nil && env.Secret != "" {
+				return errors.New("linter: node steps cannot use secrets")
+			}
+		}
+		for _, param := range step.Settings {
+			if param != nil && param.Secret != "" {
+				return errors.New("linter: node steps cannot use secrets")
+			}
+		}
+	}
+	return nil
+}
+
+func checkKVM(pipeline *resource.Pipeline, opts Opts, repos []string) error {
+	steps := append(pipeline.Services, pipeline.Steps...)
+	for _, step := range steps {
+		if step.KVM && opts.Fork {
+			return errors.New("linter: pull requests from forked repositories cannot use the kvm device")
+		}
+		if step.KVM && !matchAny(repos, opts.Slug) {
+			return errors.New("linter: repository restricted from using the kvm device")
+		}
+	}
+	return nil
+}
+
 func checkNamespace(namespace, name string, mapping map[string][]string) error {
 	if len(mapping) == 0 {
 		return nil
@@ -147,3 +869,14 @@ func checkNamespace(namespace, name string, mapping map[string][]string) error {
 	}
 	return errors.New("linter: pipeline restricted from using configured namespace")
 }
+
+// helper function returns true if the path contains a
+// parent directory element.
+func hasDotDot(path string) bool {
+	for _, s := range strings.Split(filepath.ToSlash(path), "/") {
+		if s == ".." {
+			return true
+		}
+	}
+	return false
+}
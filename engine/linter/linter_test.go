@@ -16,10 +16,13 @@ func TestLint(t *testing.T) {
 	tests := []struct {
 		path     string
 		trusted  bool
+		fork     bool
 		invalid  bool
 		message  string
 		repo     string
+		remote   string
 		patterns map[string][]string
+		policy   Policy
 	}{
 		{
 			path:    "testdata/simple.yml",
@@ -31,6 +34,52 @@ func TestLint(t *testing.T) {
 			invalid: true,
 			message: "linter: invalid or missing image",
 		},
+		{
+			path:    "testdata/publish.yml",
+			invalid: false,
+		},
+		{
+			path:    "testdata/publish_missing_repo.yml",
+			invalid: true,
+			message: "linter: invalid or missing publish repo: publish",
+		},
+		{
+			path:    "testdata/deploy.yml",
+			invalid: false,
+		},
+		{
+			path:    "testdata/deploy_service_account.yml",
+			trusted: true,
+			invalid: false,
+		},
+		{
+			path:    "testdata/deploy_service_account.yml",
+			invalid: true,
+			message: "linter: untrusted repositories cannot deploy with the pipeline service account",
+		},
+		{
+			path:    "testdata/cluster.yml",
+			invalid: false,
+		},
+		{
+			path:    "testdata/cluster_kubeconfig.yml",
+			invalid: true,
+			message: "linter: cluster kubeconfig must be sourced from a secret",
+		},
+		{
+			path:    "testdata/off_peak.yml",
+			invalid: false,
+		},
+		{
+			path:    "testdata/off_peak_invalid.yml",
+			invalid: true,
+			message: "linter: invalid best_effort_schedule: nightly",
+		},
+		{
+			path:    "testdata/tmpfs_relative_path.yml",
+			invalid: true,
+			message: "linter: tmpfs path must be absolute",
+		},
 		// user should not use reserved volume names.
 		{
 			path:    "testdata/volume_missing_name.yml",
@@ -57,6 +106,76 @@ func TestLint(t *testing.T) {
 			trusted: true,
 			invalid: false,
 		},
+		// user should not be able to project secrets or
+		// config maps unless the repository is trusted.
+		{
+			path:    "testdata/volume_projected.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot mount projected secrets or config maps",
+		},
+		{
+			path:    "testdata/volume_projected.yml",
+			trusted: true,
+			invalid: false,
+		},
+		// user should not be able to mount secrets, config
+		// maps or existing volume claims unless the
+		// repository is trusted.
+		{
+			path:    "testdata/volume_secret.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot mount secrets or config maps",
+		},
+		{
+			path:    "testdata/volume_secret.yml",
+			trusted: true,
+			invalid: false,
+		},
+		{
+			path:    "testdata/volume_claim.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot mount existing volume claims",
+		},
+		{
+			path:    "testdata/volume_claim.yml",
+			trusted: true,
+			invalid: false,
+		},
+		{
+			path:    "testdata/volume_claim_size.yml",
+			trusted: true,
+			invalid: true,
+			message: "linter: volume claim requires a size or the name of an existing claim",
+		},
+		// user should not be able to cache directories
+		// outside of the workspace using relative paths.
+		{
+			path:    "testdata/cache_path.yml",
+			invalid: true,
+			message: `linter: invalid cache path: "../../etc"`,
+		},
+		// user should not be able to import the artifacts
+		// of a pipeline that is not a dependency.
+		{
+			path:    "testdata/artifacts_import.yml",
+			invalid: true,
+			message: "linter: cannot import the artifacts of a pipeline that is not a dependency: build",
+		},
+		// user should not be able to depend on the files
+		// written by a deduplicated step.
+		{
+			path:    "testdata/dedupe_depends.yml",
+			invalid: true,
+			message: "linter: step publish cannot depend on the deduplicated step build, because the files written by the step are not shared",
+		},
+		{
+			path:    "testdata/dedupe_volume.yml",
+			invalid: true,
+			message: "linter: steps that mount volumes cannot be deduplicated: build",
+		},
 		// user should be able to mount emptyDir volumes
 		// where no medium is specified.
 		{
@@ -86,6 +205,55 @@ func TestLint(t *testing.T) {
 			invalid: true,
 			message: "linter: cannot mount volume at /run/drone",
 		},
+		{
+			path:    "testdata/coverage_threshold.yml",
+			invalid: true,
+			message: "linter: coverage threshold must be between 0 and 100",
+		},
+		{
+			path:    "testdata/step_timeout.yml",
+			invalid: true,
+			message: "linter: step timeout cannot be negative",
+		},
+		// user should not be able to mount a volume sub_path
+		// outside of the volume.
+		{
+			path:    "testdata/volume_sub_path.yml",
+			invalid: true,
+			message: "linter: invalid volume sub_path: ../cache",
+		},
+		// user should not be able to read an env_file outside
+		// of the workspace.
+		{
+			path:    "testdata/env_file.yml",
+			invalid: true,
+			message: "linter: invalid env_file: ../../etc/passwd",
+		},
+		// user should not be able to wait for a tcp address
+		// without a port.
+		{
+			path:    "testdata/wait_for.yml",
+			invalid: true,
+			message: "linter: invalid wait_for tcp address: database",
+		},
+		{
+			path: "testdata/readiness.yml",
+		},
+		// user should not be able to configure a readiness
+		// probe for a step that is not a service.
+		{
+			path:    "testdata/readiness_step.yml",
+			invalid: true,
+			message: "linter: readiness is only supported for services: test",
+		},
+		// user should not be able to use bidirectional mount
+		// propagation unless the step is privileged.
+		{
+			path:    "testdata/volume_propagation.yml",
+			trusted: true,
+			invalid: true,
+			message: "linter: bidirectional mount propagation requires privileged mode",
+		},
 		// user should not be able to set the securityContext
 		// unless the repository is trusted.
 		{
@@ -99,6 +267,289 @@ func TestLint(t *testing.T) {
 			trusted: true,
 			invalid: false,
 		},
+		// user should not be able to use an unsupported
+		// partial clone filter.
+		{
+			path:    "testdata/clone_filter.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: invalid clone filter: blob:all",
+		},
+		{
+			path:    "testdata/clone_submodules.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: invalid submodule credentials: everyone",
+		},
+		{
+			path:    "testdata/clone_pin.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: clone pin_commit cannot be combined with depth or shallow_since",
+		},
+		// user should only be able to use the clone options
+		// that are supported by the clone image.
+		{
+			path:    "testdata/clone_sparse.yml",
+			invalid: true,
+			message: "linter: clone sparse_checkout is not supported by the clone image",
+		},
+		{
+			path:   "testdata/clone_sparse.yml",
+			policy: Policy{CloneOptions: true},
+		},
+		{
+			path:    "testdata/clone_mirror.yml",
+			invalid: true,
+			message: "linter: clone mirror is not supported by the clone image",
+		},
+		{
+			path:    "testdata/clone_shallow.yml",
+			invalid: true,
+			message: "linter: clone shallow_since is not supported by the clone image",
+		},
+		// user should only be able to mount nfs and csi
+		// volumes that match the allow-list.
+		{
+			path:    "testdata/volume_nfs.yml",
+			invalid: true,
+			message: "linter: nfs server not allowed: nfs.example.com",
+		},
+		{
+			path:   "testdata/volume_nfs.yml",
+			policy: Policy{NFS: []string{"*.example.com"}},
+		},
+		{
+			path:    "testdata/volume_csi.yml",
+			invalid: true,
+			policy:  Policy{CSI: []string{"secrets-store.csi.k8s.io"}},
+			message: "linter: csi driver not allowed: inline.storage.kubernetes.io",
+		},
+		{
+			path:   "testdata/volume_csi.yml",
+			policy: Policy{CSI: []string{"inline.storage.kubernetes.io"}},
+		},
+		// user should not be able to request capabilities
+		// that are not supported by the runner.
+		{
+			path:    "testdata/simple.yml",
+			invalid: true,
+			policy:  Policy{Platforms: []string{"linux/arm64"}},
+			message: "linter: unsupported platform: linux/amd64",
+		},
+		{
+			path:   "testdata/simple.yml",
+			policy: Policy{Platforms: []string{"linux/*"}},
+		},
+		{
+			path:    "testdata/resources.yml",
+			invalid: true,
+			policy:  Policy{MaxCPU: 1000},
+			message: "linter: step test exceeds the maximum cpu of 1000m",
+		},
+		{
+			path:    "testdata/resources.yml",
+			invalid: true,
+			policy:  Policy{MaxMemory: 1073741824},
+			message: "linter: step test exceeds the maximum memory of 1073741824 bytes",
+		},
+		{
+			path:    "testdata/resources_pipeline.yml",
+			invalid: true,
+			policy:  Policy{MaxCPU: 1000},
+			message: "linter: pipeline exceeds the maximum cpu of 1000m",
+		},
+		{
+			path:   "testdata/resources_pipeline.yml",
+			policy: Policy{MaxCPU: 4000, MaxMemory: 4294967296},
+		},
+		{
+			path:    "testdata/resources_profile.yml",
+			invalid: true,
+			policy:  Policy{ResourceProfiles: []string{"small"}},
+			message: "linter: unknown resource profile: gpu",
+		},
+		{
+			path:   "testdata/resources_profile.yml",
+			policy: Policy{ResourceProfiles: []string{"small", "gpu"}},
+		},
+		{
+			path:    "testdata/locale_invalid.yml",
+			invalid: true,
+			message: "linter: invalid timezone: ../../etc/shadow",
+		},
+		{
+			path: "testdata/topology.yml",
+		},
+		{
+			path:    "testdata/topology_invalid.yml",
+			invalid: true,
+			message: "linter: invalid zone: us-east1-c/../b",
+		},
+		{
+			path:    "testdata/topology.yml",
+			invalid: true,
+			policy: Policy{
+				NodeSelectors: []string{"topology.kubernetes.io/zone=us-east1-b"},
+				ZoneLabel:     "topology.kubernetes.io/zone",
+				RegionLabel:   "topology.kubernetes.io/region",
+			},
+			message: "linter: zone not allowed: us-east1-c",
+		},
+		{
+			path: "testdata/topology.yml",
+			policy: Policy{
+				NodeSelectors: []string{"topology.kubernetes.io/*=us-east1*"},
+				ZoneLabel:     "topology.kubernetes.io/zone",
+				RegionLabel:   "topology.kubernetes.io/region",
+			},
+		},
+		// user should only be able to read secrets and config
+		// maps if the repository is trusted, and vault secrets
+		// that match the allow-list.
+		{
+			path:    "testdata/env_from.yml",
+			trusted: true,
+			invalid: true,
+			message: "linter: vault path not allowed: secret/data/ci/deploy",
+		},
+		{
+			path:    "testdata/env_from.yml",
+			invalid: true,
+			policy:  Policy{Vault: []string{"secret/data/ci/*"}},
+			message: "linter: untrusted repositories cannot read secrets or config maps",
+		},
+		{
+			path:    "testdata/env_from.yml",
+			trusted: true,
+			policy:  Policy{Vault: []string{"secret/data/ci/*"}},
+		},
+		{
+			path:    "testdata/env_from_invalid.yml",
+			trusted: true,
+			invalid: true,
+			message: "linter: invalid env_from: DB_PASSWORD",
+		},
+		// user should only be able to steer the pipeline pod
+		// to nodes that match the allow-list.
+		{
+			path: "testdata/placement.yml",
+		},
+		{
+			path:    "testdata/placement.yml",
+			invalid: true,
+			policy:  Policy{NodeSelectors: []string{"kubernetes.io/arch=*"}},
+			message: "linter: node selector not allowed: pool=gpu",
+		},
+		{
+			path:    "testdata/placement.yml",
+			invalid: true,
+			policy:  Policy{NodeSelectors: []string{"pool=*"}},
+			message: "linter: node affinity not allowed: kubernetes.io/arch=arm64",
+		},
+		{
+			path:    "testdata/placement.yml",
+			invalid: true,
+			policy:  Policy{Tolerations: []string{"example.com/*"}},
+			message: "linter: toleration not allowed: nvidia.com/gpu=",
+		},
+		{
+			path:    "testdata/placement.yml",
+			invalid: true,
+			policy:  Policy{RuntimeClasses: []string{"kata"}},
+			message: "linter: runtime class not allowed: gvisor",
+		},
+		{
+			path: "testdata/placement.yml",
+			policy: Policy{
+				NodeSelectors:  []string{"pool=gpu", "kubernetes.io/arch=*"},
+				Tolerations:    []string{"nvidia.com/gpu=*"},
+				RuntimeClasses: []string{"gvisor"},
+			},
+		},
+		// user should not be able to use images or clone urls
+		// outside of the internal domains in offline mode.
+		{
+			path:    "testdata/simple.yml",
+			invalid: true,
+			policy:  Policy{Domains: []string{"registry.local"}},
+			message: "linter: image not hosted by an internal domain: redis",
+		},
+		{
+			path:    "testdata/offline.yml",
+			invalid: true,
+			remote:  "https://github.com/octocat/hello-world.git",
+			policy:  Policy{Domains: []string{"registry.local"}},
+			message: "linter: clone url not hosted by an internal domain: https://github.com/octocat/hello-world.git",
+		},
+		{
+			path:   "testdata/offline.yml",
+			remote: "https://git.registry.local/octocat/hello-world.git",
+			policy: Policy{Domains: []string{"registry.local"}},
+		},
+		// user should only be able to run node steps if the
+		// repository is trusted and allowed by the policy.
+		{
+			path:    "testdata/node.yml",
+			invalid: true,
+			repo:    "octocat/hello-world",
+			policy:  Policy{NodeRepos: []string{"octocat/*"}},
+			message: "linter: untrusted repositories cannot run node steps",
+		},
+		{
+			path:    "testdata/node.yml",
+			trusted: true,
+			invalid: true,
+			repo:    "spaceghost/hello-world",
+			policy:  Policy{NodeRepos: []string{"octocat/*"}},
+			message: "linter: repository restricted from running node steps",
+		},
+		{
+			path:    "testdata/node.yml",
+			trusted: true,
+			repo:    "octocat/hello-world",
+			policy:  Policy{NodeRepos: []string{"octocat/*"}},
+		},
+		// user should not be able to run node steps in a pull
+		// request from a forked repository.
+		{
+			path:    "testdata/node.yml",
+			trusted: true,
+			fork:    true,
+			invalid: true,
+			repo:    "octocat/hello-world",
+			policy:  Policy{NodeRepos: []string{"octocat/*"}},
+			message: "linter: pull requests from forked repositories cannot run node steps",
+		},
+		{
+			path:    "testdata/node_secret.yml",
+			trusted: true,
+			invalid: true,
+			repo:    "octocat/hello-world",
+			policy:  Policy{NodeRepos: []string{"octocat/*"}},
+			message: "linter: node steps cannot use secrets",
+		},
+		// user should only be able to request the kvm device
+		// if the repository is allowed by the policy.
+		{
+			path:    "testdata/kvm.yml",
+			invalid: true,
+			repo:    "octocat/hello-world",
+			message: "linter: repository restricted from using the kvm device",
+		},
+		{
+			path:   "testdata/kvm.yml",
+			repo:   "octocat/hello-world",
+			policy: Policy{KVMRepos: []string{"octocat/*"}},
+		},
+		{
+			path:    "testdata/kvm.yml",
+			fork:    true,
+			invalid: true,
+			repo:    "octocat/hello-world",
+			policy:  Policy{KVMRepos: []string{"octocat/*"}},
+			message: "linter: pull requests from forked repositories cannot use the kvm device",
+		},
 		// linter should verify whether or not a repository can
 		// use a target namespace
 		{
@@ -183,8 +634,8 @@ func TestLint(t *testing.T) {
 				return
 			}
 
-			lint := New(test.patterns)
-			opts := Opts{Trusted: test.trusted, Slug: test.repo}
+			lint := New(test.patterns, test.policy)
+			opts := Opts{Trusted: test.trusted, Fork: test.fork, Slug: test.repo, Remote: test.remote}
 			err = lint.Lint(resources.Resources[0].(*resource.Pipeline), opts)
 			if err == nil && test.invalid == true {
 				t.Logf("yaml: %s", test.path)
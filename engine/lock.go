@@ -0,0 +1,262 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// the duration of a concurrency lease. The lease is renewed
+// while the pipeline is running, and expires if the runner
+// exits without releasing the lease.
+const leaseDuration = 60 * time.Second
+
+// the interval at which a pipeline waiting for a concurrency
+// lock retries acquiring the lock.
+var lockInterval = 5 * time.Second
+
+// Lock acquires the namespace object quota and the concurrency
+// lock for the pipeline. Lock blocks until both are acquired or
+// the context is cancelled, and invokes the wait function once
+// for each lock that is not immediately available.
+func (k *Kubernetes) Lock(ctx context.Context, spec *Spec, wait func(string)) (func(), error) {
+	release, err := k.quota.acquire(ctx, spec, func() {
+		wait("namespace quota " + spec.PodSpec.Namespace)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if spec.Concurrency == nil {
+		return release, nil
+	}
+	unlock, err := k.lockConcurrency(ctx, spec, func() {
+		wait("lock " + spec.Concurrency.Group)
+	})
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		release()
+	}, nil
+}
+
+// Relock re-acquires the namespace object quota and the
+// concurrency lock for a recovered pipeline. The pipeline
+// objects already exist, so the objects are counted against
+// the namespace quota without waiting. The concurrency lease
+// is held by the pipeline, so it is re-acquired immediately
+// unless it expired and was acquired by another pipeline
+// while the runner was restarting, in which case Relock
+// blocks like Lock.
+func (k *Kubernetes) Relock(ctx context.Context, spec *Spec, wait func(string)) (func(), error) {
+	release := k.quota.adopt(spec)
+	if spec.Concurrency == nil {
+		return release, nil
+	}
+	unlock, err := k.lockConcurrency(ctx, spec, func() {
+		wait("lock " + spec.Concurrency.Group)
+	})
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		release()
+	}, nil
+}
+
+// helper function acquires a concurrency lock for the pipeline.
+// The lock is implemented with a lease object, so that pipelines
+// executed by multiple runner replicas are mutually exclusive.
+func (k *Kubernetes) lockConcurrency(ctx context.Context, spec *Spec, wait func()) (func(), error) {
+	limit := spec.Concurrency.Limit
+	if limit < 1 {
+		limit = 1
+	}
+	for waiting := false; ; waiting = true {
+		for i := 0; i < limit; i++ {
+			name := leaseName(spec, i)
+			ok, err := k.acquireLease(ctx, spec, name)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			renewCtx, cancel := context.WithCancel(context.Background())
+			go k.renewLease(renewCtx, spec, name)
+			return func() {
+				cancel()
+				k.releaseLease(spec, name)
+			}, nil
+		}
+		if !waiting && wait != nil {
+			wait()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockInterval):
+		}
+	}
+}
+
+// helper function returns the name of the lease for the
+// concurrency group. The group name is hashed, because the
+// group is defined by the user and may not be a valid object
+// name.
+func leaseName(spec *Spec, i int) string {
+	sum := sha1.Sum([]byte(spec.Concurrency.Group))
+	return fmt.Sprintf("drone-concurrency-%x-%d", sum[:8], i)
+}
+
+// SetLockNamespace sets the namespace of the concurrency lease
+// objects. The leases of all pipelines are kept in the same
+// namespace, so that pipelines of the same concurrency group
+// are mutually exclusive when the pipeline pods are created in
+// different namespaces, for example namespaces assigned from
+// the namespace pool or requested by the pipeline.
+func (k *Kubernetes) SetLockNamespace(namespace string) {
+	k.lockNamespace = namespace
+}
+
+// helper function returns the namespace of the lease objects
+// of the concurrency group.
+func (k *Kubernetes) leaseNamespace(spec *Spec) string {
+	if k.lockNamespace != "" {
+		return k.lockNamespace
+	}
+	if spec.Concurrency.Namespace != "" {
+		return spec.Concurrency.Namespace
+	}
+	return spec.PodSpec.Namespace
+}
+
+// helper function acquires the lease and returns true if the
+// lease is held by the pipeline. A lease can be acquired if it
+// does not exist, or if the lease has expired.
+func (k *Kubernetes) acquireLease(ctx context.Context, spec *Spec, name string) (bool, error) {
+	ctx, cancel := k.deadline(ctx)
+	defer cancel()
+	client := k.coordinationV1(ctx).Leases(k.leaseNamespace(spec))
+	holder := spec.PodSpec.Name
+	now := metav1.NewMicroTime(time.Now())
+
+	lease, err := client.Get(name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		_, err = client.Create(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       stringptr(holder),
+				LeaseDurationSeconds: int32ptr(int32(leaseDuration / time.Second)),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		})
+		if kerrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if isHeld(lease, now.Time) && *lease.Spec.HolderIdentity != holder {
+		return false, nil
+	}
+	lease.Spec.HolderIdentity = stringptr(holder)
+	lease.Spec.LeaseDurationSeconds = int32ptr(int32(leaseDuration / time.Second))
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	_, err = client.Update(lease)
+	if kerrors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// helper function renews the lease until the context is
+// cancelled.
+func (k *Kubernetes) renewLease(ctx context.Context, spec *Spec, name string) {
+	ticker := time.NewTicker(leaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reqCtx, cancel := k.deadline(ctx)
+		client := k.coordinationV1(reqCtx).Leases(k.leaseNamespace(spec))
+		lease, err := client.Get(name, metav1.GetOptions{})
+		if err == nil && isHolder(lease, spec.PodSpec.Name) {
+			now := metav1.NewMicroTime(time.Now())
+			lease.Spec.RenewTime = &now
+			_, err = client.Update(lease)
+		}
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			logrus.WithError(err).
+				WithField("lease", name).
+				Warnln("cannot renew concurrency lease")
+		}
+	}
+}
+
+// helper function releases the lease if it is held by the
+// pipeline. The lease is released even if the pipeline is
+// cancelled, subject to the request deadline.
+func (k *Kubernetes) releaseLease(spec *Spec, name string) {
+	ctx, cancel := k.deadline(context.Background())
+	defer cancel()
+	client := k.coordinationV1(ctx).Leases(k.leaseNamespace(spec))
+	lease, err := client.Get(name, metav1.GetOptions{})
+	if err == nil && isHolder(lease, spec.PodSpec.Name) {
+		err = client.Delete(name, &metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{
+				ResourceVersion: stringptr(lease.ResourceVersion),
+			},
+		})
+	}
+	if err != nil && !kerrors.IsNotFound(err) {
+		logrus.WithError(err).
+			WithField("lease", name).
+			Warnln("cannot release concurrency lease")
+	}
+}
+
+// helper function returns true if the lease is held and has
+// not expired.
+func isHeld(lease *coordinationv1.Lease, now time.Time) bool {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" {
+		return false
+	}
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expires := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+	return expires.After(now)
+}
+
+// helper function returns true if the lease is held by the
+// named holder.
+func isHolder(lease *coordinationv1.Lease, holder string) bool {
+	return lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == holder
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIsHeld(t *testing.T) {
+	now := time.Now()
+	renewed := metav1.NewMicroTime(now.Add(-30 * time.Second))
+	tests := []struct {
+		holder string
+		renew  *metav1.MicroTime
+		expiry int32
+		held   bool
+	}{
+		{holder: "drone-pod", renew: &renewed, expiry: 60, held: true},
+		{holder: "drone-pod", renew: &renewed, expiry: 10, held: false},
+		{holder: "", renew: &renewed, expiry: 60, held: false},
+		{holder: "drone-pod", renew: nil, expiry: 60, held: false},
+	}
+	for i, test := range tests {
+		lease := &coordinationv1.Lease{
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       stringptr(test.holder),
+				RenewTime:            test.renew,
+				LeaseDurationSeconds: int32ptr(test.expiry),
+			},
+		}
+		if got, want := isHeld(lease, now), test.held; got != want {
+			t.Errorf("Want held %v, got %v at index %d", want, got, i)
+		}
+	}
+}
+
+func TestLeaseName(t *testing.T) {
+	a := &Spec{Concurrency: &Concurrency{Group: "octocat/hello-world/production"}}
+	b := &Spec{Concurrency: &Concurrency{Group: "octocat/hello-world/staging"}}
+	if leaseName(a, 0) == leaseName(b, 0) {
+		t.Errorf("Expect unique lease names for each group")
+	}
+	if leaseName(a, 0) == leaseName(a, 1) {
+		t.Errorf("Expect unique lease names for each slot")
+	}
+	if got, want := len(leaseName(a, 0)), len("drone-concurrency-")+16+2; got != want {
+		t.Errorf("Want lease name length %d, got %d", want, got)
+	}
+}
+
+func TestLeaseNamespace(t *testing.T) {
+	a := &Spec{
+		PodSpec:     PodSpec{Namespace: "pool-a"},
+		Concurrency: &Concurrency{Group: "octocat/hello-world/production"},
+	}
+	b := &Spec{
+		PodSpec:     PodSpec{Namespace: "pool-b"},
+		Concurrency: &Concurrency{Group: "octocat/hello-world/production"},
+	}
+	k := new(Kubernetes)
+	if k.leaseNamespace(a) == k.leaseNamespace(b) {
+		t.Errorf("Want lease in the pod namespace if not configured")
+	}
+	k.SetLockNamespace("drone")
+	for _, spec := range []*Spec{a, b} {
+		if got, want := k.leaseNamespace(spec), "drone"; got != want {
+			t.Errorf("Want lease namespace %s, got %s", want, got)
+		}
+	}
+}
+
+func TestLock_Wait(t *testing.T) {
+	defer func(d time.Duration) { lockInterval = d }(lockInterval)
+	lockInterval = 10 * time.Millisecond
+
+	spec := &Spec{
+		PodSpec:     PodSpec{Name: "drone-abc", Namespace: "default"},
+		Concurrency: &Concurrency{Group: "octocat/hello-world/production"},
+	}
+	held := func() *coordinationv1.Lease {
+		now := metav1.NewMicroTime(time.Now())
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: leaseName(spec, 0), Namespace: "default"},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       stringptr("drone-xyz"),
+				LeaseDurationSeconds: int32ptr(60),
+				RenewTime:            &now,
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		release bool
+		waits   []string
+		err     error
+	}{
+		{
+			name: "lock free",
+		},
+		{
+			name:    "lock released while waiting",
+			objects: []runtime.Object{held()},
+			release: true,
+			waits:   []string{"lock octocat/hello-world/production"},
+		},
+		{
+			name:    "lock held",
+			objects: []runtime.Object{held()},
+			waits:   []string{"lock octocat/hello-world/production"},
+			err:     context.DeadlineExceeded,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.objects...)
+		k := &Kubernetes{client: client}
+		if test.release {
+			time.AfterFunc(50*time.Millisecond, func() {
+				client.CoordinationV1().Leases("default").Delete(leaseName(spec, 0), &metav1.DeleteOptions{})
+			})
+		}
+
+		var waits []string
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		unlock, err := k.Lock(ctx, spec, func(lock string) {
+			waits = append(waits, lock)
+		})
+		cancel()
+		if err != test.err {
+			t.Errorf("%s: Want error %v, got %v", test.name, test.err, err)
+		}
+		if diff := cmp.Diff(test.waits, waits); diff != "" {
+			t.Errorf("%s: Want the wait function invoked once while waiting", test.name)
+			t.Log(diff)
+		}
+		if err != nil {
+			continue
+		}
+		lease, err := client.CoordinationV1().Leases("default").Get(leaseName(spec, 0), metav1.GetOptions{})
+		if err != nil || !isHolder(lease, "drone-abc") {
+			t.Errorf("%s: Want lease held by the pipeline", test.name)
+		}
+		unlock()
+		if _, err := client.CoordinationV1().Leases("default").Get(leaseName(spec, 0), metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+			t.Errorf("%s: Want lease released, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maximum number of consecutive attempts to reopen the log
+// stream before the step fails.
+const maxLogAttempts = 10
+
+// helper function executes the step script in the background
+// with the output written to the container logs, and streams
+// the container logs to the step output. The log stream is
+// reopened from the last received line if it disconnects, so
+// that output is not lost when the api server or the exec
+// stream is interrupted. Returns false if the container logs
+// cannot be streamed, in which case the caller falls back to
+// streaming the output of the exec session.
+func (k *Kubernetes) runWithLogs(ctx context.Context, spec *Spec, step *Step, script string, exports []byte, state *State, output io.Writer) (bool, error) {
+	marker, err := toExitMarker()
+	if err != nil {
+		return false, err
+	}
+
+	// the log stream is opened before the script starts, so
+	// that no output is missed.
+	t := &logTail{}
+	stream, err := k.openLogs(ctx, spec, step, t)
+	if err != nil {
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			WithField("container", step.ID).
+			Debugln("cannot stream container logs, falling back to exec")
+		return false, nil
+	}
+
+	// the exit marker is recorded before the script starts,
+	// so that the runner can re-attach to the step output if
+	// the runner process restarts.
+	k.checkpoint(spec, step, &Checkpoint{Started: time.Now().Unix(), Marker: marker})
+
+	command := toLogCommand(script, marker)
+	if len(exports) == 0 {
+		err = k.exec(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, command), ioutil.Discard, ioutil.Discard)
+	} else {
+		command = ". /dev/stdin; " + command
+		err = k.stream(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, command), bytes.NewReader(exports), ioutil.Discard, ioutil.Discard)
+	}
+	if err != nil {
+		stream.Close()
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			WithField("container", step.ID).
+			Debugln("cannot start step in the background, falling back to exec")
+		return false, nil
+	}
+
+	return true, k.followLogs(ctx, spec, step, t, stream, marker, state, output)
+}
+
+// helper function streams the container logs to the output
+// until the exit marker is read, and reopens the log stream
+// from the last received line if it disconnects.
+func (k *Kubernetes) followLogs(ctx context.Context, spec *Spec, step *Step, t *logTail, stream io.ReadCloser, marker string, state *State, output io.Writer) error {
+	// the log stream is closed when the pipeline is cancelled,
+	// which unblocks the reader.
+	var mu sync.Mutex
+	current := stream
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			mu.Lock()
+			current.Close()
+			mu.Unlock()
+		}
+	}()
+
+	attempts := 0
+	for {
+		code, ok, err := t.read(stream, marker, output)
+		stream.Close()
+		if ok {
+			state.ExitCode = code
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// the log stream ends without the exit marker if the
+		// container terminated, for example if it was killed
+		// because it ran out of memory.
+		if k.checkTerminated(ctx, spec, step, state) {
+			return nil
+		}
+
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			WithField("container", step.ID).
+			Debugln("container log stream disconnected, resuming")
+
+		for {
+			attempts++
+			if attempts > maxLogAttempts {
+				return &Error{
+					Code: CodeLogsDisconnected,
+					Err:  fmt.Errorf("engine: cannot resume the log stream of step %s: %s", step.Name, err),
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			stream, err = k.openLogs(ctx, spec, step, t)
+			if err == nil {
+				mu.Lock()
+				current = stream
+				mu.Unlock()
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			stream.Close()
+			return ctx.Err()
+		}
+		attempts = 0
+	}
+}
+
+// helper function opens the container log stream, starting
+// from the last line received if the stream is resumed. The
+// stream is closed once the context is cancelled.
+func (k *Kubernetes) openLogs(ctx context.Context, spec *Spec, step *Step, t *logTail) (io.ReadCloser, error) {
+	opts := &v1.PodLogOptions{
+		Container:  step.ID,
+		Follow:     true,
+		Timestamps: true,
+	}
+	if !t.last.IsZero() {
+		since := metav1.NewTime(t.last)
+		opts.SinceTime = &since
+	}
+	return k.coreV1(ctx).Pods(spec.PodSpec.Namespace).GetLogs(spec.PodSpec.Name, opts).Stream()
+}
+
+// helper function returns the terminated state of the step
+// container, if the container terminated.
+func (k *Kubernetes) terminated(ctx context.Context, spec *Spec, step *Step) (*v1.ContainerStateTerminated, bool) {
+	ctx, cancel := k.deadline(ctx)
+	defer cancel()
+	pod, err := k.coreV1(ctx).Pods(spec.PodSpec.Namespace).Get(spec.PodSpec.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == step.ID && status.State.Terminated != nil {
+			return status.State.Terminated, true
+		}
+	}
+	return nil, false
+}
+
+// logTail tracks the position in the container logs, so that
+// lines are not repeated when the log stream is resumed. The
+// api server only supports resuming from a whole second, so
+// the lines received since are skipped.
+type logTail struct {
+	last time.Time
+	seen int
+}
+
+// helper function reads the timestamped log lines and writes
+// the lines to the output. Returns the step exit code and true
+// when the exit marker is read.
+func (t *logTail) read(r io.Reader, marker string, output io.Writer) (int, bool, error) {
+	skip := t.last
+	skipped := 0
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			ts, text := splitTimestamp(line)
+
+			// lines received before the stream was resumed
+			// are skipped.
+			if !skip.IsZero() && !ts.IsZero() {
+				if ts.Before(skip) {
+					continue
+				}
+				if ts.Equal(skip) && skipped < t.seen {
+					skipped++
+					continue
+				}
+			}
+			if ts.Equal(t.last) {
+				t.seen++
+			} else if !ts.IsZero() {
+				t.last = ts
+				t.seen = 1
+			}
+
+			// the exit marker is appended to the last line
+			// of output if the output does not end with a
+			// newline.
+			if i := strings.Index(text, marker+" "); i > 0 {
+				if _, err := io.WriteString(output, text[:i]); err != nil {
+					return 0, false, err
+				}
+				text = text[i:]
+			}
+			if code, ok := parseExitMarker(text, marker); ok {
+				return code, true, nil
+			}
+			if _, err := io.WriteString(output, text); err != nil {
+				return 0, false, err
+			}
+		}
+		if err != nil {
+			return 0, false, err
+		}
+	}
+}
+
+// helper function splits the timestamp from the log line.
+func splitTimestamp(line string) (time.Time, string) {
+	i := strings.IndexByte(line, ' ')
+	if i == -1 {
+		return time.Time{}, line
+	}
+	ts, err := time.Parse(time.RFC3339Nano, line[:i])
+	if err != nil {
+		return time.Time{}, line
+	}
+	return ts, line[i+1:]
+}
+
+// helper function returns the exit code if the line is the
+// exit marker.
+func parseExitMarker(line, marker string) (int, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, marker+" ") {
+		return 0, false
+	}
+	code, err := strconv.Atoi(strings.TrimPrefix(line, marker+" "))
+	if err != nil {
+		return 0, false
+	}
+	return code, true
+}
+
+// helper function returns a random exit marker, so that the
+// step output cannot forge the exit code.
+func toExitMarker() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "drone-exit-" + hex.EncodeToString(b), nil
+}
+
+// helper function returns the command that executes the step
+// script in the background with the output written to the
+// container logs, followed by the exit marker and exit code.
+// The standard output and standard error of the script share a
+// single pipe, which preserves the order of the output, and the
+// exit marker is written to the same pipe after the script
+// exits, so that it cannot overtake buffered output. The pipe
+// has a single writer to the container logs, so the output of
+// the script is not interleaved with itself.
+func toLogCommand(script, marker string) string {
+	return fmt.Sprintf(
+		`((%s) </dev/null 2>&1; echo "%s $?") | cat >/proc/1/fd/1 &`,
+		script, marker,
+	)
+}
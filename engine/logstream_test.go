@@ -0,0 +1,89 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLogTail(t *testing.T) {
+	marker := "drone-exit-abc"
+	out := new(bytes.Buffer)
+	tail := new(logTail)
+
+	// the first stream disconnects after two lines with the
+	// same timestamp.
+	first := strings.Join([]string{
+		"2019-10-10T10:00:00.000000001Z go build\n",
+		"2019-10-10T10:00:01.000000002Z go test\n",
+		"2019-10-10T10:00:01.000000002Z ok\n",
+	}, "")
+	_, ok, err := tail.read(strings.NewReader(first), marker, out)
+	if ok {
+		t.Errorf("Expect exit marker not found")
+	}
+	if err != io.EOF {
+		t.Errorf("Want EOF, got %v", err)
+	}
+
+	// the resumed stream repeats the lines since the start of
+	// the second, which are skipped.
+	second := strings.Join([]string{
+		"2019-10-10T10:00:01.000000002Z go test\n",
+		"2019-10-10T10:00:01.000000002Z ok\n",
+		"2019-10-10T10:00:01.000000002Z PASS\n",
+		"2019-10-10T10:00:02.000000000Z go vet\n",
+		"2019-10-10T10:00:03.000000000Z drone-exit-abc 2\n",
+	}, "")
+	code, ok, err := tail.read(strings.NewReader(second), marker, out)
+	if err != nil {
+		t.Error(err)
+	}
+	if !ok {
+		t.Fatalf("Expect exit marker found")
+	}
+	if got, want := code, 2; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if got, want := out.String(), "go build\ngo test\nok\nPASS\ngo vet\n"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+func TestLogTail_Unterminated(t *testing.T) {
+	out := new(bytes.Buffer)
+	tail := new(logTail)
+	in := "2019-10-10T10:00:00.000000001Z progress 100%drone-exit-abc 0\n"
+	code, ok, err := tail.read(strings.NewReader(in), "drone-exit-abc", out)
+	if err != nil {
+		t.Error(err)
+	}
+	if !ok || code != 0 {
+		t.Fatalf("Expect exit marker found after unterminated output")
+	}
+	if got, want := out.String(), "progress 100%"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+func TestToLogCommand(t *testing.T) {
+	got := toLogCommand("go test", "drone-exit-abc")
+	want := `((go test) </dev/null 2>&1; echo "drone-exit-abc $?") | cat >/proc/1/fd/1 &`
+	if got != want {
+		t.Errorf("Want command %q, got %q", want, got)
+	}
+}
+
+func TestParseExitMarker(t *testing.T) {
+	if _, ok := parseExitMarker("drone-exit-abc 0\n", "drone-exit-xyz"); ok {
+		t.Errorf("Expect forged exit marker ignored")
+	}
+	if code, ok := parseExitMarker("drone-exit-xyz 137\n", "drone-exit-xyz"); !ok || code != 137 {
+		t.Errorf("Want exit code 137, got %d", code)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/internal/metrics"
+)
+
+// engine metrics, exposed in the Prometheus format.
+var (
+	podCreateSeconds = metrics.NewHistogram(
+		"drone_pod_create_duration_seconds",
+		"Time taken to create the pipeline pod.",
+		metrics.DefaultBuckets,
+	)
+	podRunningSeconds = metrics.NewHistogram(
+		"drone_pod_time_to_running_seconds",
+		"Time from pipeline pod creation until the pod is running, including scheduling and image pulls.",
+		metrics.DefaultBuckets,
+	)
+	destroySeconds = metrics.NewHistogram(
+		"drone_pipeline_destroy_duration_seconds",
+		"Time taken to delete the pipeline resources.",
+		metrics.DefaultBuckets,
+	)
+	stepSeconds = metrics.NewHistogram(
+		"drone_step_duration_seconds",
+		"Time taken to run a pipeline step, by outcome, excluding the time waiting for the pod.",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+		"outcome",
+	)
+	stepsWaiting = metrics.NewGauge(
+		"drone_steps_waiting",
+		"Number of steps waiting for the pipeline pod to run.",
+	)
+	imagePullFailures = metrics.NewCounter(
+		"drone_image_pull_failures_total",
+		"Total number of steps that failed because the image could not be pulled.",
+	)
+	execStreamErrors = metrics.NewCounter(
+		"drone_exec_stream_errors_total",
+		"Total number of exec streams disconnected before the step exited.",
+	)
+	apiRetries = metrics.NewCounter(
+		"drone_api_retries_total",
+		"Total number of kubernetes api calls retried after a transient error.",
+	)
+	chaosFaults = metrics.NewCounter(
+		"drone_chaos_faults_total",
+		"Total number of synthetic failures injected in chaos mode, by fault.",
+		"fault",
+	)
+)
+
+// helper function records the duration and outcome of the
+// step.
+func observeStep(start time.Time, state *State, err error) {
+	outcome := "success"
+	switch {
+	case err != nil:
+		outcome = "error"
+	case state == nil:
+	case state.TimedOut:
+		outcome = "timeout"
+	case state.OOMKilled, state.ExitCode != 0:
+		outcome = "failure"
+	}
+	stepSeconds.Observe(time.Since(start).Seconds(), outcome)
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// metadataAddresses provides the addresses of the cloud
+// provider metadata endpoints. The link-local address is used
+// by aws, gcp, azure and others, the ipv6 address by aws, and
+// the shared address by alibaba cloud.
+var metadataAddresses = struct {
+	ipv4 []string
+	ipv6 []string
+}{
+	ipv4: []string{"169.254.169.254/32", "100.100.100.200/32"},
+	ipv6: []string{"fd00:ec2::254/128"},
+}
+
+// helper function returns the network policy that blocks
+// egress from the pod to the cloud provider metadata
+// endpoints. Egress to any other address, and to pods in
+// the cluster, is allowed.
+func toNetworkPolicy(spec *Spec) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   spec.PodSpec.Name,
+			Labels: toOwnerLabels(spec),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: toOwnerLabels(spec),
+			},
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeEgress,
+			},
+			Egress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{
+					{
+						IPBlock: &networkingv1.IPBlock{
+							CIDR:   "0.0.0.0/0",
+							Except: metadataAddresses.ipv4,
+						},
+					},
+					{
+						IPBlock: &networkingv1.IPBlock{
+							CIDR:   "::/0",
+							Except: metadataAddresses.ipv6,
+						},
+					},
+					{
+						NamespaceSelector: &metav1.LabelSelector{},
+					},
+				},
+			}},
+		},
+	}
+}
+
+// helper function returns the pod labels. The pod is labeled
+// with the owner labels if the pod is selected by a network
+// policy.
+func toPodLabels(spec *Spec) map[string]string {
+	if !spec.PodSpec.BlockMetadata {
+		return spec.PodSpec.Labels
+	}
+	labels := map[string]string{}
+	for k, v := range spec.PodSpec.Labels {
+		labels[k] = v
+	}
+	for k, v := range toOwnerLabels(spec) {
+		labels[k] = v
+	}
+	return labels
+}
@@ -0,0 +1,32 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestToNetworkPolicy(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:          "drone-pod",
+			Labels:        map[string]string{"team": "octocat"},
+			BlockMetadata: true,
+		},
+	}
+	policy := toNetworkPolicy(spec)
+	if got, want := policy.Spec.PodSelector.MatchLabels["io.drone.name"], "drone-pod"; got != want {
+		t.Errorf("Want pod selector %s, got %s", want, got)
+	}
+	if got, want := policy.Spec.Egress[0].To[0].IPBlock.Except[0], "169.254.169.254/32"; got != want {
+		t.Errorf("Want metadata address %s blocked, got %s", want, got)
+	}
+
+	labels := toPodLabels(spec)
+	if labels["io.drone.name"] != "drone-pod" || labels["team"] != "octocat" {
+		t.Errorf("Expect pod labeled with the owner labels, got %v", labels)
+	}
+	if _, ok := spec.PodSpec.Labels["io.drone.name"]; ok {
+		t.Errorf("Expect spec labels not modified")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-kube/nicelog"
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/exec"
+)
+
+// errNoNodeHelper is returned when there is no running node
+// helper pod on the pipeline node.
+var errNoNodeHelper = errors.New("no node helper is running on the node")
+
+// helper function executes the node step in the node helper
+// pod that runs on the same node as the pipeline pod. The
+// step script and environment are written to the standard
+// input of the helper shell.
+func (k *Kubernetes) runOnNode(ctx context.Context, spec *Spec, step *Step, output io.Writer) (*State, error) {
+	if spec.NodeHelper == nil {
+		fmt.Fprintln(output, "node: node steps are not enabled")
+		return &State{Exited: true, ExitCode: 1}, nil
+	}
+
+	var pod *v1.Pod
+	err := k.call(ctx, func(ctx context.Context) (err error) {
+		pod, err = k.coreV1(ctx).Pods(spec.PodSpec.Namespace).Get(spec.PodSpec.Name, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	helper, err := k.findNodeHelper(ctx, spec.NodeHelper, pod.Spec.NodeName)
+	if err != nil {
+		fmt.Fprintf(output, "node: %s\n", err)
+		return &State{Exited: true, ExitCode: 1}, nil
+	}
+
+	script := toNodeScript(spec, step)
+
+	// every node step is written to the audit log, because
+	// the helper pod has privileged access to the node.
+	logrus.WithField("audit", "node-step").
+		WithField("pod", spec.PodSpec.Name).
+		WithField("namespace", spec.PodSpec.Namespace).
+		WithField("labels", spec.PodSpec.Labels).
+		WithField("node", pod.Spec.NodeName).
+		WithField("helper", helper.Name).
+		WithField("step", step.Name).
+		WithField("script", toNodeCommand(spec, step)).
+		Infoln("executing node step")
+
+	stdout := nicelog.New(output)
+	stderr := nicelog.New(output)
+	state := &State{Exited: true}
+	err = k.stream(ctx, helper.Namespace, helper.Name, spec.NodeHelper.Container, []string{"sh", "-s"}, bytes.NewReader(script), stdout, stderr)
+	stdout.Flush()
+	stderr.Flush()
+	if err != nil {
+		e, ok := err.(exec.CodeExitError)
+		if !ok {
+			return nil, err
+		}
+		state.ExitCode = e.ExitStatus()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("audit", "node-step").
+		WithField("pod", spec.PodSpec.Name).
+		WithField("node", pod.Spec.NodeName).
+		WithField("step", step.Name).
+		WithField("exit_code", state.ExitCode).
+		Infoln("node step complete")
+	return state, nil
+}
+
+// helper function returns a running node helper pod on the
+// named node.
+func (k *Kubernetes) findNodeHelper(ctx context.Context, helper *NodeHelper, node string) (*v1.Pod, error) {
+	var list *v1.PodList
+	err := k.call(ctx, func(ctx context.Context) (err error) {
+		list, err = k.coreV1(ctx).Pods(helper.Namespace).List(metav1.ListOptions{
+			LabelSelector: helper.Selector,
+			FieldSelector: "spec.nodeName=" + node,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		if pod := &list.Items[i]; pod.Status.Phase == v1.PodRunning {
+			return pod, nil
+		}
+	}
+	return nil, errNoNodeHelper
+}
+
+// helper function returns the node step script, which
+// exports the step environment and executes the step
+// commands.
+func toNodeScript(spec *Spec, step *Step) []byte {
+	var names []string
+	for name := range step.Envs {
+		if name != "DRONE_SCRIPT" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString("export ")
+		buf.WriteString(name)
+		buf.WriteString("=")
+		buf.WriteString(quote(step.Envs[name]))
+		buf.WriteString("\n")
+	}
+	buf.WriteString(toNodeCommand(spec, step))
+	return buf.Bytes()
+}
+
+// helper function returns the node step commands. Scripts
+// that exceed the maximum environment variable size are
+// stored in the pipeline secret.
+func toNodeCommand(spec *Spec, step *Step) string {
+	if step.ScriptFile != "" {
+		if secret, ok := spec.Secrets[step.ScriptFile]; ok {
+			return secret.Data
+		}
+	}
+	return step.Envs["DRONE_SCRIPT"]
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestNodeScript(t *testing.T) {
+	spec := &Spec{}
+	step := &Step{
+		Envs: map[string]string{
+			"DRONE_SCRIPT": "crictl rmi --prune\n",
+			"GREETING":     "it's me",
+		},
+	}
+	got := string(toNodeScript(spec, step))
+	want := "export GREETING='it'\\''s me'\ncrictl rmi --prune\n"
+	if got != want {
+		t.Errorf("Want node script %q, got %q", want, got)
+	}
+}
+
+func TestNodeScript_File(t *testing.T) {
+	spec := &Spec{
+		Secrets: map[string]*Secret{
+			"abc.sh": {Name: "abc.sh", Data: "crictl rmi --prune\n"},
+		},
+	}
+	step := &Step{ScriptFile: "abc.sh"}
+	if got, want := toNodeCommand(spec, step), "crictl rmi --prune\n"; got != want {
+		t.Errorf("Want node command %q, got %q", want, got)
+	}
+}
+
+func TestNodeContainers(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{ID: "a", Name: "build"},
+			{ID: "b", Name: "prune", Node: true},
+		},
+	}
+	containers := toContainers(spec)
+	if got, want := len(containers), 1; got != want {
+		t.Fatalf("Want %d containers, got %d", want, got)
+	}
+	if got, want := containers[0].Name, "a"; got != want {
+		t.Errorf("Want container %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// errNodesNotSynced is returned if the node cache is not
+// synced before the request deadline expires, for example
+// because the runner is not permitted to list the nodes.
+var errNodesNotSynced = errors.New("engine: cannot sync the node cache")
+
+// nodeCache caches the cluster nodes, so that the pipelines
+// read the nodes from a shared informer instead of listing
+// the nodes of the cluster when the pipeline is set up.
+type nodeCache struct {
+	once     sync.Once
+	informer cache.SharedIndexInformer
+}
+
+// helper function returns the cached nodes of the cluster.
+// The informer is started on first use and runs for the
+// lifetime of the engine. An error is returned if the cache
+// is not synced before the request deadline expires.
+func (k *Kubernetes) listNodes(ctx context.Context) ([]*v1.Node, error) {
+	c := &k.nodes
+	c.once.Do(func() {
+		lw := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return k.client.CoreV1().Nodes().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.TimeoutSeconds = k.watchTimeout()
+				return k.client.CoreV1().Nodes().Watch(options)
+			},
+		}
+		c.informer = cache.NewSharedIndexInformer(lw, &v1.Node{}, 0, cache.Indexers{})
+		go c.informer.Run(make(chan struct{}))
+	})
+
+	ctx, cancel := k.deadline(ctx)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		return nil, errNodesNotSynced
+	}
+	var nodes []*v1.Node
+	for _, obj := range c.informer.GetStore().List() {
+		if node, ok := obj.(*v1.Node); ok {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
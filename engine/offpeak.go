@@ -0,0 +1,183 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OffPeak defines when the steps scheduled off-peak start.
+type OffPeak struct {
+	// Window returns true if the time is within the off-peak
+	// window. The window is ignored if nil.
+	Window func(time.Time) bool
+
+	// Threshold provides the ratio of the cpu requested by
+	// the pods to the allocatable cpu of the cluster nodes,
+	// below which off-peak steps start. The cluster load is
+	// ignored if zero.
+	Threshold float64
+
+	// MaxDelay provides the maximum time an off-peak step is
+	// delayed.
+	MaxDelay time.Duration
+
+	// Interval provides the interval at which the window and
+	// the cluster load are checked.
+	Interval time.Duration
+}
+
+// ScheduleOffPeak delays the steps scheduled off-peak until
+// the off-peak window opens or the cluster load drops below
+// the threshold, for at most the maximum delay, so that
+// expensive non-blocking steps do not compete with
+// interactive builds.
+func (k *Kubernetes) ScheduleOffPeak(policy OffPeak) {
+	if policy.Interval <= 0 {
+		policy.Interval = time.Minute
+	}
+	k.offpeak = &policy
+}
+
+// helper function blocks until the off-peak step can start,
+// or the context is cancelled. The reason the step is delayed
+// is written to the step output.
+func (k *Kubernetes) waitOffPeak(ctx context.Context, step *Step, output io.Writer) error {
+	if k.offpeak == nil || !step.OffPeak {
+		return nil
+	}
+	policy := k.offpeak
+	deadline := time.Now().Add(policy.MaxDelay)
+	for delayed := false; ; delayed = true {
+		if reason := k.offPeakReason(ctx); reason != "" {
+			if delayed {
+				fmt.Fprintf(output, "[off-peak] %s, starting step\n", reason)
+			}
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			fmt.Fprintf(output, "[off-peak] maximum delay of %s reached, starting step\n", policy.MaxDelay)
+			return nil
+		}
+		if !delayed {
+			fmt.Fprintf(output, "[off-peak] step delayed until off-peak, for at most %s\n", policy.MaxDelay)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(policy.Interval):
+		}
+	}
+}
+
+// helper function returns the reason the off-peak step can
+// start, or an empty string if the step is delayed.
+func (k *Kubernetes) offPeakReason(ctx context.Context) string {
+	policy := k.offpeak
+	if policy.Window == nil && policy.Threshold <= 0 {
+		return "off-peak scheduling not configured"
+	}
+	if policy.Window != nil && policy.Window(time.Now()) {
+		return "off-peak window open"
+	}
+	if policy.Threshold <= 0 {
+		return ""
+	}
+	load, err := k.clusterLoad(ctx)
+	if err != nil {
+		logrus.WithError(err).Debugln("cannot determine the cluster load")
+		return ""
+	}
+	if load < policy.Threshold {
+		return fmt.Sprintf("cluster load %.0f%% below threshold", load*100)
+	}
+	return ""
+}
+
+// loadSampler caches the cluster load, so that the waiting
+// off-peak steps share one sample per interval instead of
+// each step listing the cluster pods on every poll.
+type loadSampler struct {
+	sync.Mutex
+	load    float64
+	err     error
+	sampled time.Time
+}
+
+// helper function returns the cluster load, which is sampled
+// at most once per interval and shared by the waiting steps.
+func (k *Kubernetes) clusterLoad(ctx context.Context) (float64, error) {
+	s := &k.load
+	s.Lock()
+	defer s.Unlock()
+	if !s.sampled.IsZero() && time.Since(s.sampled) < k.offpeak.Interval {
+		return s.load, s.err
+	}
+	load, err := k.sampleLoad(ctx)
+	if ctx.Err() != nil {
+		// the sample of a cancelled step is not shared.
+		return load, err
+	}
+	s.load, s.err, s.sampled = load, err, time.Now()
+	return load, err
+}
+
+// helper function returns the ratio of the cpu requested by
+// the pods to the allocatable cpu of the ready nodes. The
+// nodes are read from the node cache.
+func (k *Kubernetes) sampleLoad(ctx context.Context) (float64, error) {
+	nodes, err := k.listNodes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var pods *v1.PodList
+	err = k.call(ctx, func(ctx context.Context) (err error) {
+		pods, err = k.coreV1(ctx).Pods("").List(metav1.ListOptions{
+			FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return toClusterLoad(nodes, pods.Items), nil
+}
+
+// helper function returns the ratio of the cpu requested by
+// the pods to the allocatable cpu of the ready nodes. Nodes
+// that are not ready or unschedulable are excluded.
+func toClusterLoad(nodes []*v1.Node, pods []v1.Pod) float64 {
+	ready := map[string]bool{}
+	var allocatable int64
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !isNodeReady(node) {
+			continue
+		}
+		ready[node.Name] = true
+		cpu := node.Status.Allocatable[v1.ResourceCPU]
+		allocatable += cpu.MilliValue()
+	}
+	if allocatable == 0 {
+		return 1
+	}
+	var requested int64
+	for i := range pods {
+		if !ready[pods[i].Spec.NodeName] {
+			continue
+		}
+		cpu := podRequests(&pods[i])[v1.ResourceCPU]
+		requested += cpu.MilliValue()
+	}
+	return float64(requested) / float64(allocatable)
+}
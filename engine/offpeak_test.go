@@ -0,0 +1,148 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWaitOffPeak(t *testing.T) {
+	step := &Step{Name: "e2e", OffPeak: true}
+
+	// steps are not delayed if off-peak scheduling is not
+	// enabled, or the step is not scheduled off-peak.
+	k := new(Kubernetes)
+	if err := k.waitOffPeak(context.Background(), step, new(bytes.Buffer)); err != nil {
+		t.Error(err)
+	}
+	k.ScheduleOffPeak(OffPeak{Window: func(time.Time) bool { return false }, MaxDelay: time.Hour})
+	if err := k.waitOffPeak(context.Background(), &Step{}, new(bytes.Buffer)); err != nil {
+		t.Error(err)
+	}
+
+	// the step is delayed until the window opens.
+	var checks int
+	k.ScheduleOffPeak(OffPeak{
+		Window: func(time.Time) bool {
+			checks++
+			return checks > 3
+		},
+		MaxDelay: time.Hour,
+		Interval: time.Millisecond,
+	})
+	var buf bytes.Buffer
+	if err := k.waitOffPeak(context.Background(), step, &buf); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(buf.String(), "off-peak window open") {
+		t.Errorf("Want step delayed until the window opens, got %q", buf.String())
+	}
+
+	// the step starts once the maximum delay is reached.
+	k.ScheduleOffPeak(OffPeak{
+		Window:   func(time.Time) bool { return false },
+		MaxDelay: 5 * time.Millisecond,
+		Interval: time.Millisecond,
+	})
+	buf.Reset()
+	if err := k.waitOffPeak(context.Background(), step, &buf); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(buf.String(), "maximum delay") {
+		t.Errorf("Want step started after the maximum delay, got %q", buf.String())
+	}
+
+	// the wait is abandoned if the step is cancelled.
+	k.ScheduleOffPeak(OffPeak{
+		Window:   func(time.Time) bool { return false },
+		MaxDelay: time.Hour,
+		Interval: time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := k.waitOffPeak(ctx, step, new(bytes.Buffer)); err == nil {
+		t.Errorf("Want error if the step is cancelled")
+	}
+}
+
+func TestToClusterLoad(t *testing.T) {
+	ready := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: v1.NodeStatus{
+				Conditions:  ready,
+				Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+			Spec:       v1.NodeSpec{Unschedulable: true},
+			Status: v1.NodeStatus{
+				Conditions:  ready,
+				Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+			},
+		},
+	}
+	pod := func(node, cpu string) v1.Pod {
+		return v1.Pod{
+			Spec: v1.PodSpec{
+				NodeName: node,
+				Containers: []v1.Container{{
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)},
+					},
+				}},
+			},
+		}
+	}
+	pods := []v1.Pod{pod("node-1", "1"), pod("node-1", "500m"), pod("node-2", "2")}
+	if got, want := toClusterLoad(nodes, pods), 0.375; got != want {
+		t.Errorf("Want cluster load %v, got %v", want, got)
+	}
+	if got := toClusterLoad(nil, pods); got != 1 {
+		t.Errorf("Want full load without ready nodes, got %v", got)
+	}
+}
+
+func TestClusterLoad_Shared(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{
+			Conditions:  []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+		},
+	})
+	k := &Kubernetes{client: client}
+	k.ScheduleOffPeak(OffPeak{Threshold: 0.5, Interval: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		load, err := k.clusterLoad(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if load != 0 {
+			t.Errorf("Want cluster load 0, got %v", load)
+		}
+	}
+	var lists int
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "pods" {
+			lists++
+		}
+	}
+	if lists != 1 {
+		t.Errorf("Want the cluster load sampled once per interval, got %d samples", lists)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OutputPath is the path of the file where a step writes the
+// KEY=VALUE variables exported to subsequent steps. The file
+// is local to the step container.
+const OutputPath = "/tmp/drone-output.env"
+
+// regular expression to validate output variable names.
+var outputName = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// helper function reads the variables exported by the step
+// and stores the variables, so that they are injected into
+// the environment of subsequent steps.
+func (k *Kubernetes) collectOutputs(ctx context.Context, spec *Spec, step *Step) {
+	ctx, cancel := k.deadline(ctx)
+	defer cancel()
+	buf := new(bytes.Buffer)
+	cmd := "cat " + OutputPath + " 2>/dev/null; true"
+	err := k.exec(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, cmd), buf, ioutil.Discard)
+	if err != nil {
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			WithField("container", step.ID).
+			Warnln("cannot read step outputs")
+		return
+	}
+	outputs := parseOutputs(buf.Bytes())
+	if len(outputs) == 0 {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.outputs == nil {
+		k.outputs = map[string]map[string]string{}
+	}
+	if k.outputs[spec.PodSpec.Name] == nil {
+		k.outputs[spec.PodSpec.Name] = map[string]string{}
+	}
+	for name, value := range outputs {
+		k.outputs[spec.PodSpec.Name][name] = value
+	}
+}
+
+// helper function returns the shell commands that export the
+// variables written by previous steps.
+func (k *Kubernetes) toOutputExports(spec *Spec) []byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	outputs := k.outputs[spec.PodSpec.Name]
+	if len(outputs) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString("export ")
+		buf.WriteString(name)
+		buf.WriteString("=")
+		buf.WriteString(quote(outputs[name]))
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// helper function removes the variables written by the
+// pipeline steps.
+func (k *Kubernetes) removeOutputs(spec *Spec) {
+	k.mu.Lock()
+	delete(k.outputs, spec.PodSpec.Name)
+	k.mu.Unlock()
+}
+
+// helper function parses the KEY=VALUE lines written by the
+// step. Blank lines, comments and invalid variable names are
+// ignored.
+func parseOutputs(data []byte) map[string]string {
+	outputs := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || !outputName.MatchString(parts[0]) {
+			continue
+		}
+		outputs[parts[0]] = parts[1]
+	}
+	return outputs
+}
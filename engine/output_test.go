@@ -0,0 +1,45 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseOutputs(t *testing.T) {
+	data := []byte("VERSION=1.2.3\n\n# comment\nTAGS=latest,1.2\ninvalid-name=foo\nnovalue\nURL=http://example.com/?a=b\n")
+	want := map[string]string{
+		"VERSION": "1.2.3",
+		"TAGS":    "latest,1.2",
+		"URL":     "http://example.com/?a=b",
+	}
+	if diff := cmp.Diff(want, parseOutputs(data)); diff != "" {
+		t.Errorf("Unexpected outputs")
+		t.Log(diff)
+	}
+}
+
+func TestOutputExports(t *testing.T) {
+	k := new(Kubernetes)
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-abc"}}
+	if got := k.toOutputExports(spec); got != nil {
+		t.Errorf("Want no exports, got %q", got)
+	}
+
+	k.outputs = map[string]map[string]string{
+		"drone-abc": {"VERSION": "1.2.3", "MESSAGE": "it's"},
+	}
+	want := "export MESSAGE='it'\\''s'\nexport VERSION='1.2.3'\n"
+	if got := string(k.toOutputExports(spec)); got != want {
+		t.Errorf("Want exports %q, got %q", want, got)
+	}
+
+	k.removeOutputs(spec)
+	if _, ok := k.outputs["drone-abc"]; ok {
+		t.Errorf("Expect outputs removed")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pending records the most recent pod status observed while
+// waiting for the pod to run.
+type pending struct {
+	sync.Mutex
+	pod *v1.Pod
+}
+
+func (p *pending) set(pod *v1.Pod) {
+	p.Lock()
+	p.pod = pod
+	p.Unlock()
+}
+
+func (p *pending) get() *v1.Pod {
+	p.Lock()
+	defer p.Unlock()
+	return p.pod
+}
+
+// helper function periodically writes the reason the pod is
+// pending to the step output until the context is cancelled,
+// for example the pod is unschedulable or an image is pulled.
+func (k *Kubernetes) reportPending(ctx context.Context, spec *Spec, p *pending, output io.Writer) {
+	interval := time.Duration(spec.PendingInterval) * time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		pod := p.get()
+		if pod == nil {
+			continue
+		}
+		reason := toPendingReason(pod, k.latestEvent(ctx, spec))
+		if reason != "" {
+			fmt.Fprintf(output, "[pending] %s\n", reason)
+		}
+	}
+}
+
+// helper function returns the most recent event of the pod,
+// or nil if no event is found.
+func (k *Kubernetes) latestEvent(ctx context.Context, spec *Spec) *v1.Event {
+	ctx, cancel := k.deadline(ctx)
+	defer cancel()
+	list, err := k.coreV1(ctx).Events(spec.PodSpec.Namespace).List(metav1.ListOptions{
+		FieldSelector: "involvedObject.name=" + spec.PodSpec.Name,
+	})
+	if err != nil || len(list.Items) == 0 {
+		return nil
+	}
+	events := list.Items
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+	return &events[len(events)-1]
+}
+
+// helper function returns a human-readable reason the pod is
+// pending, derived from the pod conditions, the container
+// states and the most recent pod event.
+func toPendingReason(pod *v1.Pod, event *v1.Event) string {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodScheduled && cond.Status == v1.ConditionFalse {
+			if cond.Message != "" {
+				return "unschedulable: " + cond.Message
+			}
+			return "waiting to be scheduled"
+		}
+	}
+
+	var reasons []string
+	var statuses []v1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting == nil {
+			continue
+		}
+		switch waiting.Reason {
+		case "", "ContainerCreating", "PodInitializing":
+			continue
+		}
+		reason := status.Name + ": " + waiting.Reason
+		if waiting.Message != "" {
+			reason += " (" + waiting.Message + ")"
+		}
+		reasons = append(reasons, reason)
+	}
+	if len(reasons) != 0 {
+		return strings.Join(reasons, ", ")
+	}
+
+	if event != nil && event.Message != "" {
+		return strings.ToLower(event.Reason) + ": " + event.Message
+	}
+	if pod.Status.Phase == v1.PodPending {
+		return "creating containers"
+	}
+	return ""
+}
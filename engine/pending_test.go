@@ -0,0 +1,64 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestToPendingReason(t *testing.T) {
+	tests := []struct {
+		pod   *v1.Pod
+		event *v1.Event
+		want  string
+	}{
+		{
+			pod: &v1.Pod{Status: v1.PodStatus{
+				Phase: v1.PodPending,
+				Conditions: []v1.PodCondition{{
+					Type:    v1.PodScheduled,
+					Status:  v1.ConditionFalse,
+					Message: "0/12 nodes are available: 12 Insufficient memory.",
+				}},
+			}},
+			want: "unschedulable: 0/12 nodes are available: 12 Insufficient memory.",
+		},
+		{
+			pod: &v1.Pod{Status: v1.PodStatus{
+				Phase: v1.PodPending,
+				ContainerStatuses: []v1.ContainerStatus{
+					{
+						Name:  "drone-step-1",
+						State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+					},
+					{
+						Name: "drone-step-2",
+						State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{
+							Reason:  "ImagePullBackOff",
+							Message: `Back-off pulling image "golang:nope"`,
+						}},
+					},
+				},
+			}},
+			want: `drone-step-2: ImagePullBackOff (Back-off pulling image "golang:nope")`,
+		},
+		{
+			pod:   &v1.Pod{Status: v1.PodStatus{Phase: v1.PodPending}},
+			event: &v1.Event{Reason: "Pulling", Message: `Pulling image "golang"`},
+			want:  `pulling: Pulling image "golang"`,
+		},
+		{
+			pod:  &v1.Pod{Status: v1.PodStatus{Phase: v1.PodPending}},
+			want: "creating containers",
+		},
+	}
+	for i, test := range tests {
+		if got := toPendingReason(test.pod, test.event); got != test.want {
+			t.Errorf("Want pending reason %q at index %d, got %q", test.want, i, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// helper function returns the name of the node label that
+// advertises the node feature, for example binfmt.
+func labelFeature(prefix, feature string) string {
+	return prefix + ".feature." + feature
+}
+
+// helper function returns the name of the node label that
+// advertises the architecture variant of the node.
+func labelVariant(prefix string) string {
+	return prefix + ".arch.variant"
+}
+
+// helper function restricts the pod to the nodes that support
+// the pipeline platform. If the pipeline declares a variant or
+// node features, which are not known to the scheduler, an
+// error is returned if none of the nodes that match the
+// pipeline node selector support the platform. The nodes are
+// read from the node cache. Nil is returned if the nodes
+// cannot be read, or if no node matches the node selector, in
+// which case the pod cannot be scheduled regardless of the
+// platform.
+func (k *Kubernetes) checkPlatform(ctx context.Context, spec *Spec) error {
+	platform := spec.Platform
+	if platform.Variant == "" && len(platform.Features) == 0 {
+		constrainPlatform(spec)
+		return nil
+	}
+	pod, err := toTemplatePod(spec)
+	if err != nil {
+		return nil
+	}
+	nodes, err := k.listNodes(ctx)
+	if err != nil {
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			Warnln("cannot list nodes to check the platform")
+		constrainPlatform(spec)
+		return nil
+	}
+	if err := matchPlatform(spec, pod, nodes); err != nil {
+		return err
+	}
+	constrainPlatform(spec)
+	return nil
+}
+
+// helper function returns an error if none of the nodes that
+// can schedule the pod support the platform, with the reasons
+// each node was rejected.
+func matchPlatform(spec *Spec, pod *v1.Pod, nodes []*v1.Node) error {
+	reasons := map[string]int{}
+	var candidates int
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !matchNodeSelector(pod, node) || !toleratesTaints(pod, node) {
+			continue
+		}
+		candidates++
+		reason := supports(spec, node)
+		if reason == "" {
+			return nil
+		}
+		reasons[reason]++
+	}
+	if candidates == 0 {
+		return nil
+	}
+
+	var summary []string
+	for reason, n := range reasons {
+		summary = append(summary, fmt.Sprintf("%d %s", n, reason))
+	}
+	sort.Strings(summary)
+	return &Error{
+		Code: CodePlatformUnsupported,
+		Err: fmt.Errorf("engine: 0/%d selected nodes support the pipeline platform %s: %s. "+
+			"Select a node pool that supports the platform, or label the nodes that provide "+
+			"a feature with %s=true",
+			candidates, toPlatformString(spec.Platform), strings.Join(summary, ", "),
+			labelFeature(labelPrefix(spec), "<name>")),
+	}
+}
+
+// helper function returns the reason the node does not
+// support the pipeline platform, or an empty string if the
+// node supports the platform. The variant is only compared if
+// the node advertises the variant.
+func supports(spec *Spec, node *v1.Node) string {
+	platform := spec.Platform
+	nodeOS := node.Status.NodeInfo.OperatingSystem
+	if nodeOS == "" {
+		nodeOS = node.Labels["kubernetes.io/os"]
+	}
+	arch := node.Status.NodeInfo.Architecture
+	if arch == "" {
+		arch = node.Labels["kubernetes.io/arch"]
+	}
+	switch {
+	case platform.OS != "" && platform.OS != nodeOS:
+		return "node(s) run operating system " + nodeOS
+	case platform.Arch != "" && platform.Arch != arch:
+		return "node(s) have architecture " + arch
+	}
+	if variant, ok := node.Labels[labelVariant(labelPrefix(spec))]; ok && platform.Variant != "" && variant != platform.Variant {
+		return "node(s) have architecture variant " + variant
+	}
+	for _, feature := range platform.Features {
+		if node.Labels[labelFeature(labelPrefix(spec), feature)] != "true" {
+			return "node(s) lack feature " + feature
+		}
+	}
+	return ""
+}
+
+// helper function adds the node affinity requirements that
+// restrict the pod to the nodes that support the platform.
+func constrainPlatform(spec *Spec) {
+	platform := spec.Platform
+	var required []NodeRequirement
+	if platform.OS != "" {
+		required = append(required, NodeRequirement{Key: "kubernetes.io/os", Values: []string{platform.OS}})
+	}
+	if platform.Arch != "" {
+		required = append(required, NodeRequirement{Key: "kubernetes.io/arch", Values: []string{platform.Arch}})
+	}
+	for _, feature := range platform.Features {
+		required = append(required, NodeRequirement{Key: labelFeature(labelPrefix(spec), feature), Values: []string{"true"}})
+	}
+	if len(required) == 0 {
+		return
+	}
+	if spec.PodSpec.Affinity == nil {
+		spec.PodSpec.Affinity = new(Affinity)
+	}
+	spec.PodSpec.Affinity.Required = append(spec.PodSpec.Affinity.Required, required...)
+}
+
+// helper function returns the platform in the os/arch/variant
+// format.
+func toPlatformString(platform Platform) string {
+	parts := []string{platform.OS, platform.Arch}
+	if parts[0] == "" {
+		parts[0] = "linux"
+	}
+	if parts[1] == "" {
+		parts[1] = "amd64"
+	}
+	if platform.Variant != "" {
+		parts = append(parts, platform.Variant)
+	}
+	s := strings.Join(parts, "/")
+	if len(platform.Features) != 0 {
+		s += " with " + strings.Join(platform.Features, ", ")
+	}
+	return s
+}
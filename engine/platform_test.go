@@ -0,0 +1,119 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPlatformNode(name, arch string, labels map[string]string) *v1.Node {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels["pool"] = "builds"
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{OperatingSystem: "linux", Architecture: arch},
+		},
+	}
+}
+
+func TestMatchPlatform(t *testing.T) {
+	nodes := []*v1.Node{
+		testPlatformNode("node-1", "amd64", nil),
+		testPlatformNode("node-2", "amd64", nil),
+	}
+	pod := &v1.Pod{Spec: v1.PodSpec{NodeSelector: map[string]string{"pool": "builds"}}}
+
+	spec := &Spec{Platform: Platform{OS: "linux", Arch: "arm64"}}
+	err := matchPlatform(spec, pod, nodes)
+	if got, want := CodeOf(err), CodePlatformUnsupported; got != want {
+		t.Fatalf("Want error code %s, got %s", want, got)
+	}
+	if !strings.Contains(err.Error(), "2 node(s) have architecture amd64") {
+		t.Errorf("Want the rejected nodes in the error, got %s", err)
+	}
+
+	spec = &Spec{Platform: Platform{Features: []string{"binfmt"}}}
+	if err := matchPlatform(spec, pod, nodes); err == nil || !strings.Contains(err.Error(), "lack feature binfmt") {
+		t.Errorf("Want missing feature error, got %v", err)
+	}
+	nodes[1].Labels["io.drone.feature.binfmt"] = "true"
+	if err := matchPlatform(spec, pod, nodes); err != nil {
+		t.Errorf("Want platform supported, got %s", err)
+	}
+
+	// nodes that do not match the node selector are ignored.
+	pod.Spec.NodeSelector["pool"] = "gpu"
+	spec = &Spec{Platform: Platform{Arch: "arm64"}}
+	if err := matchPlatform(spec, pod, nodes); err != nil {
+		t.Errorf("Want no error if no node matches the node selector, got %s", err)
+	}
+}
+
+func TestCheckPlatform(t *testing.T) {
+	client := fake.NewSimpleClientset(testPlatformNode("node-1", "amd64", nil))
+	k := &Kubernetes{client: client}
+	lists := func() (n int) {
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "list" && action.GetResource().Resource == "nodes" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// the nodes are not read if the platform is enforced by
+	// the scheduler.
+	spec := &Spec{Platform: Platform{OS: "linux", Arch: "arm64"}}
+	if err := k.checkPlatform(context.Background(), spec); err != nil {
+		t.Error(err)
+	}
+	if spec.PodSpec.Affinity == nil || len(spec.PodSpec.Affinity.Required) != 2 {
+		t.Errorf("Want the platform requirements added")
+	}
+	if got := lists(); got != 0 {
+		t.Errorf("Want nodes not listed without variant or features, got %d lists", got)
+	}
+
+	spec = &Spec{Platform: Platform{Features: []string{"binfmt"}}}
+	if got, want := CodeOf(k.checkPlatform(context.Background(), spec)), CodePlatformUnsupported; got != want {
+		t.Errorf("Want error code %s, got %s", want, got)
+	}
+	spec = &Spec{Platform: Platform{Arch: "amd64", Variant: "v8"}}
+	if err := k.checkPlatform(context.Background(), spec); err != nil {
+		t.Errorf("Want the variant ignored if the node does not advertise it, got %s", err)
+	}
+
+	// the nodes are read from the cache.
+	if got := lists(); got != 1 {
+		t.Errorf("Want the nodes listed once, got %d lists", got)
+	}
+}
+
+func TestConstrainPlatform(t *testing.T) {
+	spec := &Spec{Platform: Platform{OS: "linux", Arch: "arm64", Features: []string{"binfmt"}}}
+	constrainPlatform(spec)
+	required := spec.PodSpec.Affinity.Required
+	if len(required) != 3 {
+		t.Fatalf("Want platform requirements added, got %v", required)
+	}
+	if got, want := required[2].Key, "io.drone.feature.binfmt"; got != want {
+		t.Errorf("Want feature requirement %s, got %s", want, got)
+	}
+
+	spec = &Spec{}
+	constrainPlatform(spec)
+	if spec.PodSpec.Affinity != nil {
+		t.Errorf("Want no requirements without a platform")
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// CheckCapacity returns an error if the cluster cannot schedule
+// the pipeline pod. The pod is submitted as a server-side dry
+// run to detect pods that exceed the namespace quota, and the
+// scheduler is simulated against the allocatable resources of
+// the nodes. Nil is returned if the capacity cannot be
+// determined, for example if the runner cannot list nodes.
+func (k *Kubernetes) CheckCapacity(ctx context.Context, spec *Spec) error {
+	pod, err := toTemplatePod(spec)
+	if err != nil {
+		return nil
+	}
+
+	// the namespace of isolated pipelines does not exist
+	// until the pipeline is setup.
+	if !spec.PodSpec.Isolated {
+		err := k.retry(ctx, func() error {
+			return k.client.CoreV1().RESTClient().Post().
+				Namespace(spec.PodSpec.Namespace).
+				Resource("pods").
+				VersionedParams(&metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}, scheme.ParameterCodec).
+				Body(pod).
+				Do().
+				Error()
+		})
+		if kerrors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota") {
+			return &Error{Code: CodeQuotaExceeded, Err: err}
+		}
+	}
+
+	nodes, err := k.client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		logrus.WithError(err).Debugln("cannot list nodes to check capacity")
+		return nil
+	}
+	pods, err := k.client.CoreV1().Pods("").List(metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		logrus.WithError(err).Debugln("cannot list pods to check capacity")
+		return nil
+	}
+	return schedule(pod, nodes.Items, pods.Items)
+}
+
+// helper function returns an error if none of the nodes can
+// schedule the pod, with the reasons each node was rejected in
+// the same format as the scheduler.
+func schedule(pod *v1.Pod, nodes []v1.Node, pods []v1.Pod) error {
+	used := map[string]v1.ResourceList{}
+	count := map[string]int64{}
+	for i := range pods {
+		name := pods[i].Spec.NodeName
+		if name == "" {
+			continue
+		}
+		count[name]++
+		if used[name] == nil {
+			used[name] = v1.ResourceList{}
+		}
+		addResources(used[name], podRequests(&pods[i]))
+	}
+
+	need := podRequests(pod)
+	reasons := map[string]int{}
+	for i := range nodes {
+		reason := fits(pod, need, &nodes[i], used[nodes[i].Name], count[nodes[i].Name])
+		if reason == "" {
+			return nil
+		}
+		reasons[reason]++
+	}
+
+	var summary []string
+	for reason, n := range reasons {
+		summary = append(summary, fmt.Sprintf("%d %s", n, reason))
+	}
+	sort.Strings(summary)
+	return &Error{
+		Code: CodeSchedulingFailed,
+		Err: fmt.Errorf("engine: 0/%d nodes are available: %s",
+			len(nodes), strings.Join(summary, ", ")),
+	}
+}
+
+// helper function returns the reason the node cannot schedule
+// the pod, or an empty string if the pod fits on the node.
+func fits(pod *v1.Pod, need v1.ResourceList, node *v1.Node, used v1.ResourceList, count int64) string {
+	switch {
+	case pod.Spec.NodeName != "" && pod.Spec.NodeName != node.Name:
+		return "node(s) didn't match the node name"
+	case node.Spec.Unschedulable:
+		return "node(s) were unschedulable"
+	case !isNodeReady(node):
+		return "node(s) were not ready"
+	case !matchNodeSelector(pod, node):
+		return "node(s) didn't match node selector"
+	case !toleratesTaints(pod, node):
+		return "node(s) had taints that the pod didn't tolerate"
+	}
+	allocatable := node.Status.Allocatable
+	if pods, ok := allocatable[v1.ResourcePods]; ok && count >= pods.Value() {
+		return "Too many pods"
+	}
+	var names []string
+	for name := range need {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		quantity := need[v1.ResourceName(name)]
+		free := allocatable[v1.ResourceName(name)]
+		free.Sub(used[v1.ResourceName(name)])
+		if free.Cmp(quantity) < 0 {
+			return "Insufficient " + name
+		}
+	}
+	return ""
+}
+
+// helper function returns the resources requested by the pod.
+// The requests of a container default to the limits, and the
+// pod requests the larger of the sum of the containers and the
+// largest init container.
+func podRequests(pod *v1.Pod) v1.ResourceList {
+	total := v1.ResourceList{}
+	for i := range pod.Spec.Containers {
+		addResources(total, containerRequests(&pod.Spec.Containers[i]))
+	}
+	for i := range pod.Spec.InitContainers {
+		for name, quantity := range containerRequests(&pod.Spec.InitContainers[i]) {
+			if current, ok := total[name]; !ok || quantity.Cmp(current) > 0 {
+				total[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	return total
+}
+
+func containerRequests(container *v1.Container) v1.ResourceList {
+	requests := v1.ResourceList{}
+	for name, quantity := range container.Resources.Limits {
+		requests[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range container.Resources.Requests {
+		requests[name] = quantity.DeepCopy()
+	}
+	return requests
+}
+
+func addResources(dst, src v1.ResourceList) {
+	for name, quantity := range src {
+		current, ok := dst[name]
+		if !ok {
+			current = *resource.NewQuantity(0, quantity.Format)
+		}
+		current.Add(quantity)
+		dst[name] = current
+	}
+}
+
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// helper function returns true if the node matches the node
+// selector and the required node affinity of the pod.
+func matchNodeSelector(pod *v1.Pod, node *v1.Node) bool {
+	for key, value := range pod.Spec.NodeSelector {
+		if node.Labels[key] != value {
+			return false
+		}
+	}
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil ||
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	// the node must match one of the terms, and all of the
+	// expressions of the term.
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if matchExpressions(term.MatchExpressions, node.Labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchExpressions(expressions []v1.NodeSelectorRequirement, labels map[string]string) bool {
+	for _, expr := range expressions {
+		value, ok := labels[expr.Key]
+		switch expr.Operator {
+		case v1.NodeSelectorOpIn:
+			if !ok || !containsValue(expr.Values, value) {
+				return false
+			}
+		case v1.NodeSelectorOpNotIn:
+			if ok && containsValue(expr.Values, value) {
+				return false
+			}
+		case v1.NodeSelectorOpExists:
+			if !ok {
+				return false
+			}
+		case v1.NodeSelectorOpDoesNotExist:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the pod tolerates the taints
+// of the node that prevent scheduling.
+func toleratesTaints(pod *v1.Pod, node *v1.Node) bool {
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for j := range pod.Spec.Tolerations {
+			if pod.Spec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
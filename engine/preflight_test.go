@@ -0,0 +1,137 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testNode(name, cpu, memory string) v1.Node {
+	return v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"pool": "builds"},
+		},
+		Status: v1.NodeStatus{
+			Allocatable: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(memory),
+				v1.ResourcePods:   resource.MustParse("110"),
+			},
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func testPod(node, cpu, memory string) v1.Pod {
+	return v1.Pod{
+		Spec: v1.PodSpec{
+			NodeName: node,
+			Containers: []v1.Container{
+				{
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceCPU:    resource.MustParse(cpu),
+							v1.ResourceMemory: resource.MustParse(memory),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	nodes := []v1.Node{
+		testNode("node-1", "4", "8Gi"),
+		testNode("node-2", "4", "8Gi"),
+	}
+	pods := []v1.Pod{
+		testPod("node-1", "3", "1Gi"),
+		testPod("node-2", "1", "7Gi"),
+	}
+
+	pod := testPod("", "2", "2Gi")
+	err := schedule(&pod, nodes, pods)
+	if err == nil {
+		t.Fatalf("Want error when no node has capacity")
+	}
+	if got, want := CodeOf(err), CodeSchedulingFailed; got != want {
+		t.Errorf("Want error code %s, got %s", want, got)
+	}
+	if !strings.Contains(err.Error(), "1 Insufficient cpu") ||
+		!strings.Contains(err.Error(), "1 Insufficient memory") {
+		t.Errorf("Want insufficient resources reported, got %s", err)
+	}
+
+	pod = testPod("", "1", "1Gi")
+	if err := schedule(&pod, nodes, pods); err != nil {
+		t.Errorf("Want pod scheduled, got %s", err)
+	}
+}
+
+func TestSchedule_NodeSelector(t *testing.T) {
+	nodes := []v1.Node{testNode("node-1", "4", "8Gi")}
+	pod := testPod("", "1", "1Gi")
+	pod.Spec.NodeSelector = map[string]string{"pool": "gpu"}
+	if err := schedule(&pod, nodes, nil); err == nil {
+		t.Errorf("Want error when no node matches the node selector")
+	}
+	pod.Spec.NodeSelector = map[string]string{"pool": "builds"}
+	if err := schedule(&pod, nodes, nil); err != nil {
+		t.Errorf("Want pod scheduled, got %s", err)
+	}
+}
+
+func TestSchedule_Taints(t *testing.T) {
+	node := testNode("node-1", "4", "8Gi")
+	node.Spec.Taints = []v1.Taint{
+		{Key: "dedicated", Value: "builds", Effect: v1.TaintEffectNoSchedule},
+	}
+	pod := testPod("", "1", "1Gi")
+	if err := schedule(&pod, []v1.Node{node}, nil); err == nil {
+		t.Errorf("Want error when the pod does not tolerate the taint")
+	}
+	pod.Spec.Tolerations = []v1.Toleration{
+		{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "builds", Effect: v1.TaintEffectNoSchedule},
+	}
+	if err := schedule(&pod, []v1.Node{node}, nil); err != nil {
+		t.Errorf("Want pod scheduled, got %s", err)
+	}
+}
+
+func TestPodRequests(t *testing.T) {
+	pod := testPod("", "500m", "1Gi")
+	pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{
+		Resources: v1.ResourceRequirements{
+			Limits: v1.ResourceList{
+				"devices.kubevirt.io/kvm": resource.MustParse("1"),
+			},
+		},
+	})
+	pod.Spec.InitContainers = []v1.Container{
+		{
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceCPU: resource.MustParse("2"),
+				},
+			},
+		},
+	}
+	requests := podRequests(&pod)
+	if cpu := requests[v1.ResourceCPU]; cpu.MilliValue() != 2000 {
+		t.Errorf("Want init container cpu request, got %s", cpu.String())
+	}
+	if kvm := requests["devices.kubevirt.io/kvm"]; kvm.Value() != 1 {
+		t.Errorf("Want device request defaulted to the limit")
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// pulls coordinates the image pulls of the pipeline pods, so
+// that concurrent pipelines do not saturate the node network
+// or exceed the registry pull rate limits.
+type pulls struct {
+	perNode  int
+	cooldown time.Duration
+
+	mu sync.Mutex
+	// pods pulling images, by node.
+	nodes map[string]map[string]bool
+	// time the registry rate limit expires, by registry.
+	limited map[string]time.Time
+}
+
+// ThrottlePulls coordinates the image pulls of concurrent
+// pipeline pods. Pipeline pods prefer the nodes where fewer
+// than perNode pods are pulling images, and pipeline pods that
+// pull from a registry that rejected a pull with a rate limit
+// error are not created until the cooldown expires. The node
+// limit is not enforced if zero.
+func (k *Kubernetes) ThrottlePulls(perNode int, cooldown time.Duration) {
+	k.pulls = &pulls{
+		perNode:  perNode,
+		cooldown: cooldown,
+		nodes:    map[string]map[string]bool{},
+		limited:  map[string]time.Time{},
+	}
+}
+
+// helper function prefers the nodes where fewer than the
+// maximum number of pods are pulling images. The nodes are
+// preferred, not required, so that the pod is scheduled on a
+// busy node if no other node is available.
+func (p *pulls) avoidBusy(spec *Spec) {
+	if p == nil || p.perNode <= 0 || spec.PodSpec.NodeName != "" {
+		return
+	}
+	p.mu.Lock()
+	var nodes []string
+	for node, pods := range p.nodes {
+		if len(pods) >= p.perNode {
+			nodes = append(nodes, node)
+		}
+	}
+	p.mu.Unlock()
+	if len(nodes) == 0 {
+		return
+	}
+	sort.Strings(nodes)
+	if spec.PodSpec.Affinity == nil {
+		spec.PodSpec.Affinity = new(Affinity)
+	}
+	spec.PodSpec.Affinity.Preferred = append(spec.PodSpec.Affinity.Preferred, NodeRequirement{
+		Key:      "kubernetes.io/hostname",
+		Operator: string(v1.NodeSelectorOpNotIn),
+		Values:   nodes,
+		Weight:   100,
+	})
+}
+
+// helper function blocks until the rate limits of the
+// registries of the pipeline images expire, or the context
+// is cancelled.
+func (p *pulls) waitRegistries(ctx context.Context, spec *Spec) error {
+	if p == nil {
+		return nil
+	}
+	for {
+		wait := p.limit(spec)
+		if wait <= 0 {
+			return nil
+		}
+		logrus.WithField("pod", spec.PodSpec.Name).
+			WithField("wait", wait).
+			Infoln("registry rate limit exceeded, delaying pod creation")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// helper function returns the time remaining until the rate
+// limits of the registries of the pipeline images expire.
+func (p *pulls) limit(spec *Spec) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	for _, name := range specImages(spec) {
+		if until, ok := p.limited[image.Domain(name)]; ok && until.Sub(now) > wait {
+			wait = until.Sub(now)
+		}
+	}
+	return wait
+}
+
+// helper function records the image pulls of the pod. The pod
+// is pulling images once it is scheduled, until it is running
+// or the pod fails. A pull rejected with a rate limit error
+// starts the cooldown of the registry.
+func (p *pulls) observe(pod *v1.Pod) {
+	if p == nil || pod.Spec.NodeName == "" {
+		return
+	}
+	key := pod.Namespace + "/" + pod.Name
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pod.Status.Phase == v1.PodPending {
+		if p.nodes[pod.Spec.NodeName] == nil {
+			p.nodes[pod.Spec.NodeName] = map[string]bool{}
+		}
+		p.nodes[pod.Spec.NodeName][key] = true
+	} else {
+		p.release(pod.Spec.NodeName, key)
+	}
+
+	var statuses []v1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil && isRateLimited(waiting) {
+			p.limited[image.Domain(status.Image)] = time.Now().Add(p.cooldown)
+		}
+	}
+}
+
+// helper function removes the pod from the pods pulling images,
+// once the pod is destroyed.
+func (p *pulls) forget(spec *Spec) {
+	if p == nil {
+		return
+	}
+	key := spec.PodSpec.Namespace + "/" + spec.PodSpec.Name
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for node := range p.nodes {
+		p.release(node, key)
+	}
+}
+
+// helper function removes the pod from the pods pulling images
+// on the node. The caller must hold the lock.
+func (p *pulls) release(node, key string) {
+	delete(p.nodes[node], key)
+	if len(p.nodes[node]) == 0 {
+		delete(p.nodes, node)
+	}
+}
+
+// helper function returns true if the image pull was rejected
+// by the registry rate limit.
+func isRateLimited(waiting *v1.ContainerStateWaiting) bool {
+	switch waiting.Reason {
+	case "ErrImagePull", "ImagePullBackOff":
+	default:
+		return false
+	}
+	message := strings.ToLower(waiting.Message)
+	return strings.Contains(message, "toomanyrequests") ||
+		strings.Contains(message, "429 too many requests")
+}
+
+// helper function returns the images of the pipeline pod.
+func specImages(spec *Spec) []string {
+	var images []string
+	for _, step := range spec.Init {
+		images = append(images, step.Image)
+	}
+	for _, sidecar := range spec.Sidecars {
+		images = append(images, sidecar.Image)
+	}
+	for _, step := range spec.Steps {
+		images = append(images, step.Image)
+	}
+	return images
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPullsAvoidBusy(t *testing.T) {
+	k := new(Kubernetes)
+	k.ThrottlePulls(1, time.Minute)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "drone-a"},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+		Status:     v1.PodStatus{Phase: v1.PodPending},
+	}
+	k.pulls.observe(pod)
+
+	spec := new(Spec)
+	k.pulls.avoidBusy(spec)
+	if spec.PodSpec.Affinity == nil || len(spec.PodSpec.Affinity.Preferred) != 1 {
+		t.Errorf("Want busy node avoided")
+		return
+	}
+	got := spec.PodSpec.Affinity.Preferred[0]
+	if got.Operator != "NotIn" || len(got.Values) != 1 || got.Values[0] != "node-1" {
+		t.Errorf("Want node-1 avoided, got %v", got)
+	}
+
+	// the node is no longer busy once the pod is running.
+	pod.Status.Phase = v1.PodRunning
+	k.pulls.observe(pod)
+	spec = new(Spec)
+	k.pulls.avoidBusy(spec)
+	if spec.PodSpec.Affinity != nil {
+		t.Errorf("Want no node avoided once the pod is running")
+	}
+}
+
+func TestPullsForget(t *testing.T) {
+	k := new(Kubernetes)
+	k.ThrottlePulls(1, time.Minute)
+	k.pulls.observe(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "drone-a"},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+		Status:     v1.PodStatus{Phase: v1.PodPending},
+	})
+	k.pulls.forget(&Spec{PodSpec: PodSpec{Namespace: "default", Name: "drone-a"}})
+	if len(k.pulls.nodes) != 0 {
+		t.Errorf("Want destroyed pod removed from the pulling pods")
+	}
+}
+
+func TestPullsRateLimited(t *testing.T) {
+	k := new(Kubernetes)
+	k.ThrottlePulls(0, time.Hour)
+	k.pulls.observe(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "drone-a"},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			ContainerStatuses: []v1.ContainerStatus{{
+				Image: "golang:1.14",
+				State: v1.ContainerState{
+					Waiting: &v1.ContainerStateWaiting{
+						Reason:  "ErrImagePull",
+						Message: "toomanyrequests: You have reached your pull rate limit.",
+					},
+				},
+			}},
+		},
+	})
+
+	spec := &Spec{Steps: []*Step{{Image: "node:12"}}}
+	if k.pulls.limit(spec) <= 0 {
+		t.Errorf("Want docker hub rate limited")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := k.pulls.waitRegistries(ctx, spec); err == nil {
+		t.Errorf("Want error waiting for the rate limit if cancelled")
+	}
+
+	spec = &Spec{Steps: []*Step{{Image: "gcr.io/distroless/base"}}}
+	if err := k.pulls.waitRegistries(ctx, spec); err != nil {
+		t.Errorf("Want other registries not rate limited")
+	}
+}
+
+func TestPullsDisabled(t *testing.T) {
+	var p *pulls
+	spec := new(Spec)
+	p.avoidBusy(spec)
+	p.observe(&v1.Pod{})
+	p.forget(spec)
+	if err := p.waitRegistries(context.Background(), spec); err != nil {
+		t.Error(err)
+	}
+}
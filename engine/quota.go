@@ -0,0 +1,148 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// LimitObjects limits the number of pods and secrets the
+// runner creates in each namespace, so that pipelines queue
+// instead of exceeding the namespace object count quota. A
+// zero value disables the limit for the object kind.
+func (k *Kubernetes) LimitObjects(pods, secrets int) {
+	k.quota = newQuota(pods, secrets)
+}
+
+// objects tracks the number of objects created in a namespace.
+type objects struct {
+	pods    int
+	secrets int
+}
+
+// quota tracks the number of objects created by the runner
+// in each namespace, and blocks pipelines until the objects
+// they create fit within the soft limits.
+type quota struct {
+	pods    int
+	secrets int
+
+	mu      sync.Mutex
+	used    map[string]*objects
+	release chan struct{}
+}
+
+func newQuota(pods, secrets int) *quota {
+	return &quota{
+		pods:    pods,
+		secrets: secrets,
+		used:    map[string]*objects{},
+		release: make(chan struct{}),
+	}
+}
+
+// acquire blocks until the objects created by the pipeline fit
+// within the namespace limits, or the context is cancelled. It
+// returns a function that releases the objects. The wait
+// function is invoked once if the pipeline must wait. A nil
+// quota acquires immediately.
+func (q *quota) acquire(ctx context.Context, spec *Spec, wait func()) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+	namespace := spec.PodSpec.Namespace
+	need := toObjects(spec)
+	for waiting := false; ; waiting = true {
+		q.mu.Lock()
+		used, ok := q.used[namespace]
+		if !ok {
+			used = new(objects)
+			q.used[namespace] = used
+		}
+		if q.fits(used, need) {
+			used.pods += need.pods
+			used.secrets += need.secrets
+			q.mu.Unlock()
+			return func() { q.free(namespace, need) }, nil
+		}
+		release := q.release
+		q.mu.Unlock()
+
+		if !waiting {
+			wait()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-release:
+		}
+	}
+}
+
+// adopt counts the objects of a pipeline that already exist,
+// for example a pipeline recovered after the runner restarted,
+// and returns a function that releases the objects. A nil
+// quota adopts nothing.
+func (q *quota) adopt(spec *Spec) func() {
+	if q == nil {
+		return func() {}
+	}
+	namespace := spec.PodSpec.Namespace
+	need := toObjects(spec)
+	q.mu.Lock()
+	used, ok := q.used[namespace]
+	if !ok {
+		used = new(objects)
+		q.used[namespace] = used
+	}
+	used.pods += need.pods
+	used.secrets += need.secrets
+	q.mu.Unlock()
+	return func() { q.free(namespace, need) }
+}
+
+// helper function returns true if the objects fit within the
+// limits. The objects always fit if the namespace is unused,
+// so that a pipeline that exceeds the limits by itself does
+// not block indefinitely.
+func (q *quota) fits(used, need *objects) bool {
+	if used.pods == 0 && used.secrets == 0 {
+		return true
+	}
+	if q.pods > 0 && used.pods+need.pods > q.pods {
+		return false
+	}
+	if q.secrets > 0 && used.secrets+need.secrets > q.secrets {
+		return false
+	}
+	return true
+}
+
+// helper function releases the objects and wakes the waiting
+// pipelines.
+func (q *quota) free(namespace string, need *objects) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if used, ok := q.used[namespace]; ok {
+		used.pods -= need.pods
+		used.secrets -= need.secrets
+		if used.pods <= 0 && used.secrets <= 0 {
+			delete(q.used, namespace)
+		}
+	}
+	close(q.release)
+	q.release = make(chan struct{})
+}
+
+// helper function returns the number of objects created by
+// the pipeline.
+func toObjects(spec *Spec) *objects {
+	need := &objects{pods: 1, secrets: 1}
+	if spec.PullSecret != nil {
+		need.secrets++
+	}
+	return need
+}
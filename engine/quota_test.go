@@ -0,0 +1,136 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	q := newQuota(2, 0)
+	spec := &Spec{PodSpec: PodSpec{Namespace: "default"}}
+
+	release1, err := q.acquire(context.Background(), spec, func() {
+		t.Errorf("Expect first pipeline does not wait")
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	release2, err := q.acquire(context.Background(), spec, func() {
+		t.Errorf("Expect second pipeline does not wait")
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	waited := 0
+	acquired := make(chan struct{})
+	go func() {
+		release, err := q.acquire(context.Background(), spec, func() { waited++ })
+		if err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Errorf("Expect third pipeline waits for the namespace quota")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release1()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Errorf("Expect third pipeline acquires the quota when released")
+		return
+	}
+	if got, want := waited, 1; got != want {
+		t.Errorf("Want wait function invoked %d time, got %d", want, got)
+	}
+
+	// a pipeline in a different namespace is not limited
+	// by the default namespace quota.
+	other := &Spec{PodSpec: PodSpec{Namespace: "other"}}
+	release3, err := q.acquire(context.Background(), other, func() {
+		t.Errorf("Expect pipeline in other namespace does not wait")
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	release3()
+	release2()
+
+	if got := len(q.used); got != 0 {
+		t.Errorf("Want namespace usage removed when released, got %d", got)
+	}
+}
+
+func TestQuota_Cancel(t *testing.T) {
+	q := newQuota(0, 1)
+	spec := &Spec{PodSpec: PodSpec{Namespace: "default"}}
+	release, err := q.acquire(context.Background(), spec, func() {})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = q.acquire(ctx, spec, func() {})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded error, got %v", err)
+	}
+}
+
+func TestQuota_Adopt(t *testing.T) {
+	q := newQuota(1, 0)
+	spec := &Spec{PodSpec: PodSpec{Namespace: "default"}}
+
+	// recovered pipelines are counted even if the namespace
+	// quota is exceeded, because the objects already exist.
+	release1 := q.adopt(spec)
+	release2 := q.adopt(spec)
+	if got := q.used["default"].pods; got != 2 {
+		t.Errorf("Want 2 pods counted, got %d", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := q.acquire(ctx, spec, func() {}); err != context.DeadlineExceeded {
+		t.Errorf("Expect new pipeline waits for the recovered pipelines, got %v", err)
+	}
+
+	release1()
+	release2()
+	if got := len(q.used); got != 0 {
+		t.Errorf("Want namespace usage removed when released, got %d", got)
+	}
+}
+
+func TestQuota_Nil(t *testing.T) {
+	var q *quota
+	release, err := q.acquire(context.Background(), &Spec{}, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	release()
+	q.adopt(&Spec{})()
+}
+
+func TestToObjects(t *testing.T) {
+	spec := &Spec{PullSecret: &Secret{Name: "pull"}}
+	need := toObjects(spec)
+	if need.pods != 1 || need.secrets != 2 {
+		t.Errorf("Want 1 pod and 2 secrets, got %d pods and %d secrets", need.pods, need.secrets)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// specKey is the key of the pipeline secret that stores the
+// specification of recoverable pipelines.
+const specKey = "drone-spec.json"
+
+// sealedPrefix is the prefix of the pipeline specification
+// if the specification is encrypted with the runner key.
+const sealedPrefix = "sealed:"
+
+// Checkpoint provides the progress of a pipeline step, which
+// is recorded in the pod annotations so that the runner can
+// re-attach to the step after the runner process restarts.
+type Checkpoint struct {
+	Started  int64 `json:"started,omitempty"`
+	Stopped  int64 `json:"stopped,omitempty"`
+	Exited   bool  `json:"exited,omitempty"`
+	ExitCode int   `json:"exit_code,omitempty"`
+
+	// Marker provides the exit marker of a step that writes
+	// the step output to the container logs. Steps that do
+	// not have a marker cannot be re-attached.
+	Marker string `json:"marker,omitempty"`
+}
+
+// Recovered provides a pipeline that was running when the
+// runner process exited.
+type Recovered struct {
+	Spec *Spec
+
+	// Steps provides the checkpoints of the steps that were
+	// started, keyed by step name.
+	Steps map[string]*Checkpoint
+}
+
+// Recover returns the pipelines in the namespace that can be
+// re-attached, because the pipeline checkpoint was stored
+// when the pipeline was created, and that match the filter.
+// The pipelines are tracked, so that the pipelines are not
+// collected as leaked pipelines. The prefix must match the
+// label prefix used to compile the pipeline.
+func (k *Kubernetes) Recover(ctx context.Context, namespace, prefix string, match func(*Spec) bool) ([]*Recovered, error) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	pods, err := k.client.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: prefix + "=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var recovered []*Recovered
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if _, ok := pod.Annotations[annotationRetain(prefix)]; ok {
+			continue
+		}
+		secret, err := k.client.CoreV1().Secrets(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+		if err != nil {
+			continue
+		}
+		data, ok := secret.Data[specKey]
+		if !ok {
+			continue
+		}
+		spec, err := k.openSpec(data)
+		if err != nil {
+			logrus.WithError(err).
+				WithField("pod", pod.Name).
+				Warnln("cannot read the pipeline checkpoint")
+			continue
+		}
+		if !match(spec) {
+			continue
+		}
+		k.gc.track(spec)
+		k.heartbeats.track(spec)
+		recovered = append(recovered, &Recovered{
+			Spec:  spec,
+			Steps: parseCheckpoints(spec, pod.Annotations),
+		})
+	}
+	return recovered, nil
+}
+
+// Attach re-attaches to a pipeline step that was started
+// before the runner process restarted, and returns the step
+// state once the step exits. The step output is streamed from
+// the container logs, from the start of the step. Steps that
+// do not write the output to the container logs cannot be
+// re-attached, and are killed.
+func (k *Kubernetes) Attach(ctx context.Context, spec *Spec, step *Step, output io.Writer) (*State, error) {
+	var pod *v1.Pod
+	err := k.call(ctx, func(ctx context.Context) (err error) {
+		pod, err = k.coreV1(ctx).Pods(spec.PodSpec.Namespace).Get(spec.PodSpec.Name, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	cp, ok := parseCheckpoints(spec, pod.Annotations)[step.Name]
+	switch {
+	case !ok:
+		return k.Run(ctx, spec, step, output)
+	case cp.Exited:
+		return &State{Exited: true, ExitCode: cp.ExitCode}, nil
+	case cp.Marker == "":
+		k.kill(spec, step)
+		return nil, &Error{
+			Code: CodeExecDisconnected,
+			Err:  fmt.Errorf("engine: cannot re-attach to step %s after the runner restarted", step.Name),
+		}
+	}
+
+	state := &State{Exited: true}
+	t := &logTail{}
+	stream, err := k.openLogs(ctx, spec, step, t)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.followLogs(ctx, spec, step, t, stream, cp.Marker, state, output); err != nil {
+		return nil, err
+	}
+	cp.Exited, cp.ExitCode, cp.Stopped = true, state.ExitCode, time.Now().Unix()
+	k.checkpoint(spec, step, cp)
+	if spec.Outputs {
+		k.collectOutputs(spec, step)
+	}
+	return state, nil
+}
+
+// helper function adds the pipeline specification to the
+// pipeline secret, if the pipeline is recoverable. The
+// specification is encrypted with the runner key, if secret
+// encryption is enabled.
+func (k *Kubernetes) storeSpec(spec *Spec, secret *v1.Secret) error {
+	if len(spec.Checkpoint) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	value := string(data)
+	if k.kek != nil {
+		sealed, err := seal(k.kek, data)
+		if err != nil {
+			return err
+		}
+		value = sealedPrefix + sealed
+	}
+	if secret.StringData == nil {
+		secret.StringData = map[string]string{}
+	}
+	secret.StringData[specKey] = value
+	return nil
+}
+
+// helper function decodes the pipeline specification stored in
+// the pipeline secret.
+func (k *Kubernetes) openSpec(data []byte) (*Spec, error) {
+	if s := string(data); strings.HasPrefix(s, sealedPrefix) {
+		if k.kek == nil {
+			return nil, errInvalidKey
+		}
+		plain, err := unseal(k.kek, strings.TrimPrefix(s, sealedPrefix))
+		if err != nil {
+			return nil, err
+		}
+		data = plain
+	}
+	spec := new(Spec)
+	err := json.Unmarshal(data, spec)
+	return spec, err
+}
+
+// helper function records the step checkpoint in the pod
+// annotations, if the pipeline is recoverable. Errors are
+// logged, because the step does not fail if the checkpoint
+// cannot be recorded.
+func (k *Kubernetes) checkpoint(spec *Spec, step *Step, cp *Checkpoint) {
+	if len(spec.Checkpoint) == 0 {
+		return
+	}
+	value, _ := json.Marshal(cp)
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				annotationCheckpoint(labelPrefix(spec), step): string(value),
+			},
+		},
+	})
+	err := k.call(context.Background(), func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).Pods(spec.PodSpec.Namespace).Patch(spec.PodSpec.Name, types.MergePatchType, patch)
+		return err
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			WithField("step", step.Name).
+			Warnln("cannot record the step checkpoint")
+	}
+}
+
+// helper function returns the checkpoints of the pipeline
+// steps, keyed by step name.
+func parseCheckpoints(spec *Spec, annotations map[string]string) map[string]*Checkpoint {
+	steps := map[string]*Checkpoint{}
+	for _, step := range spec.Steps {
+		value, ok := annotations[annotationCheckpoint(labelPrefix(spec), step)]
+		if !ok {
+			continue
+		}
+		cp := new(Checkpoint)
+		if err := json.Unmarshal([]byte(value), cp); err != nil {
+			continue
+		}
+		steps[step.Name] = cp
+	}
+	return steps
+}
+
+// helper function returns the name of the annotation used to
+// record the step checkpoint.
+func annotationCheckpoint(prefix string, step *Step) string {
+	return prefix + ".step." + step.ID
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestStoreSpec(t *testing.T) {
+	kek, err := newAEAD(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	k := &Kubernetes{kek: kek}
+
+	spec := &Spec{
+		PodSpec:    PodSpec{Name: "drone-pod"},
+		Checkpoint: json.RawMessage(`{"machine":"runner-1"}`),
+		Steps:      []*Step{{ID: "drone-step", Name: "build"}},
+	}
+	secret := new(v1.Secret)
+	if err := k.storeSpec(spec, secret); err != nil {
+		t.Error(err)
+		return
+	}
+	value := secret.StringData[specKey]
+	if !strings.HasPrefix(value, sealedPrefix) {
+		t.Errorf("Want the pipeline specification sealed with the runner key")
+	}
+
+	got, err := k.openSpec([]byte(value))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if diff := cmp.Diff(got, spec); diff != "" {
+		t.Errorf("Unexpected pipeline specification")
+		t.Log(diff)
+	}
+
+	if _, err := new(Kubernetes).openSpec([]byte(value)); err == nil {
+		t.Errorf("Want error opening a sealed specification without the runner key")
+	}
+}
+
+func TestStoreSpec_NotRecoverable(t *testing.T) {
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-pod"}}
+	secret := new(v1.Secret)
+	if err := new(Kubernetes).storeSpec(spec, secret); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := secret.StringData[specKey]; ok {
+		t.Errorf("Want the specification stored for recoverable pipelines only")
+	}
+}
+
+func TestParseCheckpoints(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{ID: "step-clone", Name: "clone"},
+			{ID: "step-build", Name: "build"},
+			{ID: "step-test", Name: "test"},
+		},
+	}
+	annotations := map[string]string{
+		"io.drone.step.step-clone": `{"started":1,"stopped":2,"exited":true}`,
+		"io.drone.step.step-build": `{"started":3,"marker":"exit-marker"}`,
+		"io.drone.step.step-test":  `{invalid`,
+	}
+	want := map[string]*Checkpoint{
+		"clone": {Started: 1, Stopped: 2, Exited: true},
+		"build": {Started: 3, Marker: "exit-marker"},
+	}
+	got := parseCheckpoints(spec, annotations)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected checkpoints")
+		t.Log(diff)
+	}
+}
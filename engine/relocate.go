@@ -0,0 +1,131 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodePod describes a pipeline pod that runs on a node, so
+// that a node drain controller can decide whether to wait for
+// the pipeline to complete before the node is drained.
+type NodePod struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Node      string    `json:"node"`
+	Repo      string    `json:"repo"`
+	Build     int64     `json:"build"`
+	Stage     int64     `json:"stage"`
+	StageID   int64     `json:"stage_id"`
+	Started   time.Time `json:"started"`
+	Deadline  time.Time `json:"deadline"`
+
+	// Checkpoints provides the container checkpoint archives
+	// on the node, keyed by container name, if the pod was
+	// checkpointed.
+	Checkpoints map[string]string `json:"checkpoints,omitempty"`
+}
+
+// NodePods returns the pipeline pods that run on the named
+// node in the namespaces. The deadline of the pod is the
+// creation time plus the stage timeout, which is the latest
+// time the pipeline is expected to complete. The prefix must
+// match the label prefix used to compile the pipeline.
+func (k *Kubernetes) NodePods(namespaces []string, prefix, node string) ([]*NodePod, error) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	var pods []*NodePod
+	for _, namespace := range namespaces {
+		list, err := k.client.CoreV1().Pods(namespace).List(metav1.ListOptions{
+			LabelSelector: prefix + "=true",
+			FieldSelector: "spec.nodeName=" + node,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			pod := &list.Items[i]
+			if pod.Status.Phase != v1.PodPending && pod.Status.Phase != v1.PodRunning {
+				continue
+			}
+			pods = append(pods, toNodePod(pod, prefix))
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Deadline.Before(pods[j].Deadline)
+	})
+	return pods, nil
+}
+
+// Relocate excludes the named node from scheduling, so that
+// pipelines created after the call are scheduled on other
+// nodes while the node is drained. Pipelines that already run
+// on the node are not affected.
+func (k *Kubernetes) Relocate(node string) {
+	logrus.WithField("node", node).
+		Infoln("new pipelines are scheduled on other nodes")
+	k.relocated.Store(node, true)
+}
+
+// Release reverts the relocation of the named node, for
+// example once the node maintenance is complete.
+func (k *Kubernetes) Release(node string) {
+	logrus.WithField("node", node).
+		Infoln("new pipelines can be scheduled on the node")
+	k.relocated.Delete(node)
+}
+
+// helper function adds a node affinity requirement that
+// excludes the relocated nodes. The nodes are matched by the
+// hostname label, which defaults to the node name.
+func (k *Kubernetes) avoidRelocated(spec *Spec) {
+	var nodes []string
+	k.relocated.Range(func(key, _ interface{}) bool {
+		nodes = append(nodes, key.(string))
+		return true
+	})
+	if len(nodes) == 0 {
+		return
+	}
+	sort.Strings(nodes)
+	if spec.PodSpec.Affinity == nil {
+		spec.PodSpec.Affinity = new(Affinity)
+	}
+	spec.PodSpec.Affinity.Required = append(spec.PodSpec.Affinity.Required, NodeRequirement{
+		Key:      "kubernetes.io/hostname",
+		Operator: string(v1.NodeSelectorOpNotIn),
+		Values:   nodes,
+	})
+}
+
+// helper function returns the node pod from the pipeline pod
+// labels and annotations.
+func toNodePod(pod *v1.Pod, prefix string) *NodePod {
+	out := &NodePod{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Node:      pod.Spec.NodeName,
+		Repo:      pod.Annotations[prefix+".repo.slug"],
+		Started:   pod.CreationTimestamp.Time,
+	}
+	out.Build, _ = strconv.ParseInt(pod.Annotations[prefix+".build.number"], 10, 64)
+	out.Stage, _ = strconv.ParseInt(pod.Annotations[prefix+".stage.number"], 10, 64)
+	out.StageID, _ = strconv.ParseInt(pod.Annotations[prefix+".stage.id"], 10, 64)
+	if archives := parseContainerCheckpoints(pod, prefix); len(archives) != 0 {
+		out.Checkpoints = archives
+	}
+	if timeout, err := strconv.ParseInt(pod.Annotations[prefix+".stage.timeout"], 10, 64); err == nil && timeout > 0 {
+		out.Deadline = out.Started.Add(time.Duration(timeout) * time.Minute)
+	}
+	return out
+}
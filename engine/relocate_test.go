@@ -0,0 +1,70 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestToNodePod(t *testing.T) {
+	created := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "drone-abc",
+			CreationTimestamp: metav1.NewTime(created),
+			Annotations: map[string]string{
+				"io.drone.repo.slug":     "octocat/hello-world",
+				"io.drone.build.number":  "42",
+				"io.drone.stage.number":  "2",
+				"io.drone.stage.id":      "1337",
+				"io.drone.stage.timeout": "60",
+			},
+		},
+		Spec: v1.PodSpec{NodeName: "node-1"},
+	}
+	got := toNodePod(pod, DefaultLabelPrefix)
+	if got.Repo != "octocat/hello-world" || got.Build != 42 || got.Stage != 2 || got.StageID != 1337 {
+		t.Errorf("Unexpected node pod %+v", got)
+	}
+	if got, want := got.Deadline, created.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("Want deadline %s, got %s", want, got)
+	}
+}
+
+func TestAvoidRelocated(t *testing.T) {
+	k := &Kubernetes{}
+	spec := &Spec{}
+	k.avoidRelocated(spec)
+	if spec.PodSpec.Affinity != nil {
+		t.Errorf("Want no affinity without relocated nodes")
+	}
+
+	k.Relocate("node-2")
+	k.Relocate("node-1")
+	spec.PodSpec.Affinity = &Affinity{
+		Required: []NodeRequirement{{Key: "disktype", Values: []string{"ssd"}}},
+	}
+	k.avoidRelocated(spec)
+	required := spec.PodSpec.Affinity.Required
+	if len(required) != 2 {
+		t.Fatalf("Want relocated nodes added to the required affinity")
+	}
+	if req := required[1]; req.Operator != "NotIn" || len(req.Values) != 2 || req.Values[0] != "node-1" {
+		t.Errorf("Unexpected node requirement %+v", req)
+	}
+
+	k.Release("node-1")
+	k.Release("node-2")
+	spec = &Spec{}
+	k.avoidRelocated(spec)
+	if spec.PodSpec.Affinity != nil {
+		t.Errorf("Want no affinity once the nodes are released")
+	}
+}
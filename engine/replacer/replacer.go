@@ -7,6 +7,7 @@
 package replacer
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io"
 	"strings"
@@ -16,6 +17,10 @@ import (
 
 const maskedf = "[secret:%s]"
 
+// minLineSize is the minimum size of a line of a multi-line
+// secret that is masked.
+const minLineSize = 4
+
 // Replacer is an io.Writer that finds and masks sensitive data.
 type Replacer struct {
 	w io.WriteCloser
@@ -33,6 +38,34 @@ func New(w io.WriteCloser, secrets []*engine.Secret) io.WriteCloser {
 		masked := fmt.Sprintf(maskedf, name)
 		oldnew = append(oldnew, secret.Data)
 		oldnew = append(oldnew, masked)
+
+		// the step output is written line by line, so the
+		// lines of multi-line secrets are masked separately.
+		// The decoded value of binary secrets is masked too,
+		// for example a base64 encoded kubeconfig.
+		values := []string{secret.Data}
+		if secret.Binary {
+			if decoded, err := base64.StdEncoding.DecodeString(secret.Data); err == nil {
+				values = append(values, string(decoded))
+			}
+		}
+		for _, value := range values {
+			if value != secret.Data {
+				oldnew = append(oldnew, value, masked)
+			}
+			if !strings.Contains(value, "\n") {
+				continue
+			}
+			for _, line := range strings.Split(value, "\n") {
+				line = strings.TrimSpace(line)
+				// short lines are not masked, to avoid masking
+				// common words and punctuation.
+				if len(line) < minLineSize {
+					continue
+				}
+				oldnew = append(oldnew, line, masked)
+			}
+		}
 	}
 	if len(oldnew) == 0 {
 		return w
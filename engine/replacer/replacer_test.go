@@ -31,6 +31,26 @@ func TestReplace(t *testing.T) {
 	}
 }
 
+// this test verifies that the lines of multi-line secrets, and
+// the decoded value of binary secrets, are masked when the
+// output is written line by line.
+func TestReplaceMultiline(t *testing.T) {
+	secrets := []*engine.Secret{
+		{Name: "SSH_KEY", Data: "-----BEGIN KEY-----\nMIIEowIBAAKCAQEA\n-----END KEY-----\n", Mask: true},
+		{Name: "TOKEN", Data: "c2VjcmV0LXRva2Vu", Mask: true, Binary: true},
+	}
+
+	buf := new(bytes.Buffer)
+	w := New(&nopCloser{buf}, secrets)
+	w.Write([]byte("MIIEowIBAAKCAQEA\n"))
+	w.Write([]byte("token secret-token\n"))
+	w.Close()
+
+	if got, want := buf.String(), "[secret:ssh_key]\ntoken [secret:token]\n"; got != want {
+		t.Errorf("Want masked string %q, got %q", want, got)
+	}
+}
+
 // this test verifies that if there are no secrets to scan and
 // mask, the io.WriteCloser is returned as-is.
 func TestReplaceNone(t *testing.T) {
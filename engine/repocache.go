@@ -0,0 +1,176 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// helper function returns the name of the label used to
+// identify the repository cache volume claims.
+func labelCache(prefix string) string {
+	return prefix + ".cache"
+}
+
+// helper function returns the name of the annotation used to
+// store the time the repository cache was last used.
+func annotationLastUsed(prefix string) string {
+	return prefix + ".last-used"
+}
+
+// helper function returns true if the volume claim is a
+// repository cache.
+func isCache(spec *Spec, claim *v1.PersistentVolumeClaim) bool {
+	return claim.Labels[labelCache(labelPrefix(spec))] == "true"
+}
+
+// helper function updates the time the repository cache was
+// last used, so that the cache janitor evicts the least
+// recently used caches first. The pipeline does not fail if
+// the claim cannot be updated.
+func (k *Kubernetes) touchCache(ctx context.Context, spec *Spec, name string) {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				annotationLastUsed(labelPrefix(spec)): time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	err := k.call(ctx, func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).PersistentVolumeClaims(spec.PodSpec.Namespace).Patch(name, types.MergePatchType, patch)
+		return err
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("claim", name).
+			Debugln("cannot update the repository cache")
+	}
+}
+
+// ReapCaches deletes the least recently used repository cache
+// volume claims in the namespace until the total requested
+// storage does not exceed the limit. Caches that are mounted
+// by a pipeline pod are not deleted. ReapCaches blocks until
+// the context is cancelled. The prefix must match the label
+// prefix used to compile the pipeline.
+func (k *Kubernetes) ReapCaches(ctx context.Context, namespace, prefix string, limit int64, interval time.Duration) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		k.reapCaches(ctx, namespace, prefix, limit)
+	}
+}
+
+// helper function deletes the least recently used caches.
+func (k *Kubernetes) reapCaches(ctx context.Context, namespace, prefix string, limit int64) {
+	var claims *v1.PersistentVolumeClaimList
+	err := k.call(ctx, func(ctx context.Context) (err error) {
+		claims, err = k.coreV1(ctx).PersistentVolumeClaims(namespace).List(metav1.ListOptions{
+			LabelSelector: labelCache(prefix) + "=true",
+		})
+		return err
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("namespace", namespace).
+			Warnln("cannot list repository caches")
+		return
+	}
+	var pods *v1.PodList
+	err = k.call(ctx, func(ctx context.Context) (err error) {
+		pods, err = k.coreV1(ctx).Pods(namespace).List(metav1.ListOptions{
+			LabelSelector: prefix + "=true",
+		})
+		return err
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("namespace", namespace).
+			Warnln("cannot list pipeline pods")
+		return
+	}
+	for _, name := range toEvicted(claims.Items, toMounted(pods.Items), prefix, limit) {
+		k.deletes.wait(priorityLow)
+		err := k.call(ctx, func(ctx context.Context) error {
+			return k.coreV1(ctx).PersistentVolumeClaims(namespace).Delete(name, &metav1.DeleteOptions{})
+		})
+		if err != nil && !kerrors.IsNotFound(err) {
+			logrus.WithError(err).
+				WithField("claim", name).
+				Warnln("cannot delete repository cache")
+			continue
+		}
+		logrus.WithField("claim", name).
+			Debugln("deleted least recently used repository cache")
+	}
+}
+
+// helper function returns the names of the volume claims
+// mounted by the pods that have not terminated.
+func toMounted(pods []v1.Pod) map[string]bool {
+	mounted := map[string]bool{}
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				mounted[volume.PersistentVolumeClaim.ClaimName] = true
+			}
+		}
+	}
+	return mounted
+}
+
+// helper function returns the names of the least recently
+// used caches that are evicted so that the total requested
+// storage does not exceed the limit. The creation time is
+// used if the cache has not been used since it was created.
+func toEvicted(claims []v1.PersistentVolumeClaim, mounted map[string]bool, prefix string, limit int64) []string {
+	lastUsed := func(claim *v1.PersistentVolumeClaim) time.Time {
+		t, err := time.Parse(time.RFC3339, claim.Annotations[annotationLastUsed(prefix)])
+		if err != nil {
+			return claim.CreationTimestamp.Time
+		}
+		return t
+	}
+	sort.Slice(claims, func(i, j int) bool {
+		return lastUsed(&claims[i]).Before(lastUsed(&claims[j]))
+	})
+	var total int64
+	for _, claim := range claims {
+		size := claim.Spec.Resources.Requests[v1.ResourceStorage]
+		total += size.Value()
+	}
+	var evicted []string
+	for _, claim := range claims {
+		if total <= limit {
+			break
+		}
+		if mounted[claim.Name] {
+			continue
+		}
+		size := claim.Spec.Resources.Requests[v1.ResourceStorage]
+		total -= size.Value()
+		evicted = append(evicted, claim.Name)
+	}
+	return evicted
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testCacheClaim(name string, used time.Time, size int64) v1.PersistentVolumeClaim {
+	return v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				"io.drone.last-used": used.Format(time.RFC3339),
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: *resource.NewQuantity(size, resource.BinarySI),
+				},
+			},
+		},
+	}
+}
+
+func TestToEvicted(t *testing.T) {
+	now := time.Now()
+	claims := []v1.PersistentVolumeClaim{
+		testCacheClaim("recent", now, 10),
+		testCacheClaim("oldest", now.Add(-3*time.Hour), 10),
+		testCacheClaim("older", now.Add(-2*time.Hour), 10),
+		testCacheClaim("old", now.Add(-time.Hour), 10),
+	}
+	mounted := map[string]bool{"oldest": true}
+	got := toEvicted(claims, mounted, DefaultLabelPrefix, 20)
+	if len(got) != 2 || got[0] != "older" || got[1] != "old" {
+		t.Errorf("Want least recently used caches evicted, skipping mounted caches, got %v", got)
+	}
+	if got := toEvicted(claims, nil, DefaultLabelPrefix, 40); len(got) != 0 {
+		t.Errorf("Want no caches evicted within the limit, got %v", got)
+	}
+}
+
+func TestToMounted(t *testing.T) {
+	claim := func(name string) v1.Volume {
+		return v1.Volume{
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: name},
+			},
+		}
+	}
+	pods := []v1.Pod{
+		{
+			Spec:   v1.PodSpec{Volumes: []v1.Volume{claim("running")}},
+			Status: v1.PodStatus{Phase: v1.PodRunning},
+		},
+		{
+			Spec:   v1.PodSpec{Volumes: []v1.Volume{claim("done")}},
+			Status: v1.PodStatus{Phase: v1.PodSucceeded},
+		},
+	}
+	mounted := toMounted(pods)
+	if !mounted["running"] || mounted["done"] {
+		t.Errorf("Want only claims of running pods mounted, got %v", mounted)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/drone/runner-go/manifest"
+
+	"github.com/buildkite/yaml"
+)
+
+// TypeDocker defines the Resource Type of docker pipelines.
+// Docker pipelines are translated to kubernetes pipelines,
+// so that repositories can migrate without rewriting the
+// configuration file:
+//
+//   - steps and services are containers in the pipeline pod,
+//     and services are reachable by name on the loopback
+//     address, as in the docker network.
+//   - host and temp volumes are translated to host path and
+//     empty dir volumes, and are mounted the same way.
+//   - privileged steps and host volumes require a trusted
+//     repository, as with the docker runner.
+//   - the workspace base and path are joined.
+//   - the step mem_limit is translated to a memory limit.
+//   - the step network_mode and devices are not supported,
+//     because steps share the pod network and cannot access
+//     host devices.
+const TypeDocker = "docker"
+
+type (
+	// dockerPipeline defines the docker pipeline fields that
+	// differ from the kubernetes pipeline.
+	dockerPipeline struct {
+		Services  []*dockerStep   `json:"services,omitempty"`
+		Steps     []*dockerStep   `json:"steps,omitempty"`
+		Workspace dockerWorkspace `json:"workspace,omitempty"`
+	}
+
+	// dockerStep defines the docker step fields that differ
+	// from the kubernetes step.
+	dockerStep struct {
+		Devices     []interface{}      `json:"devices,omitempty"`
+		MemLimit    manifest.BytesSize `json:"mem_limit,omitempty" yaml:"mem_limit"`
+		NetworkMode string             `json:"network_mode,omitempty" yaml:"network_mode"`
+	}
+
+	// dockerWorkspace defines the docker workspace, which
+	// supports a base path.
+	dockerWorkspace struct {
+		Base string `json:"base,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+)
+
+// helper function translates the docker pipeline fields to
+// the kubernetes pipeline.
+func translateDocker(data []byte, out *Pipeline) error {
+	in := new(dockerPipeline)
+	if err := yaml.Unmarshal(data, in); err != nil {
+		return err
+	}
+	if in.Workspace.Base != "" {
+		out.Workspace.Path = path.Join(in.Workspace.Base, in.Workspace.Path)
+	}
+	if err := translateDockerSteps(in.Services, out.Services); err != nil {
+		return err
+	}
+	return translateDockerSteps(in.Steps, out.Steps)
+}
+
+// helper function translates the docker step fields to the
+// kubernetes steps.
+func translateDockerSteps(src []*dockerStep, dst []*Step) error {
+	for i, step := range src {
+		if step == nil || i >= len(dst) || dst[i] == nil {
+			continue
+		}
+		if step.NetworkMode != "" {
+			return fmt.Errorf("Linter: network_mode is not supported by kubernetes pipelines: %s", dst[i].Name)
+		}
+		if len(step.Devices) != 0 {
+			return fmt.Errorf("Linter: devices are not supported by kubernetes pipelines: %s", dst[i].Name)
+		}
+		if step.MemLimit != 0 && dst[i].Resources.Limits.Memory == 0 {
+			dst[i].Resources.Limits.Memory = step.MemLimit
+		}
+	}
+	return nil
+}
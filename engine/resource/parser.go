@@ -26,13 +26,21 @@ func parse(r *manifest.RawResource) (manifest.Resource, bool, error) {
 	if err != nil {
 		return out, true, err
 	}
+	if r.Type == TypeDocker {
+		err = translateDocker(r.Data, out)
+		if err != nil {
+			return out, true, err
+		}
+	}
 	err = lint(out)
 	return out, true, err
 }
 
 // match returns true if the resource matches the kind and type.
+// Docker pipelines are matched and translated to kubernetes
+// pipelines.
 func match(r *manifest.RawResource) bool {
-	return (r.Kind == Kind && r.Type == Type)
+	return r.Kind == Kind && (r.Type == Type || r.Type == TypeDocker)
 }
 
 func lint(pipeline *Pipeline) error {
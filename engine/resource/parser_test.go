@@ -51,8 +51,10 @@ func TestParse(t *testing.T) {
 				OS:   "linux",
 				Arch: "arm64",
 			},
-			Clone: manifest.Clone{
-				Depth: 50,
+			Clone: Clone{
+				Clone: manifest.Clone{
+					Depth: 50,
+				},
 			},
 			NodeSelector: map[string]string{"foo": "bar"},
 			PullSecrets:  []string{"dockerconfigjson"},
@@ -154,6 +156,14 @@ func TestMatch(t *testing.T) {
 		t.Errorf("Expect kind mismatch, got true")
 	}
 
+	r = &manifest.RawResource{
+		Kind: "pipeline",
+		Type: "docker",
+	}
+	if match(r) == false {
+		t.Errorf("Expect docker pipeline match, got false")
+	}
+
 	r = &manifest.RawResource{
 		Kind: "pipeline",
 		Type: "dummy",
@@ -181,3 +191,39 @@ func TestLint(t *testing.T) {
 		t.Errorf("Expect error when empty name")
 	}
 }
+
+func TestParseDocker(t *testing.T) {
+	got, err := manifest.ParseFile("testdata/docker.yml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(got.Resources) != 1 {
+		t.Errorf("Want 1 resource, got %d", len(got.Resources))
+		return
+	}
+	pipeline, ok := got.Resources[0].(*Pipeline)
+	if !ok {
+		t.Errorf("Expect docker pipeline translated to kubernetes pipeline")
+		return
+	}
+	if got, want := pipeline.Workspace.Path, "/go/src/github.com/octocat/hello-world"; got != want {
+		t.Errorf("Want workspace path %q, got %q", want, got)
+	}
+	if got, want := pipeline.Steps[0].Resources.Limits.Memory, manifest.BytesSize(524288000); got != want {
+		t.Errorf("Want memory limit %d, got %d", want, got)
+	}
+	if got, want := len(pipeline.Services), 1; got != want {
+		t.Errorf("Want %d services, got %d", want, got)
+	}
+	if got, want := len(pipeline.Volumes), 1; got != want || pipeline.Volumes[0].EmptyDir == nil {
+		t.Errorf("Want temp volume translated to empty dir volume")
+	}
+}
+
+func TestParseDocker_NetworkMode(t *testing.T) {
+	_, err := manifest.ParseFile("testdata/docker_network.yml")
+	if err == nil {
+		t.Errorf("Expect error when network_mode is set")
+	}
+}
@@ -30,11 +30,12 @@ type Pipeline struct {
 	Name    string   `json:"name,omitempty"`
 	Deps    []string `json:"depends_on,omitempty"`
 
-	Clone       manifest.Clone       `json:"clone,omitempty"`
-	Concurrency manifest.Concurrency `json:"concurrency,omitempty"`
-	Node        map[string]string    `json:"node,omitempty"`
-	Platform    manifest.Platform    `json:"platform,omitempty"`
-	Trigger     manifest.Conditions  `json:"conditions,omitempty"`
+	Clone       Clone               `json:"clone,omitempty"`
+	Concurrency Concurrency         `json:"concurrency,omitempty"`
+	Node        map[string]string   `json:"node,omitempty"`
+	Platform    manifest.Platform   `json:"platform,omitempty"`
+	Features    []string            `json:"features,omitempty"`
+	Trigger     manifest.Conditions `json:"conditions,omitempty"`
 
 	Environment map[string]string `json:"environment,omitempty"`
 	Services    []*Step           `json:"services,omitempty"`
@@ -42,13 +43,26 @@ type Pipeline struct {
 	Volumes     []*Volume         `json:"volumes,omitempty"`
 	PullSecrets []string          `json:"image_pull_secrets,omitempty" yaml:"image_pull_secrets"`
 	Workspace   Workspace         `json:"workspace,omitempty"`
+	Resources   Resources         `json:"resources,omitempty"`
+	Cache       *Cache            `json:"cache,omitempty"`
+	Export      *Export           `json:"export,omitempty"`
+	Import      []string          `json:"import,omitempty"`
+	Cluster     *Cluster          `json:"cluster,omitempty"`
 
-	Metadata           Metadata          `json:"metadata,omitempty"`
-	NodeName           string            `json:"node_name,omitempty" yaml:"node_name"`
-	NodeSelector       map[string]string `json:"node_selector,omitempty"        yaml:"node_selector"`
-	ServiceAccountName string            `json:"service_account_name,omitempty" yaml:"service_account_name"`
-	Tolerations        []Toleration      `json:"tolerations,omitempty"`
-	DNS                *DNS              `json:"dns,omitempty" yaml:"dns"`
+	Metadata                     Metadata          `json:"metadata,omitempty"`
+	NodeName                     string            `json:"node_name,omitempty" yaml:"node_name"`
+	NodeSelector                 map[string]string `json:"node_selector,omitempty"        yaml:"node_selector"`
+	ServiceAccountName           string            `json:"service_account_name,omitempty" yaml:"service_account_name"`
+	AutomountServiceAccountToken bool              `json:"automount_service_account_token,omitempty" yaml:"automount_service_account_token"`
+	Tolerations                  []Toleration      `json:"tolerations,omitempty"`
+	Affinity                     *Affinity         `json:"affinity,omitempty"`
+	RuntimeClassName             string            `json:"runtime_class_name,omitempty" yaml:"runtime_class_name"`
+	DNS                          *DNS              `json:"dns,omitempty" yaml:"dns"`
+	SecurityContext              *SecurityContext  `json:"security_context,omitempty" yaml:"security_context"`
+	Timezone                     string            `json:"timezone,omitempty"`
+	Locale                       string            `json:"locale,omitempty"`
+	Zone                         string            `json:"zone,omitempty"`
+	Region                       string            `json:"region,omitempty"`
 }
 
 // GetVersion returns the resource version.
@@ -76,7 +90,7 @@ func (p *Pipeline) GetNodes() map[string]string { return p.Node }
 func (p *Pipeline) GetPlatform() manifest.Platform { return p.Platform }
 
 // GetConcurrency returns the resource concurrency limits.
-func (p *Pipeline) GetConcurrency() manifest.Concurrency { return p.Concurrency }
+func (p *Pipeline) GetConcurrency() manifest.Concurrency { return p.Concurrency.Concurrency }
 
 // GetStep returns the named step. If no step exists with the
 // given name, a nil value is returned.
@@ -106,6 +120,101 @@ type (
 		Value             string `json:"value,omitempty"`
 	}
 
+	// Affinity defines Kubernetes pod node affinity. The
+	// required node requirements must all be met, and nodes
+	// that meet the preferred node requirements are preferred
+	// by the scheduler.
+	Affinity struct {
+		Required  []NodeRequirement `json:"required,omitempty"`
+		Preferred []NodeRequirement `json:"preferred,omitempty"`
+	}
+
+	// NodeRequirement defines a node label requirement. The
+	// weight is only used by preferred requirements.
+	NodeRequirement struct {
+		Key      string   `json:"key,omitempty"`
+		Operator string   `json:"operator,omitempty"`
+		Values   []string `json:"values,omitempty"`
+		Weight   int      `json:"weight,omitempty"`
+	}
+
+	// Concurrency configures the pipeline concurrency. It
+	// extends the standard concurrency configuration with a
+	// concurrency group, which limits the number of pipelines
+	// in the group that execute concurrently across builds.
+	Concurrency struct {
+		manifest.Concurrency `yaml:",inline"`
+
+		// Group provides the name of the concurrency group.
+		Group string `json:"group,omitempty"`
+	}
+
+	// Clone configures the git clone. It extends the
+	// standard clone configuration with options specific to
+	// the clone image.
+	Clone struct {
+		manifest.Clone `yaml:",inline"`
+
+		// LFS enables fetching git lfs objects.
+		LFS bool `json:"lfs,omitempty"`
+
+		// Sparse provides a list of paths to include in a
+		// sparse checkout.
+		Sparse []string `json:"sparse_checkout,omitempty" yaml:"sparse_checkout"`
+
+		// Filter provides the partial clone filter spec, for
+		// example blob:none or tree:0.
+		Filter string `json:"filter,omitempty"`
+
+		// Recursive enables recursive submodule checkout.
+		Recursive bool `json:"recursive,omitempty"`
+
+		// SubmoduleRemote updates submodules to the latest
+		// commit of the remote tracking branch.
+		SubmoduleRemote bool `json:"submodule_update_remote,omitempty" yaml:"submodule_update_remote"`
+
+		// SubmoduleCredentials defines which submodules can
+		// reuse the repository credentials. Valid values are
+		// same-host (default), all and none.
+		SubmoduleCredentials string `json:"submodule_credentials,omitempty" yaml:"submodule_credentials"`
+
+		// Mirror provides an optional mirror remote that is
+		// fetched before the origin remote.
+		Mirror string `json:"mirror,omitempty"`
+
+		// ShallowSince limits the clone history to commits
+		// more recent than the given date.
+		ShallowSince string `json:"shallow_since,omitempty" yaml:"shallow_since"`
+
+		// Pin fetches only the exact build commit with a
+		// depth of one.
+		Pin bool `json:"pin_commit,omitempty" yaml:"pin_commit"`
+
+		// PinFallback defines the strategy when the remote
+		// does not advertise the pinned commit. Valid values
+		// are ref (default), full and fail.
+		PinFallback string `json:"pin_fallback,omitempty" yaml:"pin_fallback"`
+	}
+
+	// SecurityContext defines the Kubernetes pod security
+	// context. The runner defaults can only be overridden
+	// within the bounds defined by the runner.
+	SecurityContext struct {
+		RunAsUser                *int64        `json:"run_as_user,omitempty" yaml:"run_as_user"`
+		RunAsGroup               *int64        `json:"run_as_group,omitempty" yaml:"run_as_group"`
+		RunAsNonRoot             bool          `json:"run_as_non_root,omitempty" yaml:"run_as_non_root"`
+		FSGroup                  *int64        `json:"fs_group,omitempty" yaml:"fs_group"`
+		SeccompProfile           string        `json:"seccomp_profile,omitempty" yaml:"seccomp_profile"`
+		Capabilities             *Capabilities `json:"capabilities,omitempty"`
+		AllowPrivilegeEscalation *bool         `json:"allow_privilege_escalation,omitempty" yaml:"allow_privilege_escalation"`
+	}
+
+	// Capabilities defines the container capabilities that
+	// are dropped.
+	Capabilities struct {
+		Drop []string `json:"drop,omitempty"`
+	}
+
 	// DNS defines Kubernetes pod dns
 	DNS struct {
 		DNSPolicy string              `json:"dns_policy,omitempty"`
@@ -116,36 +225,171 @@ type (
 	Step struct {
 		Command     []string                       `json:"command,omitempty"`
 		Commands    []string                       `json:"commands,omitempty"`
+		Coverage    *Coverage                      `json:"coverage,omitempty"`
+		Dedupe      bool                           `json:"dedupe,omitempty"`
+		Deploy      *Deploy                        `json:"deploy,omitempty"`
 		Detach      bool                           `json:"detach,omitempty"`
 		DependsOn   []string                       `json:"depends_on,omitempty" yaml:"depends_on"`
 		Entrypoint  []string                       `json:"entrypoint,omitempty"`
 		Environment map[string]*manifest.Variable  `json:"environment,omitempty"`
+		EnvFile     []string                       `json:"env_file,omitempty" yaml:"env_file"`
+		EnvFrom     map[string]*EnvFrom            `json:"env_from,omitempty" yaml:"env_from"`
 		Failure     string                         `json:"failure,omitempty"`
 		Image       string                         `json:"image,omitempty"`
+		JUnit       []string                       `json:"junit,omitempty"`
+		KVM         bool                           `json:"kvm,omitempty"`
 		Name        string                         `json:"name,omitempty"`
+		Node        bool                           `json:"node,omitempty"`
 		Privileged  bool                           `json:"privileged,omitempty"`
+		Publish     *Publish                       `json:"publish,omitempty"`
 		Pull        string                         `json:"pull,omitempty"`
+		Readiness   *Readiness                     `json:"readiness,omitempty"`
 		Resources   Resources                      `json:"resource,omitempty"`
+		Schedule    string                         `json:"best_effort_schedule,omitempty" yaml:"best_effort_schedule"`
+		SecretFiles []*SecretFile                  `json:"secret_files,omitempty" yaml:"secret_files"`
 		Settings    map[string]*manifest.Parameter `json:"settings,omitempty"`
 		Shell       string                         `json:"shell,omitempty"`
+		Stdin       *manifest.Parameter            `json:"stdin,omitempty"`
+		Timeout     int64                          `json:"timeout,omitempty"`
+		Tmpfs       []*Tmpfs                       `json:"tmpfs,omitempty"`
 		User        string                         `json:"user,omitempty"`
+		Uses        string                         `json:"uses,omitempty"`
 		Volumes     []*VolumeMount                 `json:"volumes,omitempty"`
+		WaitFor     *WaitFor                       `json:"wait_for,omitempty" yaml:"wait_for"`
 		When        manifest.Conditions            `json:"when,omitempty"`
 		WorkingDir  string                         `json:"working_dir,omitempty" yaml:"working_dir"`
 	}
 
+	// Coverage configures code coverage reporting for the
+	// step. Coverage files matching the paths are merged and
+	// the step fails if the line coverage percentage is below
+	// the threshold.
+	Coverage struct {
+		Paths     []string `json:"paths,omitempty"`
+		Threshold float64  `json:"threshold,omitempty"`
+	}
+
+	// Deploy deploys a helm chart, the default, or a kustomize
+	// directory. The kubeconfig is sourced from a secret, or
+	// is generated from a token of the pipeline service
+	// account, scoped to the namespace. The applied manifests
+	// are written to the workspace.
+	Deploy struct {
+		Tool       string             `json:"tool,omitempty"`
+		Chart      string             `json:"chart,omitempty"`
+		Release    string             `json:"release,omitempty"`
+		Path       string             `json:"path,omitempty"`
+		Namespace  string             `json:"namespace,omitempty"`
+		Values     []string           `json:"values,omitempty"`
+		Set        map[string]string  `json:"set,omitempty"`
+		Wait       bool               `json:"wait,omitempty"`
+		Kubeconfig *manifest.Variable `json:"kubeconfig,omitempty"`
+	}
+
+	// Publish builds and publishes an image without privileges
+	// using kaniko, the default, or buildah. The dockerfile is
+	// relative to the context, and the image is pushed with
+	// each tag. Layers are cached in the cache repository if
+	// defined.
+	Publish struct {
+		Builder    string             `json:"builder,omitempty"`
+		Repo       string             `json:"repo,omitempty"`
+		Tags       []string           `json:"tags,omitempty"`
+		Context    string             `json:"context,omitempty"`
+		Dockerfile string             `json:"dockerfile,omitempty"`
+		Target     string             `json:"target,omitempty"`
+		BuildArgs  map[string]string  `json:"build_args,omitempty" yaml:"build_args"`
+		CacheRepo  string             `json:"cache_repo,omitempty" yaml:"cache_repo"`
+		Registry   string             `json:"registry,omitempty"`
+		Username   *manifest.Variable `json:"username,omitempty"`
+		Password   *manifest.Variable `json:"password,omitempty"`
+	}
+
+	// SecretFile mounts a secret into the step container as a
+	// file. Binary secrets, for example keystores, are stored
+	// base64 encoded and are decoded if the encoding is base64.
+	SecretFile struct {
+		Secret   string `json:"from_secret,omitempty" yaml:"from_secret"`
+		Path     string `json:"path,omitempty"`
+		Encoding string `json:"encoding,omitempty"`
+	}
+
+	// Tmpfs mounts a memory-backed filesystem in the step
+	// container. The size is optional, and the files count
+	// towards the memory of the pod.
+	Tmpfs struct {
+		Path string             `json:"path,omitempty"`
+		Size manifest.BytesSize `json:"size,omitempty"`
+	}
+
+	// WaitFor configures conditions that must be met before
+	// the step commands are executed. The timeout is defined
+	// in seconds.
+	WaitFor struct {
+		HTTP    string `json:"http,omitempty"`
+		TCP     string `json:"tcp,omitempty"`
+		File    string `json:"file,omitempty"`
+		Command string `json:"command,omitempty"`
+		Timeout int64  `json:"timeout,omitempty"`
+	}
+
+	// Readiness configures the readiness probe of a service.
+	// The service is ready when the port accepts connections,
+	// or when a get request to the path returns a successful
+	// status if the path is defined. The timeout is defined in
+	// seconds.
+	Readiness struct {
+		Port    int    `json:"port,omitempty"`
+		Path    string `json:"path,omitempty"`
+		Timeout int64  `json:"timeout,omitempty"`
+	}
+
 	// Volume that can be mounted by containers.
 	Volume struct {
-		Name     string          `json:"name,omitempty"`
-		EmptyDir *VolumeEmptyDir `json:"temp,omitempty" yaml:"temp"`
-		HostPath *VolumeHostPath `json:"host,omitempty" yaml:"host"`
+		Name      string           `json:"name,omitempty"`
+		EmptyDir  *VolumeEmptyDir  `json:"temp,omitempty" yaml:"temp"`
+		HostPath  *VolumeHostPath  `json:"host,omitempty" yaml:"host"`
+		NFS       *VolumeNFS       `json:"nfs,omitempty" yaml:"nfs"`
+		CSI       *VolumeCSI       `json:"csi,omitempty" yaml:"csi"`
+		Projected *VolumeProjected `json:"projected,omitempty" yaml:"projected"`
+		Claim     *VolumeClaim     `json:"claim,omitempty" yaml:"claim"`
+		Secret    *VolumeSecret    `json:"secret,omitempty" yaml:"secret"`
+		ConfigMap *VolumeConfigMap `json:"config_map,omitempty" yaml:"config_map"`
+	}
+
+	// EnvFrom provides the source of an environment variable
+	// that is injected without passing through the server,
+	// from an existing Kubernetes secret or config map, or
+	// from vault.
+	EnvFrom struct {
+		Secret    *EnvKeyRef   `json:"secret,omitempty"`
+		ConfigMap *EnvKeyRef   `json:"config_map,omitempty" yaml:"config_map"`
+		Vault     *EnvVaultRef `json:"vault,omitempty"`
+	}
+
+	// EnvKeyRef references a key of an existing Kubernetes
+	// secret or config map.
+	EnvKeyRef struct {
+		Name     string `json:"name,omitempty"`
+		Key      string `json:"key,omitempty"`
+		Optional bool   `json:"optional,omitempty"`
+	}
+
+	// EnvVaultRef references a key of a vault secret.
+	EnvVaultRef struct {
+		Path     string `json:"path,omitempty"`
+		Key      string `json:"key,omitempty"`
+		Optional bool   `json:"optional,omitempty"`
 	}
 
 	// VolumeMount describes a mounting of a Volume
 	// within a container.
 	VolumeMount struct {
-		Name      string `json:"name,omitempty"`
-		MountPath string `json:"path,omitempty" yaml:"path"`
+		Name        string `json:"name,omitempty"`
+		MountPath   string `json:"path,omitempty" yaml:"path"`
+		SubPath     string `json:"sub_path,omitempty" yaml:"sub_path"`
+		ReadOnly    bool   `json:"read_only,omitempty" yaml:"read_only"`
+		Propagation string `json:"mount_propagation,omitempty" yaml:"mount_propagation"`
 	}
 
 	// VolumeEmptyDir mounts a temporary directory from the
@@ -162,6 +406,106 @@ type (
 		Path string `json:"path,omitempty"`
 	}
 
+	// VolumeNFS mounts a directory exported by an nfs server
+	// into your container.
+	VolumeNFS struct {
+		Server   string `json:"server,omitempty"`
+		Path     string `json:"path,omitempty"`
+		ReadOnly bool   `json:"read_only,omitempty" yaml:"read_only"`
+	}
+
+	// VolumeCSI mounts an ephemeral volume that is provided
+	// by a csi driver into your container.
+	VolumeCSI struct {
+		Driver     string            `json:"driver,omitempty"`
+		FSType     string            `json:"fs_type,omitempty" yaml:"fs_type"`
+		ReadOnly   bool              `json:"read_only,omitempty" yaml:"read_only"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+	}
+
+	// VolumeClaim mounts a persistent volume claim into your
+	// container. If the claim name is empty, a claim is
+	// provisioned for the pipeline and removed when the
+	// pipeline completes. Otherwise the existing claim is
+	// mounted, which can be used to share a build cache
+	// between pipelines.
+	VolumeClaim struct {
+		Name         string             `json:"name,omitempty"`
+		StorageClass string             `json:"storage_class,omitempty" yaml:"storage_class"`
+		AccessMode   string             `json:"access_mode,omitempty" yaml:"access_mode"`
+		Size         manifest.BytesSize `json:"size,omitempty"`
+		ReadOnly     bool               `json:"read_only,omitempty" yaml:"read_only"`
+	}
+
+	// VolumeSecret mounts the keys of an existing Kubernetes
+	// secret into your container as files.
+	VolumeSecret struct {
+		Name  string                 `json:"name,omitempty"`
+		Items []*VolumeProjectedItem `json:"items,omitempty"`
+	}
+
+	// VolumeConfigMap mounts the keys of an existing
+	// Kubernetes config map into your container as files.
+	VolumeConfigMap struct {
+		Name  string                 `json:"name,omitempty"`
+		Items []*VolumeProjectedItem `json:"items,omitempty"`
+	}
+
+	// VolumeProjected mounts multiple volume sources into the
+	// same directory.
+	VolumeProjected struct {
+		Sources []*VolumeProjection `json:"sources,omitempty"`
+	}
+
+	// VolumeProjection describes a projected volume source.
+	VolumeProjection struct {
+		Secret              *VolumeProjectedObject `json:"secret,omitempty"`
+		ConfigMap           *VolumeProjectedObject `json:"config_map,omitempty" yaml:"config_map"`
+		ServiceAccountToken *VolumeProjectedToken  `json:"service_account_token,omitempty" yaml:"service_account_token"`
+	}
+
+	// VolumeProjectedObject projects the keys of a secret or
+	// config map.
+	VolumeProjectedObject struct {
+		Name  string                 `json:"name,omitempty"`
+		Items []*VolumeProjectedItem `json:"items,omitempty"`
+	}
+
+	// VolumeProjectedItem maps a key to a file path.
+	VolumeProjectedItem struct {
+		Key  string `json:"key,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+
+	// VolumeProjectedToken projects a service account token.
+	VolumeProjectedToken struct {
+		Audience          string `json:"audience,omitempty"`
+		ExpirationSeconds int64  `json:"expiration_seconds,omitempty" yaml:"expiration_seconds"`
+		Path              string `json:"path,omitempty"`
+	}
+
+	// Cache defines the pipeline cache directories, which are
+	// saved when the pipeline completes and restored by
+	// subsequent pipelines of the same branch. Relative paths
+	// are relative to the workspace.
+	Cache struct {
+		Paths []string `json:"paths,omitempty"`
+	}
+
+	// Export defines the workspace paths that are uploaded to
+	// the object store when the pipeline succeeds, and that are
+	// imported by the dependent pipelines of the build. Relative
+	// paths are relative to the workspace.
+	Export struct {
+		Paths []string `json:"paths,omitempty"`
+	}
+
+	// Cluster defines the cluster where the pipeline runs. The
+	// kubeconfig must be sourced from a secret.
+	Cluster struct {
+		Kubeconfig *manifest.Variable `json:"kubeconfig,omitempty"`
+	}
+
 	// Workspace represents the pipeline workspace configuration.
 	Workspace struct {
 		Path string `json:"path,omitempty"`
@@ -177,6 +521,11 @@ type (
 		// Request describes the minimum amount of
 		// compute resources required.
 		Requests ResourceObject `json:"requests,omitempty" yaml:"requests"`
+
+		// Profile provides the name of a resource profile
+		// defined by the runner. The limits and requests
+		// take precedence over the profile.
+		Profile string `json:"profile,omitempty" yaml:"profile"`
 	}
 
 	// ResourceObject describes compute resource
@@ -0,0 +1,283 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/drone/runner-go/manifest"
+
+	"github.com/buildkite/yaml"
+)
+
+// Schema is a json schema that describes the pipeline
+// resource, and that can be consumed by editors and
+// pre-commit hooks.
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Const       string             `json:"const,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Definitions map[string]*Schema `json:"definitions,omitempty"`
+
+	// AdditionalProperties is false if the object does not
+	// accept unknown properties, or the schema of the map
+	// values.
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+}
+
+// unmarshaler is implemented by the manifest types that
+// accept multiple yaml representations, for example a
+// condition that accepts a string or a list of strings.
+type unmarshaler interface {
+	UnmarshalYAML(func(interface{}) error) error
+}
+
+var unmarshalerType = reflect.TypeOf((*unmarshaler)(nil)).Elem()
+
+// NewSchema returns the json schema of the kubernetes
+// pipeline resource. The schema is generated from the
+// pipeline structure, and follows the yaml field names
+// accepted by the parser.
+func NewSchema() *Schema {
+	defs := map[string]*Schema{}
+	root := toSchema(reflect.TypeOf(Pipeline{}), defs)
+	pipeline := defs[strings.TrimPrefix(root.Ref, "#/definitions/")]
+	pipeline.Properties["kind"] = &Schema{Type: "string", Const: Kind}
+	pipeline.Properties["type"] = &Schema{Type: "string", Const: Type}
+	// the dependencies are parsed from the raw resource.
+	pipeline.Properties["depends_on"] = &Schema{
+		Type:  "array",
+		Items: &Schema{Type: "string"},
+	}
+	return &Schema{
+		Schema:      "http://json-schema.org/draft-07/schema#",
+		Title:       "Drone kubernetes pipeline",
+		Ref:         root.Ref,
+		Definitions: defs,
+	}
+}
+
+// Validate validates the pipelines in the yaml document
+// against the pipeline schema, and returns the unknown fields
+// and the fields that have the wrong type, followed by the
+// parser and linter errors. Resources that are not kubernetes
+// pipelines are ignored.
+func Validate(data []byte) []error {
+	raws, err := manifest.ParseRawBytes(data)
+	if err != nil {
+		return []error{err}
+	}
+	schema := NewSchema()
+	var errs []error
+	for i, raw := range raws {
+		if raw.Kind != Kind || raw.Type != Type {
+			continue
+		}
+		name := raw.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		var doc interface{}
+		if err := yaml.Unmarshal(raw.Data, &doc); err != nil {
+			errs = append(errs, fmt.Errorf("pipeline %s: %s", name, err))
+			continue
+		}
+		var problems []string
+		schema.validate(schema, "", doc, &problems)
+		for _, problem := range problems {
+			errs = append(errs, fmt.Errorf("pipeline %s: %s", name, problem))
+		}
+		if len(problems) != 0 {
+			continue
+		}
+		if _, _, err := parse(raw); err != nil {
+			errs = append(errs, fmt.Errorf("pipeline %s: %s", name, err))
+		}
+	}
+	return errs
+}
+
+// helper function validates the yaml value against the
+// schema, and appends the problems found.
+func (root *Schema) validate(s *Schema, path string, v interface{}, problems *[]string) {
+	if s.Ref != "" {
+		s = root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+	}
+	if s == nil || s.Type == "" || v == nil {
+		return
+	}
+	at := path
+	if at == "" {
+		at = "pipeline"
+	}
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			*problems = append(*problems, at+": want an object")
+			return
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, fmt.Sprint(k))
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			value := m[key]
+			if prop, ok := s.Properties[key]; ok {
+				root.validate(prop, child, value, problems)
+			} else if extra, ok := s.AdditionalProperties.(*Schema); ok {
+				root.validate(extra, child, value, problems)
+			} else if s.AdditionalProperties == false {
+				*problems = append(*problems, child+": unknown field")
+			}
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			*problems = append(*problems, at+": want a list")
+			return
+		}
+		for i, item := range list {
+			root.validate(s.Items, fmt.Sprintf("%s[%d]", path, i), item, problems)
+		}
+	case "string":
+		switch v.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			*problems = append(*problems, at+": want a string")
+			return
+		}
+		if s.Const != "" && fmt.Sprint(v) != s.Const {
+			*problems = append(*problems, fmt.Sprintf("%s: want %q", at, s.Const))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			*problems = append(*problems, at+": want a boolean")
+		}
+	case "integer":
+		switch v.(type) {
+		case int, int64, uint64:
+		default:
+			*problems = append(*problems, at+": want an integer")
+		}
+	case "number":
+		switch v.(type) {
+		case int, int64, uint64, float64:
+		default:
+			*problems = append(*problems, at+": want a number")
+		}
+	}
+}
+
+// helper function returns the schema of the type. Structures
+// are added to the definitions and referenced by name.
+func toSchema(t reflect.Type, defs map[string]*Schema) *Schema {
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		// the type accepts multiple representations.
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return toSchema(t.Elem(), defs)
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: toSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: toSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		name := toDefinitionName(t)
+		if _, ok := defs[name]; !ok {
+			// the definition is added before the fields are
+			// visited, so that recursive types terminate.
+			def := &Schema{
+				Type:                 "object",
+				Properties:           map[string]*Schema{},
+				AdditionalProperties: false,
+			}
+			defs[name] = def
+			addFields(def, t, defs)
+		}
+		return &Schema{Ref: "#/definitions/" + name}
+	default:
+		return &Schema{}
+	}
+}
+
+// helper function adds the structure fields to the schema
+// properties. The field names follow the yaml parser rules,
+// and inline structures are flattened.
+func addFields(def *Schema, t reflect.Type, defs map[string]*Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		if tag == "" && !strings.Contains(string(field.Tag), ":") {
+			tag = string(field.Tag)
+		}
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		if hasFlag(parts[1:], "inline") {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			addFields(def, ft, defs)
+			continue
+		}
+		name := parts[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		def.Properties[name] = toSchema(field.Type, defs)
+	}
+}
+
+// helper function returns the definition name of the type.
+// Types of other packages are prefixed with the package name
+// to avoid conflicts, for example manifest.Clone.
+func toDefinitionName(t reflect.Type) string {
+	if t.PkgPath() == reflect.TypeOf(Pipeline{}).PkgPath() {
+		return t.Name()
+	}
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i != -1 {
+		pkg = pkg[i+1:]
+	}
+	return pkg + "." + t.Name()
+}
+
+// helper function returns true if the tag flags contain the
+// named flag.
+func hasFlag(flags []string, name string) bool {
+	for _, flag := range flags {
+		if flag == name {
+			return true
+		}
+	}
+	return false
+}
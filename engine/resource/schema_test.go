@@ -0,0 +1,78 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	schema := NewSchema()
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatal(err)
+	}
+	pipeline := schema.Definitions["Pipeline"]
+	if pipeline == nil {
+		t.Fatalf("Want pipeline definition")
+	}
+	for _, name := range []string{"steps", "node_selector", "depends_on", "trigger", "image_pull_secrets"} {
+		if _, ok := pipeline.Properties[name]; !ok {
+			t.Errorf("Want pipeline property %q", name)
+		}
+	}
+	// the inline manifest clone fields are flattened.
+	clone := schema.Definitions["Clone"]
+	for _, name := range []string{"depth", "disable", "lfs", "sparse_checkout"} {
+		if _, ok := clone.Properties[name]; !ok {
+			t.Errorf("Want clone property %q", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(raw); len(errs) != 0 {
+		t.Errorf("Want valid manifest, got %v", errs)
+	}
+
+	errs := Validate([]byte(`
+kind: pipeline
+type: kubernetes
+name: default
+
+steps:
+- name: build
+  image: golang
+  comands: [ go build ]
+  privileged: yes please
+`))
+	want := []string{
+		"pipeline default: steps[0].comands: unknown field",
+		"pipeline default: steps[0].privileged: want a boolean",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Want %d errors, got %v", len(want), errs)
+	}
+	for i := range want {
+		if got := errs[i].Error(); got != want[i] {
+			t.Errorf("Want error %q, got %q", want[i], got)
+		}
+	}
+}
+
+func TestValidate_Lint(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/linterr.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(raw); len(errs) == 0 {
+		t.Errorf("Want linter error")
+	}
+}
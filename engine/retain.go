@@ -0,0 +1,234 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RetainFailed retains the pipeline environment of failed
+// pipelines for the ttl, so that the pipeline pod can be
+// inspected. If more than max pipelines are retained, the
+// oldest retained pipeline is destroyed. A max of zero does
+// not limit the number of retained pipelines.
+func (k *Kubernetes) RetainFailed(ttl time.Duration, max int) {
+	k.retained = &retention{ttl: ttl, max: max}
+}
+
+// Retain retains the pipeline environment of a failed
+// pipeline. Returns false if the pipeline environment is not
+// retained, in which case it must be destroyed.
+func (k *Kubernetes) Retain(ctx context.Context, spec *Spec) (bool, error) {
+	if k.retained == nil || k.retained.ttl <= 0 {
+		return false, nil
+	}
+
+	// the pipelines that run in a cluster provided by the
+	// pipeline are not retained, since retained pipelines are
+	// only reaped in the runner cluster.
+	if spec.Cluster != nil {
+		return false, nil
+	}
+
+	// the pod is annotated with the retention deadline, so
+	// that the pod is removed by the reaper if the runner
+	// restarts before the deadline.
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				annotationRetain(labelPrefix(spec)): time.Now().Add(k.retained.ttl).UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	err := k.call(ctx, func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).Pods(spec.PodSpec.Namespace).Patch(spec.PodSpec.Name, types.MergePatchType, patch)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	k.retained.add(spec, func(spec *Spec) {
+		if err := k.Destroy(context.Background(), spec); err != nil {
+			logrus.WithError(err).
+				WithField("pod", spec.PodSpec.Name).
+				Warnln("cannot destroy retained pipeline")
+		}
+	})
+	return true, nil
+}
+
+// Revoke destroys the retained pipelines of the repository,
+// so that the pipeline secrets do not remain in the cluster
+// once the repository is disabled or its credentials are
+// revoked. Returns the number of destroyed pipelines.
+func (k *Kubernetes) Revoke(ctx context.Context, repo string) int {
+	if k.retained == nil {
+		return 0
+	}
+	specs := k.retained.revoke(repo)
+	for _, spec := range specs {
+		if err := k.Destroy(ctx, spec); err != nil {
+			logrus.WithError(err).
+				WithField("pod", spec.PodSpec.Name).
+				Warnln("cannot destroy revoked pipeline")
+		}
+	}
+	return len(specs)
+}
+
+// Reap deletes the retained pods in the namespace whose
+// retention deadline has passed, for example pods retained
+// before the runner restarted. Reap blocks until the context
+// is cancelled. The prefix must match the label prefix used
+// to compile the pipeline.
+func (k *Kubernetes) Reap(ctx context.Context, namespace, prefix string, interval time.Duration) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		k.reap(ctx, namespace, prefix)
+	}
+}
+
+// helper function deletes the expired retained pods.
+func (k *Kubernetes) reap(ctx context.Context, namespace, prefix string) {
+	client := k.client.CoreV1().Pods(namespace)
+	pods, err := client.List(metav1.ListOptions{
+		LabelSelector: prefix + "=true",
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("namespace", namespace).
+			Warnln("cannot list retained pods")
+		return
+	}
+	now := time.Now()
+	for _, pod := range pods.Items {
+		deadline, err := time.Parse(time.RFC3339, pod.Annotations[annotationRetain(prefix)])
+		if err != nil || now.Before(deadline) {
+			continue
+		}
+		// the pipeline secrets are owned by the pod, and are
+		// garbage collected with the pod.
+		err = k.retry(ctx, func() error {
+			return client.Delete(pod.Name, &metav1.DeleteOptions{
+				GracePeriodSeconds: int64ptr(0),
+			})
+		})
+		if err != nil {
+			logrus.WithError(err).
+				WithField("pod", pod.Name).
+				Warnln("cannot delete retained pod")
+		}
+	}
+}
+
+// helper function returns the name of the annotation used to
+// store the retention deadline.
+func annotationRetain(prefix string) string {
+	return prefix + ".retain-until"
+}
+
+// helper function returns the name of the annotation that
+// stores the repository slug.
+func annotationRepo(spec *Spec) string {
+	return labelPrefix(spec) + ".repo.slug"
+}
+
+// retention tracks the retained pipelines.
+type retention struct {
+	ttl time.Duration
+	max int
+
+	mu   sync.Mutex
+	list []*retained
+}
+
+type retained struct {
+	spec  *Spec
+	timer *time.Timer
+}
+
+// add retains the pipeline. The expire function is called
+// when the ttl elapses, or when the pipeline is evicted
+// because the maximum number of pipelines are retained.
+func (r *retention) add(spec *Spec, expire func(*Spec)) {
+	p := &retained{spec: spec}
+
+	r.mu.Lock()
+	p.timer = time.AfterFunc(r.ttl, func() {
+		if r.remove(p) {
+			expire(spec)
+		}
+	})
+	r.list = append(r.list, p)
+	var evicted []*retained
+	for r.max > 0 && len(r.list) > r.max {
+		evicted = append(evicted, r.list[0])
+		r.list = r.list[1:]
+	}
+	r.mu.Unlock()
+
+	// evicted pipelines are removed from the list, so their
+	// timers do not call the expire function.
+	for _, p := range evicted {
+		p.timer.Stop()
+		go expire(p.spec)
+	}
+}
+
+// helper function removes the pipeline from the list and
+// returns true if the pipeline was retained.
+func (r *retention) remove(p *retained) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, v := range r.list {
+		if v == p {
+			r.list = append(r.list[:i], r.list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// helper function removes the retained pipelines of the
+// repository from the list, and returns the pipelines.
+func (r *retention) revoke(repo string) []*Spec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var revoked []*Spec
+	var list []*retained
+	for _, p := range r.list {
+		if p.spec.PodSpec.Annotations[annotationRepo(p.spec)] != repo {
+			list = append(list, p)
+			continue
+		}
+		p.timer.Stop()
+		revoked = append(revoked, p.spec)
+	}
+	r.list = list
+	return revoked
+}
+
+// helper function returns the number of retained pipelines.
+func (r *retention) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.list)
+}
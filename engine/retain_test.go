@@ -0,0 +1,72 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetention_Evict(t *testing.T) {
+	r := &retention{ttl: time.Hour, max: 2}
+	expired := make(chan string, 3)
+	expire := func(spec *Spec) {
+		expired <- spec.PodSpec.Name
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		spec := &Spec{}
+		spec.PodSpec.Name = name
+		r.add(spec, expire)
+	}
+	select {
+	case name := <-expired:
+		if name != "a" {
+			t.Errorf("Want oldest pipeline evicted, got %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want oldest pipeline evicted")
+	}
+	if got, want := r.count(), 2; got != want {
+		t.Errorf("Want %d retained pipelines, got %d", want, got)
+	}
+}
+
+func TestRetention_Expire(t *testing.T) {
+	r := &retention{ttl: time.Millisecond}
+	expired := make(chan string, 1)
+	spec := &Spec{}
+	spec.PodSpec.Name = "a"
+	r.add(spec, func(spec *Spec) {
+		expired <- spec.PodSpec.Name
+	})
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Errorf("Want pipeline expired")
+	}
+	if got, want := r.count(), 0; got != want {
+		t.Errorf("Want %d retained pipelines, got %d", want, got)
+	}
+}
+
+func TestRetention_Revoke(t *testing.T) {
+	r := &retention{ttl: time.Hour}
+	expire := func(spec *Spec) {
+		t.Errorf("Want revoked pipeline removed without expiring")
+	}
+	for _, repo := range []string{"octocat/hello-world", "octocat/spoon-knife"} {
+		spec := &Spec{}
+		spec.PodSpec.Annotations = map[string]string{
+			DefaultLabelPrefix + ".repo.slug": repo,
+		}
+		r.add(spec, expire)
+	}
+	if got, want := len(r.revoke("octocat/hello-world")), 1; got != want {
+		t.Errorf("Want %d revoked pipelines, got %d", want, got)
+	}
+	if got, want := r.count(), 1; got != want {
+		t.Errorf("Want %d retained pipelines, got %d", want, got)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// RetryPolicy defines how kubernetes api calls that fail with
+// a transient error are retried.
+type RetryPolicy struct {
+	// Attempts provides the maximum number of attempts,
+	// including the first attempt.
+	Attempts int
+
+	// Delay provides the delay before the first retry. The
+	// delay is multiplied by Factor after each retry, up to
+	// MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	Factor   float64
+
+	// Jitter provides the maximum fraction of the delay that
+	// is randomly added to the delay, so that pipelines do not
+	// retry in lockstep.
+	Jitter float64
+}
+
+// defaultRetryPolicy is used if no retry policy is configured.
+var defaultRetryPolicy = RetryPolicy{
+	Attempts: 5,
+	Delay:    500 * time.Millisecond,
+	MaxDelay: 10 * time.Second,
+	Factor:   2,
+	Jitter:   0.2,
+}
+
+// RetryRequests sets the policy used to retry kubernetes api
+// calls that fail with a transient error, for example when the
+// api server is rate limiting requests or the etcd leader
+// changes. The delay suggested by the api server in the
+// Retry-After header takes precedence over the policy delay.
+func (k *Kubernetes) RetryRequests(policy RetryPolicy) {
+	k.retries = &policy
+}
+
+// helper function calls the function and retries the call with
+// exponential backoff if it fails with a transient error. The
+// last error is returned if the attempts are exhausted or the
+// context is cancelled.
+func (k *Kubernetes) retry(ctx context.Context, fn func() error) error {
+	policy := k.retries
+	if policy == nil {
+		policy = &defaultRetryPolicy
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.Attempts || !isTransient(err) {
+			return err
+		}
+		delay := policy.delay(attempt, err)
+		apiRetries.Inc()
+		logrus.WithError(err).
+			WithField("attempt", attempt).
+			WithField("delay", delay).
+			Debugln("kubernetes api call failed, retrying")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// helper function returns the delay before the next attempt.
+func (p *RetryPolicy) delay(attempt int, err error) time.Duration {
+	if seconds, ok := kerrors.SuggestsClientDelay(err); ok && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	factor := p.Factor
+	if factor < 1 {
+		factor = 1
+	}
+	d := float64(p.Delay) * math.Pow(factor, float64(attempt-1))
+	if max := float64(p.MaxDelay); max > 0 && d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// helper function returns true if the error is transient and
+// the api call can be retried.
+func isTransient(err error) bool {
+	switch {
+	case kerrors.IsTooManyRequests(err),
+		kerrors.IsServerTimeout(err),
+		kerrors.IsTimeout(err),
+		kerrors.IsServiceUnavailable(err),
+		kerrors.IsInternalError(err),
+		kerrors.IsConflict(err):
+		return true
+	case utilnet.IsConnectionReset(err),
+		utilnet.IsConnectionRefused(err),
+		utilnet.IsProbableEOF(err):
+		return true
+	}
+	if _, ok := kerrors.SuggestsClientDelay(err); ok {
+		return true
+	}
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRetry(t *testing.T) {
+	k := &Kubernetes{}
+	k.RetryRequests(RetryPolicy{Attempts: 3, Delay: time.Millisecond})
+
+	calls := 0
+	err := k.retry(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return kerrors.NewTooManyRequests("slow down", 0)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := calls, 3; got != want {
+		t.Errorf("Want %d calls, got %d", want, got)
+	}
+}
+
+func TestRetry_Exhausted(t *testing.T) {
+	k := &Kubernetes{}
+	k.RetryRequests(RetryPolicy{Attempts: 2, Delay: time.Millisecond})
+
+	calls := 0
+	err := k.retry(context.Background(), func() error {
+		calls++
+		return kerrors.NewServiceUnavailable("unavailable")
+	})
+	if !kerrors.IsServiceUnavailable(err) {
+		t.Errorf("Want service unavailable error, got %v", err)
+	}
+	if got, want := calls, 2; got != want {
+		t.Errorf("Want %d calls, got %d", want, got)
+	}
+}
+
+func TestRetry_Permanent(t *testing.T) {
+	k := &Kubernetes{}
+	calls := 0
+	resource := schema.GroupResource{Resource: "pods"}
+	for _, want := range []error{
+		kerrors.NewAlreadyExists(resource, "test"),
+		kerrors.NewNotFound(resource, "test"),
+		errors.New("permanent"),
+	} {
+		calls = 0
+		err := k.retry(context.Background(), func() error {
+			calls++
+			return want
+		})
+		if err != want {
+			t.Errorf("Want error %v, got %v", want, err)
+		}
+		if calls != 1 {
+			t.Errorf("Want error %v not retried, got %d calls", want, calls)
+		}
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := &RetryPolicy{
+		Delay:    time.Second,
+		MaxDelay: 5 * time.Second,
+		Factor:   2,
+	}
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+	}
+	for _, test := range tests {
+		if got, want := policy.delay(test.attempt, errors.New("")), test.delay; got != want {
+			t.Errorf("Want delay %s for attempt %d, got %s", want, test.attempt, got)
+		}
+	}
+
+	// the delay suggested by the api server is used.
+	err := kerrors.NewTooManyRequests("slow down", 30)
+	if got, want := policy.delay(1, err), 30*time.Second; got != want {
+		t.Errorf("Want retry after delay %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// names of the pipeline secrets that store the short-lived
+// registry credentials.
+const (
+	robotUsername = "robot.username"
+	robotPassword = "robot.password"
+)
+
+type (
+	// Robot defines the short-lived registry credentials that
+	// are minted for the pipeline when the pipeline is created,
+	// and revoked when the pipeline is destroyed. The account
+	// is scoped to the image namespace of the repository.
+	Robot struct {
+		// Namespace provides the image namespace the
+		// credentials are scoped to.
+		Namespace string `json:"namespace,omitempty"`
+
+		// Name provides the name of the robot account.
+		Name string `json:"name,omitempty"`
+
+		// Steps provides the identifiers of the steps that
+		// receive the credentials.
+		Steps []string `json:"steps,omitempty"`
+
+		// ID provides the identifier of the minted account,
+		// which is used to revoke the account.
+		ID string `json:"id,omitempty"`
+	}
+
+	// RobotAccount provides the credentials of a robot account.
+	RobotAccount struct {
+		ID       string
+		Registry string
+		Username string
+		Password string
+	}
+
+	// RobotProvider mints and revokes short-lived registry
+	// accounts, for example harbor robot accounts.
+	RobotProvider interface {
+		// Mint returns a new account with push and pull access
+		// to the image namespace.
+		Mint(ctx context.Context, namespace, name string) (*RobotAccount, error)
+
+		// Revoke revokes the account.
+		Revoke(ctx context.Context, namespace, id string) error
+	}
+)
+
+// MintRobots configures the engine to mint short-lived
+// registry credentials for the pipelines that request them,
+// so that publish steps never receive long-lived credentials.
+func (k *Kubernetes) MintRobots(provider RobotProvider) {
+	k.robots = provider
+}
+
+// helper function mints the registry credentials of the
+// pipeline, and exposes the credentials to the publish steps
+// as the plugin registry settings.
+func (k *Kubernetes) mintRobot(ctx context.Context, spec *Spec) error {
+	robot := spec.Robot
+	if robot == nil || k.robots == nil {
+		return nil
+	}
+	account, err := k.robots.Mint(ctx, robot.Namespace, robot.Name)
+	if err != nil {
+		return fmt.Errorf("engine: cannot mint registry credentials: %s", err)
+	}
+	robot.ID = account.ID
+
+	if spec.Secrets == nil {
+		spec.Secrets = map[string]*Secret{}
+	}
+	spec.Secrets[robotUsername] = &Secret{Name: robotUsername, Data: account.Username, Mask: true}
+	spec.Secrets[robotPassword] = &Secret{Name: robotPassword, Data: account.Password, Mask: true}
+	for _, step := range spec.Steps {
+		if !containsValue(robot.Steps, step.ID) {
+			continue
+		}
+		if step.Envs == nil {
+			step.Envs = map[string]string{}
+		}
+		step.Envs["PLUGIN_REGISTRY"] = account.Registry
+		step.Secrets = append(step.Secrets,
+			&SecretVar{Name: robotUsername, Env: "PLUGIN_USERNAME"},
+			&SecretVar{Name: robotPassword, Env: "PLUGIN_PASSWORD"},
+		)
+	}
+	return nil
+}
+
+// helper function revokes the registry credentials of the
+// pipeline, once. The error is logged, and the credentials
+// expire if they cannot be revoked.
+func (k *Kubernetes) revokeRobot(ctx context.Context, spec *Spec) {
+	robot := spec.Robot
+	if robot == nil || robot.ID == "" || k.robots == nil {
+		return
+	}
+	if err := k.robots.Revoke(ctx, robot.Namespace, robot.ID); err != nil {
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			WithField("robot", robot.ID).
+			Warnln("cannot revoke registry credentials")
+	}
+	robot.ID = ""
+}
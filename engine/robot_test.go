@@ -0,0 +1,88 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+type fakeRobots struct {
+	minted  string
+	revoked string
+}
+
+func (f *fakeRobots) Mint(ctx context.Context, namespace, name string) (*RobotAccount, error) {
+	f.minted = namespace + "/" + name
+	return &RobotAccount{
+		ID:       "42",
+		Registry: "harbor.company.com",
+		Username: "robot$octocat+drone-abc",
+		Password: "correct-horse",
+	}, nil
+}
+
+func (f *fakeRobots) Revoke(ctx context.Context, namespace, id string) error {
+	f.revoked = namespace + "/" + id
+	return nil
+}
+
+func TestMintRobot(t *testing.T) {
+	robots := new(fakeRobots)
+	k := &Kubernetes{robots: robots}
+	spec := &Spec{
+		Robot: &Robot{Namespace: "octocat", Name: "drone-abc", Steps: []string{"publish"}},
+		Steps: []*Step{
+			{ID: "test", Name: "test"},
+			{ID: "publish", Name: "publish"},
+		},
+	}
+	if err := k.mintRobot(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := robots.minted, "octocat/drone-abc"; got != want {
+		t.Errorf("Want robot %q minted, got %q", want, got)
+	}
+	if got, want := spec.Robot.ID, "42"; got != want {
+		t.Errorf("Want robot id %q, got %q", want, got)
+	}
+	if s := spec.Secrets[robotPassword]; s == nil || s.Data != "correct-horse" || !s.Mask {
+		t.Errorf("Want masked robot password secret")
+	}
+	if len(spec.Steps[0].Secrets) != 0 {
+		t.Errorf("Want credentials not exposed to other steps")
+	}
+	publish := spec.Steps[1]
+	if got, want := publish.Envs["PLUGIN_REGISTRY"], "harbor.company.com"; got != want {
+		t.Errorf("Want registry %q, got %q", want, got)
+	}
+	if len(publish.Secrets) != 2 || publish.Secrets[0].Env != "PLUGIN_USERNAME" || publish.Secrets[1].Env != "PLUGIN_PASSWORD" {
+		t.Errorf("Want credentials exposed to the publish step")
+	}
+
+	k.revokeRobot(context.Background(), spec)
+	if got, want := robots.revoked, "octocat/42"; got != want {
+		t.Errorf("Want robot %q revoked, got %q", want, got)
+	}
+
+	// the credentials are revoked once, for example if the
+	// pipeline is destroyed after setup failed.
+	robots.revoked = ""
+	k.revokeRobot(context.Background(), spec)
+	if robots.revoked != "" {
+		t.Errorf("Want robot revoked once")
+	}
+}
+
+func TestMintRobot_Disabled(t *testing.T) {
+	k := &Kubernetes{}
+	spec := &Spec{Robot: &Robot{Namespace: "octocat"}}
+	if err := k.mintRobot(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Robot.ID != "" || len(spec.Secrets) != 0 {
+		t.Errorf("Want no credentials minted without a provider")
+	}
+}
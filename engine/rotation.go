@@ -0,0 +1,254 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/registry/auths"
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PullCredential defines a registry credential of the
+// rotation pool. The name identifies the credential in the
+// runner logs, and must not be secret.
+type PullCredential struct {
+	Name     string
+	Registry string
+	Username string
+	Password string
+}
+
+// rotation rotates the pipeline pods among a pool of registry
+// credentials when a registry rejects an image pull with a
+// rate limit error.
+type rotation struct {
+	mu sync.Mutex
+	// credentials of the pool, by registry.
+	pool map[string][]*PullCredential
+	// index of the current credential, by registry.
+	current map[string]int
+	// index of the credential assigned to each pod, by pod
+	// and registry.
+	assigned map[string]map[string]int
+}
+
+// RotatePullCredentials enables the rotation of the pipeline
+// pods among a pool of registry credentials. The pipeline pods
+// pull the images of a registry with the current credential of
+// the registry, unless the pipeline provides a credential for
+// the registry. If the registry rejects an image pull with a
+// rate limit error, the next credential becomes the current
+// credential, and the pull secret of the pod is updated so that
+// the pull is retried with the next credential.
+func (k *Kubernetes) RotatePullCredentials(pool []*PullCredential) {
+	r := &rotation{
+		pool:     map[string][]*PullCredential{},
+		current:  map[string]int{},
+		assigned: map[string]map[string]int{},
+	}
+	for _, cred := range pool {
+		registry := toRegistryHost(cred.Registry)
+		r.pool[registry] = append(r.pool[registry], cred)
+	}
+	k.rotation = r
+}
+
+// helper function adds the current credential of each pooled
+// registry of the pipeline images to the pipeline pull secret.
+// The credential assigned to the pod is logged, so that the
+// credential that served each build can be audited.
+func (r *rotation) assign(spec *Spec) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var creds []*drone.Registry
+	if spec.PullSecret != nil {
+		creds, _ = auths.ParseString(spec.PullSecret.Data)
+	}
+	provided := map[string]bool{}
+	for _, cred := range creds {
+		provided[toRegistryHost(cred.Address)] = true
+	}
+
+	key := spec.PodSpec.Namespace + "/" + spec.PodSpec.Name
+	assigned := map[string]int{}
+	for _, name := range specImages(spec) {
+		registry := image.Domain(name)
+		if _, ok := assigned[registry]; ok || provided[registry] || len(r.pool[registry]) == 0 {
+			continue
+		}
+		index := r.current[registry]
+		assigned[registry] = index
+		logrus.WithField("pod", spec.PodSpec.Name).
+			WithField("repo", spec.PodSpec.Annotations[labelPrefix(spec)+".repo.slug"]).
+			WithField("build", spec.PodSpec.Annotations[labelPrefix(spec)+".build.number"]).
+			WithField("registry", registry).
+			WithField("credential", r.pool[registry][index].Name).
+			Infoln("assigned registry credential")
+	}
+	if len(assigned) == 0 {
+		return
+	}
+	r.assigned[key] = assigned
+	if spec.PullSecret == nil {
+		spec.PullSecret = &Secret{Name: spec.PodSpec.Name + "-registry"}
+	}
+	spec.PullSecret.Data = r.encode(creds, assigned)
+}
+
+// helper function rotates the credential of the registries that
+// rejected an image pull of the pod with a rate limit error,
+// and returns the pull secret data of the pod with the next
+// credentials, or an empty string if no credential is rotated.
+func (r *rotation) rotate(spec *Spec, pod *v1.Pod) string {
+	if r == nil || spec.PullSecret == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := spec.PodSpec.Namespace + "/" + spec.PodSpec.Name
+	assigned := r.assigned[key]
+	if assigned == nil {
+		return ""
+	}
+	var rotated bool
+	var statuses []v1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting == nil || !isRateLimited(waiting) {
+			continue
+		}
+		registry := image.Domain(status.Image)
+		index, ok := assigned[registry]
+		if !ok || len(r.pool[registry]) < 2 {
+			continue
+		}
+		// the current credential is only rotated by the first
+		// pod that observes the rate limit of the credential,
+		// and the other pods switch to the current credential.
+		if r.current[registry] == index {
+			r.current[registry] = (index + 1) % len(r.pool[registry])
+			logrus.WithField("registry", registry).
+				WithField("credential", r.pool[registry][index].Name).
+				WithField("next", r.pool[registry][r.current[registry]].Name).
+				Warnln("registry credential rate limited, rotating credential")
+		}
+		if assigned[registry] == r.current[registry] {
+			continue
+		}
+		assigned[registry] = r.current[registry]
+		rotated = true
+		logrus.WithField("pod", spec.PodSpec.Name).
+			WithField("registry", registry).
+			WithField("credential", r.pool[registry][assigned[registry]].Name).
+			Infoln("assigned registry credential")
+	}
+	if !rotated {
+		return ""
+	}
+	creds, _ := auths.ParseString(spec.PullSecret.Data)
+	var provided []*drone.Registry
+	for _, cred := range creds {
+		if _, ok := assigned[toRegistryHost(cred.Address)]; !ok {
+			provided = append(provided, cred)
+		}
+	}
+	spec.PullSecret.Data = r.encode(provided, assigned)
+	return spec.PullSecret.Data
+}
+
+// helper function removes the credentials assigned to the pod,
+// once the pod is destroyed.
+func (r *rotation) forget(spec *Spec) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.assigned, spec.PodSpec.Namespace+"/"+spec.PodSpec.Name)
+	r.mu.Unlock()
+}
+
+// helper function returns the docker config of the credentials
+// provided by the pipeline and the assigned pool credentials.
+// The caller must hold the lock.
+func (r *rotation) encode(creds []*drone.Registry, assigned map[string]int) string {
+	for registry, index := range assigned {
+		cred := r.pool[registry][index]
+		creds = append(creds, &drone.Registry{
+			Address:  toRegistryAddress(registry),
+			Username: cred.Username,
+			Password: cred.Password,
+		})
+	}
+	return auths.Encode(creds...)
+}
+
+// helper function rotates the pool credentials of the pod if
+// an image pull is rate limited, and updates the pull secret,
+// so that the kubelet retries the pull with the next
+// credential.
+func (k *Kubernetes) rotateCredentials(ctx context.Context, spec *Spec, pod *v1.Pod) {
+	data := k.rotation.rotate(spec, pod)
+	if data == "" {
+		return
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"data": map[string][]byte{
+			".dockerconfigjson": []byte(data),
+		},
+	})
+	err := k.call(ctx, func(ctx context.Context) error {
+		_, err := k.coreV1(ctx).Secrets(spec.PodSpec.Namespace).Patch(spec.PullSecret.Name, types.MergePatchType, patch)
+		return err
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("pod", spec.PodSpec.Name).
+			Warnln("cannot update the pull secret")
+	}
+}
+
+// helper function returns the registry address used in the
+// docker config. The docker hub credentials are keyed by the
+// legacy index address.
+func toRegistryAddress(registry string) string {
+	if registry == "docker.io" {
+		return "https://index.docker.io/v1/"
+	}
+	return registry
+}
+
+// helper function returns the registry host of the docker
+// config address, which may include the scheme and path.
+func toRegistryHost(address string) string {
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		address = u.Host
+	}
+	if i := strings.Index(address, "/"); i != -1 {
+		address = address[:i]
+	}
+	switch address {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return address
+}
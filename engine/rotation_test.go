@@ -0,0 +1,122 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/drone/runner-go/registry/auths"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func testRotation() *rotation {
+	k := new(Kubernetes)
+	k.RotatePullCredentials([]*PullCredential{
+		{Name: "hub-a", Registry: "docker.io", Username: "a", Password: "pa"},
+		{Name: "hub-b", Registry: "https://index.docker.io/v1/", Username: "b", Password: "pb"},
+	})
+	return k.rotation
+}
+
+func testRateLimitedPod() *v1.Pod {
+	return &v1.Pod{
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{{
+				Image: "golang:1.14",
+				State: v1.ContainerState{
+					Waiting: &v1.ContainerStateWaiting{
+						Reason:  "ImagePullBackOff",
+						Message: "429 Too Many Requests",
+					},
+				},
+			}},
+		},
+	}
+}
+
+func TestRotationAssign(t *testing.T) {
+	r := testRotation()
+	spec := &Spec{
+		PodSpec: PodSpec{Namespace: "default", Name: "drone-a"},
+		Steps:   []*Step{{Image: "golang:1.14"}, {Image: "gcr.io/distroless/base"}},
+	}
+	r.assign(spec)
+	if spec.PullSecret == nil {
+		t.Errorf("Want pull secret created")
+		return
+	}
+	creds, _ := auths.ParseString(spec.PullSecret.Data)
+	if len(creds) != 1 || creds[0].Username != "a" {
+		t.Errorf("Want first pool credential assigned, got %v", creds)
+	}
+
+	// the pipeline credentials are not replaced.
+	spec = &Spec{
+		PodSpec:    PodSpec{Namespace: "default", Name: "drone-b"},
+		Steps:      []*Step{{Image: "golang:1.14"}},
+		PullSecret: &Secret{Name: "pull", Data: `{"auths":{"https://index.docker.io/v1/":{"auth":"b2N0b2NhdDpwYXNz"}}}`},
+	}
+	r.assign(spec)
+	if _, ok := r.assigned["default/drone-b"]; ok {
+		t.Errorf("Want no pool credential if the pipeline provides a credential")
+	}
+}
+
+func TestRotationRotate(t *testing.T) {
+	r := testRotation()
+	a := &Spec{
+		PodSpec: PodSpec{Namespace: "default", Name: "drone-a"},
+		Steps:   []*Step{{Image: "golang:1.14"}},
+	}
+	b := &Spec{
+		PodSpec: PodSpec{Namespace: "default", Name: "drone-b"},
+		Steps:   []*Step{{Image: "golang:1.14"}},
+	}
+	r.assign(a)
+	r.assign(b)
+
+	data := r.rotate(a, testRateLimitedPod())
+	creds, _ := auths.ParseString(data)
+	if len(creds) != 1 || creds[0].Username != "b" {
+		t.Errorf("Want next pool credential assigned, got %v", creds)
+	}
+	if got := r.current["docker.io"]; got != 1 {
+		t.Errorf("Want current credential rotated, got %d", got)
+	}
+
+	// the second pod switches to the current credential
+	// without rotating it again.
+	r.rotate(b, testRateLimitedPod())
+	if got := r.current["docker.io"]; got != 1 {
+		t.Errorf("Want current credential rotated once, got %d", got)
+	}
+	if got := r.assigned["default/drone-b"]["docker.io"]; got != 1 {
+		t.Errorf("Want second pod switched to the current credential, got %d", got)
+	}
+
+	r.forget(a)
+	if _, ok := r.assigned["default/drone-a"]; ok {
+		t.Errorf("Want destroyed pod forgotten")
+	}
+}
+
+func TestToRegistryHost(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"docker.io", "docker.io"},
+		{"index.docker.io", "docker.io"},
+		{"https://index.docker.io/v1/", "docker.io"},
+		{"gcr.io", "gcr.io"},
+		{"localhost:5000", "localhost:5000"},
+		{"https://registry.example.com/v2/", "registry.example.com"},
+	}
+	for _, test := range tests {
+		if got := toRegistryHost(test.in); got != test.want {
+			t.Errorf("Want registry host %s for %s, got %s", test.want, test.in, got)
+		}
+	}
+}
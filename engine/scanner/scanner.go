@@ -0,0 +1,179 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package scanner detects secrets in the step output that are
+// not masked, for example access tokens and private keys that
+// are printed by a step.
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+)
+
+// maxFindings is the maximum number of findings reported for
+// a step.
+const maxFindings = 10
+
+// maxLine is the maximum length of a line that is scanned.
+// Longer lines are scanned in chunks.
+const maxLine = 64 * 1024
+
+// minEntropy is the minimum entropy, in bits per character,
+// of a string that is reported as a possible secret.
+const minEntropy = 4.5
+
+// patterns provides the known token formats.
+var patterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"aws access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"gitlab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{"slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"google api key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"stripe key", regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}\b`)},
+	{"npm token", regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+}
+
+// candidate matches strings that are checked for high entropy.
+var candidate = regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`)
+
+// Finding describes a possible secret in the step output. The
+// secret value is not recorded.
+type Finding struct {
+	Kind string
+	Line int
+}
+
+// Scanner is an io.Writer that scans the step output for
+// secrets. The output is written to the base writer unchanged,
+// and the findings are written to the base writer when the
+// scanner is closed.
+type Scanner struct {
+	w     io.WriteCloser
+	buf   []byte
+	line  int
+	found []Finding
+}
+
+// New returns a scanner that wraps writer w.
+func New(w io.WriteCloser) *Scanner {
+	return &Scanner{w: w}
+}
+
+// Write writes p to the base writer and scans the completed
+// lines for secrets.
+func (s *Scanner) Write(p []byte) (n int, err error) {
+	s.scan(p)
+	return s.w.Write(p)
+}
+
+// Close writes the findings to the base writer and closes the
+// base writer.
+func (s *Scanner) Close() error {
+	if len(s.buf) != 0 {
+		s.line++
+		s.check(s.buf)
+		s.buf = nil
+	}
+	for _, f := range s.found {
+		fmt.Fprintf(s.w, "[secret scan] possible %s on line %d\n", f.Kind, f.Line)
+	}
+	return s.w.Close()
+}
+
+// Found returns the possible secrets found in the output.
+func (s *Scanner) Found() []Finding {
+	return s.found
+}
+
+// helper function splits the output into lines and scans
+// each completed line.
+func (s *Scanner) scan(p []byte) {
+	for len(p) != 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			s.buf = append(s.buf, p...)
+			if len(s.buf) > maxLine {
+				s.check(s.buf)
+				s.buf = s.buf[:0]
+			}
+			return
+		}
+		s.buf = append(s.buf, p[:i]...)
+		s.line++
+		s.check(s.buf)
+		s.buf = s.buf[:0]
+		p = p[i+1:]
+	}
+}
+
+// helper function records the possible secrets in the line.
+func (s *Scanner) check(line []byte) {
+	if len(s.found) >= maxFindings {
+		return
+	}
+	for _, pattern := range patterns {
+		if pattern.re.Match(line) {
+			s.record(pattern.kind)
+			return
+		}
+	}
+	for _, match := range candidate.FindAll(line, -1) {
+		if isSecret(match) {
+			s.record("high entropy string")
+			return
+		}
+	}
+}
+
+func (s *Scanner) record(kind string) {
+	s.found = append(s.found, Finding{Kind: kind, Line: s.line})
+}
+
+// helper function returns true if the string has mixed case
+// letters and digits, and high entropy. Hexadecimal strings,
+// for example commit shas and image digests, are ignored.
+func isSecret(b []byte) bool {
+	var upper, lower, digit bool
+	for _, c := range b {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+	}
+	if !upper || !lower || !digit {
+		return false
+	}
+	return entropy(b) >= minEntropy
+}
+
+// helper function returns the shannon entropy of the string,
+// in bits per character.
+func entropy(b []byte) float64 {
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+	var h float64
+	n := float64(len(b))
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type nopCloser struct {
+	bytes.Buffer
+}
+
+func (*nopCloser) Close() error { return nil }
+
+func TestScanner(t *testing.T) {
+	buf := new(nopCloser)
+	s := New(buf)
+	s.Write([]byte("+ go build\n+ echo $AWS_ACCESS_KEY_ID\nAKIAIOSF"))
+	s.Write([]byte("ODNN7EXAMPLE\n"))
+	s.Write([]byte("token: 8fJ2kQz9LmX4vR7tYpW3nB6cD1sGhA5e\n"))
+	s.Close()
+
+	found := s.Found()
+	if got, want := len(found), 2; got != want {
+		t.Fatalf("Want %d findings, got %d", want, got)
+	}
+	if got, want := found[0], (Finding{Kind: "aws access key", Line: 3}); got != want {
+		t.Errorf("Want finding %v, got %v", want, got)
+	}
+	if got, want := found[1], (Finding{Kind: "high entropy string", Line: 4}); got != want {
+		t.Errorf("Want finding %v, got %v", want, got)
+	}
+	if !strings.Contains(buf.String(), "[secret scan] possible aws access key on line 3\n") {
+		t.Errorf("Expect findings written to the output")
+	}
+	if strings.Contains(buf.String()[strings.Index(buf.String(), "[secret scan]"):], "AKIA") {
+		t.Errorf("Expect findings do not include the secret")
+	}
+}
+
+func TestScanner_Ignore(t *testing.T) {
+	buf := new(nopCloser)
+	s := New(buf)
+	s.Write([]byte("HEAD is now at 3f4b2c1d9e8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c\n"))
+	s.Write([]byte("+ ls /usr/local/lib/node_modules/typescript/lib\n"))
+	s.Write([]byte("+ echo [secret:password]\n"))
+	s.Close()
+
+	if got := s.Found(); len(got) != 0 {
+		t.Errorf("Want no findings, got %v", got)
+	}
+}
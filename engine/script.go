@@ -0,0 +1,79 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"path"
+	"strings"
+)
+
+// helper function quotes the glob pattern so that only the
+// wildcard characters are expanded by the shell.
+func shellquote(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*', '?', '[', ']':
+			b.WriteRune(r)
+		case '\'':
+			b.WriteString(`\'`)
+		default:
+			b.WriteString("'")
+			b.WriteRune(r)
+			b.WriteString("'")
+		}
+	}
+	return b.String()
+}
+
+// helper function returns the command used to execute the
+// step script. If the step defines an exec template, the
+// {shell} placeholder is replaced with the step shell, and
+// the {script} placeholder with a shell word that expands to
+// the step script.
+func toScriptCommand(step *Step) string {
+	shell := toShell(step)
+	if step.ExecTemplate != "" {
+		script := `"$DRONE_SCRIPT"`
+		if step.ScriptFile != "" {
+			script = `"$(cat ` + ScriptPath + "/" + step.ScriptFile + `)"`
+		}
+		return strings.NewReplacer(
+			"{shell}", shell,
+			"{script}", script,
+		).Replace(step.ExecTemplate)
+	}
+	if step.ScriptFile != "" {
+		return shell + " " + ScriptPath + "/" + step.ScriptFile
+	}
+	// the script is passed as an argument if the step reads
+	// the standard input, so that the script does not consume
+	// the standard input.
+	if step.Stdin != nil {
+		return shell + ` -c "$DRONE_SCRIPT"`
+	}
+	return `echo "$DRONE_SCRIPT" | ` + shell
+}
+
+// helper function returns the shell command used to execute
+// the command in the step container. If the shell is injected
+// into the container, the shell directory is added to the
+// path so that the command can use the injected utilities.
+func toShellCommand(step *Step, command string) []string {
+	shell := toShell(step)
+	if path.IsAbs(shell) {
+		command = "export PATH=$PATH:" + path.Dir(shell) + "; " + command
+	}
+	return []string{shell, "-c", command}
+}
+
+// helper function returns the step shell, defaulting to the
+// shell included in the image.
+func toShell(step *Step) string {
+	if step.Shell != "" {
+		return step.Shell
+	}
+	return "sh"
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestShellquote(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"*.xml", `*'.''x''m''l'`},
+		{"it's/*.xml", `'i''t'\''s''/'*'.''x''m''l'`},
+		{"$(rm)", `'$''(''r''m'')'`},
+	}
+	for _, test := range tests {
+		if got := shellquote(test.pattern); got != test.want {
+			t.Errorf("Want quoted pattern %s, got %s", test.want, got)
+		}
+	}
+}
+
+func TestToScriptCommand(t *testing.T) {
+	tests := []struct {
+		step *Step
+		want string
+	}{
+		{
+			step: &Step{},
+			want: `echo "$DRONE_SCRIPT" | sh`,
+		},
+		{
+			step: &Step{Shell: "/drone/bin/sh", ScriptFile: "abc"},
+			want: "/drone/bin/sh " + ScriptPath + "/abc",
+		},
+		{
+			step: &Step{ExecTemplate: `printf '%s' {script} | bash`},
+			want: `printf '%s' "$DRONE_SCRIPT" | bash`,
+		},
+		{
+			step: &Step{ExecTemplate: "{shell} -c {script}", ScriptFile: "abc"},
+			want: `sh -c "$(cat ` + ScriptPath + `/abc)"`,
+		},
+	}
+	for i, test := range tests {
+		if got := toScriptCommand(test.step); got != test.want {
+			t.Errorf("Want command %q, got %q at index %d", test.want, got, i)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// seccompAnnotation provides the name of the annotation that
+// selects the seccomp profile of the pod containers.
+const seccompAnnotation = "seccomp.security.alpha.kubernetes.io/pod"
+
+// helper function returns the pod security context.
+func toPodSecurityContext(spec *Spec) *v1.PodSecurityContext {
+	src := spec.PodSpec.SecurityContext
+	if src == nil {
+		return nil
+	}
+	dst := &v1.PodSecurityContext{
+		RunAsUser:  src.RunAsUser,
+		RunAsGroup: src.RunAsGroup,
+		FSGroup:    src.FSGroup,
+	}
+	if src.RunAsNonRoot {
+		dst.RunAsNonRoot = boolptr(true)
+	}
+	return dst
+}
+
+// helper function returns the pod annotations, including the
+// seccomp profile annotation if a seccomp profile is defined.
+func toPodAnnotations(spec *Spec) map[string]string {
+	src := spec.PodSpec.SecurityContext
+	if src == nil || src.SeccompProfile == "" {
+		return spec.PodSpec.Annotations
+	}
+	annotations := map[string]string{}
+	for k, v := range spec.PodSpec.Annotations {
+		annotations[k] = v
+	}
+	annotations[seccompAnnotation] = toSeccompProfile(src.SeccompProfile)
+	return annotations
+}
+
+// helper function returns the seccomp profile in annotation
+// format. The profile can be defined in the field format,
+// for example RuntimeDefault, or in the annotation format,
+// for example runtime/default.
+func toSeccompProfile(profile string) string {
+	switch profile {
+	case "RuntimeDefault":
+		return "runtime/default"
+	case "Unconfined":
+		return "unconfined"
+	}
+	if strings.HasPrefix(profile, "Localhost/") {
+		return "localhost/" + strings.TrimPrefix(profile, "Localhost/")
+	}
+	return profile
+}
+
+// helper function returns the container security context.
+// Capabilities are not dropped from privileged containers,
+// which have all capabilities.
+func toContainerSecurityContext(spec *Spec, privileged bool, uid, gid *int64) *v1.SecurityContext {
+	dst := &v1.SecurityContext{
+		Privileged: boolptr(privileged),
+		RunAsUser:  uid,
+		RunAsGroup: gid,
+	}
+	src := spec.PodSpec.SecurityContext
+	if src == nil || privileged {
+		return dst
+	}
+	if len(src.DropCapabilities) != 0 {
+		dst.Capabilities = &v1.Capabilities{}
+		for _, capability := range src.DropCapabilities {
+			dst.Capabilities.Drop = append(dst.Capabilities.Drop, v1.Capability(capability))
+		}
+	}
+	dst.AllowPrivilegeEscalation = src.AllowPrivilegeEscalation
+	return dst
+}
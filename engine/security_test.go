@@ -0,0 +1,99 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestToPodSecurityContext(t *testing.T) {
+	if toPodSecurityContext(&Spec{}) != nil {
+		t.Errorf("Want nil pod security context")
+	}
+	spec := &Spec{
+		PodSpec: PodSpec{
+			SecurityContext: &SecurityContext{
+				RunAsUser:    int64ptr(1000),
+				RunAsNonRoot: true,
+				FSGroup:      int64ptr(2000),
+			},
+		},
+	}
+	sc := toPodSecurityContext(spec)
+	if sc.RunAsUser == nil || *sc.RunAsUser != 1000 {
+		t.Errorf("Want pod run as user 1000")
+	}
+	if sc.FSGroup == nil || *sc.FSGroup != 2000 {
+		t.Errorf("Want pod fs group 2000")
+	}
+	if sc.RunAsNonRoot == nil || *sc.RunAsNonRoot == false {
+		t.Errorf("Want pod run as non-root")
+	}
+}
+
+func TestToPodAnnotations_Seccomp(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Annotations: map[string]string{"foo": "bar"},
+			SecurityContext: &SecurityContext{
+				SeccompProfile: "RuntimeDefault",
+			},
+		},
+	}
+	annotations := toPodAnnotations(spec)
+	if got, want := annotations[seccompAnnotation], "runtime/default"; got != want {
+		t.Errorf("Want seccomp profile %q, got %q", want, got)
+	}
+	if annotations["foo"] != "bar" {
+		t.Errorf("Want pod annotations preserved")
+	}
+	if _, ok := spec.PodSpec.Annotations[seccompAnnotation]; ok {
+		t.Errorf("Want spec annotations unchanged")
+	}
+}
+
+func TestToSeccompProfile(t *testing.T) {
+	tests := map[string]string{
+		"RuntimeDefault":         "runtime/default",
+		"runtime/default":        "runtime/default",
+		"Unconfined":             "unconfined",
+		"Localhost/profile.json": "localhost/profile.json",
+	}
+	for profile, want := range tests {
+		if got := toSeccompProfile(profile); got != want {
+			t.Errorf("Want seccomp profile %q, got %q", want, got)
+		}
+	}
+}
+
+func TestToContainerSecurityContext(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			SecurityContext: &SecurityContext{
+				DropCapabilities:         []string{"ALL"},
+				AllowPrivilegeEscalation: boolptr(false),
+			},
+		},
+	}
+	sc := toContainerSecurityContext(spec, false, int64ptr(1000), nil)
+	if sc.Capabilities == nil || len(sc.Capabilities.Drop) != 1 || sc.Capabilities.Drop[0] != v1.Capability("ALL") {
+		t.Errorf("Want capabilities dropped")
+	}
+	if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+		t.Errorf("Want privilege escalation disallowed")
+	}
+	if sc.RunAsUser == nil || *sc.RunAsUser != 1000 {
+		t.Errorf("Want step user")
+	}
+
+	// privileged containers have all capabilities, and
+	// cannot disallow privilege escalation.
+	sc = toContainerSecurityContext(spec, true, nil, nil)
+	if sc.Capabilities != nil || sc.AllowPrivilegeEscalation != nil {
+		t.Errorf("Want privileged container security context unchanged")
+	}
+}
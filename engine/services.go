@@ -0,0 +1,136 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// default time to wait for the services to be ready, in
+// seconds.
+const defaultReadinessTimeout = 300
+
+// helper function returns the readiness probe of the service
+// container, or nil if the service has no readiness probe.
+func toReadinessProbe(step *Step) *v1.Probe {
+	return toProbe(step.Readiness)
+}
+
+// helper function returns the readiness probe, or nil if the
+// readiness is not defined.
+func toProbe(ready *Readiness) *v1.Probe {
+	if ready == nil {
+		return nil
+	}
+	probe := &v1.Probe{
+		PeriodSeconds:    1,
+		FailureThreshold: 1,
+	}
+	if ready.Path != "" {
+		probe.HTTPGet = &v1.HTTPGetAction{
+			Path: ready.Path,
+			Port: intstr.FromInt(ready.Port),
+		}
+	} else {
+		probe.TCPSocket = &v1.TCPSocketAction{
+			Port: intstr.FromInt(ready.Port),
+		}
+	}
+	return probe
+}
+
+// helper function waits until the services with a readiness
+// probe are ready, so that steps do not start before the
+// services accept connections. Returns an error if the
+// services are not ready before the timeout.
+func (k *Kubernetes) waitForServices(ctx context.Context, spec *Spec, output io.Writer) error {
+	services := map[string]*Step{}
+	var timeout int64
+	for _, s := range spec.Steps {
+		if !s.Detach || s.Readiness == nil || s.RunPolicy == RunNever {
+			continue
+		}
+		services[s.ID] = s
+		if s.Readiness.Timeout > timeout {
+			timeout = s.Readiness.Timeout
+		}
+	}
+	// sidecars with a readiness probe, for example dependency
+	// caching proxies, are waited for like services.
+	for _, s := range spec.Sidecars {
+		if s.Readiness == nil {
+			continue
+		}
+		services[s.Name] = &Step{Name: s.Name, Readiness: s.Readiness}
+		if s.Readiness.Timeout > timeout {
+			timeout = s.Readiness.Timeout
+		}
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	if timeout == 0 {
+		timeout = defaultReadinessTimeout
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var pending []string
+	var reported bool
+	err := k.waitFor(waitCtx, spec, func(e watch.Event) (bool, error) {
+		switch e.Type {
+		case watch.Added, watch.Modified:
+		default:
+			return false, nil
+		}
+		pod, ok := e.Object.(*v1.Pod)
+		if !ok || pod.ObjectMeta.Name != spec.PodSpec.Name {
+			return false, nil
+		}
+		pending = notReady(pod, services)
+		if len(pending) == 0 {
+			return true, nil
+		}
+		if !reported {
+			reported = true
+			fmt.Fprintf(output, "waiting for services: %s\n", strings.Join(pending, ", "))
+		}
+		return false, nil
+	})
+	if err != nil && ctx.Err() == nil && waitCtx.Err() != nil {
+		return &Error{
+			Code: CodeServicesNotReady,
+			Err:  fmt.Errorf("engine: services not ready after %ds: %s", timeout, strings.Join(pending, ", ")),
+		}
+	}
+	return err
+}
+
+// helper function returns the sorted names of the services
+// that are not ready.
+func notReady(pod *v1.Pod, services map[string]*Step) []string {
+	ready := map[string]bool{}
+	for _, status := range pod.Status.ContainerStatuses {
+		ready[status.Name] = status.Ready
+	}
+	var names []string
+	for id, s := range services {
+		if !ready[id] {
+			names = append(names, s.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestToReadinessProbe(t *testing.T) {
+	if toReadinessProbe(&Step{}) != nil {
+		t.Errorf("Want no readiness probe")
+	}
+	probe := toReadinessProbe(&Step{Readiness: &Readiness{Port: 5432}})
+	if probe.TCPSocket == nil || probe.TCPSocket.Port.IntValue() != 5432 {
+		t.Errorf("Want tcp readiness probe on port 5432")
+	}
+	probe = toReadinessProbe(&Step{Readiness: &Readiness{Port: 8080, Path: "/healthz"}})
+	if probe.HTTPGet == nil || probe.HTTPGet.Path != "/healthz" || probe.HTTPGet.Port.IntValue() != 8080 {
+		t.Errorf("Want http readiness probe on port 8080")
+	}
+}
+
+func TestNotReady(t *testing.T) {
+	services := map[string]*Step{
+		"drone-1": {Name: "redis"},
+		"drone-2": {Name: "database"},
+	}
+	pod := &v1.Pod{}
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{
+		{Name: "drone-1", Ready: true},
+		{Name: "drone-2", Ready: false},
+	}
+	if got, want := notReady(pod, services), []string{"database"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want services %v not ready, got %v", want, got)
+	}
+	pod.Status.ContainerStatuses[1].Ready = true
+	if got := notReady(pod, services); len(got) != 0 {
+		t.Errorf("Want services ready, got %v not ready", got)
+	}
+}
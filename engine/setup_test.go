@@ -0,0 +1,240 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testSetupSpec() *Spec {
+	return &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-abc",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone": "true", "io.drone.name": "drone-abc"},
+		},
+		Steps: []*Step{
+			{ID: "step-a", Name: "build", Image: "alpine", Envs: map[string]string{}},
+		},
+		Secrets:    map[string]*Secret{"password": {Name: "password", Data: "correct-horse"}},
+		PullSecret: &Secret{Name: "drone-abc-pull", Data: "{}"},
+		Volumes: []*Volume{
+			{Claim: &VolumeClaim{ID: "workspace", Name: "_workspace", ClaimName: "drone-abc-workspace", Provision: true, Size: 1 << 30}},
+		},
+	}
+}
+
+func TestSetup(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k := &Kubernetes{client: client}
+	spec := testSetupSpec()
+	if err := k.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.CoreV1().Pods("default").Get("drone-abc", metav1.GetOptions{}); err != nil {
+		t.Errorf("Want pod created, got %s", err)
+	}
+	for _, name := range []string{"drone-abc", "drone-abc-pull"} {
+		if _, err := client.CoreV1().Secrets("default").Get(name, metav1.GetOptions{}); err != nil {
+			t.Errorf("Want secret %s created, got %s", name, err)
+		}
+	}
+	if _, err := client.CoreV1().PersistentVolumeClaims("default").Get("drone-abc-workspace", metav1.GetOptions{}); err != nil {
+		t.Errorf("Want volume claim created, got %s", err)
+	}
+}
+
+func TestSetup_Rollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+	}{
+		{name: "pod", resource: "pods"},
+		{name: "volume claim", resource: "persistentvolumeclaims"},
+		{name: "pull secret", resource: "secrets"},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		client.PrependReactor("create", test.resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			obj := action.(k8stesting.CreateAction).GetObject()
+			if secret, ok := obj.(*v1.Secret); ok && secret.Name != "drone-abc-pull" {
+				return false, nil, nil
+			}
+			return true, nil, kerrors.NewBadRequest("rejected")
+		})
+		k := &Kubernetes{client: client}
+
+		err := k.Setup(context.Background(), testSetupSpec())
+		if got, want := CodeOf(err), CodeSetupFailed; got != want {
+			t.Errorf("%s: Want error code %s, got %s", test.name, want, got)
+		}
+
+		// the resources that were created before the failure
+		// are deleted.
+		pods, _ := client.CoreV1().Pods("default").List(metav1.ListOptions{})
+		if len(pods.Items) != 0 {
+			t.Errorf("%s: Want pod rolled back, got %d pods", test.name, len(pods.Items))
+		}
+		secrets, _ := client.CoreV1().Secrets("default").List(metav1.ListOptions{})
+		if len(secrets.Items) != 0 {
+			t.Errorf("%s: Want secrets rolled back, got %d secrets", test.name, len(secrets.Items))
+		}
+		claims, _ := client.CoreV1().PersistentVolumeClaims("default").List(metav1.ListOptions{})
+		if len(claims.Items) != 0 {
+			t.Errorf("%s: Want volume claims rolled back, got %d claims", test.name, len(claims.Items))
+		}
+	}
+}
+
+func TestSetup_AlreadyExists(t *testing.T) {
+	owned := metav1.ObjectMeta{
+		Namespace: "default",
+		Labels:    map[string]string{"io.drone.name": "drone-abc"},
+	}
+	foreign := metav1.ObjectMeta{
+		Namespace: "default",
+		Labels:    map[string]string{"app": "billing"},
+	}
+	secret := func(meta metav1.ObjectMeta) *v1.Secret {
+		meta.Name = "drone-abc"
+		return &v1.Secret{ObjectMeta: meta, StringData: map[string]string{"password": "stale"}}
+	}
+	claim := func(meta metav1.ObjectMeta) *v1.PersistentVolumeClaim {
+		meta.Name = "drone-abc-workspace"
+		return &v1.PersistentVolumeClaim{ObjectMeta: meta}
+	}
+	pod := func(phase v1.PodPhase) *v1.Pod {
+		spec := testSetupSpec()
+		pod := toPod(spec)
+		pod.Annotations = withSpecHash(spec, pod.Annotations)
+		pod.Status.Phase = phase
+		return pod
+	}
+
+	tests := []struct {
+		name     string
+		existing runtime.Object
+		code     ErrorCode
+	}{
+		{name: "owned secret", existing: secret(owned)},
+		{name: "owned volume claim", existing: claim(owned)},
+		{name: "owned running pod", existing: pod(v1.PodRunning)},
+		{name: "foreign secret", existing: secret(foreign), code: CodeResourceConflict},
+		{name: "foreign volume claim", existing: claim(foreign), code: CodeResourceConflict},
+		{name: "terminated pod", existing: pod(v1.PodFailed), code: CodeResourceConflict},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.existing)
+		k := &Kubernetes{client: client}
+
+		err := k.Setup(context.Background(), testSetupSpec())
+		if got, want := CodeOf(err), test.code; got != want {
+			t.Errorf("%s: Want error code %q, got %q (%v)", test.name, want, got, err)
+		}
+		if test.code != "" {
+			continue
+		}
+		// the existing secret is updated with the pipeline
+		// secrets.
+		got, err := client.CoreV1().Secrets("default").Get("drone-abc", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if got.StringData["password"] != "correct-horse" {
+			t.Errorf("%s: Want the pipeline secret reconciled", test.name)
+		}
+	}
+
+	// the foreign objects are not removed when setup is
+	// rolled back.
+	client := fake.NewSimpleClientset(secret(foreign))
+	k := &Kubernetes{client: client}
+	k.Setup(context.Background(), testSetupSpec())
+	got, err := client.CoreV1().Secrets("default").Get("drone-abc", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("Want the foreign secret kept, got %s", err)
+	} else if got.Labels["app"] != "billing" || got.StringData["password"] != "stale" {
+		t.Errorf("Want the foreign secret unchanged")
+	}
+}
+
+func TestSetup_OwnerReferences(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*v1.Pod)
+		pod.UID = "3f1c0c5e"
+		return false, nil, nil
+	})
+	k := &Kubernetes{client: client}
+
+	// setup is retried to verify the owner reference is not
+	// appended more than once.
+	for i := 0; i < 2; i++ {
+		if err := k.Setup(context.Background(), testSetupSpec()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []string{"drone-abc", "drone-abc-pull"}
+	for _, name := range tests {
+		secret, err := client.CoreV1().Secrets("default").Get(name, metav1.GetOptions{})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := len(secret.OwnerReferences); got != 1 {
+			t.Errorf("%s: Want 1 owner reference, got %d", name, got)
+			continue
+		}
+		ref := secret.OwnerReferences[0]
+		if ref.Kind != "Pod" || ref.Name != "drone-abc" || ref.UID != "3f1c0c5e" {
+			t.Errorf("%s: Want secret owned by the pipeline pod, got %v", name, ref)
+		}
+	}
+}
+
+func TestSetup_WorkspaceClaim(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k := &Kubernetes{client: client}
+	spec := testSetupSpec()
+	spec.Volumes[0].Claim.StorageClass = "fast"
+	spec.Volumes[0].Claim.AccessMode = "ReadWriteMany"
+	if err := k.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	claim, err := client.CoreV1().PersistentVolumeClaims("default").Get("drone-abc-workspace", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class := claim.Spec.StorageClassName; class == nil || *class != "fast" {
+		t.Errorf("Want storage class fast, got %v", class)
+	}
+	if modes := claim.Spec.AccessModes; len(modes) != 1 || modes[0] != v1.ReadWriteMany {
+		t.Errorf("Want access mode ReadWriteMany, got %v", modes)
+	}
+	if size := claim.Spec.Resources.Requests[v1.ResourceStorage]; size.Value() != 1<<30 {
+		t.Errorf("Want requested size 1Gi, got %s", size.String())
+	}
+
+	pod, _ := client.CoreV1().Pods("default").Get("drone-abc", metav1.GetOptions{})
+	var mounted bool
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == "drone-abc-workspace" {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Errorf("Want workspace volume claim mounted by the pod")
+	}
+}
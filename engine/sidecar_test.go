@@ -0,0 +1,62 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStopSidecar_NoCommand(t *testing.T) {
+	// the sidecar is not stopped, and the pod is not read,
+	// if the sidecar has no stop command.
+	client := fake.NewSimpleClientset()
+	k := &Kubernetes{client: client}
+	if err := k.stopSidecar(context.Background(), testSetupSpec(), &Sidecar{Name: "logger"}); err != nil {
+		t.Error(err)
+	}
+	if n := len(client.Actions()); n != 0 {
+		t.Errorf("Want no api calls, got %d", n)
+	}
+}
+
+func TestWaitSidecarExit(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  v1.ContainerState
+		exited bool
+	}{
+		{
+			name:   "terminated",
+			state:  v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0}},
+			exited: true,
+		},
+		{
+			name:   "running",
+			state:  v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+			exited: false,
+		},
+	}
+	for _, test := range tests {
+		spec := testSetupSpec()
+		pod := toPod(spec)
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{
+			{Name: "step-a", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+			{Name: "logger", State: test.state},
+		}
+		k := &Kubernetes{client: fake.NewSimpleClientset(pod)}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err := k.waitSidecarExit(ctx, spec, &Sidecar{Name: "logger"})
+		cancel()
+		if got := err == nil; got != test.exited {
+			t.Errorf("%s: Want sidecar exited %v, got error %v", test.name, test.exited, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// helper function returns the name of the label used to
+// identify volume snapshots that can be used to restore a
+// volume claim.
+func labelSnapshot(spec *Spec) string {
+	return labelPrefix(spec) + ".snapshot"
+}
+
+// volume snapshot resource, provided by the CSI external
+// snapshotter.
+var snapshotResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1beta1",
+	Resource: "volumesnapshots",
+}
+
+// Snapshot creates a snapshot of the pipeline volume claims
+// that are configured to create a snapshot. Older snapshots
+// with the same key are removed once the snapshot is created.
+func (k *Kubernetes) Snapshot(ctx context.Context, spec *Spec) error {
+	k, err := k.forCluster(spec)
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, v := range spec.Volumes {
+		if v.Claim == nil || v.Claim.Provision == false {
+			continue
+		}
+		if v.Claim.Snapshot == nil || v.Claim.Snapshot.Create == false {
+			continue
+		}
+		if err := k.createSnapshot(spec, v.Claim); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+// helper function creates a snapshot of the volume claim and
+// removes older snapshots with the same key.
+func (k *Kubernetes) createSnapshot(spec *Spec, claim *VolumeClaim) error {
+	client := k.dynamic.Resource(snapshotResource).Namespace(spec.PodSpec.Namespace)
+
+	snapshot := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": snapshotResource.GroupVersion().String(),
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name": claim.ClaimName,
+				"labels": map[string]interface{}{
+					labelSnapshot(spec): claim.Snapshot.Key,
+				},
+			},
+			"spec": map[string]interface{}{
+				"source": map[string]interface{}{
+					"persistentVolumeClaimName": claim.ClaimName,
+				},
+			},
+		},
+	}
+	if claim.Snapshot.Class != "" {
+		unstructured.SetNestedField(snapshot.Object, claim.Snapshot.Class, "spec", "volumeSnapshotClassName")
+	}
+	if _, err := client.Create(snapshot, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+
+	list, err := client.List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", labelSnapshot(spec), claim.Snapshot.Key),
+	})
+	if err != nil {
+		return err
+	}
+	var result error
+	for _, item := range list.Items {
+		if item.GetName() == claim.ClaimName {
+			continue
+		}
+		if err := client.Delete(item.GetName(), &metav1.DeleteOptions{}); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+// helper function configures the volume claim to be restored
+// from the most recent snapshot with a matching key that is
+// ready to use. The volume claim is provisioned empty if no
+// snapshot exists.
+func (k *Kubernetes) restoreSnapshot(spec *Spec, claim *v1.PersistentVolumeClaim) {
+	var key string
+	for _, v := range spec.Volumes {
+		if v.Claim != nil && v.Claim.ClaimName == claim.Name && v.Claim.Snapshot != nil {
+			key = v.Claim.Snapshot.Key
+		}
+	}
+	if key == "" {
+		return
+	}
+
+	client := k.dynamic.Resource(snapshotResource).Namespace(spec.PodSpec.Namespace)
+	list, err := client.List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", labelSnapshot(spec), key),
+	})
+	if err != nil {
+		logrus.WithError(err).
+			WithField("claim", claim.Name).
+			Warnln("cannot list volume snapshots")
+		return
+	}
+
+	var items []unstructured.Unstructured
+	for _, item := range list.Items {
+		ready, _, _ := unstructured.NestedBool(item.Object, "status", "readyToUse")
+		if ready {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a := items[i].GetCreationTimestamp()
+		b := items[j].GetCreationTimestamp()
+		return b.Before(&a)
+	})
+
+	claim.Spec.DataSource = &v1.TypedLocalObjectReference{
+		APIGroup: stringptr(snapshotResource.Group),
+		Kind:     "VolumeSnapshot",
+		Name:     items[0].GetName(),
+	}
+}
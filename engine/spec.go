@@ -4,56 +4,291 @@
 
 package engine
 
+import "encoding/json"
+
 type (
 	// Spec provides the pipeline spec. This provides the
 	// required instructions for reproducible pipeline
 	// execution.
 	Spec struct {
-		PodSpec    PodSpec            `json:"pod_spec,omitempty"`
-		Platform   Platform           `json:"platform,omitempty"`
-		Steps      []*Step            `json:"steps,omitempty"`
-		Volumes    []*Volume          `json:"volumes,omitempty"`
-		Secrets    map[string]*Secret `json:"secrets,omitempty"`
-		PullSecret *Secret            `json:"pull_secrets,omitempty"`
-		CommonEnvs map[string]string  `json:"common_envs,omitempty"`
+		PodSpec     PodSpec            `json:"pod_spec,omitempty"`
+		Platform    Platform           `json:"platform,omitempty"`
+		Init        []*Step            `json:"init_steps,omitempty"`
+		Sidecars    []*Sidecar         `json:"sidecars,omitempty"`
+		Steps       []*Step            `json:"steps,omitempty"`
+		Volumes     []*Volume          `json:"volumes,omitempty"`
+		Secrets     map[string]*Secret `json:"secrets,omitempty"`
+		PullSecret  *Secret            `json:"pull_secrets,omitempty"`
+		CommonEnvs  map[string]string  `json:"common_envs,omitempty"`
+		Concurrency *Concurrency       `json:"concurrency,omitempty"`
+
+		// UsageInterval provides the interval, in seconds, at
+		// which the step resource usage is written to the step
+		// output. Resource usage is not reported if zero.
+		UsageInterval int64 `json:"usage_interval,omitempty"`
+
+		// KeepAlive provides the interval, in seconds, at which
+		// keep-alive traffic is written to the exec stream of
+		// the step. Keep-alive traffic is disabled if zero.
+		KeepAlive int64 `json:"keep_alive,omitempty"`
+
+		// Watchdog provides the interval, in seconds, after
+		// which the step container is probed if the exec stream
+		// does not write output, so that the step completes if
+		// the stream is not closed after the step script exits.
+		// The watchdog is disabled if zero.
+		Watchdog int64 `json:"watchdog,omitempty"`
+
+		// PendingInterval provides the interval, in seconds, at
+		// which the reason the pod is pending is written to the
+		// step output. Pending reasons are not reported if zero.
+		PendingInterval int64 `json:"pending_interval,omitempty"`
+
+		// ScheduleTimeout provides the time, in seconds, the pod
+		// may wait to be scheduled before the step fails. The
+		// timeout is not enforced if zero.
+		ScheduleTimeout int64 `json:"schedule_timeout,omitempty"`
+
+		// PullTimeout provides the time, in seconds, a step may
+		// wait for its container to start once the pod is
+		// scheduled. The timeout is not enforced if zero.
+		PullTimeout int64 `json:"pull_timeout,omitempty"`
+
+		// TeardownTimeout provides the time, in seconds, allowed
+		// to destroy the pipeline environment. The timeout is
+		// not enforced if zero.
+		TeardownTimeout int64 `json:"teardown_timeout,omitempty"`
+
+		// Janitor provides the label value of the resources
+		// created by the pipeline steps, which are deleted
+		// when the pipeline is destroyed.
+		Janitor string `json:"janitor,omitempty"`
+
+		// Outputs enables passing the variables written by a
+		// step to the environment of subsequent steps.
+		Outputs bool `json:"outputs,omitempty"`
+
+		// LogStream enables streaming the step output from the
+		// container logs instead of the exec session.
+		LogStream bool `json:"log_stream,omitempty"`
+
+		// SecretScan provides the policy for secrets detected
+		// in the step output that are not masked.
+		SecretScan ScanPolicy `json:"secret_scan,omitempty"`
+
+		// NodeHelper provides the helper pods used to execute
+		// node steps. Node steps fail if nil.
+		NodeHelper *NodeHelper `json:"node_helper,omitempty"`
+
+		// Features provides the names of the feature flags
+		// enabled for the pipeline, which select new compiler
+		// and engine code paths.
+		Features []string `json:"features,omitempty"`
+
+		// Skipped is true if the pipeline is skipped, because
+		// the pipeline is not affected by the files changed by
+		// the build. The pipeline pod is not created.
+		Skipped bool `json:"skipped,omitempty"`
+
+		// Robot provides the short-lived registry credentials
+		// minted for the pipeline. Credentials are not minted
+		// if nil.
+		Robot *Robot `json:"robot,omitempty"`
+
+		// Cluster provides the cluster where the pipeline runs,
+		// if the pipeline provides a kubeconfig. The pipeline
+		// runs in the runner cluster if nil. The cluster is not
+		// serialized, so that the kubeconfig is not stored with
+		// the pipeline checkpoint.
+		Cluster *Cluster `json:"-"`
+
+		// Checkpoint provides the runner data stored with the
+		// pipeline, so that the runner can re-attach to the
+		// pipeline if the runner process restarts. The pipeline
+		// is not recoverable if empty.
+		Checkpoint json.RawMessage `json:"checkpoint,omitempty"`
+	}
+
+	// Concurrency defines a concurrency group. Pipelines in
+	// the same group acquire one of a limited number of locks
+	// before the pipeline is created.
+	Concurrency struct {
+		Group string `json:"group,omitempty"`
+		Limit int    `json:"limit,omitempty"`
+
+		// Namespace provides the namespace of the lease objects
+		// if the engine does not configure the lease namespace.
+		// Defaults to the pod namespace if empty.
+		Namespace string `json:"namespace,omitempty"`
+	}
+
+	// Cluster defines the cluster of a kubeconfig sourced
+	// from a pipeline secret.
+	Cluster struct {
+		Secret     string
+		Kubeconfig []byte
+	}
+
+	// Sidecar defines a container that is injected into the
+	// pipeline pod by the runner. If a stop command is defined,
+	// it is executed when the pipeline completes, and the
+	// pipeline is not complete until the sidecar exits.
+	Sidecar struct {
+		Name        string            `json:"name,omitempty"`
+		Image       string            `json:"image,omitempty"`
+		Entrypoint  []string          `json:"entrypoint,omitempty"`
+		Command     []string          `json:"args,omitempty"`
+		Envs        map[string]string `json:"environment,omitempty"`
+		Privileged  bool              `json:"privileged,omitempty"`
+		Resources   Resources         `json:"resources,omitempty"`
+		Stop        []string          `json:"stop,omitempty"`
+		StopTimeout int64             `json:"stop_timeout,omitempty"`
+		Readiness   *Readiness        `json:"readiness,omitempty"`
+	}
+
+	// Coverage configures code coverage reporting for a
+	// pipeline step.
+	Coverage struct {
+		Paths     []string `json:"paths,omitempty"`
+		Threshold float64  `json:"threshold,omitempty"`
+	}
+
+	// PathFilter defines the path conditions of a pipeline
+	// step, which are evaluated after the repository is
+	// cloned if the changed files are unknown when the
+	// pipeline is compiled.
+	PathFilter struct {
+		Include []string `json:"include,omitempty"`
+		Exclude []string `json:"exclude,omitempty"`
 	}
 
 	// Step defines a pipeline step.
 	Step struct {
 		ID           string            `json:"id,omitempty"`
 		Command      []string          `json:"args,omitempty"`
+		Coverage     *Coverage         `json:"coverage,omitempty"`
+		Dedupe       string            `json:"dedupe,omitempty"`
 		Detach       bool              `json:"detach,omitempty"`
 		DependsOn    []string          `json:"depends_on,omitempty"`
 		Entrypoint   []string          `json:"entrypoint,omitempty"`
 		Envs         map[string]string `json:"environment,omitempty"`
+		EnvFiles     []string          `json:"env_files,omitempty"`
+		EnvRefs      []*EnvRef         `json:"env_refs,omitempty"`
+		ExecTemplate string            `json:"exec_template,omitempty"`
 		IgnoreErr    bool              `json:"ignore_err,omitempty"`
 		IgnoreStdout bool              `json:"ignore_stderr,omitempty"`
 		IgnoreStderr bool              `json:"ignore_stdout,omitempty"`
 		Image        string            `json:"image,omitempty"`
+		JUnit        []string          `json:"junit,omitempty"`
 		Name         string            `json:"name,omitempty"`
+		Node         bool              `json:"node,omitempty"`
+		OffPeak      bool              `json:"off_peak,omitempty"`
+		Paths        *PathFilter       `json:"paths,omitempty"`
 		Privileged   bool              `json:"privileged,omitempty"`
 		Resources    Resources         `json:"resources,omitempty"`
 		Pull         PullPolicy        `json:"pull,omitempty"`
+		Readiness    *Readiness        `json:"readiness,omitempty"`
 		RunPolicy    RunPolicy         `json:"run_policy,omitempty"`
 		Secrets      []*SecretVar      `json:"secrets,omitempty"`
+		SecretFiles  []*SecretFile     `json:"secret_files,omitempty"`
+		ScriptFile   string            `json:"script_file,omitempty"`
+		Shell        string            `json:"shell,omitempty"`
+		Stdin        *Stdin            `json:"stdin,omitempty"`
+		Timeout      int64             `json:"timeout,omitempty"`
 		User         string            `json:"user,omitempty"`
+		ValueFiles   []string          `json:"value_files,omitempty"`
 		Volumes      []*VolumeMount    `json:"volumes,omitempty"`
+		WaitFor      *WaitFor          `json:"wait_for,omitempty"`
 		WorkingDir   string            `json:"working_dir,omitempty"`
 	}
 
+	// NodeHelper defines the helper pods used to execute
+	// node steps. A helper pod is expected to run on every
+	// node, for example as part of a DaemonSet.
+	NodeHelper struct {
+		Namespace string `json:"namespace,omitempty"`
+		Selector  string `json:"selector,omitempty"`
+		Container string `json:"container,omitempty"`
+	}
+
+	// WaitFor defines conditions that must be met before
+	// the step commands are executed.
+	WaitFor struct {
+		HTTP    string `json:"http,omitempty"`
+		TCP     string `json:"tcp,omitempty"`
+		File    string `json:"file,omitempty"`
+		Command string `json:"command,omitempty"`
+		Timeout int64  `json:"timeout,omitempty"`
+	}
+
+	// Readiness defines the readiness probe of a service.
+	Readiness struct {
+		Port    int    `json:"port,omitempty"`
+		Path    string `json:"path,omitempty"`
+		Timeout int64  `json:"timeout,omitempty"`
+	}
+
 	// Platform defines the target platform.
 	Platform struct {
 		OS      string `json:"os,omitempty"`
 		Arch    string `json:"arch,omitempty"`
 		Variant string `json:"variant,omitempty"`
 		Version string `json:"version,omitempty"`
+
+		// Features provides the node features required by
+		// the pipeline, for example binfmt for emulated
+		// cross-platform builds.
+		Features []string `json:"features,omitempty"`
 	}
 
-	// Secret represents a secret variable.
+	// Secret represents a secret variable. Local secrets
+	// are resolved at compile time and used by the runner,
+	// for example to mask output, but are not written to
+	// the pipeline secret. Encrypted secrets are written to
+	// the pipeline secret encrypted with the runner key.
+	// Binary secrets hold base64 encoded data, which is
+	// decoded when written to the pipeline secret.
 	Secret struct {
+		Name      string `json:"name,omitempty"`
+		Data      string `json:"data,omitempty"`
+		Mask      bool   `json:"mask,omitempty"`
+		Local     bool   `json:"local,omitempty"`
+		Encrypted bool   `json:"encrypted,omitempty"`
+		Binary    bool   `json:"binary,omitempty"`
+	}
+
+	// SecretFile represents a pipeline secret that is mounted
+	// into the step container as a file at the given path.
+	SecretFile struct {
 		Name string `json:"name,omitempty"`
-		Data string `json:"data,omitempty"`
-		Mask bool   `json:"mask,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+
+	// EnvRef represents an environment variable that is
+	// injected without passing through the server, from an
+	// existing Kubernetes secret or config map, or from vault.
+	EnvRef struct {
+		Env       string     `json:"env,omitempty"`
+		Secret    *EnvKeyRef `json:"secret,omitempty"`
+		ConfigMap *EnvKeyRef `json:"config_map,omitempty"`
+		Vault     *EnvKeyRef `json:"vault,omitempty"`
+	}
+
+	// EnvKeyRef references a key of a Kubernetes secret or
+	// config map. For vault secrets, the name provides the
+	// secret path.
+	EnvKeyRef struct {
+		Name     string `json:"name,omitempty"`
+		Key      string `json:"key,omitempty"`
+		Optional bool   `json:"optional,omitempty"`
+	}
+
+	// Stdin provides the payload written to the standard
+	// input of the step process, inline or from a pipeline
+	// secret.
+	Stdin struct {
+		Data   string `json:"data,omitempty"`
+		Secret string `json:"secret,omitempty"`
 	}
 
 	// SecretVar represents an environment variable
@@ -63,11 +298,20 @@ type (
 		Env  string `json:"env,omitempty"`
 	}
 
+	// Image describes a container image used by the pipeline,
+	// resolved to the image digest.
+	Image struct {
+		Container string `json:"container"`
+		Name      string `json:"name"`
+		Digest    string `json:"digest,omitempty"`
+	}
+
 	// State represents the process state.
 	State struct {
 		ExitCode  int  // Container exit code
 		Exited    bool // Container exited
 		OOMKilled bool // Container is oom killed
+		TimedOut  bool // Step exceeded the step timeout
 	}
 
 	// Volume that can be mounted by containers.
@@ -75,13 +319,22 @@ type (
 		EmptyDir    *VolumeEmptyDir    `json:"temp,omitempty"`
 		HostPath    *VolumeHostPath    `json:"host,omitempty"`
 		DownwardAPI *VolumeDownwardAPI `json:"downward_api,omitempty"`
+		Secret      *VolumeSecret      `json:"secret,omitempty"`
+		Claim       *VolumeClaim       `json:"claim,omitempty"`
+		NFS         *VolumeNFS         `json:"nfs,omitempty"`
+		CSI         *VolumeCSI         `json:"csi,omitempty"`
+		Projected   *VolumeProjected   `json:"projected,omitempty"`
+		ConfigMap   *VolumeConfigMap   `json:"config_map,omitempty"`
 	}
 
 	// VolumeMount describes a mounting of a Volume
 	// within a container.
 	VolumeMount struct {
-		Name string `json:"name,omitempty"`
-		Path string `json:"path,omitempty"`
+		Name        string `json:"name,omitempty"`
+		Path        string `json:"path,omitempty"`
+		SubPath     string `json:"sub_path,omitempty"`
+		ReadOnly    bool   `json:"read_only,omitempty"`
+		Propagation string `json:"propagation,omitempty"`
 	}
 
 	// VolumeEmptyDir mounts a temporary directory from the
@@ -101,6 +354,27 @@ type (
 		Name string `json:"name,omitempty"`
 		Path string `json:"path,omitempty"`
 	}
+
+	// VolumeNFS mounts a directory exported by an nfs server
+	// into the container.
+	VolumeNFS struct {
+		ID       string `json:"id,omitempty"`
+		Name     string `json:"name,omitempty"`
+		Server   string `json:"server,omitempty"`
+		Path     string `json:"path,omitempty"`
+		ReadOnly bool   `json:"read_only,omitempty"`
+	}
+
+	// VolumeCSI mounts an ephemeral inline volume provided by
+	// a csi driver into the container.
+	VolumeCSI struct {
+		ID         string            `json:"id,omitempty"`
+		Name       string            `json:"name,omitempty"`
+		Driver     string            `json:"driver,omitempty"`
+		FSType     string            `json:"fs_type,omitempty"`
+		ReadOnly   bool              `json:"read_only,omitempty"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+	}
 	// VolumeDownwardAPI ...
 	VolumeDownwardAPI struct {
 		ID    string                  `json:"id,omitempty"`
@@ -113,10 +387,100 @@ type (
 		FieldPath string `json:"field_path,omitempty"`
 	}
 
+	// VolumeSecret mounts keys from a Kubernetes secret
+	// into the container as files.
+	VolumeSecret struct {
+		ID         string             `json:"id,omitempty"`
+		Name       string             `json:"name,omitempty"`
+		SecretName string             `json:"secret_name,omitempty"`
+		Items      []VolumeSecretItem `json:"items,omitempty"`
+	}
+
+	// VolumeClaim mounts a persistent volume claim. If
+	// provisioning is enabled, the claim is created when the
+	// pipeline environment is setup and removed when the
+	// pipeline environment is destroyed. If keep is enabled,
+	// the claim is created if it does not exist and is never
+	// removed, so that it is reused by subsequent pipelines.
+	VolumeClaim struct {
+		ID           string `json:"id,omitempty"`
+		Name         string `json:"name,omitempty"`
+		ClaimName    string `json:"claim_name,omitempty"`
+		Provision    bool   `json:"provision,omitempty"`
+		StorageClass string `json:"storage_class,omitempty"`
+		AccessMode   string `json:"access_mode,omitempty"`
+		Size         int64  `json:"size,omitempty"`
+		ReadOnly     bool   `json:"read_only,omitempty"`
+		Keep         bool   `json:"keep,omitempty"`
+		Cache        bool   `json:"cache,omitempty"`
+
+		Snapshot *VolumeClaimSnapshot `json:"snapshot,omitempty"`
+	}
+
+	// VolumeConfigMap mounts keys from a Kubernetes config
+	// map into the container as files.
+	VolumeConfigMap struct {
+		ID            string             `json:"id,omitempty"`
+		Name          string             `json:"name,omitempty"`
+		ConfigMapName string             `json:"config_map_name,omitempty"`
+		Items         []VolumeSecretItem `json:"items,omitempty"`
+	}
+
+	// VolumeClaimSnapshot configures the volume claim to be
+	// restored from the most recent snapshot with a matching
+	// key. If create is true, a snapshot of the volume claim
+	// is taken when the pipeline succeeds.
+	VolumeClaimSnapshot struct {
+		Key    string `json:"key,omitempty"`
+		Class  string `json:"class,omitempty"`
+		Create bool   `json:"create,omitempty"`
+	}
+
+	// VolumeProjected mounts multiple volume sources into the
+	// same directory.
+	VolumeProjected struct {
+		ID      string             `json:"id,omitempty"`
+		Name    string             `json:"name,omitempty"`
+		Sources []VolumeProjection `json:"sources,omitempty"`
+	}
+
+	// VolumeProjection describes a projected volume source.
+	VolumeProjection struct {
+		Secret              *VolumeProjectedObject `json:"secret,omitempty"`
+		ConfigMap           *VolumeProjectedObject `json:"config_map,omitempty"`
+		ServiceAccountToken *VolumeProjectedToken  `json:"service_account_token,omitempty"`
+	}
+
+	// VolumeProjectedObject projects the keys of a secret or
+	// config map.
+	VolumeProjectedObject struct {
+		Name  string             `json:"name,omitempty"`
+		Items []VolumeSecretItem `json:"items,omitempty"`
+	}
+
+	// VolumeProjectedToken projects a service account token.
+	VolumeProjectedToken struct {
+		Audience          string `json:"audience,omitempty"`
+		ExpirationSeconds int64  `json:"expiration_seconds,omitempty"`
+		Path              string `json:"path,omitempty"`
+	}
+
+	// VolumeSecretItem maps a secret key to a file path
+	// relative to the volume mount point.
+	VolumeSecretItem struct {
+		Key  string `json:"key,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+
 	// Resources describes the compute resource requirements.
 	Resources struct {
 		Limits   ResourceObject `json:"limits,omitempty"`
 		Requests ResourceObject `json:"requests,omitempty"`
+
+		// Devices provides the device plugin resources
+		// requested by the container, for example the kvm
+		// device.
+		Devices map[string]int64 `json:"devices,omitempty"`
 	}
 
 	// ResourceObject describes compute resource requirements.
@@ -131,12 +495,62 @@ type (
 		Namespace          string            `json:"namespace,omitempty"`
 		Annotations        map[string]string `json:"annotations,omitempty"`
 		Labels             map[string]string `json:"labels,omitempty"`
+		LabelPrefix        string            `json:"label_prefix,omitempty"`
+		Finalizers         []string          `json:"finalizers,omitempty"`
+		Template           json.RawMessage   `json:"template,omitempty"`
 		NodeName           string            `json:"node_name,omitempty"`
 		NodeSelector       map[string]string `json:"node_selector,omitempty"`
 		Tolerations        []Toleration      `json:"tolerations,omitempty"`
+		Affinity           *Affinity         `json:"affinity,omitempty"`
+		RuntimeClassName   string            `json:"runtime_class_name,omitempty"`
 		ServiceAccountName string            `json:"service_account_name,omitempty"`
+		AutomountToken     bool              `json:"automount_service_account_token,omitempty"`
 		HostAliases        []HostAlias       `json:"host_aliases,omitempty"`
 		DNS                DNS               `json:"dns,omitempty"`
+
+		// PullSecrets provides the names of existing image pull
+		// secrets in the pod namespace, for example registry
+		// credentials managed outside of the runner.
+		PullSecrets []string `json:"image_pull_secrets,omitempty"`
+
+		// BlockMetadata blocks access to the cloud provider
+		// metadata endpoints from the pod, using a network
+		// policy that is created with the pod.
+		BlockMetadata bool `json:"block_metadata,omitempty"`
+
+		// Isolated creates the pod namespace when the pipeline
+		// is setup, and deletes the namespace, including all
+		// pipeline resources, when the pipeline is destroyed.
+		Isolated bool `json:"isolated,omitempty"`
+
+		// NodeAddress exposes the address of the node to the
+		// step containers as DRONE_NODE_IP, so that the step
+		// environment can reference services running on the
+		// node, for example dependency caching proxies.
+		NodeAddress bool `json:"node_address,omitempty"`
+
+		// Topology provides the zone and region labels of the
+		// pipeline, which are also part of the node selector,
+		// so that the engine can verify that nodes exist in
+		// the zone or region before the pod is created.
+		Topology map[string]string `json:"topology,omitempty"`
+
+		// SecurityContext provides the security context of the
+		// pod, which is applied to all pipeline containers.
+		SecurityContext *SecurityContext `json:"security_context,omitempty"`
+	}
+
+	// SecurityContext defines the pod security context. The
+	// capabilities are dropped, and privilege escalation is
+	// configured, for each container that is not privileged.
+	SecurityContext struct {
+		RunAsUser                *int64   `json:"run_as_user,omitempty"`
+		RunAsGroup               *int64   `json:"run_as_group,omitempty"`
+		RunAsNonRoot             bool     `json:"run_as_non_root,omitempty"`
+		FSGroup                  *int64   `json:"fs_group,omitempty"`
+		SeccompProfile           string   `json:"seccomp_profile,omitempty"`
+		DropCapabilities         []string `json:"drop_capabilities,omitempty"`
+		AllowPrivilegeEscalation *bool    `json:"allow_privilege_escalation,omitempty"`
 	}
 
 	// HostAlias ...
@@ -154,6 +568,20 @@ type (
 		Value             string `json:"value,omitempty"`
 	}
 
+	// Affinity defines the node affinity of the pod.
+	Affinity struct {
+		Required  []NodeRequirement `json:"required,omitempty"`
+		Preferred []NodeRequirement `json:"preferred,omitempty"`
+	}
+
+	// NodeRequirement defines a node label requirement.
+	NodeRequirement struct {
+		Key      string   `json:"key,omitempty"`
+		Operator string   `json:"operator,omitempty"`
+		Values   []string `json:"values,omitempty"`
+		Weight   int      `json:"weight,omitempty"`
+	}
+
 	// DNS ...
 	DNS struct {
 		DNSPolicy string              `json:"dns_policy,omitempty"`
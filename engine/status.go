@@ -0,0 +1,128 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// waiting reasons that prevent a container from starting, and
+// that are not resolved without changes to the pipeline or
+// the cluster. The pod never runs if a container cannot start,
+// so the pipeline fails instead of waiting for the timeout.
+var waitingReasons = map[string]waitingReason{
+	"ErrImagePull":               {"cannot pull image", CodeImagePullFailed},
+	"ImagePullBackOff":           {"cannot pull image", CodeImagePullFailed},
+	"ErrImageNeverPull":          {"cannot pull image", CodeImagePullFailed},
+	"InvalidImageName":           {"invalid image name", CodeImagePullFailed},
+	"CreateContainerConfigError": {"cannot create container", CodeContainerFailed},
+}
+
+// waitingReason describes a waiting reason that prevents a
+// container from starting.
+type waitingReason struct {
+	desc string
+	code ErrorCode
+}
+
+// helper function returns an error if a container of the pod
+// is waiting for a reason that prevents the container from
+// starting, for example if the container image cannot be
+// pulled.
+func checkContainers(spec *Spec, pod *v1.Pod) error {
+	statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting == nil {
+			continue
+		}
+		reason, ok := waitingReasons[waiting.Reason]
+		if !ok {
+			continue
+		}
+		name := status.Name
+		for _, step := range spec.Steps {
+			if step.ID == status.Name {
+				name = step.Name
+				break
+			}
+		}
+		if waiting.Message == "" {
+			return &Error{
+				Code: reason.code,
+				Err:  fmt.Errorf("engine: step %s: %s %s (%s)", name, reason.desc, status.Image, waiting.Reason),
+			}
+		}
+		return &Error{
+			Code: reason.code,
+			Err:  fmt.Errorf("engine: step %s: %s %s (%s): %s", name, reason.desc, status.Image, waiting.Reason, waiting.Message),
+		}
+	}
+	return nil
+}
+
+// helper function returns an error if the pod failed before
+// it was running because it was rejected by the node, for
+// example because the node is out of resources.
+func checkRejected(pod *v1.Pod) error {
+	if pod.Status.Phase != v1.PodFailed || pod.Status.Reason == "" {
+		return nil
+	}
+	return &Error{
+		Code: CodeSchedulingFailed,
+		Err:  fmt.Errorf("engine: pod %s was rejected by the node (%s): %s", pod.Name, pod.Status.Reason, pod.Status.Message),
+	}
+}
+
+// helper function updates the step state from the terminated
+// state of the step container, if the container terminated.
+// Returns true if the container terminated.
+func (k *Kubernetes) checkTerminated(ctx context.Context, spec *Spec, step *Step, state *State) bool {
+	terminated, ok := k.terminated(ctx, spec, step)
+	if !ok {
+		return false
+	}
+	state.Exited = true
+	state.ExitCode = int(terminated.ExitCode)
+	state.OOMKilled = terminated.Reason == "OOMKilled"
+	return true
+}
+
+// helper function returns true if the step container is
+// running, so that the step does not exec into the container
+// before it started. Containers are not restarted, so an error
+// is returned if the container of a step that has not started
+// terminated, for example because the entrypoint exited.
+func checkStepContainer(pod *v1.Pod, step *Step) (bool, error) {
+	// node steps do not run in a container of the pod.
+	if step.Node {
+		return true, nil
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != step.ID {
+			continue
+		}
+		if status.State.Running != nil {
+			return true, nil
+		}
+		terminated := status.State.Terminated
+		if terminated == nil {
+			return false, nil
+		}
+		// detached steps may exit before the step is
+		// started, for example a service that runs once.
+		if step.Detach {
+			return true, nil
+		}
+		return false, &Error{
+			Code: CodeContainerFailed,
+			Err:  fmt.Errorf("engine: step %s: container terminated before the step started (%s)", step.Name, terminated.Reason),
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestCheckContainers(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{ID: "drone-abc", Name: "build"},
+		},
+	}
+	tests := []struct {
+		reason  string
+		message string
+		err     string
+	}{
+		{
+			reason: "ContainerCreating",
+		},
+		{
+			reason:  "ImagePullBackOff",
+			message: "Back-off pulling image \"golang:404\"",
+			err:     "[IMAGE_PULL_FAILED] engine: step build: cannot pull image golang:404 (ImagePullBackOff): Back-off pulling image \"golang:404\"",
+		},
+		{
+			reason: "CreateContainerConfigError",
+			err:    "[CONTAINER_CREATE_FAILED] engine: step build: cannot create container golang:404 (CreateContainerConfigError)",
+		},
+	}
+	for _, test := range tests {
+		pod := &v1.Pod{}
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{
+			{
+				Name:  "drone-abc",
+				Image: "golang:404",
+				State: v1.ContainerState{
+					Waiting: &v1.ContainerStateWaiting{
+						Reason:  test.reason,
+						Message: test.message,
+					},
+				},
+			},
+		}
+		err := checkContainers(spec, pod)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("Want no error for reason %s, got %s", test.reason, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Want error for reason %s", test.reason)
+		} else if got, want := err.Error(), test.err; got != want {
+			t.Errorf("Want error %q, got %q", want, got)
+		}
+	}
+}
+
+func TestCheckRejected(t *testing.T) {
+	pod := &v1.Pod{}
+	pod.Name = "drone-abc"
+	pod.Status.Phase = v1.PodPending
+	if err := checkRejected(pod); err != nil {
+		t.Errorf("Want no error for pending pod, got %s", err)
+	}
+	pod.Status.Phase = v1.PodFailed
+	pod.Status.Reason = "OutOfcpu"
+	pod.Status.Message = "Node didn't have enough resource: cpu"
+	err := checkRejected(pod)
+	if got, want := CodeOf(err), CodeSchedulingFailed; got != want {
+		t.Errorf("Want error code %s, got %s", want, got)
+	}
+}
+
+func TestCheckStepContainer(t *testing.T) {
+	step := &Step{ID: "drone-abc", Name: "build"}
+	pod := &v1.Pod{}
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{
+		{
+			Name: "drone-abc",
+			State: v1.ContainerState{
+				Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"},
+			},
+		},
+		{
+			Name: "drone-def",
+			State: v1.ContainerState{
+				Running: &v1.ContainerStateRunning{},
+			},
+		},
+	}
+	if ready, err := checkStepContainer(pod, step); ready || err != nil {
+		t.Errorf("Want step waiting for the step container")
+	}
+
+	pod.Status.ContainerStatuses[0].State = v1.ContainerState{
+		Running: &v1.ContainerStateRunning{},
+	}
+	if ready, err := checkStepContainer(pod, step); !ready || err != nil {
+		t.Errorf("Want step ready when the step container is running")
+	}
+
+	pod.Status.ContainerStatuses[0].State = v1.ContainerState{
+		Terminated: &v1.ContainerStateTerminated{Reason: "Error"},
+	}
+	_, err := checkStepContainer(pod, step)
+	if got, want := CodeOf(err), CodeContainerFailed; got != want {
+		t.Errorf("Want error code %s, got %s", want, got)
+	}
+	step.Detach = true
+	if ready, err := checkStepContainer(pod, step); !ready || err != nil {
+		t.Errorf("Want detached step ready when the container terminated")
+	}
+}
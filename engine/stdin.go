@@ -0,0 +1,53 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+)
+
+// helper function returns the payload written to the standard
+// input of the step process. Returns an error if the payload is
+// sourced from a pipeline secret that does not exist.
+func toStdin(spec *Spec, step *Step) ([]byte, error) {
+	if step.Stdin == nil {
+		return nil, nil
+	}
+	if step.Stdin.Secret == "" {
+		return []byte(step.Stdin.Data), nil
+	}
+	secret, ok := spec.Secrets[step.Stdin.Secret]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", step.Stdin.Secret)
+	}
+	return []byte(secret.Data), nil
+}
+
+// helper function returns the command that sources the exports
+// from the standard input up to the marker line, so that the
+// remainder of the standard input is available to the step
+// process. The lines are read one at a time, because the shell
+// does not read past the line from a pipe.
+func toStdinCommand(command, marker string) string {
+	return fmt.Sprintf(
+		`_drone_exports=; while IFS= read -r _drone_line && [ "$_drone_line" != "%s" ]; do _drone_exports="$_drone_exports$_drone_line
+"; done; eval "$_drone_exports"; unset _drone_exports _drone_line; %s`,
+		marker, command,
+	)
+}
+
+// helper function returns the standard input of a step that
+// reads the payload, prefixed with the exports and the marker
+// line if the step has exports.
+func toStdinStream(exports, payload []byte, marker string) []byte {
+	if len(exports) == 0 {
+		return payload
+	}
+	var buf []byte
+	buf = append(buf, exports...)
+	buf = append(buf, marker...)
+	buf = append(buf, '\n')
+	return append(buf, payload...)
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToStdin(t *testing.T) {
+	spec := &Spec{
+		Secrets: map[string]*Secret{
+			"passphrase": {Name: "passphrase", Data: "correct-horse\n"},
+		},
+	}
+	tests := []struct {
+		stdin *Stdin
+		want  string
+		err   bool
+	}{
+		{stdin: nil, want: ""},
+		{stdin: &Stdin{Data: "yes\n"}, want: "yes\n"},
+		{stdin: &Stdin{Secret: "passphrase"}, want: "correct-horse\n"},
+		{stdin: &Stdin{Secret: "unknown"}, err: true},
+	}
+	for _, test := range tests {
+		got, err := toStdin(spec, &Step{Stdin: test.stdin})
+		if (err != nil) != test.err {
+			t.Errorf("Want error %v, got %v", test.err, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("Want stdin %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestToStdinStream(t *testing.T) {
+	payload := []byte("yes\n")
+	if got := toStdinStream(nil, payload, "marker"); string(got) != "yes\n" {
+		t.Errorf("Want the payload only if the step has no exports, got %q", got)
+	}
+	got := toStdinStream([]byte("export TOKEN='a\nb'\n"), payload, "marker")
+	if want := "export TOKEN='a\nb'\nmarker\nyes\n"; string(got) != want {
+		t.Errorf("Want exports and marker before the payload, got %q", got)
+	}
+}
+
+func TestToScriptCommand_Stdin(t *testing.T) {
+	step := &Step{Stdin: &Stdin{Data: "yes\n"}}
+	if got, want := toScriptCommand(step), `sh -c "$DRONE_SCRIPT"`; got != want {
+		t.Errorf("Want script passed as argument %q, got %q", want, got)
+	}
+	if got := toStdinCommand("make", "marker"); !strings.HasSuffix(got, "; make") || !strings.Contains(got, `!= "marker"`) {
+		t.Errorf("Want exports read up to the marker, got %q", got)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// helper function returns the pipeline pod merged with the
+// pod template. The compiled pod is strategically merged into
+// the template, so that lists with a merge key, for example
+// containers and volumes, are combined, and the compiled pod
+// takes precedence for all other fields it defines.
+func toTemplatePod(spec *Spec) (*v1.Pod, error) {
+	pod := toPod(spec)
+	if len(spec.PodSpec.Template) == 0 {
+		return pod, nil
+	}
+	patch, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := strategicpatch.StrategicMergePatch(spec.PodSpec.Template, patch, v1.Pod{})
+	if err != nil {
+		return nil, fmt.Errorf("engine: cannot merge pod template: %s", err)
+	}
+	out := new(v1.Pod)
+	if err := json.Unmarshal(merged, out); err != nil {
+		return nil, fmt.Errorf("engine: cannot merge pod template: %s", err)
+	}
+	return out, nil
+}
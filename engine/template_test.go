@@ -0,0 +1,55 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestTemplatePod(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-pod",
+			Namespace: "default",
+			Template: []byte(`{
+				"metadata": {"labels": {"team": "platform"}},
+				"spec": {
+					"tolerations": [{"key": "ci", "operator": "Exists"}],
+					"containers": [{"name": "proxy", "image": "envoyproxy/envoy"}]
+				}
+			}`),
+		},
+		Steps: []*Step{
+			{ID: "drone-step", Name: "build", Image: "golang"},
+		},
+	}
+	pod, err := toTemplatePod(spec)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := pod.Name, "drone-pod"; got != want {
+		t.Errorf("Want pod name %q, got %q", want, got)
+	}
+	if got, want := pod.Labels["team"], "platform"; got != want {
+		t.Errorf("Want template label %q, got %q", want, got)
+	}
+	if got, want := len(pod.Spec.Containers), 2; got != want {
+		t.Errorf("Want %d containers, got %d", want, got)
+	}
+	if got, want := len(pod.Spec.Tolerations), 1; got != want {
+		t.Errorf("Want %d tolerations, got %d", want, got)
+	}
+}
+
+func TestTemplatePod_Invalid(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:     "drone-pod",
+			Template: []byte(`{"spec":`),
+		},
+	}
+	if _, err := toTemplatePod(spec); err == nil {
+		t.Errorf("Expect error merging invalid pod template")
+	}
+}
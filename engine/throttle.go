@@ -0,0 +1,91 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"sync"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// ThrottleDeletes limits the rate of delete calls issued when
+// pipelines are destroyed, so that mass cancellations do not
+// overload the api server. Pod deletes are prioritized over
+// secret and volume claim deletes, so that pipelines stop
+// promptly and the remaining objects are deleted after.
+func (k *Kubernetes) ThrottleDeletes(qps float32, burst int) {
+	k.deletes = newThrottle(flowcontrol.NewTokenBucketRateLimiter(qps, burst))
+}
+
+// delete priorities.
+const (
+	priorityHigh = iota
+	priorityLow
+)
+
+// throttle grants permission to issue api calls at a fixed
+// rate, in order of priority. Requests of the same priority
+// are granted in order of arrival.
+type throttle struct {
+	limiter limiter
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	waiting [2][]chan struct{}
+}
+
+// limiter blocks until a request is permitted by the rate
+// limit.
+type limiter interface {
+	Accept()
+}
+
+func newThrottle(limiter limiter) *throttle {
+	t := &throttle{limiter: limiter}
+	t.cond = sync.NewCond(&t.mu)
+	go t.run()
+	return t
+}
+
+// wait blocks until the request is granted. A nil throttle
+// grants requests immediately.
+func (t *throttle) wait(priority int) {
+	if t == nil {
+		return
+	}
+	ch := make(chan struct{})
+	t.mu.Lock()
+	t.waiting[priority] = append(t.waiting[priority], ch)
+	t.cond.Signal()
+	t.mu.Unlock()
+	<-ch
+}
+
+// helper function grants the waiting requests at the rate
+// permitted by the rate limiter.
+func (t *throttle) run() {
+	for {
+		t.limiter.Accept()
+		t.next()
+	}
+}
+
+// helper function grants the highest priority waiting request,
+// blocking until a request is waiting.
+func (t *throttle) next() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for {
+		for i, queue := range t.waiting {
+			if len(queue) == 0 {
+				continue
+			}
+			close(queue[0])
+			t.waiting[i] = queue[1:]
+			return
+		}
+		t.cond.Wait()
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLimiter grants a token each time a value is sent on
+// the channel.
+type fakeLimiter struct {
+	tokens chan struct{}
+}
+
+func (l *fakeLimiter) Accept() { <-l.tokens }
+
+func TestThrottle_Priority(t *testing.T) {
+	limiter := &fakeLimiter{tokens: make(chan struct{})}
+	throttle := newThrottle(limiter)
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for _, priority := range []int{priorityLow, priorityLow, priorityHigh} {
+		wg.Add(1)
+		go func(priority int) {
+			defer wg.Done()
+			throttle.wait(priority)
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+		}(priority)
+	}
+
+	// wait for the requests to be queued before tokens are
+	// granted.
+	for {
+		throttle.mu.Lock()
+		n := len(throttle.waiting[priorityHigh]) + len(throttle.waiting[priorityLow])
+		throttle.mu.Unlock()
+		if n == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	limiter.tokens <- struct{}{}
+	for {
+		mu.Lock()
+		n := len(order)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	limiter.tokens <- struct{}{}
+	limiter.tokens <- struct{}{}
+	wg.Wait()
+
+	if order[0] != priorityHigh {
+		t.Errorf("Want high priority request granted first, got order %v", order)
+	}
+}
+
+func TestThrottle_Nil(t *testing.T) {
+	var throttle *throttle
+	throttle.wait(priorityHigh)
+}
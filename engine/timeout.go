@@ -0,0 +1,100 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// timeoutInterval is the interval at which the pod is checked
+// against the schedule and pull timeouts.
+var timeoutInterval = 5 * time.Second
+
+// helper function periodically checks the most recent pod
+// status against the schedule and pull timeouts until the
+// context is cancelled, and calls the expire function with
+// the error once a timeout expired.
+func watchTimeouts(ctx context.Context, spec *Spec, step *Step, p *pending, expire func(error)) {
+	ticker := time.NewTicker(timeoutInterval)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+		pod := p.get()
+		if pod == nil {
+			continue
+		}
+		if err := checkTimeouts(spec, step, pod, now); err != nil {
+			expire(err)
+			return
+		}
+	}
+}
+
+// helper function returns an error if the pod is not scheduled
+// within the schedule timeout, or if the step container does
+// not start within the pull timeout once the pod is scheduled
+// and initialized.
+func checkTimeouts(spec *Spec, step *Step, pod *v1.Pod, now time.Time) error {
+	scheduled := podCondition(pod, v1.PodScheduled)
+	if scheduled == nil || scheduled.Status != v1.ConditionTrue {
+		timeout := time.Duration(spec.ScheduleTimeout) * time.Second
+		if timeout > 0 && now.Sub(pod.CreationTimestamp.Time) > timeout {
+			return &Error{
+				Code: CodeSchedulingFailed,
+				Err:  fmt.Errorf("engine: pod %s was not scheduled within %s", pod.Name, timeout),
+			}
+		}
+		return nil
+	}
+
+	timeout := time.Duration(spec.PullTimeout) * time.Second
+	if timeout == 0 || step.Node {
+		return nil
+	}
+	// the init containers run before the step containers are
+	// created, so the pull timeout starts once the pod is
+	// initialized.
+	started := scheduled.LastTransitionTime.Time
+	if initialized := podCondition(pod, v1.PodInitialized); initialized != nil {
+		if initialized.Status != v1.ConditionTrue {
+			return nil
+		}
+		if initialized.LastTransitionTime.After(started) {
+			started = initialized.LastTransitionTime.Time
+		}
+	}
+	if now.Sub(started) <= timeout {
+		return nil
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == step.ID && status.State.Waiting != nil {
+			return &Error{
+				Code: CodeImagePullFailed,
+				Err:  fmt.Errorf("engine: step %s: image %s was not pulled within %s", step.Name, step.Image, timeout),
+			}
+		}
+	}
+	return nil
+}
+
+// helper function returns the pod condition of the given type,
+// or nil if the pod does not have the condition.
+func podCondition(pod *v1.Pod, kind v1.PodConditionType) *v1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == kind {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckTimeouts(t *testing.T) {
+	now := time.Now()
+	spec := &Spec{ScheduleTimeout: 600, PullTimeout: 300}
+	step := &Step{ID: "drone-step-1", Name: "build", Image: "golang:1.13"}
+
+	unscheduled := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "drone-pod",
+			CreationTimestamp: metav1.NewTime(now.Add(-11 * time.Minute)),
+		},
+		Status: v1.PodStatus{
+			Conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionFalse},
+			},
+		},
+	}
+	if err := checkTimeouts(spec, step, unscheduled, now); CodeOf(err) != CodeSchedulingFailed {
+		t.Errorf("Want scheduling failure, got %v", err)
+	}
+	if err := checkTimeouts(spec, step, unscheduled, now.Add(-2*time.Minute)); err != nil {
+		t.Errorf("Want no error before the schedule timeout, got %v", err)
+	}
+
+	pulling := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.NewTime(now.Add(-20 * time.Minute)),
+		},
+		Status: v1.PodStatus{
+			Conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: metav1.NewTime(now.Add(-15 * time.Minute))},
+				{Type: v1.PodInitialized, Status: v1.ConditionTrue, LastTransitionTime: metav1.NewTime(now.Add(-6 * time.Minute))},
+			},
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Name:  "drone-step-1",
+					State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+				},
+			},
+		},
+	}
+	if err := checkTimeouts(spec, step, pulling, now); CodeOf(err) != CodeImagePullFailed {
+		t.Errorf("Want image pull failure, got %v", err)
+	}
+	// the pull timeout starts once the pod is initialized.
+	if err := checkTimeouts(spec, step, pulling, now.Add(-2*time.Minute)); err != nil {
+		t.Errorf("Want no error before the pull timeout, got %v", err)
+	}
+	// the timeouts are not enforced if zero.
+	if err := checkTimeouts(new(Spec), step, pulling, now); err != nil {
+		t.Errorf("Want no error without timeouts, got %v", err)
+	}
+}
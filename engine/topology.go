@@ -0,0 +1,92 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// helper function returns an error if none of the schedulable
+// nodes are in the zone and region of the pipeline, in which
+// case the pod would remain pending until the pipeline times
+// out. Nil is returned if the nodes cannot be listed.
+func (k *Kubernetes) checkTopology(ctx context.Context, spec *Spec) error {
+	topology := spec.PodSpec.Topology
+	if len(topology) == 0 {
+		return nil
+	}
+	var nodes *v1.NodeList
+	err := k.call(ctx, func(ctx context.Context) (err error) {
+		nodes, err = k.coreV1(ctx).Nodes().List(metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		logrus.WithError(err).Debugln("cannot list nodes to check the topology")
+		return nil
+	}
+	return matchTopology(topology, nodes.Items)
+}
+
+// helper function returns an error if none of the schedulable
+// nodes match the topology labels, with the label values that
+// are available in the cluster.
+func matchTopology(topology map[string]string, nodes []v1.Node) error {
+	available := map[string]map[string]bool{}
+	var schedulable int
+	for i := range nodes {
+		node := &nodes[i]
+		if node.Spec.Unschedulable {
+			continue
+		}
+		schedulable++
+		matched := true
+		for label, value := range topology {
+			if v, ok := node.Labels[label]; ok {
+				if available[label] == nil {
+					available[label] = map[string]bool{}
+				}
+				available[label][v] = true
+			}
+			if node.Labels[label] != value {
+				matched = false
+			}
+		}
+		if matched {
+			return nil
+		}
+	}
+	if schedulable == 0 {
+		return nil
+	}
+
+	var requested, summary []string
+	for label, value := range topology {
+		requested = append(requested, label+"="+value)
+		var values []string
+		for v := range available[label] {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		if len(values) == 0 {
+			values = []string{"none"}
+		}
+		summary = append(summary, fmt.Sprintf("%s: %s", label, strings.Join(values, ", ")))
+	}
+	sort.Strings(requested)
+	sort.Strings(summary)
+	return &Error{
+		Code: CodeTopologyUnavailable,
+		Err: fmt.Errorf("engine: 0/%d nodes are in the pipeline topology %s. Available %s",
+			schedulable, strings.Join(requested, ","), strings.Join(summary, "; ")),
+	}
+}
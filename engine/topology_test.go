@@ -0,0 +1,63 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testTopologyNode(name, region, zone string) v1.Node {
+	return v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				"topology.kubernetes.io/region": region,
+				"topology.kubernetes.io/zone":   zone,
+			},
+		},
+	}
+}
+
+func TestMatchTopology(t *testing.T) {
+	nodes := []v1.Node{
+		testTopologyNode("node-1", "us-east1", "us-east1-b"),
+		testTopologyNode("node-2", "us-east1", "us-east1-c"),
+	}
+
+	topology := map[string]string{
+		"topology.kubernetes.io/region": "us-east1",
+		"topology.kubernetes.io/zone":   "us-east1-c",
+	}
+	if err := matchTopology(topology, nodes); err != nil {
+		t.Errorf("Want topology available, got %s", err)
+	}
+
+	topology["topology.kubernetes.io/zone"] = "us-east1-d"
+	err := matchTopology(topology, nodes)
+	if got, want := CodeOf(err), CodeTopologyUnavailable; got != want {
+		t.Fatalf("Want error code %s, got %s", want, got)
+	}
+	want := "[TOPOLOGY_UNAVAILABLE] engine: 0/2 nodes are in the pipeline topology " +
+		"topology.kubernetes.io/region=us-east1,topology.kubernetes.io/zone=us-east1-d. " +
+		"Available topology.kubernetes.io/region: us-east1; topology.kubernetes.io/zone: us-east1-b, us-east1-c"
+	if got := err.Error(); got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+
+	// nodes that are cordoned cannot schedule the pod.
+	topology["topology.kubernetes.io/zone"] = "us-east1-c"
+	nodes[1].Spec.Unschedulable = true
+	if err := matchTopology(topology, nodes); err == nil {
+		t.Errorf("Want error if the nodes in the zone are unschedulable")
+	}
+
+	// the topology is not checked if no node can be listed.
+	if err := matchTopology(topology, nil); err != nil {
+		t.Errorf("Want no error without nodes, got %s", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// summary is the subset of the kubelet summary api response
+// used to report step resource usage.
+type summary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Containers []struct {
+			Name string `json:"name"`
+			CPU  struct {
+				UsageNanoCores uint64 `json:"usageNanoCores"`
+			} `json:"cpu"`
+			Memory struct {
+				WorkingSetBytes uint64 `json:"workingSetBytes"`
+			} `json:"memory"`
+		} `json:"containers"`
+	} `json:"pods"`
+}
+
+// helper function periodically writes the step resource usage
+// to the step output until the context is cancelled. Usage is
+// sampled from the kubelet summary api of the pod node.
+func (k *Kubernetes) usage(ctx context.Context, spec *Spec, step *Step, output io.Writer) {
+	interval := time.Duration(spec.UsageInterval) * time.Second
+
+	var node string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if node == "" {
+			var pod *v1.Pod
+			err := k.call(ctx, func(ctx context.Context) (err error) {
+				pod, err = k.coreV1(ctx).Pods(spec.PodSpec.Namespace).Get(spec.PodSpec.Name, metav1.GetOptions{})
+				return err
+			})
+			if err != nil {
+				continue
+			}
+			node = pod.Spec.NodeName
+		}
+		reqCtx, cancel := k.deadline(ctx)
+		raw, err := k.coreV1(reqCtx).RESTClient().Get().
+			Resource("nodes").
+			Name(node).
+			SubResource("proxy").
+			Suffix("stats/summary").
+			DoRaw()
+		cancel()
+		if err != nil {
+			logrus.WithError(err).
+				WithField("node", node).
+				Debugln("cannot get kubelet summary")
+			continue
+		}
+		if line, ok := toUsage(raw, spec, step); ok {
+			io.WriteString(output, line)
+		}
+	}
+}
+
+// helper function returns the resource usage line for the
+// step container, and false if the container is not found in
+// the kubelet summary.
+func toUsage(raw []byte, spec *Spec, step *Step) (string, bool) {
+	out := new(summary)
+	if err := json.Unmarshal(raw, out); err != nil {
+		return "", false
+	}
+	for _, pod := range out.Pods {
+		if pod.PodRef.Name != spec.PodSpec.Name || pod.PodRef.Namespace != spec.PodSpec.Namespace {
+			continue
+		}
+		for _, c := range pod.Containers {
+			if c.Name != step.ID {
+				continue
+			}
+			cpu := float64(c.CPU.UsageNanoCores) / 1e9
+			return fmt.Sprintf("[resource] cpu=%.1f mem=%s\n", cpu, formatBytes(c.Memory.WorkingSetBytes)), true
+		}
+	}
+	return "", false
+}
+
+// helper function formats the number of bytes using binary
+// suffixes, for example 3.1Gi.
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ci", float64(b)/float64(div), "KMGTP"[exp])
+}
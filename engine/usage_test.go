@@ -0,0 +1,51 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestToUsage(t *testing.T) {
+	raw := []byte(`{
+		"pods": [
+			{
+				"podRef": { "name": "drone-abc", "namespace": "default" },
+				"containers": [
+					{ "name": "step-1", "cpu": { "usageNanoCores": 1200000000 }, "memory": { "workingSetBytes": 3328599654 } },
+					{ "name": "step-2", "cpu": { "usageNanoCores": 5000000 }, "memory": { "workingSetBytes": 1048576 } }
+				]
+			}
+		]
+	}`)
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-abc", Namespace: "default"}}
+
+	got, ok := toUsage(raw, spec, &Step{ID: "step-1"})
+	if !ok {
+		t.Errorf("Expect container usage found")
+	}
+	if want := "[resource] cpu=1.2 mem=3.1Gi\n"; got != want {
+		t.Errorf("Want usage line %q, got %q", want, got)
+	}
+
+	if _, ok := toUsage(raw, spec, &Step{ID: "step-3"}); ok {
+		t.Errorf("Expect container usage not found")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes uint64
+		want  string
+	}{
+		{512, "512"},
+		{2048, "2.0Ki"},
+		{1048576, "1.0Mi"},
+		{3328599654, "3.1Gi"},
+	}
+	for _, test := range tests {
+		if got := formatBytes(test.bytes); got != test.want {
+			t.Errorf("Want %s, got %s", test.want, got)
+		}
+	}
+}
@@ -26,6 +26,22 @@ func FilterInvalidChar(content string) string {
 	return content
 }
 
+// ParseUser parses the numeric uid and optional gid from the
+// user string in uid[:gid] format. Non-numeric users cannot be
+// resolved by Kubernetes and are ignored.
+func ParseUser(user string) (uid, gid *int64) {
+	parts := strings.SplitN(user, ":", 2)
+	if v, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+		uid = &v
+	}
+	if len(parts) == 2 {
+		if v, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			gid = &v
+		}
+	}
+	return
+}
+
 // ConvertUnicode utf-8 to unicode
 func ConvertUnicode(content string) string {
 	textQuoted := strconv.QuoteToASCII(content)
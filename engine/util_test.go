@@ -27,3 +27,21 @@ func Test_filterEmoji(t *testing.T) {
 		})
 	}
 }
+
+func TestParseUser(t *testing.T) {
+	uid, gid := ParseUser("1000:2000")
+	if uid == nil || *uid != 1000 {
+		t.Errorf("Want uid 1000")
+	}
+	if gid == nil || *gid != 2000 {
+		t.Errorf("Want gid 2000")
+	}
+	uid, gid = ParseUser("1000")
+	if uid == nil || *uid != 1000 || gid != nil {
+		t.Errorf("Want uid 1000 without gid")
+	}
+	uid, gid = ParseUser("octocat")
+	if uid != nil || gid != nil {
+		t.Errorf("Want non-numeric user ignored")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// defaultWaitTimeout is the default number of seconds a step
+// waits for its conditions to be met.
+const defaultWaitTimeout = 60
+
+// helper function returns the step command prefixed with a
+// loop that checks the wait conditions once per second, and
+// exits with a non-zero code if the conditions are not met
+// before the timeout.
+func toWaitCommand(command string, wait *WaitFor) string {
+	timeout := wait.Timeout
+	if timeout == 0 {
+		timeout = defaultWaitTimeout
+	}
+	return fmt.Sprintf(
+		`(_drone_deadline=$(($(date +%%s)+%d)); until %s; do if [ "$(date +%%s)" -ge "$_drone_deadline" ]; then echo %s >&2; exit 1; fi; sleep 1; done) && %s`,
+		timeout,
+		toWaitCondition(wait),
+		quote(fmt.Sprintf("wait_for: timed out after %ds waiting for %s", timeout, toWaitDescription(wait))),
+		command,
+	)
+}
+
+// helper function returns the shell condition that is true
+// when all of the wait conditions are met.
+func toWaitCondition(wait *WaitFor) string {
+	var conds []string
+	if wait.File != "" {
+		conds = append(conds, "test -e "+quote(wait.File))
+	}
+	if wait.TCP != "" {
+		// nc is used when installed in the image, with the
+		// bash /dev/tcp device as a fallback.
+		host, port, _ := net.SplitHostPort(wait.TCP)
+		conds = append(conds, fmt.Sprintf(
+			"{ nc -z -w 1 %s %s || (exec 3<>/dev/tcp/%s/%s); } >/dev/null 2>&1",
+			quote(host), quote(port), quote(host), quote(port),
+		))
+	}
+	if wait.HTTP != "" {
+		// curl is used when installed in the image, with
+		// wget as a fallback.
+		conds = append(conds, fmt.Sprintf(
+			"{ curl -fs -o /dev/null %s || wget -q -O /dev/null %s; } >/dev/null 2>&1",
+			quote(wait.HTTP), quote(wait.HTTP),
+		))
+	}
+	if wait.Command != "" {
+		conds = append(conds, "sh -c "+quote(wait.Command)+" >/dev/null 2>&1")
+	}
+	return strings.Join(conds, " && ")
+}
+
+// helper function returns a description of the wait
+// conditions for the timeout message.
+func toWaitDescription(wait *WaitFor) string {
+	var desc []string
+	if wait.File != "" {
+		desc = append(desc, "file "+wait.File)
+	}
+	if wait.TCP != "" {
+		desc = append(desc, "tcp "+wait.TCP)
+	}
+	if wait.HTTP != "" {
+		desc = append(desc, "http "+wait.HTTP)
+	}
+	if wait.Command != "" {
+		desc = append(desc, "command")
+	}
+	return strings.Join(desc, ", ")
+}
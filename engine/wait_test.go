@@ -0,0 +1,55 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWaitCommand(t *testing.T) {
+	got := toWaitCommand(`echo "$DRONE_SCRIPT" | sh`, &WaitFor{TCP: "database:5432"})
+	if !strings.Contains(got, "nc -z -w 1 'database' '5432'") {
+		t.Errorf("Expect tcp condition in command, got %s", got)
+	}
+	if !strings.Contains(got, "+60))") {
+		t.Errorf("Expect default timeout in command, got %s", got)
+	}
+	if !strings.HasSuffix(got, `&& echo "$DRONE_SCRIPT" | sh`) {
+		t.Errorf("Expect step command after wait loop, got %s", got)
+	}
+}
+
+func TestWaitCommand_Exec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "ready")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("sh", "-c", toWaitCommand("echo done", &WaitFor{File: file, Command: "true"})).CombinedOutput()
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := string(out), "done\n"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+
+	out, err = exec.Command("sh", "-c", toWaitCommand("echo done", &WaitFor{File: file + ".missing", Timeout: 1})).CombinedOutput()
+	if err == nil {
+		t.Errorf("Expect error when wait condition is not met")
+	}
+	if !strings.Contains(string(out), "wait_for: timed out after 1s waiting for file ") {
+		t.Errorf("Expect timeout message, got %q", out)
+	}
+}
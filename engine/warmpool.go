@@ -0,0 +1,270 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// warmShellImage is the default image that provides the sleep
+// binary of the standby containers, so that images without a
+// shell can be pooled.
+const warmShellImage = "busybox:1.31"
+
+// warmPath is the path where the sleep binary is mounted in the
+// standby containers.
+const warmPath = "/drone/warm"
+
+// WarmPool defines the number of standby pods that keep a
+// plugin image pulled and cached on the cluster nodes.
+type WarmPool struct {
+	Image    string
+	Replicas int
+}
+
+// warmer manages the standby pods of the warm pools.
+type warmer struct {
+	namespace string
+	prefix    string
+	shell     string
+	pools     []*WarmPool
+}
+
+// EnableWarmPools enables the warm pools. The standby pods are
+// created in the namespace, and a pipeline that uses a pooled
+// image claims a standby pod, so that the pipeline pod is
+// preferably scheduled on the node where the image is cached.
+// The claimed standby pod is deleted to release the node
+// resources, and is replaced by Replenish.
+func (k *Kubernetes) EnableWarmPools(namespace, prefix, shell string, pools []*WarmPool) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	if shell == "" {
+		shell = warmShellImage
+	}
+	k.warm = &warmer{
+		namespace: namespace,
+		prefix:    prefix,
+		shell:     shell,
+		pools:     pools,
+	}
+}
+
+// Replenish periodically creates the missing standby pods of
+// the warm pools, and deletes the surplus standby pods, until
+// the context is cancelled.
+func (k *Kubernetes) Replenish(ctx context.Context, interval time.Duration) {
+	if k.warm == nil {
+		return
+	}
+	for {
+		for _, pool := range k.warm.pools {
+			k.replenish(pool)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// helper function creates or deletes the standby pods of the
+// pool until the pool has the desired number of replicas.
+func (k *Kubernetes) replenish(pool *WarmPool) {
+	logger := logrus.WithField("image", pool.Image)
+	pods, err := k.listStandby(pool)
+	if err != nil {
+		logger.WithError(err).
+			Warnln("cannot list standby pods")
+		return
+	}
+	for i := len(pods); i < pool.Replicas; i++ {
+		_, err := k.client.CoreV1().Pods(k.warm.namespace).Create(k.warm.toStandbyPod(pool))
+		if err != nil {
+			logger.WithError(err).
+				Warnln("cannot create standby pod")
+			return
+		}
+	}
+	for i := pool.Replicas; i < len(pods); i++ {
+		err := k.client.CoreV1().Pods(k.warm.namespace).Delete(pods[i].Name, &metav1.DeleteOptions{
+			GracePeriodSeconds: int64ptr(0),
+		})
+		if err != nil && !kerrors.IsNotFound(err) {
+			logger.WithError(err).
+				WithField("pod", pods[i].Name).
+				Warnln("cannot delete standby pod")
+		}
+	}
+}
+
+// helper function returns the standby pods of the pool that
+// are not terminating, ordered by name. Failed standby pods
+// are deleted, so that they are replaced.
+func (k *Kubernetes) listStandby(pool *WarmPool) ([]v1.Pod, error) {
+	list, err := k.client.CoreV1().Pods(k.warm.namespace).List(metav1.ListOptions{
+		LabelSelector: labelWarmPool(k.warm.prefix) + "=" + toPoolID(pool.Image),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var pods []v1.Pod
+	for _, pod := range list.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if pod.Status.Phase == v1.PodFailed || pod.Status.Phase == v1.PodSucceeded {
+			k.client.CoreV1().Pods(k.warm.namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+	return pods, nil
+}
+
+// helper function claims a running standby pod for each pooled
+// image of the pipeline, and prefers the nodes of the claimed
+// pods. A standby pod is claimed by deleting it, so that a pod
+// is claimed by a single pipeline. The pipeline is scheduled
+// normally if no standby pod can be claimed.
+func (k *Kubernetes) claimStandby(spec *Spec) {
+	if k.warm == nil || spec.PodSpec.NodeName != "" {
+		return
+	}
+	var nodes []string
+	for _, pool := range k.warm.pools {
+		if !usesImage(spec, pool.Image) {
+			continue
+		}
+		if node := k.claim(pool); node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return
+	}
+	if spec.PodSpec.Affinity == nil {
+		spec.PodSpec.Affinity = new(Affinity)
+	}
+	for _, node := range nodes {
+		spec.PodSpec.Affinity.Preferred = append(spec.PodSpec.Affinity.Preferred, NodeRequirement{
+			Key:    "kubernetes.io/hostname",
+			Values: []string{node},
+			Weight: 100,
+		})
+	}
+}
+
+// helper function claims a running standby pod of the pool,
+// and returns the node of the pod, or an empty string if no
+// standby pod could be claimed.
+func (k *Kubernetes) claim(pool *WarmPool) string {
+	pods, err := k.listStandby(pool)
+	if err != nil {
+		logrus.WithError(err).
+			WithField("image", pool.Image).
+			Debugln("cannot list standby pods")
+		return ""
+	}
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodRunning || pod.Spec.NodeName == "" {
+			continue
+		}
+		// the pod uid is a precondition of the delete, so
+		// that a standby pod that was already claimed, and
+		// replaced, is not claimed twice.
+		uid := pod.UID
+		err := k.client.CoreV1().Pods(k.warm.namespace).Delete(pod.Name, &metav1.DeleteOptions{
+			GracePeriodSeconds: int64ptr(0),
+			Preconditions:      &metav1.Preconditions{UID: &uid},
+		})
+		if err != nil {
+			continue
+		}
+		return pod.Spec.NodeName
+	}
+	return ""
+}
+
+// helper function returns the standby pod of the pool. The
+// sleep binary is copied from the shell image, so that the
+// standby container runs without executing the image
+// entrypoint.
+func (w *warmer) toStandbyPod(pool *WarmPool) *v1.Pod {
+	mount := v1.VolumeMount{Name: "drone-warm", MountPath: warmPath}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "drone-warm-",
+			Namespace:    w.namespace,
+			Labels: map[string]string{
+				labelWarmPool(w.prefix): toPoolID(pool.Image),
+			},
+			Annotations: map[string]string{
+				w.prefix + ".warm-image": pool.Image,
+			},
+		},
+		Spec: v1.PodSpec{
+			RestartPolicy:                 v1.RestartPolicyAlways,
+			AutomountServiceAccountToken:  boolptr(false),
+			TerminationGracePeriodSeconds: int64ptr(0),
+			Volumes: []v1.Volume{{
+				Name:         mount.Name,
+				VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+			}},
+			InitContainers: []v1.Container{{
+				Name:         "sleep",
+				Image:        w.shell,
+				Command:      []string{"/bin/busybox", "cp", "/bin/busybox", warmPath + "/busybox"},
+				VolumeMounts: []v1.VolumeMount{mount},
+			}},
+			Containers: []v1.Container{{
+				Name:         "standby",
+				Image:        pool.Image,
+				Command:      []string{warmPath + "/busybox", "sleep", "2147483647"},
+				VolumeMounts: []v1.VolumeMount{mount},
+			}},
+		},
+	}
+}
+
+// helper function returns true if a pipeline step uses the
+// image, including the image tag.
+func usesImage(spec *Spec, name string) bool {
+	for _, step := range spec.Steps {
+		if image.MatchTag(step.Image, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the name of the label used to
+// identify the standby pods of a warm pool.
+func labelWarmPool(prefix string) string {
+	return prefix + ".warm-pool"
+}
+
+// helper function returns the pool identifier of the image,
+// which is a valid label value.
+func toPoolID(name string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(image.Expand(name))))
+}
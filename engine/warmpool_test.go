@@ -0,0 +1,38 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestToStandbyPod(t *testing.T) {
+	w := &warmer{namespace: "drone", prefix: "io.drone", shell: "busybox:1.31"}
+	pod := w.toStandbyPod(&WarmPool{Image: "plugins/docker", Replicas: 2})
+	if got, want := pod.Labels["io.drone.warm-pool"], toPoolID("docker.io/plugins/docker:latest"); got != want {
+		t.Errorf("Want pool label %q, got %q", want, got)
+	}
+	if got := len(pod.Labels["io.drone.warm-pool"]); got > 63 {
+		t.Errorf("Want pool label value to be a valid label value")
+	}
+	if got, want := pod.Spec.Containers[0].Image, "plugins/docker"; got != want {
+		t.Errorf("Want standby image %q, got %q", want, got)
+	}
+	if got, want := pod.Spec.Containers[0].Command[0], "/drone/warm/busybox"; got != want {
+		t.Errorf("Want standby container to run the copied sleep binary, got %q", got)
+	}
+}
+
+func TestUsesImage(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{Image: "docker.io/plugins/docker:latest"},
+		},
+	}
+	if !usesImage(spec, "plugins/docker") {
+		t.Errorf("Want expanded image name matched")
+	}
+	if usesImage(spec, "plugins/docker:19") {
+		t.Errorf("Want image tag matched")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/client-go/util/exec"
+)
+
+// watchdog detects exec streams that are not closed after the
+// step script exits. The step script records its process id
+// and exit code in the container, and if the exec stream does
+// not write output for the watchdog interval, the container is
+// probed to check whether the script already exited.
+type watchdog struct {
+	interval time.Duration
+	pidFile  string
+	exitFile string
+
+	mu        sync.Mutex
+	last      time.Time
+	abandoned bool
+}
+
+func newWatchdog(step *Step, interval time.Duration) *watchdog {
+	return &watchdog{
+		interval: interval,
+		pidFile:  "/tmp/drone-" + step.ID + ".pid",
+		exitFile: "/tmp/drone-" + step.ID + ".exit",
+		last:     time.Now(),
+	}
+}
+
+// helper function returns the step command wrapped to record
+// the process id of the shell and the exit code of the step
+// script. The command is executed in a subshell, so that the
+// exit code is recorded if the command exits the shell.
+func (w *watchdog) command(command string) string {
+	return fmt.Sprintf(
+		`rm -f %[1]s; echo $$ >%[2]s 2>/dev/null; (%[3]s); _drone_status=$?; echo $_drone_status >%[1]s 2>/dev/null; exit $_drone_status`,
+		w.exitFile, w.pidFile, command,
+	)
+}
+
+// helper function returns the command that probes the process
+// table of the step container. The command writes running if
+// the script has not exited, the exit code if the script
+// exited, or killed if the script exited without recording
+// the exit code. Processes that exited but were not reaped
+// are considered exited.
+func (w *watchdog) probe() string {
+	return fmt.Sprintf(
+		`if [ ! -f %[1]s ]; then echo running; exit 0; fi; `+
+			`pid=$(cat %[1]s); `+
+			`if [ -d /proc/$pid ] && ! grep -q '^State:[[:space:]]*Z' /proc/$pid/status 2>/dev/null; then echo running; `+
+			`elif [ -f %[2]s ]; then cat %[2]s; `+
+			`else echo killed; fi`,
+		w.pidFile, w.exitFile,
+	)
+}
+
+// helper function returns a writer that records the time of
+// the last output of the exec stream. Output is discarded once
+// the exec stream is abandoned.
+func (w *watchdog) writer(out io.Writer) io.Writer {
+	return &watchdogWriter{w: w, out: out}
+}
+
+// helper function returns the duration since the last output
+// of the exec stream.
+func (w *watchdog) idle() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return time.Since(w.last)
+}
+
+// helper function resets the watchdog before the step script
+// is executed.
+func (w *watchdog) reset() {
+	w.mu.Lock()
+	w.last = time.Now()
+	w.mu.Unlock()
+}
+
+// helper function abandons the exec stream, after which the
+// output of the stream is discarded.
+func (w *watchdog) abandon() {
+	w.mu.Lock()
+	w.abandoned = true
+	w.mu.Unlock()
+}
+
+type watchdogWriter struct {
+	w   *watchdog
+	out io.Writer
+}
+
+func (ww *watchdogWriter) Write(p []byte) (int, error) {
+	ww.w.mu.Lock()
+	defer ww.w.mu.Unlock()
+	if ww.w.abandoned {
+		return len(p), nil
+	}
+	ww.w.last = time.Now()
+	return ww.out.Write(p)
+}
+
+// helper function parses the output of the probe command.
+// Returns the exit code and true if the step script exited.
+func parseProbe(out string) (int, bool) {
+	switch out = strings.TrimSpace(out); out {
+	case "", "running":
+		return 0, false
+	case "killed":
+		return 1, true
+	}
+	code, err := strconv.Atoi(out)
+	if err != nil {
+		return 0, false
+	}
+	return code, true
+}
+
+// helper function returns the exec function wrapped with the
+// watchdog. If the exec stream does not write output for the
+// watchdog interval, and the container process table shows
+// the step script exited, the exec stream is abandoned and the
+// recovered exit code is returned, so that the step completes
+// instead of waiting for the stream forever.
+func (k *Kubernetes) watchExec(ctx context.Context, spec *Spec, step *Step, w *watchdog, output io.Writer, execFunc func(string) error) func(string) error {
+	return func(cmd string) error {
+		w.reset()
+		result := make(chan error, 1)
+		go func() {
+			result <- execFunc(cmd)
+		}()
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case err := <-result:
+				return err
+			case <-ticker.C:
+			}
+			if w.idle() < w.interval {
+				continue
+			}
+
+			// the probe is executed in the background, so that
+			// the watchdog does not block if the probe stream
+			// is stuck as well.
+			probe := make(chan string, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(ctx, w.interval)
+				defer cancel()
+				buf := new(bytes.Buffer)
+				if err := k.exec(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, step.ID, toShellCommand(step, w.probe()), buf, nil); err != nil {
+					logrus.WithError(err).
+						WithField("pod", spec.PodSpec.Name).
+						WithField("container", step.ID).
+						Debugln("cannot probe step processes")
+				}
+				probe <- buf.String()
+			}()
+
+			var out string
+			select {
+			case err := <-result:
+				return err
+			case out = <-probe:
+			case <-time.After(w.interval):
+				continue
+			}
+			code, exited := parseProbe(out)
+			if !exited {
+				continue
+			}
+
+			// the stream may complete while the container is
+			// probed, in which case the stream result is used.
+			select {
+			case err := <-result:
+				return err
+			default:
+			}
+			w.abandon()
+			logrus.WithField("pod", spec.PodSpec.Name).
+				WithField("container", step.ID).
+				WithField("exit", code).
+				Warnln("exec stream not closed after the step exited, abandoning stream")
+			fmt.Fprintf(output, "watchdog: the exec stream was not closed after the step exited with code %d\n", code)
+			if code == 0 {
+				return nil
+			}
+			return exec.CodeExitError{
+				Err:  fmt.Errorf("command terminated with exit code %d", code),
+				Code: code,
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchdogCommand(t *testing.T) {
+	w := newWatchdog(&Step{ID: "abc123"}, time.Minute)
+	got := w.command(`echo "$DRONE_SCRIPT" | sh`)
+	if !strings.HasPrefix(got, "rm -f /tmp/drone-abc123.exit; echo $$ >/tmp/drone-abc123.pid") {
+		t.Errorf("Expect process id recorded before the script, got %s", got)
+	}
+	if !strings.Contains(got, `(echo "$DRONE_SCRIPT" | sh); _drone_status=$?; echo $_drone_status >/tmp/drone-abc123.exit`) {
+		t.Errorf("Expect exit code recorded after the script, got %s", got)
+	}
+	if !strings.HasSuffix(got, "exit $_drone_status") {
+		t.Errorf("Expect exit code preserved, got %s", got)
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	tests := []struct {
+		out    string
+		code   int
+		exited bool
+	}{
+		{"running\n", 0, false},
+		{"", 0, false},
+		{"0\n", 0, true},
+		{"2\n", 2, true},
+		{"killed\n", 1, true},
+		{"cat: can't open\n", 0, false},
+	}
+	for _, test := range tests {
+		code, exited := parseProbe(test.out)
+		if code != test.code || exited != test.exited {
+			t.Errorf("Want probe %q to return %d %v, got %d %v", test.out, test.code, test.exited, code, exited)
+		}
+	}
+}
+
+func TestWatchdogWriter(t *testing.T) {
+	w := newWatchdog(&Step{ID: "abc123"}, time.Minute)
+	w.last = time.Now().Add(-time.Hour)
+
+	buf := new(bytes.Buffer)
+	out := w.writer(buf)
+	out.Write([]byte("compiling\n"))
+	if w.idle() > time.Minute {
+		t.Errorf("Expect output to reset the idle time")
+	}
+
+	w.abandon()
+	n, err := out.Write([]byte("linking\n"))
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := n, 8; got != want {
+		t.Errorf("Want %d bytes written, got %d", want, got)
+	}
+	if got, want := buf.String(), "compiling\n"; got != want {
+		t.Errorf("Want output discarded once abandoned, got %q", got)
+	}
+}
@@ -0,0 +1,12 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+//go:build boringcrypto
+// +build boringcrypto
+
+package main
+
+// restricts tls to fips-approved versions, cipher suites
+// and curves when compiled with the boringcrypto toolchain.
+import _ "crypto/tls/fipsonly"
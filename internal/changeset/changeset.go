@@ -0,0 +1,167 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package changeset provides the files changed by a build,
+// used to skip pipeline steps with path conditions when no
+// relevant files changed.
+package changeset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// maximum number of files returned by the compare api. If
+// the limit is reached, the changed files are unknown.
+const maxFiles = 300
+
+type (
+	// Request provides arguments for requesting the files
+	// changed by the build.
+	Request struct {
+		Repo  *drone.Repo
+		Build *drone.Build
+		Netrc *drone.Netrc
+	}
+
+	// Provider returns the files changed by the build. If the
+	// changed files are unknown, a nil slice is returned.
+	Provider interface {
+		List(context.Context, *Request) ([]string, error)
+	}
+)
+
+// GitHub returns a provider that requests the files changed
+// by the build from the GitHub compare api, authenticated with
+// the netrc credentials of the build. If the server address is
+// empty, the compare api is used for repositories hosted on
+// github.com only.
+func GitHub(server string) Provider {
+	return &github{
+		server: strings.TrimSuffix(server, "/"),
+		client: http.DefaultClient,
+	}
+}
+
+type github struct {
+	server string
+	client *http.Client
+}
+
+func (g *github) List(ctx context.Context, in *Request) ([]string, error) {
+	server := g.server
+	if server == "" {
+		if !strings.HasPrefix(in.Repo.Link, "https://github.com/") {
+			return nil, nil
+		}
+		server = "https://api.github.com"
+	}
+	// the changed files are unknown for new branches, and
+	// for builds that are not triggered by a commit.
+	if in.Build.Before == "" || strings.Trim(in.Build.Before, "0") == "" || in.Build.After == "" {
+		return nil, nil
+	}
+	endpoint := fmt.Sprintf("%s/repos/%s/%s/compare/%s...%s",
+		server,
+		url.PathEscape(in.Repo.Namespace),
+		url.PathEscape(in.Repo.Name),
+		url.PathEscape(in.Build.Before),
+		url.PathEscape(in.Build.After),
+	)
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if in.Netrc != nil && in.Netrc.Login != "" {
+		req.SetBasicAuth(in.Netrc.Login, in.Netrc.Password)
+	}
+
+	res, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("changeset: compare api returned status %d", res.StatusCode)
+	}
+	out := struct {
+		Files []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+		} `json:"files"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Files) >= maxFiles {
+		return nil, nil
+	}
+	files := []string{}
+	for _, file := range out.Files {
+		files = append(files, file.Filename)
+		if file.PreviousFilename != "" {
+			files = append(files, file.PreviousFilename)
+		}
+	}
+	return files, nil
+}
+
+// Match returns true if any of the files matches the include
+// patterns and none of the exclude patterns. If there are no
+// include patterns, all files are included. The ** pattern
+// matches any number of directories.
+func Match(files, include, exclude []string) bool {
+	for _, file := range files {
+		if matchAny(exclude, file) {
+			continue
+		}
+		if len(include) == 0 || matchAny(include, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the file matches any of the
+// glob patterns.
+func matchAny(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		if match(strings.Split(pattern, "/"), strings.Split(file, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function matches the path segments of the file with
+// the path segments of the glob pattern.
+func match(pattern, file []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(file); i++ {
+				if match(pattern[1:], file[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(file) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], file[0]); !ok {
+			return false
+		}
+		pattern, file = pattern[1:], file[1:]
+	}
+	return len(file) == 0
+}
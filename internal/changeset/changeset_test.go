@@ -0,0 +1,87 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package changeset
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		files   []string
+		include []string
+		exclude []string
+		match   bool
+	}{
+		{[]string{"README.md"}, []string{"*.md"}, nil, true},
+		{[]string{"docs/index.md"}, []string{"*.md"}, nil, false},
+		{[]string{"docs/index.md"}, []string{"docs/**"}, nil, true},
+		{[]string{"docs/api/index.md"}, []string{"docs/**/*.md"}, nil, true},
+		{[]string{"docs/index.md"}, []string{"docs/**/*.md"}, nil, true},
+		{[]string{"src/main.go"}, []string{"docs/**"}, nil, false},
+		{[]string{"src/main.go"}, nil, []string{"docs/**"}, true},
+		{[]string{"docs/index.md"}, nil, []string{"docs/**"}, false},
+		{[]string{"docs/index.md", "main.go"}, nil, []string{"docs/**"}, true},
+		{[]string{}, nil, nil, false},
+	}
+	for i, test := range tests {
+		if got, want := Match(test.files, test.include, test.exclude), test.match; got != want {
+			t.Errorf("Want match %v at index %d, got %v", want, i, got)
+		}
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/octocat/hello-world/compare/6d...7e" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if user, pass, _ := r.BasicAuth(); user != "octocat" || pass != "correct-horse-battery-staple" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"files":[{"filename":"docs/index.md"},{"filename":"main.go","previous_filename":"app.go"}]}`))
+	}))
+	defer ts.Close()
+
+	req := &Request{
+		Repo:  &drone.Repo{Namespace: "octocat", Name: "hello-world"},
+		Build: &drone.Build{Before: "6d", After: "7e"},
+		Netrc: &drone.Netrc{Login: "octocat", Password: "correct-horse-battery-staple"},
+	}
+	files, err := GitHub(ts.URL).List(context.Background(), req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if want := []string{"docs/index.md", "main.go", "app.go"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("Want files %v, got %v", want, files)
+	}
+
+	// the changed files are unknown for new branches.
+	req.Build.Before = "0000000000000000000000000000000000000000"
+	files, err = GitHub(ts.URL).List(context.Background(), req)
+	if err != nil || files != nil {
+		t.Errorf("Want unknown changed files for new branches")
+	}
+}
+
+func TestGitHub_Unsupported(t *testing.T) {
+	req := &Request{
+		Repo:  &drone.Repo{Link: "https://gitlab.com/octocat/hello-world"},
+		Build: &drone.Build{Before: "6d", After: "7e"},
+	}
+	files, err := GitHub("").List(context.Background(), req)
+	if err != nil || files != nil {
+		t.Errorf("Want unknown changed files for repositories not hosted on github.com")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cloudauth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/registry"
+)
+
+// acrUsername is the username of the acr refresh token.
+const acrUsername = "00000000-0000-0000-0000-000000000000"
+
+// acrResource is the resource of the azure active directory
+// token that is exchanged for the acr refresh token.
+const acrResource = "https://management.azure.com/"
+
+// ACR returns a registry provider that mints azure container
+// registry credentials for the hosts, for example
+// example.azurecr.io, using the identity of the runner. The
+// azure active directory token is requested with the azure
+// workload identity token if configured, and from the managed
+// identity of the node otherwise, and is exchanged for an acr
+// refresh token. The client id selects the managed identity if
+// the node has multiple identities.
+func ACR(hosts []string, clientID string) registry.Provider {
+	a := &acr{
+		hosts:    hosts,
+		clientID: clientID,
+		scheme:   "https",
+		imds:     "http://169.254.169.254",
+		getenv:   os.Getenv,
+	}
+	return newCache("acr", a.refresh)
+}
+
+type acr struct {
+	hosts    []string
+	clientID string
+	scheme   string
+	imds     string
+	getenv   func(string) string
+}
+
+func (a *acr) refresh(ctx context.Context) ([]*drone.Registry, time.Time, error) {
+	token, expires, err := a.token(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var registries []*drone.Registry
+	for _, host := range a.hosts {
+		params := url.Values{
+			"grant_type":   {"access_token"},
+			"service":      {host},
+			"access_token": {token},
+		}
+		if tenant := a.getenv("AZURE_TENANT_ID"); tenant != "" {
+			params.Set("tenant", tenant)
+		}
+		req, err := http.NewRequest("POST", a.scheme+"://"+host+"/oauth2/exchange", strings.NewReader(params.Encode()))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		out := struct {
+			RefreshToken string `json:"refresh_token"`
+		}{}
+		if err := doJSON(ctx, req, &out); err != nil {
+			return nil, time.Time{}, fmt.Errorf("acr: cannot exchange token for %s: %s", host, err)
+		}
+		registries = append(registries, &drone.Registry{
+			Address:  host,
+			Username: acrUsername,
+			Password: out.RefreshToken,
+		})
+	}
+	// the refresh token is valid for longer than the azure
+	// active directory token it is exchanged for.
+	return registries, expires, nil
+}
+
+// helper function returns the azure active directory token of
+// the runner identity, and the time the token expires.
+func (a *acr) token(ctx context.Context) (string, time.Time, error) {
+	var req *http.Request
+	if path := a.getenv("AZURE_FEDERATED_TOKEN_FILE"); path != "" {
+		assertion, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("acr: cannot read federated token: %s", err)
+		}
+		clientID := a.clientID
+		if clientID == "" {
+			clientID = a.getenv("AZURE_CLIENT_ID")
+		}
+		authority := a.getenv("AZURE_AUTHORITY_HOST")
+		if authority == "" {
+			authority = "https://login.microsoftonline.com/"
+		}
+		params := url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_id":             {clientID},
+			"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+			"client_assertion":      {strings.TrimSpace(string(assertion))},
+			"scope":                 {acrResource + ".default"},
+		}
+		endpoint := strings.TrimSuffix(authority, "/") + "/" + a.getenv("AZURE_TENANT_ID") + "/oauth2/v2.0/token"
+		req, err = http.NewRequest("POST", endpoint, strings.NewReader(params.Encode()))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		params := url.Values{
+			"api-version": {"2018-02-01"},
+			"resource":    {acrResource},
+		}
+		if a.clientID != "" {
+			params.Set("client_id", a.clientID)
+		}
+		req, _ = http.NewRequest("GET", a.imds+"/metadata/identity/oauth2/token?"+params.Encode(), nil)
+		req.Header.Set("Metadata", "true")
+	}
+	out := struct {
+		AccessToken string  `json:"access_token"`
+		ExpiresIn   seconds `json:"expires_in"`
+	}{}
+	if err := doJSON(ctx, req, &out); err != nil {
+		return "", time.Time{}, fmt.Errorf("acr: cannot get access token: %s", err)
+	}
+	return out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn) * time.Second), nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cloudauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestACR(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/identity/oauth2/token":
+			if got, want := r.Header.Get("Metadata"), "true"; got != want {
+				t.Errorf("Want metadata header")
+			}
+			if got, want := r.URL.Query().Get("client_id"), "client"; got != want {
+				t.Errorf("Want client id %q, got %q", want, got)
+			}
+			w.Write([]byte(`{"access_token":"aad-token","expires_in":"3599"}`))
+		case "/oauth2/exchange":
+			r.ParseForm()
+			if got, want := r.Form.Get("access_token"), "aad-token"; got != want {
+				t.Errorf("Want access token %q, got %q", want, got)
+			}
+			if got, want := r.Form.Get("service"), r.Host; got != want {
+				t.Errorf("Want service %q, got %q", want, got)
+			}
+			w.Write([]byte(`{"refresh_token":"acr-token"}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	a := &acr{
+		hosts:    []string{host},
+		clientID: "client",
+		scheme:   "http",
+		imds:     ts.URL,
+		getenv:   func(string) string { return "" },
+	}
+	creds, _, err := a.refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 1 {
+		t.Fatalf("Want registry credentials")
+	}
+	if creds[0].Address != host || creds[0].Username != acrUsername || creds[0].Password != "acr-token" {
+		t.Errorf("Want acr refresh token credentials, got %+v", creds[0])
+	}
+}
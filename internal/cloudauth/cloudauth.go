@@ -0,0 +1,120 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package cloudauth provides short-lived registry credentials
+// minted from the cloud provider identity of the runner, for
+// example an IAM role or a workload identity. The credentials
+// are cached, and refreshed before the credentials expire.
+package cloudauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/registry"
+	"github.com/sirupsen/logrus"
+)
+
+// credentials are refreshed when they expire within the
+// refresh margin, so that the pull secret of a build remains
+// valid while the pipeline images are pulled.
+var refreshMargin = 30 * time.Minute
+
+// timeout of a single request to the cloud provider.
+var timeout = 30 * time.Second
+
+// refreshFunc mints the registry credentials, and returns the
+// time the credentials expire.
+type refreshFunc func(ctx context.Context) ([]*drone.Registry, time.Time, error)
+
+// cache caches the registry credentials until the credentials
+// are about to expire.
+type cache struct {
+	name    string
+	refresh refreshFunc
+
+	mu      sync.Mutex
+	creds   []*drone.Registry
+	expires time.Time
+}
+
+func newCache(name string, refresh refreshFunc) registry.Provider {
+	return &cache{name: name, refresh: refresh}
+}
+
+// List returns the cached registry credentials. The error is
+// logged instead of returned if the credentials cannot be
+// refreshed, so that the credentials of other providers are
+// not discarded.
+func (c *cache) List(ctx context.Context, _ *registry.Request) ([]*drone.Registry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Add(refreshMargin).Before(c.expires) {
+		return c.creds, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	creds, expires, err := c.refresh(ctx)
+	if err != nil {
+		logrus.WithError(err).
+			WithField("provider", c.name).
+			Warnln("cannot refresh registry credentials")
+		// the cached credentials are used until they expire.
+		if now.Before(c.expires) {
+			return c.creds, nil
+		}
+		return nil, nil
+	}
+	logrus.WithField("provider", c.name).
+		WithField("expires", expires).
+		Debugln("refreshed registry credentials")
+	c.creds = creds
+	c.expires = expires
+	return creds, nil
+}
+
+// helper function sends the request and decodes the json
+// response body into out.
+func doJSON(ctx context.Context, req *http.Request, out interface{}) error {
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s %s returned status %d: %s", req.Method, req.URL.Host, res.StatusCode, body)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// seconds decodes a duration in seconds that is encoded as a
+// json number or string, for example the expiry of an oauth2
+// token returned by the azure instance metadata service.
+type seconds int64
+
+func (s *seconds) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(string(trimQuotes(data)), 10, 64)
+	if err != nil {
+		return err
+	}
+	*s = seconds(v)
+	return nil
+}
+
+func trimQuotes(data []byte) []byte {
+	if len(data) >= 2 && data[0] == '"' && data[len(data)-1] == '"' {
+		return data[1 : len(data)-1]
+	}
+	return data
+}
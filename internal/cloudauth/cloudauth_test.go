@@ -0,0 +1,71 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cloudauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func TestCache(t *testing.T) {
+	var calls int
+	var fail bool
+	expires := time.Now().Add(time.Hour)
+	c := newCache("test", func(context.Context) ([]*drone.Registry, time.Time, error) {
+		calls++
+		if fail {
+			return nil, time.Time{}, errors.New("unauthorized")
+		}
+		return []*drone.Registry{{Address: "registry.example.com"}}, expires, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		creds, _ := c.List(context.Background(), nil)
+		if len(creds) != 1 {
+			t.Errorf("Want registry credentials")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Want credentials cached, got %d refreshes", calls)
+	}
+
+	// the credentials are refreshed before they expire, and
+	// the cached credentials are used if the refresh fails.
+	expires = time.Now().Add(time.Minute)
+	c.(*cache).expires = expires
+	fail = true
+	creds, err := c.List(context.Background(), nil)
+	if err != nil || len(creds) != 1 {
+		t.Errorf("Want cached credentials if the refresh fails")
+	}
+	if calls != 2 {
+		t.Errorf("Want credentials refreshed before they expire")
+	}
+
+	c.(*cache).expires = time.Now().Add(-time.Minute)
+	creds, err = c.List(context.Background(), nil)
+	if err != nil || len(creds) != 0 {
+		t.Errorf("Want no credentials if the credentials expired")
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	for _, data := range []string{`{"expires_in":3599}`, `{"expires_in":"3599"}`} {
+		out := struct {
+			ExpiresIn seconds `json:"expires_in"`
+		}{}
+		if err := json.Unmarshal([]byte(data), &out); err != nil {
+			t.Error(err)
+		}
+		if out.ExpiresIn != 3599 {
+			t.Errorf("Want 3599 seconds, got %d", out.ExpiresIn)
+		}
+	}
+}
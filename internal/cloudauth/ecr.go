@@ -0,0 +1,231 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cloudauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/registry"
+)
+
+// ECR returns a registry provider that mints amazon ecr
+// credentials for the registries in the region, using the
+// iam role of the runner. The role credentials are loaded
+// from the environment, from a web identity token, for example
+// iam roles for service accounts, or from the instance
+// metadata service. The credentials of the runner account
+// registry are minted if no registry ids are provided.
+func ECR(region string, registryIDs []string) registry.Provider {
+	e := &ecr{
+		region:      region,
+		registryIDs: registryIDs,
+		endpoint:    "https://api.ecr." + region + ".amazonaws.com/",
+		sts:         "https://sts." + region + ".amazonaws.com/",
+		imds:        "http://169.254.169.254",
+		getenv:      os.Getenv,
+	}
+	return newCache("ecr", e.refresh)
+}
+
+type ecr struct {
+	region      string
+	registryIDs []string
+	endpoint    string
+	sts         string
+	imds        string
+	getenv      func(string) string
+}
+
+func (e *ecr) refresh(ctx context.Context) ([]*drone.Registry, time.Time, error) {
+	creds, err := e.credentials(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return e.authorize(ctx, creds)
+}
+
+// helper function returns the registry credentials decoded
+// from the authorization tokens minted with the aws
+// credentials.
+func (e *ecr) authorize(ctx context.Context, creds *awsCredentials) ([]*drone.Registry, time.Time, error) {
+	in := map[string]interface{}{}
+	if len(e.registryIDs) != 0 {
+		in["registryIds"] = e.registryIDs
+	}
+	body, _ := json.Marshal(in)
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken")
+	signV4(req, body, creds, e.region, "ecr", time.Now())
+
+	out := struct {
+		AuthorizationData []struct {
+			AuthorizationToken string  `json:"authorizationToken"`
+			ExpiresAt          float64 `json:"expiresAt"`
+			ProxyEndpoint      string  `json:"proxyEndpoint"`
+		} `json:"authorizationData"`
+	}{}
+	if err := doJSON(ctx, req, &out); err != nil {
+		return nil, time.Time{}, fmt.Errorf("ecr: cannot get authorization token: %s", err)
+	}
+
+	var registries []*drone.Registry
+	var expires time.Time
+	for _, data := range out.AuthorizationData {
+		decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("ecr: cannot decode authorization token: %s", err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return nil, time.Time{}, errors.New("ecr: malformed authorization token")
+		}
+		registries = append(registries, &drone.Registry{
+			Address:  strings.TrimPrefix(data.ProxyEndpoint, "https://"),
+			Username: parts[0],
+			Password: parts[1],
+		})
+		t := time.Unix(int64(data.ExpiresAt), 0)
+		if expires.IsZero() || t.Before(expires) {
+			expires = t
+		}
+	}
+	return registries, expires, nil
+}
+
+// helper function returns the aws credentials of the runner.
+func (e *ecr) credentials(ctx context.Context) (*awsCredentials, error) {
+	if id := e.getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: e.getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    e.getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	if path := e.getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); path != "" {
+		return e.assumeRoleWithWebIdentity(ctx, path)
+	}
+	return e.instanceCredentials(ctx)
+}
+
+// helper function exchanges the web identity token for the
+// credentials of the role. The request is not signed.
+func (e *ecr) assumeRoleWithWebIdentity(ctx context.Context, path string) (*awsCredentials, error) {
+	token, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ecr: cannot read web identity token: %s", err)
+	}
+	session := e.getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = "drone-runner-kube"
+	}
+	params := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {e.getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequest("POST", e.sts, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("ecr: cannot assume role with web identity: status %d", res.StatusCode)
+	}
+	out := struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}{}
+	if err := xml.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &awsCredentials{
+		AccessKeyID:     out.Credentials.AccessKeyID,
+		SecretAccessKey: out.Credentials.SecretAccessKey,
+		SessionToken:    out.Credentials.SessionToken,
+		Expires:         out.Credentials.Expiration,
+	}, nil
+}
+
+// helper function returns the credentials of the instance
+// role from the instance metadata service, using a session
+// token as required by imdsv2.
+func (e *ecr) instanceCredentials(ctx context.Context) (*awsCredentials, error) {
+	req, err := http.NewRequest("PUT", e.imds+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := readString(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("ecr: cannot get instance metadata token: %s", err)
+	}
+
+	req, _ = http.NewRequest("GET", e.imds+"/latest/meta-data/iam/security-credentials/", nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := readString(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("ecr: cannot get instance role: %s", err)
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+
+	req, _ = http.NewRequest("GET", e.imds+"/latest/meta-data/iam/security-credentials/"+role, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	out := struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}{}
+	if err := doJSON(ctx, req, &out); err != nil {
+		return nil, fmt.Errorf("ecr: cannot get instance role credentials: %s", err)
+	}
+	return &awsCredentials{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.Token,
+		Expires:         out.Expiration,
+	}, nil
+}
+
+func readString(ctx context.Context, req *http.Request) (string, error) {
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return "", fmt.Errorf("status %d", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	return string(body), err
+}
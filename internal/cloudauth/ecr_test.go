@@ -0,0 +1,150 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cloudauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestECR(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKID/") {
+			t.Errorf("Want signed request, got %q", r.Header.Get("Authorization"))
+		}
+		if got, want := r.Header.Get("X-Amz-Security-Token"), "session"; got != want {
+			t.Errorf("Want session token %q, got %q", want, got)
+		}
+		in := map[string][]string{}
+		json.NewDecoder(r.Body).Decode(&in)
+		if got := in["registryIds"]; len(got) != 1 || got[0] != "123456789012" {
+			t.Errorf("Want registry ids, got %v", got)
+		}
+		w.Write([]byte(`{"authorizationData":[{
+			"authorizationToken":"` + base64.StdEncoding.EncodeToString([]byte("AWS:password")) + `",
+			"expiresAt":1.5E9,
+			"proxyEndpoint":"https://123456789012.dkr.ecr.us-east-1.amazonaws.com"}]}`))
+	}))
+	defer ts.Close()
+
+	e := &ecr{
+		region:      "us-east-1",
+		registryIDs: []string{"123456789012"},
+		endpoint:    ts.URL,
+		getenv: func(key string) string {
+			return map[string]string{
+				"AWS_ACCESS_KEY_ID":     "AKID",
+				"AWS_SECRET_ACCESS_KEY": "secret",
+				"AWS_SESSION_TOKEN":     "session",
+			}[key]
+		},
+	}
+	creds, expires, err := e.refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 1 {
+		t.Fatalf("Want registry credentials")
+	}
+	if got, want := creds[0].Address, "123456789012.dkr.ecr.us-east-1.amazonaws.com"; got != want {
+		t.Errorf("Want address %q, got %q", want, got)
+	}
+	if creds[0].Username != "AWS" || creds[0].Password != "password" {
+		t.Errorf("Want decoded authorization token")
+	}
+	if got, want := expires.Unix(), int64(1500000000); got != want {
+		t.Errorf("Want expiry %d, got %d", want, got)
+	}
+}
+
+func TestECR_WebIdentity(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if got, want := r.Form.Get("WebIdentityToken"), "jwt"; got != want {
+			t.Errorf("Want web identity token %q, got %q", want, got)
+		}
+		if got, want := r.Form.Get("RoleArn"), "arn:aws:iam::123456789012:role/drone"; got != want {
+			t.Errorf("Want role %q, got %q", want, got)
+		}
+		w.Write([]byte(`<AssumeRoleWithWebIdentityResponse>
+			<AssumeRoleWithWebIdentityResult>
+				<Credentials>
+					<AccessKeyId>AKID</AccessKeyId>
+					<SecretAccessKey>secret</SecretAccessKey>
+					<SessionToken>session</SessionToken>
+					<Expiration>2019-11-09T13:34:41Z</Expiration>
+				</Credentials>
+			</AssumeRoleWithWebIdentityResult>
+		</AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer ts.Close()
+
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+	ioutil.WriteFile(path, []byte("jwt\n"), 0600)
+
+	e := &ecr{
+		sts: ts.URL,
+		getenv: func(key string) string {
+			return map[string]string{
+				"AWS_WEB_IDENTITY_TOKEN_FILE": path,
+				"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/drone",
+			}[key]
+		},
+	}
+	creds, err := e.credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKID" || creds.SessionToken != "session" {
+		t.Errorf("Want role credentials")
+	}
+}
+
+func TestECR_Instance(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			if r.Method != "PUT" {
+				t.Errorf("Want session token requested with PUT")
+			}
+			w.Write([]byte("imds-token"))
+			return
+		}
+		if got, want := r.Header.Get("X-aws-ec2-metadata-token"), "imds-token"; got != want {
+			t.Errorf("Want metadata token %q, got %q", want, got)
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("drone-node\n"))
+		case "/latest/meta-data/iam/security-credentials/drone-node":
+			w.Write([]byte(`{"AccessKeyId":"AKID","SecretAccessKey":"secret","Token":"session","Expiration":"2019-11-09T13:34:41Z"}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	e := &ecr{
+		imds:   ts.URL,
+		getenv: func(string) string { return "" },
+	}
+	creds, err := e.credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKID" || creds.SessionToken != "session" {
+		t.Errorf("Want instance role credentials")
+	}
+}
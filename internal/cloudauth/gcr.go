@@ -0,0 +1,64 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cloudauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/registry"
+)
+
+// GCR returns a registry provider that mints google container
+// registry and artifact registry credentials for the hosts,
+// for example gcr.io or us-docker.pkg.dev, using the access
+// token of the service account of the runner. The token is
+// requested from the metadata server, which provides the token
+// of the workload identity on gke.
+func GCR(hosts []string) registry.Provider {
+	metadata := "http://metadata.google.internal"
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		metadata = "http://" + host
+	}
+	g := &gcr{
+		hosts:    hosts,
+		metadata: metadata,
+	}
+	return newCache("gcr", g.refresh)
+}
+
+type gcr struct {
+	hosts    []string
+	metadata string
+}
+
+func (g *gcr) refresh(ctx context.Context) ([]*drone.Registry, time.Time, error) {
+	req, err := http.NewRequest("GET", g.metadata+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	out := struct {
+		AccessToken string  `json:"access_token"`
+		ExpiresIn   seconds `json:"expires_in"`
+	}{}
+	if err := doJSON(ctx, req, &out); err != nil {
+		return nil, time.Time{}, fmt.Errorf("gcr: cannot get access token: %s", err)
+	}
+	var registries []*drone.Registry
+	for _, host := range g.hosts {
+		registries = append(registries, &drone.Registry{
+			Address:  host,
+			Username: "oauth2accesstoken",
+			Password: out.AccessToken,
+		})
+	}
+	expires := time.Now().Add(time.Duration(out.ExpiresIn) * time.Second)
+	return registries, expires, nil
+}
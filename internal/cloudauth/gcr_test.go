@@ -0,0 +1,41 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cloudauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGCR(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Metadata-Flavor"), "Google"; got != want {
+			t.Errorf("Want metadata flavor header")
+		}
+		w.Write([]byte(`{"access_token":"ya29.token","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer ts.Close()
+
+	g := &gcr{
+		hosts:    []string{"gcr.io", "us-docker.pkg.dev"},
+		metadata: ts.URL,
+	}
+	creds, expires, err := g.refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 2 {
+		t.Fatalf("Want credentials for each host")
+	}
+	if creds[1].Address != "us-docker.pkg.dev" || creds[1].Username != "oauth2accesstoken" || creds[1].Password != "ya29.token" {
+		t.Errorf("Want access token credentials, got %+v", creds[1])
+	}
+	if expires.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("Want expiry from the token lifetime")
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cloudauth
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+// duration of the role session used to mint the scoped
+// credentials, which is the minimum session duration.
+const sessionDuration = 900
+
+// characters that are not valid in a role session name.
+var sessionName = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// ECRRobots returns a robot provider that mints amazon ecr
+// credentials scoped to the repositories of the image
+// namespace. The runner assumes the role with a session policy
+// that limits the role permissions to the namespace
+// repositories, and the authorization token inherits the
+// session permissions. Ecr credentials cannot be revoked, and
+// expire with the authorization token.
+func ECRRobots(region, roleARN string) engine.RobotProvider {
+	return &ecrRobots{
+		ecr: &ecr{
+			region:   region,
+			endpoint: "https://api.ecr." + region + ".amazonaws.com/",
+			sts:      "https://sts." + region + ".amazonaws.com/",
+			imds:     "http://169.254.169.254",
+			getenv:   os.Getenv,
+		},
+		role: roleARN,
+	}
+}
+
+type ecrRobots struct {
+	*ecr
+	role string
+}
+
+// Mint returns ecr credentials with push and pull access to
+// the repositories of the namespace.
+func (e *ecrRobots) Mint(ctx context.Context, namespace, name string) (*engine.RobotAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	creds, err := e.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scoped, err := e.assumeRole(ctx, creds, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	registries, _, err := e.authorize(ctx, scoped)
+	if err != nil {
+		return nil, err
+	}
+	if len(registries) == 0 {
+		return nil, errors.New("ecr: no authorization token")
+	}
+	return &engine.RobotAccount{
+		Registry: registries[0].Address,
+		Username: registries[0].Username,
+		Password: registries[0].Password,
+	}, nil
+}
+
+// Revoke is a no-op, since ecr credentials cannot be revoked.
+func (e *ecrRobots) Revoke(ctx context.Context, namespace, id string) error {
+	return nil
+}
+
+// helper function assumes the role with a session policy that
+// limits the role permissions to the repositories of the
+// namespace.
+func (e *ecrRobots) assumeRole(ctx context.Context, creds *awsCredentials, namespace, name string) (*awsCredentials, error) {
+	policy, _ := json.Marshal(toSessionPolicy(e.region, namespace))
+	session := sessionName.ReplaceAllString(name, "-")
+	if len(session) > 64 {
+		session = session[:64]
+	}
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {e.role},
+		"RoleSessionName": {session},
+		"Policy":          {string(policy)},
+		"DurationSeconds": {fmt.Sprint(sessionDuration)},
+	}
+	body := []byte(params.Encode())
+	req, err := http.NewRequest("POST", e.sts, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signV4(req, body, creds, e.region, "sts", time.Now())
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("ecr: cannot assume role: status %d", res.StatusCode)
+	}
+	out := struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleResult>Credentials"`
+	}{}
+	if err := xml.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &awsCredentials{
+		AccessKeyID:     out.Credentials.AccessKeyID,
+		SecretAccessKey: out.Credentials.SecretAccessKey,
+		SessionToken:    out.Credentials.SessionToken,
+		Expires:         out.Credentials.Expiration,
+	}, nil
+}
+
+// helper function returns the session policy that grants push
+// and pull access to the repositories of the namespace.
+func toSessionPolicy(region, namespace string) interface{} {
+	type statement struct {
+		Effect   string   `json:"Effect"`
+		Action   []string `json:"Action"`
+		Resource string   `json:"Resource"`
+	}
+	return struct {
+		Version   string       `json:"Version"`
+		Statement []*statement `json:"Statement"`
+	}{
+		Version: "2012-10-17",
+		Statement: []*statement{
+			{
+				Effect:   "Allow",
+				Action:   []string{"ecr:GetAuthorizationToken"},
+				Resource: "*",
+			},
+			{
+				Effect: "Allow",
+				Action: []string{
+					"ecr:BatchCheckLayerAvailability",
+					"ecr:BatchGetImage",
+					"ecr:GetDownloadUrlForLayer",
+					"ecr:InitiateLayerUpload",
+					"ecr:UploadLayerPart",
+					"ecr:CompleteLayerUpload",
+					"ecr:PutImage",
+				},
+				Resource: fmt.Sprintf("arn:aws:ecr:%s:*:repository/%s/*", region, namespace),
+			},
+		},
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cloudauth
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestECRRobots(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "" {
+			r.ParseForm()
+			if got, want := r.Form.Get("RoleArn"), "arn:aws:iam::123456789012:role/publish"; got != want {
+				t.Errorf("Want role %q, got %q", want, got)
+			}
+			if got := r.Form.Get("Policy"); !strings.Contains(got, "repository/octocat/*") {
+				t.Errorf("Want session policy scoped to the namespace, got %s", got)
+			}
+			if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKID/") {
+				t.Errorf("Want assume role request signed with the runner credentials")
+			}
+			w.Write([]byte(`<AssumeRoleResponse><AssumeRoleResult><Credentials>
+				<AccessKeyId>SCOPED</AccessKeyId>
+				<SecretAccessKey>secret</SecretAccessKey>
+				<SessionToken>session</SessionToken>
+				<Expiration>2030-01-01T00:00:00Z</Expiration>
+			</Credentials></AssumeRoleResult></AssumeRoleResponse>`))
+			return
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=SCOPED/") {
+			t.Errorf("Want token request signed with the scoped credentials")
+		}
+		w.Write([]byte(`{"authorizationData":[{
+			"authorizationToken":"` + base64.StdEncoding.EncodeToString([]byte("AWS:password")) + `",
+			"expiresAt":1.5E9,
+			"proxyEndpoint":"https://123456789012.dkr.ecr.us-east-1.amazonaws.com"}]}`))
+	}))
+	defer ts.Close()
+
+	p := &ecrRobots{
+		ecr: &ecr{
+			region:   "us-east-1",
+			endpoint: ts.URL,
+			sts:      ts.URL,
+			getenv: func(key string) string {
+				return map[string]string{
+					"AWS_ACCESS_KEY_ID":     "AKID",
+					"AWS_SECRET_ACCESS_KEY": "secret",
+				}[key]
+			},
+		},
+		role: "arn:aws:iam::123456789012:role/publish",
+	}
+	account, err := p.Mint(context.Background(), "octocat", "drone-abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := account.Registry, "123456789012.dkr.ecr.us-east-1.amazonaws.com"; got != want {
+		t.Errorf("Want registry %q, got %q", want, got)
+	}
+	if account.Username != "AWS" || account.Password != "password" {
+		t.Errorf("Want decoded authorization token")
+	}
+}
@@ -0,0 +1,444 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package control provides an http api that a fleet controller
+// uses to inspect and manage the runner. The api requires
+// bearer token authentication, except for the health check.
+// If the api is served with mutual tls, the client identity is
+// the common name of the verified client certificate, and the
+// api can be restricted to the identities of the fleet
+// controller and the peer runner replicas.
+//
+//	GET  /api/control/health    returns 200 unless the runner is drained.
+//	GET  /api/control/state     returns the runner state.
+//	POST /api/control/pause     pauses execution for the window in the body.
+//	POST /api/control/resume    resumes execution.
+//	POST /api/control/drain     stops polling for new stages.
+//	POST /api/control/capacity  sets the capacity in the body.
+//	POST /api/control/cancel    cancels the stage or step in the body.
+//	POST /api/control/revoke    cancels the stages of the repository in the body.
+//	GET  /api/control/node      returns the pipeline pods on the node in the query.
+//	POST /api/control/relocate  schedules new pipelines off the node in the body.
+//	POST /api/control/release   schedules new pipelines on the node in the body.
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/internal/pause"
+	"github.com/drone-runners/drone-runner-kube/runtime"
+
+	"github.com/drone/runner-go/pipeline/history"
+)
+
+// State provides the runner state.
+type State struct {
+	Name        string           `json:"name"`
+	Capacity    int              `json:"capacity"`
+	MaxCapacity int              `json:"max_capacity"`
+	Active      int              `json:"active"`
+	Draining    bool             `json:"draining"`
+	Drained     bool             `json:"drained"`
+	Pause       *pause.Window    `json:"pause,omitempty"`
+	Stages      []*history.Entry `json:"stages"`
+}
+
+// Controller manages the runner.
+type Controller struct {
+	// Name provides the runner name.
+	Name string
+
+	// Token provides the bearer token used to authenticate
+	// requests.
+	Token string
+
+	// Peers provides the identities of the clients permitted
+	// to use the api if the api is served with mutual tls. If
+	// empty, any client with a verified certificate is
+	// permitted.
+	Peers []string
+
+	// MaxCapacity provides the maximum capacity, which is the
+	// number of connections used to poll for stages.
+	MaxCapacity int
+
+	Capacity  *runtime.Capacity
+	Pause     *pause.Switch
+	History   *history.History
+	Canceller *runtime.Canceller
+
+	// Revoke destroys the retained pipelines of the repository
+	// and returns the number of destroyed pipelines.
+	Revoke func(repo string) int
+
+	// Node returns the pipeline pods that run on the named
+	// node. Relocate excludes the named node from scheduling
+	// while the node is drained, and Release reverts the
+	// relocation.
+	Node     func(name string) ([]*engine.NodePod, error)
+	Relocate func(name string)
+	Release  func(name string)
+
+	// Checkpoint checkpoints the running containers of the
+	// pipeline pod, and returns the checkpoint archives keyed
+	// by container name. Checkpointing is experimental.
+	Checkpoint func(pod *engine.NodePod) (map[string]string, error)
+
+	// Drain stops polling for new stages, and Drained is
+	// closed once the in-flight stages are complete.
+	Drain   func()
+	Drained <-chan struct{}
+
+	once     sync.Once
+	mu       sync.Mutex
+	draining bool
+}
+
+// Handler returns the control api http handler.
+func (c *Controller) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/control/health", c.handleHealth)
+	mux.Handle("/api/control/state", c.auth(http.HandlerFunc(c.handleState)))
+	mux.Handle("/api/control/pause", c.auth(post(c.handlePause)))
+	mux.Handle("/api/control/resume", c.auth(post(c.handleResume)))
+	mux.Handle("/api/control/drain", c.auth(post(c.handleDrain)))
+	mux.Handle("/api/control/capacity", c.auth(post(c.handleCapacity)))
+	mux.Handle("/api/control/cancel", c.auth(post(c.handleCancel)))
+	mux.Handle("/api/control/revoke", c.auth(post(c.handleRevoke)))
+	mux.Handle("/api/control/node", c.auth(http.HandlerFunc(c.handleNode)))
+	mux.Handle("/api/control/relocate", c.auth(post(c.handleRelocate)))
+	mux.Handle("/api/control/release", c.auth(post(c.handleRelease)))
+	return mux
+}
+
+func (c *Controller) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if c.drained() {
+		http.Error(w, "drained", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func (c *Controller) handleState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.state())
+}
+
+func (c *Controller) handlePause(w http.ResponseWriter, r *http.Request) {
+	window := new(pause.Window)
+	if err := json.NewDecoder(r.Body).Decode(window); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.Pause.Pause(window)
+	writeJSON(w, c.state())
+}
+
+func (c *Controller) handleResume(w http.ResponseWriter, r *http.Request) {
+	c.Pause.Resume()
+	writeJSON(w, c.state())
+}
+
+func (c *Controller) handleDrain(w http.ResponseWriter, r *http.Request) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.draining = true
+		c.mu.Unlock()
+		c.Drain()
+	})
+	writeJSON(w, c.state())
+}
+
+func (c *Controller) handleCapacity(w http.ResponseWriter, r *http.Request) {
+	in := struct {
+		Capacity int `json:"capacity"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.Capacity < 0 || in.Capacity > c.MaxCapacity {
+		http.Error(w, "capacity must be between 0 and the maximum capacity", http.StatusBadRequest)
+		return
+	}
+	c.Capacity.Set(in.Capacity)
+	writeJSON(w, c.state())
+}
+
+// Cancel identifies the running stages or step to cancel. If
+// the step is set, the step of the stage is cancelled and the
+// stage continues. Otherwise the stage, or the stages of the
+// build, are cancelled.
+type Cancel struct {
+	Build int64  `json:"build"`
+	Stage int64  `json:"stage"`
+	Step  string `json:"step"`
+}
+
+func (c *Controller) handleCancel(w http.ResponseWriter, r *http.Request) {
+	in := new(Cancel)
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case in.Step != "" && in.Stage == 0:
+		http.Error(w, "stage is required to cancel a step", http.StatusBadRequest)
+		return
+	case in.Build == 0 && in.Stage == 0:
+		http.Error(w, "build or stage is required", http.StatusBadRequest)
+		return
+	case c.Canceller == nil:
+		http.Error(w, "cancellation is not supported", http.StatusNotImplemented)
+		return
+	}
+	if in.Step != "" {
+		if !c.Canceller.CancelStep(in.Stage, in.Step) {
+			http.Error(w, "step is not running", http.StatusNotFound)
+			return
+		}
+	} else if c.Canceller.Cancel(in.Build, in.Stage) == 0 {
+		http.Error(w, "stage is not running", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, c.state())
+}
+
+// Revoked provides the result of a repository revocation.
+type Revoked struct {
+	Repo      string `json:"repo"`
+	Cancelled int    `json:"cancelled"`
+	Destroyed int    `json:"destroyed"`
+}
+
+// handleRevoke cancels the running stages of a repository that
+// is disabled or whose credentials are revoked, and destroys
+// the retained pipelines of the repository, so that the build
+// secrets are removed from the cluster. The revocation is
+// idempotent, and succeeds if no stages are running.
+func (c *Controller) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	in := struct {
+		Repo string `json:"repo"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.Repo == "" {
+		http.Error(w, "repo is required", http.StatusBadRequest)
+		return
+	}
+	out := &Revoked{Repo: in.Repo}
+	if c.Canceller != nil {
+		out.Cancelled = c.Canceller.CancelRepo(in.Repo)
+	}
+	if c.Revoke != nil {
+		out.Destroyed = c.Revoke(in.Repo)
+	}
+	writeJSON(w, out)
+}
+
+// NodeState provides the pipeline pods that run on a node.
+type NodeState struct {
+	Name      string            `json:"name"`
+	Pods      []*engine.NodePod `json:"pods"`
+	Cancelled int               `json:"cancelled,omitempty"`
+}
+
+// Relocation identifies the node that is drained. If cancel is
+// true, the running stages on the node are cancelled, so that
+// the node can be drained before the stages complete. If
+// checkpoint is true, the running containers are checkpointed
+// before the stages are cancelled.
+type Relocation struct {
+	Node       string `json:"node"`
+	Cancel     bool   `json:"cancel"`
+	Checkpoint bool   `json:"checkpoint"`
+}
+
+// handleNode returns the pipeline pods that run on the node,
+// and the latest time each pipeline is expected to complete,
+// so that a node drain controller can decide whether to wait
+// for the pipelines or to relocate them.
+func (c *Controller) handleNode(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if c.Node == nil {
+		http.Error(w, "node inspection is not supported", http.StatusNotImplemented)
+		return
+	}
+	pods, err := c.Node(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, &NodeState{Name: name, Pods: pods})
+}
+
+// handleRelocate excludes the node from scheduling. The running
+// pipelines are not moved, because a pipeline pod cannot be
+// rescheduled, and complete on the node unless cancelled.
+func (c *Controller) handleRelocate(w http.ResponseWriter, r *http.Request) {
+	in := new(Relocation)
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case in.Node == "":
+		http.Error(w, "node is required", http.StatusBadRequest)
+		return
+	case c.Node == nil || c.Relocate == nil || c.Release == nil:
+		http.Error(w, "relocation is not supported", http.StatusNotImplemented)
+		return
+	case in.Cancel && c.Canceller == nil:
+		http.Error(w, "cancellation is not supported", http.StatusNotImplemented)
+		return
+	case in.Checkpoint && c.Checkpoint == nil:
+		http.Error(w, "checkpointing is not enabled", http.StatusNotImplemented)
+		return
+	}
+	c.Relocate(in.Node)
+	pods, err := c.Node(in.Node)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := &NodeState{Name: in.Node, Pods: pods}
+	if in.Checkpoint {
+		for _, pod := range pods {
+			archives, err := c.Checkpoint(pod)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			pod.Checkpoints = archives
+		}
+	}
+	if in.Cancel {
+		for _, pod := range pods {
+			if pod.StageID != 0 {
+				out.Cancelled += c.Canceller.Cancel(0, pod.StageID)
+			}
+		}
+	}
+	writeJSON(w, out)
+}
+
+// handleRelease reverts the relocation of the node, for
+// example once the node maintenance is complete.
+func (c *Controller) handleRelease(w http.ResponseWriter, r *http.Request) {
+	in := new(Relocation)
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case in.Node == "":
+		http.Error(w, "node is required", http.StatusBadRequest)
+		return
+	case c.Release == nil:
+		http.Error(w, "relocation is not supported", http.StatusNotImplemented)
+		return
+	}
+	c.Release(in.Node)
+	writeJSON(w, &NodeState{Name: in.Node})
+}
+
+// helper function returns the runner state.
+func (c *Controller) state() *State {
+	c.mu.Lock()
+	draining := c.draining
+	c.mu.Unlock()
+	return &State{
+		Name:        c.Name,
+		Capacity:    c.Capacity.Limit(),
+		MaxCapacity: c.MaxCapacity,
+		Active:      c.Capacity.Active(),
+		Draining:    draining,
+		Drained:     c.drained(),
+		Pause:       c.Pause.Window(),
+		Stages:      c.History.Entries(),
+	}
+}
+
+// helper function returns true if the runner is drained.
+func (c *Controller) drained() bool {
+	select {
+	case <-c.Drained:
+		return true
+	default:
+		return false
+	}
+}
+
+// helper function returns an http handler that requires
+// bearer token authentication.
+func (c *Controller) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if c.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.TLS != nil && !c.permitted(Identity(r)) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// helper function returns true if the client identity is
+// permitted to use the api.
+func (c *Controller) permitted(identity string) bool {
+	if identity == "" {
+		return false
+	}
+	if len(c.Peers) == 0 {
+		return true
+	}
+	for _, peer := range c.Peers {
+		if peer == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity returns the identity of the client, which is the
+// common name of the verified client certificate. An empty
+// string is returned if the client certificate is not
+// verified.
+func Identity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// helper function returns an http handler that only accepts
+// post requests.
+func post(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// helper function writes the json-encoded value.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
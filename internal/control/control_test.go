@@ -0,0 +1,264 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package control
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/internal/pause"
+	"github.com/drone-runners/drone-runner-kube/runtime"
+
+	"github.com/drone/runner-go/pipeline/history"
+)
+
+func newController() *Controller {
+	drained := make(chan struct{})
+	return &Controller{
+		Name:        "runner-1",
+		Token:       "correct-horse-battery-staple",
+		MaxCapacity: 10,
+		Capacity:    runtime.NewCapacity(2),
+		Pause:       pause.New(nil),
+		History:     history.New(nil),
+		Canceller:   runtime.NewCanceller(),
+		Drain:       func() { close(drained) },
+		Drained:     drained,
+	}
+}
+
+func do(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestUnauthorized(t *testing.T) {
+	h := newController().Handler()
+	w := do(h, "GET", "/api/control/state", "", "")
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	w = do(h, "GET", "/api/control/state", "incorrect", "")
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	c := newController()
+	h := c.Handler()
+	w := do(h, "POST", "/api/control/capacity", c.Token, `{"capacity": 5}`)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("Want status %d, got %d", want, got)
+	}
+	state := new(State)
+	json.NewDecoder(w.Body).Decode(state)
+	if got, want := state.Capacity, 5; got != want {
+		t.Errorf("Want capacity %d, got %d", want, got)
+	}
+
+	w = do(h, "POST", "/api/control/capacity", c.Token, `{"capacity": 11}`)
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	c := newController()
+	h := c.Handler()
+	if got, want := do(h, "GET", "/api/control/health", "", "").Code, http.StatusOK; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	if got, want := do(h, "GET", "/api/control/drain", c.Token, "").Code, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	// the drain request is idempotent.
+	do(h, "POST", "/api/control/drain", c.Token, "")
+	w := do(h, "POST", "/api/control/drain", c.Token, "")
+	state := new(State)
+	json.NewDecoder(w.Body).Decode(state)
+	if !state.Draining || !state.Drained {
+		t.Errorf("Want runner draining and drained, got %v and %v", state.Draining, state.Drained)
+	}
+	if got, want := do(h, "GET", "/api/control/health", "", "").Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+}
+
+func TestPause(t *testing.T) {
+	c := newController()
+	h := c.Handler()
+	do(h, "POST", "/api/control/pause", c.Token, `{"repos": ["octocat/*"]}`)
+	if c.Pause.Window() == nil {
+		t.Errorf("Want runner paused")
+	}
+	do(h, "POST", "/api/control/resume", c.Token, "")
+	if c.Pause.Window() != nil {
+		t.Errorf("Want runner resumed")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	c := newController()
+	h := c.Handler()
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"build": 1, "step": "test"}`, http.StatusBadRequest},
+		{`{"build": 1}`, http.StatusNotFound},
+		{`{"stage": 2, "step": "test"}`, http.StatusNotFound},
+	}
+	for i, test := range tests {
+		if got, want := do(h, "POST", "/api/control/cancel", c.Token, test.body).Code, test.code; got != want {
+			t.Errorf("Want status %d, got %d at index %d", want, got, i)
+		}
+	}
+	if got, want := do(h, "POST", "/api/control/cancel", "", `{"build": 1}`).Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	c := newController()
+	var revoked string
+	c.Revoke = func(repo string) int {
+		revoked = repo
+		return 1
+	}
+	h := c.Handler()
+	if got, want := do(h, "POST", "/api/control/revoke", c.Token, `{}`).Code, http.StatusBadRequest; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	res := do(h, "POST", "/api/control/revoke", c.Token, `{"repo": "octocat/hello-world"}`)
+	if got, want := res.Code, http.StatusOK; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	if got, want := revoked, "octocat/hello-world"; got != want {
+		t.Errorf("Want retained pipelines of %s destroyed, got %q", want, got)
+	}
+	if got, want := do(h, "POST", "/api/control/revoke", "", `{"repo": "octocat/hello-world"}`).Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	c := newController()
+	relocated := map[string]bool{}
+	c.Node = func(name string) ([]*engine.NodePod, error) {
+		return []*engine.NodePod{{Name: "drone-abc", Node: name, StageID: 2}}, nil
+	}
+	c.Relocate = func(name string) { relocated[name] = true }
+	c.Release = func(name string) { delete(relocated, name) }
+	h := c.Handler()
+
+	if got, want := do(h, "GET", "/api/control/node", c.Token, "").Code, http.StatusBadRequest; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	w := do(h, "GET", "/api/control/node?name=node-1", c.Token, "")
+	node := new(NodeState)
+	json.NewDecoder(w.Body).Decode(node)
+	if len(node.Pods) != 1 || node.Pods[0].Name != "drone-abc" {
+		t.Errorf("Want pipeline pods on the node")
+	}
+
+	if got, want := do(h, "POST", "/api/control/relocate", c.Token, `{}`).Code, http.StatusBadRequest; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	w = do(h, "POST", "/api/control/relocate", c.Token, `{"node": "node-1", "cancel": true}`)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	if !relocated["node-1"] {
+		t.Errorf("Want node relocated")
+	}
+	node = new(NodeState)
+	json.NewDecoder(w.Body).Decode(node)
+	if node.Cancelled != 0 {
+		t.Errorf("Want no stages cancelled if the stage is not running")
+	}
+
+	do(h, "POST", "/api/control/release", c.Token, `{"node": "node-1"}`)
+	if relocated["node-1"] {
+		t.Errorf("Want node released")
+	}
+}
+
+func TestRelocate_Checkpoint(t *testing.T) {
+	c := newController()
+	c.Node = func(name string) ([]*engine.NodePod, error) {
+		return []*engine.NodePod{{Name: "drone-abc", Node: name}}, nil
+	}
+	c.Relocate = func(name string) {}
+	c.Release = func(name string) {}
+	h := c.Handler()
+
+	body := `{"node": "node-1", "checkpoint": true}`
+	if got, want := do(h, "POST", "/api/control/relocate", c.Token, body).Code, http.StatusNotImplemented; got != want {
+		t.Errorf("Want status %d if checkpointing is not enabled, got %d", want, got)
+	}
+
+	c.Checkpoint = func(pod *engine.NodePod) (map[string]string, error) {
+		return map[string]string{"build": "/var/lib/kubelet/checkpoints/" + pod.Name + ".tar"}, nil
+	}
+	w := do(h, "POST", "/api/control/relocate", c.Token, body)
+	node := new(NodeState)
+	json.NewDecoder(w.Body).Decode(node)
+	if len(node.Pods) != 1 || node.Pods[0].Checkpoints["build"] != "/var/lib/kubelet/checkpoints/drone-abc.tar" {
+		t.Errorf("Want checkpoint archives in the response")
+	}
+}
+
+func TestRelocate_NotSupported(t *testing.T) {
+	c := newController()
+	h := c.Handler()
+	if got, want := do(h, "GET", "/api/control/node?name=node-1", c.Token, "").Code, http.StatusNotImplemented; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	if got, want := do(h, "POST", "/api/control/relocate", c.Token, `{"node": "node-1"}`).Code, http.StatusNotImplemented; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+}
+
+func TestPeers(t *testing.T) {
+	c := newController()
+	c.Peers = []string{"fleet-controller"}
+	h := c.Handler()
+
+	request := func(identity string) int {
+		r := httptest.NewRequest("GET", "/api/control/state", nil)
+		r.Header.Set("Authorization", "Bearer "+c.Token)
+		r.TLS = &tls.ConnectionState{}
+		if identity != "" {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: identity}}
+			r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if got, want := request("fleet-controller"), http.StatusOK; got != want {
+		t.Errorf("Want status %d for a permitted peer, got %d", want, got)
+	}
+	if got, want := request("runner-2"), http.StatusForbidden; got != want {
+		t.Errorf("Want status %d for an unknown peer, got %d", want, got)
+	}
+	if got, want := request(""), http.StatusForbidden; got != want {
+		t.Errorf("Want status %d without a verified certificate, got %d", want, got)
+	}
+}
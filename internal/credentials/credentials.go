@@ -0,0 +1,156 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package credentials provides short-lived credentials for
+// deployment pipelines.
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+type (
+	// Request provides arguments for requesting credentials.
+	Request struct {
+		Repo  *drone.Repo
+		Build *drone.Build
+	}
+
+	// Credentials provides short-lived credentials exposed
+	// to the pipeline as environment variables.
+	Credentials struct {
+		Environ map[string]string `json:"environment"`
+		Expires int64             `json:"expires"`
+	}
+
+	// Policy maps repositories and deployment environments
+	// to a policy defined by the credentials broker.
+	Policy struct {
+		Name         string        `yaml:"name"`
+		Repos        []string      `yaml:"repos"`
+		Environments []string      `yaml:"environments"`
+		Duration     time.Duration `yaml:"duration"`
+	}
+
+	// Provider returns credentials for the pipeline.
+	Provider interface {
+		Find(context.Context, *Request) (*Credentials, error)
+	}
+)
+
+// Broker returns a provider that requests short-lived
+// credentials from a remote credentials broker. Credentials
+// are only requested for deployments, using the first policy
+// that matches the repository and deployment environment.
+func Broker(endpoint, token string, skipverify bool, policies []*Policy) Provider {
+	client := http.DefaultClient
+	if skipverify {
+		client = &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		}
+	}
+	return &broker{
+		endpoint: endpoint,
+		token:    token,
+		policies: policies,
+		client:   client,
+	}
+}
+
+type broker struct {
+	endpoint string
+	token    string
+	policies []*Policy
+	client   *http.Client
+}
+
+// request is the payload sent to the credentials broker.
+type request struct {
+	Policy      string `json:"policy"`
+	Repo        string `json:"repo"`
+	Environment string `json:"environment"`
+	Build       int64  `json:"build"`
+	Duration    int64  `json:"duration,omitempty"`
+}
+
+func (b *broker) Find(ctx context.Context, in *Request) (*Credentials, error) {
+	if b.endpoint == "" || in.Build.Deploy == "" {
+		return nil, nil
+	}
+	policy := b.match(in.Repo.Slug, in.Build.Deploy)
+	if policy == nil {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(&request{
+		Policy:      policy.Name,
+		Repo:        in.Repo.Slug,
+		Environment: in.Build.Deploy,
+		Build:       in.Build.Number,
+		Duration:    int64(policy.Duration / time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", b.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+b.token)
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("credentials: broker returned status %d", res.StatusCode)
+	}
+	out := new(Credentials)
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// helper function returns the first policy that matches the
+// repository and deployment environment.
+func (b *broker) match(repo, environment string) *Policy {
+	for _, policy := range b.policies {
+		if match(repo, policy.Repos) && match(environment, policy.Environments) {
+			return policy
+		}
+	}
+	return nil
+}
+
+func match(s string, patterns []string) bool {
+	// if no matching patterns are defined the string
+	// is always considered a match.
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if match, _ := filepath.Match(pattern, s); match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func TestBroker(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer secret"; got != want {
+			t.Errorf("Want authorization header %q, got %q", want, got)
+		}
+		in := new(request)
+		json.NewDecoder(r.Body).Decode(in)
+		if got, want := in.Policy, "deploy-prod"; got != want {
+			t.Errorf("Want policy %q, got %q", want, got)
+		}
+		if got, want := in.Duration, int64(900); got != want {
+			t.Errorf("Want duration %d, got %d", want, got)
+		}
+		json.NewEncoder(w).Encode(&Credentials{
+			Environ: map[string]string{"AWS_SESSION_TOKEN": "token"},
+		})
+	}))
+	defer ts.Close()
+
+	policies := []*Policy{
+		{Name: "deploy-staging", Environments: []string{"staging"}},
+		{Name: "deploy-prod", Repos: []string{"octocat/*"}, Environments: []string{"prod*"}, Duration: 15 * time.Minute},
+	}
+	provider := Broker(ts.URL, "secret", false, policies)
+	creds, err := provider.Find(context.Background(), &Request{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{Number: 1, Deploy: "production"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if creds == nil {
+		t.Errorf("Expect credentials returned")
+		return
+	}
+	if got, want := creds.Environ["AWS_SESSION_TOKEN"], "token"; got != want {
+		t.Errorf("Want credential %q, got %q", want, got)
+	}
+}
+
+func TestBroker_NoMatch(t *testing.T) {
+	policies := []*Policy{
+		{Name: "deploy-prod", Repos: []string{"octocat/*"}},
+	}
+	provider := Broker("http://localhost", "secret", false, policies)
+	tests := []*Request{
+		// not a deployment
+		{Repo: &drone.Repo{Slug: "octocat/hello-world"}, Build: &drone.Build{}},
+		// repository does not match a policy
+		{Repo: &drone.Repo{Slug: "spaceghost/hello-world"}, Build: &drone.Build{Deploy: "production"}},
+	}
+	for _, test := range tests {
+		creds, err := provider.Find(context.Background(), test)
+		if err != nil {
+			t.Error(err)
+		}
+		if creds != nil {
+			t.Errorf("Expect no credentials for %s", test.Repo.Slug)
+		}
+	}
+}
@@ -5,13 +5,55 @@
 package image
 
 import (
+	"net"
 	"strings"
 
 	"github.com/docker/distribution/reference"
 )
 
+// placeholder hostname used to parse images hosted by a
+// registry with an ipv6 address, which are not supported by
+// the reference parser.
+const placeholder = "ipv6.invalid"
+
+// helper function replaces a bracketed ipv6 registry host,
+// for example [fd00::1]:5000, with a placeholder hostname.
+// It returns the image name and the registry host.
+func maskHost(name string) (string, string) {
+	if !strings.HasPrefix(name, "[") {
+		return name, ""
+	}
+	i := strings.Index(name, "/")
+	if i == -1 {
+		return name, ""
+	}
+	host := name[:i]
+	end := strings.Index(host, "]")
+	if end == -1 || net.ParseIP(host[1:end]) == nil {
+		return name, ""
+	}
+	if port := host[end+1:]; port != "" && !strings.HasPrefix(port, ":") {
+		return name, ""
+	}
+	return placeholder + name[i:], host
+}
+
+// helper function restores the registry host replaced by a
+// placeholder hostname.
+func unmaskHost(name, host string) string {
+	if host == "" {
+		return name
+	}
+	return strings.Replace(name, placeholder, host, 1)
+}
+
 // Trim returns the short image name without tag.
 func Trim(name string) string {
+	name, host := maskHost(name)
+	return unmaskHost(trim(name), host)
+}
+
+func trim(name string) string {
 	ref, err := reference.ParseAnyReference(name)
 	if err != nil {
 		return name
@@ -26,6 +68,11 @@ func Trim(name string) string {
 
 // Expand returns the fully qualified image name.
 func Expand(name string) string {
+	name, host := maskHost(name)
+	return unmaskHost(expand(name), host)
+}
+
+func expand(name string) string {
 	ref, err := reference.ParseAnyReference(name)
 	if err != nil {
 		return name
@@ -60,18 +107,29 @@ func MatchTag(a, b string) bool {
 // MatchHostname returns true if the image hostname
 // matches the specified hostname.
 func MatchHostname(image, hostname string) bool {
+	if hostname == "index.docker.io" {
+		hostname = "docker.io"
+	}
+	domain := Domain(image)
+	return domain != "" && domain == hostname
+}
+
+// Domain returns the registry hostname of the image, for
+// example docker.io for official images. An empty string is
+// returned if the image cannot be parsed.
+func Domain(image string) string {
+	if _, host := maskHost(image); host != "" {
+		return host
+	}
 	ref, err := reference.ParseAnyReference(image)
 	if err != nil {
-		return false
+		return ""
 	}
 	named, err := reference.ParseNamed(ref.String())
 	if err != nil {
-		return false
-	}
-	if hostname == "index.docker.io" {
-		hostname = "docker.io"
+		return ""
 	}
-	return reference.Domain(named) == hostname
+	return reference.Domain(named)
 }
 
 // IsLatest parses the image and returns true if
@@ -27,6 +27,10 @@ func Test_trimImage(t *testing.T) {
 			from: "library/golang",
 			want: "golang",
 		},
+		{
+			from: "[fd00::1]:5000/golang:1.0.0",
+			want: "[fd00::1]:5000/golang",
+		},
 		{
 			from: "library/golang:latest",
 			want: "golang",
@@ -78,6 +82,10 @@ func Test_expandImage(t *testing.T) {
 			from: "golang:1.0.0",
 			want: "docker.io/library/golang:1.0.0",
 		},
+		{
+			from: "[fd00::1]:5000/golang",
+			want: "[fd00::1]:5000/golang:latest",
+		},
 		{
 			from: "library/golang",
 			want: "docker.io/library/golang:latest",
@@ -245,6 +253,16 @@ func Test_matchHostname(t *testing.T) {
 			hostname: "1.2.3.4:8000",
 			want:     true,
 		},
+		{
+			image:    "[fd00::1]:8000/golang:1.0.0",
+			hostname: "[fd00::1]:8000",
+			want:     true,
+		},
+		{
+			image:    "[fd00::1]:8000/golang:1.0.0",
+			hostname: "1.2.3.4:8000",
+			want:     false,
+		},
 		{
 			image:    "*&^%",
 			hostname: "1.2.3.4:8000",
@@ -0,0 +1,355 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package inspect fetches the configuration of container
+// images from the registry, so that the entrypoint of plugin
+// images can be executed.
+package inspect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+
+	"github.com/buildkite/yaml"
+	"github.com/docker/distribution/reference"
+)
+
+// media types of the image manifest and manifest list.
+const (
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+)
+
+// maximum size of a manifest or image config, in bytes.
+const maxSize = 4 << 20
+
+// Config provides the image configuration.
+type Config struct {
+	Entrypoint []string `json:"entrypoint,omitempty" yaml:"entrypoint"`
+	Cmd        []string `json:"cmd,omitempty"        yaml:"cmd"`
+}
+
+// Inspector fetches and caches the image configuration.
+type Inspector struct {
+	client   *http.Client
+	scheme   string
+	username string
+	password string
+	ttl      time.Duration
+
+	mu     sync.Mutex
+	cache  map[string]*entry
+	static map[string]*Config
+}
+
+type entry struct {
+	config  *Config
+	expires time.Time
+}
+
+// New returns a new inspector. The registry credentials are
+// optional. The image configuration is cached for the ttl.
+func New(username, password string, ttl time.Duration) *Inspector {
+	return &Inspector{
+		client:   http.DefaultClient,
+		scheme:   "https",
+		username: username,
+		password: password,
+		ttl:      ttl,
+		cache:    map[string]*entry{},
+		static:   map[string]*Config{},
+	}
+}
+
+// Load loads the image configuration from the manifest cache
+// file, which maps the image name to the image configuration.
+// Images in the manifest cache are never fetched from the
+// registry, which supports registries that cannot be reached
+// from the runner.
+func (i *Inspector) Load(path string) error {
+	out, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	configs := map[string]*Config{}
+	if err := yaml.Unmarshal(out, &configs); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	for name, config := range configs {
+		i.static[image.Expand(name)] = config
+	}
+	i.mu.Unlock()
+	return nil
+}
+
+// Inspect returns the configuration of the image for the
+// platform, for example linux/amd64.
+func (i *Inspector) Inspect(ctx context.Context, name, platform string) (*Config, error) {
+	key := image.Expand(name)
+	i.mu.Lock()
+	if config, ok := i.static[key]; ok {
+		i.mu.Unlock()
+		return config, nil
+	}
+	if e, ok := i.cache[key+"@"+platform]; ok && time.Now().Before(e.expires) {
+		i.mu.Unlock()
+		return e.config, nil
+	}
+	i.mu.Unlock()
+
+	config, err := i.fetch(ctx, name, platform)
+	if err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	i.cache[key+"@"+platform] = &entry{
+		config:  config,
+		expires: time.Now().Add(i.ttl),
+	}
+	i.mu.Unlock()
+	return config, nil
+}
+
+// ref identifies an image in the registry.
+type ref struct {
+	Registry   string
+	Repository string
+	Version    string
+}
+
+// helper function parses the image name.
+func parseRef(name string) (ref, error) {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return ref{}, err
+	}
+	r := ref{
+		Registry:   reference.Domain(named),
+		Repository: reference.Path(named),
+		Version:    "latest",
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		r.Version = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		r.Version = digested.Digest().String()
+	}
+	if r.Registry == "docker.io" {
+		r.Registry = "registry-1.docker.io"
+	}
+	return r, nil
+}
+
+// helper function fetches the image configuration from the
+// registry. If the manifest is a manifest list, the manifest
+// of the platform is used.
+func (i *Inspector) fetch(ctx context.Context, name, platform string) (*Config, error) {
+	r, err := parseRef(name)
+	if err != nil {
+		return nil, err
+	}
+	accept := strings.Join([]string{
+		mediaTypeManifest,
+		mediaTypeManifestList,
+		mediaTypeOCIManifest,
+		mediaTypeOCIIndex,
+	}, ", ")
+
+	raw, token, err := i.get(ctx, r, "manifests/"+r.Version, accept, "")
+	if err != nil {
+		return nil, err
+	}
+	m := new(manifest)
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, err
+	}
+	if len(m.Manifests) != 0 {
+		digest, ok := m.find(platform)
+		if !ok {
+			return nil, fmt.Errorf("inspect: no manifest for platform %s in %s", platform, name)
+		}
+		raw, token, err = i.get(ctx, r, "manifests/"+digest, accept, token)
+		if err != nil {
+			return nil, err
+		}
+		m = new(manifest)
+		if err := json.Unmarshal(raw, m); err != nil {
+			return nil, err
+		}
+	}
+	if m.Config.Digest == "" {
+		return nil, fmt.Errorf("inspect: no image config in %s", name)
+	}
+	raw, _, err = i.get(ctx, r, "blobs/"+m.Config.Digest, "", token)
+	if err != nil {
+		return nil, err
+	}
+	out := struct {
+		Config struct {
+			Entrypoint []string `json:"Entrypoint"`
+			Cmd        []string `json:"Cmd"`
+		} `json:"config"`
+	}{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return &Config{
+		Entrypoint: out.Config.Entrypoint,
+		Cmd:        out.Config.Cmd,
+	}, nil
+}
+
+// manifest is the subset of the image manifest and manifest
+// list used to find the image config.
+type manifest struct {
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// helper function returns the digest of the manifest for the
+// platform, in os/arch or os/arch/variant format.
+func (m *manifest) find(platform string) (string, bool) {
+	for _, v := range m.Manifests {
+		p := v.Platform.OS + "/" + v.Platform.Architecture
+		if p == platform || p+"/"+v.Platform.Variant == platform {
+			return v.Digest, true
+		}
+	}
+	return "", false
+}
+
+// helper function gets the registry resource. If the registry
+// requires a bearer token, the token is requested and the
+// request is retried. Returns the token, which is reused for
+// subsequent requests.
+func (i *Inspector) get(ctx context.Context, r ref, path, accept, token string) ([]byte, string, error) {
+	endpoint := fmt.Sprintf("%s://%s/v2/%s/%s", i.scheme, r.Registry, r.Repository, path)
+	res, err := i.do(ctx, endpoint, accept, token)
+	if err != nil {
+		return nil, token, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("Www-Authenticate")
+		res.Body.Close()
+		token, err = i.token(ctx, challenge, r)
+		if err != nil {
+			return nil, token, err
+		}
+		res, err = i.do(ctx, endpoint, accept, token)
+		if err != nil {
+			return nil, token, err
+		}
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return nil, token, fmt.Errorf("inspect: registry returned status %d for %s/%s", res.StatusCode, r.Registry, r.Repository)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(res.Body, maxSize+1))
+	if err != nil {
+		return nil, token, err
+	}
+	if len(raw) > maxSize {
+		return nil, token, fmt.Errorf("inspect: response exceeds %d bytes", maxSize)
+	}
+	return raw, token, nil
+}
+
+// helper function sends the request with the optional bearer
+// token.
+func (i *Inspector) do(ctx context.Context, endpoint, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return i.client.Do(req)
+}
+
+// helper function requests a bearer token with pull access to
+// the repository, using the realm and service of the registry
+// authentication challenge.
+func (i *Inspector) token(ctx context.Context, challenge string, r ref) (string, error) {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("inspect: registry authentication required for %s/%s", r.Registry, r.Repository)
+	}
+	query := url.Values{}
+	query.Set("scope", "repository:"+r.Repository+":pull")
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	req, err := http.NewRequest("GET", realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	if i.username != "" {
+		req.SetBasicAuth(i.username, i.password)
+	}
+	res, err := i.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return "", fmt.Errorf("inspect: token endpoint returned status %d", res.StatusCode)
+	}
+	out := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if out.Token != "" {
+		return out.Token, nil
+	}
+	return out.AccessToken, nil
+}
+
+// helper function parses the parameters of a bearer
+// authentication challenge.
+func parseChallenge(s string) map[string]string {
+	params := map[string]string{}
+	if !strings.HasPrefix(strings.ToLower(s), "bearer ") {
+		return params
+	}
+	for _, part := range strings.Split(s[len("bearer "):], ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return params
+}
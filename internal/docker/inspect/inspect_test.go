@@ -0,0 +1,116 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package inspect
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helper function returns a test registry that serves a
+// manifest list, and requires a bearer token.
+func testRegistry(requests *int) *httptest.Server {
+	list := `{"manifests":[
+		{"digest":"sha256:arm64","platform":{"os":"linux","architecture":"arm64"}},
+		{"digest":"sha256:amd64","platform":{"os":"linux","architecture":"amd64"}}
+	]}`
+	manifest := `{"config":{"digest":"sha256:config"}}`
+	config := `{"config":{"Entrypoint":["/bin/drone-slack"],"Cmd":["--help"]}}`
+
+	mux := http.NewServeMux()
+	var server *httptest.Server
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.FormValue("scope"); got != "repository:plugins/slack:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"token":"secret"}`)
+	})
+	mux.HandleFunc("/v2/plugins/slack/", func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/plugins/slack/manifests/1":
+			fmt.Fprint(w, list)
+		case "/v2/plugins/slack/manifests/sha256:amd64":
+			fmt.Fprint(w, manifest)
+		case "/v2/plugins/slack/blobs/sha256:config":
+			fmt.Fprint(w, config)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func TestInspect(t *testing.T) {
+	var requests int
+	server := testRegistry(&requests)
+	defer server.Close()
+
+	i := New("", "", time.Hour)
+	i.scheme = "http"
+	name := strings.TrimPrefix(server.URL, "http://") + "/plugins/slack:1"
+
+	config, err := i.Inspect(context.Background(), name, "linux/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Entrypoint: []string{"/bin/drone-slack"},
+		Cmd:        []string{"--help"},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("Want config %v, got %v", want, config)
+	}
+
+	// the image config is cached.
+	n := requests
+	if _, err := i.Inspect(context.Background(), name, "linux/amd64"); err != nil {
+		t.Error(err)
+	}
+	if requests != n {
+		t.Errorf("Want image config served from the cache")
+	}
+
+	if _, err := i.Inspect(context.Background(), name, "windows/amd64"); err == nil {
+		t.Errorf("Want error for unsupported platform")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("plugins/slack:\n  entrypoint: [ /bin/drone-slack ]\n")
+	f.Close()
+
+	i := New("", "", time.Hour)
+	if err := i.Load(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	config, err := i.Inspect(context.Background(), "docker.io/plugins/slack:latest", "linux/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Entrypoint, []string{"/bin/drone-slack"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want entrypoint %v, got %v", want, got)
+	}
+}
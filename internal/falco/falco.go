@@ -0,0 +1,174 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package falco receives the alerts of the falco node agent,
+// so that steps whose containers perform suspicious system
+// calls or network connections are annotated in the build.
+// The agent posts the alerts with the falco http output.
+package falco
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maximum number of alerts stored for each pipeline pod.
+const maxAlerts = 100
+
+// priorities lists the falco priorities from the highest to
+// the lowest priority.
+var priorities = []string{
+	"emergency",
+	"alert",
+	"critical",
+	"error",
+	"warning",
+	"notice",
+	"informational",
+	"debug",
+}
+
+// Alert is a falco alert.
+type Alert struct {
+	Output   string                 `json:"output"`
+	Priority string                 `json:"priority"`
+	Rule     string                 `json:"rule"`
+	Time     time.Time              `json:"time"`
+	Fields   map[string]interface{} `json:"output_fields"`
+}
+
+// String returns the alert as a line of the step log.
+func (a *Alert) String() string {
+	return fmt.Sprintf("[security] %s %s: %s", a.Priority, a.Rule, a.Output)
+}
+
+// Receiver receives the falco alerts of the pipeline pods that
+// are watched, and discards other alerts.
+type Receiver struct {
+	token    string
+	priority int
+	fail     bool
+
+	mu   sync.Mutex
+	pods map[string][]*Alert
+}
+
+// New returns a new receiver. The receiver requires bearer
+// token authentication, and discards alerts below the minimum
+// priority. If fail is true, steps with alerts fail.
+func New(token, priority string, fail bool) (*Receiver, error) {
+	level := toPriority(priority)
+	if level == -1 {
+		return nil, fmt.Errorf("falco: unknown priority %s", priority)
+	}
+	return &Receiver{
+		token:    token,
+		priority: level,
+		fail:     fail,
+		pods:     map[string][]*Alert{},
+	}, nil
+}
+
+// Fail returns true if steps with alerts fail. A nil receiver
+// returns false.
+func (r *Receiver) Fail() bool {
+	return r != nil && r.fail
+}
+
+// Watch stores the alerts of the pipeline pod until the
+// returned function is called. A nil receiver is a no-op.
+func (r *Receiver) Watch(pod string) func() {
+	if r == nil {
+		return func() {}
+	}
+	r.mu.Lock()
+	r.pods[pod] = nil
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		delete(r.pods, pod)
+		r.mu.Unlock()
+	}
+}
+
+// Alerts returns the alerts of the container of the pipeline
+// pod. A nil receiver returns no alerts.
+func (r *Receiver) Alerts(pod, container string) []*Alert {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var alerts []*Alert
+	for _, alert := range r.pods[pod] {
+		if matchContainer(alert, container) {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// Handler returns an http handler that receives the alerts
+// posted by the falco http output.
+func (r *Receiver) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(r.token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		alert := new(Alert)
+		if err := json.NewDecoder(req.Body).Decode(alert); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.add(alert)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// helper function stores the alert if the pipeline pod is
+// watched and the alert priority is above the minimum.
+func (r *Receiver) add(alert *Alert) {
+	level := toPriority(alert.Priority)
+	if level == -1 || level > r.priority {
+		return
+	}
+	pod, _ := alert.Fields["k8s.pod.name"].(string)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	alerts, ok := r.pods[pod]
+	if !ok || len(alerts) >= maxAlerts {
+		return
+	}
+	r.pods[pod] = append(alerts, alert)
+}
+
+// helper function returns true if the alert was raised by the
+// container. Docker prefixes the kubernetes container names.
+func matchContainer(alert *Alert, container string) bool {
+	name, _ := alert.Fields["container.name"].(string)
+	return name == container || strings.HasPrefix(name, "k8s_"+container+"_")
+}
+
+// helper function returns the priority level, where zero is
+// the highest priority, or -1 if the priority is unknown.
+func toPriority(s string) int {
+	s = strings.ToLower(s)
+	for i, priority := range priorities {
+		if priority == s {
+			return i
+		}
+	}
+	return -1
+}
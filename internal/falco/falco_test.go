@@ -0,0 +1,84 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package falco
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const alertBody = `{
+  "output": "Notice A shell was spawned in a container",
+  "priority": "%s",
+  "rule": "Terminal shell in container",
+  "time": "2019-10-04T18:30:00.000000000Z",
+  "output_fields": {
+    "k8s.pod.name": "drone-pod",
+    "container.name": "k8s_drone-step_drone-pod_default_1234_0"
+  }
+}`
+
+func post(h http.Handler, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/falco", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestReceiver(t *testing.T) {
+	r, err := New("correct-horse-battery-staple", "warning", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := r.Handler()
+
+	// alerts are discarded if the pod is not watched.
+	post(h, r.token, strings.Replace(alertBody, "%s", "Warning", 1))
+	stop := r.Watch("drone-pod")
+	if got := len(r.Alerts("drone-pod", "drone-step")); got != 0 {
+		t.Errorf("Want alerts of unwatched pods discarded, got %d", got)
+	}
+
+	if got, want := post(h, "", alertBody).Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	if got, want := post(h, r.token, strings.Replace(alertBody, "%s", "Warning", 1)).Code, http.StatusNoContent; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	post(h, r.token, strings.Replace(alertBody, "%s", "Notice", 1))
+
+	alerts := r.Alerts("drone-pod", "drone-step")
+	if got, want := len(alerts), 1; got != want {
+		t.Fatalf("Want %d alerts above the minimum priority, got %d", want, got)
+	}
+	if got, want := alerts[0].Rule, "Terminal shell in container"; got != want {
+		t.Errorf("Want rule %s, got %s", want, got)
+	}
+	if got := len(r.Alerts("drone-pod", "drone-other")); got != 0 {
+		t.Errorf("Want no alerts for other containers, got %d", got)
+	}
+
+	stop()
+	if got := len(r.Alerts("drone-pod", "drone-step")); got != 0 {
+		t.Errorf("Want alerts discarded when the pod is no longer watched, got %d", got)
+	}
+}
+
+func TestReceiver_Priority(t *testing.T) {
+	if _, err := New("", "loud", false); err == nil {
+		t.Errorf("Want error for unknown priority")
+	}
+}
+
+func TestReceiver_Nil(t *testing.T) {
+	var r *Receiver
+	r.Watch("drone-pod")()
+	if r.Fail() || r.Alerts("drone-pod", "drone-step") != nil {
+		t.Errorf("Want nil receiver to be a no-op")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package feature provides feature flags that route a
+// percentage of builds through new code paths, so that large
+// changes to the compiler and engine can be rolled out safely.
+// The outcome of builds with and without each feature is
+// recorded in the runner metrics for comparison.
+package feature
+
+import (
+	"expvar"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// metrics records the number of builds and the total build
+// duration for each feature and cohort, keyed by
+// <feature>.<cohort>.<status> and <feature>.<cohort>.seconds.
+var metrics = expvar.NewMap("feature_flags")
+
+// Flags maps the feature name to the percentage of builds
+// for which the feature is enabled.
+type Flags map[string]int
+
+// Enabled returns true if the feature is enabled for the key.
+// The same key is always assigned to the same cohort, so that
+// every stage of a build takes the same code path.
+func (f Flags) Enabled(name, key string) bool {
+	percent := f[name]
+	if percent <= 0 {
+		return false
+	}
+	if percent >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return int(h.Sum32()%100) < percent
+}
+
+// Select returns the sorted names of the features enabled for
+// the key.
+func (f Flags) Select(key string) []string {
+	var names []string
+	for name := range f {
+		if f.Enabled(name, key) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Record records the outcome of a build in the metrics of
+// every feature. Builds with the feature enabled are recorded
+// in the canary cohort, and the other builds in the control
+// cohort.
+func (f Flags) Record(enabled []string, status string, duration time.Duration) {
+	for name := range f {
+		cohort := "control"
+		if Has(enabled, name) {
+			cohort = "canary"
+		}
+		metrics.Add(name+"."+cohort+"."+status, 1)
+		metrics.AddFloat(name+"."+cohort+".seconds", duration.Seconds())
+	}
+}
+
+// Has returns true if the feature is in the list of enabled
+// features.
+func Has(enabled []string, name string) bool {
+	for _, s := range enabled {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package feature
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEnabled(t *testing.T) {
+	flags := Flags{"none": 0, "all": 100, "some": 25}
+	if flags.Enabled("none", "octocat/hello-world/1") {
+		t.Errorf("Expect feature disabled at 0 percent")
+	}
+	if !flags.Enabled("all", "octocat/hello-world/1") {
+		t.Errorf("Expect feature enabled at 100 percent")
+	}
+	if flags.Enabled("unknown", "octocat/hello-world/1") {
+		t.Errorf("Expect unknown feature disabled")
+	}
+
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("octocat/hello-world/%d", i)
+		if flags.Enabled("some", key) != flags.Enabled("some", key) {
+			t.Fatalf("Expect the same key assigned to the same cohort")
+		}
+		if flags.Enabled("some", key) {
+			enabled++
+		}
+	}
+	if enabled < 200 || enabled > 300 {
+		t.Errorf("Want about 250 of 1000 builds enabled, got %d", enabled)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	flags := Flags{"b": 100, "a": 100, "c": 0}
+	got := flags.Select("octocat/hello-world/1")
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Want features [a b], got %v", got)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	flags := Flags{"placeholder": 50}
+	flags.Record([]string{"placeholder"}, "success", 2*time.Second)
+	flags.Record(nil, "failure", time.Second)
+	if got, want := metrics.Get("placeholder.canary.success").String(), "1"; got != want {
+		t.Errorf("Want canary success count %s, got %s", want, got)
+	}
+	if got, want := metrics.Get("placeholder.control.failure").String(), "1"; got != want {
+		t.Errorf("Want control failure count %s, got %s", want, got)
+	}
+	if got, want := metrics.Get("placeholder.canary.seconds").String(), "2"; got != want {
+		t.Errorf("Want canary seconds %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package flaky tracks the outcome of pipeline steps by
+// commit, and reports steps that pass on a commit for which
+// the step failed before, for example when a failed build is
+// restarted. Such steps are likely flaky. The failures are
+// persisted to a local file, so that the tracker survives
+// runner restarts, and the number of runs, failures and flaky
+// passes of each step are published in the runner metrics.
+package flaky
+
+import (
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// metrics records the number of runs, failures and flaky
+// passes of each step, keyed by <repo>/<stage>/<step>.<kind>.
+var metrics = expvar.NewMap("flaky_steps")
+
+// maxAge is the maximum age of a recorded failure. A step
+// that passes after the failure expired is not flaky.
+const maxAge = 7 * 24 * time.Hour
+
+// Tracker tracks the step failures by commit.
+type Tracker struct {
+	path string
+	max  int
+
+	mu       sync.Mutex
+	failures map[string]time.Time
+}
+
+// New returns a new tracker that persists the failures to the
+// file, if not empty, and tracks at most max failures. The
+// oldest failures are forgotten first.
+func New(path string, max int) *Tracker {
+	t := &Tracker{
+		path:     path,
+		max:      max,
+		failures: map[string]time.Time{},
+	}
+	if path != "" {
+		if raw, err := ioutil.ReadFile(path); err == nil {
+			json.Unmarshal(raw, &t.failures)
+		}
+	}
+	return t
+}
+
+// Record records the outcome of the step for the commit, and
+// returns true if the step passed after failing on the same
+// commit. A nil tracker records nothing.
+func (t *Tracker) Record(repo, commit, stage, step string, passed bool) bool {
+	if t == nil || commit == "" {
+		return false
+	}
+	name := repo + "/" + stage + "/" + step
+	key := name + "@" + commit
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	metrics.Add(name+".runs", 1)
+	if !passed {
+		metrics.Add(name+".failures", 1)
+		t.failures[key] = time.Now()
+		t.prune()
+		t.save()
+		return false
+	}
+	failed, ok := t.failures[key]
+	if !ok {
+		return false
+	}
+	delete(t.failures, key)
+	t.save()
+	if time.Since(failed) > maxAge {
+		return false
+	}
+	metrics.Add(name+".flaky", 1)
+	return true
+}
+
+// helper function removes the expired failures, and the
+// oldest failures that exceed the maximum.
+func (t *Tracker) prune() {
+	for key, failed := range t.failures {
+		if time.Since(failed) > maxAge {
+			delete(t.failures, key)
+		}
+	}
+	for t.max > 0 && len(t.failures) > t.max {
+		var oldest string
+		for key, failed := range t.failures {
+			if oldest == "" || failed.Before(t.failures[oldest]) ||
+				(failed.Equal(t.failures[oldest]) && key < oldest) {
+				oldest = key
+			}
+		}
+		delete(t.failures, oldest)
+	}
+}
+
+// helper function writes the failures to the file. The file
+// is replaced atomically, so that a partially written file is
+// never read.
+func (t *Tracker) save() {
+	if t.path == "" {
+		return
+	}
+	raw, _ := json.Marshal(t.failures)
+	tmp := t.path + ".tmp"
+	err := ioutil.WriteFile(tmp, raw, 0600)
+	if err == nil {
+		err = os.Rename(tmp, t.path)
+	}
+	if err != nil {
+		logrus.WithError(err).
+			WithField("path", t.path).
+			Warnln("cannot save the step failures")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package flaky
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	tracker := New("", 0)
+	if tracker.Record("octocat/hello-world", "a1b2c3", "default", "test", true) {
+		t.Errorf("Want step that passed not flaky")
+	}
+	if tracker.Record("octocat/hello-world", "a1b2c3", "default", "test", false) {
+		t.Errorf("Want step that failed not flaky")
+	}
+	if tracker.Record("octocat/hello-world", "d4e5f6", "default", "test", true) {
+		t.Errorf("Want step that passed on another commit not flaky")
+	}
+	if !tracker.Record("octocat/hello-world", "a1b2c3", "default", "test", true) {
+		t.Errorf("Want step that passed on retry flaky")
+	}
+	if tracker.Record("octocat/hello-world", "a1b2c3", "default", "test", true) {
+		t.Errorf("Want step marked flaky once per failure")
+	}
+}
+
+func TestRecord_Persist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "failures.json")
+
+	New(path, 0).Record("octocat/hello-world", "a1b2c3", "default", "test", false)
+	if !New(path, 0).Record("octocat/hello-world", "a1b2c3", "default", "test", true) {
+		t.Errorf("Want failures restored after restart")
+	}
+}
+
+func TestRecord_Max(t *testing.T) {
+	tracker := New("", 1)
+	tracker.Record("octocat/hello-world", "a1b2c3", "default", "test", false)
+	tracker.Record("octocat/hello-world", "d4e5f6", "default", "test", false)
+	if tracker.Record("octocat/hello-world", "a1b2c3", "default", "test", true) {
+		t.Errorf("Want oldest failure forgotten")
+	}
+}
+
+func TestRecord_Nil(t *testing.T) {
+	var tracker *Tracker
+	if tracker.Record("octocat/hello-world", "a1b2c3", "default", "test", true) {
+		t.Errorf("Want nil tracker to record nothing")
+	}
+}
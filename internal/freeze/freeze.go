@@ -0,0 +1,183 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package freeze enforces deployment freeze windows, during
+// which deployment pipelines are rejected or held by the
+// runner, even if the server allows them.
+package freeze
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// defaultEvents provides the build events that are frozen if
+// the window does not list the events.
+var defaultEvents = []string{"promote", "rollback"}
+
+// interval at which a held stage checks whether the freeze
+// window ended.
+var interval = time.Minute
+
+// Window defines a deployment freeze window.
+type Window struct {
+	// Name provides the name of the window.
+	Name string `yaml:"name"`
+
+	// Repos provides a list of repository name patterns that
+	// are frozen. All repositories are frozen if empty.
+	Repos []string `yaml:"repos"`
+
+	// Environments provides a list of deployment environment
+	// patterns that are frozen. All environments are frozen
+	// if empty.
+	Environments []string `yaml:"environments"`
+
+	// Events provides the build events that are frozen, and
+	// defaults to promote and rollback.
+	Events []string `yaml:"events"`
+
+	// Schedule provides a cron expression that matches each
+	// minute of the freeze window, for example "* 16-23 * * 5"
+	// for friday evenings.
+	Schedule string `yaml:"schedule"`
+
+	// Timezone provides the timezone of the schedule, and
+	// defaults to UTC.
+	Timezone string `yaml:"timezone"`
+
+	// Hold holds the stage until the freeze window ends,
+	// instead of rejecting the stage.
+	Hold bool `yaml:"hold"`
+
+	// Message provides the message displayed when a stage is
+	// rejected by the freeze window.
+	Message string `yaml:"message"`
+
+	schedule *schedule
+	location *time.Location
+}
+
+// Error is returned when a stage is rejected by a freeze
+// window.
+type Error struct {
+	Window *Window
+}
+
+func (e *Error) Error() string {
+	if e.Window.Message != "" {
+		return "deployment freeze: " + e.Window.Message
+	}
+	if e.Window.Name != "" {
+		return fmt.Sprintf("deployment freeze: deployments are frozen by the %s window", e.Window.Name)
+	}
+	return "deployment freeze: deployments are frozen"
+}
+
+// Freezer enforces the deployment freeze windows.
+type Freezer struct {
+	windows []*Window
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// New returns a new freezer. An error is returned if a window
+// schedule or timezone is invalid.
+func New(windows []*Window) (*Freezer, error) {
+	for _, window := range windows {
+		if window.Schedule == "" {
+			return nil, errors.New("freeze: schedule is required")
+		}
+		var err error
+		window.schedule, err = parseSchedule(window.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		window.location = time.UTC
+		if window.Timezone != "" {
+			window.location, err = time.LoadLocation(window.Timezone)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Freezer{windows: windows, now: time.Now}, nil
+}
+
+// Wait returns an error if the build is rejected by a freeze
+// window, and blocks while the build is held by a freeze
+// window, or until the context is cancelled. A nil freezer
+// returns immediately.
+func (f *Freezer) Wait(ctx context.Context, repo *drone.Repo, build *drone.Build) error {
+	if f == nil {
+		return nil
+	}
+	for {
+		window := f.find(repo, build)
+		if window == nil {
+			return nil
+		}
+		if !window.Hold {
+			return &Error{Window: window}
+		}
+		now := f.now()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(now.Truncate(interval).Add(interval).Sub(now)):
+		}
+	}
+}
+
+// helper function returns the first freeze window that
+// applies to the build at the current time.
+func (f *Freezer) find(repo *drone.Repo, build *drone.Build) *Window {
+	now := f.now()
+	for _, window := range f.windows {
+		events := window.Events
+		if len(events) == 0 {
+			events = defaultEvents
+		}
+		if !contains(events, build.Event) ||
+			!match(repo.Slug, window.Repos) ||
+			!match(build.Deploy, window.Environments) {
+			continue
+		}
+		if window.schedule.match(now.In(window.location)) {
+			return window
+		}
+	}
+	return nil
+}
+
+// helper function returns true if the value matches a
+// pattern, or if the list of patterns is empty.
+func match(value string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the list contains the
+// value.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package freeze
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// friday evening, 2019-10-04 18:30 UTC.
+var friday = time.Date(2019, 10, 4, 18, 30, 0, 0, time.UTC)
+
+func TestSchedule(t *testing.T) {
+	tests := []struct {
+		expr  string
+		time  time.Time
+		match bool
+	}{
+		{"* * * * *", friday, true},
+		{"* 16-23 * * 5", friday, true},
+		{"* 16-23 * * 5", friday.Add(-24 * time.Hour), false},
+		{"* 0-8 * * *", friday, false},
+		{"*/15 * * * *", friday, true},
+		{"*/20 * * * *", friday, false},
+		{"* * 24-31 12 *", time.Date(2019, 12, 25, 0, 0, 0, 0, time.UTC), true},
+		{"* * 24-31 12 *", friday, false},
+		{"* * * * 0,6", time.Date(2019, 10, 6, 0, 0, 0, 0, time.UTC), true},
+		{"* * * * 7", time.Date(2019, 10, 6, 0, 0, 0, 0, time.UTC), true},
+		// day of month or day of week match if both are set.
+		{"* * 1 * 5", friday, true},
+	}
+	for _, test := range tests {
+		s, err := parseSchedule(test.expr)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got, want := s.match(test.time), test.match; got != want {
+			t.Errorf("Want schedule %q match %v at %s", test.expr, want, test.time)
+		}
+	}
+}
+
+func TestSchedule_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 5-1 * * *",
+		"*/0 * * * *",
+		"* * * jan *",
+	} {
+		if _, err := parseSchedule(expr); err == nil {
+			t.Errorf("Want error parsing schedule %q", expr)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("* 0-6 * * *", "Asia/Tokyo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// 20:00 UTC is 05:00 in Tokyo.
+	if !s.Match(time.Date(2019, 10, 4, 20, 0, 0, 0, time.UTC)) {
+		t.Errorf("Want schedule matched in the timezone")
+	}
+	if s.Match(time.Date(2019, 10, 4, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("Want schedule not matched in the timezone")
+	}
+	if _, err := ParseSchedule("* * * * *", "Mars/Olympus"); err == nil {
+		t.Errorf("Want error parsing invalid timezone")
+	}
+}
+
+func TestFreezer_Reject(t *testing.T) {
+	f, err := New([]*Window{
+		{
+			Repos:        []string{"octocat/*"},
+			Environments: []string{"production"},
+			Schedule:     "* 16-23 * * 5",
+			Message:      "no friday deployments",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	f.now = func() time.Time { return friday }
+
+	repo := &drone.Repo{Slug: "octocat/hello-world"}
+	build := &drone.Build{Event: "promote", Deploy: "production"}
+	err = f.Wait(context.Background(), repo, build)
+	if _, ok := err.(*Error); !ok {
+		t.Errorf("Want deployment rejected, got %v", err)
+	} else if got, want := err.Error(), "deployment freeze: no friday deployments"; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+
+	builds := []*drone.Build{
+		{Event: "push"},
+		{Event: "promote", Deploy: "staging"},
+	}
+	for _, build := range builds {
+		if err := f.Wait(context.Background(), repo, build); err != nil {
+			t.Errorf("Want %s %s not frozen", build.Event, build.Deploy)
+		}
+	}
+	if err := f.Wait(context.Background(), &drone.Repo{Slug: "spaceghost/hello-world"}, build); err != nil {
+		t.Errorf("Want other repositories not frozen")
+	}
+}
+
+func TestFreezer_Hold(t *testing.T) {
+	f, err := New([]*Window{
+		{Schedule: "* 16-23 * * 5", Timezone: "UTC", Hold: true},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	f.now = func() time.Time { return friday }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	repo := &drone.Repo{Slug: "octocat/hello-world"}
+	build := &drone.Build{Event: "rollback"}
+	if err := f.Wait(ctx, repo, build); err != context.DeadlineExceeded {
+		t.Errorf("Want deployment held until the context is cancelled, got %v", err)
+	}
+}
+
+func TestFreezer_Nil(t *testing.T) {
+	var f *Freezer
+	if err := f.Wait(context.Background(), nil, nil); err != nil {
+		t.Errorf("Want nil freezer to return immediately")
+	}
+}
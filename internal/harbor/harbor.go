@@ -0,0 +1,177 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package harbor mints short-lived robot accounts of a harbor
+// registry, scoped to the harbor project of the repository.
+package harbor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+// Config provides the harbor client configuration.
+type Config struct {
+	// Address provides the harbor server address.
+	Address string
+
+	// Username and Password provide the credentials of the
+	// harbor account that creates the robot accounts, which
+	// requires the project admin role.
+	Username string
+	Password string
+
+	// Duration provides the duration of the robot account,
+	// in days. Robot accounts are expected to be revoked
+	// when the pipeline is destroyed, and otherwise expire
+	// after the duration. Defaults to one day.
+	Duration int
+}
+
+// New returns a robot provider that mints harbor robot
+// accounts with push and pull access to the project.
+func New(config Config) engine.RobotProvider {
+	if config.Duration <= 0 {
+		config.Duration = 1
+	}
+	return &client{
+		config: config,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+type client struct {
+	config Config
+	client *http.Client
+}
+
+type (
+	access struct {
+		Resource string `json:"resource"`
+		Action   string `json:"action"`
+	}
+
+	permission struct {
+		Kind      string    `json:"kind"`
+		Namespace string    `json:"namespace"`
+		Access    []*access `json:"access"`
+	}
+
+	robot struct {
+		Name        string        `json:"name"`
+		Description string        `json:"description,omitempty"`
+		Level       string        `json:"level"`
+		Duration    int           `json:"duration"`
+		Permissions []*permission `json:"permissions"`
+	}
+)
+
+// Mint creates a robot account with push and pull access to
+// the project.
+func (c *client) Mint(ctx context.Context, project, name string) (*engine.RobotAccount, error) {
+	body, _ := json.Marshal(&robot{
+		Name:        name,
+		Description: "drone pipeline " + name,
+		Level:       "project",
+		Duration:    c.config.Duration,
+		Permissions: []*permission{
+			{
+				Kind:      "project",
+				Namespace: project,
+				Access: []*access{
+					{Resource: "repository", Action: "push"},
+					{Resource: "repository", Action: "pull"},
+				},
+			},
+		},
+	})
+	req, err := c.request(ctx, "POST", "/api/v2.0/robots", body)
+	if err != nil {
+		return nil, err
+	}
+	out := struct {
+		ID     int64  `json:"id"`
+		Name   string `json:"name"`
+		Secret string `json:"secret"`
+	}{}
+	if err := c.do(req, &out); err != nil {
+		return nil, fmt.Errorf("harbor: cannot create robot account: %s", err)
+	}
+	return &engine.RobotAccount{
+		ID:       strconv.FormatInt(out.ID, 10),
+		Registry: c.registry(),
+		Username: out.Name,
+		Password: out.Secret,
+	}, nil
+}
+
+// Revoke deletes the robot account. Robot accounts that no
+// longer exist are ignored.
+func (c *client) Revoke(ctx context.Context, project, id string) error {
+	req, err := c.request(ctx, "DELETE", "/api/v2.0/robots/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	err = c.do(req, nil)
+	if err == errNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("harbor: cannot delete robot account: %s", err)
+	}
+	return nil
+}
+
+// helper function returns the registry hostname.
+func (c *client) registry() string {
+	u, err := url.Parse(c.config.Address)
+	if err != nil || u.Host == "" {
+		return strings.TrimSuffix(c.config.Address, "/")
+	}
+	return u.Host
+}
+
+var errNotFound = errors.New("not found")
+
+func (c *client) request(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.config.Address, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.config.Username, c.config.Password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req.WithContext(ctx), nil
+}
+
+func (c *client) do(req *http.Request, out interface{}) error {
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package harbor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMint(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "admin" || pass != "correct-horse" {
+			t.Errorf("Want basic auth credentials")
+		}
+		if r.Method != "POST" || r.URL.Path != "/api/v2.0/robots" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		in := new(robot)
+		json.NewDecoder(r.Body).Decode(in)
+		if got, want := in.Name, "drone-abc"; got != want {
+			t.Errorf("Want robot name %q, got %q", want, got)
+		}
+		if len(in.Permissions) != 1 || in.Permissions[0].Namespace != "octocat" {
+			t.Errorf("Want robot scoped to the project")
+		}
+		if got, want := in.Duration, 1; got != want {
+			t.Errorf("Want duration %d, got %d", want, got)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":42,"name":"robot$octocat+drone-abc","secret":"s3cr3t"}`))
+	}))
+	defer ts.Close()
+
+	p := New(Config{Address: ts.URL, Username: "admin", Password: "correct-horse"})
+	account, err := p.Mint(context.Background(), "octocat", "drone-abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := account.ID, "42"; got != want {
+		t.Errorf("Want id %q, got %q", want, got)
+	}
+	if got, want := account.Username, "robot$octocat+drone-abc"; got != want {
+		t.Errorf("Want username %q, got %q", want, got)
+	}
+	if got, want := account.Password, "s3cr3t"; got != want {
+		t.Errorf("Want password %q, got %q", want, got)
+	}
+	if got, want := account.Registry, ts.Listener.Addr().String(); got != want {
+		t.Errorf("Want registry %q, got %q", want, got)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	var deleted string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			t.Errorf("Want delete request, got %s", r.Method)
+		}
+		deleted = r.URL.Path
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	p := New(Config{Address: ts.URL})
+	if err := p.Revoke(context.Background(), "octocat", "42"); err != nil {
+		t.Errorf("Want robot accounts that do not exist ignored, got %s", err)
+	}
+	if got, want := deleted, "/api/v2.0/robots/42"; got != want {
+		t.Errorf("Want path %q, got %q", want, got)
+	}
+}
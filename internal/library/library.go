@@ -0,0 +1,238 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package library resolves pipeline steps that reference
+// reusable step bundles published as oci artifacts.
+package library
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/buildkite/yaml"
+	"github.com/drone/runner-go/manifest"
+)
+
+// Resolver fetches step bundles from oci registries and
+// expands the pipeline steps that reference them.
+type Resolver struct {
+	client     *http.Client
+	scheme     string
+	username   string
+	password   string
+	registries []string
+}
+
+// bundle is a reusable list of pipeline steps.
+type bundle struct {
+	Steps []*resource.Step `json:"steps,omitempty"`
+}
+
+// New returns a new resolver. The registry credentials are
+// optional. If the list of registries is not empty, steps can
+// only reference bundles published to the listed registries.
+func New(username, password string, registries []string) *Resolver {
+	return &Resolver{
+		client:     http.DefaultClient,
+		scheme:     "https",
+		username:   username,
+		password:   password,
+		registries: registries,
+	}
+}
+
+// Expand returns the pipeline with the steps that reference a
+// step bundle replaced by the bundle steps. The pipeline is
+// returned unmodified if no step references a bundle, and a
+// copy is returned otherwise.
+//
+// The referencing step name is used for a single step bundle.
+// The steps of a multi-step bundle are named after the
+// referencing step and the bundle step, and execute in order.
+// The environment, settings, conditions, volumes and
+// dependencies of the referencing step are applied to the
+// bundle steps.
+func (r *Resolver) Expand(ctx context.Context, pipeline *resource.Pipeline) (*resource.Pipeline, error) {
+	if !hasUses(pipeline) {
+		return pipeline, nil
+	}
+
+	// steps that depend on the referencing step depend
+	// on the last step of the expanded bundle.
+	renamed := map[string]string{}
+
+	// steps execute in order unless the pipeline defines a
+	// dependency graph, in which case the bundle steps are
+	// chained to execute in order.
+	graph := hasDependsOn(pipeline)
+
+	var steps []*resource.Step
+	for _, step := range pipeline.Steps {
+		if step.Uses == "" {
+			steps = append(steps, step)
+			continue
+		}
+		expanded, err := r.expand(ctx, step, graph)
+		if err != nil {
+			return nil, err
+		}
+		renamed[step.Name] = expanded[len(expanded)-1].Name
+		steps = append(steps, expanded...)
+	}
+
+	names := map[string]struct{}{}
+	for _, step := range steps {
+		if _, ok := names[step.Name]; ok {
+			return nil, fmt.Errorf("library: duplicate step name: %s", step.Name)
+		}
+		names[step.Name] = struct{}{}
+	}
+	for i, step := range steps {
+		if !dependsOnRenamed(step, renamed) {
+			continue
+		}
+		dst := *step
+		dst.DependsOn = nil
+		for _, name := range step.DependsOn {
+			if v, ok := renamed[name]; ok {
+				name = v
+			}
+			dst.DependsOn = append(dst.DependsOn, name)
+		}
+		steps[i] = &dst
+	}
+
+	out := *pipeline
+	out.Steps = steps
+	return &out, nil
+}
+
+// helper function fetches the step bundle referenced by the
+// step and returns the expanded steps.
+func (r *Resolver) expand(ctx context.Context, src *resource.Step, graph bool) ([]*resource.Step, error) {
+	ref, err := parseReference(src.Uses)
+	if err != nil {
+		return nil, err
+	}
+	if !r.isAllowed(ref.Registry) {
+		return nil, fmt.Errorf("library: registry not allowed: %s", ref.Registry)
+	}
+	raw, err := r.fetch(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	b := new(bundle)
+	if err := yaml.Unmarshal(raw, b); err != nil {
+		return nil, fmt.Errorf("library: cannot parse step bundle %s: %s", ref, err)
+	}
+	if len(b.Steps) == 0 {
+		return nil, fmt.Errorf("library: step bundle has no steps: %s", ref)
+	}
+
+	var steps []*resource.Step
+	for i, step := range b.Steps {
+		if step == nil || step.Name == "" {
+			return nil, fmt.Errorf("library: invalid or missing step name in %s", ref)
+		}
+		if step.Uses != "" {
+			return nil, fmt.Errorf("library: step bundle cannot reference a step bundle: %s", ref)
+		}
+		dst := *step
+		dst.Name = src.Name
+		if len(b.Steps) > 1 {
+			dst.Name = src.Name + "-" + step.Name
+		}
+		dst.When = src.When
+		dst.Failure = src.Failure
+		dst.Volumes = append(dst.Volumes, src.Volumes...)
+		dst.Environment = merge(step.Environment, src.Environment)
+		dst.Settings = mergeSettings(step.Settings, src.Settings)
+		switch {
+		case i == 0:
+			dst.DependsOn = src.DependsOn
+		case graph:
+			dst.DependsOn = []string{steps[i-1].Name}
+		default:
+			dst.DependsOn = nil
+		}
+		steps = append(steps, &dst)
+	}
+	return steps, nil
+}
+
+// helper function returns true if the registry is allowed.
+func (r *Resolver) isAllowed(registry string) bool {
+	if len(r.registries) == 0 {
+		return true
+	}
+	for _, allowed := range r.registries {
+		if strings.EqualFold(allowed, registry) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if any pipeline step
+// references a step bundle.
+func hasUses(pipeline *resource.Pipeline) bool {
+	for _, step := range pipeline.Steps {
+		if step.Uses != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if any pipeline step defines
+// dependencies.
+func hasDependsOn(pipeline *resource.Pipeline) bool {
+	for _, step := range pipeline.Steps {
+		if len(step.DependsOn) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the bundle variables with the step
+// variables applied.
+func merge(bundle, step map[string]*manifest.Variable) map[string]*manifest.Variable {
+	out := map[string]*manifest.Variable{}
+	for k, v := range bundle {
+		out[k] = v
+	}
+	for k, v := range step {
+		out[k] = v
+	}
+	return out
+}
+
+// helper function returns the bundle settings with the step
+// settings applied.
+func mergeSettings(bundle, step map[string]*manifest.Parameter) map[string]*manifest.Parameter {
+	out := map[string]*manifest.Parameter{}
+	for k, v := range bundle {
+		out[k] = v
+	}
+	for k, v := range step {
+		out[k] = v
+	}
+	return out
+}
+
+// helper function returns true if the step depends on a step
+// that is renamed.
+func dependsOnRenamed(step *resource.Step, renamed map[string]string) bool {
+	for _, name := range step.DependsOn {
+		if v, ok := renamed[name]; ok && v != name {
+			return true
+		}
+	}
+	return false
+}
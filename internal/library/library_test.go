@@ -0,0 +1,178 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package library
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+)
+
+const testBundle = `
+steps:
+- name: lint
+  image: golangci/golangci-lint
+  commands:
+  - golangci-lint run
+- name: test
+  image: golang
+  commands:
+  - go test ./...
+`
+
+// helper function returns a test registry that serves the
+// step bundle, and requires a bearer token.
+func testRegistry(bundle string) *httptest.Server {
+	blob := digest([]byte(bundle))
+	manifest := fmt.Sprintf(`{"mediaType":%q,"layers":[{"mediaType":%q,"digest":%q,"size":%d}]}`,
+		mediaTypeManifest, mediaTypeBundle, blob, len(bundle))
+
+	mux := http.NewServeMux()
+	var server *httptest.Server
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.FormValue("scope"); got != "repository:steps/go:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"token":"secret"}`)
+	})
+	mux.HandleFunc("/v2/steps/go/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/steps/go/manifests/v1", "/v2/steps/go/manifests/" + digest([]byte(manifest)):
+			fmt.Fprint(w, manifest)
+		case "/v2/steps/go/blobs/" + blob:
+			fmt.Fprint(w, bundle)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func testResolver() *Resolver {
+	r := New("", "", nil)
+	r.scheme = "http"
+	return r
+}
+
+func TestExpand(t *testing.T) {
+	server := testRegistry(testBundle)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	pipeline := &resource.Pipeline{
+		Steps: []*resource.Step{
+			{Name: "build", Image: "golang"},
+			{Name: "check", Uses: "oci://" + host + "/steps/go:v1", DependsOn: []string{"build"}},
+			{Name: "publish", Image: "plugins/docker", DependsOn: []string{"check"}},
+		},
+	}
+	got, err := testResolver().Expand(context.Background(), pipeline)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var names []string
+	for _, step := range got.Steps {
+		names = append(names, step.Name+":"+strings.Join(step.DependsOn, ","))
+	}
+	want := "build:,check-lint:build,check-test:check-lint,publish:check-test"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("Want expanded steps %s, got %s", want, got)
+	}
+	if pipeline.Steps[2].DependsOn[0] != "check" {
+		t.Errorf("Expect the source pipeline is not modified")
+	}
+}
+
+func TestExpand_Digest(t *testing.T) {
+	server := testRegistry(testBundle)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	pipeline := &resource.Pipeline{
+		Steps: []*resource.Step{
+			{Name: "check", Uses: "oci://" + host + "/steps/go@sha256:0000"},
+		},
+	}
+	_, err := testResolver().Expand(context.Background(), pipeline)
+	if err == nil {
+		t.Errorf("Expect error when the manifest does not match the digest")
+	}
+}
+
+func TestExpand_Registries(t *testing.T) {
+	r := New("", "", []string{"registry.company.com"})
+	pipeline := &resource.Pipeline{
+		Steps: []*resource.Step{
+			{Name: "check", Uses: "oci://docker.io/steps/go:v1"},
+		},
+	}
+	_, err := r.Expand(context.Background(), pipeline)
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Want registry not allowed error, got %v", err)
+	}
+}
+
+func TestExpand_NoUses(t *testing.T) {
+	pipeline := &resource.Pipeline{
+		Steps: []*resource.Step{{Name: "build", Image: "golang"}},
+	}
+	got, err := New("", "", nil).Expand(context.Background(), pipeline)
+	if err != nil {
+		t.Error(err)
+	}
+	if got != pipeline {
+		t.Errorf("Expect pipeline returned unmodified")
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		in   string
+		want reference
+	}{
+		{
+			in:   "oci://registry.company.com/steps/build:v1",
+			want: reference{Registry: "registry.company.com", Repository: "steps/build", Tag: "v1"},
+		},
+		{
+			in:   "oci://localhost:5000/steps/build",
+			want: reference{Registry: "localhost:5000", Repository: "steps/build", Tag: "latest"},
+		},
+		{
+			in:   "oci://registry.company.com/steps/build@sha256:abc",
+			want: reference{Registry: "registry.company.com", Repository: "steps/build", Digest: "sha256:abc"},
+		},
+	}
+	for _, test := range tests {
+		got, err := parseReference(test.in)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Want reference %+v, got %+v", test.want, got)
+		}
+	}
+
+	for _, in := range []string{"docker://golang", "oci://golang", "oci://registry/steps@md5:abc"} {
+		if _, err := parseReference(in); err == nil {
+			t.Errorf("Expect error parsing reference %s", in)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package library
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// media types of the step bundle artifact.
+const (
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeBundle   = "application/vnd.drone.step.bundle.v1+yaml"
+)
+
+// maximum size of a manifest or step bundle, in bytes.
+const maxSize = 1 << 20
+
+// errDigestMismatch is returned when the content does not
+// match the expected digest.
+var errDigestMismatch = errors.New("library: content does not match digest")
+
+// reference identifies a step bundle in an oci registry.
+type reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// String returns the reference in the oci:// format.
+func (r reference) String() string {
+	s := "oci://" + r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// ociManifest is the subset of the oci image manifest used to
+// find the step bundle layer.
+type ociManifest struct {
+	MediaType string `json:"mediaType"`
+	Layers    []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Size      int64  `json:"size"`
+	} `json:"layers"`
+}
+
+// helper function parses the step bundle reference, for
+// example oci://registry.company.com/steps/build:v1.
+func parseReference(s string) (reference, error) {
+	var ref reference
+	if !strings.HasPrefix(s, "oci://") {
+		return ref, fmt.Errorf("library: invalid reference: %s", s)
+	}
+	orig := s
+	s = strings.TrimPrefix(s, "oci://")
+	if i := strings.Index(s, "@"); i != -1 {
+		ref.Digest = s[i+1:]
+		s = s[:i]
+		if !strings.HasPrefix(ref.Digest, "sha256:") {
+			return ref, fmt.Errorf("library: unsupported digest: %s", ref.Digest)
+		}
+	}
+	i := strings.Index(s, "/")
+	if i == -1 {
+		return ref, fmt.Errorf("library: invalid reference: %s", orig)
+	}
+	ref.Registry, s = s[:i], s[i+1:]
+	if i := strings.LastIndex(s, ":"); i != -1 {
+		ref.Tag, s = s[i+1:], s[:i]
+	}
+	ref.Repository = s
+	if ref.Registry == "" || ref.Repository == "" {
+		return ref, fmt.Errorf("library: invalid reference: %s", orig)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+// helper function fetches the step bundle from the registry.
+// The manifest is verified if the reference includes a digest,
+// and the bundle is verified against the manifest digest.
+func (r *Resolver) fetch(ctx context.Context, ref reference) ([]byte, error) {
+	version := ref.Digest
+	if version == "" {
+		version = ref.Tag
+	}
+	raw, err := r.get(ctx, ref, "manifests/"+version, mediaTypeManifest)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Digest != "" && digest(raw) != ref.Digest {
+		return nil, errDigestMismatch
+	}
+	m := new(ociManifest)
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, err
+	}
+	for _, layer := range m.Layers {
+		if layer.MediaType != mediaTypeBundle {
+			continue
+		}
+		if layer.Size > maxSize {
+			return nil, fmt.Errorf("library: step bundle exceeds %d bytes", maxSize)
+		}
+		blob, err := r.get(ctx, ref, "blobs/"+layer.Digest, "")
+		if err != nil {
+			return nil, err
+		}
+		if digest(blob) != layer.Digest {
+			return nil, errDigestMismatch
+		}
+		return blob, nil
+	}
+	return nil, fmt.Errorf("library: no step bundle found in %s", ref)
+}
+
+// helper function gets the registry resource. If the registry
+// requires a bearer token, the token is requested and the
+// request is retried.
+func (r *Resolver) get(ctx context.Context, ref reference, path, accept string) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s://%s/v2/%s/%s", r.scheme, ref.Registry, ref.Repository, path)
+	res, err := r.do(ctx, endpoint, accept, "")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("Www-Authenticate")
+		res.Body.Close()
+		token, err := r.token(ctx, challenge, ref)
+		if err != nil {
+			return nil, err
+		}
+		res, err = r.do(ctx, endpoint, accept, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("library: registry returned status %d for %s", res.StatusCode, ref)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(res.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxSize {
+		return nil, fmt.Errorf("library: response exceeds %d bytes", maxSize)
+	}
+	return raw, nil
+}
+
+// helper function sends the request with the optional bearer
+// token.
+func (r *Resolver) do(ctx context.Context, endpoint, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r.client.Do(req)
+}
+
+// helper function requests a bearer token with pull access to
+// the repository, using the realm and service of the registry
+// authentication challenge.
+func (r *Resolver) token(ctx context.Context, challenge string, ref reference) (string, error) {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("library: registry authentication required for %s", ref)
+	}
+	query := url.Values{}
+	query.Set("scope", "repository:"+ref.Repository+":pull")
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	req, err := http.NewRequest("GET", realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return "", fmt.Errorf("library: token endpoint returned status %d", res.StatusCode)
+	}
+	out := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if out.Token != "" {
+		return out.Token, nil
+	}
+	return out.AccessToken, nil
+}
+
+// helper function parses the parameters of a bearer
+// authentication challenge, for example:
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(s string) map[string]string {
+	params := map[string]string{}
+	if !strings.HasPrefix(strings.ToLower(s), "bearer ") {
+		return params
+	}
+	for _, part := range strings.Split(s[len("bearer "):], ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return params
+}
+
+// helper function returns the sha256 digest of the content.
+func digest(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
@@ -0,0 +1,353 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package livelog provides a log writer that streams log lines
+// to the server, and bounds the memory used to buffer the full
+// log by spilling the overflow to a temporary file.
+package livelog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+)
+
+// endpoint used to upload the full log.
+const endpointUpload = "/rpc/v2/step/%d/logs/upload"
+
+// default interval at which pending lines are sent to the
+// server.
+const defaultInterval = time.Second
+
+// maximum size of the lines pending to be sent to the server.
+// The oldest pending lines are dropped if the server does not
+// keep up with the step output, because the live log stream
+// is ephemeral and the full log is uploaded when the step
+// completes.
+const maxPending = 1 << 20
+
+// Writer is an io.WriteCloser that sends log lines to the server
+// in batches, and uploads the full log when closed. The full log
+// is buffered in memory up to the buffer size, and the overflow
+// is spilled to a temporary file, so that the memory used by
+// verbose steps is bounded.
+type Writer struct {
+	sync.Mutex
+
+	client client.Client
+	id     int64
+	num    int
+	now    time.Time
+
+	interval time.Duration
+	buffer   int
+	limit    int
+	dir      string
+
+	size        int
+	memsize     int
+	pending     []*drone.Line
+	pendingsize int
+	history     []*drone.Line
+
+	spill   *os.File
+	spilled bool
+
+	closed bool
+	close  chan struct{}
+	ready  chan struct{}
+}
+
+// New returns a new Writer for the step. The buffer provides the
+// maximum size of the log buffered in memory, and the limit
+// provides the maximum size of the log, after which the log is
+// truncated. The overflow is spilled to a temporary file in the
+// directory dir, or the default temporary directory if empty.
+// A zero buffer or limit is unlimited.
+func New(client client.Client, id int64, buffer, limit int, dir string) *Writer {
+	return &Writer{
+		client:   client,
+		id:       id,
+		now:      time.Now(),
+		interval: defaultInterval,
+		buffer:   buffer,
+		limit:    limit,
+		dir:      dir,
+		close:    make(chan struct{}),
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// Write uploads the live log stream to the server.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	for _, part := range split(p) {
+		if w.limit > 0 && w.size+len(part) > w.limit {
+			// the log is truncated once the limit is exceeded,
+			// and streaming stops.
+			w.stop()
+			break
+		}
+		line := &drone.Line{
+			Number:    w.num,
+			Message:   part,
+			Timestamp: int64(time.Since(w.now).Seconds()),
+		}
+		w.size = w.size + len(part)
+		w.num++
+
+		w.Lock()
+		if w.buffer > 0 && w.memsize+len(part) > w.buffer {
+			w.spillHistory()
+		}
+		if !w.closed {
+			w.pending = append(w.pending, line)
+			w.pendingsize = w.pendingsize + len(part)
+			for w.pendingsize > maxPending && len(w.pending) > 1 {
+				w.pendingsize = w.pendingsize - len(w.pending[0].Message)
+				w.pending[0] = nil
+				w.pending = w.pending[1:]
+			}
+		}
+		w.history = append(w.history, line)
+		w.memsize = w.memsize + len(part)
+		w.Unlock()
+
+		if !w.stopped() {
+			select {
+			case w.ready <- struct{}{}:
+			default:
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Close closes the writer and uploads the full log to the
+// server, including the lines spilled to disk.
+func (w *Writer) Close() error {
+	if w.stop() {
+		w.flush()
+	}
+	return w.upload()
+}
+
+// Start starts a periodic loop to flush log lines to the server.
+func (w *Writer) Start() {
+	for {
+		select {
+		case <-w.close:
+			return
+		case <-w.ready:
+			select {
+			case <-w.close:
+				return
+			case <-time.After(w.interval):
+				// we intentionally ignore errors. log streams
+				// are ephemeral and are considered low priority
+				// because they are not required for drone to
+				// operate, and the impact of failure is minimal.
+				w.flush()
+			}
+		}
+	}
+}
+
+// helper function writes the lines buffered in memory to the
+// spill file, and releases the memory. If the spill file cannot
+// be written, the lines remain in memory.
+func (w *Writer) spillHistory() {
+	if w.spill == nil && !w.spilled {
+		w.spilled = true
+		f, err := ioutil.TempFile(w.dir, "drone-log-")
+		if err != nil {
+			return
+		}
+		w.spill = f
+	}
+	if w.spill == nil {
+		return
+	}
+	enc := json.NewEncoder(w.spill)
+	for _, line := range w.history {
+		if err := enc.Encode(line); err != nil {
+			return
+		}
+	}
+	w.history = nil
+	w.memsize = 0
+}
+
+// helper function sends the pending lines to the server.
+func (w *Writer) flush() error {
+	w.Lock()
+	lines := w.pending
+	w.pending = nil
+	w.pendingsize = 0
+	w.Unlock()
+	if len(lines) == 0 {
+		return nil
+	}
+	return w.client.Batch(context.Background(), w.id, lines)
+}
+
+// helper function uploads the full log to the server, and
+// removes the spill file. If the client is the http client,
+// the log is streamed from the spill file, so that the full
+// log is not loaded into memory.
+func (w *Writer) upload() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.spill != nil {
+		defer func() {
+			w.spill.Close()
+			os.Remove(w.spill.Name())
+			w.spill = nil
+		}()
+		if _, err := w.spill.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		w.history = nil
+		w.memsize = 0
+	}()
+	if c, ok := w.client.(*client.HTTPClient); ok {
+		return w.uploadStream(c)
+	}
+
+	var lines []*drone.Line
+	if w.spill != nil {
+		dec := json.NewDecoder(w.spill)
+		for {
+			line := new(drone.Line)
+			err := dec.Decode(line)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, w.history...)
+	return w.client.Upload(context.Background(), w.id, lines)
+}
+
+// helper function streams the full log to the server. The log
+// is encoded as a json array, line by line, from the spill file
+// followed by the lines buffered in memory.
+func (w *Writer) uploadStream(c *client.HTTPClient) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(w.encode(pw))
+	}()
+	defer pr.Close()
+
+	uri := c.Endpoint + fmt.Sprintf(endpointUpload, w.id)
+	req, err := http.NewRequest("POST", uri, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("X-Drone-Token", c.Secret)
+	req.Header.Add("Content-Type", "application/json")
+
+	httpClient := c.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return fmt.Errorf("livelog: cannot upload the log: %s", res.Status)
+	}
+	return nil
+}
+
+// helper function writes the full log to the writer as a json
+// array.
+func (w *Writer) encode(out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+	first := true
+	write := func(line *drone.Line) error {
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		return enc.Encode(line)
+	}
+	bw.WriteByte('[')
+	if w.spill != nil {
+		dec := json.NewDecoder(w.spill)
+		for {
+			line := new(drone.Line)
+			err := dec.Decode(line)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := write(line); err != nil {
+				return err
+			}
+		}
+	}
+	for _, line := range w.history {
+		if err := write(line); err != nil {
+			return err
+		}
+	}
+	bw.WriteByte(']')
+	return bw.Flush()
+}
+
+func (w *Writer) stop() bool {
+	w.Lock()
+	var closed bool
+	if !w.closed {
+		close(w.close)
+		closed = true
+		w.closed = true
+	}
+	w.Unlock()
+	return closed
+}
+
+func (w *Writer) stopped() bool {
+	w.Lock()
+	closed := w.closed
+	w.Unlock()
+	return closed
+}
+
+// helper function splits the output into multiple lines.
+// kubernetes buffers the output and may combine multiple
+// lines into a single block of output.
+func split(p []byte) []string {
+	s := string(p)
+	v := []string{s}
+	if strings.Contains(strings.TrimSuffix(s, "\n"), "\n") {
+		v = strings.SplitAfter(s, "\n")
+		if v[len(v)-1] == "" {
+			v = v[:len(v)-1]
+		}
+	}
+	return v
+}
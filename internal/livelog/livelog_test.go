@@ -0,0 +1,160 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package livelog
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+)
+
+type fakeClient struct {
+	client.Client
+
+	uploads [][]*drone.Line
+}
+
+func (c *fakeClient) Batch(ctx context.Context, step int64, lines []*drone.Line) error {
+	return nil
+}
+
+func (c *fakeClient) Upload(ctx context.Context, step int64, lines []*drone.Line) error {
+	c.uploads = append(c.uploads, lines)
+	return nil
+}
+
+func TestWriter_Spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "livelog")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	fake := new(fakeClient)
+	w := New(fake, 1, 10, 0, dir)
+	w.Write([]byte("hello\nworld\n"))
+	w.Write([]byte("foo\nbar\n"))
+
+	if w.spill == nil {
+		t.Errorf("Want the overflow spilled to disk")
+	}
+	if w.memsize > 10 {
+		t.Errorf("Want the memory buffer bounded, got %d bytes", w.memsize)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(fake.uploads) != 1 {
+		t.Errorf("Want the full log uploaded")
+		return
+	}
+	want := []string{"hello\n", "world\n", "foo\n", "bar\n"}
+	got := fake.uploads[0]
+	if len(got) != len(want) {
+		t.Errorf("Want %d lines uploaded, got %d", len(want), len(got))
+		return
+	}
+	for i, line := range got {
+		if line.Number != i || line.Message != want[i] {
+			t.Errorf("Unexpected line %d: %d %q", i, line.Number, line.Message)
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Want the spill file removed")
+	}
+}
+
+func TestWriter_Limit(t *testing.T) {
+	fake := new(fakeClient)
+	w := New(fake, 1, 0, 10, "")
+	w.Write([]byte("hello\nworld\nfoo\n"))
+	w.Close()
+
+	if len(fake.uploads) != 1 || len(fake.uploads[0]) != 1 {
+		t.Errorf("Want the log truncated at the limit")
+	}
+	if w.spill != nil {
+		t.Errorf("Want no spill file if the buffer is unlimited")
+	}
+}
+
+func TestWriter_UploadStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "livelog")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	var got []*drone.Line
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rpc/v2/step/1/logs/upload" {
+			w.WriteHeader(404)
+			return
+		}
+		if r.Header.Get("X-Drone-Token") != "correct-horse" {
+			w.WriteHeader(401)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	w := New(client.New(server.URL, "correct-horse", false), 1, 10, 0, dir)
+	w.stop()
+	w.Write([]byte("hello\nworld\n"))
+	w.Write([]byte("foo\nbar\n"))
+	if err := w.upload(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []string{"hello\n", "world\n", "foo\n", "bar\n"}
+	if len(got) != len(want) {
+		t.Errorf("Want %d lines uploaded, got %d", len(want), len(got))
+		return
+	}
+	for i, line := range got {
+		if line.Number != i || line.Message != want[i] {
+			t.Errorf("Unexpected line %d: %d %q", i, line.Number, line.Message)
+		}
+	}
+}
+
+func TestWriter_Pending(t *testing.T) {
+	fake := new(fakeClient)
+	w := New(fake, 1, 0, 0, "")
+	line := strings.Repeat("a", maxPending/2) + "\n"
+	w.Write([]byte(line))
+	w.Write([]byte(line))
+	w.Write([]byte(line))
+
+	if got, want := len(w.pending), 1; got != want {
+		t.Errorf("Want the oldest pending lines dropped, got %d lines", got)
+	}
+	if w.pendingsize > maxPending {
+		t.Errorf("Want the pending lines bounded, got %d bytes", w.pendingsize)
+	}
+	if got, want := len(w.history), 3; got != want {
+		t.Errorf("Want all lines kept for the upload, got %d lines", got)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package livelog
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/drone/runner-go/client"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// Streamer is a pipeline.Streamer that streams the step logs to
+// the server with a bounded memory buffer for each step.
+type Streamer struct {
+	client client.Client
+	buffer int
+	limit  int
+	dir    string
+}
+
+// NewStreamer returns a new streamer. The buffer provides the
+// maximum size of the log buffered in memory for each step, the
+// limit provides the maximum size of the step log, and the dir
+// provides the directory of the spill files.
+func NewStreamer(client client.Client, buffer, limit int, dir string) *Streamer {
+	return &Streamer{
+		client: client,
+		buffer: buffer,
+		limit:  limit,
+		dir:    dir,
+	}
+}
+
+// Stream returns an io.WriteCloser that streams the log of the
+// named step to the server.
+func (s *Streamer) Stream(ctx context.Context, state *pipeline.State, name string) io.WriteCloser {
+	state.Lock()
+	var id int64
+	for _, step := range state.Stage.Steps {
+		if step.Name == name {
+			id = step.ID
+		}
+	}
+	state.Unlock()
+	if id == 0 {
+		return nopCloser{ioutil.Discard}
+	}
+	w := New(s.client, id, s.buffer, s.limit, s.dir)
+	go w.Start()
+	return w
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -6,6 +6,7 @@ package match
 
 import (
 	"path/filepath"
+	"strings"
 
 	"github.com/drone/drone-go/drone"
 )
@@ -16,6 +17,10 @@ import (
 // The matching function is a last line of defence to prevent
 // unauthorized code from running on the host machine.
 
+// build parameter that provides the comma-separated list of
+// pull request labels.
+const labelsParam = "DRONE_PULL_REQUEST_LABELS"
+
 // Func returns a new match function that returns true if the
 // repository and build do not match the allowd repository names
 // and build events.
@@ -36,6 +41,28 @@ func Func(repos, events []string, trusted bool) func(*drone.Repo, *drone.Build)
 	}
 }
 
+// Approved returns a new match function that returns true if
+// the build is not a pull request from a forked repository, or
+// if the pull request has the approval label. Pull request
+// labels are sourced from the comma-separated labels build
+// parameter.
+func Approved(label string) func(*drone.Repo, *drone.Build) bool {
+	return func(repo *drone.Repo, build *drone.Build) bool {
+		if label == "" {
+			return true
+		}
+		if build.Event != drone.EventPullRequest || build.Fork == "" || build.Fork == repo.Slug {
+			return true
+		}
+		for _, s := range strings.Split(build.Params[labelsParam], ",") {
+			if strings.TrimSpace(s) == label {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 func match(s string, patterns []string) bool {
 	// if no matching patterns are defined the string
 	// is always considered a match.
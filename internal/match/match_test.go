@@ -123,3 +123,37 @@ func TestFunc(t *testing.T) {
 		}
 	}
 }
+
+func TestApproved(t *testing.T) {
+	tests := []struct {
+		event  string
+		fork   string
+		labels string
+		match  bool
+	}{
+		{event: "push", match: true},
+		{event: "pull_request", fork: "octocat/hello-world", match: true},
+		{event: "pull_request", fork: "spaceghost/hello-world", match: false},
+		{event: "pull_request", fork: "spaceghost/hello-world", labels: "bug, ok-to-test", match: true},
+		{event: "pull_request", fork: "spaceghost/hello-world", labels: "bug", match: false},
+	}
+
+	matcher := Approved("ok-to-test")
+	for i, test := range tests {
+		repo := &drone.Repo{
+			Slug: "octocat/hello-world",
+		}
+		build := &drone.Build{
+			Event:  test.event,
+			Fork:   test.fork,
+			Params: map[string]string{"DRONE_PULL_REQUEST_LABELS": test.labels},
+		}
+		if got := matcher(repo, build); got != test.match {
+			t.Errorf("Expect match %v at index %d", test.match, i)
+		}
+	}
+
+	if !Approved("")(&drone.Repo{}, &drone.Build{Event: "pull_request", Fork: "spaceghost/hello-world"}) {
+		t.Errorf("Expect match when no approval label is configured")
+	}
+}
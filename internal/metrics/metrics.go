@@ -0,0 +1,267 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package metrics provides counters, gauges and histograms that
+// are exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets provides the default histogram buckets, in
+// seconds, suitable for kubernetes api latencies.
+var DefaultBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// registry of the metrics exposed by the handler.
+var registry = struct {
+	sync.Mutex
+	metrics []metric
+}{}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+func register(m metric) {
+	registry.Lock()
+	registry.metrics = append(registry.metrics, m)
+	registry.Unlock()
+}
+
+// Handler returns an http.Handler that writes the metrics in
+// the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		registry.Lock()
+		metrics := append([]metric(nil), registry.metrics...)
+		registry.Unlock()
+		sort.Slice(metrics, func(i, j int) bool {
+			return metrics[i].name() < metrics[j].name()
+		})
+		for _, m := range metrics {
+			m.write(w)
+		}
+	})
+}
+
+// vec stores the values of a metric by label values.
+type vec struct {
+	fname  string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string][]string
+}
+
+func newVec(name, help, kind string, labels []string) vec {
+	return vec{
+		fname:  name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string][]string{},
+	}
+}
+
+func (v *vec) name() string { return v.fname }
+
+// helper function returns the series key of the label values,
+// and records the label values of the series. The caller must
+// hold the lock.
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.fname, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	if _, ok := v.series[key]; !ok {
+		v.series[key] = append([]string(nil), values...)
+	}
+	return key
+}
+
+// helper function returns the sorted series keys. The caller
+// must hold the lock.
+func (v *vec) keys() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.fname, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.fname, v.kind)
+}
+
+// helper function formats the labels of the series, with the
+// optional extra label.
+func (v *vec) format(key string, extra ...string) string {
+	var pairs []string
+	for i, value := range v.series[key] {
+		pairs = append(pairs, v.labels[i]+"="+quote(value))
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, extra[0]+"="+quote(extra[1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter is a cumulative metric that only increases.
+type Counter struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounter registers and returns a counter with the label
+// names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		vec:    newVec(name, help, "counter", labels),
+		values: map[string]float64{},
+	}
+	register(c)
+	return c
+}
+
+// Inc increments the counter of the label values by one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds the delta to the counter of the label values.
+func (c *Counter) Add(delta float64, values ...string) {
+	c.mu.Lock()
+	c.values[c.key(values)] += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range c.keys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.fname, c.format(key), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge is a metric that can increase and decrease.
+type Gauge struct {
+	Counter
+}
+
+// NewGauge registers and returns a gauge with the label names.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{
+		Counter: Counter{
+			vec:    newVec(name, help, "gauge", labels),
+			values: map[string]float64{},
+		},
+	}
+	register(g)
+	return g
+}
+
+// Dec decrements the gauge of the label values by one.
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+// Set sets the gauge of the label values.
+func (g *Gauge) Set(value float64, values ...string) {
+	g.mu.Lock()
+	g.values[g.key(values)] = value
+	g.mu.Unlock()
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	vec
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+}
+
+// NewHistogram registers and returns a histogram with the
+// upper bounds of the buckets, in increasing order, and the
+// label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vec:     newVec(name, help, "histogram", labels),
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+	}
+	register(h)
+	return h
+}
+
+// Observe adds the observation to the histogram of the label
+// values.
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(values)
+	counts, ok := h.counts[key]
+	if !ok {
+		// the last count is the +Inf bucket.
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[key] = counts
+	}
+	i := sort.SearchFloat64s(h.buckets, value)
+	counts[i]++
+	h.sums[key] += value
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, key := range h.keys() {
+		var total uint64
+		for i, count := range h.counts[key] {
+			total += count
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fname, h.format(key, "le", formatFloat(le)), total)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fname, h.format(key), formatFloat(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fname, h.format(key), total)
+	}
+}
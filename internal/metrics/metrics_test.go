@@ -0,0 +1,63 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	counter := NewCounter("test_errors_total", "Total errors.", "code")
+	counter.Inc("EXEC_DISCONNECTED")
+	counter.Add(2, `quote"d`)
+
+	gauge := NewGauge("test_waiting", "Waiting steps.")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+
+	histogram := NewHistogram("test_duration_seconds", "Duration.", []float64{1, 5})
+	histogram.Observe(0.5)
+	histogram.Observe(1)
+	histogram.Observe(10)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := strings.Join([]string{
+		"# HELP test_duration_seconds Duration.",
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{le="1"} 2`,
+		`test_duration_seconds_bucket{le="5"} 2`,
+		`test_duration_seconds_bucket{le="+Inf"} 3`,
+		"test_duration_seconds_sum 11.5",
+		"test_duration_seconds_count 3",
+		"# HELP test_errors_total Total errors.",
+		"# TYPE test_errors_total counter",
+		`test_errors_total{code="EXEC_DISCONNECTED"} 1`,
+		`test_errors_total{code="quote\"d"} 2`,
+		"# HELP test_waiting Waiting steps.",
+		"# TYPE test_waiting gauge",
+		"test_waiting 1",
+		"",
+	}, "\n")
+	if got := rec.Body.String(); got != want {
+		t.Errorf("Want metrics\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Want panic for missing label values")
+		}
+	}()
+	c := &Counter{vec: newVec("test", "", "counter", []string{"code"}), values: map[string]float64{}}
+	c.Inc()
+	c.write(new(bytes.Buffer))
+}
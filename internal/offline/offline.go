@@ -0,0 +1,66 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package offline verifies that images and endpoints are
+// hosted by internal domains, so that pipelines in clusters
+// without internet access do not attempt to reach external
+// hosts.
+package offline
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
+)
+
+// MatchHost returns true if the host is one of the domains,
+// or a subdomain of one of the domains. The port is ignored.
+func MatchHost(domains []string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if host == "" {
+		return false
+	}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if domain == "" {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchImage returns true if the image registry is hosted by
+// one of the domains. Images without a registry are hosted
+// by docker.io.
+func MatchImage(domains []string, name string) bool {
+	return MatchHost(domains, image.Domain(name))
+}
+
+// MatchURL returns true if the url host is one of the domains.
+// Scp-like git addresses, for example git@host:org/repo.git,
+// are supported.
+func MatchURL(domains []string, rawurl string) bool {
+	if !strings.Contains(rawurl, "://") {
+		if i := strings.Index(rawurl, "@"); i != -1 {
+			rawurl = rawurl[i+1:]
+		}
+		if i := strings.Index(rawurl, ":"); i != -1 {
+			rawurl = rawurl[:i]
+		}
+		return MatchHost(domains, rawurl)
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	return MatchHost(domains, u.Host)
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package offline
+
+import "testing"
+
+var domains = []string{"corp.example.com", "registry.local"}
+
+func TestMatchImage(t *testing.T) {
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{"registry.local/golang:1.12", true},
+		{"registry.local:5000/golang", true},
+		{"mirror.corp.example.com/library/alpine", true},
+		{"golang:1.12", false},
+		{"docker.io/library/golang", false},
+		{"gcr.io/distroless/base", false},
+		{"evilcorp.example.com/golang", false},
+	}
+	for _, test := range tests {
+		if got := MatchImage(domains, test.image); got != test.want {
+			t.Errorf("Want image %s internal %v, got %v", test.image, test.want, got)
+		}
+	}
+}
+
+func TestMatchURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://git.corp.example.com/octocat/hello-world.git", true},
+		{"git@git.corp.example.com:octocat/hello-world.git", true},
+		{"http://registry.local:8080/api", true},
+		{"https://github.com/octocat/hello-world.git", false},
+		{"git@github.com:octocat/hello-world.git", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := MatchURL(domains, test.url); got != test.want {
+			t.Errorf("Want url %s internal %v, got %v", test.url, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package pause provides a switch that pauses stage execution
+// during cluster maintenance. Running stages are not affected
+// and execution resumes when the maintenance window ends.
+package pause
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+)
+
+// Window defines a maintenance window during which stage
+// execution is paused.
+type Window struct {
+	// Repos provides a list of repository name patterns
+	// that are paused. All repositories are paused if the
+	// list is empty.
+	Repos []string `json:"repos,omitempty"`
+
+	// Start provides the time the window starts. The window
+	// starts immediately if zero.
+	Start time.Time `json:"start,omitempty"`
+
+	// End provides the time the window ends and execution
+	// automatically resumes. The window does not end if zero.
+	End time.Time `json:"end,omitempty"`
+}
+
+// Switch pauses and resumes stage execution.
+type Switch struct {
+	mu      sync.Mutex
+	window  *Window
+	changed chan struct{}
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// New returns a new switch. Stage execution is paused if the
+// window is not nil.
+func New(window *Window) *Switch {
+	return &Switch{
+		window:  window,
+		changed: make(chan struct{}),
+		now:     time.Now,
+	}
+}
+
+// Pause schedules the maintenance window, replacing the
+// current window.
+func (s *Switch) Pause(window *Window) {
+	s.mu.Lock()
+	s.window = window
+	s.notify()
+	s.mu.Unlock()
+}
+
+// Resume cancels the maintenance window.
+func (s *Switch) Resume() {
+	s.mu.Lock()
+	s.window = nil
+	s.notify()
+	s.mu.Unlock()
+}
+
+// Window returns the current maintenance window, or nil if no
+// window is scheduled or the window ended.
+func (s *Switch) Window() *Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.window == nil || s.ended() {
+		return nil
+	}
+	out := *s.window
+	return &out
+}
+
+// Wait blocks while stage execution is paused for the
+// repository, or until the context is cancelled. A nil switch
+// returns immediately.
+func (s *Switch) Wait(ctx context.Context, repo *drone.Repo) error {
+	if s == nil {
+		return nil
+	}
+	return s.wait(ctx, func(w *Window) bool {
+		return match(repo.Slug, w.Repos)
+	})
+}
+
+// Client returns a client that does not request stages while
+// stage execution is paused for all repositories. Stages that
+// are already running are not affected.
+func (s *Switch) Client(c client.Client) client.Client {
+	return &pausedClient{Client: c, s: s}
+}
+
+type pausedClient struct {
+	client.Client
+	s *Switch
+}
+
+func (c *pausedClient) Request(ctx context.Context, args *client.Filter) (*drone.Stage, error) {
+	err := c.s.wait(ctx, func(w *Window) bool {
+		return len(w.Repos) == 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.Client.Request(ctx, args)
+}
+
+// helper function blocks while the maintenance window applies
+// to the stage, or until the context is cancelled.
+func (s *Switch) wait(ctx context.Context, applies func(*Window) bool) error {
+	for {
+		s.mu.Lock()
+		window, changed := s.window, s.changed
+		paused := window != nil && s.active() && applies(window)
+		var timer <-chan time.Time
+		if paused && !window.End.IsZero() {
+			timer = time.After(window.End.Sub(s.now()))
+		}
+		s.mu.Unlock()
+
+		if !paused {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-timer:
+		}
+	}
+}
+
+// helper function returns true if the maintenance window
+// started and has not ended. The caller must hold the lock.
+func (s *Switch) active() bool {
+	return !s.now().Before(s.window.Start) && !s.ended()
+}
+
+// helper function returns true if the maintenance window
+// ended. The caller must hold the lock.
+func (s *Switch) ended() bool {
+	return !s.window.End.IsZero() && !s.now().Before(s.window.End)
+}
+
+// helper function wakes the waiting stages when the window
+// changes. The caller must hold the lock.
+func (s *Switch) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// helper function returns true if the repository matches a
+// pattern, or if the list of patterns is empty.
+func match(slug string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, slug); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package pause
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func TestWait(t *testing.T) {
+	s := New(&Window{Repos: []string{"octocat/*"}})
+	repo := &drone.Repo{Slug: "octocat/hello-world"}
+
+	done := make(chan error)
+	go func() {
+		done <- s.Wait(context.Background(), repo)
+	}()
+
+	select {
+	case <-done:
+		t.Errorf("Expect stage waits while the repository is paused")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.Resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expect stage resumes when the window is cancelled")
+	}
+}
+
+func TestWait_Unmatched(t *testing.T) {
+	s := New(&Window{Repos: []string{"octocat/*"}})
+	repo := &drone.Repo{Slug: "spaceghost/hello-world"}
+	if err := s.Wait(context.Background(), repo); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWait_End(t *testing.T) {
+	s := New(&Window{End: time.Now().Add(50 * time.Millisecond)})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Wait(ctx, &drone.Repo{}); err != nil {
+		t.Errorf("Expect stage resumes when the window ends, got %v", err)
+	}
+	if s.Window() != nil {
+		t.Errorf("Expect no window once the window ends")
+	}
+}
+
+func TestWait_Scheduled(t *testing.T) {
+	s := New(&Window{Start: time.Now().Add(time.Hour)})
+	if err := s.Wait(context.Background(), &drone.Repo{}); err != nil {
+		t.Error(err)
+	}
+	if s.Window() == nil {
+		t.Errorf("Expect scheduled window returned")
+	}
+}
+
+func TestWait_Cancel(t *testing.T) {
+	s := New(&Window{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Wait(ctx, &drone.Repo{}); err != context.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded error, got %v", err)
+	}
+}
+
+func TestWait_Nil(t *testing.T) {
+	var s *Switch
+	if err := s.Wait(context.Background(), &drone.Repo{}); err != nil {
+		t.Error(err)
+	}
+}
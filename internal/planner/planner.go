@@ -0,0 +1,151 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package planner computes the pipelines and steps of a
+// monorepo build that are affected by the files changed by
+// the build, based on path ownership rules, so that pipelines
+// and steps with no relevant changes are skipped.
+package planner
+
+import (
+	"path"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/internal/changeset"
+)
+
+type (
+	// Config defines the path ownership rules of the
+	// repositories matching the repository patterns.
+	Config struct {
+		Repos []string `yaml:"repos"`
+		Rules []*Rule  `yaml:"rules"`
+	}
+
+	// Rule defines the owners of the files matching the path
+	// patterns. An owner is a pipeline name, or a pipeline
+	// step in pipeline/step format. Files matching a rule
+	// without owners do not affect any pipeline.
+	Rule struct {
+		Paths  []string `yaml:"paths"`
+		Owners []string `yaml:"owners"`
+	}
+
+	// Plan provides the pipelines and steps affected by the
+	// files changed by a build. Pipelines and steps that are
+	// not owned by a rule are always affected.
+	Plan struct {
+		owned    map[string]bool
+		affected map[string]bool
+	}
+)
+
+// Planner computes the execution plan of a build.
+type Planner struct {
+	configs []*Config
+}
+
+// New returns a new planner.
+func New(configs []*Config) *Planner {
+	return &Planner{configs: configs}
+}
+
+// Owned returns true if the repository has ownership rules.
+// A nil planner returns false.
+func (p *Planner) Owned(repo string) bool {
+	if p == nil {
+		return false
+	}
+	for _, config := range p.configs {
+		if matchRepo(config.Repos, repo) && len(config.Rules) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan returns the execution plan of the repository for the
+// changed files. A nil plan is returned if the repository has
+// no ownership rules or the changed files are unknown. If a
+// changed file is not owned by a rule, all pipelines and steps
+// are affected. A nil planner returns a nil plan.
+func (p *Planner) Plan(repo string, files []string) *Plan {
+	if p == nil || files == nil {
+		return nil
+	}
+	var rules []*Rule
+	for _, config := range p.configs {
+		if matchRepo(config.Repos, repo) {
+			rules = append(rules, config.Rules...)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	plan := &Plan{
+		owned:    map[string]bool{},
+		affected: map[string]bool{},
+	}
+	for _, rule := range rules {
+		for _, owner := range rule.Owners {
+			plan.owned[owner] = true
+		}
+	}
+	for _, file := range files {
+		var owned bool
+		for _, rule := range rules {
+			if !changeset.Match([]string{file}, rule.Paths, nil) {
+				continue
+			}
+			owned = true
+			for _, owner := range rule.Owners {
+				plan.affected[owner] = true
+			}
+		}
+		if !owned {
+			return nil
+		}
+	}
+	return plan
+}
+
+// SkipPipeline returns true if the pipeline is owned by a rule
+// and neither the pipeline nor its steps are affected. A nil
+// plan returns false.
+func (p *Plan) SkipPipeline(pipeline string) bool {
+	if p == nil || !p.owned[pipeline] || p.affected[pipeline] {
+		return false
+	}
+	for owner := range p.affected {
+		if strings.HasPrefix(owner, pipeline+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// SkipStep returns true if the pipeline step is owned by a
+// rule and is not affected. A nil plan returns false.
+func (p *Plan) SkipStep(pipeline, step string) bool {
+	if p == nil {
+		return false
+	}
+	name := pipeline + "/" + step
+	return p.owned[name] && !p.affected[name]
+}
+
+// helper function returns true if the repository matches any
+// of the patterns. If there are no patterns, all repositories
+// match.
+func matchRepo(patterns []string, repo string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return true
+		}
+	}
+	return false
+}
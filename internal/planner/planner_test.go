@@ -0,0 +1,67 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package planner
+
+import "testing"
+
+var configs = []*Config{
+	{
+		Repos: []string{"octocat/*"},
+		Rules: []*Rule{
+			{Paths: []string{"web/**"}, Owners: []string{"frontend"}},
+			{Paths: []string{"api/**"}, Owners: []string{"backend"}},
+			{Paths: []string{"api/docs/**"}, Owners: []string{"backend/docs"}},
+			{Paths: []string{"*.md"}, Owners: []string{}},
+		},
+	},
+}
+
+func TestPlan(t *testing.T) {
+	plan := New(configs).Plan("octocat/monorepo", []string{"web/index.html", "README.md"})
+	if plan == nil {
+		t.Fatalf("Want execution plan")
+	}
+	if plan.SkipPipeline("frontend") {
+		t.Errorf("Want affected pipeline executed")
+	}
+	if !plan.SkipPipeline("backend") {
+		t.Errorf("Want unaffected pipeline skipped")
+	}
+	if plan.SkipPipeline("release") {
+		t.Errorf("Want pipeline without ownership rules executed")
+	}
+	if !plan.SkipStep("backend", "docs") {
+		t.Errorf("Want unaffected step skipped")
+	}
+	if plan.SkipStep("frontend", "test") {
+		t.Errorf("Want step without ownership rules executed")
+	}
+}
+
+func TestPlan_Step(t *testing.T) {
+	plan := New(configs).Plan("octocat/monorepo", []string{"api/docs/index.md"})
+	if plan.SkipPipeline("backend") {
+		t.Errorf("Want pipeline with affected steps executed")
+	}
+	if plan.SkipStep("backend", "docs") {
+		t.Errorf("Want affected step executed")
+	}
+}
+
+func TestPlan_Unowned(t *testing.T) {
+	if New(configs).Plan("octocat/monorepo", []string{"go.mod"}) != nil {
+		t.Errorf("Want all pipelines executed if a changed file is not owned")
+	}
+	if New(configs).Plan("octocat/monorepo", nil) != nil {
+		t.Errorf("Want all pipelines executed if the changed files are unknown")
+	}
+	if New(configs).Plan("spaceghost/monorepo", []string{"web/index.html"}) != nil {
+		t.Errorf("Want all pipelines executed if the repository has no rules")
+	}
+	var planner *Planner
+	if planner.Plan("octocat/monorepo", []string{"web/index.html"}).SkipPipeline("backend") {
+		t.Errorf("Want nil planner to be a no-op")
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package provenance emits signed SLSA provenance for each
+// pipeline, describing the builder, the source materials, the
+// step images and the step commands.
+package provenance
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone/drone-go/drone"
+)
+
+const (
+	statementType  = "https://in-toto.io/Statement/v0.1"
+	predicateType  = "https://slsa.dev/provenance/v0.2"
+	buildType      = "https://github.com/drone-runners/drone-runner-kube/pipeline@v1"
+	payloadType    = "application/vnd.in-toto+json"
+	scriptVariable = "DRONE_SCRIPT"
+)
+
+type (
+	// Statement is an in-toto statement.
+	Statement struct {
+		Type          string     `json:"_type"`
+		PredicateType string     `json:"predicateType"`
+		Subject       []*Subject `json:"subject"`
+		Predicate     *Predicate `json:"predicate"`
+	}
+
+	// Subject is the artifact described by the statement.
+	Subject struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	}
+
+	// Predicate is the SLSA provenance predicate.
+	Predicate struct {
+		Builder     Builder     `json:"builder"`
+		BuildType   string      `json:"buildType"`
+		Invocation  Invocation  `json:"invocation"`
+		BuildConfig BuildConfig `json:"buildConfig"`
+		Metadata    Metadata    `json:"metadata"`
+		Materials   []*Material `json:"materials"`
+	}
+
+	// Builder identifies the runner.
+	Builder struct {
+		ID string `json:"id"`
+	}
+
+	// Invocation describes the build event.
+	Invocation struct {
+		ConfigSource ConfigSource      `json:"configSource"`
+		Parameters   map[string]string `json:"parameters"`
+	}
+
+	// ConfigSource describes the pipeline configuration.
+	ConfigSource struct {
+		URI        string            `json:"uri"`
+		Digest     map[string]string `json:"digest"`
+		EntryPoint string            `json:"entryPoint"`
+	}
+
+	// BuildConfig describes the pipeline steps.
+	BuildConfig struct {
+		Steps []*Step `json:"steps"`
+	}
+
+	// Step describes a pipeline step.
+	Step struct {
+		Name    string `json:"name"`
+		Image   string `json:"image"`
+		Command string `json:"command,omitempty"`
+	}
+
+	// Metadata describes the pipeline execution.
+	Metadata struct {
+		InvocationID string       `json:"buildInvocationId"`
+		StartedOn    string       `json:"buildStartedOn"`
+		FinishedOn   string       `json:"buildFinishedOn"`
+		Completeness Completeness `json:"completeness"`
+		Reproducible bool         `json:"reproducible"`
+	}
+
+	// Completeness describes which fields are complete.
+	Completeness struct {
+		Parameters  bool `json:"parameters"`
+		Environment bool `json:"environment"`
+		Materials   bool `json:"materials"`
+	}
+
+	// Material is a source or image used by the pipeline.
+	Material struct {
+		URI    string            `json:"uri"`
+		Digest map[string]string `json:"digest,omitempty"`
+	}
+
+	// Input provides the pipeline described by the provenance.
+	Input struct {
+		System *drone.System
+		Repo   *drone.Repo
+		Build  *drone.Build
+		Stage  *drone.Stage
+		Spec   *engine.Spec
+		Images []*engine.Image
+
+		// Status provides the pipeline status, which is
+		// recorded in the execution record.
+		Status string
+	}
+)
+
+// Emitter signs the provenance of each pipeline and writes the
+// signed provenance to the store.
+type Emitter struct {
+	builder string
+	key     *ecdsa.PrivateKey
+	store   Store
+	records bool
+}
+
+// New returns a new provenance emitter. The builder provides
+// the builder identity recorded in the provenance.
+func New(builder string, key *ecdsa.PrivateKey, store Store) *Emitter {
+	return &Emitter{
+		builder: builder,
+		key:     key,
+		store:   store,
+	}
+}
+
+// Emit signs the provenance of the pipeline and writes the
+// signed provenance to the store. A nil emitter is a no-op.
+func (e *Emitter) Emit(ctx context.Context, in *Input) error {
+	if e == nil {
+		return nil
+	}
+	statement := toStatement(e.builder, in)
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+	envelope, err := sign(e.key, payloadType, payload)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s/%d/%d.intoto.json", in.Repo.Slug, in.Build.Number, in.Stage.Number)
+	return e.store.Put(ctx, name, data)
+}
+
+// helper function returns the provenance statement of the
+// pipeline. The runner does not know the artifacts published
+// by the pipeline, and the source commit is the subject.
+func toStatement(builder string, in *Input) *Statement {
+	commit := map[string]string{"sha1": in.Build.After}
+	source := "git+" + in.Repo.HTTPURL + "@" + in.Build.Ref
+
+	predicate := &Predicate{
+		Builder:   Builder{ID: builder},
+		BuildType: buildType,
+		Invocation: Invocation{
+			ConfigSource: ConfigSource{
+				URI:        source,
+				Digest:     commit,
+				EntryPoint: in.Repo.Config,
+			},
+			Parameters: map[string]string{
+				"event":    in.Build.Event,
+				"ref":      in.Build.Ref,
+				"target":   in.Build.Target,
+				"pipeline": in.Stage.Name,
+			},
+		},
+		Metadata: Metadata{
+			InvocationID: toInvocationID(in),
+			StartedOn:    toTimestamp(in.Stage.Started),
+			FinishedOn:   time.Now().UTC().Format(time.RFC3339),
+			Completeness: Completeness{Parameters: true},
+		},
+		Materials: []*Material{{URI: source, Digest: commit}},
+	}
+
+	for _, step := range in.Spec.Steps {
+		predicate.BuildConfig.Steps = append(predicate.BuildConfig.Steps, &Step{
+			Name:    step.Name,
+			Image:   step.Image,
+			Command: toCommand(in.Spec, step),
+		})
+	}
+	for _, image := range in.Images {
+		material := &Material{URI: image.Name}
+		if parts := strings.SplitN(image.Digest, ":", 2); len(parts) == 2 {
+			material.Digest = map[string]string{parts[0]: parts[1]}
+		}
+		predicate.Materials = append(predicate.Materials, material)
+	}
+
+	return &Statement{
+		Type:          statementType,
+		PredicateType: predicateType,
+		Subject: []*Subject{{
+			Name:   source,
+			Digest: commit,
+		}},
+		Predicate: predicate,
+	}
+}
+
+// helper function returns the step script. Large scripts are
+// stored in the pipeline secret.
+func toCommand(spec *engine.Spec, step *engine.Step) string {
+	if script, ok := step.Envs[scriptVariable]; ok {
+		return script
+	}
+	if secret, ok := spec.Secrets[step.ScriptFile]; ok {
+		return secret.Data
+	}
+	var args []string
+	args = append(args, step.Entrypoint...)
+	args = append(args, step.Command...)
+	return strings.Join(args, " ")
+}
+
+// helper function returns the link to the stage, or the stage
+// id if the server address is unknown.
+func toInvocationID(in *Input) string {
+	if in.System == nil || in.System.Link == "" {
+		return fmt.Sprintf("%s/%d/%d", in.Repo.Slug, in.Build.Number, in.Stage.Number)
+	}
+	return fmt.Sprintf("%s/%s/%d/%d", in.System.Link, in.Repo.Slug, in.Build.Number, in.Stage.Number)
+}
+
+func toTimestamp(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package provenance
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone/drone-go/drone"
+)
+
+func TestEmit(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	in := &Input{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world", HTTPURL: "https://github.com/octocat/hello-world.git", Config: ".drone.yml"},
+		Build: &drone.Build{Number: 42, Event: "push", Ref: "refs/heads/master", After: "7fd1a60b01f91b314f59955a4e4d4e80d8edf11d"},
+		Stage: &drone.Stage{Number: 1, Name: "default"},
+		Spec: &engine.Spec{
+			Steps: []*engine.Step{
+				{Name: "build", Image: "golang:1.12", Envs: map[string]string{"DRONE_SCRIPT": "go build"}},
+			},
+		},
+		Images: []*engine.Image{
+			{Container: "build", Name: "golang:1.12", Digest: "sha256:1a2b"},
+		},
+	}
+	err = New("https://drone.company.com/runner/kube", key, Dir(tmp)).Emit(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(tmp, "octocat/hello-world/42/1.intoto.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := new(Envelope)
+	if err := json.Unmarshal(data, envelope); err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := base64.StdEncoding.DecodeString(envelope.Payload)
+	sig, _ := base64.StdEncoding.DecodeString(envelope.Signatures[0].Sig)
+	var rs struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(pae(envelope.PayloadType, payload))
+	if !ecdsa.Verify(&key.PublicKey, hash[:], rs.R, rs.S) {
+		t.Errorf("Expect valid provenance signature")
+	}
+
+	statement := new(Statement)
+	if err := json.Unmarshal(payload, statement); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := statement.Predicate.Builder.ID, "https://drone.company.com/runner/kube"; got != want {
+		t.Errorf("Want builder %s, got %s", want, got)
+	}
+	if got, want := statement.Subject[0].Digest["sha1"], in.Build.After; got != want {
+		t.Errorf("Want subject digest %s, got %s", want, got)
+	}
+	if got, want := statement.Predicate.BuildConfig.Steps[0].Command, "go build"; got != want {
+		t.Errorf("Want step command %s, got %s", want, got)
+	}
+	if got, want := len(statement.Predicate.Materials), 2; got != want {
+		t.Fatalf("Want %d materials, got %d", want, got)
+	}
+	if got, want := statement.Predicate.Materials[1].Digest["sha256"], "1a2b"; got != want {
+		t.Errorf("Want image digest %s, got %s", want, got)
+	}
+}
+
+func TestEmit_Nil(t *testing.T) {
+	var e *Emitter
+	if err := e.Emit(context.Background(), nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package provenance
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+const recordType = "application/vnd.drone.record+json"
+
+type (
+	// Record is an execution record of a pipeline, which
+	// describes everything the runner executed so that the
+	// pipeline can be audited. Secret values are not recorded.
+	Record struct {
+		Builder  string          `json:"builder"`
+		Repo     string          `json:"repo"`
+		Build    int64           `json:"build"`
+		Stage    int             `json:"stage"`
+		Pipeline string          `json:"pipeline"`
+		Event    string          `json:"event"`
+		Ref      string          `json:"ref"`
+		Commit   string          `json:"commit"`
+		Target   string          `json:"target,omitempty"`
+		Status   string          `json:"status"`
+		Started  string          `json:"started"`
+		Finished string          `json:"finished"`
+		Manifest string          `json:"manifest"`
+		Digest   string          `json:"digest"`
+		Images   []*engine.Image `json:"images,omitempty"`
+		Steps    []*RecordStep   `json:"steps"`
+	}
+
+	// RecordStep describes an executed pipeline step.
+	RecordStep struct {
+		Name     string   `json:"name"`
+		Image    string   `json:"image"`
+		Command  string   `json:"command,omitempty"`
+		Secrets  []string `json:"secrets,omitempty"`
+		Status   string   `json:"status"`
+		ExitCode int      `json:"exit_code"`
+		Started  string   `json:"started,omitempty"`
+		Stopped  string   `json:"stopped,omitempty"`
+	}
+)
+
+// RecordExecutions configures the emitter to sign and store an
+// execution record of every pipeline, including pipelines that
+// fail or are cancelled.
+func (e *Emitter) RecordExecutions() {
+	e.records = true
+}
+
+// Record signs the execution record of the pipeline and writes
+// the signed record to the store, next to the provenance of the
+// build. A nil emitter is a no-op.
+func (e *Emitter) Record(ctx context.Context, in *Input) error {
+	if e == nil || !e.records {
+		return nil
+	}
+	payload, err := json.Marshal(toRecord(e.builder, in))
+	if err != nil {
+		return err
+	}
+	envelope, err := sign(e.key, recordType, payload)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s/%d/%d.record.json", in.Repo.Slug, in.Build.Number, in.Stage.Number)
+	return e.store.Put(ctx, name, data)
+}
+
+// helper function returns the execution record of the
+// pipeline. The compiled pipeline is recorded as the redacted
+// kubernetes manifests, and the secrets are recorded by name.
+func toRecord(builder string, in *Input) *Record {
+	var buf bytes.Buffer
+	engine.DumpRedacted(&buf, in.Spec)
+	digest := sha256.Sum256(buf.Bytes())
+
+	record := &Record{
+		Builder:  builder,
+		Repo:     in.Repo.Slug,
+		Build:    in.Build.Number,
+		Stage:    in.Stage.Number,
+		Pipeline: in.Stage.Name,
+		Event:    in.Build.Event,
+		Ref:      in.Build.Ref,
+		Commit:   in.Build.After,
+		Target:   in.Build.Deploy,
+		Status:   in.Status,
+		Started:  toTimestamp(in.Stage.Started),
+		Finished: time.Now().UTC().Format(time.RFC3339),
+		Manifest: buf.String(),
+		Digest:   "sha256:" + hex.EncodeToString(digest[:]),
+		Images:   in.Images,
+	}
+
+	states := map[string]*RecordStep{}
+	for _, step := range in.Stage.Steps {
+		states[step.Name] = &RecordStep{
+			Status:   step.Status,
+			ExitCode: step.ExitCode,
+		}
+		if step.Started != 0 {
+			states[step.Name].Started = toTimestamp(step.Started)
+		}
+		if step.Stopped != 0 {
+			states[step.Name].Stopped = toTimestamp(step.Stopped)
+		}
+	}
+	for _, step := range in.Spec.Steps {
+		out, ok := states[step.Name]
+		if !ok {
+			// steps that never run are not stored by the
+			// server, and are recorded as skipped.
+			out = &RecordStep{Status: "skipped"}
+		}
+		out.Name = step.Name
+		out.Image = step.Image
+		out.Command = toCommand(in.Spec, step)
+		out.Secrets = toSecretNames(step)
+		record.Steps = append(record.Steps, out)
+	}
+	return record
+}
+
+// helper function returns the sorted names of the secrets used
+// by the step.
+func toSecretNames(step *engine.Step) []string {
+	var names []string
+	for _, secret := range step.Secrets {
+		// vault secrets are resolved into step secrets when
+		// the pipeline is created, and are recorded by path.
+		if strings.HasPrefix(secret.Name, "vault."+step.ID+".") {
+			continue
+		}
+		names = append(names, secret.Name)
+	}
+	for _, ref := range step.EnvRefs {
+		switch {
+		case ref.Secret != nil:
+			names = append(names, "secret:"+ref.Secret.Name+"/"+ref.Secret.Key)
+		case ref.ConfigMap != nil:
+			names = append(names, "configmap:"+ref.ConfigMap.Name+"/"+ref.ConfigMap.Key)
+		case ref.Vault != nil:
+			names = append(names, "vault:"+ref.Vault.Name+"/"+ref.Vault.Key)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package provenance
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone/drone-go/drone"
+)
+
+func TestRecord(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	in := &Input{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{Number: 42, Event: "push"},
+		Stage: &drone.Stage{
+			Number: 1,
+			Name:   "default",
+			Steps: []*drone.Step{
+				{Name: "build", Status: drone.StatusFailing, ExitCode: 2, Started: 1570000000},
+			},
+		},
+		Spec: &engine.Spec{
+			PodSpec: engine.PodSpec{Name: "drone-pod", Namespace: "default"},
+			Secrets: map[string]*engine.Secret{
+				"password": {Name: "password", Data: "correct-horse", Mask: true},
+			},
+			Steps: []*engine.Step{
+				{
+					ID:      "drone-build",
+					Name:    "build",
+					Image:   "golang:1.12",
+					Secrets: []*engine.SecretVar{{Name: "password", Env: "PASSWORD"}},
+					Envs:    map[string]string{"PASSWORD_COPY": "correct-horse"},
+				},
+				{Name: "deploy", Image: "alpine"},
+			},
+		},
+		Status: drone.StatusFailing,
+	}
+
+	e := New("https://drone.company.com/runner/kube", key, Dir(tmp))
+	e.RecordExecutions()
+	if err := e.Record(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(tmp, "octocat/hello-world/42/1.record.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), base64.StdEncoding.EncodeToString([]byte("correct-horse"))) {
+		t.Errorf("Want secret values excluded from the record")
+	}
+	envelope := new(Envelope)
+	if err := json.Unmarshal(data, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := envelope.PayloadType, recordType; got != want {
+		t.Errorf("Want payload type %s, got %s", want, got)
+	}
+	payload, _ := base64.StdEncoding.DecodeString(envelope.Payload)
+	if strings.Contains(string(payload), "correct-horse") {
+		t.Errorf("Want secret values excluded from the record")
+	}
+
+	record := new(Record)
+	if err := json.Unmarshal(payload, record); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(record.Steps), 2; got != want {
+		t.Fatalf("Want %d steps, got %d", want, got)
+	}
+	if got, want := record.Steps[0].ExitCode, 2; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if got, want := strings.Join(record.Steps[0].Secrets, ","), "password"; got != want {
+		t.Errorf("Want secret names %s, got %s", want, got)
+	}
+	if got, want := record.Steps[1].Status, "skipped"; got != want {
+		t.Errorf("Want step status %s, got %s", want, got)
+	}
+	if !strings.HasPrefix(record.Digest, "sha256:") {
+		t.Errorf("Want manifest digest, got %s", record.Digest)
+	}
+}
+
+func TestRecord_Disabled(t *testing.T) {
+	e := New("", nil, nil)
+	if err := e.Record(context.Background(), nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package provenance
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+)
+
+type (
+	// Envelope is a DSSE envelope.
+	Envelope struct {
+		PayloadType string       `json:"payloadType"`
+		Payload     string       `json:"payload"`
+		Signatures  []*Signature `json:"signatures"`
+	}
+
+	// Signature is a DSSE signature.
+	Signature struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	}
+)
+
+// LoadKey reads the pem encoded ecdsa private key from the
+// file.
+func LoadKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("provenance: cannot decode pem key")
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("provenance: key is not an ecdsa key")
+		}
+		return ecdsaKey, nil
+	default:
+		return nil, fmt.Errorf("provenance: unsupported pem type %s", block.Type)
+	}
+}
+
+// helper function signs the payload and returns the envelope.
+// The key id is the sha256 hash of the public key.
+func sign(key *ecdsa.PrivateKey, payloadType string, payload []byte) (*Envelope, error) {
+	hash := sha256.Sum256(pae(payloadType, payload))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		return nil, err
+	}
+	keyid, err := toKeyID(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []*Signature{{
+			KeyID: keyid,
+			Sig:   base64.StdEncoding.EncodeToString(sig),
+		}},
+	}, nil
+}
+
+// helper function returns the DSSE pre-authentication encoding
+// of the payload.
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+func toKeyID(key *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
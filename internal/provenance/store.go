@@ -0,0 +1,97 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package provenance
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store stores the signed provenance.
+type Store interface {
+	Put(ctx context.Context, name string, data []byte) error
+}
+
+// NewStore returns a store for the address. An http or https
+// address returns a store that uploads the provenance to the
+// endpoint. Any other address is a directory.
+func NewStore(address, token string, skipverify bool) Store {
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		return HTTP(address, token, skipverify)
+	}
+	return Dir(address)
+}
+
+// Dir returns a store that writes the provenance to the
+// directory.
+func Dir(path string) Store {
+	return dir(path)
+}
+
+type dir string
+
+func (d dir) Put(ctx context.Context, name string, data []byte) error {
+	path := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// HTTP returns a store that uploads the provenance to the
+// endpoint. The provenance is uploaded with a PUT request to
+// the endpoint joined with the provenance name.
+func HTTP(endpoint, token string, skipverify bool) Store {
+	client := http.DefaultClient
+	if skipverify {
+		client = &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		}
+	}
+	return &remote{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+		client:   client,
+	}
+}
+
+type remote struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func (r *remote) Put(ctx context.Context, name string, data []byte) error {
+	req, err := http.NewRequest("PUT", r.endpoint+"/"+name, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/vnd.dsse.envelope.v1+json")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return fmt.Errorf("provenance: store returned status %d", res.StatusCode)
+	}
+	return nil
+}
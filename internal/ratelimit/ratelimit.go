@@ -0,0 +1,72 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package ratelimit limits the rate at which stages are
+// executed for each build event, so that scheduled builds do
+// not crowd out interactive builds.
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// interval at which a waiting stage retries the rate limiter.
+var interval = time.Second
+
+// limiter returns true if a request is permitted by the rate
+// limit.
+type limiter interface {
+	TryAccept() bool
+}
+
+// Limiter limits the rate at which stages are executed for
+// each build event. Stages for events without a limit are not
+// limited.
+type Limiter struct {
+	limiters map[string]limiter
+}
+
+// New returns a new limiter. The rates provide the number of
+// stages per minute for each build event, for example cron
+// and tag. The burst provides the number of stages for each
+// build event that can execute at once, and defaults to one.
+func New(rates map[string]int, burst map[string]int) *Limiter {
+	l := &Limiter{limiters: map[string]limiter{}}
+	for event, rate := range rates {
+		if rate <= 0 {
+			continue
+		}
+		b := burst[event]
+		if b < 1 {
+			b = 1
+		}
+		l.limiters[event] = flowcontrol.NewTokenBucketRateLimiter(float32(rate)/60, b)
+	}
+	return l
+}
+
+// Wait blocks until the stage is permitted by the rate limit
+// for the build event, or the context is cancelled. A nil
+// limiter returns immediately.
+func (l *Limiter) Wait(ctx context.Context, build *drone.Build) error {
+	if l == nil {
+		return nil
+	}
+	limiter, ok := l.limiters[build.Event]
+	if !ok {
+		return nil
+	}
+	for !limiter.TryAccept() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+type fakeLimiter struct {
+	tokens int
+}
+
+func (f *fakeLimiter) TryAccept() bool {
+	if f.tokens == 0 {
+		return false
+	}
+	f.tokens--
+	return true
+}
+
+func TestWait(t *testing.T) {
+	interval = time.Millisecond
+	l := &Limiter{limiters: map[string]limiter{
+		drone.EventCron: &fakeLimiter{tokens: 1},
+	}}
+	cron := &drone.Build{Event: drone.EventCron}
+
+	if err := l.Wait(context.Background(), cron); err != nil {
+		t.Error(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, cron); err != context.DeadlineExceeded {
+		t.Errorf("Want cron stage to wait for the rate limit, got %v", err)
+	}
+
+	push := &drone.Build{Event: drone.EventPush}
+	if err := l.Wait(ctx, push); err != nil {
+		t.Errorf("Expect push stage is not limited, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	l := New(
+		map[string]int{drone.EventCron: 10, drone.EventTag: 0},
+		map[string]int{drone.EventCron: 5},
+	)
+	if _, ok := l.limiters[drone.EventCron]; !ok {
+		t.Errorf("Expect cron limiter")
+	}
+	if _, ok := l.limiters[drone.EventTag]; ok {
+		t.Errorf("Expect no limiter when the rate is zero")
+	}
+}
+
+func TestWait_Nil(t *testing.T) {
+	var l *Limiter
+	if err := l.Wait(context.Background(), &drone.Build{}); err != nil {
+		t.Error(err)
+	}
+}
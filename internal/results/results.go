@@ -0,0 +1,211 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package results caches the result of the stages that
+// succeeded, keyed by the commit, the pipeline configuration
+// and the build parameters, so that an identical stage, for
+// example from a re-delivered webhook or a branch re-push,
+// reports the cached result instead of executing again. The
+// results are persisted to a local file, so that the cache
+// survives runner restarts.
+package results
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/sirupsen/logrus"
+)
+
+// cache metrics, exposed with the expvar handler.
+var (
+	hits   = expvar.NewInt("result_cache_hits")
+	misses = expvar.NewInt("result_cache_misses")
+)
+
+// Scope defines which builds of a repository are identical.
+type Scope string
+
+// Scope enumeration.
+const (
+	// ScopeRepo treats builds of the same commit in the
+	// repository as identical.
+	ScopeRepo Scope = "repo"
+
+	// ScopeBranch treats builds of the same commit on the
+	// same target branch as identical.
+	ScopeBranch Scope = "branch"
+
+	// ScopeRef treats builds of the same commit with the same
+	// event and git reference as identical.
+	ScopeRef Scope = "ref"
+)
+
+// Result records a stage that succeeded.
+type Result struct {
+	Build    int64     `json:"build"`
+	Commit   string    `json:"commit"`
+	Steps    []*Step   `json:"steps"`
+	Finished time.Time `json:"finished"`
+}
+
+// Step records the status of a step of the stage.
+type Step struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	ExitCode  int    `json:"exit_code"`
+	ErrIgnore bool   `json:"err_ignore,omitempty"`
+}
+
+// Store stores the stage results.
+type Store struct {
+	path   string
+	scope  Scope
+	events map[string]bool
+	ttl    time.Duration
+	max    int
+
+	mu      sync.Mutex
+	results map[string]*Result
+}
+
+// New returns a new store that persists the results to the
+// file, if not empty. Only the builds of the events are
+// cached. Results expire after the ttl, if not zero, and at
+// most max results are stored. The oldest results are
+// forgotten first.
+func New(path string, scope Scope, events []string, ttl time.Duration, max int) *Store {
+	s := &Store{
+		path:    path,
+		scope:   scope,
+		events:  map[string]bool{},
+		ttl:     ttl,
+		max:     max,
+		results: map[string]*Result{},
+	}
+	for _, event := range events {
+		s.events[event] = true
+	}
+	if path != "" {
+		if raw, err := ioutil.ReadFile(path); err == nil {
+			json.Unmarshal(raw, &s.results)
+		}
+	}
+	return s
+}
+
+// Key returns the key of the stage, or an empty string if the
+// stage result is not cached. Restarted builds are never
+// cached, because the user explicitly requested the stage to
+// execute again. A nil store returns an empty string.
+func (s *Store) Key(repo *drone.Repo, build *drone.Build, stage *drone.Stage, config string) string {
+	if s == nil || build.After == "" || build.Parent != 0 || !s.events[build.Event] {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", repo.Slug, build.After, stage.Name)
+	switch s.scope {
+	case ScopeBranch:
+		fmt.Fprintf(h, "%s\x00", build.Target)
+	case ScopeRef:
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", build.Target, build.Event, build.Ref)
+	}
+	var params []string
+	for k := range build.Params {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		fmt.Fprintf(h, "%s=%s\x00", k, build.Params[k])
+	}
+	fmt.Fprint(h, config)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// Lookup returns the result of the stage, and false if no
+// identical stage succeeded.
+func (s *Store) Lookup(key string) (*Result, bool) {
+	if s == nil || key == "" {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, ok := s.results[key]
+	if ok && s.expired(result) {
+		delete(s.results, key)
+		s.save()
+		ok = false
+	}
+	if !ok {
+		misses.Add(1)
+		return nil, false
+	}
+	hits.Add(1)
+	return result, true
+}
+
+// Record records the result of the stage that succeeded.
+func (s *Store) Record(key string, result *Result) {
+	if s == nil || key == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[key] = result
+	s.prune()
+	s.save()
+}
+
+// helper function returns true if the result expired.
+func (s *Store) expired(result *Result) bool {
+	return s.ttl > 0 && time.Since(result.Finished) > s.ttl
+}
+
+// helper function removes the expired results, and the oldest
+// results that exceed the maximum.
+func (s *Store) prune() {
+	for key, result := range s.results {
+		if s.expired(result) {
+			delete(s.results, key)
+		}
+	}
+	for s.max > 0 && len(s.results) > s.max {
+		var oldest string
+		for key, result := range s.results {
+			if oldest == "" || result.Finished.Before(s.results[oldest].Finished) ||
+				(result.Finished.Equal(s.results[oldest].Finished) && key < oldest) {
+				oldest = key
+			}
+		}
+		delete(s.results, oldest)
+	}
+}
+
+// helper function writes the results to the file. The file is
+// replaced atomically, so that a partially written file is
+// never read.
+func (s *Store) save() {
+	if s.path == "" {
+		return
+	}
+	raw, _ := json.Marshal(s.results)
+	tmp := s.path + ".tmp"
+	err := ioutil.WriteFile(tmp, raw, 0600)
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		logrus.WithError(err).
+			WithField("path", s.path).
+			Warnln("cannot save the stage results")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package results
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+var (
+	testRepo  = &drone.Repo{Slug: "octocat/hello-world"}
+	testStage = &drone.Stage{Name: "default"}
+)
+
+func testBuild() *drone.Build {
+	return &drone.Build{
+		Event:  drone.EventPush,
+		After:  "a1b2c3",
+		Target: "master",
+		Ref:    "refs/heads/master",
+	}
+}
+
+func TestKey(t *testing.T) {
+	s := New("", ScopeBranch, []string{drone.EventPush}, 0, 0)
+	key := s.Key(testRepo, testBuild(), testStage, "kind: pipeline")
+	if key == "" {
+		t.Errorf("Want push build cached")
+		return
+	}
+	if got := s.Key(testRepo, testBuild(), testStage, "kind: pipeline"); got != key {
+		t.Errorf("Want identical builds to have the same key")
+	}
+
+	build := testBuild()
+	build.Target = "develop"
+	if s.Key(testRepo, build, testStage, "kind: pipeline") == key {
+		t.Errorf("Want branch included in the key")
+	}
+	build = testBuild()
+	build.Params = map[string]string{"DEPLOY": "true"}
+	if s.Key(testRepo, build, testStage, "kind: pipeline") == key {
+		t.Errorf("Want parameters included in the key")
+	}
+	if s.Key(testRepo, testBuild(), testStage, "kind: secret") == key {
+		t.Errorf("Want configuration included in the key")
+	}
+	if s.Key(testRepo, testBuild(), &drone.Stage{Name: "test"}, "kind: pipeline") == key {
+		t.Errorf("Want stage included in the key")
+	}
+
+	// the builds of other events, and restarted builds, are
+	// not cached.
+	build = testBuild()
+	build.Event = drone.EventPromote
+	if s.Key(testRepo, build, testStage, "kind: pipeline") != "" {
+		t.Errorf("Want promote build not cached")
+	}
+	build = testBuild()
+	build.Parent = 1
+	if s.Key(testRepo, build, testStage, "kind: pipeline") != "" {
+		t.Errorf("Want restarted build not cached")
+	}
+}
+
+func TestKey_Scope(t *testing.T) {
+	a, b := testBuild(), testBuild()
+	b.Target = "develop"
+
+	s := New("", ScopeRepo, []string{drone.EventPush}, 0, 0)
+	if s.Key(testRepo, a, testStage, "") != s.Key(testRepo, b, testStage, "") {
+		t.Errorf("Want commit on any branch identical with repo scope")
+	}
+	s = New("", ScopeRef, []string{drone.EventPush, drone.EventTag}, 0, 0)
+	b = testBuild()
+	b.Event = drone.EventTag
+	if s.Key(testRepo, a, testStage, "") == s.Key(testRepo, b, testStage, "") {
+		t.Errorf("Want event included in the key with ref scope")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	s := New("", ScopeBranch, []string{drone.EventPush}, time.Hour, 0)
+	if _, ok := s.Lookup("a"); ok {
+		t.Errorf("Want no result before the stage succeeded")
+	}
+	s.Record("a", &Result{Build: 1, Finished: time.Now()})
+	result, ok := s.Lookup("a")
+	if !ok || result.Build != 1 {
+		t.Errorf("Want cached result")
+	}
+
+	s.Record("b", &Result{Build: 2, Finished: time.Now().Add(-2 * time.Hour)})
+	if _, ok := s.Lookup("b"); ok {
+		t.Errorf("Want expired result ignored")
+	}
+}
+
+func TestRecord_Persist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.json")
+
+	New(path, ScopeBranch, nil, 0, 0).Record("a", &Result{Build: 1, Finished: time.Now()})
+	if _, ok := New(path, ScopeBranch, nil, 0, 0).Lookup("a"); !ok {
+		t.Errorf("Want results restored after restart")
+	}
+}
+
+func TestRecord_Max(t *testing.T) {
+	s := New("", ScopeBranch, nil, 0, 1)
+	s.Record("a", &Result{Build: 1, Finished: time.Now().Add(-time.Minute)})
+	s.Record("b", &Result{Build: 2, Finished: time.Now()})
+	if _, ok := s.Lookup("a"); ok {
+		t.Errorf("Want oldest result forgotten")
+	}
+}
+
+func TestStore_Nil(t *testing.T) {
+	var s *Store
+	if s.Key(testRepo, testBuild(), testStage, "") != "" {
+		t.Errorf("Want nil store to cache nothing")
+	}
+	s.Record("a", &Result{})
+	if _, ok := s.Lookup("a"); ok {
+		t.Errorf("Want nil store to cache nothing")
+	}
+}
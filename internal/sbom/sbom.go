@@ -0,0 +1,122 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package sbom records the container images used by a
+// pipeline as a software bill of materials, in the CycloneDX
+// json format.
+package sbom
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+// Document is a CycloneDX bill of materials.
+type Document struct {
+	Format      string       `json:"bomFormat"`
+	SpecVersion string       `json:"specVersion"`
+	Version     int          `json:"version"`
+	Metadata    Metadata     `json:"metadata"`
+	Components  []*Component `json:"components"`
+}
+
+// Metadata describes the pipeline.
+type Metadata struct {
+	Timestamp string     `json:"timestamp"`
+	Component *Component `json:"component"`
+}
+
+// Component describes a container image.
+type Component struct {
+	Type       string      `json:"type"`
+	Name       string      `json:"name"`
+	Version    string      `json:"version,omitempty"`
+	Purl       string      `json:"purl,omitempty"`
+	Properties []*Property `json:"properties,omitempty"`
+}
+
+// Property is a name value pair.
+type Property struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// New returns a bill of materials of the images used by the
+// named pipeline, for example the repository and build number.
+func New(name, version string, images []*engine.Image) *Document {
+	doc := &Document{
+		Format:      "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: Metadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Component: &Component{
+				Type:    "application",
+				Name:    name,
+				Version: version,
+			},
+		},
+		Components: []*Component{},
+	}
+	for _, image := range images {
+		doc.Components = append(doc.Components, toComponent(image))
+	}
+	return doc
+}
+
+// Write writes the json encoded document to w.
+func (d *Document) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// helper function returns the component of the image. The
+// component version is the image digest, or the image tag if
+// the digest is unknown.
+func toComponent(image *engine.Image) *Component {
+	repo, tag := splitImage(image.Name)
+	c := &Component{
+		Type:    "container",
+		Name:    repo,
+		Version: tag,
+		Properties: []*Property{
+			{Name: "drone:step", Value: image.Container},
+			{Name: "drone:image", Value: image.Name},
+		},
+	}
+	if image.Digest != "" {
+		c.Version = image.Digest
+		c.Purl = toPurl(repo, image.Digest)
+	}
+	return c
+}
+
+// helper function returns the package url of the image, for
+// example pkg:oci/golang@sha256%3A1a2b?repository_url=docker.io/library/golang
+func toPurl(repo, digest string) string {
+	name := repo
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	return "pkg:oci/" + strings.ToLower(name) + "@" + url.QueryEscape(digest) +
+		"?repository_url=" + repo
+}
+
+// helper function splits the image into the repository and
+// tag. The tag defaults to latest.
+func splitImage(image string) (repo, tag string) {
+	if i := strings.Index(image, "@"); i != -1 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
@@ -0,0 +1,46 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package sbom
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+func TestNew(t *testing.T) {
+	doc := New("octocat/hello-world", "42", []*engine.Image{
+		{Container: "build", Name: "docker.io/library/golang:1.12", Digest: "sha256:1a2b"},
+		{Container: "test", Name: "localhost:5000/test"},
+	})
+	if got, want := len(doc.Components), 2; got != want {
+		t.Fatalf("Want %d components, got %d", want, got)
+	}
+
+	c := doc.Components[0]
+	if got, want := c.Name, "docker.io/library/golang"; got != want {
+		t.Errorf("Want name %s, got %s", want, got)
+	}
+	if got, want := c.Version, "sha256:1a2b"; got != want {
+		t.Errorf("Want version %s, got %s", want, got)
+	}
+	if got, want := c.Purl, "pkg:oci/golang@sha256%3A1a2b?repository_url=docker.io/library/golang"; got != want {
+		t.Errorf("Want purl %s, got %s", want, got)
+	}
+	if got, want := c.Properties[0].Value, "build"; got != want {
+		t.Errorf("Want step %s, got %s", want, got)
+	}
+
+	c = doc.Components[1]
+	if got, want := c.Name, "localhost:5000/test"; got != want {
+		t.Errorf("Want name %s, got %s", want, got)
+	}
+	if got, want := c.Version, "latest"; got != want {
+		t.Errorf("Want version %s, got %s", want, got)
+	}
+	if c.Purl != "" {
+		t.Errorf("Want no purl without digest, got %s", c.Purl)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package schema provides http handlers that serve the json
+// schema of the pipeline resource and validate pipelines, so
+// that editors and pre-commit hooks can use the runner.
+package schema
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+)
+
+// maxSize is the maximum size of the yaml document.
+const maxSize = 1 << 20
+
+// Handler returns an http handler that writes the json
+// schema of the pipeline resource.
+func Handler() http.Handler {
+	schema := resource.NewSchema()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		json.NewEncoder(w).Encode(schema)
+	})
+}
+
+// ValidateHandler returns an http handler that validates the
+// yaml document in the request body, and writes the validation
+// errors. The document is valid if the list of errors is empty.
+func ValidateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		raw, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		out := struct {
+			Errors []string `json:"errors"`
+		}{Errors: []string{}}
+		for _, err := range resource.Validate(raw) {
+			out.Errors = append(out.Errors, err.Error())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	})
+}
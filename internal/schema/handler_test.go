@@ -0,0 +1,44 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/schema", nil)
+	Handler().ServeHTTP(w, r)
+	if got, want := w.Code, 200; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	out := map[string]interface{}{}
+	json.NewDecoder(w.Body).Decode(&out)
+	if out["$schema"] == nil {
+		t.Errorf("Want json schema")
+	}
+}
+
+func TestValidateHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/validate", strings.NewReader(
+		"kind: pipeline\ntype: kubernetes\nname: default\nstepz: []\n",
+	))
+	ValidateHandler().ServeHTTP(w, r)
+	if got, want := w.Code, 200; got != want {
+		t.Errorf("Want status %d, got %d", want, got)
+	}
+	out := struct {
+		Errors []string `json:"errors"`
+	}{}
+	json.NewDecoder(w.Body).Decode(&out)
+	if len(out.Errors) != 1 || out.Errors[0] != "pipeline default: stepz: unknown field" {
+		t.Errorf("Want unknown field error, got %v", out.Errors)
+	}
+}
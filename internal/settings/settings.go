@@ -0,0 +1,114 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package settings provides per-repository runner settings
+// sourced from a remote settings extension.
+package settings
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/drone/drone-go/drone"
+)
+
+type (
+	// Request provides arguments for requesting settings.
+	Request struct {
+		Repo  *drone.Repo  `json:"repo"`
+		Build *drone.Build `json:"build"`
+	}
+
+	// Settings provides runner settings for a repository,
+	// which override the runner defaults. This gives platform
+	// teams the option to roll out runner features to
+	// individual repositories.
+	Settings struct {
+		Workspace    Workspace         `json:"workspace"`
+		NodeSelector map[string]string `json:"node_selector"`
+	}
+
+	// Workspace provides the workspace volume settings. Nil
+	// values do not override the runner defaults.
+	Workspace struct {
+		Backend      string `json:"backend"`
+		Claim        *bool  `json:"claim"`
+		StorageClass string `json:"storage_class"`
+		Size         int64  `json:"size"`
+		Snapshot     *bool  `json:"snapshot"`
+	}
+
+	// Provider returns settings for the repository.
+	Provider interface {
+		Find(context.Context, *Request) (*Settings, error)
+	}
+)
+
+// External returns a provider that requests repository
+// settings from a remote settings extension.
+func External(endpoint, token string, skipverify bool) Provider {
+	client := http.DefaultClient
+	if skipverify {
+		client = &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		}
+	}
+	return &external{
+		endpoint: endpoint,
+		token:    token,
+		client:   client,
+	}
+}
+
+type external struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func (e *external) Find(ctx context.Context, in *Request) (*Settings, error) {
+	if e.endpoint == "" {
+		return nil, nil
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+e.token)
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// the extension returns no content if there are no
+	// settings for the repository.
+	if res.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("settings: extension returned status %d", res.StatusCode)
+	}
+	out := new(Settings)
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
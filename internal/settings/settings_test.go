@@ -0,0 +1,71 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func TestExternal(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer secret"; got != want {
+			t.Errorf("Want authorization header %q, got %q", want, got)
+		}
+		in := new(Request)
+		json.NewDecoder(r.Body).Decode(in)
+		if in.Repo.Slug != "octocat/hello-world" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"workspace":{"claim":true,"size":10737418240},"node_selector":{"pool":"canary"}}`))
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "secret", false)
+	out, err := provider.Find(context.Background(), &Request{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{Number: 1},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if out.Workspace.Claim == nil || *out.Workspace.Claim == false {
+		t.Errorf("Want workspace claim enabled")
+	}
+	if got, want := out.Workspace.Size, int64(10737418240); got != want {
+		t.Errorf("Want workspace size %d, got %d", want, got)
+	}
+	if out.Workspace.Snapshot != nil {
+		t.Errorf("Want workspace snapshot not overridden")
+	}
+	if got, want := out.NodeSelector["pool"], "canary"; got != want {
+		t.Errorf("Want node pool %q, got %q", want, got)
+	}
+
+	out, err = provider.Find(context.Background(), &Request{
+		Repo:  &drone.Repo{Slug: "spaceghost/hello-world"},
+		Build: &drone.Build{Number: 1},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if out != nil {
+		t.Errorf("Want no settings for the repository")
+	}
+}
+
+func TestExternal_NoEndpoint(t *testing.T) {
+	out, err := External("", "", false).Find(context.Background(), &Request{})
+	if err != nil || out != nil {
+		t.Errorf("Want no settings without an endpoint")
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package spool provides a client that persists log lines
+// and status updates that cannot be sent to the server, and
+// retries when connectivity is restored.
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+	"github.com/sirupsen/logrus"
+)
+
+// maximum number of lines sent to the server in a single
+// request when retrying.
+const chunkSize = 500
+
+// errLimitExceeded is returned when the spool directory size
+// limit is exceeded.
+var errLimitExceeded = errors.New("spool: size limit exceeded")
+
+// pending stores the log lines that are not yet sent to the
+// server. If the full log is pending, the pending batches are
+// discarded, because the full log replaces the live log.
+type pending struct {
+	Batch  []*drone.Line `json:"batch,omitempty"`
+	Upload []*drone.Line `json:"upload,omitempty"`
+}
+
+// Client wraps the remote client and persists log lines to
+// the spool directory if the lines cannot be sent to the
+// server. Pending lines are sent in chunks, so that a retry
+// resumes from the last line received by the server.
+type Client struct {
+	client.Client
+
+	dir   string
+	limit int64
+
+	mu      sync.Mutex
+	pending map[int64]*pending
+
+	// status updates are queued if wait is non-zero.
+	wait     time.Duration
+	stages   map[int64]*drone.Stage
+	steps    map[int64]*drone.Step
+	versions map[string]int64
+}
+
+// New returns a new spool client. Pending log lines that were
+// persisted by a previous runner process are loaded from the
+// spool directory.
+func New(c client.Client, dir string, limit int64) (*Client, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Client{
+		Client:   c,
+		dir:      dir,
+		limit:    limit,
+		pending:  map[int64]*pending{},
+		stages:   map[int64]*drone.Stage{},
+		steps:    map[int64]*drone.Step{},
+		versions: map[string]int64{},
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), "stage-") || strings.HasPrefix(file.Name(), "step-") {
+			if err := s.load(file.Name()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		p := new(pending)
+		if err := json.Unmarshal(raw, p); err != nil {
+			logrus.WithError(err).
+				WithField("file", file.Name()).
+				Warnln("cannot read spooled log lines")
+			continue
+		}
+		s.pending[id] = p
+	}
+	return s, nil
+}
+
+// Batch sends the log lines to the live log stream. If the
+// lines cannot be sent, the lines are persisted and sent when
+// connectivity is restored.
+func (s *Client) Batch(ctx context.Context, step int64, lines []*drone.Line) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// if lines are pending for the step, the lines are
+	// appended to the pending lines to preserve order.
+	if p, ok := s.pending[step]; ok {
+		if p.Upload == nil {
+			p.Batch = append(p.Batch, lines...)
+		}
+		return s.save(step, p)
+	}
+	if err := s.Client.Batch(ctx, step, lines); err != nil {
+		logrus.WithError(err).
+			WithField("step", step).
+			Debugln("cannot send log lines, spooling")
+		p := &pending{Batch: lines}
+		s.pending[step] = p
+		return s.save(step, p)
+	}
+	return nil
+}
+
+// Upload uploads the full log. If the log cannot be uploaded,
+// the log is persisted and uploaded when connectivity is
+// restored.
+func (s *Client) Upload(ctx context.Context, step int64, lines []*drone.Line) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.Client.Upload(ctx, step, lines); err != nil {
+		logrus.WithError(err).
+			WithField("step", step).
+			Warnln("cannot upload logs, spooling")
+		p := &pending{Upload: lines}
+		s.pending[step] = p
+		return s.save(step, p)
+	}
+	delete(s.pending, step)
+	s.remove(step)
+	return nil
+}
+
+// Run sends the pending log lines to the server at the given
+// interval until the context is cancelled.
+func (s *Client) Run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			s.flush(ctx)
+		}
+	}
+}
+
+// helper function sends the pending log lines to the server.
+func (s *Client) flush(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flushStatus(ctx)
+	for step, p := range s.pending {
+		if err := s.send(ctx, step, p); err != nil {
+			logrus.WithError(err).
+				WithField("step", step).
+				Debugln("cannot send spooled log lines")
+			continue
+		}
+		delete(s.pending, step)
+		s.remove(step)
+	}
+}
+
+// helper function sends the pending log lines for the step.
+// Batches are sent in chunks, and the pending lines are saved
+// after each chunk so that a retry resumes from the first line
+// that was not sent.
+func (s *Client) send(ctx context.Context, step int64, p *pending) error {
+	if p.Upload != nil {
+		return s.Client.Upload(ctx, step, p.Upload)
+	}
+	for len(p.Batch) != 0 {
+		n := chunkSize
+		if n > len(p.Batch) {
+			n = len(p.Batch)
+		}
+		if err := s.Client.Batch(ctx, step, p.Batch[:n]); err != nil {
+			return err
+		}
+		p.Batch = p.Batch[n:]
+		if err := s.save(step, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function persists the pending lines to the spool
+// directory.
+func (s *Client) save(step int64, p *pending) error {
+	return s.write(s.path(step), p)
+}
+
+// helper function persists the value to the spool file. If
+// the size limit is exceeded, the value is kept in memory only.
+func (s *Client) write(path string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if s.limit > 0 {
+		var existing int64
+		if info, err := os.Stat(path); err == nil {
+			existing = info.Size()
+		}
+		if s.size()-existing+int64(len(raw)) > s.limit {
+			logrus.WithField("file", filepath.Base(path)).
+				WithField("limit", s.limit).
+				Warnln("spool size limit exceeded, updates are kept in memory only")
+			return errLimitExceeded
+		}
+	}
+	return ioutil.WriteFile(path, raw, 0600)
+}
+
+// helper function removes the pending lines from the spool
+// directory.
+func (s *Client) remove(step int64) {
+	os.Remove(s.path(step))
+}
+
+// helper function returns the size of the spool directory.
+func (s *Client) size() int64 {
+	var total int64
+	files, _ := ioutil.ReadDir(s.dir)
+	for _, file := range files {
+		total += file.Size()
+	}
+	return total
+}
+
+// helper function returns the path of the spool file for
+// the step.
+func (s *Client) path(step int64) string {
+	return filepath.Join(s.dir, strconv.FormatInt(step, 10)+".json")
+}
@@ -0,0 +1,255 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package spool
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+)
+
+var errOffline = errors.New("offline")
+
+type fakeClient struct {
+	client.Client
+
+	offline bool
+	batches [][]*drone.Line
+	uploads [][]*drone.Line
+	stages  []*drone.Stage
+	steps   []*drone.Step
+}
+
+func (c *fakeClient) Batch(ctx context.Context, step int64, lines []*drone.Line) error {
+	if c.offline {
+		return errOffline
+	}
+	c.batches = append(c.batches, lines)
+	return nil
+}
+
+func (c *fakeClient) Upload(ctx context.Context, step int64, lines []*drone.Line) error {
+	if c.offline {
+		return errOffline
+	}
+	c.uploads = append(c.uploads, lines)
+	return nil
+}
+
+func (c *fakeClient) Update(ctx context.Context, stage *drone.Stage) error {
+	if c.offline {
+		return errOffline
+	}
+	stage.Version++
+	c.stages = append(c.stages, stage)
+	return nil
+}
+
+func (c *fakeClient) UpdateStep(ctx context.Context, step *drone.Step) error {
+	if c.offline {
+		return errOffline
+	}
+	step.Version++
+	c.steps = append(c.steps, step)
+	return nil
+}
+
+func TestSpool_Batch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	fake := &fakeClient{offline: true}
+	s, err := New(fake, dir, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	lines := make([]*drone.Line, chunkSize+1)
+	for i := range lines {
+		lines[i] = &drone.Line{Number: i}
+	}
+	s.Batch(context.Background(), 1, lines[:chunkSize])
+	s.Batch(context.Background(), 1, lines[chunkSize:])
+
+	// the pending lines are loaded by a new client, for
+	// example after the runner restarts.
+	fake.offline = false
+	s, err = New(fake, dir, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.flush(context.Background())
+
+	if got, want := len(fake.batches), 2; got != want {
+		t.Errorf("Want %d chunks sent, got %d", want, got)
+		return
+	}
+	if got, want := fake.batches[1][0].Number, chunkSize; got != want {
+		t.Errorf("Want second chunk to resume at line %d, got %d", want, got)
+	}
+	if _, ok := s.pending[1]; ok {
+		t.Errorf("Expect pending lines removed")
+	}
+	if _, err := os.Stat(s.path(1)); !os.IsNotExist(err) {
+		t.Errorf("Expect spool file removed")
+	}
+}
+
+func TestSpool_Upload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	fake := &fakeClient{offline: true}
+	s, err := New(fake, dir, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	lines := []*drone.Line{{Number: 0}, {Number: 1}}
+	s.Batch(context.Background(), 1, lines[:1])
+	s.Upload(context.Background(), 1, lines)
+
+	fake.offline = false
+	s.flush(context.Background())
+
+	if got, want := len(fake.batches), 0; got != want {
+		t.Errorf("Want pending batches discarded once the full log is pending, got %d", got)
+	}
+	if got, want := len(fake.uploads), 1; got != want {
+		t.Errorf("Want %d uploads, got %d", want, got)
+	}
+}
+
+func TestSpool_Limit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(&fakeClient{offline: true}, dir, 10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Batch(context.Background(), 1, []*drone.Line{{Message: "hello world"}})
+	if err != errLimitExceeded {
+		t.Errorf("Want limit exceeded error, got %v", err)
+	}
+	if _, ok := s.pending[1]; !ok {
+		t.Errorf("Expect pending lines kept in memory")
+	}
+}
+
+func TestSpool_UpdateStep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	fake := &fakeClient{offline: true}
+	s, err := New(fake, dir, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.QueueUpdates(time.Minute)
+
+	step := &drone.Step{ID: 1, Status: drone.StatusRunning}
+	if err := s.UpdateStep(context.Background(), step); err != nil {
+		t.Errorf("Want step update spooled, got error %s", err)
+	}
+	step.Status = drone.StatusPassing
+	s.UpdateStep(context.Background(), step)
+
+	// the pending updates are loaded by a new client, for
+	// example after the runner restarts.
+	fake.offline = false
+	s, err = New(fake, dir, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.QueueUpdates(time.Minute)
+	s.flush(context.Background())
+
+	if got, want := len(fake.steps), 1; got != want {
+		t.Errorf("Want the latest step update sent, got %d updates", got)
+		return
+	}
+	if got, want := fake.steps[0].Status, drone.StatusPassing; got != want {
+		t.Errorf("Want step status %s, got %s", want, got)
+	}
+	if _, err := os.Stat(s.statusPath(stepKey(1))); !os.IsNotExist(err) {
+		t.Errorf("Expect spool file removed")
+	}
+
+	// the next update uses the version of the spooled update
+	// received by the server.
+	s.UpdateStep(context.Background(), step)
+	if got, want := step.Version, int64(2); got != want {
+		t.Errorf("Want step version %d, got %d", want, got)
+	}
+}
+
+func TestSpool_Update(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	backoff = time.Millisecond
+	defer func() { backoff = 5 * time.Second }()
+
+	fake := &fakeClient{offline: true}
+	s, err := New(fake, dir, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.QueueUpdates(10 * time.Millisecond)
+
+	// the running stage update is required to start the
+	// stage, and is retried until the wait duration elapses.
+	stage := &drone.Stage{ID: 1, Steps: []*drone.Step{{Name: "build"}}}
+	if err := s.Update(context.Background(), stage); err != errOffline {
+		t.Errorf("Want error once the wait duration elapses, got %v", err)
+	}
+
+	stage.Steps[0].ID = 2
+	if err := s.Update(context.Background(), stage); err != nil {
+		t.Errorf("Want stage update spooled, got error %s", err)
+	}
+	if _, ok := s.stages[1]; !ok {
+		t.Errorf("Expect pending stage update")
+	}
+
+	fake.offline = false
+	s.flush(context.Background())
+	if got, want := len(fake.stages), 1; got != want {
+		t.Errorf("Want %d stage updates, got %d", want, got)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+	"github.com/sirupsen/logrus"
+)
+
+// backoff is the duration the client waits before it retries
+// a request that is required to start an accepted stage.
+var backoff = 5 * time.Second
+
+// QueueUpdates configures the client to persist the stage and
+// step status updates that cannot be sent to the server, so
+// that a short server outage does not fail running stages. The
+// stage details and the running stage update are required to
+// start an accepted stage, and are retried until the wait
+// duration elapses.
+func (s *Client) QueueUpdates(wait time.Duration) {
+	s.wait = wait
+}
+
+// Detail fetches the stage details. If status updates are
+// queued, the request is retried until the server is reachable
+// or the wait duration elapses.
+func (s *Client) Detail(ctx context.Context, stage *drone.Stage) (*client.Context, error) {
+	var out *client.Context
+	err := s.retry(ctx, func() (err error) {
+		out, err = s.Client.Detail(ctx, stage)
+		return
+	})
+	return out, err
+}
+
+// Update updates the stage. If status updates are queued and
+// the stage cannot be updated, the latest stage status is
+// persisted and sent when connectivity is restored.
+func (s *Client) Update(ctx context.Context, stage *drone.Stage) error {
+	if s.wait == 0 {
+		return s.Client.Update(ctx, stage)
+	}
+
+	// the server creates the stage steps when the stage is
+	// started, and the step identifiers are required to report
+	// the steps. The running stage update is retried.
+	if !hasSteps(stage) {
+		return s.retry(ctx, func() error {
+			return s.Client.Update(ctx, stage)
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stageKey(stage.ID)
+	if _, ok := s.stages[stage.ID]; !ok {
+		s.restoreVersion(key, &stage.Version)
+		err := s.Client.Update(ctx, stage)
+		if !retryable(ctx, err) {
+			return err
+		}
+		logrus.WithError(err).
+			WithField("stage", stage.ID).
+			Warnln("cannot update stage, spooling")
+	}
+	s.stages[stage.ID] = copyStage(stage)
+	s.write(s.statusPath(key), s.stages[stage.ID])
+	return nil
+}
+
+// UpdateStep updates the step. If status updates are queued
+// and the step cannot be updated, the latest step status is
+// persisted and sent when connectivity is restored.
+func (s *Client) UpdateStep(ctx context.Context, step *drone.Step) error {
+	if s.wait == 0 {
+		return s.Client.UpdateStep(ctx, step)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stepKey(step.ID)
+	if _, ok := s.steps[step.ID]; !ok {
+		s.restoreVersion(key, &step.Version)
+		err := s.Client.UpdateStep(ctx, step)
+		if !retryable(ctx, err) {
+			return err
+		}
+		logrus.WithError(err).
+			WithField("step", step.ID).
+			Warnln("cannot update step, spooling")
+	}
+	copy := *step
+	s.steps[step.ID] = &copy
+	s.write(s.statusPath(key), &copy)
+	return nil
+}
+
+// helper function sends the pending status updates to the
+// server. Steps are updated before stages, so that the server
+// does not complete a stage with pending steps. The version of
+// each update received by the server is stored, so that the
+// next update of the stage or step is not rejected.
+func (s *Client) flushStatus(ctx context.Context) {
+	for id, step := range s.steps {
+		key := stepKey(id)
+		err := s.Client.UpdateStep(ctx, step)
+		if retryable(ctx, err) {
+			logrus.WithError(err).
+				WithField("step", id).
+				Debugln("cannot send spooled step update")
+			return
+		}
+		if err == nil {
+			s.versions[key] = step.Version
+		}
+		delete(s.steps, id)
+		os.Remove(s.statusPath(key))
+	}
+	for id, stage := range s.stages {
+		key := stageKey(id)
+		err := s.Client.Update(ctx, stage)
+		if retryable(ctx, err) {
+			logrus.WithError(err).
+				WithField("stage", id).
+				Debugln("cannot send spooled stage update")
+			return
+		}
+		if err == nil {
+			s.versions[key] = stage.Version
+		}
+		delete(s.stages, id)
+		os.Remove(s.statusPath(key))
+	}
+}
+
+// helper function retries the request until the request
+// succeeds, the error is not retryable, or the wait duration
+// elapses.
+func (s *Client) retry(ctx context.Context, fn func() error) error {
+	deadline := time.Now().Add(s.wait)
+	for {
+		err := fn()
+		if !retryable(ctx, err) || time.Now().After(deadline) {
+			return err
+		}
+		logrus.WithError(err).
+			Warnln("cannot reach the server, retrying")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// helper function sets the version of the stage or step to
+// the version of the last spooled update received by the
+// server.
+func (s *Client) restoreVersion(key string, version *int64) {
+	if v, ok := s.versions[key]; ok {
+		*version = v
+		delete(s.versions, key)
+	}
+}
+
+// helper function loads the spooled status update from the
+// spool directory.
+func (s *Client) load(name string) error {
+	raw, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(name, "stage-") {
+		stage := new(drone.Stage)
+		err = json.Unmarshal(raw, stage)
+		if err == nil {
+			s.stages[stage.ID] = stage
+		}
+	} else {
+		step := new(drone.Step)
+		err = json.Unmarshal(raw, step)
+		if err == nil {
+			s.steps[step.ID] = step
+		}
+	}
+	if err != nil {
+		logrus.WithError(err).
+			WithField("file", name).
+			Warnln("cannot read spooled status update")
+	}
+	return nil
+}
+
+// helper function returns the path of the spool file for the
+// status update.
+func (s *Client) statusPath(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+// helper function returns true if the request failed and can
+// be retried. Optimistic lock errors are returned by the
+// server, and are not retried.
+func retryable(ctx context.Context, err error) bool {
+	return err != nil && err != client.ErrOptimisticLock && ctx.Err() == nil
+}
+
+// helper function returns true if the server created the
+// stage steps.
+func hasSteps(stage *drone.Stage) bool {
+	for _, step := range stage.Steps {
+		if step.ID == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function returns a copy of the stage.
+func copyStage(stage *drone.Stage) *drone.Stage {
+	out := *stage
+	out.Steps = nil
+	for _, step := range stage.Steps {
+		copy := *step
+		out.Steps = append(out.Steps, &copy)
+	}
+	return &out
+}
+
+// helper functions return the spool file keys of the stage
+// and step status updates.
+func stageKey(id int64) string {
+	return fmt.Sprintf("stage-%d", id)
+}
+
+func stepKey(id int64) string {
+	return fmt.Sprintf("step-%d", id)
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package timeouts provides the timeout hierarchy of a
+// pipeline: the time the pod may wait to be scheduled, the time
+// a step may wait for its image, the default step timeout, the
+// maximum stage timeout and the time allowed to tear down the
+// pipeline. The runner defaults can be overridden for matching
+// repositories by policies.
+package timeouts
+
+import (
+	"path"
+	"time"
+)
+
+type (
+	// Timeouts defines the pipeline timeouts. A zero timeout
+	// is not enforced.
+	Timeouts struct {
+		// Schedule provides the maximum time the pipeline pod
+		// waits to be scheduled on a node.
+		Schedule time.Duration `yaml:"schedule"`
+
+		// Pull provides the maximum time a step waits for its
+		// container to start once the pod is scheduled, which
+		// is dominated by the image pull.
+		Pull time.Duration `yaml:"pull"`
+
+		// Step provides the default timeout of steps that do
+		// not define a timeout.
+		Step time.Duration `yaml:"step"`
+
+		// Stage provides the maximum stage timeout. The
+		// repository timeout is capped to the maximum.
+		Stage time.Duration `yaml:"stage"`
+
+		// Teardown provides the maximum time allowed to stop
+		// the sidecars and delete the pipeline resources.
+		Teardown time.Duration `yaml:"teardown"`
+	}
+
+	// Policy overrides the timeouts of the repositories that
+	// match the repository patterns. Zero timeouts are not
+	// overridden.
+	Policy struct {
+		Name     string   `yaml:"name"`
+		Repos    []string `yaml:"repos"`
+		Timeouts `yaml:",inline"`
+	}
+)
+
+// Table resolves the timeouts of a repository.
+type Table struct {
+	defaults Timeouts
+	policies []*Policy
+}
+
+// New returns a new table with the default timeouts and the
+// policies that override the defaults.
+func New(defaults Timeouts, policies []*Policy) *Table {
+	return &Table{defaults: defaults, policies: policies}
+}
+
+// Lookup returns the timeouts of the repository, which are the
+// defaults overridden by the first policy that matches the
+// repository. A nil table returns zero timeouts.
+func (t *Table) Lookup(repo string) Timeouts {
+	if t == nil {
+		return Timeouts{}
+	}
+	out := t.defaults
+	for _, policy := range t.policies {
+		if !match(policy.Repos, repo) {
+			continue
+		}
+		override(&out.Schedule, policy.Schedule)
+		override(&out.Pull, policy.Pull)
+		override(&out.Step, policy.Step)
+		override(&out.Stage, policy.Stage)
+		override(&out.Teardown, policy.Teardown)
+		break
+	}
+	return out
+}
+
+// helper function overrides the timeout if not zero.
+func override(dst *time.Duration, src time.Duration) {
+	if src != 0 {
+		*dst = src
+	}
+}
+
+// helper function returns true if the repository matches any
+// of the patterns. If there are no patterns, all repositories
+// match.
+func match(patterns []string, repo string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return true
+		}
+	}
+	return false
+}
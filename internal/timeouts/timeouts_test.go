@@ -0,0 +1,50 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package timeouts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	table := New(
+		Timeouts{Schedule: 10 * time.Minute, Step: time.Hour, Stage: 2 * time.Hour},
+		[]*Policy{
+			{
+				Name:     "ml",
+				Repos:    []string{"octocat/ml-*"},
+				Timeouts: Timeouts{Stage: 12 * time.Hour, Pull: 30 * time.Minute},
+			},
+			{
+				Name:     "default",
+				Timeouts: Timeouts{Step: 30 * time.Minute},
+			},
+		},
+	)
+
+	got := table.Lookup("octocat/ml-models")
+	want := Timeouts{
+		Schedule: 10 * time.Minute,
+		Pull:     30 * time.Minute,
+		Step:     time.Hour,
+		Stage:    12 * time.Hour,
+	}
+	if got != want {
+		t.Errorf("Want the first matching policy to override the defaults, got %+v", got)
+	}
+
+	got = table.Lookup("octocat/hello-world")
+	if got.Step != 30*time.Minute || got.Stage != 2*time.Hour {
+		t.Errorf("Want policy without repos to match all repositories, got %+v", got)
+	}
+}
+
+func TestLookup_Nil(t *testing.T) {
+	var table *Table
+	if got := table.Lookup("octocat/hello-world"); got != (Timeouts{}) {
+		t.Errorf("Want zero timeouts for nil table")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package tlsconfig provides the tls configuration used for
+// outbound connections, and for servers that require mutual
+// tls.
+package tlsconfig
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Config configures the tls policy.
+type Config struct {
+	// MinVersion provides the minimum tls version, for
+	// example 1.2.
+	MinVersion string
+
+	// CipherSuites provides the list of permitted cipher
+	// suites for tls 1.2 and earlier.
+	CipherSuites []string
+
+	// CAFile provides the path to a file with the pem-encoded
+	// certificate authorities used to verify server
+	// certificates, replacing the system certificate pool.
+	CAFile string
+
+	// Pins provides a list of base64-encoded sha256 hashes
+	// of the subject public key info of certificates in the
+	// verified chain. If provided, the connection is rejected
+	// unless a certificate in the chain matches a pin.
+	Pins []string
+}
+
+// list of supported tls versions.
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// list of supported cipher suites.
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+}
+
+// errPinMismatch is returned when no certificate in the
+// verified chain matches a pin.
+var errPinMismatch = errors.New("tls: certificate does not match a pinned public key")
+
+// New returns the tls configuration for the policy. A nil
+// value is returned if the policy is empty.
+func New(config Config) (*tls.Config, error) {
+	if config.MinVersion == "" && len(config.CipherSuites) == 0 && config.CAFile == "" && len(config.Pins) == 0 {
+		return nil, nil
+	}
+	out := new(tls.Config)
+	if v := config.MinVersion; v != "" {
+		version, ok := versions[v]
+		if !ok {
+			return nil, fmt.Errorf("tls: unsupported version: %s", v)
+		}
+		out.MinVersion = version
+	}
+	for _, name := range config.CipherSuites {
+		suite, ok := cipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("tls: unsupported cipher suite: %s", name)
+		}
+		out.CipherSuites = append(out.CipherSuites, suite)
+	}
+	if file := config.CAFile; file != "" {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("tls: no certificates found in %s", file)
+		}
+		out.RootCAs = pool
+	}
+	if len(config.Pins) != 0 {
+		out.VerifyPeerCertificate = verifyPins(config.Pins)
+	}
+	return out, nil
+}
+
+// Server returns the tls configuration for a server that
+// requires clients to present a certificate signed by the
+// certificate authorities in the client ca file.
+func Server(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", clientCAFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// helper function returns a function that verifies a
+// certificate in the verified chain matches a pin.
+func verifyPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	set := map[string]bool{}
+	for _, pin := range pins {
+		set[pin] = true
+	}
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if set[Pin(cert)] {
+					return nil
+				}
+			}
+		}
+		return errPinMismatch
+	}
+}
+
+// Pin returns the base64-encoded sha256 hash of the subject
+// public key info of the certificate.
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
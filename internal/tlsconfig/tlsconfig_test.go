@@ -0,0 +1,85 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	config, err := New(Config{
+		MinVersion:   "1.2",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := config.MinVersion, uint16(tls.VersionTLS12); got != want {
+		t.Errorf("Want min version %d, got %d", want, got)
+	}
+	if got, want := len(config.CipherSuites), 1; got != want {
+		t.Errorf("Want %d cipher suites, got %d", want, got)
+	}
+}
+
+func TestNew_Empty(t *testing.T) {
+	config, err := New(Config{})
+	if err != nil {
+		t.Error(err)
+	}
+	if config != nil {
+		t.Errorf("Expect nil configuration for empty policy")
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New(Config{MinVersion: "2.0"}); err == nil {
+		t.Errorf("Expect error for unsupported version")
+	}
+	if _, err := New(Config{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}); err == nil {
+		t.Errorf("Expect error for unsupported cipher suite")
+	}
+}
+
+func TestPins(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	tests := []struct {
+		pins []string
+		ok   bool
+	}{
+		{pins: []string{Pin(ts.Certificate())}, ok: true},
+		{pins: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}, ok: false},
+	}
+	for i, test := range tests {
+		config := &tls.Config{
+			RootCAs:               pool,
+			VerifyPeerCertificate: verifyPins(test.pins),
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		res, err := client.Get(ts.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		if got, want := err == nil, test.ok; got != want {
+			t.Errorf("Want success %v, got error %v at index %d", want, err, i)
+		}
+	}
+}
+
+func TestServer_Invalid(t *testing.T) {
+	if _, err := Server("testdata/missing.crt", "testdata/missing.key", "testdata/missing.pem"); err == nil {
+		t.Errorf("Expect error for missing certificate")
+	}
+}
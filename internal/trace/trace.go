@@ -0,0 +1,77 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package trace records pipeline execution timings in the
+// Chrome trace event format. The trace can be loaded in
+// chrome://tracing or https://ui.perfetto.dev to find the
+// critical path of a pipeline.
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event is a Chrome trace complete event.
+type Event struct {
+	Name      string            `json:"name"`
+	Category  string            `json:"cat"`
+	Phase     string            `json:"ph"`
+	Timestamp int64             `json:"ts"`
+	Duration  int64             `json:"dur"`
+	PID       int               `json:"pid"`
+	TID       int               `json:"tid"`
+	Args      map[string]string `json:"args,omitempty"`
+}
+
+// Trace records pipeline execution timings.
+type Trace struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []*Event
+}
+
+// New returns a new trace.
+func New() *Trace {
+	return &Trace{start: time.Now()}
+}
+
+// Begin records the start of the named span and returns a
+// function that records the end of the span. The lane is used
+// to render concurrent spans on separate rows.
+func (t *Trace) Begin(category, name string, lane int) func(args map[string]string) {
+	start := time.Now()
+	return func(args map[string]string) {
+		end := time.Now()
+		t.mu.Lock()
+		t.events = append(t.events, &Event{
+			Name:      name,
+			Category:  category,
+			Phase:     "X",
+			Timestamp: int64(start.Sub(t.start) / time.Microsecond),
+			Duration:  int64(end.Sub(start) / time.Microsecond),
+			PID:       1,
+			TID:       lane,
+			Args:      args,
+		})
+		t.mu.Unlock()
+	}
+}
+
+// Events returns the recorded events.
+func (t *Trace) Events() []*Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Event(nil), t.events...)
+}
+
+// Write writes the trace to w in the Chrome trace format.
+func (t *Trace) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"traceEvents":     t.Events(),
+		"displayTimeUnit": "ms",
+	})
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	tr := New()
+	end := tr.Begin("step", "build", 2)
+	end(map[string]string{"exit_code": "0"})
+
+	events := tr.Events()
+	if got, want := len(events), 1; got != want {
+		t.Errorf("Want %d events, got %d", want, got)
+		return
+	}
+	if got, want := events[0].Name, "build"; got != want {
+		t.Errorf("Want event name %q, got %q", want, got)
+	}
+	if got, want := events[0].TID, 2; got != want {
+		t.Errorf("Want event lane %d, got %d", want, got)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tr.Write(buf); err != nil {
+		t.Error(err)
+		return
+	}
+	out := struct {
+		TraceEvents []*Event `json:"traceEvents"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := out.TraceEvents[0].Phase, "X"; got != want {
+		t.Errorf("Want complete event phase %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package tunnel provides a dialer that connects through a
+// SOCKS5 or HTTP CONNECT proxy, for example a bastion host or
+// the konnectivity server of a firewalled cluster, and a local
+// forwarder that exposes a remote address through the tunnel.
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DialFunc dials the address through the tunnel.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// New returns a dialer for the proxy url. The url scheme is
+// socks5, http or https. The tls configuration is used to
+// connect to https proxies, and may be nil.
+func New(rawurl string, config *tls.Config) (DialFunc, error) {
+	proxy, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		return socks5(proxy), nil
+	case "http", "https":
+		return connect(proxy, config), nil
+	default:
+		return nil, fmt.Errorf("tunnel: unsupported proxy scheme %q", proxy.Scheme)
+	}
+}
+
+// Forward listens on a local address and forwards each
+// connection to the remote address through the tunnel, until
+// the context is cancelled. The local address is returned.
+func Forward(ctx context.Context, dial DialFunc, addr string) (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go func() {
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer local.Close()
+				remote, err := dial(ctx, "tcp", addr)
+				if err != nil {
+					logrus.WithError(err).
+						WithField("addr", addr).
+						Warnln("tunnel: cannot connect to the remote address")
+					return
+				}
+				defer remote.Close()
+				done := make(chan struct{}, 2)
+				go func() { io.Copy(remote, local); done <- struct{}{} }()
+				go func() { io.Copy(local, remote); done <- struct{}{} }()
+				<-done
+			}()
+		}
+	}()
+	return listener.Addr().String(), nil
+}
+
+// helper function returns a dialer that connects through the
+// SOCKS5 proxy, with optional username and password
+// authentication. The address is resolved by the proxy.
+func socks5(proxy *url.URL) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", proxy.Host)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+			defer conn.SetDeadline(time.Time{})
+		}
+		if err := socks5Handshake(conn, proxy.User, addr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// helper function negotiates the SOCKS5 connection to the
+// address, as defined in RFC 1928 and RFC 1929.
+func socks5Handshake(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portstr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portstr)
+	if err != nil {
+		return err
+	}
+	if len(host) > 255 {
+		return errors.New("tunnel: host name too long")
+	}
+
+	methods := []byte{0x00}
+	if user != nil {
+		methods = []byte{0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch {
+	case reply[0] != 0x05:
+		return errors.New("tunnel: proxy is not a socks5 proxy")
+	case reply[1] == 0x02 && user != nil:
+		password, _ := user.Password()
+		req := []byte{0x01, byte(len(user.Username()))}
+		req = append(req, user.Username()...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("tunnel: socks5 authentication failed")
+		}
+	case reply[1] != 0x00:
+		return errors.New("tunnel: no acceptable socks5 authentication method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	req = append(req, host...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("tunnel: socks5 connect to %s failed with code %d", addr, header[1])
+	}
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return err
+		}
+		skip = int(size[0])
+	default:
+		return errors.New("tunnel: invalid socks5 address type")
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// helper function returns a dialer that connects through the
+// HTTP CONNECT proxy, for example the konnectivity server in
+// http-connect mode.
+func connect(proxy *url.URL, config *tls.Config) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", proxy.Host)
+		if err != nil {
+			return nil, err
+		}
+		if proxy.Scheme == "https" {
+			c := config.Clone()
+			if c == nil {
+				c = new(tls.Config)
+			}
+			if c.ServerName == "" {
+				c.ServerName = proxy.Hostname()
+			}
+			conn = tls.Client(conn, c)
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+			defer conn.SetDeadline(time.Time{})
+		}
+		req := &http.Request{
+			Method: "CONNECT",
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: http.Header{},
+		}
+		if user := proxy.User; user != nil {
+			password, _ := user.Password()
+			req.Header.Set("Proxy-Authorization", "Basic "+
+				base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+		}
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, req)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		// the response body is not closed, because the body of
+		// a successful connect response is the tunnel itself.
+		if res.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("tunnel: proxy connect to %s failed: %s", addr, res.Status)
+		}
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+}
+
+// bufferedConn reads the data buffered while reading the proxy
+// response before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
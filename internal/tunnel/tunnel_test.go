@@ -0,0 +1,156 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// helper function starts a tcp server that writes the greeting
+// to each connection.
+func testTarget(t *testing.T, greeting string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, greeting)
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// helper function starts an http connect proxy that connects
+// to the target regardless of the requested address.
+func testConnectProxy(t *testing.T, target string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != "CONNECT" || req.Header.Get("Proxy-Authorization") == "" {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				remote, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// helper function starts a socks5 proxy without authentication
+// that connects to the target regardless of the requested
+// address.
+func testSocksProxy(t *testing.T, target string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				header := make([]byte, 2)
+				io.ReadFull(conn, header)
+				io.ReadFull(conn, make([]byte, header[1]))
+				conn.Write([]byte{0x05, 0x00})
+				req := make([]byte, 5)
+				io.ReadFull(conn, req)
+				io.ReadFull(conn, make([]byte, int(req[4])+2))
+				remote, err := net.Dial("tcp", target)
+				if err != nil {
+					conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer remote.Close()
+				conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 80})
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestConnect(t *testing.T) {
+	proxy := testConnectProxy(t, testTarget(t, "hello"))
+	dial, err := New("http://user:pass@"+proxy, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial(context.Background(), "tcp", "kubernetes.edge.local:6443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	out, _ := ioutil.ReadAll(conn)
+	if got, want := string(out), "hello"; got != want {
+		t.Errorf("Want %q through the tunnel, got %q", want, got)
+	}
+
+	dial, _ = New("http://"+proxy, nil)
+	if _, err := dial(context.Background(), "tcp", "kubernetes.edge.local:6443"); err == nil {
+		t.Errorf("Want error if the proxy rejects the connection")
+	}
+}
+
+func TestSocks5(t *testing.T) {
+	proxy := testSocksProxy(t, testTarget(t, "hello"))
+	dial, err := New("socks5://"+proxy, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	local, err := Forward(ctx, dial, "kubernetes.edge.local:6443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	out, _ := ioutil.ReadAll(conn)
+	if got, want := string(out), "hello"; got != want {
+		t.Errorf("Want %q forwarded through the tunnel, got %q", want, got)
+	}
+}
+
+func TestNew_Unsupported(t *testing.T) {
+	if _, err := New("ftp://bastion:21", nil); err == nil {
+		t.Errorf("Want error for unsupported proxy scheme")
+	}
+}
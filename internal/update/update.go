@@ -0,0 +1,143 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package update provides self-update of the runner
+// deployment.
+package update
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// key of the config map entry that provides the desired
+// runner image.
+const imageKey = "image"
+
+// Updater watches a config map for the desired runner image,
+// and updates the image of the runner deployment.
+type Updater struct {
+	Client     kubernetes.Interface
+	Namespace  string
+	ConfigMap  string
+	Deployment string
+	Container  string
+	Interval   time.Duration
+}
+
+// Wait blocks until the desired runner image differs from the
+// image of the runner deployment, and returns the desired
+// image. Wait returns an error if the context is cancelled.
+func (u *Updater) Wait(ctx context.Context) (string, error) {
+	for {
+		image, ok, err := u.check()
+		if err != nil {
+			logrus.WithError(err).
+				WithField("configmap", u.ConfigMap).
+				WithField("deployment", u.Deployment).
+				Warnln("cannot check for runner updates")
+		} else if ok {
+			return image, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(u.Interval):
+		}
+	}
+}
+
+// Run updates the runner deployment each time the desired
+// runner image changes, until the context is cancelled. If
+// the update fails, it is retried on the update interval.
+func (u *Updater) Run(ctx context.Context) {
+	for {
+		image, err := u.Wait(ctx)
+		if err != nil {
+			return
+		}
+		if err := u.Apply(image); err != nil {
+			logrus.WithError(err).
+				WithField("image", image).
+				WithField("deployment", u.Deployment).
+				Errorln("cannot update the runner deployment")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(u.Interval):
+			}
+			continue
+		}
+		logrus.WithField("image", image).
+			WithField("deployment", u.Deployment).
+			Infoln("runner deployment updated")
+	}
+}
+
+// Apply updates the runner deployment to the image. The
+// deployment controller replaces the runner pods with pods
+// running the updated image. Every runner replica applies the
+// update, so the update is retried on conflict and is a no-op
+// if the deployment is already updated.
+func (u *Updater) Apply(image string) error {
+	client := u.Client.AppsV1().Deployments(u.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := client.Get(u.Deployment, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		i, err := u.container(deployment.Spec.Template.Spec.Containers)
+		if err != nil {
+			return err
+		}
+		if deployment.Spec.Template.Spec.Containers[i].Image == image {
+			return nil
+		}
+		deployment.Spec.Template.Spec.Containers[i].Image = image
+		_, err = client.Update(deployment)
+		return err
+	})
+}
+
+// helper function returns the desired image, and true if the
+// image differs from the image of the runner deployment.
+func (u *Updater) check() (string, bool, error) {
+	configMap, err := u.Client.CoreV1().ConfigMaps(u.Namespace).Get(u.ConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return "", false, err
+	}
+	image := configMap.Data[imageKey]
+	if image == "" {
+		return "", false, nil
+	}
+	deployment, err := u.Client.AppsV1().Deployments(u.Namespace).Get(u.Deployment, metav1.GetOptions{})
+	if err != nil {
+		return "", false, err
+	}
+	i, err := u.container(deployment.Spec.Template.Spec.Containers)
+	if err != nil {
+		return "", false, err
+	}
+	return image, deployment.Spec.Template.Spec.Containers[i].Image != image, nil
+}
+
+// helper function returns the index of the runner container,
+// defaulting to the first container if no container name is
+// configured.
+func (u *Updater) container(containers []v1.Container) (int, error) {
+	for i, c := range containers {
+		if u.Container == "" || c.Name == u.Container {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("update: cannot find container %q in deployment %s", u.Container, u.Deployment)
+}
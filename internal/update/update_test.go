@@ -0,0 +1,187 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package update
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestUpdater(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "drone-runner-version", Namespace: "drone"},
+			Data:       map[string]string{"image": "drone/drone-runner-kube:1.1.0"},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "drone-runner", Namespace: "drone"},
+			Spec: appsv1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{
+							{Name: "proxy", Image: "envoyproxy/envoy"},
+							{Name: "runner", Image: "drone/drone-runner-kube:1.0.0"},
+						},
+					},
+				},
+			},
+		},
+	)
+	updater := &Updater{
+		Client:     client,
+		Namespace:  "drone",
+		ConfigMap:  "drone-runner-version",
+		Deployment: "drone-runner",
+		Container:  "runner",
+		Interval:   time.Millisecond,
+	}
+
+	image, err := updater.Wait(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := image, "drone/drone-runner-kube:1.1.0"; got != want {
+		t.Errorf("Want image %q, got %q", want, got)
+	}
+	if err := updater.Apply(image); err != nil {
+		t.Error(err)
+		return
+	}
+
+	deployment, _ := client.AppsV1().Deployments("drone").Get("drone-runner", metav1.GetOptions{})
+	containers := deployment.Spec.Template.Spec.Containers
+	if got, want := containers[1].Image, image; got != want {
+		t.Errorf("Want updated image %q, got %q", want, got)
+	}
+	if got, want := containers[0].Image, "envoyproxy/envoy"; got != want {
+		t.Errorf("Want unchanged image %q, got %q", want, got)
+	}
+
+	// once the deployment is updated, the updater waits for
+	// the next image.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := updater.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded, got %v", err)
+	}
+}
+
+func TestUpdater_Conflict(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "drone-runner", Namespace: "drone"},
+			Spec: appsv1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{
+							{Name: "runner", Image: "drone/drone-runner-kube:1.0.0"},
+						},
+					},
+				},
+			},
+		},
+	)
+	// the first update conflicts with the update of a peer
+	// runner replica.
+	updates := 0
+	client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates == 1 {
+			return true, nil, kerrors.NewConflict(schema.GroupResource{Resource: "deployments"}, "drone-runner", nil)
+		}
+		return false, nil, nil
+	})
+	updater := &Updater{
+		Client:     client,
+		Namespace:  "drone",
+		Deployment: "drone-runner",
+	}
+	if err := updater.Apply("drone/drone-runner-kube:1.1.0"); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := updates, 2; got != want {
+		t.Errorf("Want update retried on conflict, got %d updates", got)
+	}
+
+	// the update is a no-op if the deployment is already
+	// updated, for example by a peer runner replica.
+	if err := updater.Apply("drone/drone-runner-kube:1.1.0"); err != nil {
+		t.Error(err)
+	}
+	if got, want := updates, 2; got != want {
+		t.Errorf("Want no update if the image is unchanged, got %d updates", got)
+	}
+}
+
+func TestUpdater_Run(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "drone-runner-version", Namespace: "drone"},
+			Data:       map[string]string{"image": "drone/drone-runner-kube:1.1.0"},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "drone-runner", Namespace: "drone"},
+			Spec: appsv1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{
+							{Name: "runner", Image: "drone/drone-runner-kube:1.0.0"},
+						},
+					},
+				},
+			},
+		},
+	)
+	// the first update fails, and is retried on the update
+	// interval.
+	updated := make(chan struct{})
+	updates := 0
+	client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates == 1 {
+			return true, nil, kerrors.NewInternalError(errors.New("etcdserver: request timed out"))
+		}
+		close(updated)
+		return false, nil, nil
+	})
+	updater := &Updater{
+		Client:     client,
+		Namespace:  "drone",
+		ConfigMap:  "drone-runner-version",
+		Deployment: "drone-runner",
+		Interval:   time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		updater.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Errorf("Want the failed update retried")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Want the updater stopped once the context is cancelled")
+	}
+}
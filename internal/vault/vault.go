@@ -0,0 +1,176 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package vault resolves pipeline secrets from the key/value
+// secrets engine of a vault server.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+// tokenPath is the path of the service account token used to
+// authenticate with the kubernetes auth method.
+const tokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Config provides the vault client configuration.
+type Config struct {
+	// Address provides the vault server address.
+	Address string
+
+	// Token provides the vault token. If empty, the client
+	// authenticates with the kubernetes auth method, using
+	// the service account token of the runner.
+	Token string
+
+	// Namespace provides the vault enterprise namespace.
+	Namespace string
+
+	// Role provides the role of the kubernetes auth method.
+	Role string
+
+	// Mount provides the mount path of the kubernetes auth
+	// method. Defaults to kubernetes.
+	Mount string
+}
+
+// New returns a secret store that resolves secrets from the
+// vault server. Both version 1 and version 2 of the key/value
+// secrets engine are supported. For version 2, the secret path
+// includes the data prefix, for example secret/data/ci.
+func New(config Config) engine.SecretStore {
+	if config.Mount == "" {
+		config.Mount = "kubernetes"
+	}
+	return &client{
+		config: config,
+		jwt:    tokenPath,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+type client struct {
+	config Config
+	jwt    string
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Find returns the value of the key of the secret at the path.
+func (c *client) Find(ctx context.Context, path, key string) (string, error) {
+	token, err := c.login(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := c.request(ctx, "GET", "/v1/"+strings.Trim(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	out := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := c.do(req, &out); err != nil {
+		return "", err
+	}
+	data := out.Data
+
+	// version 2 of the key/value secrets engine nests the
+	// secret data and includes the secret metadata.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, ok := data[key]
+	if !ok || value == nil {
+		return "", engine.ErrSecretNotFound
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	raw, err := json.Marshal(value)
+	return string(raw), err
+}
+
+// helper function returns the vault token. The token is
+// requested with the kubernetes auth method if the token is
+// not configured, and is renewed before the lease expires.
+func (c *client) login(ctx context.Context) (string, error) {
+	if c.config.Token != "" {
+		return c.config.Token, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+	jwt, err := ioutil.ReadFile(c.jwt)
+	if err != nil {
+		return "", fmt.Errorf("vault: cannot read the service account token: %s", err)
+	}
+	body, _ := json.Marshal(map[string]string{
+		"role": c.config.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	req, err := c.request(ctx, "POST", "/v1/auth/"+strings.Trim(c.config.Mount, "/")+"/login", body)
+	if err != nil {
+		return "", err
+	}
+	out := struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}{}
+	if err := c.do(req, &out); err != nil {
+		return "", fmt.Errorf("vault: cannot login: %s", err)
+	}
+	// the token is renewed once 80 percent of the lease
+	// duration has elapsed.
+	lease := time.Duration(out.Auth.LeaseDuration) * time.Second
+	c.token = out.Auth.ClientToken
+	c.expires = time.Now().Add(lease * 4 / 5)
+	return c.token, nil
+}
+
+func (c *client) request(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.config.Address, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.config.Namespace)
+	}
+	return req.WithContext(ctx), nil
+}
+
+func (c *client) do(req *http.Request, out interface{}) error {
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return engine.ErrSecretNotFound
+	}
+	if res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("vault: %s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+)
+
+func TestFind(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("X-Vault-Token"), "s.token"; got != want {
+			t.Errorf("Want vault token %q, got %q", want, got)
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/ci":
+			w.Write([]byte(`{"data":{"data":{"token":"correct-horse","port":5432},"metadata":{"version":2}}}`))
+		case "/v1/kv/ci":
+			w.Write([]byte(`{"data":{"token":"battery-staple"}}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	store := New(Config{Address: ts.URL, Token: "s.token"})
+	tests := []struct {
+		path, key, value string
+		err              error
+	}{
+		{path: "secret/data/ci", key: "token", value: "correct-horse"},
+		{path: "secret/data/ci", key: "port", value: "5432"},
+		{path: "kv/ci", key: "token", value: "battery-staple"},
+		{path: "kv/ci", key: "password", err: engine.ErrSecretNotFound},
+		{path: "kv/unknown", key: "token", err: engine.ErrSecretNotFound},
+	}
+	for _, test := range tests {
+		value, err := store.Find(context.Background(), test.path, test.key)
+		if err != test.err {
+			t.Errorf("Want error %v for %s#%s, got %v", test.err, test.path, test.key, err)
+		}
+		if value != test.value {
+			t.Errorf("Want value %q for %s#%s, got %q", test.value, test.path, test.key, value)
+		}
+	}
+}
+
+func TestFind_KubernetesAuth(t *testing.T) {
+	jwt, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(jwt.Name())
+	jwt.WriteString("eyJhbGciOi\n")
+	jwt.Close()
+
+	var logins int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("X-Vault-Namespace"), "ci"; got != want {
+			t.Errorf("Want vault namespace %q, got %q", want, got)
+		}
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			logins++
+			in := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&in)
+			if in["role"] != "drone" || in["jwt"] != "eyJhbGciOi" {
+				t.Errorf("Unexpected login request %v", in)
+			}
+			w.Write([]byte(`{"auth":{"client_token":"s.login","lease_duration":3600}}`))
+		case "/v1/kv/ci":
+			if got, want := r.Header.Get("X-Vault-Token"), "s.login"; got != want {
+				t.Errorf("Want vault token %q, got %q", want, got)
+			}
+			w.Write([]byte(`{"data":{"token":"correct-horse"}}`))
+		}
+	}))
+	defer ts.Close()
+
+	store := New(Config{Address: ts.URL, Namespace: "ci", Role: "drone"}).(*client)
+	store.jwt = jwt.Name()
+	for i := 0; i < 2; i++ {
+		value, err := store.Find(context.Background(), "kv/ci", "token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != "correct-horse" {
+			t.Errorf("Want secret value, got %q", value)
+		}
+	}
+	if logins != 1 {
+		t.Errorf("Want token reused until the lease expires, got %d logins", logins)
+	}
+}
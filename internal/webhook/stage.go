@@ -0,0 +1,86 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/runtime"
+)
+
+// Stage event types.
+const (
+	EventStageStarted  = "stage_started"
+	EventStageFinished = "stage_finished"
+)
+
+// Payload formats.
+const (
+	FormatJSON  = "json"
+	FormatSlack = "slack"
+)
+
+// slackMessage is the payload of a slack incoming webhook.
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// Stages posts the stage lifecycle events to the endpoint, as
+// a json payload or as a slack message, independent of the
+// notification plugins of the server.
+type Stages struct {
+	observer *Observer
+	format   string
+}
+
+// NewStages returns an observer that posts the stage lifecycle
+// events to the endpoint in the format. The events are posted
+// until the context is cancelled.
+func NewStages(ctx context.Context, endpoint, token, format string, skipverify bool) *Stages {
+	return &Stages{
+		observer: New(ctx, endpoint, token, skipverify),
+		format:   format,
+	}
+}
+
+// OnStageStarted posts the stage started event.
+func (s *Stages) OnStageStarted(ctx context.Context, e *runtime.StageEvent) {
+	s.send(EventStageStarted, e)
+}
+
+// OnStageFinished posts the stage finished event.
+func (s *Stages) OnStageFinished(ctx context.Context, e *runtime.StageEvent) {
+	s.send(EventStageFinished, e)
+}
+
+func (s *Stages) send(event string, e *runtime.StageEvent) {
+	if s.format == FormatSlack {
+		s.observer.send(event, &slackMessage{Text: toSlackText(event, e)})
+	} else {
+		s.observer.enqueue(event, e)
+	}
+}
+
+// helper function returns the slack message text of the stage
+// event.
+func toSlackText(event string, e *runtime.StageEvent) string {
+	name := fmt.Sprintf("%s #%d %s", e.Repo, e.Build, e.Stage)
+	if e.Link != "" {
+		name = fmt.Sprintf("<%s|%s>", e.Link, name)
+	}
+	if event == EventStageStarted {
+		return fmt.Sprintf(":arrow_forward: %s started on %s in pod %s/%s",
+			name, e.Machine, e.Namespace, e.Pod)
+	}
+	icon := ":x:"
+	if e.Status == "success" {
+		icon = ":white_check_mark:"
+	}
+	duration := time.Duration(e.Duration * float64(time.Second)).Round(time.Second)
+	return fmt.Sprintf("%s %s finished with status %s in %s on %s in pod %s/%s",
+		icon, name, e.Status, duration, e.Machine, e.Namespace, e.Pod)
+}
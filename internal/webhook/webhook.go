@@ -0,0 +1,156 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package webhook provides observers that post the pipeline and
+// stage lifecycle events to a webhook endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/sirupsen/logrus"
+)
+
+// size of the event queue. Events are dropped if the endpoint
+// cannot keep up, so that pipelines are never blocked.
+const queueSize = 1000
+
+// timeout of a single webhook request.
+var timeout = 10 * time.Second
+
+// Event types.
+const (
+	EventPodCreated   = "pod_created"
+	EventStepStarted  = "step_started"
+	EventStepFinished = "step_finished"
+	EventDestroy      = "destroy"
+)
+
+// Payload is the json payload posted to the endpoint.
+type Payload struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// Observer posts the lifecycle events to the endpoint, in the
+// order the events are received.
+type Observer struct {
+	endpoint string
+	token    string
+	client   *http.Client
+	queue    chan *message
+}
+
+// message is a queued request body.
+type message struct {
+	event string
+	body  interface{}
+}
+
+// New returns an observer that posts the lifecycle events to
+// the endpoint. The events are posted until the context is
+// cancelled.
+func New(ctx context.Context, endpoint, token string, skipverify bool) *Observer {
+	client := http.DefaultClient
+	if skipverify {
+		client = &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		}
+	}
+	o := &Observer{
+		endpoint: endpoint,
+		token:    token,
+		client:   client,
+		queue:    make(chan *message, queueSize),
+	}
+	go o.run(ctx)
+	return o
+}
+
+// OnPodCreated posts the pod created event.
+func (o *Observer) OnPodCreated(ctx context.Context, e *engine.PodEvent) {
+	o.enqueue(EventPodCreated, e)
+}
+
+// OnStepStarted posts the step started event.
+func (o *Observer) OnStepStarted(ctx context.Context, e *engine.StepEvent) {
+	o.enqueue(EventStepStarted, e)
+}
+
+// OnStepFinished posts the step finished event.
+func (o *Observer) OnStepFinished(ctx context.Context, e *engine.StepEvent) {
+	o.enqueue(EventStepFinished, e)
+}
+
+// OnDestroy posts the destroy event.
+func (o *Observer) OnDestroy(ctx context.Context, e *engine.PodEvent) {
+	o.enqueue(EventDestroy, e)
+}
+
+func (o *Observer) enqueue(event string, data interface{}) {
+	o.send(event, &Payload{Event: event, Data: data})
+}
+
+func (o *Observer) send(event string, body interface{}) {
+	select {
+	case o.queue <- &message{event: event, body: body}:
+	default:
+		logrus.WithField("event", event).
+			Warnln("webhook: queue is full, dropping lifecycle event")
+	}
+}
+
+func (o *Observer) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-o.queue:
+			if err := o.post(ctx, msg.body); err != nil {
+				logrus.WithError(err).
+					WithField("event", msg.event).
+					Warnln("webhook: cannot post lifecycle event")
+			}
+		}
+	}
+}
+
+func (o *Observer) post(ctx context.Context, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
+	res, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return fmt.Errorf("webhook: endpoint returned status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/runtime"
+)
+
+func TestObserver(t *testing.T) {
+	received := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer correct-horse"; got != want {
+			t.Errorf("Want authorization %q, got %q", want, got)
+		}
+		payload := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := New(ctx, server.URL, "correct-horse", false)
+	o.OnPodCreated(ctx, &engine.PodEvent{Pod: "drone-abc"})
+	o.OnStepFinished(ctx, &engine.StepEvent{
+		PodEvent: engine.PodEvent{Pod: "drone-abc"},
+		Step:     "build",
+		ExitCode: 1,
+	})
+
+	for _, want := range []string{EventPodCreated, EventStepFinished} {
+		select {
+		case payload := <-received:
+			if payload["event"] != want {
+				t.Errorf("Want event %s, got %v", want, payload["event"])
+			}
+			data := payload["data"].(map[string]interface{})
+			if data["pod"] != "drone-abc" {
+				t.Errorf("Want pod in event data, got %v", data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Want event %s posted", want)
+		}
+	}
+}
+
+func TestStages_Slack(t *testing.T) {
+	received := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewStages(ctx, server.URL, "", FormatSlack, false)
+	s.OnStageFinished(ctx, &runtime.StageEvent{
+		Repo:      "octocat/hello-world",
+		Build:     42,
+		Stage:     "test",
+		Machine:   "runner-1",
+		Namespace: "default",
+		Pod:       "drone-abc",
+		Status:    "failure",
+		Duration:  90,
+	})
+
+	select {
+	case payload := <-received:
+		want := ":x: octocat/hello-world #42 test finished with status failure in 1m30s on runner-1 in pod default/drone-abc"
+		if got := payload["text"]; got != want {
+			t.Errorf("Want slack text %q, got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Want stage event posted")
+	}
+}
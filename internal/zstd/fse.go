@@ -0,0 +1,110 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package zstd
+
+// table indexes.
+const (
+	llTable = iota
+	ofTable
+	mlTable
+)
+
+// tables are the finite state entropy tables of the predefined
+// literal length, offset and match length distributions.
+var tables = [3]*fseTable{
+	llTable: newTable(6, []int{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}),
+	ofTable: newTable(5, []int{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}),
+	mlTable: newTable(6, []int{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}),
+}
+
+// fseTable is a finite state entropy table. The encoder walks
+// the decoding table backward: for each symbol, cover maps the
+// state the decoder transitions to, to the state that decodes
+// the symbol.
+type fseTable struct {
+	log      uint
+	symbol   []uint8
+	nbBits   []uint
+	baseline []uint16
+	cover    [][]uint16
+}
+
+// helper function builds the table of the normalized
+// distribution, as defined by the Zstandard specification.
+func newTable(log uint, norm []int) *fseTable {
+	size := 1 << log
+	t := &fseTable{
+		log:      log,
+		symbol:   make([]uint8, size),
+		nbBits:   make([]uint, size),
+		baseline: make([]uint16, size),
+		cover:    make([][]uint16, len(norm)),
+	}
+
+	// symbols with a less than one probability are placed at
+	// the end of the table.
+	high := size - 1
+	next := make([]int, len(norm))
+	for s, p := range norm {
+		next[s] = p
+		if p == -1 {
+			t.symbol[high] = uint8(s)
+			high--
+			next[s] = 1
+		}
+	}
+	pos, step, mask := 0, size>>1+size>>3+3, size-1
+	for s, p := range norm {
+		for i := 0; i < p; i++ {
+			t.symbol[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	for state := 0; state < size; state++ {
+		s := t.symbol[state]
+		n := next[s]
+		next[s]++
+		t.nbBits[state] = log - highBit(uint32(n))
+		t.baseline[state] = uint16(n<<t.nbBits[state] - size)
+
+		if t.cover[s] == nil {
+			t.cover[s] = make([]uint16, size)
+		}
+		for i := 0; i < 1<<t.nbBits[state]; i++ {
+			t.cover[s][int(t.baseline[state])+i] = uint16(state)
+		}
+	}
+	return t
+}
+
+// helper function returns a state that decodes the symbol.
+func (t *fseTable) first(symbol uint8) uint16 {
+	return t.cover[symbol][0]
+}
+
+// helper function returns the state that decodes the symbol
+// and transitions to the next state, and the bits read by the
+// decoder in the transition.
+func (t *fseTable) encode(symbol uint8, next uint16) (state uint16, bits uint64, nb uint) {
+	state = t.cover[symbol][next]
+	nb = t.nbBits[state]
+	return state, uint64(next - t.baseline[state]), nb
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// minimum size of the request body before the body is
+// compressed. Smaller bodies are not worth compressing.
+const minSize = 1024
+
+// negotiation states.
+const (
+	unknown int32 = iota
+	supported
+	unsupported
+)
+
+// Transport is an http.RoundTripper that compresses the log
+// lines uploaded to the server. Log uploads are sent plain
+// until the server advertises zstd support with the
+// Accept-Encoding response header, and compression is disabled
+// if the server rejects a compressed upload.
+type Transport struct {
+	Base http.RoundTripper
+
+	state int32
+}
+
+// NewTransport returns a transport that compresses log uploads
+// sent with the base transport. If the base transport is nil,
+// the default transport is used.
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{Base: base}
+}
+
+// RoundTrip executes a single http transaction.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.LoadInt32(&t.state) != supported || !isLogUpload(req) {
+		return t.roundTrip(req)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < minSize {
+		return t.roundTrip(withBody(req, body, ""))
+	}
+	res, err := t.roundTrip(withBody(req, Encode(nil, body), "zstd"))
+	if err != nil || res.StatusCode != http.StatusUnsupportedMediaType {
+		return res, err
+	}
+
+	// the server no longer accepts compressed uploads, for
+	// example after a downgrade, and the upload is retried
+	// uncompressed.
+	res.Body.Close()
+	atomic.StoreInt32(&t.state, unsupported)
+	return t.roundTrip(withBody(req, body, ""))
+}
+
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	res, err := base.RoundTrip(req)
+	if err == nil && acceptsZstd(res.Header) {
+		atomic.CompareAndSwapInt32(&t.state, unknown, supported)
+	}
+	return res, err
+}
+
+// helper function returns a shallow copy of the request with
+// the body and content encoding.
+func withBody(req *http.Request, body []byte, encoding string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	if encoding != "" {
+		r.Header.Set("Content-Encoding", encoding)
+	}
+	r.ContentLength = int64(len(body))
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return r
+}
+
+// helper function returns true if the request uploads log
+// lines to the live log stream or the log store.
+func isLogUpload(req *http.Request) bool {
+	if req.Method != "POST" || req.Body == nil || req.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+	return strings.HasSuffix(req.URL.Path, "/logs/batch") ||
+		strings.HasSuffix(req.URL.Path, "/logs/upload")
+}
+
+// helper function returns true if the Accept-Encoding response
+// header includes zstd.
+func acceptsZstd(header http.Header) bool {
+	for _, value := range header["Accept-Encoding"] {
+		for _, coding := range strings.Split(value, ",") {
+			if i := strings.Index(coding, ";"); i != -1 {
+				coding = coding[:i]
+			}
+			if strings.EqualFold(strings.TrimSpace(coding), "zstd") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	var encodings []string
+	var rejected bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		encoding := r.Header.Get("Content-Encoding")
+		encodings = append(encodings, encoding)
+		if encoding == "zstd" && rejected {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if encoding == "" && !bytes.Equal(body, testLog()) {
+			t.Errorf("Want uncompressed upload")
+		}
+		w.Header().Set("Accept-Encoding", "gzip, zstd")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewTransport(nil)}
+	upload := func() {
+		res, err := client.Post(server.URL+"/rpc/v2/step/1/logs/upload", "application/json", bytes.NewReader(testLog()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Errorf("Want upload accepted, got status %d", res.StatusCode)
+		}
+	}
+
+	upload()
+	upload()
+	rejected = true
+	upload()
+	upload()
+
+	want := []string{"", "zstd", "zstd", "", ""}
+	if len(encodings) != len(want) {
+		t.Fatalf("Want encodings %q, got %q", want, encodings)
+	}
+	for i := range want {
+		if encodings[i] != want[i] {
+			t.Errorf("Want encodings %q, got %q", want, encodings)
+			break
+		}
+	}
+}
+
+func TestAcceptsZstd(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"", false},
+		{"gzip", false},
+		{"zstd", true},
+		{"gzip, ZSTD;q=0.5", true},
+		{"zstdx", false},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set("Accept-Encoding", test.value)
+		if got := acceptsZstd(header); got != test.want {
+			t.Errorf("Want %v for %q, got %v", test.want, test.value, got)
+		}
+	}
+}
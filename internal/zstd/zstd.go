@@ -0,0 +1,300 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package zstd provides a minimal Zstandard encoder, and an http
+// transport that compresses log uploads with the encoder.
+//
+// The encoder trades compression ratio for simplicity. Matches
+// are found with a single hash table, literals are stored raw,
+// and sequences are encoded with the predefined distributions,
+// which works well for the repetitive text of build logs.
+package zstd
+
+import "encoding/binary"
+
+const (
+	magic        = 0xFD2FB528
+	maxBlockSize = 128 << 10
+	minMatch     = 4
+	maxOffset    = 1 << 22
+	hashLog      = 16
+)
+
+// Encode returns the src compressed as a single Zstandard frame
+// appended to dst.
+func Encode(dst, src []byte) []byte {
+	dst = appendFrameHeader(dst, len(src))
+	if len(src) == 0 {
+		return appendBlockHeader(dst, true, blockRaw, 0)
+	}
+	e := &encoder{src: src}
+	for start := 0; start < len(src); start += maxBlockSize {
+		end := start + maxBlockSize
+		if end > len(src) {
+			end = len(src)
+		}
+		dst = e.appendBlock(dst, start, end, end == len(src))
+	}
+	return dst
+}
+
+// block types.
+const (
+	blockRaw        = 0
+	blockCompressed = 2
+)
+
+// helper function appends a single segment frame header with
+// the content size, so that the window size is not encoded.
+func appendFrameHeader(dst []byte, size int) []byte {
+	dst = append(dst, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(dst[len(dst)-4:], magic)
+	switch {
+	case size < 256:
+		dst = append(dst, 0<<6|1<<5, byte(size))
+	case size < 256+1<<16:
+		dst = append(dst, 1<<6|1<<5, byte(size-256), byte((size-256)>>8))
+	case uint64(size) < 1<<32:
+		dst = append(dst, 2<<6|1<<5, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(dst[len(dst)-4:], uint32(size))
+	default:
+		dst = append(dst, 3<<6|1<<5, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(dst[len(dst)-8:], uint64(size))
+	}
+	return dst
+}
+
+func appendBlockHeader(dst []byte, last bool, kind, size int) []byte {
+	h := size<<3 | kind<<1
+	if last {
+		h |= 1
+	}
+	return append(dst, byte(h), byte(h>>8), byte(h>>16))
+}
+
+// sequence is a run of literals followed by a match.
+type sequence struct {
+	litLen   int
+	matchLen int
+	offset   int
+}
+
+type encoder struct {
+	src   []byte
+	table [1 << hashLog]int32
+	seqs  []sequence
+}
+
+// helper function appends the block for src[start:end]. The
+// block is stored raw if it cannot be compressed.
+func (e *encoder) appendBlock(dst []byte, start, end int, last bool) []byte {
+	lits := e.match(start, end)
+	n := len(dst)
+	dst = appendBlockHeader(dst, last, blockCompressed, 0)
+	dst = appendLiterals(dst, lits)
+	dst = appendSequences(dst, e.seqs)
+	size := len(dst) - n - 3
+	if size >= end-start {
+		dst = appendBlockHeader(dst[:n], last, blockRaw, end-start)
+		return append(dst, e.src[start:end]...)
+	}
+	// the block size is known once the block is encoded.
+	appendBlockHeader(dst[n:n], last, blockCompressed, size)
+	return dst
+}
+
+// helper function finds the matches in src[start:end] and
+// returns the literals. Matches may refer to previous blocks,
+// since the window of a single segment frame is the frame.
+func (e *encoder) match(start, end int) []byte {
+	src := e.src
+	e.seqs = e.seqs[:0]
+	var lits []byte
+	anchor := start
+	for i := start; i+minMatch <= end; {
+		h := hash(binary.LittleEndian.Uint32(src[i:]))
+		c := int(e.table[h]) - 1
+		e.table[h] = int32(i + 1)
+		if c < 0 || i-c > maxOffset || binary.LittleEndian.Uint32(src[c:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+		n := minMatch
+		for i+n < end && src[c+n] == src[i+n] {
+			n++
+		}
+		lits = append(lits, src[anchor:i]...)
+		e.seqs = append(e.seqs, sequence{
+			litLen:   i - anchor,
+			matchLen: n,
+			offset:   i - c,
+		})
+		i += n
+		anchor = i
+	}
+	return append(lits, src[anchor:end]...)
+}
+
+func hash(u uint32) uint32 {
+	return (u * 2654435761) >> (32 - hashLog)
+}
+
+// helper function appends the literals section with the raw
+// literals.
+func appendLiterals(dst, lits []byte) []byte {
+	n := len(lits)
+	switch {
+	case n < 1<<5:
+		dst = append(dst, byte(n<<3))
+	case n < 1<<12:
+		dst = append(dst, byte(1<<2|n<<4), byte(n>>4))
+	default:
+		dst = append(dst, byte(3<<2|n<<4), byte(n>>4), byte(n>>12))
+	}
+	return append(dst, lits...)
+}
+
+// helper function appends the sequences section. Sequences are
+// encoded in reverse order, since the bitstream is read from
+// the end.
+func appendSequences(dst []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8)+128, byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+	// the literal length, offset and match length codes use
+	// the predefined distributions.
+	dst = append(dst, 0)
+
+	codes := make([][3]uint8, n)
+	for i, seq := range seqs {
+		codes[i] = [3]uint8{
+			litLenCode(seq.litLen),
+			offsetCode(seq.offset),
+			matchLenCode(seq.matchLen),
+		}
+	}
+	var states [3]uint16
+	for t := range states {
+		states[t] = tables[t].first(codes[n-1][t])
+	}
+
+	w := &bitWriter{out: dst}
+	for i := n - 1; i >= 0; i-- {
+		seq, code := seqs[i], codes[i]
+		if i < n-1 {
+			for _, t := range []int{ofTable, mlTable, llTable} {
+				state, bits, nb := tables[t].encode(code[t], states[t])
+				w.add(bits, nb)
+				states[t] = state
+			}
+		}
+		w.add(uint64(seq.litLen-litLenBase[code[llTable]]), litLenBits[code[llTable]])
+		w.add(uint64(seq.matchLen-matchLenBase[code[mlTable]]), matchLenBits[code[mlTable]])
+		w.add(uint64(seq.offset+3-1<<code[ofTable]), uint(code[ofTable]))
+	}
+	w.add(uint64(states[mlTable]), tables[mlTable].log)
+	w.add(uint64(states[ofTable]), tables[ofTable].log)
+	w.add(uint64(states[llTable]), tables[llTable].log)
+	return w.close()
+}
+
+// bitWriter writes the bitstream that is read backward by the
+// decoder.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (w *bitWriter) add(v uint64, n uint) {
+	w.bits |= v << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// helper function writes the end mark and returns the padded
+// bitstream.
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.bits))
+	}
+	return w.out
+}
+
+func litLenCode(n int) uint8 {
+	if n < 16 {
+		return uint8(n)
+	}
+	return code(litLenBase[16:], n) + 16
+}
+
+func matchLenCode(n int) uint8 {
+	if n < 35 {
+		return uint8(n - 3)
+	}
+	return code(matchLenBase[32:], n) + 32
+}
+
+func offsetCode(offset int) uint8 {
+	return uint8(highBit(uint32(offset + 3)))
+}
+
+// helper function returns the index of the last base that is
+// less than or equal to n.
+func code(base []int, n int) uint8 {
+	i := 0
+	for i+1 < len(base) && base[i+1] <= n {
+		i++
+	}
+	return uint8(i)
+}
+
+func highBit(v uint32) uint {
+	n := uint(0)
+	for v > 1 {
+		v >>= 1
+		n++
+	}
+	return n
+}
+
+var litLenBase = []int{
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+	16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512,
+	1024, 2048, 4096, 8192, 16384, 32768, 65536,
+}
+
+var litLenBits = []uint{
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+	13, 14, 15, 16,
+}
+
+var matchLenBase = []int{
+	3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+	19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+	35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515,
+	1027, 2051, 4099, 8195, 16387, 32771, 65539,
+}
+
+var matchLenBits = []uint{
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+	12, 13, 14, 15, 16,
+}
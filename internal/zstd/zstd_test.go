@@ -0,0 +1,83 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"testing"
+)
+
+func TestTables(t *testing.T) {
+	for i, table := range tables {
+		for s, cover := range table.cover {
+			for next, state := range cover {
+				if table.symbol[state] != uint8(s) {
+					t.Errorf("Want table %d state %d to decode symbol %d", i, state, s)
+				}
+				if base := int(table.baseline[state]); next < base || next >= base+1<<table.nbBits[state] {
+					t.Errorf("Want table %d state %d to transition to state %d", i, state, next)
+				}
+			}
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	src := testLog()
+	out := Encode(nil, src)
+	if !bytes.HasPrefix(out, []byte{0x28, 0xB5, 0x2F, 0xFD}) {
+		t.Errorf("Want zstd frame magic number")
+	}
+	if len(out) > len(src)/4 {
+		t.Errorf("Want log compressed to less than 25%%, got %d of %d bytes", len(out), len(src))
+	}
+
+	// random data cannot be compressed and is stored raw.
+	random := make([]byte, 200000)
+	rand.Read(random)
+	if got, want := len(Encode(nil, random)), len(random)+9+2*3; got != want {
+		t.Errorf("Want %d bytes, got %d", want, got)
+	}
+}
+
+// this test verifies the encoded frames can be decoded by the
+// reference implementation, if installed.
+func TestEncodeDecode(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	random := make([]byte, 200000)
+	rand.Read(random)
+	tests := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("hello hello hello hello"),
+		bytes.Repeat([]byte("x"), 300000),
+		random,
+		append(append([]byte{}, random[:1000]...), testLog()...),
+		testLog(),
+	}
+	for i, src := range tests {
+		cmd := exec.Command("zstd", "-d", "-c")
+		cmd.Stdin = bytes.NewReader(Encode(nil, src))
+		got, err := cmd.Output()
+		if err != nil {
+			t.Errorf("Want frame %d decoded, got error %s", i, err)
+		} else if !bytes.Equal(got, src) {
+			t.Errorf("Want frame %d decoded to the source", i)
+		}
+	}
+}
+
+func testLog() []byte {
+	var buf bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "ok  \tgithub.com/drone/example/pkg%d\t0.%03ds\tcoverage: %d.0%% of statements\n", i%97, i%1000, i%100)
+	}
+	return buf.Bytes()
+}
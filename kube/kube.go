@@ -0,0 +1,312 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package kube runs pipelines on kubernetes, so that other
+// tools can embed the runner instead of executing the runner
+// binary.
+//
+// The package is the stable api of the runner, and follows
+// semantic versioning. The engine, compiler and runtime
+// packages are implementation details that may change in any
+// release. The package does not configure the default logger.
+// The engine metrics and a few engine defaults, for example
+// the step kill grace period, are shared by the process, so
+// the metrics of runners in the same process are combined.
+//
+//	runner, err := kube.New(kube.Options{
+//		Kubeconfig: "/path/to/kubeconfig",
+//		Namespace:  "ci",
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	state, err := runner.Run(ctx, &kube.Request{
+//		Source: source,
+//		Repo:   repo,
+//		Build:  build,
+//		Stage:  stage,
+//		System: system,
+//	})
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/compiler"
+	"github.com/drone-runners/drone-runner-kube/engine/linter"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/runtime"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/envsubst"
+	"github.com/drone/runner-go/environ"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/drone/runner-go/registry"
+	"github.com/drone/runner-go/secret"
+
+	"k8s.io/client-go/rest"
+)
+
+// errMissingMetadata is returned if the request does not
+// provide the build metadata.
+var errMissingMetadata = errors.New("kube: missing repo, build, stage or system")
+
+// Options provides the runner options.
+type Options struct {
+	// Config provides the kubernetes client configuration.
+	// If nil, the configuration is loaded from the
+	// kubeconfig file, or from the in-cluster environment
+	// if the kubeconfig file is empty.
+	Config *rest.Config
+
+	// Kubeconfig provides the path of the kubeconfig file.
+	Kubeconfig string
+
+	// Namespace provides the namespace of the pipeline pods
+	// when the pipeline does not provide a namespace.
+	Namespace string
+
+	// Environ provides environment variables that are added
+	// to each pipeline step.
+	Environ map[string]string
+
+	// Labels provides labels that are added to the pipeline
+	// pods.
+	Labels map[string]string
+
+	// Privileged provides images that are always privileged,
+	// in addition to the default privileged plugin images.
+	Privileged []string
+
+	// Secrets provides the secrets that pipelines can
+	// reference by name.
+	Secrets map[string]string
+
+	// Procs limits the number of steps that execute
+	// concurrently. The steps are not limited if zero.
+	Procs int64
+}
+
+// Request provides the pipeline to run.
+type Request struct {
+	// Source provides the yaml configuration file. The
+	// configuration is subject to variable substitution.
+	Source []byte
+
+	// Repo, Build, Stage and System provide the required
+	// build metadata. The stage name selects the pipeline of the
+	// configuration file. The stage steps are created when
+	// the pipeline runs.
+	Repo   *drone.Repo
+	Build  *drone.Build
+	Stage  *drone.Stage
+	System *drone.System
+
+	// Netrc provides the credentials used to clone the
+	// repository.
+	Netrc *drone.Netrc
+
+	// Include runs only the named steps, if not empty.
+	Include []string
+
+	// Exclude skips the named steps.
+	Exclude []string
+
+	// Reporter receives the stage and step status updates.
+	// Updates are discarded if nil.
+	Reporter pipeline.Reporter
+
+	// Streamer receives the step logs. Logs are discarded if
+	// nil.
+	Streamer pipeline.Streamer
+}
+
+// Runner runs pipelines on kubernetes.
+type Runner struct {
+	engine   engine.Engine
+	linter   *linter.Linter
+	compiler *compiler.Compiler
+	procs    int64
+}
+
+// New returns a new runner with the options.
+func New(opts Options) (*Runner, error) {
+	var (
+		kube *engine.Kubernetes
+		err  error
+	)
+	switch {
+	case opts.Config != nil:
+		kube, err = engine.New(opts.Config)
+	case opts.Kubeconfig != "":
+		kube, err = engine.NewFromConfig(opts.Kubeconfig)
+	default:
+		kube, err = engine.NewInCluster()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newRunner(kube, opts), nil
+}
+
+// helper function returns a new runner with the engine.
+func newRunner(engine engine.Engine, opts Options) *Runner {
+	return &Runner{
+		engine: engine,
+		linter: linter.New(nil, linter.Policy{}),
+		compiler: &compiler.Compiler{
+			Environ:    opts.Environ,
+			Labels:     opts.Labels,
+			Privileged: append(opts.Privileged, compiler.Privileged...),
+			Secret:     secret.StaticVars(opts.Secrets),
+			Registry:   registry.Combine(),
+			Namespace:  opts.Namespace,
+		},
+		procs: opts.Procs,
+	}
+}
+
+// Compile returns the intermediate representation of the
+// pipeline, or an error if the pipeline cannot be parsed or
+// breaks a linting rule.
+func (r *Runner) Compile(ctx context.Context, req *Request) (*engine.Spec, error) {
+	if req.Repo == nil || req.Build == nil || req.Stage == nil || req.System == nil {
+		return nil, errMissingMetadata
+	}
+	envs := environ.Combine(
+		r.compiler.Environ,
+		environ.System(req.System),
+		environ.Repo(req.Repo),
+		environ.Build(req.Build),
+		environ.Stage(req.Stage),
+		environ.Link(req.Repo, req.Build, req.System),
+		req.Build.Params,
+	)
+
+	// string substitution function ensures that string
+	// replacement variables are escaped and quoted if they
+	// contain newlines.
+	subf := func(k string) string {
+		v := envs[k]
+		if strings.Contains(v, "\n") {
+			v = fmt.Sprintf("%q", v)
+		}
+		return v
+	}
+	config, err := envsubst.Eval(string(req.Source), subf)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := manifest.ParseString(config)
+	if err != nil {
+		return nil, err
+	}
+	resource, err := resource.Lookup(req.Stage.Name, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.linter.Lint(resource, linter.Opts{Trusted: req.Repo.Trusted}); err != nil {
+		return nil, err
+	}
+
+	spec := r.compiler.Compile(ctx, compiler.Args{
+		Pipeline: resource,
+		Manifest: manifest,
+		Build:    req.Build,
+		Netrc:    req.Netrc,
+		Repo:     req.Repo,
+		Stage:    req.Stage,
+		System:   req.System,
+		Secret:   r.compiler.Secret,
+	})
+	filterSteps(spec, req.Include, req.Exclude)
+	return spec, nil
+}
+
+// Run runs the pipeline and returns the pipeline state once
+// the pipeline completes. The pipeline is cancelled when the
+// context is cancelled.
+func (r *Runner) Run(ctx context.Context, req *Request) (*pipeline.State, error) {
+	spec, err := r.Compile(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// create a step object for each pipeline step.
+	for _, step := range spec.Steps {
+		if step.RunPolicy == engine.RunNever {
+			continue
+		}
+		req.Stage.Steps = append(req.Stage.Steps, &drone.Step{
+			StageID:   req.Stage.ID,
+			Number:    len(req.Stage.Steps) + 1,
+			Name:      step.Name,
+			Status:    drone.StatusPending,
+			ErrIgnore: step.IgnoreErr,
+		})
+	}
+
+	state := &pipeline.State{
+		Build:  req.Build,
+		Stage:  req.Stage,
+		Repo:   req.Repo,
+		System: req.System,
+	}
+	state.Build.Status = drone.StatusRunning
+	state.Stage.Status = drone.StatusRunning
+
+	reporter := req.Reporter
+	if reporter == nil {
+		reporter = pipeline.NopReporter()
+	}
+	streamer := req.Streamer
+	if streamer == nil {
+		streamer = pipeline.NopStreamer()
+	}
+	err = runtime.NewExecer(
+		reporter,
+		streamer,
+		r.engine,
+		r.procs,
+		"",
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+	).Exec(ctx, spec, state)
+	return state, err
+}
+
+// helper function skips the steps that are not in the include
+// list, if not empty, and the steps in the exclude list. The
+// clone step is never skipped.
+func filterSteps(spec *engine.Spec, include, exclude []string) {
+	for _, step := range spec.Steps {
+		if step.Name == "clone" {
+			continue
+		}
+		if len(include) > 0 && !contains(include, step.Name) {
+			step.RunPolicy = engine.RunNever
+		}
+		if contains(exclude, step.Name) {
+			step.RunPolicy = engine.RunNever
+		}
+	}
+}
+
+// helper function returns true if the list contains the name.
+func contains(list []string, name string) bool {
+	for _, item := range list {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone/drone-go/drone"
+)
+
+const testSource = `
+kind: pipeline
+type: kubernetes
+name: default
+
+steps:
+- name: build
+  image: golang
+  commands:
+  - go build
+
+- name: test
+  image: golang
+  commands:
+  - go test ${DRONE_BRANCH}
+`
+
+func testRequest() *Request {
+	return &Request{
+		Source: []byte(testSource),
+		Repo:   &drone.Repo{Slug: "octocat/hello-world"},
+		Build:  &drone.Build{Target: "master"},
+		Stage:  &drone.Stage{Name: "default"},
+		System: &drone.System{Proto: "https", Host: "drone.company.com"},
+	}
+}
+
+func TestCompile(t *testing.T) {
+	runner := newRunner(nil, Options{Namespace: "ci"})
+	req := testRequest()
+	req.Exclude = []string{"build"}
+
+	spec, err := runner.Compile(context.Background(), req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := spec.PodSpec.Namespace, "ci"; got != want {
+		t.Errorf("Want namespace %s, got %s", want, got)
+	}
+	policies := map[string]engine.RunPolicy{}
+	for _, step := range spec.Steps {
+		policies[step.Name] = step.RunPolicy
+	}
+	if policies["build"] != engine.RunNever {
+		t.Errorf("Want excluded step skipped")
+	}
+	if policies["test"] == engine.RunNever {
+		t.Errorf("Want step not skipped")
+	}
+	if policies["clone"] == engine.RunNever {
+		t.Errorf("Want clone step not skipped")
+	}
+}
+
+func TestCompile_MissingMetadata(t *testing.T) {
+	runner := newRunner(nil, Options{})
+	req := testRequest()
+	req.System = nil
+	if _, err := runner.Compile(context.Background(), req); err != errMissingMetadata {
+		t.Errorf("Want missing metadata error, got %v", err)
+	}
+}
+
+func TestFilterSteps(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			{Name: "clone"},
+			{Name: "build"},
+			{Name: "test"},
+			{Name: "deploy"},
+		},
+	}
+	filterSteps(spec, []string{"build", "deploy"}, []string{"deploy"})
+	want := map[string]engine.RunPolicy{
+		"clone":  engine.RunOnSuccess,
+		"build":  engine.RunOnSuccess,
+		"test":   engine.RunNever,
+		"deploy": engine.RunNever,
+	}
+	for _, step := range spec.Steps {
+		if got := step.RunPolicy; got != want[step.Name] {
+			t.Errorf("Want step %s run policy %s, got %s", step.Name, want[step.Name], got)
+		}
+	}
+}
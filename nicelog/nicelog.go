@@ -9,10 +9,19 @@ import (
 
 const (
 	limit = 1024 // 1kb
+
+	// maxLine is the maximum size of a log line. Longer lines,
+	// for example minified javascript, are split into chunks
+	// so that the lines are not buffered wholly in memory.
+	maxLine = 64 * 1024 // 64kb
 )
 
 var (
 	splitFlag = []byte("\n")
+
+	// continuation marks the chunks of a split line, except
+	// the last chunk.
+	continuation = []byte(" [...]\n")
 )
 
 // 非并发安全，需要在每个协程内单独 New 一个实例
@@ -21,6 +30,7 @@ type Writer struct {
 	// 是否有数据写入
 	hasWritten bool
 	pending    []byte
+	chunk      []byte
 	writer     io.Writer
 }
 
@@ -43,7 +53,7 @@ func (w *Writer) Write(b []byte) (n int, err error) {
 		data := bytes.TrimSpace(bytes.ReplaceAll(w.pending[:idx], []byte("sh: sleep: not found"), []byte("")))
 		data = bytes.TrimSpace(bytes.ReplaceAll(data, []byte("sh: sleep: Permission denied"), []byte("")))
 		if len(data) != 0 {
-			_, err = w.writer.Write(data)
+			err = w.writeLines(data)
 			if err != nil {
 				logrus.WithField("err", err).Error("nicelog write failed")
 				return
@@ -52,9 +62,65 @@ func (w *Writer) Write(b []byte) (n int, err error) {
 		w.pending = w.pending[idx+1:]
 	}
 
+	// a line without a newline is split once it exceeds the
+	// maximum line size, and the buffer of a long line is
+	// released once the line is written.
+	for len(w.pending) > maxLine {
+		if err = w.writeChunk(w.pending[:maxLine]); err != nil {
+			logrus.WithField("err", err).Error("nicelog write failed")
+			return
+		}
+		w.pending = w.pending[maxLine:]
+	}
+	if cap(w.pending) > maxLine {
+		w.pending = append(make([]byte, 0, limit), w.pending...)
+	}
 	return
 }
 
+// writeLines writes the lines to the underlying writer. Lines
+// that exceed the maximum line size are split into chunks.
+func (w *Writer) writeLines(data []byte) error {
+	start := 0
+	for pos := 0; pos < len(data); {
+		end := bytes.IndexByte(data[pos:], '\n')
+		if end == -1 {
+			end = len(data)
+		} else {
+			end += pos
+		}
+		if end-pos > maxLine {
+			// the short lines before the long line are
+			// written unchanged.
+			if start < pos {
+				if _, err := w.writer.Write(data[start:pos]); err != nil {
+					return err
+				}
+			}
+			for ; end-pos > maxLine; pos += maxLine {
+				if err := w.writeChunk(data[pos : pos+maxLine]); err != nil {
+					return err
+				}
+			}
+			start = pos
+		}
+		pos = end + 1
+	}
+	if start < len(data) {
+		_, err := w.writer.Write(data[start:])
+		return err
+	}
+	return nil
+}
+
+// writeChunk writes the chunk of a split line, followed by
+// the continuation marker.
+func (w *Writer) writeChunk(chunk []byte) error {
+	w.chunk = append(append(w.chunk[:0], chunk...), continuation...)
+	_, err := w.writer.Write(w.chunk)
+	return err
+}
+
 // HasWritten return true if data has been written
 func (w *Writer) HasWritten() bool {
 	return w.hasWritten
@@ -0,0 +1,73 @@
+package nicelog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// recorder records each write as a separate line, like the
+// live log writer.
+type recorder struct {
+	writes []string
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.writes = append(r.writes, string(p))
+	return len(p), nil
+}
+
+func TestWriter_SplitLongLine(t *testing.T) {
+	r := new(recorder)
+	w := New(r)
+
+	line := strings.Repeat("a", maxLine*2+10)
+	w.Write([]byte("before\n" + line + "\nafter\n"))
+
+	want := []string{
+		"before\n",
+		strings.Repeat("a", maxLine) + string(continuation),
+		strings.Repeat("a", maxLine) + string(continuation),
+		strings.Repeat("a", 10) + "\nafter",
+	}
+	if len(r.writes) != len(want) {
+		t.Fatalf("Want %d writes, got %d", len(want), len(r.writes))
+	}
+	for i := range want {
+		if r.writes[i] != want[i] {
+			t.Errorf("Unexpected write %d of %d bytes", i, len(r.writes[i]))
+		}
+	}
+}
+
+func TestWriter_SplitPendingLine(t *testing.T) {
+	r := new(recorder)
+	w := New(r)
+
+	w.Write(bytes.Repeat([]byte("a"), maxLine+10))
+	if len(r.writes) != 1 || len(r.writes[0]) != maxLine+len(continuation) {
+		t.Errorf("Want a line without newline split at the maximum line size")
+	}
+	if got, want := len(w.pending), 10; got != want {
+		t.Errorf("Want %d bytes pending, got %d", want, got)
+	}
+	if cap(w.pending) > maxLine {
+		t.Errorf("Want the buffer of the long line released")
+	}
+	w.Flush()
+	if got, want := r.writes[len(r.writes)-1], "aaaaaaaaaa"; got != want {
+		t.Errorf("Want the remainder flushed, got %q", got)
+	}
+}
+
+func TestWriter_ShortLines(t *testing.T) {
+	r := new(recorder)
+	w := New(r)
+	w.Write([]byte("hello\nworld\npartial"))
+	if len(r.writes) != 1 || r.writes[0] != "hello\nworld" {
+		t.Errorf("Want complete lines written unchanged, got %q", r.writes)
+	}
+	if got := string(w.pending); got != "partial" {
+		t.Errorf("Want the partial line pending, got %q", got)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"expvar"
+	"fmt"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+// cache metrics, exposed with the expvar handler.
+var (
+	cacheHits   = expvar.NewInt("config_cache_hits")
+	cacheMisses = expvar.NewInt("config_cache_misses")
+)
+
+// Cache caches parsed pipeline configurations, keyed by a hash
+// of the configuration file after variable substitution and the
+// stage name. Retried stages and stages of the same build with
+// identical configuration files skip parsing, which is costly
+// for large generated configuration files.
+//
+// Cached pipelines are shared between stages and must not be
+// modified by the compiler.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key      string
+	manifest *manifest.Manifest
+	pipeline *resource.Pipeline
+}
+
+// NewCache returns a new cache that holds up to size parsed
+// pipeline configurations.
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// helper function returns the parsed manifest and the named
+// pipeline resource, parsing the configuration file if the
+// result is not cached.
+func (c *Cache) parse(config, stage string) (*manifest.Manifest, *resource.Pipeline, error) {
+	if c == nil || c.size <= 0 {
+		return parse(config, stage)
+	}
+	key := cacheKey(config, stage)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		entry := elem.Value.(*cacheEntry)
+		c.mu.Unlock()
+		cacheHits.Add(1)
+		return entry.manifest, entry.pipeline, nil
+	}
+	c.mu.Unlock()
+	cacheMisses.Add(1)
+
+	m, p, err := parse(config, stage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(&cacheEntry{
+			key:      key,
+			manifest: m,
+			pipeline: p,
+		})
+	}
+	for c.order.Len() > c.size {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*cacheEntry).key)
+	}
+	return m, p, nil
+}
+
+// helper function parses the configuration file and returns
+// the named pipeline resource.
+func parse(config, stage string) (*manifest.Manifest, *resource.Pipeline, error) {
+	m, err := manifest.ParseString(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := resource.Lookup(stage, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, p, nil
+}
+
+// helper function returns the cache key.
+func cacheKey(config, stage string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", stage, config)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import "testing"
+
+const testConfig = `
+kind: pipeline
+type: kubernetes
+name: default
+
+steps:
+- name: test
+  image: golang
+  commands:
+  - go test
+`
+
+func TestCache(t *testing.T) {
+	c := NewCache(1)
+
+	hits, misses := cacheHits.Value(), cacheMisses.Value()
+	_, a, err := c.parse(testConfig, "default")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, b, err := c.parse(testConfig, "default")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if a != b {
+		t.Errorf("Expect cached pipeline returned")
+	}
+	if got := cacheHits.Value() - hits; got != 1 {
+		t.Errorf("Want 1 cache hit, got %d", got)
+	}
+	if got := cacheMisses.Value() - misses; got != 1 {
+		t.Errorf("Want 1 cache miss, got %d", got)
+	}
+
+	// the least recently used entry is evicted when the
+	// cache is full.
+	c.parse(testConfig+"\n", "default")
+	if _, ok := c.entries[cacheKey(testConfig, "default")]; ok {
+		t.Errorf("Expect least recently used entry evicted")
+	}
+}
+
+func TestCache_Error(t *testing.T) {
+	c := NewCache(1)
+	if _, _, err := c.parse(testConfig, "missing"); err == nil {
+		t.Errorf("Expect error for a missing stage")
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("Expect errors not cached")
+	}
+}
+
+func TestCache_Disabled(t *testing.T) {
+	var c *Cache
+	_, a, _ := c.parse(testConfig, "default")
+	_, b, _ := c.parse(testConfig, "default")
+	if a == nil || a == b {
+		t.Errorf("Expect pipeline parsed without a cache")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"sync"
+)
+
+// Canceller tracks the stages executed by the runner, so that
+// a running stage or step can be cancelled by the runner, for
+// example if the cancellation from the remote server is stuck
+// or the server is unreachable.
+type Canceller struct {
+	mu     sync.Mutex
+	stages map[int64]*running
+}
+
+// running tracks a running stage.
+type running struct {
+	repo   string
+	build  int64
+	cancel func()
+
+	mu    sync.Mutex
+	steps map[string]func()
+}
+
+// NewCanceller returns a new canceller.
+func NewCanceller() *Canceller {
+	return &Canceller{
+		stages: map[int64]*running{},
+	}
+}
+
+// Cancel cancels the running stages of the build. If the
+// stage is not zero, only the matching stage is cancelled.
+// Returns the number of cancelled stages.
+func (c *Canceller) Cancel(build, stage int64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for id, r := range c.stages {
+		if (build == 0 || r.build == build) && (stage == 0 || id == stage) {
+			r.cancel()
+			n++
+		}
+	}
+	return n
+}
+
+// CancelRepo cancels the running stages of the repository,
+// for example if the repository is disabled or its credentials
+// are revoked. Returns the number of cancelled stages.
+func (c *Canceller) CancelRepo(repo string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for _, r := range c.stages {
+		if r.repo == repo {
+			r.cancel()
+			n++
+		}
+	}
+	return n
+}
+
+// CancelStep cancels the named step of the running stage.
+// The step fails and the stage continues. Returns false if
+// the step is not running.
+func (c *Canceller) CancelStep(stage int64, name string) bool {
+	c.mu.Lock()
+	r, ok := c.stages[stage]
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	r.mu.Lock()
+	cancel, ok := r.steps[name]
+	r.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// helper function tracks the running stage until the returned
+// function is called. The stage is added to the context, so
+// that the execer can track the running steps.
+func (c *Canceller) track(ctx context.Context, repo string, build, stage int64, cancel func()) (context.Context, func()) {
+	r := &running{
+		repo:   repo,
+		build:  build,
+		cancel: cancel,
+		steps:  map[string]func(){},
+	}
+	c.mu.Lock()
+	c.stages[stage] = r
+	c.mu.Unlock()
+	return context.WithValue(ctx, runningKey{}, r), func() {
+		c.mu.Lock()
+		delete(c.stages, stage)
+		c.mu.Unlock()
+	}
+}
+
+type runningKey struct{}
+
+// helper function returns a context for the step that is
+// cancelled if the step is cancelled by the canceller. The
+// context is not tracked if the stage is not tracked.
+func trackStep(ctx context.Context, name string) (context.Context, func()) {
+	r, ok := ctx.Value(runningKey{}).(*running)
+	if !ok {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	r.steps[name] = cancel
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.steps, name)
+		r.mu.Unlock()
+		cancel()
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCanceller(t *testing.T) {
+	c := NewCanceller()
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel1()
+	defer cancel2()
+
+	_, untrack1 := c.track(ctx1, "octocat/hello-world", 1, 10, cancel1)
+	_, untrack2 := c.track(ctx2, "octocat/spoon-knife", 2, 20, cancel2)
+	defer untrack2()
+
+	if got, want := c.Cancel(3, 0), 0; got != want {
+		t.Errorf("Want %d cancelled stages, got %d", want, got)
+	}
+	if got, want := c.Cancel(1, 0), 1; got != want {
+		t.Errorf("Want %d cancelled stages, got %d", want, got)
+	}
+	if ctx1.Err() == nil {
+		t.Errorf("Want stage 10 cancelled")
+	}
+	if ctx2.Err() != nil {
+		t.Errorf("Want stage 20 running")
+	}
+
+	// stages are no longer cancelled once they complete.
+	untrack1()
+	if got, want := c.Cancel(0, 10), 0; got != want {
+		t.Errorf("Want %d cancelled stages, got %d", want, got)
+	}
+}
+
+func TestCanceller_Step(t *testing.T) {
+	c := NewCanceller()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctx, untrack := c.track(ctx, "octocat/hello-world", 1, 10, cancel)
+	defer untrack()
+
+	if c.CancelStep(10, "test") {
+		t.Errorf("Want step not running")
+	}
+	stepCtx, done := trackStep(ctx, "test")
+	if !c.CancelStep(10, "test") {
+		t.Errorf("Want step cancelled")
+	}
+	if stepCtx.Err() == nil {
+		t.Errorf("Want step context cancelled")
+	}
+	if ctx.Err() != nil {
+		t.Errorf("Want stage context not cancelled")
+	}
+	done()
+	if c.CancelStep(10, "test") {
+		t.Errorf("Want step no longer running")
+	}
+}
+
+func TestTrackStep_Untracked(t *testing.T) {
+	ctx := context.Background()
+	stepCtx, done := trackStep(ctx, "test")
+	defer done()
+	if stepCtx != ctx {
+		t.Errorf("Want the context unchanged if the stage is not tracked")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"sync"
+)
+
+// Capacity limits the number of stages the poller executes
+// concurrently. The limit can be adjusted at runtime, up to
+// the number of poller connections.
+type Capacity struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	changed chan struct{}
+}
+
+// NewCapacity returns a new capacity with the given limit.
+func NewCapacity(limit int) *Capacity {
+	return &Capacity{
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+// Set sets the capacity limit. Running stages are not
+// affected if the limit is reduced.
+func (c *Capacity) Set(limit int) {
+	c.mu.Lock()
+	c.limit = limit
+	c.notify()
+	c.mu.Unlock()
+}
+
+// Limit returns the capacity limit.
+func (c *Capacity) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+// Active returns the number of stages being requested or
+// executed.
+func (c *Capacity) Active() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// helper function blocks until the number of active stages
+// is below the limit, or the context is cancelled.
+func (c *Capacity) acquire(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.active < c.limit {
+			c.active++
+			c.mu.Unlock()
+			return nil
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// helper function releases a stage acquired by acquire.
+func (c *Capacity) release() {
+	c.mu.Lock()
+	c.active--
+	c.notify()
+	c.mu.Unlock()
+}
+
+// helper function wakes the goroutines waiting for capacity.
+// The caller must hold the lock.
+func (c *Capacity) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCapacity(t *testing.T) {
+	c := NewCapacity(1)
+	ctx := context.Background()
+	if err := c.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		if err := c.acquire(ctx); err != nil {
+			t.Error(err)
+		}
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("Expect acquire to block at capacity")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// increasing the limit unblocks the waiting poller.
+	c.Set(2)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("Expect acquire to unblock when the limit is increased")
+	}
+	if got, want := c.Active(), 2; got != want {
+		t.Errorf("Want %d active, got %d", want, got)
+	}
+
+	c.release()
+	c.release()
+	if got, want := c.Active(), 0; got != want {
+		t.Errorf("Want %d active, got %d", want, got)
+	}
+}
+
+func TestCapacity_Cancel(t *testing.T) {
+	c := NewCapacity(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.acquire(ctx); err != context.Canceled {
+		t.Errorf("Want context canceled error, got %v", err)
+	}
+}
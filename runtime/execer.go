@@ -8,10 +8,23 @@ package runtime
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
 	"github.com/drone-runners/drone-runner-kube/engine/replacer"
+	"github.com/drone-runners/drone-runner-kube/engine/scanner"
+	"github.com/drone-runners/drone-runner-kube/internal/falco"
+	"github.com/drone-runners/drone-runner-kube/internal/flaky"
+	"github.com/drone-runners/drone-runner-kube/internal/provenance"
+	"github.com/drone-runners/drone-runner-kube/internal/sbom"
+	"github.com/drone-runners/drone-runner-kube/internal/trace"
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/logger"
@@ -26,6 +39,10 @@ import (
 // representation of a pipeline.
 type Execer interface {
 	Exec(context.Context, *engine.Spec, *pipeline.State) error
+
+	// Resume resumes the execution of a pipeline that was
+	// running when the runner process exited.
+	Resume(context.Context, *engine.Recovered, *pipeline.State) error
 }
 
 type execer struct {
@@ -34,19 +51,47 @@ type execer struct {
 	reporter pipeline.Reporter
 	streamer pipeline.Streamer
 	sem      *semaphore.Weighted
+	traces   string
+	sboms    string
+	emitter  *provenance.Emitter
+	alerts   *falco.Receiver
+	stages   StageObserver
+	flaky    *flaky.Tracker
 }
 
-// NewExecer returns a new execer used
+// NewExecer returns a new execer used. If the traces directory
+// is not empty, a trace of the pipeline execution timings is
+// written to the directory for each pipeline. If the sboms
+// directory is not empty, a bill of materials of the pipeline
+// images is written to the directory for each pipeline. If
+// the emitter is not nil, signed provenance is emitted for
+// each pipeline. If the alerts receiver is not nil, the
+// security alerts of the node agent are written to the step
+// logs. If the stage observer is not nil, it is notified when
+// each stage starts and finishes. If the flaky tracker is not
+// nil, steps that pass on retry are marked as flaky.
 func NewExecer(
 	reporter pipeline.Reporter,
 	streamer pipeline.Streamer,
 	engine engine.Engine,
 	procs int64,
+	traces string,
+	sboms string,
+	emitter *provenance.Emitter,
+	alerts *falco.Receiver,
+	stages StageObserver,
+	flaky *flaky.Tracker,
 ) Execer {
 	exec := &execer{
 		reporter: reporter,
 		streamer: streamer,
 		engine:   engine,
+		traces:   traces,
+		sboms:    sboms,
+		emitter:  emitter,
+		alerts:   alerts,
+		stages:   stages,
+		flaky:    flaky,
 	}
 	if procs > 0 {
 		// optional semaphor that limits the number of steps
@@ -59,20 +104,145 @@ func NewExecer(
 // Exec executes the intermediate representation of the pipeline
 // and returns an error if execution fails.
 func (e *execer) Exec(ctx context.Context, spec *engine.Spec, state *pipeline.State) error {
-	defer e.engine.Destroy(noContext, spec)
+	tr := trace.New()
+
+	// the pipeline is validated before it waits for the engine
+	// locks, so that pipelines rejected by the cluster, for
+	// example by admission policies, fail immediately.
+	if v, ok := e.engine.(engine.Validator); ok {
+		end := tr.Begin("validate", "validate", 0)
+		err := v.Validate(ctx, spec)
+		end(nil)
+		if err != nil {
+			state.FailAll(err)
+			return e.reporter.ReportStage(noContext, state)
+		}
+	}
+
+	// pipelines wait for the engine locks, for example the
+	// concurrency group lock, before the pipeline is created.
+	if l, ok := e.engine.(engine.Locker); ok {
+		// if the pipeline must wait for a lock, a message is
+		// written to the log stream of the first step so that
+		// the pipeline is not silently pending.
+		var wc io.WriteCloser
+		wait := func(lock string) {
+			logger.FromContext(ctx).
+				WithField("lock", lock).
+				Infoln("waiting on lock")
+			if len(spec.Steps) == 0 {
+				return
+			}
+			if wc == nil {
+				wc = e.streamer.Stream(noContext, state, spec.Steps[0].Name)
+			}
+			fmt.Fprintf(wc, "waiting on %s\n", lock)
+		}
+		end := tr.Begin("lock", "lock", 0)
+		unlock, err := l.Lock(ctx, spec, wait)
+		end(nil)
+		if wc != nil {
+			wc.Close()
+		}
+		switch err {
+		case nil:
+			defer unlock()
+		case context.Canceled, context.DeadlineExceeded:
+			state.Cancel()
+			return e.reporter.ReportStage(noContext, state)
+		default:
+			state.FailAll(err)
+			return e.reporter.ReportStage(noContext, state)
+		}
+	}
 
-	if err := e.engine.Setup(noContext, spec); err != nil {
+	defer e.teardown(ctx, tr, spec, state)
+
+	end := tr.Begin("setup", "setup", 0)
+	err := e.engine.Setup(noContext, spec)
+	end(nil)
+	if err != nil {
 		state.FailAll(err)
 		return e.reporter.ReportStage(noContext, state)
 	}
+	defer e.observeStage(ctx, spec, state)()
+	return e.run(ctx, tr, spec, state, nil)
+}
+
+// Resume resumes the execution of the recovered pipeline. The
+// steps that exited before the runner restarted are finished
+// with the recorded exit code, the steps that were running are
+// re-attached, and the remaining steps are executed.
+func (e *execer) Resume(ctx context.Context, rec *engine.Recovered, state *pipeline.State) error {
+	tr := trace.New()
+
+	// the locks of the pipeline were held by the runner
+	// process that exited, and are re-acquired before the
+	// pipeline resumes, so that the concurrency lease does
+	// not expire and the pipeline objects are counted
+	// against the namespace quota.
+	if l, ok := e.engine.(engine.Locker); ok {
+		wait := func(lock string) {
+			logger.FromContext(ctx).
+				WithField("lock", lock).
+				Infoln("waiting on lock")
+		}
+		end := tr.Begin("lock", "lock", 0)
+		unlock, err := l.Relock(ctx, rec.Spec, wait)
+		end(nil)
+		switch err {
+		case nil:
+			defer unlock()
+		case context.Canceled, context.DeadlineExceeded:
+			state.Cancel()
+		default:
+			state.FailAll(err)
+		}
+		if err != nil {
+			e.teardown(ctx, tr, rec.Spec, state)
+			return e.reporter.ReportStage(noContext, state)
+		}
+	}
+
+	defer e.teardown(ctx, tr, rec.Spec, state)
+	return e.run(ctx, tr, rec.Spec, state, rec.Steps)
+}
+
+// helper function records the pipeline images, and destroys
+// the pipeline environment unless it is retained.
+func (e *execer) teardown(ctx context.Context, tr *trace.Trace, spec *engine.Spec, state *pipeline.State) {
+	// the pipeline images are recorded before the pipeline
+	// is destroyed.
+	images := e.images(ctx, spec)
+	e.inventory(ctx, images, state)
+	e.attest(ctx, spec, images, state)
+	e.record(ctx, spec, images, state)
+
+	end := tr.Begin("teardown", "teardown", 0)
+	if !e.retain(ctx, spec, state) {
+		e.engine.Destroy(noContext, spec)
+	}
+	end(nil)
+	e.export(ctx, tr, state)
+}
+
+// helper function executes the pipeline steps. The steps with
+// a checkpoint were started before the runner restarted.
+func (e *execer) run(ctx context.Context, tr *trace.Trace, spec *engine.Spec, state *pipeline.State, checkpoints map[string]*engine.Checkpoint) error {
+	// the security alerts of the pipeline pod are stored
+	// while the pipeline is running.
+	defer e.alerts.Watch(spec.PodSpec.Name)()
 
 	// create a directed graph, where each vertex in the graph
-	// is a pipeline step.
+	// is a pipeline step. Each step is traced on a separate
+	// lane, so that concurrent steps are rendered separately.
 	var d dag.Runner
-	for _, s := range spec.Steps {
-		step := s
+	for i, s := range spec.Steps {
+		step, lane := s, i+1
 		d.AddVertex(step.Name, func() error {
-			return e.exec(ctx, state, spec, step)
+			end := tr.Begin("step", step.Name, lane)
+			defer end(nil)
+			return e.exec(ctx, state, spec, step, checkpoints[step.Name])
 		})
 	}
 
@@ -92,13 +262,48 @@ func (e *execer) Exec(ctx context.Context, spec *engine.Spec, state *pipeline.St
 	// once pipeline execution completes, notify the state
 	// manager that all steps are finished.
 	state.FinishAll()
+
+	// snapshot the pipeline volumes if the pipeline succeeded
+	// and the engine supports snapshots.
+	if s, ok := e.engine.(engine.Snapshotter); ok {
+		if !state.Failed() && !state.Cancelled() {
+			if err := s.Snapshot(noContext, spec); err != nil {
+				logger.FromContext(ctx).
+					WithError(err).
+					Warn("cannot snapshot pipeline volumes")
+			}
+		}
+	}
 	if err := e.reporter.ReportStage(noContext, state); err != nil {
 		multierror.Append(result, err)
 	}
 	return result
 }
 
-func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.Spec, step *engine.Step) error {
+// helper function retains the pipeline environment instead of
+// destroying it if the pipeline failed and the engine supports
+// retention.
+func (e *execer) retain(ctx context.Context, spec *engine.Spec, state *pipeline.State) bool {
+	r, ok := e.engine.(engine.Retainer)
+	if !ok || !state.Failed() || state.Cancelled() {
+		return false
+	}
+	retained, err := r.Retain(noContext, spec)
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			Warn("cannot retain the failed pipeline")
+		return false
+	}
+	if retained {
+		logger.FromContext(ctx).
+			WithField("pod", spec.PodSpec.Name).
+			Info("retaining the failed pipeline")
+	}
+	return retained
+}
+
+func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.Spec, step *engine.Step, cp *engine.Checkpoint) error {
 	var result error
 
 	select {
@@ -108,6 +313,21 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 	default:
 	}
 
+	// steps that were started before the runner restarted are
+	// re-attached instead of executed.
+	run := e.engine.Run
+	if cp != nil {
+		r, ok := e.engine.(engine.Recoverer)
+		if !ok {
+			state.Fail(step.Name, errors.New("cannot re-attach to the step"))
+			return e.reporter.ReportStep(noContext, state, step.Name)
+		}
+		run = r.Attach
+		if cp.Exited || step.Detach {
+			return e.restore(state, step, cp)
+		}
+	}
+
 	log := logger.FromContext(ctx)
 	log = log.WithField("step.name", step.Name)
 	ctx = logger.WithContext(ctx, log)
@@ -150,6 +370,11 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 	}
 
 	state.Start(step.Name)
+	if cp != nil {
+		state.Lock()
+		findStep(state, step.Name).Started = cp.Started
+		state.Unlock()
+	}
 	err := e.reporter.ReportStep(noContext, state, step.Name)
 	if err != nil {
 		return err
@@ -170,6 +395,15 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 
 	// writer used to stream build logs.
 	wc := e.streamer.Stream(noContext, state, step.Name)
+
+	// the step output is scanned for secrets after known
+	// secrets are masked, so that only unmasked secrets are
+	// reported.
+	var scan *scanner.Scanner
+	if spec.SecretScan != engine.ScanNone {
+		scan = scanner.New(wc)
+		wc = scan
+	}
 	wc = replacer.New(wc, toSecretSlice(spec.Secrets))
 
 	// if the step is configured as a daemon, it is detached
@@ -183,7 +417,33 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 		return nil
 	}
 
-	exited, err := e.engine.Run(ctx, spec, copy, wc)
+	// the step can be cancelled from the control api, in
+	// which case the step fails and the stage continues.
+	stepCtx, untrack := trackStep(ctx, step.Name)
+	exited, err := run(stepCtx, spec, copy, wc)
+	if err != nil && ctx.Err() == nil && stepCtx.Err() != nil {
+		exited, err = nil, errors.New("step cancelled by the runner")
+	}
+	untrack()
+
+	// the security alerts raised by the node agent for the
+	// step container are written to the step log.
+	alerts := e.alerts.Alerts(spec.PodSpec.Name, step.ID)
+	for _, alert := range alerts {
+		fmt.Fprintln(wc, alert)
+	}
+
+	// steps that pass on a commit for which the step failed
+	// before are marked as flaky in the step output.
+	if exited != nil && exited.ExitCode != engine.ExitCodeSkipped && exited.ExitCode != 78 {
+		passed := exited.ExitCode == 0 && !exited.TimedOut
+		state.Lock()
+		repo, commit, stage := state.Repo.Slug, state.Build.After, state.Stage.Name
+		state.Unlock()
+		if e.flaky.Record(repo, commit, stage, step.Name, passed) {
+			fmt.Fprintf(wc, "flaky: the step failed on a previous build of commit %.8s and passed on retry\n", commit)
+		}
+	}
 
 	// close the stream. If the session is a remote session, the
 	// full log buffer is uploaded to the remote server.
@@ -192,7 +452,28 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 	}
 
 	if exited != nil {
-		state.Finish(step.Name, exited.ExitCode)
+		// if the fail policy is configured, the step fails
+		// when secrets are found in the step output.
+		if exited.TimedOut {
+			// steps that exceed the step timeout fail with an
+			// error, so that timed out steps are distinct from
+			// steps that exit with an error or are cancelled.
+			state.Fail(step.Name, &engine.Error{
+				Code: engine.CodeStepTimeout,
+				Err:  fmt.Errorf("step timed out after %s", time.Duration(step.Timeout)*time.Second),
+			})
+		} else if exited.ExitCode == 0 && scan != nil && len(scan.Found()) != 0 && spec.SecretScan == engine.ScanFail {
+			state.Fail(step.Name, errors.New("secrets detected in the step output"))
+		} else if exited.ExitCode == 0 && len(alerts) != 0 && e.alerts.Fail() {
+			state.Fail(step.Name, fmt.Errorf("security alerts raised by the step: %s", toRules(alerts)))
+		} else if exited.ExitCode == engine.ExitCodeSkipped && step.Paths != nil {
+			// the step exits with the skipped exit code if
+			// none of the changed files match the step path
+			// conditions.
+			state.Skip(step.Name)
+		} else {
+			state.Finish(step.Name, exited.ExitCode)
+		}
 		err := e.reporter.ReportStep(noContext, state, step.Name)
 		if err != nil {
 			multierror.Append(result, err)
@@ -221,6 +502,204 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 	return result
 }
 
+// helper function restores the state of a step that exited,
+// or of a detached step that was started, before the runner
+// restarted. Detached steps continue to run in the pod.
+func (e *execer) restore(state *pipeline.State, step *engine.Step, cp *engine.Checkpoint) error {
+	if step.Detach && !cp.Exited {
+		state.Start(step.Name)
+		state.Lock()
+		findStep(state, step.Name).Started = cp.Started
+		state.Unlock()
+		return nil
+	}
+	if cp.ExitCode == engine.ExitCodeSkipped && step.Paths != nil {
+		state.Skip(step.Name)
+	} else {
+		state.Finish(step.Name, cp.ExitCode)
+	}
+	state.Lock()
+	s := findStep(state, step.Name)
+	s.Started, s.Stopped = cp.Started, cp.Stopped
+	state.Unlock()
+	if cp.ExitCode == 78 {
+		state.SkipAll()
+	}
+	return e.reporter.ReportStep(noContext, state, step.Name)
+}
+
+// helper function writes the pipeline trace to the traces
+// directory.
+func (e *execer) export(ctx context.Context, tr *trace.Trace, state *pipeline.State) {
+	if e.traces == "" {
+		return
+	}
+	state.Lock()
+	path := filepath.Join(
+		e.traces,
+		state.Repo.Slug,
+		fmt.Sprint(state.Build.Number),
+		fmt.Sprintf("%d.json", state.Stage.Number),
+	)
+	state.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		var f *os.File
+		f, err = os.Create(path)
+		if err == nil {
+			err = tr.Write(f)
+			f.Close()
+		}
+	}
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			Warn("cannot export pipeline trace")
+	}
+}
+
+// helper function returns the pipeline images, if a bill of
+// materials or provenance is required and the engine supports
+// listing the pipeline images.
+func (e *execer) images(ctx context.Context, spec *engine.Spec) []*engine.Image {
+	if e.sboms == "" && e.emitter == nil {
+		return nil
+	}
+	inv, ok := e.engine.(engine.Inventory)
+	if !ok {
+		return nil
+	}
+	images, err := inv.Images(noContext, spec)
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			Warn("cannot list pipeline images")
+		return nil
+	}
+
+	// the containers are named by the step id, and are
+	// renamed to the step name.
+	names := map[string]string{}
+	for _, step := range spec.Init {
+		names[step.ID] = step.Name
+	}
+	for _, step := range spec.Steps {
+		names[step.ID] = step.Name
+	}
+	for _, image := range images {
+		if name, ok := names[image.Container]; ok {
+			image.Container = name
+		}
+	}
+	return images
+}
+
+// helper function writes the bill of materials of the pipeline
+// images to the sboms directory.
+func (e *execer) inventory(ctx context.Context, images []*engine.Image, state *pipeline.State) {
+	if e.sboms == "" || len(images) == 0 {
+		return
+	}
+
+	state.Lock()
+	doc := sbom.New(state.Repo.Slug, fmt.Sprint(state.Build.Number), images)
+	path := filepath.Join(
+		e.sboms,
+		state.Repo.Slug,
+		fmt.Sprint(state.Build.Number),
+		fmt.Sprintf("%d.json", state.Stage.Number),
+	)
+	state.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		var f *os.File
+		f, err = os.Create(path)
+		if err == nil {
+			err = doc.Write(f)
+			f.Close()
+		}
+	}
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			Warn("cannot export pipeline sbom")
+	}
+}
+
+// helper function emits the signed provenance of the pipeline.
+// Provenance is only emitted for pipelines that succeed.
+func (e *execer) attest(ctx context.Context, spec *engine.Spec, images []*engine.Image, state *pipeline.State) {
+	if e.emitter == nil || state.Failed() || state.Cancelled() {
+		return
+	}
+	// the build details are copied so that the state is not
+	// locked while the provenance is uploaded.
+	state.Lock()
+	system := new(drone.System)
+	if state.System != nil {
+		*system = *state.System
+	}
+	repo, build, stage := *state.Repo, *state.Build, *state.Stage
+	state.Unlock()
+
+	in := &provenance.Input{
+		System: system,
+		Repo:   &repo,
+		Build:  &build,
+		Stage:  &stage,
+		Spec:   spec,
+		Images: images,
+	}
+	if err := e.emitter.Emit(noContext, in); err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			Warn("cannot emit pipeline provenance")
+	}
+}
+
+// helper function emits the signed execution record of the
+// pipeline. Records are emitted for every pipeline, so that
+// failed and cancelled pipelines can be audited.
+func (e *execer) record(ctx context.Context, spec *engine.Spec, images []*engine.Image, state *pipeline.State) {
+	if e.emitter == nil {
+		return
+	}
+	status := drone.StatusPassing
+	switch {
+	case state.Cancelled():
+		status = drone.StatusKilled
+	case state.Failed():
+		status = drone.StatusFailing
+	}
+
+	// the build details are copied so that the state is not
+	// locked while the record is uploaded.
+	state.Lock()
+	repo, build, stage := *state.Repo, *state.Build, *state.Stage
+	stage.Steps = nil
+	for _, step := range state.Stage.Steps {
+		copy := *step
+		stage.Steps = append(stage.Steps, &copy)
+	}
+	state.Unlock()
+
+	in := &provenance.Input{
+		Repo:   &repo,
+		Build:  &build,
+		Stage:  &stage,
+		Spec:   spec,
+		Images: images,
+		Status: status,
+	}
+	if err := e.emitter.Record(noContext, in); err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			Warn("cannot emit pipeline execution record")
+	}
+}
+
 // helper function to clone a step. The runner mutates a step to
 // update the environment variables to reflect the current
 // pipeline state.
@@ -241,6 +720,19 @@ func findStep(state *pipeline.State, name string) *drone.Step {
 	panic("step not found: " + name)
 }
 
+// helper function returns the distinct rules of the alerts.
+func toRules(alerts []*falco.Alert) string {
+	var rules []string
+	seen := map[string]bool{}
+	for _, alert := range alerts {
+		if !seen[alert.Rule] {
+			seen[alert.Rule] = true
+			rules = append(rules, alert.Rule)
+		}
+	}
+	return strings.Join(rules, ", ")
+}
+
 // helper function converts a map of secrets to a slice.
 func toSecretSlice(src map[string]*engine.Secret) []*engine.Secret {
 	var dst []*engine.Secret
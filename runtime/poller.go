@@ -22,6 +22,11 @@ type Poller struct {
 	Client client.Client
 	Filter *client.Filter
 	Runner *Runner
+
+	// Capacity optionally limits the number of stages that
+	// are executed concurrently, and can be adjusted at
+	// runtime up to the number of connections.
+	Capacity *Capacity
 }
 
 // Poll opens N connections to the server to poll for pending
@@ -38,7 +43,7 @@ func (p *Poller) Poll(ctx context.Context, n int) {
 					wg.Done()
 					return
 				default:
-					p.poll(ctx, i+1)
+					p.pollWithCapacity(ctx, i+1)
 				}
 			}
 		}(i)
@@ -47,6 +52,20 @@ func (p *Poller) Poll(ctx context.Context, n int) {
 	wg.Wait()
 }
 
+// pollWithCapacity waits until the number of active stages is
+// below the capacity limit, and then polls for a stage.
+func (p *Poller) pollWithCapacity(ctx context.Context, thread int) {
+	if p.Capacity == nil {
+		p.poll(ctx, thread)
+		return
+	}
+	if err := p.Capacity.acquire(ctx); err != nil {
+		return
+	}
+	defer p.Capacity.release()
+	p.poll(ctx, thread)
+}
+
 // poll requests a stage for execution from the server, and then
 // dispatches for execution.
 func (p *Poller) poll(ctx context.Context, thread int) error {
@@ -0,0 +1,129 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// checkpoint provides the stage details stored with the
+// pipeline, so that the stage can be resumed if the runner
+// process restarts.
+type checkpoint struct {
+	Machine string       `json:"machine"`
+	Stage   *drone.Stage `json:"stage"`
+}
+
+// helper function returns the checkpoint of the stage.
+func toCheckpoint(machine string, stage *drone.Stage) []byte {
+	data, _ := json.Marshal(&checkpoint{
+		Machine: machine,
+		Stage:   stage,
+	})
+	return data
+}
+
+// Recover re-attaches to the pipelines in the namespaces that
+// were started by the runner machine and were running when the
+// runner process exited, and resumes the stages in the
+// background. Pipelines of stages that are no longer running
+// are destroyed. The prefix must match the label prefix used
+// to compile the pipeline.
+func (s *Runner) Recover(ctx context.Context, eng engine.Engine, namespaces []string, prefix string) {
+	recoverer, ok := eng.(engine.Recoverer)
+	if !ok {
+		return
+	}
+	// pipelines of other runners are resumed by the runner
+	// that created the pipeline.
+	match := func(spec *engine.Spec) bool {
+		cp := new(checkpoint)
+		err := json.Unmarshal(spec.Checkpoint, cp)
+		return err == nil && cp.Stage != nil && cp.Machine == s.Machine
+	}
+	for _, namespace := range namespaces {
+		recovered, err := recoverer.Recover(ctx, namespace, prefix, match)
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("namespace", namespace).
+				Warnln("cannot recover pipelines")
+			continue
+		}
+		for _, rec := range recovered {
+			go s.resume(ctx, eng, rec)
+		}
+	}
+}
+
+// helper function resumes the stage of the recovered pipeline.
+func (s *Runner) resume(ctx context.Context, eng engine.Engine, rec *engine.Recovered) {
+	cp := new(checkpoint)
+	json.Unmarshal(rec.Spec.Checkpoint, cp)
+	stage := cp.Stage
+	log := logger.FromContext(ctx).
+		WithField("stage.id", stage.ID).
+		WithField("stage.name", stage.Name).
+		WithField("pod", rec.Spec.PodSpec.Name)
+
+	data, err := s.Client.Detail(ctx, stage)
+	if err != nil {
+		log.WithError(err).Error("cannot get the details of the recovered stage")
+		eng.Destroy(noContext, rec.Spec)
+		return
+	}
+	if data.Build.Status != drone.StatusRunning {
+		log.Info("destroying the pipeline of a stage that is no longer running")
+		eng.Destroy(noContext, rec.Spec)
+		return
+	}
+	log.Info("resuming the recovered stage")
+
+	ctxdone, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the stage timeout includes the time the stage ran
+	// before the runner restarted.
+	timeout := s.stageTimeout(data.Repo) - time.Since(time.Unix(stage.Started, 0))
+	ctxtimeout, cancel := context.WithTimeout(ctxdone, timeout)
+	defer cancel()
+
+	ctxcancel, cancel := context.WithCancel(ctxtimeout)
+	defer cancel()
+
+	go func() {
+		done, _ := s.Client.Watch(ctxdone, data.Build.ID)
+		if done {
+			cancel()
+			log.Debugln("received cancellation")
+		}
+	}()
+	if s.Canceller != nil {
+		var untrack func()
+		ctxcancel, untrack = s.Canceller.track(ctxcancel, data.Repo.Slug, data.Build.ID, stage.ID, cancel)
+		defer untrack()
+	}
+
+	state := &pipeline.State{
+		Build:  data.Build,
+		Stage:  stage,
+		Repo:   data.Repo,
+		System: data.System,
+	}
+	ctxcancel = logger.WithContext(ctxcancel, log)
+	if err := s.Execer.Resume(ctxcancel, rec, state); err != nil {
+		log.WithError(err).Debug("recovered stage failed")
+		return
+	}
+	log.Debug("updated recovered stage to complete")
+}
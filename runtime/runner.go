@@ -17,13 +17,13 @@ import (
 	"github.com/drone-runners/drone-runner-kube/engine/compiler"
 	"github.com/drone-runners/drone-runner-kube/engine/linter"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
+	"github.com/drone-runners/drone-runner-kube/internal/results"
 
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/envsubst"
 	"github.com/drone/runner-go/client"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/logger"
-	"github.com/drone/runner-go/manifest"
 	"github.com/drone/runner-go/pipeline"
 	"github.com/drone/runner-go/secret"
 )
@@ -56,9 +56,67 @@ type Runner struct {
 	// processing an unwanted pipeline.
 	Match func(*drone.Repo, *drone.Build) bool
 
+	// Approve is an optional function that returns true if the
+	// build is approved to run. This is intended to require
+	// maintainer approval of pull requests from forks.
+	Approve func(*drone.Repo, *drone.Build) bool
+
 	// Reporter reports pipeline status and logs back to the
 	// remote server.
 	Reporter pipeline.Reporter
+
+	// Pause is an optional function that blocks while stage
+	// execution is paused for the repository, for example
+	// during cluster maintenance.
+	Pause func(context.Context, *drone.Repo) error
+
+	// Throttle is an optional function that blocks until the
+	// build is permitted to execute by the rate limit for the
+	// build event.
+	Throttle func(context.Context, *drone.Build) error
+
+	// Freeze is an optional function that returns an error if
+	// the build is rejected by a deployment freeze window, and
+	// blocks while the build is held by a freeze window.
+	Freeze func(context.Context, *drone.Repo, *drone.Build) error
+
+	// Cache is an optional cache of parsed pipeline
+	// configurations.
+	Cache *Cache
+
+	// Expand is an optional function that returns the pipeline
+	// with the steps that reference a step bundle expanded.
+	Expand func(context.Context, *resource.Pipeline) (*resource.Pipeline, error)
+
+	// Canceller is an optional canceller used to cancel the
+	// running stages and steps from the control api.
+	Canceller *Canceller
+
+	// Preflight is an optional function that returns an error
+	// if the cluster cannot schedule the compiled pipeline.
+	// The stage is released to the queue, so that the stage
+	// can be accepted by a runner with capacity.
+	Preflight func(context.Context, *engine.Spec) error
+
+	// PreflightBackoff provides the duration the runner waits
+	// before it requests another stage, after a stage is
+	// released to the queue.
+	PreflightBackoff time.Duration
+
+	// MaxTimeout is an optional function that returns the
+	// maximum stage timeout of the repository. The repository
+	// timeout is capped to the maximum if not zero.
+	MaxTimeout func(*drone.Repo) time.Duration
+
+	// Recoverable stores the stage with the pipeline, so that
+	// the runner can resume the stage if the runner process
+	// restarts.
+	Recoverable bool
+
+	// Results is an optional cache of the stages that
+	// succeeded. An identical stage reports the cached result
+	// instead of executing again.
+	Results *results.Store
 }
 
 // Run runs the pipeline stage.
@@ -104,7 +162,7 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 	ctxdone, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	timeout := time.Duration(data.Repo.Timeout) * time.Minute
+	timeout := s.stageTimeout(data.Repo)
 	ctxtimeout, cancel := context.WithTimeout(ctxdone, timeout)
 	defer cancel()
 
@@ -124,6 +182,14 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 		}
 	}()
 
+	// the running stage can also be cancelled by the runner,
+	// if the cancellation from the server is stuck.
+	if s.Canceller != nil {
+		var untrack func()
+		ctxcancel, untrack = s.Canceller.track(ctxcancel, data.Repo.Slug, data.Build.ID, stage.ID, cancel)
+		defer untrack()
+	}
+
 	envs := environ.Combine(
 		environ.System(data.System),
 		environ.Repo(data.Repo),
@@ -160,6 +226,49 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 		return s.Reporter.ReportStage(noContext, state)
 	}
 
+	// evaluates whether or not the build is approved to run.
+	if s.Approve != nil && s.Approve(data.Repo, data.Build) == false {
+		log.Error("cannot process stage, approval required")
+		state.FailAll(errors.New("pull request requires maintainer approval"))
+		return s.Reporter.ReportStage(noContext, state)
+	}
+
+	// waits while stage execution is paused for the
+	// repository. The stage is cancelled if the build is
+	// cancelled while paused.
+	if s.Pause != nil {
+		if err := s.Pause(ctxcancel, data.Repo); err != nil {
+			log.WithError(err).Debug("stage cancelled while paused")
+			state.Cancel()
+			return s.Reporter.ReportStage(noContext, state)
+		}
+	}
+
+	// waits until the stage is permitted by the rate limit
+	// for the build event, for example cron.
+	if s.Throttle != nil {
+		if err := s.Throttle(ctxcancel, data.Build); err != nil {
+			log.WithError(err).Debug("stage cancelled while throttled")
+			state.Cancel()
+			return s.Reporter.ReportStage(noContext, state)
+		}
+	}
+
+	// deployments are rejected or held during a deployment
+	// freeze window, even if the server allows them.
+	if s.Freeze != nil {
+		if err := s.Freeze(ctxcancel, data.Repo, data.Build); err != nil {
+			if ctxcancel.Err() != nil {
+				log.WithError(err).Debug("stage cancelled while frozen")
+				state.Cancel()
+				return s.Reporter.ReportStage(noContext, state)
+			}
+			log.WithError(err).Error("cannot process stage, deployment freeze")
+			state.FailAll(err)
+			return s.Reporter.ReportStage(noContext, state)
+		}
+	}
+
 	// evaluates string replacement expressions and returns an
 	// update configuration file string.
 	config, err := envsubst.Eval(string(data.Config.Data), subf)
@@ -169,29 +278,35 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 		return s.Reporter.ReportStage(noContext, state)
 	}
 
-	// parse the yaml configuration file.
-	manifest, err := manifest.ParseString(config)
+	// parse the yaml configuration file and find the named
+	// stage. The parsed configuration is cached.
+	manifest, resource, err := s.Cache.parse(config, stage.Name)
 	if err != nil {
 		log.WithError(err).Error("cannot parse configuration file")
 		state.FailAll(err)
 		return s.Reporter.ReportStage(noContext, state)
 	}
 
-	// find the named stage in the yaml configuration file.
-	resource, err := resource.Lookup(stage.Name, manifest)
-	if err != nil {
-		log.WithError(err).Error("cannot find pipeline resource")
-		state.FailAll(err)
-		return s.Reporter.ReportStage(noContext, state)
+	// expand the steps that reference a step bundle. The
+	// expanded steps are linted with the pipeline.
+	if s.Expand != nil {
+		resource, err = s.Expand(ctx, resource)
+		if err != nil {
+			log.WithError(err).Error("cannot expand step bundles")
+			state.FailAll(err)
+			return s.Reporter.ReportStage(noContext, state)
+		}
 	}
 
 	// lint the pipeline configuration and fail the build
 	// if any linting rules are broken.
 	err = s.Linter.Lint(resource, linter.Opts{
 		Trusted:   data.Repo.Trusted,
+		Fork:      isFork(data.Repo, data.Build),
 		Namespace: data.Repo.Namespace,
 		Name:      data.Repo.Name,
 		Slug:      data.Repo.Slug,
+		Remote:    data.Repo.HTTPURL,
 	})
 	if err != nil {
 		log.WithError(err).Error("cannot accept configuration")
@@ -219,6 +334,39 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 	}
 
 	spec := s.Compiler.Compile(ctx, args)
+
+	// the stage is skipped without creating the pipeline pod
+	// if the pipeline is not affected by the changed files.
+	if spec.Skipped {
+		log.Debug("stage skipped, no relevant files changed")
+		now := time.Now().Unix()
+		stage.Status = drone.StatusSkipped
+		stage.Started = now
+		stage.Stopped = now
+		return s.Client.Update(ctx, stage)
+	}
+
+	// the stage is not executed if an identical stage already
+	// succeeded, for example when a webhook is re-delivered,
+	// and the cached result is reported instead.
+	key := s.Results.Key(data.Repo, data.Build, stage, config)
+	if result, ok := s.Results.Lookup(key); ok {
+		log.WithField("cached.build", result.Build).
+			Info("stage skipped, identical stage succeeded")
+		return s.reportCached(ctx, stage, result)
+	}
+
+	// verifies the cluster can schedule the pipeline before
+	// the stage is started. Stages that cannot be scheduled
+	// are released instead of pending until capacity is
+	// available, while other runners have capacity.
+	if s.Preflight != nil {
+		if err := s.Preflight(ctx, spec); err != nil {
+			log.WithError(err).Warn("cannot schedule stage, releasing to the queue")
+			return s.release(ctx, stage)
+		}
+	}
+
 	for _, src := range spec.Steps {
 		// steps that are skipped are ignored and are not stored
 		// in the drone database, nor displayed in the UI.
@@ -243,12 +391,110 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 
 	log.Debug("updated stage to running")
 
+	if s.Recoverable {
+		spec.Checkpoint = toCheckpoint(s.Machine, stage)
+	}
+
 	ctxcancel = logger.WithContext(ctxcancel, log)
+	started := time.Now()
 	err = s.Execer.Exec(ctxcancel, spec, state)
+
+	// the outcome of builds with and without each feature is
+	// recorded, so that new code paths can be compared to the
+	// existing code paths before they are rolled out.
+	s.Compiler.Features.Record(spec.Features, stage.Status, time.Since(started))
+
 	if err != nil {
 		log.WithError(err).Debug("stage failed")
 		return err
 	}
+	if stage.Status == drone.StatusPassing {
+		s.Results.Record(key, toResult(data.Build, stage))
+	}
 	log.Debug("updated stage to complete")
 	return nil
 }
+
+// helper function completes the stage with the cached result
+// of the identical stage, without executing the stage. A line
+// is written to the log of each step, so that the user can
+// find the build that executed the step.
+func (s *Runner) reportCached(ctx context.Context, stage *drone.Stage, result *results.Result) error {
+	now := time.Now().Unix()
+	for _, src := range result.Steps {
+		stage.Steps = append(stage.Steps, &drone.Step{
+			Name:      src.Name,
+			Number:    len(stage.Steps) + 1,
+			StageID:   stage.ID,
+			Status:    src.Status,
+			ExitCode:  src.ExitCode,
+			ErrIgnore: src.ErrIgnore,
+			Started:   now,
+			Stopped:   now,
+		})
+	}
+	// the steps are created when the stage is running, and
+	// the stage is completed once the step logs are uploaded.
+	stage.Status = drone.StatusRunning
+	stage.Started = now
+	if err := s.Client.Update(ctx, stage); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("reusing the result of the identical stage of build #%d\n", result.Build)
+	for _, step := range stage.Steps {
+		line := &drone.Line{Message: message}
+		if err := s.Client.Upload(ctx, step.ID, []*drone.Line{line}); err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("step", step.Name).
+				Warn("cannot upload the step logs")
+		}
+	}
+	stage.Status = drone.StatusPassing
+	stage.Stopped = time.Now().Unix()
+	return s.Client.Update(ctx, stage)
+}
+
+// helper function returns the result of the stage that
+// succeeded.
+func toResult(build *drone.Build, stage *drone.Stage) *results.Result {
+	result := &results.Result{
+		Build:    build.Number,
+		Commit:   build.After,
+		Finished: time.Now(),
+	}
+	for _, step := range stage.Steps {
+		result.Steps = append(result.Steps, &results.Step{
+			Name:      step.Name,
+			Status:    step.Status,
+			ExitCode:  step.ExitCode,
+			ErrIgnore: step.ErrIgnore,
+		})
+	}
+	return result
+}
+
+// helper function releases the accepted stage to the queue,
+// and then waits before the runner requests another stage, so
+// that the runner does not immediately receive the released
+// stage again.
+func (s *Runner) release(ctx context.Context, stage *drone.Stage) error {
+	stage.Machine = ""
+	if err := s.Client.Update(ctx, stage); err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("stage.id", stage.ID).
+			Error("cannot release stage")
+		return err
+	}
+	time.Sleep(s.PreflightBackoff)
+	return nil
+}
+
+// helper function returns true if the build is a pull request
+// from a forked repository.
+func isFork(repo *drone.Repo, build *drone.Build) bool {
+	return build.Event == drone.EventPullRequest &&
+		build.Fork != "" &&
+		build.Fork != repo.Slug
+}
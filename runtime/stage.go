@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// StageEvent describes a stage lifecycle event. The status,
+// finish time and duration are only set when the stage is
+// finished.
+type StageEvent struct {
+	Repo      string    `json:"repo"`
+	Build     int64     `json:"build"`
+	Stage     string    `json:"stage"`
+	Number    int       `json:"stage_number"`
+	Link      string    `json:"link,omitempty"`
+	Machine   string    `json:"machine"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Status    string    `json:"status,omitempty"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished,omitempty"`
+	Duration  float64   `json:"duration_seconds,omitempty"`
+}
+
+// StageObserver receives the stage lifecycle events, for
+// example to alert the infrastructure team. The observer is
+// called synchronously and must not block.
+type StageObserver interface {
+	OnStageStarted(context.Context, *StageEvent)
+	OnStageFinished(context.Context, *StageEvent)
+}
+
+// helper function returns the stage event of the pipeline.
+func toStageEvent(spec *engine.Spec, state *pipeline.State, started time.Time) *StageEvent {
+	state.Lock()
+	defer state.Unlock()
+	event := &StageEvent{
+		Repo:      state.Repo.Slug,
+		Build:     state.Build.Number,
+		Stage:     state.Stage.Name,
+		Number:    state.Stage.Number,
+		Machine:   state.Stage.Machine,
+		Namespace: spec.PodSpec.Namespace,
+		Pod:       spec.PodSpec.Name,
+		Started:   started,
+	}
+	if state.System != nil && state.System.Link != "" {
+		event.Link = fmt.Sprintf("%s/%s/%d", state.System.Link, state.Repo.Slug, state.Build.Number)
+	}
+	return event
+}
+
+// helper function notifies the stage observer that the stage
+// started, and returns a function that notifies the observer
+// that the stage finished.
+func (e *execer) observeStage(ctx context.Context, spec *engine.Spec, state *pipeline.State) func() {
+	if e.stages == nil {
+		return func() {}
+	}
+	started := time.Now()
+	e.stages.OnStageStarted(ctx, toStageEvent(spec, state, started))
+	return func() {
+		event := toStageEvent(spec, state, started)
+		state.Lock()
+		event.Status = state.Stage.Status
+		state.Unlock()
+		event.Finished = time.Now()
+		event.Duration = event.Finished.Sub(started).Seconds()
+		e.stages.OnStageFinished(ctx, event)
+	}
+}
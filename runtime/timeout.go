@@ -0,0 +1,24 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// helper function returns the stage timeout, which is the
+// repository timeout capped to the maximum stage timeout.
+func (s *Runner) stageTimeout(repo *drone.Repo) time.Duration {
+	timeout := time.Duration(repo.Timeout) * time.Minute
+	if s.MaxTimeout == nil {
+		return timeout
+	}
+	if max := s.MaxTimeout(repo); max > 0 && timeout > max {
+		return max
+	}
+	return timeout
+}
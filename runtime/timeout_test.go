@@ -0,0 +1,31 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func TestStageTimeout(t *testing.T) {
+	repo := &drone.Repo{Slug: "octocat/hello-world", Timeout: 120}
+
+	s := new(Runner)
+	if got, want := s.stageTimeout(repo), 2*time.Hour; got != want {
+		t.Errorf("Want repository timeout %s, got %s", want, got)
+	}
+
+	s.MaxTimeout = func(*drone.Repo) time.Duration { return time.Hour }
+	if got, want := s.stageTimeout(repo), time.Hour; got != want {
+		t.Errorf("Want maximum stage timeout %s, got %s", want, got)
+	}
+
+	s.MaxTimeout = func(*drone.Repo) time.Duration { return 0 }
+	if got, want := s.stageTimeout(repo), 2*time.Hour; got != want {
+		t.Errorf("Want repository timeout %s without maximum, got %s", want, got)
+	}
+}
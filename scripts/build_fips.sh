@@ -0,0 +1,10 @@
+#!/bin/sh
+
+# builds the fips variant of the binary. This requires the
+# boringcrypto go toolchain and cgo.
+export CGO_ENABLED=1
+
+set -e
+set -x
+
+GOOS=linux GOARCH=amd64 go build -tags boringcrypto -o release/linux/amd64/drone-runner-kube-fips